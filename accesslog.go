@@ -0,0 +1,184 @@
+package kipp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// accessLogQueue is how many lines may wait to be written to the
+	// access log before more are dropped.
+	accessLogQueue = 4096
+	// accessLogTime is the layout of times in the access log.
+	accessLogTime = "02/Jan/2006:15:04:05 -0700"
+)
+
+// redactedParams are the query parameters whose values are never written to
+// the access log, compared case insensitively.
+var redactedParams = map[string]bool{
+	"access_token":     true,
+	"key":              true,
+	"password":         true,
+	"secret":           true,
+	"sig":              true,
+	"signature":        true,
+	"token":            true,
+	"x-amz-credential": true,
+	"x-amz-signature":  true,
+}
+
+// accessLog writes a line for each request in the Combined Log Format to w
+// in the background, so a slow writer never holds up requests. Lines are
+// dropped when the queue is full.
+type accessLog struct {
+	w       io.Writer
+	queue   chan []byte
+	logger  *slog.Logger
+	dropped prometheus.Counter
+}
+
+func newAccessLog(r prometheus.Registerer, w io.Writer, logger *slog.Logger) (*accessLog, error) {
+	a := &accessLog{
+		w:      w,
+		queue:  make(chan []byte, accessLogQueue),
+		logger: logger,
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "access_log_dropped_total",
+			Help:      "Number of access log lines dropped because the queue was full.",
+		}),
+	}
+	if err := r.Register(a.dropped); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	return a, nil
+}
+
+// Log queues the line of r, which started at start and was responded to
+// with w.
+func (a *accessLog) Log(r *http.Request, w *statusWriter, start time.Time) {
+	select {
+	case a.queue <- accessLine(r, w, start):
+	default:
+		a.dropped.Inc()
+	}
+}
+
+// run writes queued lines until ctx is done, then those still queued.
+// They're buffered, and flushed whenever the queue is empty.
+func (a *accessLog) run(ctx context.Context) {
+	bw := bufio.NewWriter(a.w)
+	write := func(b []byte) {
+		if _, err := bw.Write(b); err != nil {
+			a.logger.Warn("write access log", "error", err)
+			bw.Reset(a.w)
+		}
+	}
+	flush := func() {
+		if err := bw.Flush(); err != nil {
+			a.logger.Warn("write access log", "error", err)
+			bw.Reset(a.w)
+		}
+	}
+	for {
+		select {
+		case b := <-a.queue:
+			write(b)
+			if len(a.queue) == 0 {
+				flush()
+			}
+		case <-ctx.Done():
+			// Requests have finished by now, so nothing more is
+			// queued.
+			for len(a.queue) > 0 {
+				write(<-a.queue)
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// accessLine returns the line of r in the Combined Log Format, with the
+// values of redactedParams redacted from its query.
+func accessLine(r *http.Request, w *statusWriter, start time.Time) []byte {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	size := "-"
+	if w.n > 0 {
+		size = strconv.FormatInt(w.n, 10)
+	}
+	target := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + redactQuery(r.URL.RawQuery)
+	}
+	b := make([]byte, 0, 256)
+	b = append(b, remoteAddr(r)...)
+	b = append(b, " - - ["...)
+	b = start.AppendFormat(b, accessLogTime)
+	b = append(b, "] \""...)
+	b = appendEscaped(b, r.Method+" "+target+" "+r.Proto)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(status), 10)
+	b = append(b, ' ')
+	b = append(b, size...)
+	b = append(b, " \""...)
+	b = appendEscaped(b, headerOrDash(r, "Referer"))
+	b = append(b, "\" \""...)
+	b = appendEscaped(b, headerOrDash(r, "User-Agent"))
+	return append(b, "\"\n"...)
+}
+
+// headerOrDash returns the header of r named key, or "-" if it's empty.
+func headerOrDash(r *http.Request, key string) string {
+	if v := r.Header.Get(key); v != "" {
+		return v
+	}
+	return "-"
+}
+
+// redactQuery returns the raw query q with the values of redactedParams
+// replaced, keeping everything else as it was.
+func redactQuery(q string) string {
+	params := strings.Split(q, "&")
+	for i, p := range params {
+		k, _, ok := strings.Cut(p, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		if ok && redactedParams[strings.ToLower(name)] {
+			params[i] = k + "=REDACTED"
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// appendEscaped appends s to b with quotes, backslashes and bytes which
+// aren't printable ASCII escaped, as Apache does, so lines can't be forged.
+func appendEscaped(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20 || c >= 0x7f:
+			b = append(b, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
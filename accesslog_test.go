@@ -0,0 +1,135 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// combinedLine matches lines of the Combined Log Format.
+var combinedLine = regexp.MustCompile(`^(\S+) - - \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"$`)
+
+// accessEntry is a parsed line of the Combined Log Format.
+type accessEntry struct {
+	remote    string
+	time      time.Time
+	request   string
+	status    int
+	size      string
+	referer   string
+	userAgent string
+}
+
+func parseAccessLog(t *testing.T, s string) []accessEntry {
+	t.Helper()
+	var entries []accessEntry
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		m := combinedLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("line isn't in the Combined Log Format: %q", line)
+		}
+		tm, err := time.Parse(accessLogTime, m[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := strconv.Atoi(m[4])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, accessEntry{m[1], tm, m[3], status, m[5], m[6], m[7]})
+	}
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	s, err := New(ctx,
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<20),
+		TrustedProxies(netip.MustParsePrefix("192.0.2.0/24")),
+		AccessLog(&buf),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := multipartRequest(t, 5)
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	location := w.Header().Get("Location")
+
+	r = httptest.NewRequest(http.MethodGet, location+"?Signature=abc&x=1&password=hunter2", nil)
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	// Lines are written in the background, and flushed by then.
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	entries := parseAccessLog(t, buf.String())
+	if len(entries) != 3 {
+		t.Fatalf("unexpected lines; got %d, want 3:\n%s", len(entries), &buf)
+	}
+	for i, want := range []accessEntry{
+		{remote: "203.0.113.9", request: "POST / HTTP/1.1", status: http.StatusSeeOther, referer: "-", userAgent: "-"},
+		{remote: "192.0.2.1", request: "GET " + location + "?Signature=REDACTED&x=1&password=REDACTED HTTP/1.1", status: http.StatusOK, size: "5", referer: "https://example.com/", userAgent: `curl/8.0 \"quoted\"`},
+		{remote: "192.0.2.1", request: "GET /missing HTTP/1.1", status: http.StatusNotFound, referer: "-", userAgent: "-"},
+	} {
+		got := entries[i]
+		if time.Since(got.time) > time.Minute {
+			t.Errorf("unexpected time of line %d; got %s", i, got.time)
+		}
+		if got.remote != want.remote || got.request != want.request || got.status != want.status || got.referer != want.referer || got.userAgent != want.userAgent {
+			t.Errorf("unexpected line %d; got %+v, want %+v", i, got, want)
+		}
+		if want.size != "" && got.size != want.size {
+			t.Errorf("unexpected size of line %d; got %s, want %s", i, got.size, want.size)
+		}
+	}
+}
+
+// blockingWriter is a writer which never returns.
+type blockingWriter struct{}
+
+func (blockingWriter) Write(b []byte) (int, error) { select {} }
+
+func TestAccessLogDropped(t *testing.T) {
+	a, err := newAccessLog(prometheus.NewRegistry(), blockingWriter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	// Lines are never waited for, however slow the writer is.
+	for range accessLogQueue + 1 {
+		a.Log(r, &statusWriter{}, time.Now())
+	}
+	if got := testutil.ToFloat64(a.dropped); got != 1 {
+		t.Fatalf("unexpected dropped lines; got %v, want 1", got)
+	}
+}
+
+func TestAppendEscaped(t *testing.T) {
+	if got, want := string(appendEscaped(nil, "a\"b\\c\nd\xffé")), `a\"b\\c\x0ad\xff\xc3\xa9`; got != want {
+		t.Fatalf("unexpected escaping; got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,559 @@
+package kipp
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/search"
+)
+
+const (
+	// adminPrefix is the path prefix the admin API is served under.
+	adminPrefix = "/admin/"
+	// adminFiles is the path of the collection of entries, under which each
+	// is served by slug.
+	adminFiles = adminPrefix + "files"
+	// adminUploadNetworks is the path of the networks clients may and may
+	// not upload from.
+	adminUploadNetworks = adminPrefix + "upload-networks"
+	// maxAdminListLimit is the most entries listed at a time.
+	maxAdminListLimit = 1000
+	// maxAdminBody is the size of the largest request body accepted.
+	maxAdminBody = 64 << 10
+)
+
+// adminEntry is an entry as the admin API serves it, with every field, unlike
+// what's public.
+type adminEntry struct {
+	Slug         string     `json:"slug"`
+	Name         string     `json:"name"`
+	Sum          string     `json:"sum"`
+	Size         int64      `json:"size"`
+	Lifetime     *time.Time `json:"lifetime,omitempty"`
+	Timestamp    time.Time  `json:"timestamp"`
+	LastAccess   *time.Time `json:"last_access,omitempty"`
+	GzipSize     int64      `json:"gzip_size,omitempty"`
+	Deleted      *time.Time `json:"deleted,omitempty"`
+	DeleteReason string     `json:"delete_reason,omitempty"`
+	Blocked      *time.Time `json:"blocked,omitempty"`
+	BlockReason  string     `json:"block_reason,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Owner        string     `json:"owner,omitempty"`
+}
+
+func newAdminEntry(e database.Entry) adminEntry {
+	return adminEntry{
+		Slug:         e.Slug,
+		Name:         e.Name,
+		Sum:          e.Sum,
+		Size:         e.Size,
+		Lifetime:     e.Lifetime,
+		Timestamp:    e.Timestamp,
+		LastAccess:   e.LastAccess,
+		GzipSize:     e.GzipSize,
+		Deleted:      e.Deleted,
+		DeleteReason: e.DeleteReason,
+		Blocked:      e.Blocked,
+		BlockReason:  e.BlockReason,
+		Tags:         e.Tags,
+		Owner:        e.Owner,
+	}
+}
+
+// adminList is a page of entries, with the cursor of the next if there is one.
+type adminList struct {
+	Files []adminEntry `json:"files"`
+	Next  string       `json:"next,omitempty"`
+}
+
+// adminNetworks are the networks clients may and may not upload from.
+type adminNetworks struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// isAdmin reports whether path is served by the admin API, which only exists
+// if there's a token for it.
+func (s Server) isAdmin(path string) bool {
+	return s.AdminToken != "" && strings.HasPrefix(path, adminPrefix)
+}
+
+// adminRoute returns the route of the admin API path is served by, without
+// its slug, so routes are bounded.
+func adminRoute(path string) string {
+	switch {
+	case path == adminFiles, path == adminUploadNetworks, path == adminEvents, path == adminDenylist, path == adminReports, path == adminAudit, path == adminReadOnly:
+		return path
+	case strings.HasPrefix(path, adminFiles+"/"):
+		switch _, sub, _ := strings.Cut(strings.TrimPrefix(path, adminFiles+"/"), "/"); sub {
+		case "stats", "tier":
+			return adminFiles + "/{slug}/" + sub
+		}
+		return adminFiles + "/{slug}"
+	case strings.HasPrefix(path, adminDenylist+"/"):
+		return adminDenylist + "/{sum}"
+	case strings.HasPrefix(path, adminReports+"/"):
+		return adminReports + "/{slug}"
+	case path == adminUsers, path == adminStats, path == adminOrphanScan, path == adminDanglingScan:
+		return path
+	case strings.HasPrefix(path, adminUsers+"/"):
+		switch strings.Count(strings.TrimPrefix(path, adminUsers+"/"), "/") {
+		case 0:
+			return adminUsers + "/{id}"
+		case 1:
+			return adminUsers + "/{id}/tokens"
+		}
+		return adminUsers + "/{id}/tokens/{token}"
+	}
+	return adminPrefix
+}
+
+// adminAuthorized reports whether r has AdminToken as a bearer token.
+func (s Server) adminAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
+// serveAdmin serves the admin API to requests with AdminToken as a bearer
+// token.
+func (s Server) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		s.httpError(w, r, newError(http.StatusUnauthorized, CodeUnauthorized, http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+	if s.adminRefusesWhileReadOnly(w, r) {
+		return
+	}
+	if r.URL.Path == adminFiles {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		s.adminList(w, r)
+		return
+	}
+	if r.URL.Path == adminUploadNetworks {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.adminNetworks(w, r)
+		case http.MethodPut:
+			s.adminSetNetworks(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		}
+		return
+	}
+	// Servers made without New have no stream.
+	if r.URL.Path == adminEvents && s.stream != nil {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		s.adminEvents(w, r)
+		return
+	}
+	if r.URL.Path == adminDenylist || strings.HasPrefix(r.URL.Path, adminDenylist+"/") {
+		s.serveAdminDenylist(w, r)
+		return
+	}
+	if r.URL.Path == adminReports || strings.HasPrefix(r.URL.Path, adminReports+"/") {
+		s.serveAdminReports(w, r)
+		return
+	}
+	if r.URL.Path == adminAudit {
+		s.serveAdminAudit(w, r)
+		return
+	}
+	if r.URL.Path == adminReadOnly {
+		s.serveAdminReadOnly(w, r)
+		return
+	}
+	if r.URL.Path == adminUsers || strings.HasPrefix(r.URL.Path, adminUsers+"/") {
+		s.serveAdminUsers(w, r)
+		return
+	}
+	if r.URL.Path == adminStats {
+		s.serveAdminStats(w, r)
+		return
+	}
+	if r.URL.Path == adminOrphanScan {
+		s.serveAdminOrphanScan(w, r)
+		return
+	}
+	if r.URL.Path == adminDanglingScan {
+		s.serveAdminDanglingScan(w, r)
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, adminFiles+"/")
+	slug, sub, _ := strings.Cut(rest, "/")
+	if !ok || slug == "" || strings.Contains(sub, "/") {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	switch sub {
+	case "":
+	case "stats":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		s.adminDownloads(w, r, slug)
+		return
+	case "tier":
+		s.serveAdminTier(w, r, slug)
+		return
+	default:
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.adminLookup(w, r, slug)
+	case http.MethodDelete:
+		s.adminDelete(w, r, slug)
+	case http.MethodPatch:
+		s.adminPatch(w, r, slug)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE, PATCH")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+	}
+}
+
+// adminList lists entries, including expired and soft deleted ones, filtered
+// and paged by the query. Entries are searched for by name if it has one.
+func (s Server) adminList(w http.ResponseWriter, r *http.Request) {
+	opts, err := adminListOptions(r)
+	if err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+	var (
+		entries []database.Entry
+		next    string
+	)
+	if name := r.URL.Query().Get("name"); name != "" {
+		entries, next, err = search.ByName(r.Context(), s.Database, name, database.SearchOptions{ListOptions: opts})
+	} else {
+		entries, next, err = s.Database.List(r.Context(), opts)
+	}
+	if err != nil {
+		s.adminDatabaseError(w, r, "list", err)
+		return
+	}
+	res := adminList{Files: make([]adminEntry, len(entries)), Next: next}
+	for i, e := range entries {
+		res.Files[i] = newAdminEntry(e)
+	}
+	s.adminJSON(w, r, http.StatusOK, res)
+}
+
+// adminListOptions parses the list options of the query of r.
+func adminListOptions(r *http.Request) (database.ListOptions, error) {
+	q := r.URL.Query()
+	opts := database.ListOptions{
+		Cursor:     q.Get("cursor"),
+		NamePrefix: q.Get("prefix"),
+		Tag:        q.Get("tag"),
+		Owner:      q.Get("owner"),
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAdminListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxAdminListLimit)
+		}
+		opts.Limit = n
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+	for _, v := range []struct {
+		name string
+		out  *bool
+	}{
+		{"expired", &opts.Expired},
+		{"deleted", &opts.Deleted},
+	} {
+		if s := q.Get(v.name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s", v.name)
+			}
+			*v.out = b
+		}
+	}
+	for _, v := range []struct {
+		name string
+		out  *time.Time
+	}{
+		{"before", &opts.CreatedBefore},
+		{"after", &opts.CreatedAfter},
+	} {
+		if s := q.Get(v.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s", v.name)
+			}
+			*v.out = t
+		}
+	}
+	return opts, nil
+}
+
+// adminLookup serves the named entry, even if it's expired or soft deleted.
+func (s Server) adminLookup(w http.ResponseWriter, r *http.Request, slug string) {
+	e, err := s.Database.Lookup(r.Context(), slug)
+	if err != nil {
+		s.adminDatabaseError(w, r, "lookup", err)
+		return
+	}
+	s.adminJSON(w, r, http.StatusOK, newAdminEntry(e))
+}
+
+// adminDelete deletes the named entry and its files.
+func (s Server) adminDelete(w http.ResponseWriter, r *http.Request, slug string) {
+	e, err := s.Database.Lookup(r.Context(), slug)
+	if err != nil {
+		s.adminDatabaseError(w, r, "lookup", err)
+		return
+	}
+	if err := s.audit(r, "delete", slug, newAdminEntry(e), nil); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	if err := s.Delete(r.Context(), slug); err != nil {
+		s.adminDatabaseError(w, r, "delete", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminPatch updates the named entry with the body, and serves the entry as it
+// is after. Its lifetime is either a time or null to remove it, block is
+// either the reason code to block it for or null to unblock it, and deleted is
+// either the reason code to soft delete it for or null to restore it.
+func (s Server) adminPatch(w http.ResponseWriter, r *http.Request, slug string) {
+	var req struct {
+		Lifetime json.RawMessage `json:"lifetime"`
+		Block    json.RawMessage `json:"block"`
+		Deleted  json.RawMessage `json:"deleted"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+		return
+	}
+	if req.Lifetime == nil && req.Block == nil && req.Deleted == nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "nothing to update"))
+		return
+	}
+	var lifetime *time.Time
+	if req.Lifetime != nil {
+		if err := json.Unmarshal(req.Lifetime, &lifetime); err != nil {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid lifetime"))
+			return
+		}
+	}
+	var block *string
+	if req.Block != nil {
+		if err := json.Unmarshal(req.Block, &block); err != nil || (block != nil && !validBlockReason(*block)) {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid block reason"))
+			return
+		}
+	}
+	var deleted *string
+	if req.Deleted != nil {
+		if err := json.Unmarshal(req.Deleted, &deleted); err != nil || (deleted != nil && !validBlockReason(*deleted)) {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid delete reason"))
+			return
+		}
+	}
+	ls, ok := s.Database.(database.LifetimeSetter)
+	if req.Lifetime != nil && !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support setting lifetimes"))
+		return
+	}
+	b, ok := s.Database.(database.Blocker)
+	if req.Block != nil && !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support blocking"))
+		return
+	}
+	if _, ok := s.Database.(database.SoftDeleter); req.Deleted != nil && !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support soft deletes"))
+		return
+	}
+	e, err := s.Database.Lookup(r.Context(), slug)
+	if err != nil {
+		s.adminDatabaseError(w, r, "lookup", err)
+		return
+	}
+	if req.Lifetime != nil {
+		type change struct {
+			Lifetime *time.Time `json:"lifetime"`
+		}
+		if err := s.audit(r, "set lifetime", slug, change{e.Lifetime}, change{lifetime}); err != nil {
+			s.adminDatabaseError(w, r, "audit", err)
+			return
+		}
+		if err := ls.SetLifetime(r.Context(), slug, lifetime); err != nil {
+			s.adminDatabaseError(w, r, "set lifetime", err)
+			return
+		}
+		e.Lifetime = lifetime
+	}
+	type change struct {
+		Block *string `json:"block"`
+	}
+	var blocked *string
+	if e.Blocked != nil {
+		blocked = &e.BlockReason
+	}
+	switch {
+	case block != nil:
+		if err := s.audit(r, "block", slug, change{blocked}, change{block}); err != nil {
+			s.adminDatabaseError(w, r, "audit", err)
+			return
+		}
+		now := time.Now().UTC()
+		if err := b.Block(r.Context(), slug, now, *block); err != nil {
+			s.adminDatabaseError(w, r, "block", err)
+			return
+		}
+		e.Blocked, e.BlockReason = &now, *block
+	case req.Block != nil:
+		if err := s.audit(r, "unblock", slug, change{blocked}, change{nil}); err != nil {
+			s.adminDatabaseError(w, r, "audit", err)
+			return
+		}
+		if err := b.Unblock(r.Context(), slug); err != nil {
+			s.adminDatabaseError(w, r, "unblock", err)
+			return
+		}
+		e.Blocked, e.BlockReason = nil, ""
+	}
+	type deletion struct {
+		Deleted *string `json:"deleted"`
+	}
+	var was *string
+	if e.Deleted != nil {
+		was = &e.DeleteReason
+	}
+	switch {
+	case deleted != nil:
+		if err := s.audit(r, "soft delete", slug, deletion{was}, deletion{deleted}); err != nil {
+			s.adminDatabaseError(w, r, "audit", err)
+			return
+		}
+		now := time.Now().UTC()
+		if err := s.SoftDelete(r.Context(), slug, *deleted); err != nil {
+			s.adminDatabaseError(w, r, "soft delete", err)
+			return
+		}
+		e.Deleted, e.DeleteReason = &now, *deleted
+	case req.Deleted != nil:
+		if err := s.audit(r, "restore", slug, deletion{was}, deletion{nil}); err != nil {
+			s.adminDatabaseError(w, r, "audit", err)
+			return
+		}
+		if err := s.Restore(r.Context(), slug); err != nil {
+			s.adminDatabaseError(w, r, "restore", err)
+			return
+		}
+		e.Deleted, e.DeleteReason = nil, ""
+	}
+	s.adminJSON(w, r, http.StatusOK, newAdminEntry(e))
+}
+
+// adminNetworks serves the networks clients may and may not upload from.
+func (s Server) adminNetworks(w http.ResponseWriter, r *http.Request) {
+	allow, deny := s.uploadNets.Lists()
+	s.adminJSON(w, r, http.StatusOK, newAdminNetworks(allow, deny))
+}
+
+// adminSetNetworks replaces the networks clients may and may not upload from
+// with those of the body, until they're next reloaded.
+func (s Server) adminSetNetworks(w http.ResponseWriter, r *http.Request) {
+	var req adminNetworks
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+		return
+	}
+	var lists [2][]netip.Prefix
+	for i, l := range [][]string{req.Allow, req.Deny} {
+		for _, v := range l {
+			p, err := parseNetwork(v)
+			if err != nil {
+				s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid network: "+err.Error()))
+				return
+			}
+			lists[i] = append(lists[i], p)
+		}
+	}
+	before := newAdminNetworks(s.uploadNets.Lists())
+	if err := s.audit(r, "set upload networks", "", before, newAdminNetworks(unmapPrefixes(lists[0]), unmapPrefixes(lists[1]))); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	s.uploadNets.Set(lists[0], lists[1])
+	res := newAdminNetworks(s.uploadNets.Lists())
+	s.adminJSON(w, r, http.StatusOK, res)
+}
+
+func newAdminNetworks(allow, deny []netip.Prefix) adminNetworks {
+	res := adminNetworks{Allow: []string{}, Deny: []string{}}
+	for _, p := range allow {
+		res.Allow = append(res.Allow, p.String())
+	}
+	for _, p := range deny {
+		res.Deny = append(res.Deny, p.String())
+	}
+	return res
+}
+
+// adminJSON responds to r with v as JSON.
+func (s Server) adminJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger().WarnContext(r.Context(), "write admin response", "error", err)
+	}
+}
+
+// adminDatabaseError responds to r with the error of op, which failed with
+// err.
+func (s Server) adminDatabaseError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	switch {
+	case errors.Is(err, database.ErrNoResults):
+		s.httpError(w, r, errNotFound)
+	case errors.Is(err, database.ErrInvalidCursor):
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+	case errors.Is(err, database.ErrUnsupported):
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, op+": "+err.Error()))
+	default:
+		s.httpError(w, r, internalError(fmt.Errorf("admin %s: %w", op, err)))
+	}
+}
@@ -0,0 +1,386 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/tier"
+)
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, nil))
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Admin("secret"), Logger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := databasetest.NewEntry("deleted")
+	deleted.Deleted, deleted.DeleteReason = &deleted.Timestamp, "abuse"
+	entries := []database.Entry{databasetest.NewEntry("a"), databasetest.NewEntry("b"), deleted}
+	for i := range entries {
+		entries[i].Timestamp = entries[i].Timestamp.Add(time.Duration(i) * time.Second)
+		if err := s.Database.Create(ctx, entries[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.FileSystem.Create(ctx, entries[i].Slug, strings.NewReader("some data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// do makes a request to the admin API, decoding its response into v
+	// if it's not nil.
+	do := func(t *testing.T, method, target, body string, code int, v any) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, code, w.Body)
+		}
+		if v != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatalf("unmarshal %q: %v", w.Body, err)
+			}
+		}
+		return w
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, auth := range []string{"", "Bearer wrong", "secret"} {
+			r := httptest.NewRequest(http.MethodGet, "/admin/files", nil)
+			r.Header.Set("Authorization", auth)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("%q: unexpected status; got %d, want %d", auth, w.Code, http.StatusUnauthorized)
+			}
+			var res errorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Error.Code != CodeUnauthorized || res.Error.RequestID == "" {
+				t.Fatalf("%q: unexpected error %q: %v", auth, w.Body, err)
+			}
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var page adminList
+		do(t, http.MethodGet, "/admin/files?limit=2", "", http.StatusOK, &page)
+		if len(page.Files) != 2 || page.Files[0].Slug != "a" || page.Files[1].Slug != "b" || page.Next == "" {
+			t.Fatalf("unexpected first page: %+v", page)
+		}
+		var last adminList
+		do(t, http.MethodGet, "/admin/files?limit=2&cursor="+page.Next, "", http.StatusOK, &last)
+		if len(last.Files) != 1 || last.Files[0].Slug != "deleted" || last.Next != "" {
+			t.Fatalf("unexpected second page: %+v", last)
+		}
+
+		var deleted adminList
+		do(t, http.MethodGet, "/admin/files?deleted=true", "", http.StatusOK, &deleted)
+		if len(deleted.Files) != 1 || deleted.Files[0].DeleteReason != "abuse" {
+			t.Fatalf("unexpected deleted files: %+v", deleted)
+		}
+		var prefixed adminList
+		do(t, http.MethodGet, "/admin/files?order=desc&prefix=b", "", http.StatusOK, &prefixed)
+		if len(prefixed.Files) != 1 || prefixed.Files[0].Slug != "b" {
+			t.Fatalf("unexpected files: %+v", prefixed)
+		}
+
+		var named adminList
+		do(t, http.MethodGet, "/admin/files?name=LETED.t", "", http.StatusOK, &named)
+		if len(named.Files) != 1 || named.Files[0].Slug != "deleted" {
+			t.Fatalf("unexpected files: %+v", named)
+		}
+		var none adminList
+		do(t, http.MethodGet, "/admin/files?name=deleted&deleted=false&prefix=a", "", http.StatusOK, &none)
+		if len(none.Files) != 0 {
+			t.Fatalf("unexpected files: %+v", none)
+		}
+
+		for _, q := range []string{"limit=0", "limit=x", "order=up", "expired=maybe", "before=yesterday", "cursor=!"} {
+			var res errorBody
+			do(t, http.MethodGet, "/admin/files?"+q, "", http.StatusBadRequest, &res)
+			if res.Error.Code != CodeBadRequest || res.Error.Message == "" {
+				t.Fatalf("%s: no error", q)
+			}
+		}
+		do(t, http.MethodPost, "/admin/files", "", http.StatusMethodNotAllowed, &errorBody{})
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		var st adminDatabaseStats
+		do(t, http.MethodGet, "/admin/stats", "", http.StatusOK, &st)
+		if st.Entries != 3 || st.Bytes != 3*1234 || st.GzipBytes != 3*123 || st.ExpiringDay != 3*1234 || len(st.Days) != 1 || st.Days[0].Entries != 3 {
+			t.Fatalf("unexpected stats: %+v", st)
+		}
+		do(t, http.MethodPost, "/admin/stats", "", http.StatusMethodNotAllowed, &errorBody{})
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		var e adminEntry
+		do(t, http.MethodGet, "/admin/files/deleted", "", http.StatusOK, &e)
+		if e.Slug != "deleted" || e.Deleted == nil || e.DeleteReason != "abuse" || e.LastAccess == nil {
+			t.Fatalf("unexpected entry: %+v", e)
+		}
+		do(t, http.MethodGet, "/admin/files/missing", "", http.StatusNotFound, &errorBody{})
+		do(t, http.MethodGet, "/admin/other", "", http.StatusNotFound, &errorBody{})
+		do(t, http.MethodPut, "/admin/files/a", "", http.StatusMethodNotAllowed, &errorBody{})
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		later := entries[0].Lifetime.Add(time.Hour).UTC().Truncate(time.Second)
+		var e adminEntry
+		do(t, http.MethodPatch, "/admin/files/a", `{"lifetime":"`+later.Format(time.RFC3339)+`"}`, http.StatusOK, &e)
+		if e.Lifetime == nil || !e.Lifetime.Equal(later) {
+			t.Fatalf("unexpected lifetime; got %v, want %v", e.Lifetime, later)
+		}
+		var cleared adminEntry
+		do(t, http.MethodPatch, "/admin/files/a", `{"lifetime":null}`, http.StatusOK, &cleared)
+		if cleared.Lifetime != nil {
+			t.Fatalf("unexpected lifetime; got %v, want nil", cleared.Lifetime)
+		}
+		got, err := s.Database.Lookup(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Lifetime != nil {
+			t.Fatalf("lifetime wasn't removed; got %v", got.Lifetime)
+		}
+		rec := find(t, records(t, &buf), "audit")
+		if rec["action"] != "set lifetime" || rec["target"] != "a" {
+			t.Fatalf("unexpected audit record: %v", rec)
+		}
+
+		for _, body := range []string{``, `{}`, `{"lifetime":"tomorrow"}`, `{"name":"x"}`} {
+			do(t, http.MethodPatch, "/admin/files/a", body, http.StatusBadRequest, &errorBody{})
+		}
+		do(t, http.MethodPatch, "/admin/files/missing", `{"lifetime":null}`, http.StatusNotFound, &errorBody{})
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		buf.Reset()
+		var e adminEntry
+		do(t, http.MethodPatch, "/admin/files/a", `{"deleted":"spam"}`, http.StatusOK, &e)
+		if e.Deleted == nil || e.DeleteReason != "spam" {
+			t.Fatalf("unexpected entry: %+v", e)
+		}
+		got, err := s.Database.Lookup(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Deleted == nil || got.DeleteReason != "spam" {
+			t.Fatalf("entry wasn't soft deleted: %+v", got)
+		}
+		rec := find(t, records(t, &buf), "audit")
+		if rec["action"] != "soft delete" || rec["target"] != "a" {
+			t.Fatalf("unexpected audit record: %v", rec)
+		}
+
+		var restored adminEntry
+		do(t, http.MethodPatch, "/admin/files/a", `{"deleted":null}`, http.StatusOK, &restored)
+		if restored.Deleted != nil || restored.DeleteReason != "" {
+			t.Fatalf("unexpected entry: %+v", restored)
+		}
+		if got, err = s.Database.Lookup(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if got.Deleted != nil {
+			t.Fatalf("entry wasn't restored: %+v", got)
+		}
+
+		do(t, http.MethodPatch, "/admin/files/a", `{"deleted":"Not a code"}`, http.StatusBadRequest, &errorBody{})
+		do(t, http.MethodPatch, "/admin/files/missing", `{"deleted":"spam"}`, http.StatusNotFound, &errorBody{})
+	})
+
+	t.Run("downloads", func(t *testing.T) {
+		dc := s.Database.(database.DownloadCounter)
+		now := time.Now()
+		for _, d := range []struct {
+			t time.Time
+			n int64
+		}{{now.AddDate(0, 0, -10), 5}, {now.AddDate(0, 0, -1), 2}, {now, 1}} {
+			if err := dc.AddDownloads(ctx, "a", d.t, d.n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var res adminDownloads
+		do(t, http.MethodGet, "/admin/files/a/stats?days=7", "", http.StatusOK, &res)
+		if len(res.Days) != 2 || res.Days[1].Day != database.Day(now).Format(time.DateOnly) || res.Days[1].Count != 1 || res.Total != 3 {
+			t.Fatalf("unexpected downloads: %+v", res)
+		}
+		do(t, http.MethodGet, "/admin/files/a/stats", "", http.StatusOK, &res)
+		if len(res.Days) != 3 || res.Total != 8 {
+			t.Fatalf("unexpected downloads: %+v", res)
+		}
+		for _, q := range []string{"days=0", "days=x", "days=1000"} {
+			do(t, http.MethodGet, "/admin/files/a/stats?"+q, "", http.StatusBadRequest, &errorBody{})
+		}
+		do(t, http.MethodGet, "/admin/files/missing/stats", "", http.StatusNotFound, &errorBody{})
+		do(t, http.MethodGet, "/admin/files/a/other", "", http.StatusNotFound, &errorBody{})
+		do(t, http.MethodPost, "/admin/files/a/stats", "", http.StatusMethodNotAllowed, &errorBody{})
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		buf.Reset()
+		do(t, http.MethodDelete, "/admin/files/b", "", http.StatusNoContent, nil)
+		if _, err := s.Database.Lookup(ctx, "b"); !errors.Is(err, database.ErrNoResults) {
+			t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+		}
+		if _, err := s.FileSystem.Open(ctx, "b"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("unexpected error; got %v, want %v", err, fs.ErrNotExist)
+		}
+		rec := find(t, records(t, &buf), "audit")
+		if rec["action"] != "delete" || rec["target"] != "b" || rec["request_id"] == nil {
+			t.Fatalf("unexpected audit record: %v", rec)
+		}
+		do(t, http.MethodDelete, "/admin/files/b", "", http.StatusNotFound, &errorBody{})
+	})
+}
+
+func TestAdminScans(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Admin("secret"), OrphanScan(0, time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is whole, b's file is missing, and orphan has no entry.
+	for _, slug := range []string{"a", "b"} {
+		e := databasetest.NewEntry(slug)
+		e.GzipSize = 0
+		if err := s.Database.Create(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a", "orphan"} {
+		if err := s.FileSystem.Create(ctx, name, strings.NewReader("some data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	do := adminDo(s)
+
+	t.Run("orphans", func(t *testing.T) {
+		var rep adminOrphanReport
+		do(t, http.MethodPost, "/admin/scans/orphans", `{"dry_run":true}`, http.StatusOK, &rep)
+		if rep.Files != 2 || len(rep.Orphans) != 1 || rep.Orphans[0] != "orphan" || rep.Removed != 0 {
+			t.Fatalf("unexpected report: %+v", rep)
+		}
+		if _, err := s.FileSystem.Stat(ctx, "orphan"); err != nil {
+			t.Fatalf("orphan was removed by a dry run: %v", err)
+		}
+		do(t, http.MethodPost, "/admin/scans/orphans", `{"dry_run":false}`, http.StatusOK, &rep)
+		if rep.Removed != 1 || rep.Reclaimed != int64(len("some data")) {
+			t.Fatalf("unexpected report: %+v", rep)
+		}
+		if _, err := s.FileSystem.Stat(ctx, "orphan"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("unexpected error; got %v, want %v", err, fs.ErrNotExist)
+		}
+		for _, body := range []string{``, `{}`, `{"dry_run":"yes"}`} {
+			do(t, http.MethodPost, "/admin/scans/orphans", body, http.StatusBadRequest, &errorBody{})
+		}
+		do(t, http.MethodGet, "/admin/scans/orphans", "", http.StatusMethodNotAllowed, &errorBody{})
+	})
+
+	t.Run("dangling", func(t *testing.T) {
+		var rep adminDanglingReport
+		do(t, http.MethodPost, "/admin/scans/dangling", `{"action":"report"}`, http.StatusOK, &rep)
+		if rep.Entries != 2 || len(rep.Dangling) != 1 || rep.Dangling[0] != "b" || rep.Resolved != 0 {
+			t.Fatalf("unexpected report: %+v", rep)
+		}
+		do(t, http.MethodPost, "/admin/scans/dangling", `{"action":"mark"}`, http.StatusOK, &rep)
+		if rep.Resolved != 1 {
+			t.Fatalf("unexpected report: %+v", rep)
+		}
+		e, err := s.Database.Lookup(ctx, "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Deleted == nil || e.DeleteReason != missingReason {
+			t.Fatalf("b wasn't marked: %+v", e)
+		}
+		for _, body := range []string{``, `{}`, `{"action":"nuke"}`} {
+			do(t, http.MethodPost, "/admin/scans/dangling", body, http.StatusBadRequest, &errorBody{})
+		}
+		do(t, http.MethodGet, "/admin/scans/dangling", "", http.StatusMethodNotAllowed, &errorBody{})
+	})
+}
+
+func TestAdminTier(t *testing.T) {
+	ctx := context.Background()
+
+	small, large := memfs.New(), memfs.New()
+	s, err := New(ctx, DB(memory.New()), FS(tier.New(small, large, 16)), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := databasetest.NewEntry("a")
+	e.GzipSize = 0
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FileSystem.Create(ctx, "a", strings.NewReader("some data")); err != nil {
+		t.Fatal(err)
+	}
+	do := adminDo(s)
+
+	var res adminTier
+	do(t, http.MethodGet, "/admin/files/a/tier", "", http.StatusOK, &res)
+	if res.Tier != "small" {
+		t.Fatalf("unexpected tier; got %q, want small", res.Tier)
+	}
+	do(t, http.MethodPut, "/admin/files/a/tier", `{"tier":"large"}`, http.StatusOK, &res)
+	if res.Tier != "large" {
+		t.Fatalf("unexpected tier; got %q, want large", res.Tier)
+	}
+	if _, err := small.Stat(ctx, "a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := large.Stat(ctx, "a"); err != nil {
+		t.Fatalf("a wasn't moved: %v", err)
+	}
+
+	for _, body := range []string{``, `{}`, `{"tier":"medium"}`} {
+		do(t, http.MethodPut, "/admin/files/a/tier", body, http.StatusBadRequest, &errorBody{})
+	}
+	do(t, http.MethodGet, "/admin/files/missing/tier", "", http.StatusNotFound, &errorBody{})
+	do(t, http.MethodPost, "/admin/files/a/tier", "", http.StatusMethodNotAllowed, &errorBody{})
+
+	// File systems which aren't tiered have no tiers to move between.
+	untiered, err := New(ctx, DB(memory.New()), FS(memfs.New()), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminDo(untiered)(t, http.MethodGet, "/admin/files/a/tier", "", http.StatusNotImplemented, &errorBody{})
+}
+
+// TestAdminDisabled checks the admin API doesn't exist without a token.
+func TestAdminDisabled(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		r := httptest.NewRequest(method, "/admin/files", nil)
+		r.Header.Set("Authorization", "Bearer ")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code == http.StatusOK || w.Code == http.StatusUnauthorized || strings.Contains(w.Header().Get("Content-Type"), "json") {
+			t.Fatalf("%s: admin API served; got %d", method, w.Code)
+		}
+	}
+}
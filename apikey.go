@@ -0,0 +1,61 @@
+package kipp
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+)
+
+// APIKey is a key clients authenticate uploads with, as a bearer token.
+// Uploads authenticated with one aren't verified by the Verifier.
+type APIKey struct {
+	// Name names the key in logs, so the key itself never is.
+	Name string
+	Key  string
+	// Limit, if not zero, is the largest upload the key may make, in
+	// place of the server's Limit, which it may be above or below.
+	Limit int64
+	// Lifetime, if not zero, is the lifetime of files uploaded with the
+	// key, in place of the server's Lifetime.
+	Lifetime time.Duration
+}
+
+type apiKeyContextKey struct{}
+
+// APIKeyName returns the name of the API key the request with ctx was
+// authenticated with, if it was.
+func APIKeyName(ctx context.Context) (string, bool) {
+	k, ok := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	if !ok {
+		return "", false
+	}
+	return k.Name, true
+}
+
+// apiKey returns the API key token is, or nil if it isn't one. Every key is
+// compared, so how long it takes doesn't say which matched.
+func (s Server) apiKey(token string) *APIKey {
+	var match *APIKey
+	for i := range s.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.APIKeys[i].Key)) == 1 {
+			match = &s.APIKeys[i]
+		}
+	}
+	return match
+}
+
+// uploadLimits returns the largest upload the request with ctx may make, and
+// the lifetime of what it uploads, which are those of its API key if it has
+// one, or the server's otherwise.
+func (s Server) uploadLimits(ctx context.Context) (limit int64, lifetime time.Duration) {
+	limit, lifetime = s.Limit, s.Lifetime
+	if k, ok := ctx.Value(apiKeyContextKey{}).(*APIKey); ok {
+		if k.Limit > 0 {
+			limit = k.Limit
+		}
+		if k.Lifetime > 0 {
+			lifetime = k.Lifetime
+		}
+	}
+	return limit, lifetime
+}
@@ -0,0 +1,212 @@
+package kipp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// adminAudit is the path of the audit log.
+const adminAudit = adminPrefix + "audit"
+
+// adminAuditRecord is a record of the audit log as the admin API serves it.
+// Before and after are JSON, and only set where they're meaningful.
+type adminAuditRecord struct {
+	ID        string          `json:"id"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Remote    string          `json:"remote,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+func newAdminAuditRecord(r database.AuditRecord) adminAuditRecord {
+	res := adminAuditRecord{
+		ID:        r.ID,
+		Time:      r.Time,
+		Actor:     r.Actor,
+		Action:    r.Action,
+		Target:    r.Target,
+		RequestID: r.RequestID,
+		Remote:    r.Remote,
+	}
+	if r.Before != "" {
+		res.Before = json.RawMessage(r.Before)
+	}
+	if r.After != "" {
+		res.After = json.RawMessage(r.After)
+	}
+	return res
+}
+
+// adminAuditLog is a page of the audit log, with the cursor of the next if
+// there is one.
+type adminAuditLog struct {
+	Records []adminAuditRecord `json:"records"`
+	Next    string             `json:"next,omitempty"`
+}
+
+// audit records that action was taken on target through the admin API, by
+// whom, with which request and what target was before and after, which are
+// marshaled as JSON if they're not nil. It's logged, and appended to the
+// database's audit log before the action is taken, so it's recorded even if
+// the action then fails. If it can't be appended, the error is returned if
+// RequireAudit is set, and the action mustn't be taken, and only logged
+// otherwise.
+func (s Server) audit(r *http.Request, action, target string, before, after any) error {
+	return s.auditAs(r, s.adminActor(), action, target, before, after)
+}
+
+// auditAs records action as audit does, but as taken by actor rather than
+// through the admin API.
+func (s Server) auditAs(r *http.Request, actor, action, target string, before, after any) error {
+	id, _ := RequestID(r.Context())
+	rec := database.AuditRecord{
+		Time:      time.Now().UTC(),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		RequestID: id,
+		Remote:    remoteAddr(r),
+	}
+	for _, v := range []struct {
+		v   any
+		out *string
+	}{
+		{before, &rec.Before},
+		{after, &rec.After},
+	} {
+		if v.v == nil {
+			continue
+		}
+		b, err := json.Marshal(v.v)
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		*v.out = string(b)
+	}
+	s.logger().LogAttrs(r.Context(), slog.LevelInfo, "audit",
+		slog.String("action", action),
+		slog.String("actor", rec.Actor),
+		slog.String("remote", rec.Remote),
+		slog.String("target", target),
+		slog.String("before", rec.Before),
+		slog.String("after", rec.After),
+	)
+
+	var b [12]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return fmt.Errorf("generate id: %w", err)
+	}
+	rec.ID = base64.RawURLEncoding.EncodeToString(b[:])
+	a, ok := s.Database.(database.Auditor)
+	if !ok {
+		return s.auditError(r, database.ErrUnsupported)
+	}
+	if err := a.Audit(r.Context(), rec); err != nil {
+		return s.auditError(r, err)
+	}
+	return nil
+}
+
+// auditError returns err, which the audit log failed to be appended to with,
+// if RequireAudit is set, and otherwise logs it and returns nil. Databases
+// which don't keep an audit log aren't an error unless it's required.
+func (s Server) auditError(r *http.Request, err error) error {
+	if s.RequireAudit {
+		return fmt.Errorf("audit: %w", err)
+	}
+	if !errors.Is(err, database.ErrUnsupported) {
+		s.logger().ErrorContext(r.Context(), "audit", "error", err)
+	}
+	return nil
+}
+
+// userActor returns the actor of the user with id.
+func userActor(id string) string { return "user:" + id }
+
+// adminActor returns who uses the admin API, which is identified by a prefix
+// of the hash of its token, so the token itself is never recorded but records
+// can be told apart once it's rotated.
+func (s Server) adminActor() string {
+	sum := sha256.Sum256([]byte(s.AdminToken))
+	return "admin:" + hex.EncodeToString(sum[:4])
+}
+
+// serveAdminAudit serves the audit log, newest first, filtered and paged by
+// the query.
+func (s Server) serveAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	a, ok := s.Database.(database.Auditor)
+	if !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support audit logs"))
+		return
+	}
+	opts, err := adminAuditOptions(r)
+	if err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+	records, next, err := a.AuditLog(r.Context(), opts)
+	if err != nil {
+		s.adminDatabaseError(w, r, "audit log", err)
+		return
+	}
+	res := adminAuditLog{Records: make([]adminAuditRecord, len(records)), Next: next}
+	for i, rec := range records {
+		res.Records[i] = newAdminAuditRecord(rec)
+	}
+	s.adminJSON(w, r, http.StatusOK, res)
+}
+
+// adminAuditOptions parses the audit log options of the query of r.
+func adminAuditOptions(r *http.Request) (database.AuditOptions, error) {
+	q := r.URL.Query()
+	opts := database.AuditOptions{
+		Cursor: q.Get("cursor"),
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAdminListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxAdminListLimit)
+		}
+		opts.Limit = n
+	}
+	for _, v := range []struct {
+		name string
+		out  *time.Time
+	}{
+		{"before", &opts.Before},
+		{"after", &opts.After},
+	} {
+		if s := q.Get(v.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s", v.name)
+			}
+			*v.out = t
+		}
+	}
+	return opts, nil
+}
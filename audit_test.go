@@ -0,0 +1,232 @@
+package kipp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// adminDo returns a function which makes requests of the admin API of s, and
+// checks their status and decodes their body into v, if it's not nil.
+func adminDo(s *Server) func(t *testing.T, method, target, body string, code int, v any) {
+	return func(t *testing.T, method, target, body string, code int, v any) {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, code, w.Body)
+		}
+		if v != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatalf("unmarshal %q: %v", w.Body, err)
+			}
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Admin("secret"), RequireAudit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := base64.RawURLEncoding.EncodeToString(make([]byte, sumSize))
+	for _, slug := range []string{"a", "b", "c"} {
+		e := databasetest.NewEntry(slug)
+		if slug == "c" {
+			e.Sum = sum
+		}
+		if err := s.Database.Create(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Database.(database.Reporter).Report(ctx, database.Report{Slug: "a", Category: "malware", Status: database.ReportOpen, Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	do := adminDo(s)
+	log := func(t *testing.T, query string) []adminAuditRecord {
+		t.Helper()
+		var res adminAuditLog
+		do(t, http.MethodGet, adminAudit+query, "", http.StatusOK, &res)
+		return res.Records
+	}
+
+	// Every mutation is recorded, with what changed where it's meaningful.
+	for _, tt := range []struct {
+		method, target, body string
+		code                 int
+		want                 []adminAuditRecord
+	}{
+		{
+			http.MethodPatch, "/admin/files/a", `{"lifetime":null}`, http.StatusOK,
+			[]adminAuditRecord{{Action: "set lifetime", Target: "a", After: json.RawMessage(`{"lifetime":null}`)}},
+		},
+		{
+			http.MethodPatch, "/admin/files/a", `{"block":"dmca"}`, http.StatusOK,
+			[]adminAuditRecord{{Action: "block", Target: "a", Before: json.RawMessage(`{"block":null}`), After: json.RawMessage(`{"block":"dmca"}`)}},
+		},
+		{
+			http.MethodPatch, "/admin/files/a", `{"block":null}`, http.StatusOK,
+			[]adminAuditRecord{{Action: "unblock", Target: "a", Before: json.RawMessage(`{"block":"dmca"}`), After: json.RawMessage(`{"block":null}`)}},
+		},
+		{
+			http.MethodPut, adminUploadNetworks, `{"allow":[],"deny":["192.0.2.0/24"]}`, http.StatusOK,
+			[]adminAuditRecord{{
+				Action: "set upload networks",
+				Before: json.RawMessage(`{"allow":[],"deny":[]}`),
+				After:  json.RawMessage(`{"allow":[],"deny":["192.0.2.0/24"]}`),
+			}},
+		},
+		{
+			http.MethodPost, adminDenylist, `{"sum":"` + sum + `","reason":"malware"}`, http.StatusOK,
+			[]adminAuditRecord{{Action: "delete", Target: "c"}, {Action: "deny", Target: sum}},
+		},
+		{
+			http.MethodDelete, adminDenylist + "/" + sum, "", http.StatusNoContent,
+			[]adminAuditRecord{{Action: "allow", Target: sum}},
+		},
+		{
+			http.MethodPatch, adminReports + "/a", `{"status":"resolved"}`, http.StatusOK,
+			[]adminAuditRecord{{Action: "resolve reports", Target: "a", Before: json.RawMessage(`{"open":1}`), After: json.RawMessage(`{"open":0}`)}},
+		},
+		{
+			http.MethodDelete, "/admin/files/b", "", http.StatusNoContent,
+			[]adminAuditRecord{{Action: "delete", Target: "b"}},
+		},
+	} {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			do(t, tt.method, tt.target, tt.body, tt.code, nil)
+			got := log(t, "?limit="+strconv.Itoa(len(tt.want)))
+			for i, want := range tt.want {
+				got := got[i]
+				if got.Action != want.Action || got.Target != want.Target {
+					t.Fatalf("unexpected record; got %s %s, want %s %s", got.Action, got.Target, want.Action, want.Target)
+				}
+				if got.Actor != s.adminActor() || got.RequestID == "" || got.Remote != "192.0.2.1" || got.ID == "" || got.Time.IsZero() {
+					t.Fatalf("unexpected record: %+v", got)
+				}
+				for _, v := range []struct {
+					name      string
+					got, want json.RawMessage
+				}{
+					{"before", got.Before, want.Before},
+					{"after", got.After, want.After},
+				} {
+					if v.want != nil && string(v.got) != string(v.want) {
+						t.Fatalf("unexpected %s; got %s, want %s", v.name, v.got, v.want)
+					}
+				}
+			}
+		})
+	}
+	// Requests which fail before anything is done aren't recorded.
+	do(t, http.MethodDelete, "/admin/files/missing", "", http.StatusNotFound, &errorBody{})
+	do(t, http.MethodPatch, adminReports+"/a", `{"status":"resolved"}`, http.StatusNotFound, &errorBody{})
+	if got := log(t, ""); len(got) != 9 || got[0].Action != "delete" || got[0].Target != "b" {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+	if strings.Contains(s.adminActor(), "secret") {
+		t.Fatalf("actor contains the token: %s", s.adminActor())
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"?action=delete", 2},
+		{"?target=a", 4},
+		{"?actor=" + s.adminActor(), 9},
+		{"?actor=someone", 0},
+		{"?before=2000-01-01T00:00:00Z", 0},
+		{"?after=2000-01-01T00:00:00Z", 9},
+	} {
+		if got := log(t, tt.query); len(got) != tt.want {
+			t.Errorf("%s: unexpected records; got %d, want %d", tt.query, len(got), tt.want)
+		}
+	}
+	var (
+		next  string
+		pages int
+		ids   = map[string]bool{}
+	)
+	for pages < 10 {
+		var res adminAuditLog
+		do(t, http.MethodGet, adminAudit+"?limit=4&cursor="+next, "", http.StatusOK, &res)
+		for _, rec := range res.Records {
+			ids[rec.ID] = true
+		}
+		if pages++; res.Next == "" {
+			break
+		}
+		next = res.Next
+	}
+	if pages != 3 || len(ids) != 9 {
+		t.Fatalf("unexpected pages; got %d pages of %d records", pages, len(ids))
+	}
+	for _, query := range []string{"?limit=0", "?limit=1001", "?before=yesterday", "?cursor=!"} {
+		do(t, http.MethodGet, adminAudit+query, "", http.StatusBadRequest, &errorBody{})
+	}
+	do(t, http.MethodPost, adminAudit, "", http.StatusMethodNotAllowed, &errorBody{})
+}
+
+// failingAuditor is a database which can't append to its audit log.
+type failingAuditor struct{ *memory.Database }
+
+func (failingAuditor) Audit(context.Context, database.AuditRecord) error {
+	return errors.New("audit log is unavailable")
+}
+
+// TestRequireAudit checks actions are only failed when they can't be audited
+// if RequireAudit is set.
+func TestRequireAudit(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		code int
+	}{
+		{"optional", nil, http.StatusNoContent},
+		{"required", []Option{RequireAudit()}, http.StatusInternalServerError},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(ctx, append([]Option{DB(failingAuditor{memory.New()}), FS(memfs.New()), Admin("secret")}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Database.Create(ctx, databasetest.NewEntry("a")); err != nil {
+				t.Fatal(err)
+			}
+			adminDo(s)(t, http.MethodDelete, "/admin/files/a", "", tt.code, nil)
+			_, err = s.Database.Lookup(ctx, "a")
+			if deleted := errors.Is(err, database.ErrNoResults); deleted != (tt.code == http.StatusNoContent) {
+				t.Fatalf("unexpected lookup error: %v", err)
+			}
+		})
+	}
+
+	// Databases without audit logs can't be required to keep them, and
+	// don't list them.
+	db := struct{ database.Database }{memory.New()}
+	if _, err := New(ctx, DB(db), FS(memfs.New()), Admin("secret"), RequireAudit()); err == nil {
+		t.Fatal("expected an error")
+	}
+	s, err := New(ctx, DB(db), FS(memfs.New()), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminDo(s)(t, http.MethodGet, adminAudit, "", http.StatusNotImplemented, &errorBody{})
+}
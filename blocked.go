@@ -0,0 +1,32 @@
+package kipp
+
+import (
+	"net/http"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// maxBlockReason is the length of the longest reason code entries may be
+// blocked for.
+const maxBlockReason = 64
+
+// blockedError returns the error responded to requests for e, which was
+// blocked. It says why, but nothing else of e, not even its name.
+func blockedError(e database.Entry) *Error {
+	return newError(http.StatusUnavailableForLegalReasons, CodeBlocked, "the file is unavailable for legal reasons ("+e.BlockReason+")")
+}
+
+// validBlockReason reports whether reason is a code entries may be blocked
+// for, such as "dmca", which is of lowercase letters, digits, hyphens and
+// underscores.
+func validBlockReason(reason string) bool {
+	if reason == "" || len(reason) > maxBlockReason {
+		return false
+	}
+	for _, c := range reason {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestBlocked(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := databasetest.NewEntry("entry")
+	e.Name = "leaked.txt"
+	e.Lifetime = nil
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FileSystem.Create(ctx, e.Slug, strings.NewReader("some data")); err != nil {
+		t.Fatal(err)
+	}
+	do := func(t *testing.T, method, target, body string, code int, v any) {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, code, w.Body)
+		}
+		if v != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatalf("unmarshal %q: %v", w.Body, err)
+			}
+		}
+	}
+
+	var blocked adminEntry
+	do(t, http.MethodPatch, "/admin/files/entry", `{"block":"dmca"}`, http.StatusOK, &blocked)
+	if blocked.Blocked == nil || blocked.BlockReason != "dmca" {
+		t.Fatalf("unexpected entry: %+v", blocked)
+	}
+
+	// Neither the file nor its metadata are served, and nothing says what
+	// it was called.
+	for _, target := range []string{"/entry.txt", "/oembed?url=" + url.QueryEscape("http://example.com/entry.txt")} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		var res errorBody
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: unmarshal %q: %v", target, w.Body, err)
+		}
+		if w.Code != http.StatusUnavailableForLegalReasons || res.Error.Code != CodeBlocked {
+			t.Fatalf("%s: unexpected response; got %d %+v", target, w.Code, res.Error)
+		}
+		if strings.Contains(w.Body.String(), "leaked") || strings.Contains(w.Body.String(), "some data") {
+			t.Fatalf("%s: entry was leaked: %s", target, w.Body)
+		}
+	}
+
+	for _, body := range []string{`{"block":""}`, `{"block":"DMCA"}`, `{"block":"a b"}`, `{"block":1}`, `{"block":"` + strings.Repeat("a", maxBlockReason+1) + `"}`} {
+		do(t, http.MethodPatch, "/admin/files/entry", body, http.StatusBadRequest, &errorBody{})
+	}
+	do(t, http.MethodPatch, "/admin/files/missing", `{"block":"dmca"}`, http.StatusNotFound, &errorBody{})
+
+	var unblocked adminEntry
+	do(t, http.MethodPatch, "/admin/files/entry", `{"block":null}`, http.StatusOK, &unblocked)
+	if unblocked.Blocked != nil || unblocked.BlockReason != "" {
+		t.Fatalf("unexpected entry: %+v", unblocked)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/entry.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "some data" {
+		t.Fatalf("unexpected response; got %d %q", w.Code, w.Body)
+	}
+}
+
+// TestBlockedUnsupported checks the admin API says blocking is unsupported by
+// databases which can't.
+func TestBlockedUnsupported(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	s, err := New(ctx, DB(struct{ database.Database }{db}), FS(memfs.New()), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(ctx, databasetest.NewEntry("entry")); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPatch, "/admin/files/entry", strings.NewReader(`{"block":"dmca"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusNotImplemented)
+	}
+}
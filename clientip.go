@@ -0,0 +1,109 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPKey is the key of the client's IP in the contexts of requests.
+type clientIPKey struct{}
+
+// ClientIP returns the IP of the client which made the request with ctx, as
+// resolved by the server, if it's known.
+func ClientIP(ctx context.Context) (netip.Addr, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(netip.Addr)
+	return ip, ok
+}
+
+// withClientIP returns r with the IP of its client on its context, if it can
+// be resolved.
+func (s Server) withClientIP(r *http.Request) *http.Request {
+	ip, ok := s.clientIP(r)
+	if !ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// clientIP resolves the IP of the client of r. If its peer is a trusted
+// proxy, the client is the rightmost address which isn't in the Forwarded
+// header, or in X-Forwarded-For if there isn't one. Otherwise, it's the peer,
+// and the headers are ignored, as anyone can set them.
+func (s Server) clientIP(r *http.Request) (netip.Addr, bool) {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	ip := ap.Addr().Unmap()
+	if !s.trusted(ip) {
+		return ip, true
+	}
+	var hops []string
+	if fwd := r.Header.Values("Forwarded"); len(fwd) > 0 {
+		hops = forwardedFor(fwd)
+	} else {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		// Hops left of one which can't be parsed can't be trusted, so
+		// the proxy which forwarded it is the client.
+		if !ok {
+			break
+		}
+		ip = hop
+		if !s.trusted(ip) {
+			break
+		}
+	}
+	return ip, true
+}
+
+// trusted reports whether ip is a trusted proxy.
+func (s Server) trusted(ip netip.Addr) bool {
+	for _, p := range s.TrustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the values of the for parameters of the elements of
+// the given RFC 7239 Forwarded headers, in order.
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, h := range headers {
+		for _, elem := range strings.Split(h, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, v)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses the address of a hop of X-Forwarded-For, or a node of
+// Forwarded, which may be quoted, and have a port. Unknown and obfuscated
+// nodes aren't addresses.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return ip.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	// IPv6 nodes are bracketed, even without a port.
+	if ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return ip.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
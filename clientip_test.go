@@ -0,0 +1,94 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+	for _, tt := range []struct {
+		name    string
+		trusted []netip.Prefix
+		remote  string
+		header  map[string]string
+		want    string
+	}{
+		{"peer", trusted, "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"mapped peer", trusted, "[::ffff:192.0.2.1]:1234", nil, "192.0.2.1"},
+		{"no trusted proxies", nil, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "10.0.0.1"},
+		{"spoofed by untrusted peer", trusted, "192.0.2.1:1234", map[string]string{
+			"X-Forwarded-For": "203.0.113.7",
+			"Forwarded":       "for=203.0.113.7",
+		}, "192.0.2.1"},
+		{"trusted peer without header", trusted, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"forwarded for", trusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+		// The leftmost hop was sent by the client, so can't be trusted.
+		{"chained proxies", trusted, "10.0.0.1:1234", map[string]string{
+			"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.0.0.2",
+		}, "203.0.113.7"},
+		{"only proxies", trusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"invalid hop", trusted, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, bogus, 10.0.0.2"}, "10.0.0.2"},
+		{"rfc 7239", trusted, "10.0.0.1:1234", map[string]string{
+			"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8::17]:4711", for=10.0.0.2`,
+		}, "2001:db8::17"},
+		{"rfc 7239 with port", trusted, "[fd00::1]:1234", map[string]string{"Forwarded": `For="203.0.113.7:4711";by=fd00::1`}, "203.0.113.7"},
+		{"rfc 7239 unknown", trusted, "10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.1"},
+		{"rfc 7239 preferred", trusted, "10.0.0.1:1234", map[string]string{
+			"Forwarded":       "for=203.0.113.7",
+			"X-Forwarded-For": "198.51.100.1",
+		}, "203.0.113.7"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Server{TrustedProxies: tt.trusted}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			ip, ok := ClientIP(s.withClientIP(r).Context())
+			if !ok {
+				t.Fatal("no client ip")
+			}
+			if got := ip.String(); got != tt.want {
+				t.Fatalf("unexpected client ip; got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClientIPProfiling checks the resolved IP is what's allowed profiles,
+// rather than the proxy's.
+func TestClientIPProfiling(t *testing.T) {
+	s, err := New(context.Background(),
+		DB(memory.New()),
+		FS(memfs.New()),
+		TrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+		Profiling("", "", netip.MustParsePrefix("203.0.113.0/24")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		remote string
+		code   int
+	}{
+		{"10.0.0.1:1234", http.StatusOK},
+		{"192.0.2.1:1234", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+		r.RemoteAddr = tt.remote
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Fatalf("%s: unexpected status; got %d, want %d", tt.remote, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/uhthomas/kipp"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// dangling scans for entries whose files are missing, and resolves them with
+// the given action. It's safe to run while kipp is serving from the same
+// database and file system.
+func dangling(ctx context.Context) error {
+	set := flag.NewFlagSet("dangling", flag.ExitOnError)
+	db := set.String("database", "badger", "database - see docs for more information")
+	fs := set.String("filesystem", "files", "filesystem - see docs for more information")
+	var action kipp.DanglingAction
+	set.TextVar(&action, "action", kipp.ReportDangling, "what to do with entries whose files are missing: report, mark or delete")
+	set.Parse(os.Args[2:])
+
+	s, err := kipp.New(ctx,
+		kipp.ParseDB(*db),
+		kipp.ParseFS(*fs),
+		kipp.DanglingScan(0, action),
+		kipp.DatabaseMetrics(false),
+		kipp.FileSystemMetrics(false),
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := s.Database.Close(xcontext.Detach(ctx)); err != nil {
+			log.Printf("close database: %v", err)
+		}
+	}()
+
+	rep, err := s.ScanDangling(ctx, action)
+	for _, slug := range rep.Dangling {
+		fmt.Println(slug)
+	}
+	log.Printf("found %d dangling entries of %d, resolved %d with %s", len(rep.Dangling), rep.Entries, rep.Resolved, action)
+	if err != nil {
+		return fmt.Errorf("scan dangling: %w", err)
+	}
+	return nil
+}
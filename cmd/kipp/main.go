@@ -24,6 +24,16 @@ func Main(ctx context.Context) error {
 	switch cmd {
 	case "", "serve":
 		return serve(ctx)
+	case "shard":
+		return shard(ctx)
+	case "orphans":
+		return orphans(ctx)
+	case "dangling":
+		return dangling(ctx)
+	case "migrate":
+		return migrateFiles(ctx)
+	case "rebuild":
+		return rebuild(ctx)
 	default:
 		fmt.Printf("unknown command: %s\n", cmd)
 		return nil
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/encrypt"
+	"github.com/uhthomas/kipp/filesystem/migrate"
+	"github.com/uhthomas/kipp/filesystem/zstd"
+	"github.com/uhthomas/kipp/internal/databaseutil"
+	"github.com/uhthomas/kipp/internal/filesystemutil"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// migrateFiles copies the files of every entry from one file system to
+// another. It's safe to run while kipp is serving, such as from a fallback
+// file system of the two, and to run again to resume it.
+func migrateFiles(ctx context.Context) error {
+	set := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbURL := set.String("database", "badger", "database - see docs for more information")
+	from := set.String("from", "files", "filesystem to copy files from - see docs for more information")
+	to := set.String("to", "", "filesystem to copy files to - see docs for more information")
+	workers := set.Int("workers", 8, "files to copy at once")
+	verify := set.Bool("verify", false, "check files match the sums of their entries as they're copied")
+	compress := set.Bool("compress", false, "files are compressed at rest with zstd")
+	fileKeyFile := set.String("file-key-file", "", "file of a base64 32 byte key files are encrypted at rest with")
+	set.Parse(os.Args[2:])
+
+	if *to == "" {
+		return errors.New("missing -to")
+	}
+	db, err := databaseutil.Parse(ctx, *dbURL, slog.Default())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(xcontext.Detach(ctx)); err != nil {
+			log.Printf("close database: %v", err)
+		}
+	}()
+
+	// Files are read and written as kipp would, so they're checked against
+	// their entries and stored as kipp expects.
+	var key []byte
+	if *fileKeyFile != "" {
+		if key, err = readFileKey(*fileKeyFile); err != nil {
+			return err
+		}
+	}
+	var fss [2]filesystem.FileSystem
+	for i, s := range []string{*from, *to} {
+		fs, err := filesystemutil.Parse(ctx, s, slog.Default())
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			if fs, err = encrypt.New(fs, encrypt.Key(key)); err != nil {
+				return fmt.Errorf("encrypt filesystem: %w", err)
+			}
+		}
+		if *compress {
+			if fs, err = zstd.New(fs); err != nil {
+				return fmt.Errorf("compress filesystem: %w", err)
+			}
+		}
+		fss[i] = fs
+	}
+
+	rep, err := migrate.Migrate(ctx, db, fss[0], fss[1], migrate.Options{
+		Workers: *workers,
+		Verify:  *verify,
+		Progress: func(p migrate.Progress) {
+			if p.Entries%1000 == 0 {
+				log.Printf("migrated %d entries: copied %d files taking up %d bytes, skipped %d, %d entries failed",
+					p.Entries, p.Copied, p.Bytes, p.Skipped, p.Failed)
+			}
+		},
+	})
+	for _, f := range rep.Failures {
+		log.Printf("%s: %v", f.Slug, f.Err)
+	}
+	log.Printf("migrated %d entries: copied %d files taking up %d bytes, skipped %d, %d entries failed",
+		rep.Entries, rep.Copied, rep.Bytes, rep.Skipped, rep.Failed)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if rep.Failed > 0 {
+		return fmt.Errorf("%d entries failed, run again to retry them", rep.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/uhthomas/kipp"
+)
+
+// parseNamespace parses a namespace of the form
+// name?host=...&prefix=...&database=...&filesystem=..., optionally with
+// limit, lifetime and web parameters, which are otherwise those of shared,
+// which configure the namespace before its own.
+func parseNamespace(s string, shared []kipp.Option) (kipp.Namespace, error) {
+	name, query, _ := strings.Cut(s, "?")
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return kipp.Namespace{}, fmt.Errorf("namespace %q: %w", name, err)
+	}
+	ns := kipp.Namespace{Name: name, PathPrefix: q.Get("prefix")}
+	if h := q.Get("host"); h != "" {
+		ns.Hosts = strings.Split(h, ",")
+	}
+	db, fs := q.Get("database"), q.Get("filesystem")
+	if db == "" || fs == "" {
+		return kipp.Namespace{}, fmt.Errorf("namespace %q: database and filesystem are required", name)
+	}
+	ns.Options = append(ns.Options, shared...)
+	ns.Options = append(ns.Options, kipp.ParseDB(db), kipp.ParseFS(fs))
+	if v := q.Get("limit"); v != "" {
+		n, err := units.ParseBase2Bytes(v)
+		if err != nil {
+			return kipp.Namespace{}, fmt.Errorf("namespace %q: parse limit: %w", name, err)
+		}
+		ns.Options = append(ns.Options, kipp.Limit(int64(n)))
+	}
+	if v := q.Get("lifetime"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return kipp.Namespace{}, fmt.Errorf("namespace %q: parse lifetime: %w", name, err)
+		}
+		ns.Options = append(ns.Options, kipp.Lifetime(d))
+	}
+	if v := q.Get("web"); v != "" {
+		ns.Options = append(ns.Options, kipp.Data(v))
+	}
+	return ns, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/uhthomas/kipp"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// orphans scans for files without entries, removing them unless it's a dry
+// run. It's safe to run while kipp is serving from the same database and file
+// system.
+func orphans(ctx context.Context) error {
+	set := flag.NewFlagSet("orphans", flag.ExitOnError)
+	db := set.String("database", "badger", "database - see docs for more information")
+	fs := set.String("filesystem", "files", "filesystem - see docs for more information")
+	age := set.Duration("age", 24*time.Hour, "minimum age of files without entries to remove")
+	dryRun := set.Bool("dry-run", false, "only report files without entries, rather than removing them")
+	set.Parse(os.Args[2:])
+
+	s, err := kipp.New(ctx,
+		kipp.ParseDB(*db),
+		kipp.ParseFS(*fs),
+		kipp.OrphanScan(0, *age),
+		kipp.DatabaseMetrics(false),
+		kipp.FileSystemMetrics(false),
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := s.Database.Close(xcontext.Detach(ctx)); err != nil {
+			log.Printf("close database: %v", err)
+		}
+	}()
+
+	rep, err := s.ScanOrphans(ctx, *dryRun)
+	for _, name := range rep.Orphans {
+		fmt.Println(name)
+	}
+	log.Printf("found %d orphans of %d files taking up %d bytes, removed %d taking up %d bytes",
+		len(rep.Orphans), rep.Files, rep.Bytes, rep.Removed, rep.Reclaimed)
+	if err != nil {
+		return fmt.Errorf("scan orphans: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/uhthomas/kipp/filesystem/local"
+	"github.com/uhthomas/kipp/internal/databaseutil"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// rebuild creates the entries recorded by the sidecars of the local file
+// system which the database doesn't have, such as after it was lost. It's
+// safe to run while kipp is serving from the same database and directory.
+func rebuild(ctx context.Context) error {
+	set := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	dbURL := set.String("database", "badger", "database - see docs for more information")
+	dir := set.String("dir", "files", "local filesystem directory")
+	set.Parse(os.Args[2:])
+
+	db, err := databaseutil.Parse(ctx, *dbURL, slog.Default())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(xcontext.Detach(ctx)); err != nil {
+			log.Printf("close database: %v", err)
+		}
+	}()
+
+	n, err := local.Rebuild(ctx, *dir, db, slog.Default())
+	log.Printf("created %d entries", n)
+	if err != nil {
+		return fmt.Errorf("rebuild: %w", err)
+	}
+	return nil
+}
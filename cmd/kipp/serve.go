@@ -2,27 +2,127 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"mime"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/alecthomas/units"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/uhthomas/kipp"
-	"github.com/uhthomas/kipp/internal/httputil"
+	"github.com/uhthomas/kipp/database/namecrypt"
+	"github.com/uhthomas/kipp/database/retry"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+	"github.com/uhthomas/kipp/scan/clamd"
+	"github.com/uhthomas/kipp/verify/siteverify"
+	_ "modernc.org/sqlite"
 )
 
 func serve(ctx context.Context) error {
-	addr := flag.String("addr", ":80", "listen addr")
+	addr := flag.String("addr", ":80", "listen addr, or unix:///path/to/socket, unless sockets are passed by systemd")
 	db := flag.String("database", "badger", "database - see docs for more information")
 	fs := flag.String("filesystem", "files", "filesystem - see docs for more information")
 	web := flag.String("web", "web", "web directory")
+	livePath := flag.String("live-path", kipp.DefaultLivePath, "path of the liveness probe, empty to disable it")
+	readyPath := flag.String("ready-path", kipp.DefaultReadyPath, "path of the readiness probe, empty to disable it")
+	healthPath := flag.String("health-path", kipp.DefaultHealthPath, "path of the alias of the readiness probe, empty to disable it")
+	metricsPath := flag.String("metrics-path", kipp.DefaultMetricsPath, "path of metrics, empty to disable them")
+	metricsTokenFile := flag.String("metrics-token-file", "", "file of a bearer token which allows metrics to be requested")
+	metricsAllow := flag.String("metrics-allow", "", "comma separated CIDRs from which metrics may be requested")
+	listDirectories := flag.Bool("list-directories", false, "list the files of directories of -web without an index.html, rather than responding with 404 Not Found")
+	spa := flag.Bool("spa", false, "serve the index.html of -web for paths without extensions which aren't found, for single-page apps")
 	limit := flagBytesValue("limit", 150<<20, "upload limit")
+	quota := flagBytesValue("quota", 0, "maximum total size of stored files, 0 is unlimited")
+	apiKeysFile := flag.String("api-keys-file", "", "file of name:key API keys uploads may be authenticated with as bearer tokens, one per line, optionally followed by limit= and lifetime=")
+	usersFile := flag.String("users-file", "", "file of id:token users uploads may be authenticated as with their tokens as bearer tokens, one per line, with a line for each of a user's tokens")
+	userAccounts := flag.Bool("user-accounts", false, "let users and their tokens be created in the database with the admin API")
+	verifyURL := flag.String("verify-url", "", "siteverify endpoint to verify uploads not authenticated with an API key or as a user with, such as "+siteverify.TurnstileURL)
+	verifySecretFile := flag.String("verify-secret-file", "", "file of the secret of -verify-url")
+	verifyField := flag.String("verify-field", siteverify.DefaultField, "form field of the tokens verified with -verify-url")
+	clamdAddr := flag.String("clamd", "", "address of a clamd to scan uploads for malware with, such as localhost:3310 or a Unix socket's path")
+	clamdTimeout := flag.Duration("clamd-timeout", 30*time.Second, "how long clamd may take to connect, accept each chunk of an upload and respond")
+	clamdMaxSize := flagBytesValue("clamd-max-size", 0, "size of the prefix of uploads scanned by clamd, which should be at most its StreamMaxLength, 0 scans all of them")
+	scanFailOpen := flag.Bool("scan-fail-open", false, "store uploads which can't be scanned, such as when clamd is down, rather than rejecting them")
+	webhooks := flag.String("webhooks", "", "comma separated URLs to send events for uploaded, deleted and expired files to")
+	webhookSecretFile := flag.String("webhook-secret-file", "", "file of the secret -webhooks are signed with")
+	uploadAllow := flag.String("upload-allow", "", "comma separated CIDRs clients may only upload from, if any")
+	uploadDeny := flag.String("upload-deny", "", "comma separated CIDRs clients may not upload from")
+	uploadAllowFile := flag.String("upload-allow-file", "", "file of CIDRs clients may only upload from, one per line, reloaded on SIGHUP")
+	uploadDenyFile := flag.String("upload-deny-file", "", "file of CIDRs clients may not upload from, one per line, reloaded on SIGHUP")
+	maxUploads := flag.Int64("max-uploads", 0, "maximum uploads in progress at once, 0 is unlimited")
+	maxInFlight := flag.Int64("max-in-flight", 0, "maximum requests in progress at once, beyond which they're shed, 0 is unlimited")
+	maxInFlightUploads := flag.Int64("max-in-flight-uploads", 0, "maximum uploads in progress at once, beyond which they're shed, 0 is unlimited")
+	maxInFlightDownloads := flag.Int64("max-in-flight-downloads", 0, "maximum downloads in progress at once, beyond which they're shed, 0 is unlimited")
+	brownoutLatency := flag.Duration("brownout-latency", 0, "p99 of how long recent uploads took above which -brownout-shed of new uploads are shed, 0 disables")
+	brownoutShed := flag.Float64("brownout-shed", 0.5, "fraction of new uploads to shed while above -brownout-latency")
+	reportLimit := flag.Int("report-limit", 0, "maximum abuse reports each client's network may make per -report-window at /api/report, 0 disables them")
+	reportWindow := flag.Duration("report-window", time.Hour, "window abuse reports are limited per")
+	uploadWait := flag.Duration("upload-wait", 0, "how long uploads beyond -max-uploads wait for one to finish before they're rejected")
 	lifetime := flag.Duration("lifetime", 24*time.Hour, "file lifetime")
+	precompress := flagBytesValue("precompress", 0, "minimum size of compressible files to store a gzip variant of, 0 disables")
+	downloadStats := flag.Duration("download-stats", 0, "interval to flush per-day download counts, 0 disables")
+	lastAccess := flag.Duration("last-access", 0, "minimum interval between last access time updates, 0 disables")
+	slidingLifetime := flag.Duration("sliding-lifetime", 0, "extend file lifetimes to at least this long after each download, 0 disables")
+	maxLifetime := flag.Duration("max-lifetime", 0, "maximum file lifetime when sliding lifetimes are enabled, 0 is unlimited")
+	statsInterval := flag.Duration("stats-interval", 0, "interval to export database statistics as metrics, 0 disables")
+	orphanInterval := flag.Duration("orphan-interval", 0, "interval to scan for and remove files without entries, 0 disables")
+	orphanAge := flag.Duration("orphan-age", 24*time.Hour, "minimum age of files without entries to remove")
+	orphanDryRun := flag.Bool("orphan-dry-run", false, "only report files without entries, rather than removing them")
+	danglingInterval := flag.Duration("dangling-interval", 0, "interval to scan for entries whose files are missing, 0 disables")
+	var danglingAction kipp.DanglingAction
+	flag.TextVar(&danglingAction, "dangling-action", kipp.ReportDangling, "what to do with entries whose files are missing: report, mark or delete")
+	reapInterval := flag.Duration("reap-interval", 0, "interval to delete expired files, 0 disables")
+	reapBatch := flag.Int("reap-batch", 100, "expired files to list at a time when deleting them")
+	purgeAfter := flag.Duration("purge-after", 0, "how long soft deleted files are kept before -reap-interval deletes them, 0 keeps them until restored")
+	evictInterval := flag.Duration("evict-interval", 0, "interval to evict the least recently accessed files while storage is above -evict-high, 0 disables")
+	evictHigh := flagBytesValue("evict-high", 0, "total size of stored files above which files are evicted")
+	evictLow := flagBytesValue("evict-low", 0, "total size of stored files to evict files down to")
+	evictMinAge := flag.Duration("evict-min-age", time.Hour, "minimum age of files to evict")
+	staging := flag.String("staging", "", "filesystem to stage uploads in before they're promoted, see docs for more information")
+	stagingAge := flag.Duration("staging-age", 24*time.Hour, "minimum age of staged uploads to remove, such as those left behind by crashes")
+	databaseRetries := flag.Int("database-retries", 3, "maximum retries of failed database calls, 0 disables")
+	compress := flag.Bool("compress", false, "compress files at rest with zstd")
+	fileKeyFile := flag.String("file-key-file", "", "file of a base64 32 byte key to encrypt files at rest with")
+	nameKeysFile := flag.String("name-keys-file", "", "file of id:base64 keys to encrypt names in the database with, the first of which is primary")
+	logFormat := flag.String("log-format", "", "format of logs: text, json, or that of the standard logger if empty")
+	var logLevel slog.Level
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "minimum level of logs: debug, info, warn or error")
+	accessLog := flag.String("access-log", "", "file to append an access log in the Combined Log Format to, - for stdout, or none if empty")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated CIDRs of proxies trusted to name clients with Forwarded or X-Forwarded-For")
+	pathPrefix := flag.String("path-prefix", "", "path kipp is served under by a proxy which strips it, so the URLs it responds with include it")
+	allowedHosts := flag.String("allowed-hosts", "", "comma separated hosts requests may be for, which may start with *. to allow subdomains, or any if empty")
+	adminTokenFile := flag.String("admin-token-file", "", "file of a bearer token which allows the admin API to be used, which is disabled otherwise")
+	requireAudit := flag.Bool("require-audit", false, "fail admin actions which can't be appended to the database's audit log, rather than only logging them")
+	readOnly := flag.Bool("read-only", false, "start read-only, refusing uploads while still serving files, which SIGUSR2 and the admin API toggle")
+	readOnlyAllowAdmin := flag.Bool("read-only-allow-admin", false, "allow admin API mutations, such as deletes, while read-only")
+	pprof := flag.Bool("pprof", false, "serve runtime profiles, guarded by -pprof-token-file or -pprof-allow")
+	pprofPrefix := flag.String("pprof-prefix", kipp.DefaultProfilePrefix, "path prefix to serve runtime profiles under")
+	pprofTokenFile := flag.String("pprof-token-file", "", "file of a bearer token which allows runtime profiles to be requested")
+	pprofAllow := flag.String("pprof-allow", "", "comma separated CIDRs from which runtime profiles may be requested")
 	// a negative grace period waits indefinitely
 	// a zero grace period immediately terminates
 	gracePeriod := flag.Duration("grace-period", time.Minute, "termination grace period")
+	headerTimeout := flag.Duration("header-timeout", 10*time.Second, "how long clients have to send request headers, 0 is unlimited")
+	progressTimeout := flag.Duration("progress-timeout", 0, "how long uploads and downloads may stall before they're cut off, 0 is unlimited")
+	transferTimeout := flag.Duration("transfer-timeout", 0, "how long uploads and downloads may take, 0 is unlimited")
+	tlsCert := flag.String("tls-cert", "", "file of a certificate to serve TLS with, reloaded on change or SIGHUP")
+	tlsKey := flag.String("tls-key", "", "file of the key of -tls-cert")
+	acmeHosts := flag.String("acme-hosts", "", "comma separated hosts to obtain certificates for from Let's Encrypt, accepting its terms of service")
+	acmeCache := flag.String("acme-cache", "acme", "directory to cache certificates obtained with -acme-hosts in")
+	socketMode := flag.String("socket-mode", "", "octal mode of the unix socket listened on, such as 0660, or that of the umask if empty")
+	redirectAddr := flag.String("redirect-addr", "", "addr to redirect plain HTTP requests to HTTPS from when serving TLS")
+	var namespaces []string
+	flag.Func("namespace", "namespace of the form name?host=...&prefix=...&database=...&filesystem=..., optionally with limit, lifetime and web, serving requests for its hosts or under its prefix with storage of its own; may be repeated", func(s string) error {
+		namespaces = append(namespaces, s)
+		return nil
+	})
 	flag.Parse()
 
 	for k, v := range mimeTypes {
@@ -33,17 +133,380 @@ func serve(ctx context.Context) error {
 		}
 	}
 
-	s, err := kipp.New(ctx,
+	retryPolicy := retry.DefaultPolicy
+	retryPolicy.Attempts = *databaseRetries + 1
+
+	// Namespaces share these, as they don't name anything which is the
+	// server's own.
+	shared := []kipp.Option{
+		kipp.Lifetime(*lifetime),
+		kipp.Limit(int64(*limit)),
+		kipp.DatabaseRetry(retryPolicy),
+		kipp.Reaper(*reapInterval, *reapBatch),
+		kipp.Data(*web),
+		kipp.ProbePaths(*livePath, *readyPath, *healthPath),
+		kipp.MetricsPath(*metricsPath),
+		kipp.GracePeriod(*gracePeriod),
+		kipp.Timeouts(*headerTimeout, *progressTimeout, *transferTimeout),
+		kipp.ReadOnlySignal(),
+	}
+	logger, err := newLogger(*logFormat, logLevel)
+	if err != nil {
+		return err
+	}
+	// The logger comes first, so the database and file system log to it.
+	opts := []kipp.Option{
+		kipp.Logger(logger),
 		kipp.ParseDB(*db),
 		kipp.ParseFS(*fs),
 		kipp.Lifetime(*lifetime),
 		kipp.Limit(int64(*limit)),
+		kipp.Quota(int64(*quota)),
+		kipp.MaxConcurrentUploads(*maxUploads, *uploadWait),
+		kipp.ShedLoad(*maxInFlight, *maxInFlightUploads, *maxInFlightDownloads),
+		kipp.Brownout(*brownoutLatency, *brownoutShed),
+		kipp.Reports(*reportLimit, *reportWindow),
+		kipp.Precompress(int64(*precompress)),
+		kipp.DownloadStats(*downloadStats),
+		kipp.LastAccess(*lastAccess),
+		kipp.SlidingLifetime(*slidingLifetime, *maxLifetime),
+		kipp.DatabaseRetry(retryPolicy),
+		kipp.StatsInterval(*statsInterval),
+		kipp.OrphanScan(*orphanInterval, *orphanAge),
+		kipp.DanglingScan(*danglingInterval, danglingAction),
+		kipp.Reaper(*reapInterval, *reapBatch),
+		kipp.PurgeAfter(*purgeAfter),
+		kipp.Eviction(*evictInterval, int64(*evictHigh), int64(*evictLow), *evictMinAge),
 		kipp.Data(*web),
-	)
+		kipp.ProbePaths(*livePath, *readyPath, *healthPath),
+		kipp.MetricsPath(*metricsPath),
+		kipp.GracePeriod(*gracePeriod),
+		kipp.Timeouts(*headerTimeout, *progressTimeout, *transferTimeout),
+	}
+	if *nameKeysFile != "" {
+		b, err := os.ReadFile(*nameKeysFile)
+		if err != nil {
+			return fmt.Errorf("read name keys: %w", err)
+		}
+		keys, err := namecrypt.ParseKeys(string(b))
+		if err != nil {
+			return fmt.Errorf("parse name keys: %w", err)
+		}
+		opts = append(opts, kipp.NameKeys(keys))
+	}
+
+	if *staging != "" {
+		opts = append(opts, kipp.ParseStaging(*staging, *stagingAge))
+	}
+	if *spa {
+		opts = append(opts, kipp.SPAFallback())
+	}
+	if *listDirectories {
+		opts = append(opts, kipp.ListDirectories())
+	}
+	if *orphanDryRun {
+		opts = append(opts, kipp.OrphanDryRun())
+	}
+	if *compress {
+		opts = append(opts, kipp.Compress())
+	}
+	if *fileKeyFile != "" {
+		key, err := readFileKey(*fileKeyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, kipp.FileKey(key))
+	}
+	proxies, err := parsePrefixes(*trustedProxies)
+	if err != nil {
+		return fmt.Errorf("parse trusted proxies: %w", err)
+	}
+	opts = append(opts, kipp.TrustedProxies(proxies...))
+	shared = append(shared, kipp.TrustedProxies(proxies...))
+	if *adminTokenFile != "" {
+		b, err := os.ReadFile(*adminTokenFile)
+		if err != nil {
+			return fmt.Errorf("read admin token: %w", err)
+		}
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return errors.New("admin token file is empty")
+		}
+		opts = append(opts, kipp.Admin(token))
+		shared = append(shared, kipp.Admin(token))
+	}
+	if *requireAudit {
+		opts = append(opts, kipp.RequireAudit())
+	}
+	opts = append(opts, kipp.ReadOnlySignal())
+	if *readOnly {
+		opts = append(opts, kipp.ReadOnly())
+		shared = append(shared, kipp.ReadOnly())
+	}
+	if *readOnlyAllowAdmin {
+		opts = append(opts, kipp.ReadOnlyAllowAdmin())
+		shared = append(shared, kipp.ReadOnlyAllowAdmin())
+	}
+	if *apiKeysFile != "" {
+		keys, err := readAPIKeys(*apiKeysFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, kipp.APIKeys(keys...))
+	}
+	if *usersFile != "" {
+		users, err := readUsers(*usersFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, kipp.Users(users...))
+	}
+	if *userAccounts {
+		opts = append(opts, kipp.UserAccounts())
+	}
+	if *verifyURL != "" {
+		b, err := os.ReadFile(*verifySecretFile)
+		if err != nil {
+			return fmt.Errorf("read verify secret: %w", err)
+		}
+		secret := strings.TrimSpace(string(b))
+		if secret == "" {
+			return errors.New("verify secret file is empty")
+		}
+		opts = append(opts, kipp.VerifyUploads(siteverify.New(*verifyURL, secret, siteverify.Field(*verifyField))))
+	}
+	if *clamdAddr != "" {
+		opts = append(opts, kipp.ScanUploads(clamd.New(*clamdAddr, clamd.Timeout(*clamdTimeout), clamd.MaxSize(int64(*clamdMaxSize)))))
+	}
+	if *scanFailOpen {
+		opts = append(opts, kipp.ScanFailOpen())
+	}
+	if *webhooks != "" {
+		b, err := os.ReadFile(*webhookSecretFile)
+		if err != nil {
+			return fmt.Errorf("read webhook secret: %w", err)
+		}
+		secret := strings.TrimSpace(string(b))
+		if secret == "" {
+			return errors.New("webhook secret file is empty")
+		}
+		opts = append(opts, kipp.Webhooks(secret, strings.Split(*webhooks, ",")...))
+	}
+	if *uploadAllow != "" || *uploadDeny != "" {
+		allow, err := parsePrefixes(*uploadAllow)
+		if err != nil {
+			return fmt.Errorf("parse upload allow: %w", err)
+		}
+		deny, err := parsePrefixes(*uploadDeny)
+		if err != nil {
+			return fmt.Errorf("parse upload deny: %w", err)
+		}
+		opts = append(opts, kipp.UploadNetworks(allow, deny))
+	}
+	if *uploadAllowFile != "" || *uploadDenyFile != "" {
+		opts = append(opts, kipp.UploadNetworkFiles(*uploadAllowFile, *uploadDenyFile))
+	}
+	if *metricsTokenFile != "" || *metricsAllow != "" {
+		var token string
+		if *metricsTokenFile != "" {
+			b, err := os.ReadFile(*metricsTokenFile)
+			if err != nil {
+				return fmt.Errorf("read metrics token: %w", err)
+			}
+			if token = strings.TrimSpace(string(b)); token == "" {
+				return errors.New("metrics token file is empty")
+			}
+		}
+		allow, err := parsePrefixes(*metricsAllow)
+		if err != nil {
+			return fmt.Errorf("parse metrics allow: %w", err)
+		}
+		opts = append(opts, kipp.ProtectMetrics(token, allow...))
+	}
+	if *pprof {
+		var token string
+		if *pprofTokenFile != "" {
+			b, err := os.ReadFile(*pprofTokenFile)
+			if err != nil {
+				return fmt.Errorf("read pprof token: %w", err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		allow, err := parsePrefixes(*pprofAllow)
+		if err != nil {
+			return fmt.Errorf("parse pprof allow: %w", err)
+		}
+		opts = append(opts, kipp.Profiling(*pprofPrefix, token, allow...))
+	}
+	if *pathPrefix != "" {
+		opts = append(opts, kipp.PathPrefix(*pathPrefix))
+	}
+	if *allowedHosts != "" {
+		opts = append(opts, kipp.AllowHosts(strings.Split(*allowedHosts, ",")...))
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		opts = append(opts, kipp.Certificate(*tlsCert, *tlsKey))
+	}
+	if *acmeHosts != "" {
+		opts = append(opts, kipp.ACME(*acmeCache, strings.Split(*acmeHosts, ",")...))
+	}
+	if *socketMode != "" {
+		mode, err := strconv.ParseUint(*socketMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("parse socket mode: %w", err)
+		}
+		opts = append(opts, kipp.SocketMode(os.FileMode(mode)))
+	}
+	if *redirectAddr != "" {
+		opts = append(opts, kipp.RedirectHTTP(*redirectAddr))
+	}
+	switch *accessLog {
+	case "":
+	case "-":
+		opts = append(opts, kipp.AccessLog(os.Stdout))
+	default:
+		f, err := os.OpenFile(*accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("open access log: %w", err)
+		}
+		defer f.Close()
+		opts = append(opts, kipp.AccessLog(f))
+	}
+	for _, v := range namespaces {
+		ns, err := parseNamespace(v, shared)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, kipp.Namespaces(ns))
+	}
+
+	// Background work is stopped by shutting down the server, once
+	// requests in flight have finished.
+	s, err := kipp.New(xcontext.Detach(ctx), opts...)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("listening on %s", *addr)
-	return httputil.ListenAndServe(ctx, *addr, s, *gracePeriod)
+	logger.Info("listening", "addr", *addr)
+	if *tlsCert != "" || *acmeHosts != "" {
+		return s.ListenAndServeTLS(ctx, *addr)
+	}
+	return s.ListenAndServe(ctx, *addr)
+}
+
+// newLogger returns a logger of the given format, logging to stderr at level
+// and above. The empty format logs with the log package, as slog.Default does.
+func newLogger(format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "":
+		slog.SetLogLoggerLevel(level)
+		return slog.Default(), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// parsePrefixes parses the comma separated CIDRs in s, ignoring space around
+// them.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var ps []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// readAPIKeys reads API keys from the named file, one name:key pair per line,
+// optionally followed by limit= and lifetime= overriding -limit and -lifetime
+// for uploads made with the key, ignoring blank lines and comments starting
+// with #.
+func readAPIKeys(name string) ([]kipp.APIKey, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read API keys: %w", err)
+	}
+	var keys []kipp.APIKey
+	for i, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		n, k, ok := strings.Cut(fields[0], ":")
+		if !ok || n == "" || k == "" {
+			return nil, fmt.Errorf("API keys line %d: not name:key", i+1)
+		}
+		key := kipp.APIKey{Name: n, Key: k}
+		for _, f := range fields[1:] {
+			switch opt, v, _ := strings.Cut(f, "="); opt {
+			case "limit":
+				limit, err := units.ParseBase2Bytes(v)
+				if err != nil {
+					return nil, fmt.Errorf("API keys line %d: parse limit: %w", i+1, err)
+				}
+				key.Limit = int64(limit)
+			case "lifetime":
+				if key.Lifetime, err = time.ParseDuration(v); err != nil {
+					return nil, fmt.Errorf("API keys line %d: parse lifetime: %w", i+1, err)
+				}
+			default:
+				return nil, fmt.Errorf("API keys line %d: unknown option %q", i+1, opt)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// readUsers reads users from the named file, one id:token pair per line, with
+// a line for each of a user's tokens, ignoring blank lines and comments
+// starting with #.
+func readUsers(name string) ([]kipp.User, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read users: %w", err)
+	}
+	var users []kipp.User
+	index := make(map[string]int)
+	for i, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, token, ok := strings.Cut(line, ":")
+		if !ok || id == "" || token == "" {
+			return nil, fmt.Errorf("users line %d: not id:token", i+1)
+		}
+		j, ok := index[id]
+		if !ok {
+			j = len(users)
+			index[id] = j
+			users = append(users, kipp.User{ID: id})
+		}
+		users[j].Tokens = append(users[j].Tokens, token)
+	}
+	return users, nil
+}
+
+// readFileKey reads the base64 encoded key files are encrypted with from the
+// named file.
+func readFileKey(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read file key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("decode file key: %w", err)
+	}
+	return key, nil
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/uhthomas/kipp/filesystem/local"
+)
+
+// shard moves files stored flat by the local file system into its sharded
+// layout. It's safe to run while kipp is serving from the same directory.
+func shard(ctx context.Context) error {
+	set := flag.NewFlagSet("shard", flag.ExitOnError)
+	dir := set.String("dir", "files", "local filesystem directory")
+	depth := set.Int("depth", 2, "levels of directories to shard files into, which must match the filesystem's shard parameter")
+	set.Parse(os.Args[2:])
+
+	fs, err := local.New(*dir, local.Shard(*depth))
+	if err != nil {
+		return err
+	}
+	n, err := fs.Migrate(ctx)
+	log.Printf("moved %d files", n)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+const (
+	// missingReason is the reason entries whose files are missing are soft
+	// deleted with, so they're served as 410 Gone.
+	missingReason = "file missing"
+	// adminDanglingScan is the path dangling scans are run from.
+	adminDanglingScan = adminPrefix + "scans/dangling"
+)
+
+// errGone is returned by lookups of entries whose files are missing. It wraps
+// database.ErrNoResults, as there's nothing to serve.
+var errGone = fmt.Errorf("%w: %s", database.ErrNoResults, missingReason)
+
+// A DanglingAction is what's done with entries whose files are missing.
+type DanglingAction int
+
+const (
+	// ReportDangling only reports them.
+	ReportDangling DanglingAction = iota
+	// MarkDangling soft deletes them, so they're served as 410 Gone. The
+	// database must implement database.SoftDeleter.
+	MarkDangling
+	// DeleteDangling deletes them.
+	DeleteDangling
+)
+
+var danglingActions = [...]string{"report", "mark", "delete"}
+
+func (a DanglingAction) String() string {
+	if a < 0 || int(a) >= len(danglingActions) {
+		return fmt.Sprintf("DanglingAction(%d)", a)
+	}
+	return danglingActions[a]
+}
+
+// MarshalText returns the name of a, such as "mark".
+func (a DanglingAction) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
+
+// UnmarshalText sets a to the action named b.
+func (a *DanglingAction) UnmarshalText(b []byte) error {
+	for i, s := range danglingActions {
+		if s == string(b) {
+			*a = DanglingAction(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown dangling action %q", b)
+}
+
+// A DanglingReport describes the dangling entries found by ScanDangling.
+type DanglingReport struct {
+	// Entries is how many entries were scanned.
+	Entries int
+	// Dangling are the slugs of entries whose files are missing.
+	Dangling []string
+	// Resolved is how many of them were marked or deleted.
+	Resolved int
+}
+
+// ScanDangling finds entries whose files are missing, such as after a disk
+// failure, and marks, deletes or only reports them according to action.
+// Expired and soft deleted entries aren't served, so are skipped. Entries
+// which can't be checked or resolved are logged and left for the next scan.
+func (s Server) ScanDangling(ctx context.Context, action DanglingAction) (DanglingReport, error) {
+	var rep DanglingReport
+	now := time.Now()
+	var opts database.ListOptions
+	for {
+		entries, next, err := s.Database.List(ctx, opts)
+		if err != nil {
+			return rep, fmt.Errorf("list: %w", err)
+		}
+		for _, e := range entries {
+			if e.Deleted != nil || (e.Lifetime != nil && e.Lifetime.Before(now)) {
+				continue
+			}
+			rep.Entries++
+			missing, err := s.missing(ctx, e)
+			if err != nil {
+				s.logger().Warn("stat dangling", "slug", e.Slug, "error", err)
+				continue
+			}
+			if !missing {
+				continue
+			}
+			rep.Dangling = append(rep.Dangling, e.Slug)
+			if action == ReportDangling {
+				continue
+			}
+			if err := s.resolveDangling(ctx, e.Slug, action); err != nil {
+				if !errors.Is(err, database.ErrNoResults) {
+					s.logger().Warn("resolve dangling", "slug", e.Slug, "action", action, "error", err)
+				}
+				continue
+			}
+			rep.Resolved++
+			if s.danglingMetrics != nil {
+				s.danglingMetrics.resolved.WithLabelValues(action.String()).Inc()
+			}
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if s.danglingMetrics != nil {
+		s.danglingMetrics.dangling.Set(float64(len(rep.Dangling)))
+	}
+	return rep, nil
+}
+
+// missing reports whether the file of e, or its gzip variant, is missing.
+func (s Server) missing(ctx context.Context, e database.Entry) (bool, error) {
+	names := []string{e.Slug}
+	if e.GzipSize > 0 {
+		names = append(names, gzipName(e.Slug))
+	}
+	for _, name := range names {
+		if _, err := s.FileSystem.Stat(ctx, name); err != nil {
+			if filesystem.IsNotExist(err) {
+				return true, nil
+			}
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (s Server) resolveDangling(ctx context.Context, slug string, action DanglingAction) error {
+	switch action {
+	case MarkDangling:
+		db, ok := s.Database.(database.SoftDeleter)
+		if !ok {
+			return errors.New("database does not support soft deletes")
+		}
+		return db.SoftDelete(ctx, slug, time.Now(), missingReason)
+	case DeleteDangling:
+		return s.Delete(ctx, slug)
+	}
+	return fmt.Errorf("unknown action %v", action)
+}
+
+// errFileGone is responded to requests for entries whose files are missing.
+var errFileGone = newError(http.StatusGone, CodeGone, "the file was lost, and can't be downloaded")
+
+// goneWriter responds to r with the error of s in place of the 404 Not Found
+// written by http.FileServer, which is err once it's set, such as 410 Gone for
+// entries whose files are missing.
+type goneWriter struct {
+	http.ResponseWriter
+	s    Server
+	r    *http.Request
+	err  *Error
+	sent bool
+}
+
+func (w *goneWriter) WriteHeader(code int) {
+	if code != http.StatusNotFound {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.sent = true
+	if w.err != nil {
+		w.s.httpError(w.ResponseWriter, w.r, w.err)
+		return
+	}
+	w.s.httpError(w.ResponseWriter, w.r, errNotFound)
+}
+
+func (w *goneWriter) Write(b []byte) (int, error) {
+	if w.sent {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom passes through to the underlying http.ResponseWriter, so sendfile
+// can still be used.
+func (w *goneWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *goneWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// danglingMetrics export the results of dangling entry scans.
+type danglingMetrics struct {
+	dangling prometheus.Gauge
+	resolved *prometheus.CounterVec
+}
+
+func newDanglingMetrics(r prometheus.Registerer) (*danglingMetrics, error) {
+	m := &danglingMetrics{
+		dangling: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "dangling_entries",
+			Help:      "Number of entries whose files are missing found by the last dangling entry scan.",
+		}),
+		resolved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "dangling_entries_resolved_total",
+			Help:      "Number of entries whose files are missing which were marked or deleted.",
+		}, []string{"action"}),
+	}
+	for _, c := range []prometheus.Collector{m.dangling, m.resolved} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// runDanglingScans scans for dangling entries every interval until ctx is
+// done.
+func runDanglingScans(ctx context.Context, s Server, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		// Dangling entries are only reported while the server is
+		// read-only.
+		action := s.DanglingAction
+		if s.readOnly.On() {
+			action = ReportDangling
+		}
+		rep, err := s.ScanDangling(ctx, action)
+		if err != nil {
+			s.logger().Error("scan dangling", "error", err)
+			continue
+		}
+		if len(rep.Dangling) > 0 {
+			s.logger().Info("found dangling entries", "dangling", len(rep.Dangling), "entries", rep.Entries, "resolved", rep.Resolved, "action", action)
+		}
+	}
+}
+
+// adminDanglingReport is a DanglingReport as the admin API serves it.
+type adminDanglingReport struct {
+	Entries  int      `json:"entries"`
+	Dangling []string `json:"dangling"`
+	Resolved int      `json:"resolved"`
+}
+
+// serveAdminDanglingScan scans for dangling entries, resolving them with the
+// action of the body, and serves what was found.
+func (s Server) serveAdminDanglingScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	var req struct {
+		Action *DanglingAction `json:"action"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+		return
+	}
+	if req.Action == nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "action is required"))
+		return
+	}
+	if _, ok := s.Database.(database.SoftDeleter); *req.Action == MarkDangling && !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support soft deletes"))
+		return
+	}
+	type scan struct {
+		Action DanglingAction `json:"action"`
+	}
+	if err := s.audit(r, "scan dangling", "", nil, scan{*req.Action}); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	rep, err := s.ScanDangling(r.Context(), *req.Action)
+	if err != nil {
+		s.adminDatabaseError(w, r, "scan dangling", err)
+		return
+	}
+	s.adminJSON(w, r, http.StatusOK, adminDanglingReport{
+		Entries:  rep.Entries,
+		Dangling: rep.Dangling,
+		Resolved: rep.Resolved,
+	})
+}
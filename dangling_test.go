@@ -0,0 +1,115 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// newDanglingServer returns a server with the entries a, whose files exist, b,
+// whose file is missing, and c, whose gzip variant is missing. The expired
+// entry d and soft deleted entry e are missing their files too.
+func newDanglingServer(t *testing.T) Server {
+	t.Helper()
+	ctx := context.Background()
+	s := Server{Database: memory.New(), FileSystem: memfs.New()}
+	past := time.Now().Add(-time.Hour)
+	for _, slug := range []string{"a", "b", "c", "d", "e"} {
+		e := databasetest.NewEntry(slug)
+		e.GzipSize = 0
+		switch slug {
+		case "a", "c":
+			e.GzipSize = 3
+		case "d":
+			e.Lifetime = &past
+		case "e":
+			e.Deleted = &past
+		}
+		if err := s.Database.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	for _, name := range []string{"a", "a.gz", "c"} {
+		if err := s.FileSystem.Create(ctx, name, strings.NewReader("abc")); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	return s
+}
+
+func TestScanDangling(t *testing.T) {
+	ctx := context.Background()
+	want := []string{"b", "c"}
+
+	t.Run("report", func(t *testing.T) {
+		s := newDanglingServer(t)
+		rep, err := s.ScanDangling(ctx, ReportDangling)
+		if err != nil {
+			t.Fatalf("scan dangling: %v", err)
+		}
+		sort.Strings(rep.Dangling)
+		if !reflect.DeepEqual(rep.Dangling, want) || rep.Entries != 3 || rep.Resolved != 0 {
+			t.Fatalf("unexpected report; got %+v, want %q of 3 entries and none resolved", rep, want)
+		}
+		if _, err := s.lookup(ctx, "b"); err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+	})
+
+	t.Run("mark", func(t *testing.T) {
+		s := newDanglingServer(t)
+		if rep, err := s.ScanDangling(ctx, MarkDangling); err != nil || rep.Resolved != 2 {
+			t.Fatalf("unexpected report; got %+v and %v, want 2 resolved", rep, err)
+		}
+		for _, slug := range want {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+slug+".txt", nil))
+			if w.Code != http.StatusGone || !strings.Contains(w.Body.String(), "lost") {
+				t.Fatalf("unexpected response for %s; got %d %q, want %d", slug, w.Code, w.Body, http.StatusGone)
+			}
+		}
+		// Other soft deleted entries are still not found.
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/e", nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := newDanglingServer(t)
+		if rep, err := s.ScanDangling(ctx, DeleteDangling); err != nil || rep.Resolved != 2 {
+			t.Fatalf("unexpected report; got %+v and %v, want 2 resolved", rep, err)
+		}
+		for _, slug := range want {
+			if _, err := s.Database.Lookup(ctx, slug); !errors.Is(err, database.ErrNoResults) {
+				t.Fatalf("unexpected error for %s; got %v, want %v", slug, err, database.ErrNoResults)
+			}
+		}
+		if _, err := s.FileSystem.Stat(ctx, "c"); err == nil {
+			t.Fatal("file of deleted entry wasn't removed")
+		}
+		if _, err := s.Database.Lookup(ctx, "a"); err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+	})
+}
+
+func TestMarkDanglingUnsupported(t *testing.T) {
+	// Hide the database's SoftDelete method.
+	db := struct{ database.Database }{memory.New()}
+	if _, err := New(context.Background(), DB(db), FS(memfs.New()), DanglingScan(0, MarkDangling), DatabaseMetrics(false)); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// An Auditor keeps an append-only log of administrative and destructive
+// actions, so who did what can be answered later. Records are never changed
+// or removed.
+type Auditor interface {
+	// Audit appends r to the log.
+	Audit(ctx context.Context, r AuditRecord) error
+	// AuditLog lists records matching opts, newest first, returning a
+	// cursor for the next page which is empty when there are no more.
+	AuditLog(ctx context.Context, opts AuditOptions) (records []AuditRecord, next string, err error)
+}
+
+// An AuditRecord records an action.
+type AuditRecord struct {
+	// ID is unique, and orders records made at the same time.
+	ID   string
+	Time time.Time
+	// Actor is who took the action, such as the ID of a token.
+	Actor  string
+	Action string
+	// Target is what the action was taken on, such as the slug of an
+	// entry or a sum, if anything.
+	Target    string
+	RequestID string
+	Remote    string
+	// Before and After are what the target was before and after the
+	// action as JSON, where they're meaningful.
+	Before, After string
+}
+
+// AuditOptions configures AuditLog.
+type AuditOptions struct {
+	// Limit is the maximum number of records to list, or DefaultListLimit
+	// if zero.
+	Limit int
+	// Cursor continues a previous listing from where it left off.
+	Cursor string
+	// Actor, Action and Target, when non-empty, only list records with
+	// them.
+	Actor, Action, Target string
+	// Before and After, when non-zero, only list records made before or
+	// after them.
+	Before, After time.Time
+}
+
+// Size returns the maximum number of records to list.
+func (o AuditOptions) Size() int {
+	if o.Limit <= 0 {
+		return DefaultListLimit
+	}
+	return o.Limit
+}
+
+// Match reports whether r passes the filters in o. The cursor is not
+// considered.
+func (o AuditOptions) Match(r AuditRecord) bool {
+	for _, v := range []struct{ want, got string }{
+		{o.Actor, r.Actor},
+		{o.Action, r.Action},
+		{o.Target, r.Target},
+	} {
+		if v.want != "" && v.want != v.got {
+			return false
+		}
+	}
+	if !o.Before.IsZero() && !r.Time.Before(o.Before) {
+		return false
+	}
+	return o.After.IsZero() || r.Time.After(o.After)
+}
+
+// Page trims records, which are listed newest first and hold at most one more
+// record than the limit, to the limit. It returns the trimmed records and the
+// cursor for the next page, which is empty if nothing was trimmed.
+func (o AuditOptions) Page(records []AuditRecord) ([]AuditRecord, string) {
+	if n := o.Size(); len(records) > n {
+		return records[:n], AuditCursorOf(records[n-1]).String()
+	}
+	return records, ""
+}
+
+// AuditCursorOf returns the cursor for r, which is its time and ID.
+func AuditCursorOf(r AuditRecord) Cursor { return Cursor{Timestamp: r.Time, Slug: r.ID} }
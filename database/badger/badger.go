@@ -3,9 +3,11 @@ package badger
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dgraph-io/badger/v2"
 	"github.com/uhthomas/kipp/database"
@@ -21,25 +23,235 @@ func Open(name string) (*Database, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open: %w", err)
 	}
-	return &Database{db: db}, nil
+	d := &Database{db: db}
+	if err := d.index(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// timestampsPrefix prefixes the keys of the timestamp index, which are
+// followed by the big endian unix nanoseconds of the timestamp and the slug.
+// Slugs never contain a "/", so the keys can't collide with entries.
+const timestampsPrefix = "timestamps/"
+
+// sumsPrefix prefixes the keys of the sum index, which are followed by the
+// sum, a zero byte and the slug.
+const sumsPrefix = "sums/"
+
+// indexed marks databases with complete indexes. It changes whenever an index
+// is added, so older databases are indexed again.
+var indexed = []byte("indexed/sums")
+
+func timestampKey(c database.Cursor) []byte {
+	b := make([]byte, len(timestampsPrefix)+8, len(timestampsPrefix)+8+len(c.Slug))
+	copy(b, timestampsPrefix)
+	binary.BigEndian.PutUint64(b[len(timestampsPrefix):], uint64(c.Timestamp.UnixNano()))
+	return append(b, c.Slug...)
+}
+
+func sumKey(sum, slug string) []byte { return []byte(sumsPrefix + sum + "\x00" + slug) }
+
+// indexKeys returns the index keys for e.
+func indexKeys(e database.Entry) [][]byte {
+	return [][]byte{timestampKey(database.CursorOf(e)), sumKey(e.Sum, e.Slug)}
 }
 
-// Create sets the key, slug with the gob encoded value of e.
-func (db *Database) Create(_ context.Context, e database.Entry) error {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
-		return fmt.Errorf("gob encode: %w", err)
+// index builds the indexes for databases created before they existed.
+func (db *Database) index() error {
+	if err := db.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(indexed)
+		return err
+	}); !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
 	}
-	return db.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(e.Slug), buf.Bytes())
-	})
+	wb := db.db.NewWriteBatch()
+	defer wb.Cancel()
+	if err := db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if bytes.IndexByte(it.Item().Key(), '/') > -1 {
+				continue
+			}
+			e, err := decode(it.Item())
+			if err != nil {
+				return err
+			}
+			for _, k := range indexKeys(e) {
+				if err := wb.Set(k, nil); err != nil {
+					return fmt.Errorf("set: %w", err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("view: %w", err)
+	}
+	if err := wb.Set(indexed, nil); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	if err := wb.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
 }
 
-// Remove removes the key with the given slug.
-func (db *Database) Remove(_ context.Context, slug string) error {
-	return db.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte(slug))
+// decode decodes the entry stored in item.
+func decode(item *badger.Item) (e database.Entry, err error) {
+	b, err := item.ValueCopy(nil)
+	if err != nil {
+		return e, fmt.Errorf("value copy: %w", err)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&e); err != nil {
+		return e, fmt.Errorf("gob decode: %w", err)
+	}
+	return e, nil
+}
+
+// Create sets the key, slug with the gob encoded value of e, replacing any
+// existing entry.
+func (db *Database) Create(ctx context.Context, e database.Entry) error {
+	return db.CreateBatch(ctx, []database.Entry{e})
+}
+
+// CreateBatch creates entries in a single transaction, so the batch must fit
+// in one.
+func (db *Database) CreateBatch(_ context.Context, entries []database.Entry) error {
+	values := make([][]byte, len(entries))
+	for i, e := range entries {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+			return fmt.Errorf("gob encode: %w", err)
+		}
+		values[i] = buf.Bytes()
+	}
+	for {
+		err := db.db.Update(func(txn *badger.Txn) error {
+			for i, e := range entries {
+				if err := remove(txn, e.Slug); err != nil && !errors.Is(err, database.ErrNoResults) {
+					return err
+				}
+				for _, k := range indexKeys(e) {
+					if err := txn.Set(k, nil); err != nil {
+						return fmt.Errorf("set: %w", err)
+					}
+				}
+				if err := txn.Set([]byte(e.Slug), values[i]); err != nil {
+					return fmt.Errorf("set: %w", err)
+				}
+			}
+			return nil
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+}
+
+// Delete deletes the key with the given slug.
+func (db *Database) Delete(_ context.Context, slug string) error {
+	for {
+		err := db.db.Update(func(txn *badger.Txn) error { return remove(txn, slug) })
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+}
+
+// remove deletes the named entry and its index key.
+func remove(txn *badger.Txn, slug string) error {
+	item, err := txn.Get([]byte(slug))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return database.ErrNoResults
+		}
+		return fmt.Errorf("get: %w", err)
+	}
+	e, err := decode(item)
+	if err != nil {
+		return err
+	}
+	for _, k := range indexKeys(e) {
+		if err := txn.Delete(k); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+	}
+	return txn.Delete([]byte(slug))
+}
+
+// LookupBySum looks up the newest entry with the given sum using the sum
+// index.
+func (db *Database) LookupBySum(_ context.Context, sum string) (newest database.Entry, err error) {
+	prefix := sumKey(sum, "")
+	err = db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item, err := txn.Get(it.Item().Key()[len(prefix):])
+			if err != nil {
+				return fmt.Errorf("get: %w", err)
+			}
+			e, err := decode(item)
+			if err != nil {
+				return err
+			}
+			if newest.Slug == "" || database.CursorOf(e).Compare(database.CursorOf(newest)) > 0 {
+				newest = e
+			}
+		}
+		if newest.Slug == "" {
+			return database.ErrNoResults
+		}
+		return nil
 	})
+	return newest, err
+}
+
+// List lists entries matching opts by walking the timestamp index.
+func (db *Database) List(_ context.Context, opts database.ListOptions) (entries []database.Entry, next string, err error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	if err := db.db.View(func(txn *badger.Txn) error {
+		prefix := []byte(timestampsPrefix)
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchValues: false,
+			Reverse:        opts.Descending,
+			Prefix:         prefix,
+		})
+		defer it.Close()
+		switch {
+		case !c.IsZero():
+			it.Seek(timestampKey(c))
+		case opts.Descending:
+			it.Seek(append(prefix, 0xff))
+		default:
+			it.Rewind()
+		}
+		for ; it.ValidForPrefix(prefix) && len(entries) <= opts.Size(); it.Next() {
+			k := it.Item().Key()
+			item, err := txn.Get(k[len(prefix)+8:])
+			if err != nil {
+				return fmt.Errorf("get: %w", err)
+			}
+			e, err := decode(item)
+			if err != nil {
+				return err
+			}
+			if c.Follows(e, opts.Descending) && opts.Match(e, now) {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", fmt.Errorf("view: %w", err)
+	}
+	entries, next = opts.Page(entries)
+	return entries, next, nil
 }
 
 // Lookup looks up the named entry.
@@ -61,6 +273,187 @@ func (db *Database) Lookup(_ context.Context, slug string) (e database.Entry, er
 	return e, gob.NewDecoder(bytes.NewReader(b)).Decode(&e)
 }
 
+// LookupMany looks up the named entries in a single transaction.
+func (db *Database) LookupMany(_ context.Context, slugs []string) (map[string]database.Entry, error) {
+	m := make(map[string]database.Entry, len(slugs))
+	if err := db.db.View(func(txn *badger.Txn) error {
+		for _, slug := range slugs {
+			item, err := txn.Get([]byte(slug))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("get: %w", err)
+			}
+			e, err := decode(item)
+			if err != nil {
+				return err
+			}
+			m[slug] = e
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("view: %w", err)
+	}
+	return m, nil
+}
+
+// Touch sets the last access time of the named entry to t.
+func (db *Database) Touch(_ context.Context, slug string, t time.Time) error {
+	return db.update(slug, func(e *database.Entry) { e.LastAccess = &t })
+}
+
+// Extend sets the lifetime of the named entry to t if it has a lifetime which
+// ends before t.
+func (db *Database) Extend(_ context.Context, slug string, t time.Time) error {
+	return db.update(slug, func(e *database.Entry) {
+		if e.Lifetime != nil && e.Lifetime.Before(t) {
+			e.Lifetime = &t
+		}
+	})
+}
+
+// SetLifetime sets the lifetime of the named entry to t, or removes it if t
+// is nil.
+func (db *Database) SetLifetime(_ context.Context, slug string, t *time.Time) error {
+	return db.update(slug, func(e *database.Entry) { e.Lifetime = t })
+}
+
+// SoftDelete marks the named entry as deleted at t for the given reason.
+func (db *Database) SoftDelete(_ context.Context, slug string, t time.Time, reason string) error {
+	return db.update(slug, func(e *database.Entry) { e.Deleted, e.DeleteReason = &t, reason })
+}
+
+// Restore unmarks the named entry as deleted.
+func (db *Database) Restore(_ context.Context, slug string) error {
+	return db.update(slug, func(e *database.Entry) { e.Deleted, e.DeleteReason = nil, "" })
+}
+
+// Block marks the named entry as blocked at t for the given reason.
+func (db *Database) Block(_ context.Context, slug string, t time.Time, reason string) error {
+	return db.update(slug, func(e *database.Entry) { e.Blocked, e.BlockReason = &t, reason })
+}
+
+// Unblock unmarks the named entry as blocked.
+func (db *Database) Unblock(_ context.Context, slug string) error {
+	return db.update(slug, func(e *database.Entry) { e.Blocked, e.BlockReason = nil, "" })
+}
+
+// Rename sets the name of the named entry.
+func (db *Database) Rename(_ context.Context, slug, name string) error {
+	return db.update(slug, func(e *database.Entry) { e.Name = name })
+}
+
+// update applies f to the named entry, retrying on conflicts.
+func (db *Database) update(slug string, f func(e *database.Entry)) error {
+	for {
+		err := db.db.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte(slug))
+			if err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					return database.ErrNoResults
+				}
+				return fmt.Errorf("get: %w", err)
+			}
+			e, err := decode(item)
+			if err != nil {
+				return err
+			}
+			f(&e)
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+				return fmt.Errorf("gob encode: %w", err)
+			}
+			return txn.Set([]byte(slug), buf.Bytes())
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+}
+
+// downloadsKey returns the key of the download count for the named entry
+// and day. Slugs never contain a "/", so the keys can't collide with
+// entries.
+func downloadsKey(slug string, day time.Time) []byte {
+	return []byte("downloads/" + slug + "/" + day.Format("2006-01-02"))
+}
+
+// AddDownloads adds n to the download count of the named entry for the day
+// containing t.
+func (db *Database) AddDownloads(_ context.Context, slug string, t time.Time, n int64) error {
+	k := downloadsKey(slug, database.Day(t))
+	for {
+		err := db.db.Update(func(txn *badger.Txn) error {
+			var c int64
+			switch v, err := txn.Get(k); {
+			case errors.Is(err, badger.ErrKeyNotFound):
+			case err != nil:
+				return fmt.Errorf("get: %w", err)
+			default:
+				if c, err = downloadsValue(v); err != nil {
+					return err
+				}
+			}
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], uint64(c+n))
+			return txn.Set(k, b[:])
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+}
+
+// Downloads returns the download counts of the named entry for each day
+// since t, oldest first.
+func (db *Database) Downloads(_ context.Context, slug string, t time.Time) (d []database.Downloads, err error) {
+	prefix := []byte("downloads/" + slug + "/")
+	return d, db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Seek(downloadsKey(slug, database.Day(t))); it.ValidForPrefix(prefix); it.Next() {
+			day, err := time.Parse("2006-01-02", string(it.Item().Key()[len(prefix):]))
+			if err != nil {
+				return fmt.Errorf("parse day: %w", err)
+			}
+			c, err := downloadsValue(it.Item())
+			if err != nil {
+				return err
+			}
+			d = append(d, database.Downloads{Day: day, Count: c})
+		}
+		return nil
+	})
+}
+
+// RemoveDownloads removes the download counts of the named entry.
+func (db *Database) RemoveDownloads(_ context.Context, slug string) error {
+	prefix := []byte("downloads/" + slug + "/")
+	return db.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return fmt.Errorf("delete: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// downloadsValue decodes the download count stored in item.
+func downloadsValue(item *badger.Item) (int64, error) {
+	b, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, fmt.Errorf("value copy: %w", err)
+	}
+	if len(b) != 8 {
+		return 0, fmt.Errorf("invalid download count length: %d", len(b))
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
 // Ping pings the database.
 func (db *Database) Ping(context.Context) error { return nil }
 
@@ -0,0 +1,21 @@
+package badger_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/badger"
+	"github.com/uhthomas/kipp/database/databasetest"
+)
+
+func TestDatabase(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := badger.Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close(context.Background()) })
+		return db
+	})
+}
@@ -0,0 +1,421 @@
+// Package bolt implements a kipp entry database in a single bbolt file, which
+// is simple to back up and well suited to small instances.
+//
+// Bolt allows any number of concurrent readers but only one writer at a time,
+// so writes are serialised. Every write is synced to disk before it returns.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// entriesBucket maps slugs to gob encoded entries.
+	entriesBucket = []byte("entries")
+	// sumsBucket indexes entries by sum, with keys of sum, a zero byte and
+	// slug.
+	sumsBucket = []byte("sums")
+	// expiriesBucket indexes entries by lifetime, with keys of the big
+	// endian unix nanoseconds of the lifetime followed by the slug.
+	expiriesBucket = []byte("expiries")
+	// timestampsBucket indexes entries by timestamp, with keys of the big
+	// endian unix nanoseconds of the timestamp followed by the slug.
+	timestampsBucket = []byte("timestamps")
+	// downloadsBucket maps slug, "/" and day to big endian download counts.
+	downloadsBucket = []byte("downloads")
+)
+
+// Database is a wrapper around a bolt database, providing high level
+// functions to act a kipp entry database.
+type Database struct{ db *bolt.DB }
+
+// Open opens or creates the named bolt database.
+func Open(name string) (*Database, error) {
+	db, err := bolt.Open(name, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(timestampsBucket) == nil
+		for _, b := range [][]byte{entriesBucket, sumsBucket, expiriesBucket, timestampsBucket, downloadsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return fmt.Errorf("create bucket %s: %w", b, err)
+			}
+		}
+		if !index {
+			return nil
+		}
+		// Index the timestamps of entries created before the index
+		// existed.
+		return tx.Bucket(entriesBucket).ForEach(func(k, _ []byte) error {
+			e, err := get(tx, string(k))
+			if err != nil {
+				return err
+			}
+			return tx.Bucket(timestampsBucket).Put(timeKey(e.Timestamp, e.Slug), nil)
+		})
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("update: %w", err)
+	}
+	return &Database{db: db}, nil
+}
+
+func sumKey(sum, slug string) []byte { return []byte(sum + "\x00" + slug) }
+
+func timeKey(t time.Time, slug string) []byte {
+	b := make([]byte, 8, 8+len(slug))
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return append(b, slug...)
+}
+
+// Create puts e, replacing any entry with the same slug.
+func (db *Database) Create(_ context.Context, e database.Entry) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		if err := remove(tx, e.Slug); err != nil {
+			return err
+		}
+		return put(tx, e)
+	})
+}
+
+// CreateBatch creates entries in a single transaction.
+func (db *Database) CreateBatch(_ context.Context, entries []database.Entry) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		for _, e := range entries {
+			if err := remove(tx, e.Slug); err != nil {
+				return err
+			}
+			if err := put(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete deletes the entry with the given slug.
+func (db *Database) Delete(_ context.Context, slug string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		if _, err := get(tx, slug); err != nil {
+			return err
+		}
+		return remove(tx, slug)
+	})
+}
+
+// Lookup looks up the named entry.
+func (db *Database) Lookup(_ context.Context, slug string) (e database.Entry, err error) {
+	return e, db.db.View(func(tx *bolt.Tx) error {
+		e, err = get(tx, slug)
+		return err
+	})
+}
+
+// LookupMany looks up the named entries in a single transaction.
+func (db *Database) LookupMany(_ context.Context, slugs []string) (map[string]database.Entry, error) {
+	m := make(map[string]database.Entry, len(slugs))
+	return m, db.db.View(func(tx *bolt.Tx) error {
+		for _, slug := range slugs {
+			e, err := get(tx, slug)
+			if errors.Is(err, database.ErrNoResults) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			m[slug] = e
+		}
+		return nil
+	})
+}
+
+// LookupBySum looks up the newest entry with the given sum using the sum
+// index.
+func (db *Database) LookupBySum(_ context.Context, sum string) (newest database.Entry, err error) {
+	prefix := sumKey(sum, "")
+	err = db.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(sumsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			e, err := get(tx, string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			if newest.Slug == "" || database.CursorOf(e).Compare(database.CursorOf(newest)) > 0 {
+				newest = e
+			}
+		}
+		if newest.Slug == "" {
+			return database.ErrNoResults
+		}
+		return nil
+	})
+	return newest, err
+}
+
+// List lists entries matching opts by walking the timestamp index.
+func (db *Database) List(_ context.Context, opts database.ListOptions) (entries []database.Entry, next string, err error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	if err := db.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(timestampsBucket).Cursor()
+		var k []byte
+		switch {
+		case !c.IsZero():
+			// Seek finds the first key at or after the cursor, which
+			// is where descending listings continue from too.
+			if k, _ = cur.Seek(timeKey(c.Timestamp, c.Slug)); opts.Descending {
+				if k == nil {
+					k, _ = cur.Last()
+				} else {
+					k, _ = cur.Prev()
+				}
+			}
+		case opts.Descending:
+			k, _ = cur.Last()
+		default:
+			k, _ = cur.First()
+		}
+		for ; k != nil && len(entries) <= opts.Size(); k = step(cur, opts.Descending) {
+			e, err := get(tx, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			if c.Follows(e, opts.Descending) && opts.Match(e, now) {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+	entries, next = opts.Page(entries)
+	return entries, next, nil
+}
+
+// step moves c forwards, or backwards if reverse is true, returning the key.
+func step(c *bolt.Cursor, reverse bool) []byte {
+	if reverse {
+		k, _ := c.Prev()
+		return k
+	}
+	k, _ := c.Next()
+	return k
+}
+
+// Touch sets the last access time of the named entry to t.
+func (db *Database) Touch(_ context.Context, slug string, t time.Time) error {
+	return db.update(slug, func(e *database.Entry) { e.LastAccess = &t })
+}
+
+// Extend sets the lifetime of the named entry to t if it has a lifetime which
+// ends before t.
+func (db *Database) Extend(_ context.Context, slug string, t time.Time) error {
+	return db.update(slug, func(e *database.Entry) {
+		if e.Lifetime != nil && e.Lifetime.Before(t) {
+			e.Lifetime = &t
+		}
+	})
+}
+
+// SetLifetime sets the lifetime of the named entry to t, or removes it if t
+// is nil.
+func (db *Database) SetLifetime(_ context.Context, slug string, t *time.Time) error {
+	return db.update(slug, func(e *database.Entry) { e.Lifetime = t })
+}
+
+// SoftDelete marks the named entry as deleted at t for the given reason.
+func (db *Database) SoftDelete(_ context.Context, slug string, t time.Time, reason string) error {
+	return db.update(slug, func(e *database.Entry) { e.Deleted, e.DeleteReason = &t, reason })
+}
+
+// Restore unmarks the named entry as deleted.
+func (db *Database) Restore(_ context.Context, slug string) error {
+	return db.update(slug, func(e *database.Entry) { e.Deleted, e.DeleteReason = nil, "" })
+}
+
+// Block marks the named entry as blocked at t for the given reason.
+func (db *Database) Block(_ context.Context, slug string, t time.Time, reason string) error {
+	return db.update(slug, func(e *database.Entry) { e.Blocked, e.BlockReason = &t, reason })
+}
+
+// Unblock unmarks the named entry as blocked.
+func (db *Database) Unblock(_ context.Context, slug string) error {
+	return db.update(slug, func(e *database.Entry) { e.Blocked, e.BlockReason = nil, "" })
+}
+
+// Rename sets the name of the named entry.
+func (db *Database) Rename(_ context.Context, slug, name string) error {
+	return db.update(slug, func(e *database.Entry) { e.Name = name })
+}
+
+// update applies f to the named entry, keeping the indexes up to date.
+func (db *Database) update(slug string, f func(e *database.Entry)) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		e, err := get(tx, slug)
+		if err != nil {
+			return err
+		}
+		if err := remove(tx, slug); err != nil {
+			return err
+		}
+		f(&e)
+		return put(tx, e)
+	})
+}
+
+// get reads the named entry.
+func get(tx *bolt.Tx, slug string) (e database.Entry, err error) {
+	b := tx.Bucket(entriesBucket).Get([]byte(slug))
+	if b == nil {
+		return database.Entry{}, database.ErrNoResults
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&e); err != nil {
+		return database.Entry{}, fmt.Errorf("gob decode: %w", err)
+	}
+	return e, nil
+}
+
+// put writes e and its index keys.
+func put(tx *bolt.Tx, e database.Entry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return fmt.Errorf("gob encode: %w", err)
+	}
+	if err := tx.Bucket(entriesBucket).Put([]byte(e.Slug), buf.Bytes()); err != nil {
+		return fmt.Errorf("put entry: %w", err)
+	}
+	if err := tx.Bucket(sumsBucket).Put(sumKey(e.Sum, e.Slug), nil); err != nil {
+		return fmt.Errorf("put sum: %w", err)
+	}
+	if err := tx.Bucket(timestampsBucket).Put(timeKey(e.Timestamp, e.Slug), nil); err != nil {
+		return fmt.Errorf("put timestamp: %w", err)
+	}
+	if e.Lifetime != nil {
+		if err := tx.Bucket(expiriesBucket).Put(timeKey(*e.Lifetime, e.Slug), nil); err != nil {
+			return fmt.Errorf("put expiry: %w", err)
+		}
+	}
+	return nil
+}
+
+// remove deletes the named entry and its index keys, if it exists.
+func remove(tx *bolt.Tx, slug string) error {
+	e, err := get(tx, slug)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResults) {
+			return nil
+		}
+		return err
+	}
+	if err := tx.Bucket(sumsBucket).Delete(sumKey(e.Sum, slug)); err != nil {
+		return fmt.Errorf("delete sum: %w", err)
+	}
+	if err := tx.Bucket(timestampsBucket).Delete(timeKey(e.Timestamp, slug)); err != nil {
+		return fmt.Errorf("delete timestamp: %w", err)
+	}
+	if e.Lifetime != nil {
+		if err := tx.Bucket(expiriesBucket).Delete(timeKey(*e.Lifetime, slug)); err != nil {
+			return fmt.Errorf("delete expiry: %w", err)
+		}
+	}
+	if err := tx.Bucket(entriesBucket).Delete([]byte(slug)); err != nil {
+		return fmt.Errorf("delete entry: %w", err)
+	}
+	return nil
+}
+
+// downloadsKey returns the key of the download count for the named entry
+// and day.
+func downloadsKey(slug string, day time.Time) []byte {
+	return []byte(slug + "/" + day.Format("2006-01-02"))
+}
+
+// AddDownloads adds n to the download count of the named entry for the day
+// containing t.
+func (db *Database) AddDownloads(_ context.Context, slug string, t time.Time, n int64) error {
+	k := downloadsKey(slug, database.Day(t))
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(downloadsBucket)
+		var c int64
+		if v := b.Get(k); v != nil {
+			var err error
+			if c, err = downloadsValue(v); err != nil {
+				return err
+			}
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(c+n))
+		return b.Put(k, v)
+	})
+}
+
+// Downloads returns the download counts of the named entry for each day
+// since t, oldest first.
+func (db *Database) Downloads(_ context.Context, slug string, t time.Time) (d []database.Downloads, err error) {
+	prefix := []byte(slug + "/")
+	return d, db.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(downloadsBucket).Cursor()
+		for k, v := c.Seek(downloadsKey(slug, database.Day(t))); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			day, err := time.Parse("2006-01-02", string(k[len(prefix):]))
+			if err != nil {
+				return fmt.Errorf("parse day: %w", err)
+			}
+			n, err := downloadsValue(v)
+			if err != nil {
+				return err
+			}
+			d = append(d, database.Downloads{Day: day, Count: n})
+		}
+		return nil
+	})
+}
+
+// RemoveDownloads removes the download counts of the named entry.
+func (db *Database) RemoveDownloads(_ context.Context, slug string) error {
+	prefix := []byte(slug + "/")
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(downloadsBucket)
+		// Deleting with the cursor would skip keys, so collect them first.
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return fmt.Errorf("delete: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// downloadsValue decodes the download count in b.
+func downloadsValue(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("invalid download count length: %d", len(b))
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// Ping verifies the database file is writable by committing an empty
+// transaction.
+func (db *Database) Ping(context.Context) error {
+	return db.db.Update(func(*bolt.Tx) error { return nil })
+}
+
+// Close closes the database.
+func (db *Database) Close(context.Context) error { return db.db.Close() }
@@ -0,0 +1,22 @@
+package bolt_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/bolt"
+	"github.com/uhthomas/kipp/database/databasetest"
+)
+
+func TestDatabase(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := bolt.Open(filepath.Join(t.TempDir(), "kipp.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close(context.Background()) })
+		return db
+	})
+}
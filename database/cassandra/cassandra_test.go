@@ -0,0 +1,150 @@
+package cassandra_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/cassandra"
+	"github.com/uhthomas/kipp/database/databasetest"
+)
+
+// hosts returns the comma separated hosts in KIPP_TEST_CASSANDRA, such as a
+// single node Scylla container, skipping the test if it isn't set.
+func hosts(t *testing.T) string {
+	hosts := os.Getenv("KIPP_TEST_CASSANDRA")
+	if hosts == "" {
+		t.Skip("KIPP_TEST_CASSANDRA not set")
+	}
+	return hosts
+}
+
+// open creates a keyspace which is dropped when the test finishes, returning
+// the database and a session for inspecting the keyspace.
+func open(t *testing.T, hosts string) (db *cassandra.Database, session *gocql.Session, keyspace string) {
+	ctx := context.Background()
+	keyspace = fmt.Sprintf("kipp_test_%d", time.Now().UnixNano())
+	session, err := gocql.NewCluster(strings.Split(hosts, ",")...).CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.Close)
+	if err := session.Query(`CREATE KEYSPACE ` + keyspace +
+		` WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = cassandra.Open(ctx, "cassandra://"+hosts+"/"+keyspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(ctx); err != nil {
+			t.Error(err)
+		}
+		if err := session.Query(`DROP KEYSPACE ` + keyspace).Exec(); err != nil {
+			t.Error(err)
+		}
+	})
+	return db, session, keyspace
+}
+
+// TestDatabase creates and drops a keyspace for each test.
+func TestDatabase(t *testing.T) {
+	h := hosts(t)
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, _, _ := open(t, h)
+		return db
+	})
+}
+
+// TestExpires checks only entries with a lifetime are given a TTL, which
+// follows the lifetime as it's extended.
+func TestExpires(t *testing.T) {
+	ctx := context.Background()
+
+	db, session, keyspace := open(t, hosts(t))
+
+	e := databasetest.NewEntry("expires")
+	forever := databasetest.NewEntry("forever")
+	forever.Lifetime = nil
+	for _, e := range []database.Entry{e, forever} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	later := e.Lifetime.Add(time.Hour)
+	for _, slug := range []string{e.Slug, forever.Slug} {
+		if err := db.Extend(ctx, slug, later); err != nil {
+			t.Fatalf("extend: %v", err)
+		}
+	}
+
+	for slug, want := range map[string]time.Duration{
+		e.Slug:       time.Until(later),
+		forever.Slug: 0,
+	} {
+		var got *int
+		if err := session.Query(`SELECT TTL(name) FROM `+keyspace+`.entry WHERE slug = ?`, slug).
+			Scan(&got); err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if want == 0 {
+			if got != nil {
+				t.Fatalf("unexpected TTL for %s; got %d, want none", slug, *got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Fatalf("unexpected TTL for %s; got none, want about %v", slug, want)
+		}
+		if d := time.Duration(*got) * time.Second; (want - d).Abs() > time.Minute {
+			t.Fatalf("unexpected TTL for %s; got %v, want about %v", slug, d, want)
+		}
+	}
+	if !db.Expires() {
+		t.Fatal("Cassandra should expire entries")
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	c, err := cassandra.ParseURL("cassandra://user:pass@a,b:9043/files?consistency=quorum&serial-consistency=serial&timeout=3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, _ := c.Authenticator.(gocql.PasswordAuthenticator)
+	if got, want := strings.Join(c.Hosts, " "), "a b:9043"; got != want {
+		t.Fatalf("unexpected hosts; got %q, want %q", got, want)
+	}
+	if c.Keyspace != "files" ||
+		c.Consistency != gocql.Quorum ||
+		c.SerialConsistency != gocql.Serial ||
+		c.Timeout != 3*time.Second ||
+		auth.Username != "user" || auth.Password != "pass" {
+		t.Fatalf("unexpected config; got %+v", c)
+	}
+
+	c, err = cassandra.ParseURL("scylla://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Keyspace != "kipp" || c.Consistency != gocql.LocalQuorum || c.SerialConsistency != gocql.LocalSerial {
+		t.Fatalf("unexpected defaults; got %+v", c)
+	}
+
+	for _, s := range []string{
+		"cassandra:///kipp",
+		"cassandra://localhost?consistency=most",
+		"cassandra://localhost?serial-consistency=quorum",
+		"cassandra://localhost?timeout=soon",
+		"cassandra://localhost?unknown=1",
+	} {
+		if _, err := cassandra.ParseURL(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
@@ -3,27 +3,276 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
+	"path"
+	"reflect"
 	"time"
 )
 
 // ErrNoResults is returned when there are no results for the given query.
 var ErrNoResults = errors.New("no results")
 
+// ErrConflict is returned by Create when an entry with the same slug already
+// exists. Databases which can't cheaply detect this replace the entry instead.
+var ErrConflict = errors.New("conflict")
+
+// ErrUnsupported is returned by databases which wrap others when the wrapped
+// database doesn't implement the optional interface a method belongs to.
+var ErrUnsupported = errors.New("unsupported")
+
+// A BatchError is returned by CreateBatch when some entries of a batch were
+// created and others weren't.
+type BatchError struct {
+	// Errs holds an error for each entry of the batch, in order, which is
+	// nil for entries which were created.
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	var n int
+	var first error
+	for _, err := range e.Errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+	}
+	return fmt.Sprintf("%d of %d entries failed: %v", n, len(e.Errs), first)
+}
+
+// Is reports whether the error of any entry is target.
+func (e *BatchError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if err != nil && errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // A Database stores and manages data.
 type Database interface {
 	// Create persists the entry to the underlying database, returning
 	// any errors if present.
 	Create(ctx context.Context, e Entry) error
-	// Remove removes the named entry.
-	Remove(ctx context.Context, slug string) error
+	// CreateBatch creates entries as Create would. Databases with
+	// transactions create all of them or none, returning the error of the
+	// entry which failed. Others create as many as they can, returning a
+	// *BatchError describing the ones they couldn't.
+	CreateBatch(ctx context.Context, entries []Entry) error
+	// Delete deletes the named entry, returning ErrNoResults if it doesn't
+	// exist.
+	Delete(ctx context.Context, slug string) error
 	// Lookup looks up the named entry.
 	Lookup(ctx context.Context, slug string) (Entry, error)
+	// LookupMany looks up the named entries at once, in any order.
+	// Entries which don't exist are absent from the map, rather than
+	// failing the lookup. Like Lookup, it may return expired entries.
+	LookupMany(ctx context.Context, slugs []string) (map[string]Entry, error)
+	// LookupBySum looks up the newest entry with the given sum, returning
+	// ErrNoResults if there are none. Entries created at the same time are
+	// ordered by slug, so the greatest slug wins. Like Lookup, it may
+	// return expired entries.
+	LookupBySum(ctx context.Context, sum string) (Entry, error)
+	// List lists entries matching opts, returning a cursor for the next
+	// page which is empty when there are no more entries.
+	List(ctx context.Context, opts ListOptions) (entries []Entry, next string, err error)
 	// Ping pings the database.
 	Ping(ctx context.Context) error
 	// Close closes the database.
 	Close(ctx context.Context) error
 }
 
+// Name returns the name of the package implementing db, such as "badger",
+// looking through databases which wrap others and have an Unwrap method.
+func Name(db Database) string {
+	for {
+		u, ok := db.(interface{ Unwrap() Database })
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	t := reflect.TypeOf(db)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return path.Base(t.PkgPath())
+}
+
+// A Toucher records when entries were last accessed.
+type Toucher interface {
+	// Touch sets the last access time of the named entry to t.
+	Touch(ctx context.Context, slug string, t time.Time) error
+}
+
+// An Extender extends the lifetime of entries.
+type Extender interface {
+	// Extend sets the lifetime of the named entry to t if it has a
+	// lifetime which ends before t. Entries without a lifetime are left
+	// as they are. Concurrent calls must never shorten a lifetime.
+	Extend(ctx context.Context, slug string, t time.Time) error
+}
+
+// A LifetimeSetter sets the lifetimes of entries to whatever they're given,
+// unlike an Extender, so they can be shortened or removed.
+type LifetimeSetter interface {
+	// SetLifetime sets the lifetime of the named entry to t, or removes it
+	// if t is nil, returning ErrNoResults if it doesn't exist.
+	SetLifetime(ctx context.Context, slug string, t *time.Time) error
+}
+
+// A SoftDeleter marks entries as deleted without removing them, so they can
+// be reviewed and restored.
+type SoftDeleter interface {
+	// SoftDelete marks the named entry as deleted at t for the given
+	// reason, returning ErrNoResults if it doesn't exist.
+	SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error
+	// Restore unmarks the named entry, returning ErrNoResults if it
+	// doesn't exist.
+	Restore(ctx context.Context, slug string) error
+}
+
+// A Blocker blocks entries, such as for legal takedowns, so they're answered
+// with why they can't be downloaded rather than served or deleted.
+type Blocker interface {
+	// Block marks the named entry as blocked at t for the given reason,
+	// returning ErrNoResults if it doesn't exist.
+	Block(ctx context.Context, slug string, t time.Time, reason string) error
+	// Unblock unmarks the named entry, returning ErrNoResults if it
+	// doesn't exist.
+	Unblock(ctx context.Context, slug string) error
+}
+
+// A Renamer renames entries.
+type Renamer interface {
+	// Rename sets the name of the named entry, returning ErrNoResults if
+	// it doesn't exist.
+	Rename(ctx context.Context, slug, name string) error
+}
+
+// An Expirer may remove entries by itself once their lifetime has ended,
+// though possibly long after. Entries without a lifetime are never removed.
+// The files of removed entries can't be found by listing expired entries, so
+// must be found by comparing the file system to the database.
+type Expirer interface {
+	// Expires reports whether the database removes expired entries.
+	Expires() bool
+}
+
+// A StatsReporter aggregates statistics about entries natively, more cheaply
+// than listing them all. See package stats for databases which don't.
+type StatsReporter interface {
+	// Stats returns statistics about every entry, with lifetimes measured
+	// from now.
+	Stats(ctx context.Context, now time.Time) (Stats, error)
+}
+
+// Stats are statistics about the entries of a database, including expired and
+// soft deleted entries which haven't been removed yet.
+type Stats struct {
+	Entries int64
+	// Bytes is the total size of the entries' files, and GzipBytes the
+	// total size of their gzip variants.
+	Bytes, GzipBytes int64
+	// ExpiringDay and ExpiringWeek are the total size of files whose
+	// lifetimes end within a day and a week respectively.
+	ExpiringDay, ExpiringWeek int64
+	// Days counts the entries uploaded each day, oldest first. Days
+	// without uploads are omitted.
+	Days []DayStats
+}
+
+// DayStats are statistics about the entries uploaded in a UTC day.
+type DayStats struct {
+	Day     time.Time
+	Entries int64
+	Bytes   int64
+}
+
+// A DownloadCounter keeps per-day download counts for entries. Only counts
+// are stored, nothing about who downloaded an entry.
+type DownloadCounter interface {
+	// AddDownloads adds n to the download count of the named entry for
+	// the day containing t.
+	AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error
+	// Downloads returns the download counts of the named entry for each
+	// day since t, oldest first. Days without downloads are omitted.
+	Downloads(ctx context.Context, slug string, t time.Time) ([]Downloads, error)
+	// RemoveDownloads removes the download counts of the named entry.
+	RemoveDownloads(ctx context.Context, slug string) error
+}
+
+// Downloads is the number of times an entry was downloaded in a day.
+type Downloads struct {
+	Day   time.Time
+	Count int64
+}
+
+// Day returns the UTC day containing t.
+func Day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// A Denylister keeps a denylist of the sums of files which mustn't be
+// uploaded, such as those of known abusive content. Denials are looked up by
+// sum for every upload, so they must be indexed by it.
+type Denylister interface {
+	// Deny adds d to the denylist, replacing any denial of the same sum.
+	Deny(ctx context.Context, d Denial) error
+	// Denied returns the denial of sum, or ErrNoResults if it isn't
+	// denied.
+	Denied(ctx context.Context, sum string) (Denial, error)
+	// Allow removes the denial of sum, returning ErrNoResults if it isn't
+	// denied.
+	Allow(ctx context.Context, sum string) error
+	// Denials returns every denial, oldest first.
+	Denials(ctx context.Context) ([]Denial, error)
+}
+
+// A Denial denies uploads of files with a sum, for a reason.
+type Denial struct {
+	Sum    string
+	Reason string
+	Time   time.Time
+}
+
+// A Reporter stores abuse reports of entries, which are triaged by slug.
+type Reporter interface {
+	// Report stores r.
+	Report(ctx context.Context, r Report) error
+	// Reports returns the reports with status, or every report if it's
+	// empty, oldest first.
+	Reports(ctx context.Context, status ReportStatus) ([]Report, error)
+	// ResolveReports resolves the open reports of the named entry,
+	// returning ErrNoResults if there are none.
+	ResolveReports(ctx context.Context, slug string) error
+}
+
+// ReportStatus is whether a report has been dealt with.
+type ReportStatus string
+
+// The statuses of reports.
+const (
+	ReportOpen     ReportStatus = "open"
+	ReportResolved ReportStatus = "resolved"
+)
+
+// A Report reports an entry for abuse, such as for malware or copyright
+// infringement.
+type Report struct {
+	Slug     string
+	Category string
+	// Description and Contact, the address of the reporter, are optional.
+	Description string
+	Contact     string
+	Status      ReportStatus
+	Time        time.Time
+}
+
 // An Entry stores relevant metadata for files.
 type Entry struct {
 	Slug      string
@@ -32,4 +281,27 @@ type Entry struct {
 	Size      int64
 	Lifetime  *time.Time
 	Timestamp time.Time
+	// LastAccess is when the file was last downloaded, or nil if it
+	// never was or access isn't tracked.
+	LastAccess *time.Time
+	// GzipSize is the size of the gzip variant of the file, or zero if
+	// there isn't one.
+	GzipSize int64
+	// Deleted is when the entry was soft deleted, or nil if it wasn't.
+	// Soft deleted entries are not served, but keep their files until
+	// they're purged.
+	Deleted      *time.Time
+	DeleteReason string
+	// Blocked is when the entry was blocked, such as by a legal takedown,
+	// or nil if it wasn't. Blocked entries aren't served, but answer with
+	// their BlockReason, a code such as "dmca", rather than as if they
+	// don't exist.
+	Blocked     *time.Time
+	BlockReason string
+	// Tags label the entry. They're sorted without duplicates, as
+	// returned by NormalizeTags, and nil if there are none.
+	Tags []string
+	// Owner is the ID of the user who uploaded the entry, or empty if it
+	// was uploaded anonymously.
+	Owner string
 }
@@ -0,0 +1,1321 @@
+// Package databasetest implements conformance tests for database.Database
+// implementations.
+package databasetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/search"
+)
+
+// Run runs the conformance tests against databases returned by open, which is
+// called once per subtest and must return a new, empty database. Tests for
+// the optional interfaces in package database are run when the database
+// implements them.
+func Run(t *testing.T, open func(t *testing.T) database.Database) {
+	t.Run("CreateLookup", func(t *testing.T) { testCreateLookup(t, open(t)) })
+	t.Run("CreateBatch", func(t *testing.T) { testCreateBatch(t, open(t)) })
+	t.Run("LookupMissing", func(t *testing.T) { testLookupMissing(t, open(t)) })
+	t.Run("LookupMany", func(t *testing.T) { testLookupMany(t, open(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, open(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, open(t)) })
+	t.Run("Ping", func(t *testing.T) { testPing(t, open(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, open(t)) })
+	t.Run("ListFilters", func(t *testing.T) { testListFilters(t, open(t)) })
+	t.Run("ListConcurrent", func(t *testing.T) { testListConcurrent(t, open(t)) })
+	t.Run("LookupBySum", func(t *testing.T) { testLookupBySum(t, open(t)) })
+	t.Run("Toucher", func(t *testing.T) { testToucher(t, open(t)) })
+	t.Run("Extender", func(t *testing.T) { testExtender(t, open(t)) })
+	t.Run("LifetimeSetter", func(t *testing.T) { testLifetimeSetter(t, open(t)) })
+	t.Run("DownloadCounter", func(t *testing.T) { testDownloadCounter(t, open(t)) })
+	t.Run("SoftDeleter", func(t *testing.T) { testSoftDeleter(t, open(t)) })
+	t.Run("Blocker", func(t *testing.T) { testBlocker(t, open(t)) })
+	t.Run("Renamer", func(t *testing.T) { testRenamer(t, open(t)) })
+	t.Run("SearchByName", func(t *testing.T) { testSearchByName(t, open(t)) })
+	t.Run("StatsReporter", func(t *testing.T) { testStatsReporter(t, open(t)) })
+	t.Run("Denylister", func(t *testing.T) { testDenylister(t, open(t)) })
+	t.Run("Reporter", func(t *testing.T) { testReporter(t, open(t)) })
+	t.Run("Auditor", func(t *testing.T) { testAuditor(t, open(t)) })
+	t.Run("UserStore", func(t *testing.T) { testUserStore(t, open(t)) })
+}
+
+// now returns the current time at a precision all backends can store.
+func now() time.Time { return time.Now().UTC().Truncate(time.Millisecond) }
+
+// NewEntry returns an entry with the given slug and every field populated.
+func NewEntry(slug string) database.Entry {
+	t := now()
+	l, a := t.Add(time.Hour), t.Add(-time.Minute)
+	return database.Entry{
+		Slug:       slug,
+		Name:       slug + ".txt",
+		Sum:        "sum-" + slug,
+		Size:       1234,
+		Lifetime:   &l,
+		Timestamp:  t,
+		LastAccess: &a,
+		GzipSize:   123,
+		Tags:       []string{"ci-artifact", "screenshot"},
+		Owner:      "owner",
+	}
+}
+
+// Equal reports whether a and b are equal, comparing times by instant rather
+// than representation.
+func Equal(a, b database.Entry) bool {
+	timesEqual := func(a, b *time.Time) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Equal(*b)
+	}
+	return a.Slug == b.Slug &&
+		a.Name == b.Name &&
+		a.Sum == b.Sum &&
+		a.Size == b.Size &&
+		timesEqual(a.Lifetime, b.Lifetime) &&
+		a.Timestamp.Equal(b.Timestamp) &&
+		timesEqual(a.LastAccess, b.LastAccess) &&
+		a.GzipSize == b.GzipSize &&
+		timesEqual(a.Deleted, b.Deleted) &&
+		a.DeleteReason == b.DeleteReason &&
+		timesEqual(a.Blocked, b.Blocked) &&
+		a.BlockReason == b.BlockReason &&
+		strings.Join(a.Tags, ",") == strings.Join(b.Tags, ",") &&
+		a.Owner == b.Owner
+}
+
+func testCreateLookup(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	e := NewEntry("create")
+	noLifetime := NewEntry("nolifetime")
+	noLifetime.Lifetime, noLifetime.LastAccess = nil, nil
+
+	for _, e := range []database.Entry{e, noLifetime} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		got, err := db.Lookup(ctx, e.Slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if !Equal(got, e) {
+			t.Fatalf("entries are not equal; got %#v, want %#v", got, e)
+		}
+	}
+
+	// Creating an entry with the same slug either replaces it, or fails
+	// with database.ErrConflict and leaves it be.
+	dup := NewEntry(e.Slug)
+	dup.Name = "duplicate.txt"
+	want := dup
+	if err := db.Create(ctx, dup); errors.Is(err, database.ErrConflict) {
+		want = e
+	} else if err != nil {
+		t.Fatalf("create duplicate: %v", err)
+	}
+	got, err := db.Lookup(ctx, e.Slug)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if !Equal(got, want) {
+		t.Fatalf("entries are not equal; got %#v, want %#v", got, want)
+	}
+}
+
+func testCreateBatch(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	if err := db.CreateBatch(ctx, nil); err != nil {
+		t.Fatalf("create empty batch: %v", err)
+	}
+
+	existing := NewEntry("existing")
+	if err := db.Create(ctx, existing); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	dup := NewEntry(existing.Slug)
+	dup.Name = "duplicate.txt"
+	batch := []database.Entry{NewEntry("batch1"), dup, NewEntry("batch2")}
+
+	// Databases either replace the conflicting entry, fail the whole batch,
+	// or create the rest and report the conflict.
+	want := map[string]*database.Entry{
+		batch[0].Slug: &batch[0],
+		existing.Slug: &dup,
+		batch[2].Slug: &batch[2],
+	}
+	var be *database.BatchError
+	switch err := db.CreateBatch(ctx, batch); {
+	case err == nil:
+	case errors.As(err, &be):
+		if len(be.Errs) != len(batch) {
+			t.Fatalf("unexpected number of errors; got %d, want %d", len(be.Errs), len(batch))
+		}
+		for i, err := range be.Errs {
+			if i == 1 && !errors.Is(err, database.ErrConflict) {
+				t.Fatalf("unexpected error for %s; got %v, want %v", batch[i].Slug, err, database.ErrConflict)
+			} else if i != 1 && err != nil {
+				t.Fatalf("unexpected error for %s: %v", batch[i].Slug, err)
+			}
+		}
+		want[existing.Slug] = &existing
+	case errors.Is(err, database.ErrConflict):
+		want = map[string]*database.Entry{
+			batch[0].Slug: nil,
+			existing.Slug: &existing,
+			batch[2].Slug: nil,
+		}
+	default:
+		t.Fatalf("create batch: %v", err)
+	}
+
+	for slug, want := range want {
+		got, err := db.Lookup(ctx, slug)
+		if want == nil {
+			if !errors.Is(err, database.ErrNoResults) {
+				t.Fatalf("unexpected error for %s; got %v, want %v", slug, err, database.ErrNoResults)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("lookup %s: %v", slug, err)
+		}
+		if !Equal(got, *want) {
+			t.Fatalf("entries are not equal; got %#v, want %#v", got, *want)
+		}
+	}
+}
+
+func testLookupMissing(t *testing.T, db database.Database) {
+	if _, err := db.Lookup(context.Background(), "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func testLookupMany(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	if m, err := db.LookupMany(ctx, nil); err != nil || len(m) != 0 {
+		t.Fatalf("lookup many of nothing; got %v, %v, want no entries", m, err)
+	}
+
+	want := make(map[string]database.Entry)
+	for _, slug := range []string{"a", "b", "c"} {
+		e := NewEntry(slug)
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		want[slug] = e
+	}
+
+	// Missing slugs are left out rather than failing the lookup, and the
+	// order of slugs doesn't matter.
+	for _, slugs := range [][]string{
+		{"a", "missing", "c", "b"},
+		{"b", "c", "a", "missing"},
+		{"c", "b", "b", "a"},
+	} {
+		got, err := db.LookupMany(ctx, slugs)
+		if err != nil {
+			t.Fatalf("lookup many %v: %v", slugs, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("unexpected entries for %v; got %v, want %v", slugs, got, want)
+		}
+		for slug, want := range want {
+			if !Equal(got[slug], want) {
+				t.Fatalf("unexpected entry for %s; got %+v, want %+v", slug, got[slug], want)
+			}
+		}
+	}
+
+	if m, err := db.LookupMany(ctx, []string{"missing"}); err != nil || len(m) != 0 {
+		t.Fatalf("lookup many missing; got %v, %v, want no entries", m, err)
+	}
+}
+
+func testDelete(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	e := NewEntry("delete")
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := db.Delete(ctx, e.Slug); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := db.Lookup(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	for _, slug := range []string{e.Slug, "missing"} {
+		if err := db.Delete(ctx, slug); !errors.Is(err, database.ErrNoResults) {
+			t.Fatalf("unexpected error deleting %s; got %v, want %v", slug, err, database.ErrNoResults)
+		}
+	}
+}
+
+func testConcurrent(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	const workers, n = 8, 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				e := NewEntry(fmt.Sprintf("c%d-%d", i, j))
+				if err := db.Create(ctx, e); err != nil {
+					errs <- fmt.Errorf("create: %w", err)
+					return
+				}
+				got, err := db.Lookup(ctx, e.Slug)
+				if err != nil {
+					errs <- fmt.Errorf("lookup: %w", err)
+					return
+				}
+				if !Equal(got, e) {
+					errs <- fmt.Errorf("entries are not equal; got %#v, want %#v", got, e)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func testPing(t *testing.T, db database.Database) {
+	if err := db.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createN creates n entries with slugs prefixed by prefix, with every three
+// sharing a timestamp so ties are exercised.
+func createN(t *testing.T, db database.Database, prefix string, base time.Time, n int) {
+	t.Helper()
+
+	const workers = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := i; j < n; j += workers {
+				e := NewEntry(fmt.Sprintf("%s%05d", prefix, j))
+				e.Timestamp = base.Add(time.Duration(j/3) * time.Millisecond)
+				if err := db.Create(context.Background(), e); err != nil {
+					errs <- fmt.Errorf("create: %w", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+// listAll walks every page of a listing, calling f between pages.
+func listAll(t *testing.T, db database.Database, opts database.ListOptions, f func()) []database.Entry {
+	t.Helper()
+	var all []database.Entry
+	for {
+		entries, next, err := db.List(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(entries) > opts.Size() {
+			t.Fatalf("too many entries; got %d, want at most %d", len(entries), opts.Size())
+		}
+		all = append(all, entries...)
+		if next == "" {
+			return all
+		}
+		opts.Cursor = next
+		if f != nil {
+			f()
+		}
+	}
+}
+
+// checkOrder fails if entries aren't strictly ordered.
+func checkOrder(t *testing.T, entries []database.Entry, descending bool) {
+	t.Helper()
+	for i := 1; i < len(entries); i++ {
+		n := database.CursorOf(entries[i-1]).Compare(database.CursorOf(entries[i]))
+		if descending {
+			n = -n
+		}
+		if n >= 0 {
+			t.Fatalf("entries out of order at %d; %s then %s", i, entries[i-1].Slug, entries[i].Slug)
+		}
+	}
+}
+
+func testList(t *testing.T, db database.Database) {
+	n := 10000
+	if testing.Short() {
+		n = 1000
+	}
+	createN(t, db, "list", now().Add(-time.Hour), n)
+
+	for _, descending := range []bool{false, true} {
+		all := listAll(t, db, database.ListOptions{Limit: 97, Descending: descending}, nil)
+		checkOrder(t, all, descending)
+		if len(all) != n {
+			t.Fatalf("unexpected number of entries; got %d, want %d", len(all), n)
+		}
+		if e := all[0]; e.Name != e.Slug+".txt" || e.Sum != "sum-"+e.Slug {
+			t.Fatalf("unexpected entry; got %#v", e)
+		}
+	}
+
+	if _, _, err := db.List(context.Background(), database.ListOptions{Cursor: "!"}); !errors.Is(err, database.ErrInvalidCursor) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrInvalidCursor)
+	}
+}
+
+func testListFilters(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	base := now().Add(-time.Hour)
+	past, future := base, now().Add(time.Hour)
+	for _, v := range []struct {
+		slug, name string
+		offset     time.Duration
+		lifetime   *time.Time
+		tags       []string
+		owner      string
+	}{
+		{slug: "a", name: "apple.txt", lifetime: &past, tags: []string{"fruit"}, owner: "alice"},
+		{slug: "b", name: "banana.txt", offset: time.Second, lifetime: &future, owner: "bob"},
+		{slug: "c", name: "apricot.txt", offset: 2 * time.Second, tags: []string{"fruit", "stone"}, owner: "alice"},
+	} {
+		e := NewEntry(v.slug)
+		e.Name, e.Timestamp, e.Lifetime, e.Tags = v.name, base.Add(v.offset), v.lifetime, v.tags
+		e.Owner = v.owner
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	for _, v := range []struct {
+		name string
+		opts database.ListOptions
+		want string
+	}{
+		{name: "all", want: "abc"},
+		{name: "descending", opts: database.ListOptions{Descending: true}, want: "cba"},
+		{name: "expired", opts: database.ListOptions{Expired: true}, want: "a"},
+		{name: "name prefix", opts: database.ListOptions{NamePrefix: "ap"}, want: "ac"},
+		{name: "created after", opts: database.ListOptions{CreatedAfter: base}, want: "bc"},
+		{name: "created before", opts: database.ListOptions{CreatedBefore: base.Add(2 * time.Second)}, want: "ab"},
+		{name: "tag", opts: database.ListOptions{Tag: "fruit"}, want: "ac"},
+		{name: "other tag", opts: database.ListOptions{Tag: "stone"}, want: "c"},
+		{name: "owner", opts: database.ListOptions{Owner: "alice"}, want: "ac"},
+		{name: "other owner", opts: database.ListOptions{Owner: "bob", Descending: true}, want: "b"},
+		{name: "paged", opts: database.ListOptions{Limit: 1, NamePrefix: "ap", Descending: true}, want: "ca"},
+	} {
+		var got string
+		for _, e := range listAll(t, db, v.opts, nil) {
+			got += e.Slug
+		}
+		want := v.want
+		// Databases with native expiry may have removed a already.
+		if db, ok := db.(database.Expirer); ok && db.Expires() && !strings.Contains(got, "a") {
+			want = strings.ReplaceAll(want, "a", "")
+		}
+		if got != want {
+			t.Errorf("%s: unexpected entries; got %q, want %q", v.name, got, want)
+		}
+	}
+}
+
+func testListConcurrent(t *testing.T, db database.Database) {
+	const n = 500
+
+	base := now().Add(-time.Hour)
+	createN(t, db, "old", base, n)
+
+	// Entries created while listing, both before and after the cursor,
+	// must not cause others to be skipped or listed twice.
+	var i int
+	all := listAll(t, db, database.ListOptions{Limit: 50}, func() {
+		for _, offset := range []time.Duration{-time.Minute, time.Minute} {
+			e := NewEntry(fmt.Sprintf("new%05d", i))
+			e.Timestamp = base.Add(offset)
+			if err := db.Create(context.Background(), e); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			i++
+		}
+	})
+	checkOrder(t, all, false)
+
+	seen := make(map[string]bool)
+	for _, e := range all {
+		if seen[e.Slug] {
+			t.Fatalf("entry listed twice: %s", e.Slug)
+		}
+		seen[e.Slug] = true
+	}
+	for j := 0; j < n; j++ {
+		if slug := fmt.Sprintf("old%05d", j); !seen[slug] {
+			t.Fatalf("entry not listed: %s", slug)
+		}
+	}
+}
+
+func testLookupBySum(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	if _, err := db.LookupBySum(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	// y and z were created at the same time, so z wins by slug.
+	base := now()
+	entries := make(map[string]database.Entry)
+	for i, slug := range []string{"x", "y", "z", "w"} {
+		e := NewEntry(slug)
+		e.Sum = "duplicate"
+		e.Timestamp = base.Add(time.Duration(i+1) / 2 * time.Second)
+		if slug == "w" {
+			e.Sum = "unique"
+		}
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create %s: %v", slug, err)
+		}
+		entries[slug] = e
+	}
+
+	check := func(sum, want string) {
+		t.Helper()
+		e, err := db.LookupBySum(ctx, sum)
+		if err != nil {
+			t.Fatalf("lookup by sum %s: %v", sum, err)
+		}
+		if !Equal(e, entries[want]) {
+			t.Fatalf("unexpected entry for %s; got %+v, want %+v", sum, e, entries[want])
+		}
+	}
+	check("duplicate", "z")
+	check("unique", "w")
+
+	if err := db.Delete(ctx, "z"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	check("duplicate", "y")
+
+	for _, slug := range []string{"x", "y"} {
+		if err := db.Delete(ctx, slug); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	if _, err := db.LookupBySum(ctx, "duplicate"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func testToucher(t *testing.T, db database.Database) {
+	td, ok := db.(database.Toucher)
+	if !ok {
+		t.Skip("database does not implement database.Toucher")
+	}
+
+	ctx := context.Background()
+
+	e := NewEntry("touch")
+	e.LastAccess = nil
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	a := now().Add(time.Minute)
+	if err := td.Touch(ctx, e.Slug, a); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	got, err := db.Lookup(ctx, e.Slug)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if got.LastAccess == nil || !got.LastAccess.Equal(a) {
+		t.Fatalf("unexpected last access; got %v, want %v", got.LastAccess, a)
+	}
+
+	if err := td.Touch(ctx, "missing", a); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func testExtender(t *testing.T, db database.Database) {
+	xd, ok := db.(database.Extender)
+	if !ok {
+		t.Skip("database does not implement database.Extender")
+	}
+
+	ctx := context.Background()
+
+	e := NewEntry("extend")
+	forever := NewEntry("forever")
+	forever.Lifetime = nil
+	for _, e := range []database.Entry{e, forever} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	later, earlier := e.Lifetime.Add(time.Hour), e.Lifetime.Add(-time.Minute)
+	for _, v := range []struct {
+		t    time.Time
+		want time.Time
+	}{
+		{t: later, want: later},
+		// Lifetimes must never be shortened.
+		{t: earlier, want: later},
+	} {
+		if err := xd.Extend(ctx, e.Slug, v.t); err != nil {
+			t.Fatalf("extend: %v", err)
+		}
+		got, err := db.Lookup(ctx, e.Slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if got.Lifetime == nil || !got.Lifetime.Equal(v.want) {
+			t.Fatalf("unexpected lifetime; got %v, want %v", got.Lifetime, v.want)
+		}
+	}
+
+	// Entries without a lifetime must not be given one.
+	if err := xd.Extend(ctx, forever.Slug, later); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	got, err := db.Lookup(ctx, forever.Slug)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if got.Lifetime != nil {
+		t.Fatalf("unexpected lifetime; got %v, want nil", got.Lifetime)
+	}
+}
+
+func testLifetimeSetter(t *testing.T, db database.Database) {
+	ls, ok := db.(database.LifetimeSetter)
+	if !ok {
+		t.Skip("database does not implement database.LifetimeSetter")
+	}
+
+	ctx := context.Background()
+
+	e, kept := NewEntry("lifetime"), NewEntry("kept")
+	for _, e := range []database.Entry{e, kept} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	earlier, later := e.Lifetime.Add(-time.Minute), e.Lifetime.Add(time.Hour)
+	// Unlike extending them, lifetimes can be shortened, removed, and
+	// given to entries without one.
+	for _, want := range []*time.Time{&earlier, nil, &later} {
+		if err := ls.SetLifetime(ctx, e.Slug, want); err != nil {
+			t.Fatalf("set lifetime: %v", err)
+		}
+		e.Lifetime = want
+		for _, want := range []database.Entry{e, kept} {
+			got, err := db.Lookup(ctx, want.Slug)
+			if err != nil {
+				t.Fatalf("lookup: %v", err)
+			}
+			if !Equal(got, want) {
+				t.Fatalf("unexpected entry; got %+v, want %+v", got, want)
+			}
+		}
+	}
+
+	if err := ls.SetLifetime(ctx, "missing", &later); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func testDownloadCounter(t *testing.T, db database.Database) {
+	dc, ok := db.(database.DownloadCounter)
+	if !ok {
+		t.Skip("database does not implement database.DownloadCounter")
+	}
+
+	ctx := context.Background()
+
+	today := database.Day(time.Now())
+	yesterday := today.AddDate(0, 0, -1)
+	for _, v := range []struct {
+		slug string
+		t    time.Time
+		n    int64
+	}{
+		{slug: "a", t: yesterday, n: 1},
+		{slug: "a", t: today, n: 2},
+		{slug: "a", t: today.Add(time.Hour), n: 3},
+		{slug: "b", t: today, n: 4},
+	} {
+		if err := dc.AddDownloads(ctx, v.slug, v.t, v.n); err != nil {
+			t.Fatalf("add downloads: %v", err)
+		}
+	}
+
+	check := func(since time.Time, want ...database.Downloads) {
+		t.Helper()
+		got, err := dc.Downloads(ctx, "a", since)
+		if err != nil {
+			t.Fatalf("downloads: %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("unexpected downloads; got %v, want %v", got, want)
+		}
+		for i := range got {
+			if !got[i].Day.Equal(want[i].Day) || got[i].Count != want[i].Count {
+				t.Fatalf("unexpected downloads; got %v, want %v", got, want)
+			}
+		}
+	}
+
+	check(yesterday, database.Downloads{Day: yesterday, Count: 1}, database.Downloads{Day: today, Count: 5})
+	check(today, database.Downloads{Day: today, Count: 5})
+
+	if err := dc.RemoveDownloads(ctx, "a"); err != nil {
+		t.Fatalf("remove downloads: %v", err)
+	}
+	check(yesterday)
+}
+
+func testSoftDeleter(t *testing.T, db database.Database) {
+	sd, ok := db.(database.SoftDeleter)
+	if !ok {
+		t.Skip("database does not implement database.SoftDeleter")
+	}
+
+	ctx := context.Background()
+
+	e, kept := NewEntry("deleted"), NewEntry("kept")
+	// Soft deleted entries must be created as they are, so they survive
+	// being copied between databases.
+	created := NewEntry("created")
+	d := now().Add(-time.Minute)
+	created.Deleted, created.DeleteReason = &d, "created deleted"
+	for _, e := range []database.Entry{e, kept, created} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	check := func(want database.Entry) {
+		t.Helper()
+		got, err := db.Lookup(ctx, want.Slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if !Equal(got, want) {
+			t.Fatalf("unexpected entry; got %+v, want %+v", got, want)
+		}
+	}
+	check(created)
+
+	d = now()
+	if err := sd.SoftDelete(ctx, e.Slug, d, "abuse"); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	deleted := e
+	deleted.Deleted, deleted.DeleteReason = &d, "abuse"
+	check(deleted)
+
+	var slugs []string
+	for _, e := range listAll(t, db, database.ListOptions{Deleted: true}, nil) {
+		slugs = append(slugs, e.Slug)
+	}
+	sort.Strings(slugs)
+	if got, want := strings.Join(slugs, " "), "created deleted"; got != want {
+		t.Fatalf("unexpected deleted entries; got %q, want %q", got, want)
+	}
+
+	if err := sd.Restore(ctx, e.Slug); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	check(e)
+	check(kept)
+
+	if err := sd.SoftDelete(ctx, "missing", d, "abuse"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if err := sd.Restore(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func testBlocker(t *testing.T, db database.Database) {
+	b, ok := db.(database.Blocker)
+	if !ok {
+		t.Skip("database does not implement database.Blocker")
+	}
+
+	ctx := context.Background()
+
+	e, kept := NewEntry("blocked"), NewEntry("kept")
+	// Blocked entries must be created as they are, so they survive being
+	// copied between databases.
+	created := NewEntry("created")
+	d := now().Add(-time.Minute)
+	created.Blocked, created.BlockReason = &d, "court_order"
+	for _, e := range []database.Entry{e, kept, created} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	check := func(want database.Entry) {
+		t.Helper()
+		got, err := db.Lookup(ctx, want.Slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if !Equal(got, want) {
+			t.Fatalf("unexpected entry; got %+v, want %+v", got, want)
+		}
+	}
+	check(created)
+
+	d = now()
+	if err := b.Block(ctx, e.Slug, d, "dmca"); errors.Is(err, database.ErrUnsupported) {
+		t.Skip("wrapped database does not implement database.Blocker")
+	} else if err != nil {
+		t.Fatalf("block: %v", err)
+	}
+	blocked := e
+	blocked.Blocked, blocked.BlockReason = &d, "dmca"
+	check(blocked)
+
+	if err := b.Unblock(ctx, e.Slug); err != nil {
+		t.Fatalf("unblock: %v", err)
+	}
+	check(e)
+	check(kept)
+
+	if err := b.Block(ctx, "missing", d, "dmca"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if err := b.Unblock(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+func testRenamer(t *testing.T, db database.Database) {
+	r, ok := db.(database.Renamer)
+	if !ok {
+		t.Skip("database does not implement database.Renamer")
+	}
+
+	ctx := context.Background()
+
+	e, kept := NewEntry("renamed"), NewEntry("kept")
+	for _, e := range []database.Entry{e, kept} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if err := r.Rename(ctx, e.Slug, "new name.txt"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	e.Name = "new name.txt"
+	for _, want := range []database.Entry{e, kept} {
+		got, err := db.Lookup(ctx, want.Slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if !Equal(got, want) {
+			t.Fatalf("unexpected entry; got %+v, want %+v", got, want)
+		}
+	}
+
+	if err := r.Rename(ctx, "missing", "name"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func testStatsReporter(t *testing.T, db database.Database) {
+	sr, ok := db.(database.StatsReporter)
+	if !ok {
+		t.Skip("database does not implement database.StatsReporter")
+	}
+
+	ctx := context.Background()
+	now := now()
+	if _, err := sr.Stats(ctx, now); errors.Is(err, database.ErrUnsupported) {
+		t.Skip("wrapped database does not implement database.StatsReporter")
+	}
+
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	// Entries are uploaded today and two days ago, and expire within a
+	// day, within a week, never, or already have.
+	for i, l := range []*time.Time{at(time.Hour), at(72 * time.Hour), nil, at(-time.Hour)} {
+		e := NewEntry(fmt.Sprintf("stats%d", i))
+		e.Size, e.GzipSize, e.Lifetime = int64(1)<<i, 1, l
+		if i%2 == 1 {
+			e.Timestamp = now.Add(-48 * time.Hour)
+		}
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	got, err := sr.Stats(ctx, now)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	want := database.Stats{
+		Entries:      4,
+		Bytes:        1 + 2 + 4 + 8,
+		GzipBytes:    4,
+		ExpiringDay:  1,
+		ExpiringWeek: 1 + 2,
+		Days: []database.DayStats{
+			{Day: database.Day(now.Add(-48 * time.Hour)), Entries: 2, Bytes: 2 + 8},
+			{Day: database.Day(now), Entries: 2, Bytes: 1 + 4},
+		},
+	}
+	if got.Entries != want.Entries ||
+		got.Bytes != want.Bytes ||
+		got.GzipBytes != want.GzipBytes ||
+		got.ExpiringDay != want.ExpiringDay ||
+		got.ExpiringWeek != want.ExpiringWeek ||
+		len(got.Days) != len(want.Days) {
+		t.Fatalf("unexpected stats; got %+v, want %+v", got, want)
+	}
+	for i, d := range got.Days {
+		if w := want.Days[i]; !d.Day.Equal(w.Day) || d.Entries != w.Entries || d.Bytes != w.Bytes {
+			t.Fatalf("unexpected stats for day %d; got %+v, want %+v", i, d, w)
+		}
+	}
+}
+
+// testSearchByName searches with search.ByName, so databases which don't
+// implement database.Searcher test the fallback.
+func testSearchByName(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	names := []string{
+		"Ünïcödé Résumé.pdf",
+		"résumé-final.PDF",
+		"日本語のファイル.txt",
+		"Σίσυφος.txt",
+		"report_100%.txt",
+		"report-2021.txt",
+	}
+	t0 := now()
+	for i, name := range names {
+		e := NewEntry(fmt.Sprintf("search%d", i))
+		e.Name, e.Timestamp = name, t0.Add(time.Duration(i)*time.Millisecond)
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	find := func(query string, opts database.SearchOptions) []string {
+		t.Helper()
+		entries, _, err := search.ByName(ctx, db, query, opts)
+		if err != nil {
+			t.Fatalf("search %q: %v", query, err)
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.Name)
+		}
+		return got
+	}
+	for _, tt := range []struct {
+		query string
+		opts  database.SearchOptions
+		want  []int
+	}{
+		{"résumé", database.SearchOptions{}, []int{0, 1}},
+		{"RÉSUMÉ", database.SearchOptions{Prefix: true}, []int{1}},
+		{"Résumé", database.SearchOptions{CaseSensitive: true}, []int{0}},
+		{"ファイル", database.SearchOptions{}, []int{2}},
+		{"ファイル", database.SearchOptions{Prefix: true}, nil},
+		{"日本", database.SearchOptions{Prefix: true}, []int{2}},
+		{"ΣΊΣΥΦ", database.SearchOptions{Prefix: true}, []int{3}},
+		{"100%", database.SearchOptions{}, []int{4}},
+		{"_", database.SearchOptions{}, []int{4}},
+		{".PDF", database.SearchOptions{}, []int{0, 1}},
+		{".PDF", database.SearchOptions{CaseSensitive: true}, []int{1}},
+		{".pdf", database.SearchOptions{ListOptions: database.ListOptions{Descending: true}}, []int{1, 0}},
+	} {
+		want := []string{}
+		for _, i := range tt.want {
+			want = append(want, names[i])
+		}
+		if got := find(tt.query, tt.opts); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("unexpected results for %q, %+v; got %q, want %q", tt.query, tt.opts, got, want)
+		}
+	}
+
+	// Results are paged like lists.
+	var (
+		got  []string
+		opts = database.SearchOptions{ListOptions: database.ListOptions{Limit: 1}}
+	)
+	for {
+		entries, next, err := search.ByName(ctx, db, "report", opts)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		for _, e := range entries {
+			got = append(got, e.Name)
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if want := []string{names[4], names[5]}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected results; got %q, want %q", got, want)
+	}
+}
+
+func testDenylister(t *testing.T, db database.Database) {
+	dl, ok := db.(database.Denylister)
+	if !ok {
+		t.Skip("database does not implement database.Denylister")
+	}
+
+	ctx := context.Background()
+	if _, err := dl.Denied(ctx, "a"); errors.Is(err, database.ErrUnsupported) {
+		t.Skip("wrapped database does not implement database.Denylister")
+	} else if !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	now := now()
+	for _, d := range []database.Denial{
+		{Sum: "b", Reason: "spam", Time: now},
+		{Sum: "a", Reason: "malware", Time: now.Add(-time.Hour)},
+		{Sum: "c", Reason: "abuse", Time: now},
+		// Denials of the same sum replace each other.
+		{Sum: "b", Reason: "phishing", Time: now.Add(time.Hour)},
+	} {
+		if err := dl.Deny(ctx, d); err != nil {
+			t.Fatalf("deny: %v", err)
+		}
+	}
+
+	got, err := dl.Denied(ctx, "b")
+	if err != nil {
+		t.Fatalf("denied: %v", err)
+	}
+	if got.Sum != "b" || got.Reason != "phishing" || !got.Time.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected denial: %+v", got)
+	}
+
+	check := func(want ...string) {
+		t.Helper()
+		denials, err := dl.Denials(ctx)
+		if err != nil {
+			t.Fatalf("denials: %v", err)
+		}
+		var sums []string
+		for _, d := range denials {
+			sums = append(sums, d.Sum)
+		}
+		if strings.Join(sums, ",") != strings.Join(want, ",") {
+			t.Fatalf("unexpected denials; got %q, want %q", sums, want)
+		}
+	}
+	check("a", "c", "b")
+
+	if err := dl.Allow(ctx, "a"); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if _, err := dl.Denied(ctx, "a"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if err := dl.Allow(ctx, "a"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	check("c", "b")
+}
+
+func testReporter(t *testing.T, db database.Database) {
+	rp, ok := db.(database.Reporter)
+	if !ok {
+		t.Skip("database does not implement database.Reporter")
+	}
+
+	ctx := context.Background()
+	if _, err := rp.Reports(ctx, ""); errors.Is(err, database.ErrUnsupported) {
+		t.Skip("wrapped database does not implement database.Reporter")
+	} else if err != nil {
+		t.Fatalf("reports: %v", err)
+	}
+
+	now := now()
+	for _, r := range []database.Report{
+		{Slug: "b", Category: "copyright", Time: now},
+		{Slug: "a", Category: "malware", Description: "it's a virus", Contact: "a@example.com", Time: now.Add(-time.Hour)},
+		{Slug: "a", Category: "other", Time: now.Add(time.Hour)},
+	} {
+		r.Status = database.ReportOpen
+		if err := rp.Report(ctx, r); err != nil {
+			t.Fatalf("report: %v", err)
+		}
+	}
+
+	check := func(status database.ReportStatus, want ...string) {
+		t.Helper()
+		reports, err := rp.Reports(ctx, status)
+		if err != nil {
+			t.Fatalf("reports: %v", err)
+		}
+		var got []string
+		for _, r := range reports {
+			got = append(got, r.Slug+":"+r.Category)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("unexpected %q reports; got %q, want %q", status, got, want)
+		}
+	}
+	check(database.ReportOpen, "a:malware", "b:copyright", "a:other")
+
+	reports, err := rp.Reports(ctx, database.ReportOpen)
+	if err != nil {
+		t.Fatalf("reports: %v", err)
+	}
+	if r := reports[0]; r.Description != "it's a virus" || r.Contact != "a@example.com" || r.Status != database.ReportOpen || !r.Time.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+
+	if err := rp.ResolveReports(ctx, "a"); err != nil {
+		t.Fatalf("resolve reports: %v", err)
+	}
+	if err := rp.ResolveReports(ctx, "a"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	check(database.ReportOpen, "b:copyright")
+	check(database.ReportResolved, "a:malware", "a:other")
+	check("", "a:malware", "b:copyright", "a:other")
+}
+
+func testAuditor(t *testing.T, db database.Database) {
+	a, ok := db.(database.Auditor)
+	if !ok {
+		t.Skip("database does not implement database.Auditor")
+	}
+
+	ctx := context.Background()
+	if _, _, err := a.AuditLog(ctx, database.AuditOptions{}); errors.Is(err, database.ErrUnsupported) {
+		t.Skip("wrapped database does not implement database.Auditor")
+	} else if err != nil {
+		t.Fatalf("audit log: %v", err)
+	}
+
+	now := now()
+	for _, r := range []database.AuditRecord{
+		{ID: "b", Time: now, Actor: "admin", Action: "delete", Target: "x", RequestID: "r1", Remote: "192.0.2.1", Before: `{"slug":"x"}`},
+		{ID: "a", Time: now.Add(-time.Hour), Actor: "admin", Action: "block", Target: "y", After: `{"block":"dmca"}`},
+		// Records made at the same time are ordered by ID.
+		{ID: "c", Time: now, Actor: "other", Action: "delete", Target: "z"},
+		{ID: "d", Time: now.Add(time.Hour), Actor: "admin", Action: "deny", Target: "sum"},
+	} {
+		if err := a.Audit(ctx, r); err != nil {
+			t.Fatalf("audit: %v", err)
+		}
+	}
+
+	list := func(opts database.AuditOptions) []string {
+		t.Helper()
+		var ids []string
+		for {
+			records, next, err := a.AuditLog(ctx, opts)
+			if err != nil {
+				t.Fatalf("audit log: %v", err)
+			}
+			for _, r := range records {
+				ids = append(ids, r.ID)
+			}
+			if next == "" {
+				return ids
+			}
+			opts.Cursor = next
+		}
+	}
+	for _, tt := range []struct {
+		opts database.AuditOptions
+		want string
+	}{
+		{database.AuditOptions{}, "d,c,b,a"},
+		{database.AuditOptions{Limit: 1}, "d,c,b,a"},
+		{database.AuditOptions{Actor: "admin"}, "d,b,a"},
+		{database.AuditOptions{Action: "delete"}, "c,b"},
+		{database.AuditOptions{Target: "y"}, "a"},
+		{database.AuditOptions{Before: now}, "a"},
+		{database.AuditOptions{After: now.Add(-time.Minute), Limit: 2}, "d,c,b"},
+	} {
+		if got := strings.Join(list(tt.opts), ","); got != tt.want {
+			t.Errorf("unexpected records of %+v; got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+
+	records, _, err := a.AuditLog(ctx, database.AuditOptions{Target: "x"})
+	if err != nil {
+		t.Fatalf("audit log: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if r := records[0]; r.Actor != "admin" || r.RequestID != "r1" || r.Remote != "192.0.2.1" || r.Before != `{"slug":"x"}` || r.After != "" || !r.Time.Equal(now) {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+	if _, _, err := a.AuditLog(ctx, database.AuditOptions{Cursor: "!"}); !errors.Is(err, database.ErrInvalidCursor) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrInvalidCursor)
+	}
+}
+
+func testUserStore(t *testing.T, db database.Database) {
+	us, ok := db.(database.UserStore)
+	if !ok {
+		t.Skip("database does not implement database.UserStore")
+	}
+
+	ctx := context.Background()
+	if _, err := us.Users(ctx); errors.Is(err, database.ErrUnsupported) {
+		t.Skip("wrapped database does not implement database.UserStore")
+	} else if err != nil {
+		t.Fatalf("users: %v", err)
+	}
+
+	now := now()
+	for _, u := range []database.User{
+		{ID: "bob", Created: now},
+		{ID: "alice", Created: now.Add(-time.Hour)},
+	} {
+		if err := us.CreateUser(ctx, u); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	if err := us.CreateUser(ctx, database.User{ID: "bob", Created: now}); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrConflict)
+	}
+	users, err := us.Users(ctx)
+	if err != nil {
+		t.Fatalf("users: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != "alice" || users[1].ID != "bob" || !users[1].Created.Equal(now) {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+	u, err := us.User(ctx, "alice")
+	if err != nil {
+		t.Fatalf("user: %v", err)
+	}
+	if u.ID != "alice" || !u.Created.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+	if _, err := us.User(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	for _, tok := range []database.UserToken{
+		{ID: "b", User: "alice", Hash: "hash-b", Created: now},
+		{ID: "a", User: "alice", Hash: "hash-a", Created: now.Add(-time.Minute)},
+		{ID: "a", User: "bob", Hash: "hash-c", Created: now},
+	} {
+		if err := us.AddUserToken(ctx, tok); err != nil {
+			t.Fatalf("add user token: %v", err)
+		}
+	}
+	for _, tok := range []database.UserToken{
+		{ID: "c", User: "alice", Hash: "hash-c", Created: now},
+		{ID: "a", User: "alice", Hash: "hash-d", Created: now},
+	} {
+		if err := us.AddUserToken(ctx, tok); !errors.Is(err, database.ErrConflict) {
+			t.Fatalf("unexpected error adding %+v; got %v, want %v", tok, err, database.ErrConflict)
+		}
+	}
+	if err := us.AddUserToken(ctx, database.UserToken{ID: "a", User: "missing", Hash: "hash-e", Created: now}); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	tokens, err := us.UserTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("user tokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].ID != "a" || tokens[0].Hash != "hash-a" || tokens[1].ID != "b" || tokens[1].User != "alice" {
+		t.Fatalf("unexpected tokens: %+v", tokens)
+	}
+	if _, err := us.UserTokens(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	u, err = us.UserByToken(ctx, "hash-b")
+	if err != nil {
+		t.Fatalf("user by token: %v", err)
+	}
+	if u.ID != "alice" {
+		t.Fatalf("unexpected user; got %q, want %q", u.ID, "alice")
+	}
+	if _, err := us.UserByToken(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	if err := us.RemoveUserToken(ctx, "alice", "b"); err != nil {
+		t.Fatalf("remove user token: %v", err)
+	}
+	if _, err := us.UserByToken(ctx, "hash-b"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if err := us.RemoveUserToken(ctx, "bob", "b"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	if err := us.DeleteUser(ctx, "alice"); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	if _, err := us.User(ctx, "alice"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if _, err := us.UserByToken(ctx, "hash-a"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if err := us.DeleteUser(ctx, "alice"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	// The user's tokens are gone, so it can be recreated without them.
+	if err := us.CreateUser(ctx, database.User{ID: "alice", Created: now}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if tokens, err := us.UserTokens(ctx, "alice"); err != nil || len(tokens) != 0 {
+		t.Fatalf("unexpected tokens; got %+v, %v, want none", tokens, err)
+	}
+	if u, err := us.UserByToken(ctx, "hash-c"); err != nil || u.ID != "bob" {
+		t.Fatalf("unexpected user; got %+v, %v, want bob", u, err)
+	}
+}
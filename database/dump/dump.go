@@ -0,0 +1,292 @@
+// Package dump exports and imports entries as JSON Lines, one entry per line,
+// so they can be backed up or moved between databases.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// Version is the version of the format written by Export. Import reads this
+// version and older.
+const Version = 1
+
+// ErrInvalid is returned by Import for lines which aren't valid entries.
+var ErrInvalid = errors.New("invalid entry")
+
+// record is the format of each line.
+type record struct {
+	Version      int        `json:"version"`
+	Slug         string     `json:"slug"`
+	Name         string     `json:"name"`
+	Sum          string     `json:"sum"`
+	Size         int64      `json:"size"`
+	Lifetime     *time.Time `json:"lifetime,omitempty"`
+	Timestamp    time.Time  `json:"timestamp"`
+	LastAccess   *time.Time `json:"last_access,omitempty"`
+	GzipSize     int64      `json:"gzip_size,omitempty"`
+	Deleted      *time.Time `json:"deleted,omitempty"`
+	DeleteReason string     `json:"delete_reason,omitempty"`
+	Blocked      *time.Time `json:"blocked,omitempty"`
+	BlockReason  string     `json:"block_reason,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Owner        string     `json:"owner,omitempty"`
+}
+
+// pageSize is the number of entries listed or created at a time.
+const pageSize = 1000
+
+// Export writes every entry in db to w, oldest first, including expired and
+// soft deleted entries. It returns the number of entries written.
+func Export(ctx context.Context, db database.Database, w io.Writer) (n int, err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var cursor string
+	for {
+		entries, next, err := db.List(ctx, database.ListOptions{Limit: pageSize, Cursor: cursor})
+		if err != nil {
+			return n, fmt.Errorf("list: %w", err)
+		}
+		for _, e := range entries {
+			if err := enc.Encode(record{
+				Version:      Version,
+				Slug:         e.Slug,
+				Name:         e.Name,
+				Sum:          e.Sum,
+				Size:         e.Size,
+				Lifetime:     e.Lifetime,
+				Timestamp:    e.Timestamp,
+				LastAccess:   e.LastAccess,
+				GzipSize:     e.GzipSize,
+				Deleted:      e.Deleted,
+				DeleteReason: e.DeleteReason,
+				Blocked:      e.Blocked,
+				BlockReason:  e.BlockReason,
+				Tags:         e.Tags,
+				Owner:        e.Owner,
+			}); err != nil {
+				return n, fmt.Errorf("encode: %w", err)
+			}
+			n++
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if err := bw.Flush(); err != nil {
+		return n, fmt.Errorf("flush: %w", err)
+	}
+	return n, nil
+}
+
+// OnConflict is what Import does with entries whose slugs already exist.
+type OnConflict int
+
+const (
+	// Fail fails the import with database.ErrConflict. It is the default.
+	Fail OnConflict = iota
+	// Skip leaves the existing entry be.
+	Skip
+	// Overwrite replaces the existing entry.
+	Overwrite
+)
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	OnConflict OnConflict
+}
+
+// ImportResult counts what Import did with the entries it read. Databases
+// which replace existing entries rather than report conflicts count
+// overwritten entries as created.
+type ImportResult struct {
+	Created, Skipped, Overwritten int
+}
+
+// Import reads entries written by Export from r and creates them in db, a
+// batch at a time. Entries are validated as they're read, and an invalid
+// entry fails the import with ErrInvalid. Batches created before a failure
+// are kept.
+func Import(ctx context.Context, db database.Database, r io.Reader, opts ImportOptions) (res ImportResult, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 1<<20)
+	batch := make([]database.Entry, 0, pageSize)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return res, fmt.Errorf("line %d: %w: %v", line, ErrInvalid, err)
+		}
+		e, err := rec.entry()
+		if err != nil {
+			return res, fmt.Errorf("line %d: %w", line, err)
+		}
+		if batch = append(batch, e); len(batch) == pageSize {
+			if err := importBatch(ctx, db, batch, opts, &res); err != nil {
+				return res, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return res, fmt.Errorf("scan: %w", err)
+	}
+	if err := importBatch(ctx, db, batch, opts, &res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// entry validates rec, and returns its entry.
+func (rec record) entry() (database.Entry, error) {
+	switch {
+	case rec.Version < 1 || rec.Version > Version:
+		return database.Entry{}, fmt.Errorf("%w: unsupported version %d", ErrInvalid, rec.Version)
+	case !validSlug(rec.Slug):
+		return database.Entry{}, fmt.Errorf("%w: slug %q", ErrInvalid, rec.Slug)
+	case !validSum(rec.Sum):
+		return database.Entry{}, fmt.Errorf("%w: sum %q", ErrInvalid, rec.Sum)
+	case len(rec.Name) > 255:
+		return database.Entry{}, fmt.Errorf("%w: name is too long", ErrInvalid)
+	case rec.Size < 0 || rec.GzipSize < 0:
+		return database.Entry{}, fmt.Errorf("%w: negative size", ErrInvalid)
+	case rec.Timestamp.IsZero():
+		return database.Entry{}, fmt.Errorf("%w: missing timestamp", ErrInvalid)
+	}
+	tags, err := database.NormalizeTags(rec.Tags)
+	if err != nil {
+		return database.Entry{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return database.Entry{
+		Slug:         rec.Slug,
+		Name:         rec.Name,
+		Sum:          rec.Sum,
+		Size:         rec.Size,
+		Lifetime:     rec.Lifetime,
+		Timestamp:    rec.Timestamp,
+		LastAccess:   rec.LastAccess,
+		GzipSize:     rec.GzipSize,
+		Deleted:      rec.Deleted,
+		DeleteReason: rec.DeleteReason,
+		Blocked:      rec.Blocked,
+		BlockReason:  rec.BlockReason,
+		Tags:         tags,
+		Owner:        rec.Owner,
+	}, nil
+}
+
+// validSlug reports whether slug is made of at most 16 URL safe base64
+// characters, as every database can store.
+func validSlug(slug string) bool {
+	if slug == "" || len(slug) > 16 {
+		return false
+	}
+	for _, c := range slug {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validSum reports whether sum is an unpadded, URL safe base64 encoded
+// BLAKE3 sum of between 32 and 64 bytes.
+func validSum(sum string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(sum)
+	return err == nil && len(b) >= 32 && len(b) <= 64
+}
+
+// importBatch creates batch in db, resolving conflicts according to opts.
+func importBatch(ctx context.Context, db database.Database, batch []database.Entry, opts ImportOptions, res *ImportResult) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	// Not every database reports conflicts, so existing entries are
+	// looked up unless they're to be overwritten.
+	var create []database.Entry
+	if opts.OnConflict == Overwrite {
+		create = batch
+	} else {
+		for _, e := range batch {
+			_, err := db.Lookup(ctx, e.Slug)
+			switch {
+			case errors.Is(err, database.ErrNoResults):
+				create = append(create, e)
+			case err != nil:
+				return fmt.Errorf("lookup %s: %w", e.Slug, err)
+			case opts.OnConflict == Skip:
+				res.Skipped++
+			default:
+				return fmt.Errorf("%s: %w", e.Slug, database.ErrConflict)
+			}
+		}
+	}
+
+	err := db.CreateBatch(ctx, create)
+	if err == nil {
+		res.Created += len(create)
+		return nil
+	}
+	// Conflicting entries are created one at a time, after a partial
+	// failure or a failed transaction.
+	var be *database.BatchError
+	if !errors.As(err, &be) && !errors.Is(err, database.ErrConflict) {
+		return fmt.Errorf("create batch: %w", err)
+	}
+	for i, e := range create {
+		if be != nil {
+			err := be.Errs[i]
+			if err == nil {
+				res.Created++
+				continue
+			}
+			if !errors.Is(err, database.ErrConflict) {
+				return fmt.Errorf("create %s: %w", e.Slug, err)
+			}
+		}
+		if err := importEntry(ctx, db, e, opts, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importEntry creates e in db, resolving a conflict according to opts.
+func importEntry(ctx context.Context, db database.Database, e database.Entry, opts ImportOptions, res *ImportResult) error {
+	err := db.Create(ctx, e)
+	if err == nil {
+		res.Created++
+		return nil
+	}
+	if !errors.Is(err, database.ErrConflict) {
+		return fmt.Errorf("create %s: %w", e.Slug, err)
+	}
+	switch opts.OnConflict {
+	case Skip:
+		res.Skipped++
+		return nil
+	case Overwrite:
+		if err := db.Delete(ctx, e.Slug); err != nil && !errors.Is(err, database.ErrNoResults) {
+			return fmt.Errorf("delete %s: %w", e.Slug, err)
+		}
+		if err := db.Create(ctx, e); err != nil {
+			return fmt.Errorf("create %s: %w", e.Slug, err)
+		}
+		res.Overwritten++
+		return nil
+	}
+	return fmt.Errorf("%s: %w", e.Slug, database.ErrConflict)
+}
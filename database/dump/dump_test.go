@@ -0,0 +1,182 @@
+package dump_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/dump"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/database/sql"
+	_ "modernc.org/sqlite"
+)
+
+// entry returns an entry with a valid sum.
+func entry(slug string) database.Entry {
+	e := databasetest.NewEntry(slug)
+	e.Sum = base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte(slug[:1]), 32))
+	return e
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	src := memory.New()
+	var want []database.Entry
+	// More than a page of entries, some without optional fields.
+	for i := 0; i < 2500; i++ {
+		e := entry(fmt.Sprintf("e%d", i))
+		e.Timestamp = e.Timestamp.Add(time.Duration(i) * time.Millisecond)
+		switch i % 3 {
+		case 1:
+			e.Lifetime, e.LastAccess, e.GzipSize = nil, nil, 0
+		case 2:
+			d := e.Timestamp.Add(time.Minute)
+			e.Deleted, e.DeleteReason = &d, "abuse"
+			e.Blocked, e.BlockReason = &d, "dmca"
+		}
+		if err := src.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		want = append(want, e)
+	}
+
+	var buf bytes.Buffer
+	if n, err := dump.Export(ctx, src, &buf); err != nil || n != len(want) {
+		t.Fatalf("export: got %d, %v, want %d", n, err, len(want))
+	}
+	exported := buf.String()
+
+	dst := memory.New()
+	res, err := dump.Import(ctx, dst, &buf, dump.ImportOptions{})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if res != (dump.ImportResult{Created: len(want)}) {
+		t.Fatalf("unexpected result; got %+v, want %d created", res, len(want))
+	}
+	for _, e := range want {
+		got, err := dst.Lookup(ctx, e.Slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if !databasetest.Equal(got, e) {
+			t.Fatalf("entries are not equal; got %#v, want %#v", got, e)
+		}
+	}
+
+	buf.Reset()
+	if _, err := dump.Export(ctx, dst, &buf); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if buf.String() != exported {
+		t.Fatal("exports differ after a round trip")
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) database.Database
+	}{
+		{"Memory", func(*testing.T) database.Database { return memory.New() }},
+		{"SQLite", func(t *testing.T) database.Database {
+			db, err := sql.Open(ctx, sql.SQLite, ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close(ctx) })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			existing, fresh := entry("existing"), entry("fresh")
+			replacement := existing
+			replacement.Name = "replacement.txt"
+
+			var in bytes.Buffer
+			if _, err := dump.Export(ctx, memoryOf(t, replacement, fresh), &in); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			for _, c := range []struct {
+				name       string
+				onConflict dump.OnConflict
+				want       database.Entry
+				err        error
+			}{
+				{"Fail", dump.Fail, existing, database.ErrConflict},
+				{"Skip", dump.Skip, existing, nil},
+				{"Overwrite", dump.Overwrite, replacement, nil},
+			} {
+				t.Run(c.name, func(t *testing.T) {
+					db := tt.open(t)
+					if err := db.Create(ctx, existing); err != nil {
+						t.Fatalf("create: %v", err)
+					}
+					_, err := dump.Import(ctx, db, bytes.NewReader(in.Bytes()), dump.ImportOptions{OnConflict: c.onConflict})
+					if !errors.Is(err, c.err) {
+						t.Fatalf("unexpected error; got %v, want %v", err, c.err)
+					}
+					got, err := db.Lookup(ctx, existing.Slug)
+					if err != nil {
+						t.Fatalf("lookup: %v", err)
+					}
+					if !databasetest.Equal(got, c.want) {
+						t.Fatalf("entries are not equal; got %#v, want %#v", got, c.want)
+					}
+					if c.err != nil {
+						return
+					}
+					if _, err := db.Lookup(ctx, fresh.Slug); err != nil {
+						t.Fatalf("lookup: %v", err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func memoryOf(t *testing.T, entries ...database.Entry) database.Database {
+	t.Helper()
+	db := memory.New()
+	for _, e := range entries {
+		if err := db.Create(context.Background(), e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	return db
+}
+
+func TestImportInvalid(t *testing.T) {
+	const valid = `"slug":"abc","sum":"YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE","timestamp":"2021-01-01T00:00:00Z"`
+	for _, line := range []string{
+		`not json`,
+		`{` + valid + `}`,
+		`{"version":2,` + valid + `}`,
+		`{"version":1,"slug":"a/b","sum":"YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE","timestamp":"2021-01-01T00:00:00Z"}`,
+		`{"version":1,"slug":"abc","sum":"short","timestamp":"2021-01-01T00:00:00Z"}`,
+		`{"version":1,"slug":"abc","sum":"YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE"}`,
+		`{"version":1,"size":-1,` + valid + `}`,
+		`{"version":1,"tags":["Not a tag"],` + valid + `}`,
+	} {
+		db := memory.New()
+		in := `{"version":1,` + valid + "}\n\n" + line + "\n"
+		_, err := dump.Import(context.Background(), db, strings.NewReader(in), dump.ImportOptions{})
+		if !errors.Is(err, dump.ErrInvalid) {
+			t.Fatalf("unexpected error for %s; got %v, want %v", line, err, dump.ErrInvalid)
+		}
+		if !strings.HasPrefix(err.Error(), "line 3: ") {
+			t.Fatalf("unexpected error for %s; got %v, want it on line 3", line, err)
+		}
+	}
+}
@@ -0,0 +1,711 @@
+// Package dynamodb implements a kipp entry database on DynamoDB.
+//
+// Entries are items keyed by slug, and a global secondary index on the sum
+// allows looking entries up by their contents. Another global secondary index
+// orders entries for listing, though as it has a single partition it's only
+// suited to occasional use by admin tooling. Lifetimes are mirrored to a
+// TTL attribute so DynamoDB removes expired entries by itself, though it may
+// take a while to do so.
+//
+// Throttled requests are retried with exponential backoff by the AWS SDK.
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/uhthomas/kipp/database"
+)
+
+// SumIndex is the name of the global secondary index on the sum attribute.
+const SumIndex = "sum"
+
+// PositionIndex is the name of the global secondary index used to list
+// entries. Its partition key is the kind attribute, which is the same for
+// every entry, and its sort key is the position attribute: the zero padded
+// unix nanoseconds of the timestamp, a "/" and the slug.
+const PositionIndex = "position"
+
+const kind = "entry"
+
+func position(c database.Cursor) string {
+	return fmt.Sprintf("%019d/%s", c.Timestamp.UnixNano(), c.Slug)
+}
+
+// Database is an abstraction over a DynamoDB table.
+type Database struct {
+	client *dynamodb.DynamoDB
+	table  string
+}
+
+// New creates a new aws session and DynamoDB client for the named table.
+func New(table string, config *aws.Config) (*Database, error) {
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("new session: %w", err)
+	}
+	return &Database{client: dynamodb.New(sess), table: table}, nil
+}
+
+// CreateTable creates the table with on-demand billing, the sum index, and
+// TTL enabled, then waits for it to become active.
+func (db *Database) CreateTable(ctx context.Context) error {
+	if _, err := db.client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName:   &db.table,
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("slug"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("sum"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("kind"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("position"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("slug"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{{
+			IndexName: aws.String(SumIndex),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String("sum"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			},
+			Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		}, {
+			IndexName: aws.String(PositionIndex),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String("kind"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+				{AttributeName: aws.String("position"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+			},
+			Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		}},
+	}); err != nil {
+		return fmt.Errorf("create table: %w", err)
+	}
+	if err := db.client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: &db.table,
+	}); err != nil {
+		return fmt.Errorf("wait until table exists: %w", err)
+	}
+	if _, err := db.client.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: &db.table,
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String("expires"),
+			Enabled:       aws.Bool(true),
+		},
+	}); err != nil {
+		return fmt.Errorf("update time to live: %w", err)
+	}
+	return nil
+}
+
+// DeleteTable deletes the table, and everything in it.
+func (db *Database) DeleteTable(ctx context.Context) error {
+	if _, err := db.client.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{
+		TableName: &db.table,
+	}); err != nil {
+		return fmt.Errorf("delete table: %w", err)
+	}
+	return nil
+}
+
+// item is the DynamoDB representation of an entry. Times are stored as unix
+// nanoseconds so they can be compared in condition expressions. Expires is
+// the lifetime in unix seconds, as DynamoDB TTL requires.
+type item struct {
+	Slug         string   `dynamodbav:"slug"`
+	Name         string   `dynamodbav:"name"`
+	Sum          string   `dynamodbav:"sum"`
+	Size         int64    `dynamodbav:"size"`
+	Lifetime     *int64   `dynamodbav:"lifetime,omitempty"`
+	Expires      *int64   `dynamodbav:"expires,omitempty"`
+	Timestamp    int64    `dynamodbav:"timestamp"`
+	LastAccess   *int64   `dynamodbav:"last_access,omitempty"`
+	GzipSize     int64    `dynamodbav:"gzip_size"`
+	Kind         string   `dynamodbav:"kind"`
+	Position     string   `dynamodbav:"position"`
+	Deleted      *int64   `dynamodbav:"deleted,omitempty"`
+	DeleteReason string   `dynamodbav:"delete_reason,omitempty"`
+	Blocked      *int64   `dynamodbav:"blocked,omitempty"`
+	BlockReason  string   `dynamodbav:"block_reason,omitempty"`
+	Tags         []string `dynamodbav:"tags,stringset,omitempty"`
+	Owner        string   `dynamodbav:"owner,omitempty"`
+}
+
+func newItem(e database.Entry) item {
+	nanos := func(t *time.Time) *int64 {
+		if t == nil {
+			return nil
+		}
+		return aws.Int64(t.UnixNano())
+	}
+	it := item{
+		Slug:         e.Slug,
+		Name:         e.Name,
+		Sum:          e.Sum,
+		Size:         e.Size,
+		Lifetime:     nanos(e.Lifetime),
+		Timestamp:    e.Timestamp.UnixNano(),
+		LastAccess:   nanos(e.LastAccess),
+		GzipSize:     e.GzipSize,
+		Kind:         kind,
+		Position:     position(database.CursorOf(e)),
+		Deleted:      nanos(e.Deleted),
+		DeleteReason: e.DeleteReason,
+		Blocked:      nanos(e.Blocked),
+		BlockReason:  e.BlockReason,
+		Tags:         e.Tags,
+		Owner:        e.Owner,
+	}
+	if e.Lifetime != nil {
+		it.Expires = aws.Int64(e.Lifetime.Unix())
+	}
+	return it
+}
+
+func (it item) entry() database.Entry {
+	t := func(n *int64) *time.Time {
+		if n == nil {
+			return nil
+		}
+		t := time.Unix(0, *n).UTC()
+		return &t
+	}
+	// String sets are unordered.
+	sort.Strings(it.Tags)
+	return database.Entry{
+		Slug:         it.Slug,
+		Name:         it.Name,
+		Sum:          it.Sum,
+		Size:         it.Size,
+		Lifetime:     t(it.Lifetime),
+		Timestamp:    time.Unix(0, it.Timestamp).UTC(),
+		LastAccess:   t(it.LastAccess),
+		GzipSize:     it.GzipSize,
+		Deleted:      t(it.Deleted),
+		DeleteReason: it.DeleteReason,
+		Blocked:      t(it.Blocked),
+		BlockReason:  it.BlockReason,
+		Tags:         it.Tags,
+		Owner:        it.Owner,
+	}
+}
+
+// isConditionalCheckFailed reports whether err is from a failed condition
+// expression.
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// Create puts e, returning database.ErrConflict if an entry with the same
+// slug already exists.
+func (db *Database) Create(ctx context.Context, e database.Entry) error {
+	av, err := dynamodbattribute.MarshalMap(newItem(e))
+	if err != nil {
+		return fmt.Errorf("marshal map: %w", err)
+	}
+	if _, err := db.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           &db.table,
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(slug)"),
+	}); err != nil {
+		if isConditionalCheckFailed(err) {
+			return database.ErrConflict
+		}
+		return fmt.Errorf("put item: %w", err)
+	}
+	return nil
+}
+
+// maxTransactItems is the most items a DynamoDB transaction may write.
+const maxTransactItems = 100
+
+// CreateBatch puts entries in transactions of up to 100, returning
+// database.ErrConflict if an entry with the same slug as one of them already
+// exists. Larger batches aren't all-or-nothing, and fail with a
+// *database.BatchError describing the entries of the transactions which
+// failed.
+func (db *Database) CreateBatch(ctx context.Context, entries []database.Entry) error {
+	if len(entries) <= maxTransactItems {
+		if err := db.transactPut(ctx, entries); err != nil {
+			if conflicts(err) != nil {
+				return database.ErrConflict
+			}
+			return err
+		}
+		return nil
+	}
+	errs := make([]error, len(entries))
+	var failed bool
+	for i := 0; i < len(entries); i += maxTransactItems {
+		j := i + maxTransactItems
+		if j > len(entries) {
+			j = len(entries)
+		}
+		err := db.transactPut(ctx, entries[i:j])
+		if err == nil {
+			continue
+		}
+		failed = true
+		c := conflicts(err)
+		for k := i; k < j; k++ {
+			if c != nil && c[k-i] {
+				errs[k] = database.ErrConflict
+			} else {
+				errs[k] = err
+			}
+		}
+	}
+	if failed {
+		return &database.BatchError{Errs: errs}
+	}
+	return nil
+}
+
+// transactPut puts entries in a single transaction, on the condition that
+// none of them exist.
+func (db *Database) transactPut(ctx context.Context, entries []database.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	items := make([]*dynamodb.TransactWriteItem, len(entries))
+	for i, e := range entries {
+		av, err := dynamodbattribute.MarshalMap(newItem(e))
+		if err != nil {
+			return fmt.Errorf("marshal map: %w", err)
+		}
+		items[i] = &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			TableName:           &db.table,
+			Item:                av,
+			ConditionExpression: aws.String("attribute_not_exists(slug)"),
+		}}
+	}
+	if _, err := db.client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}); err != nil {
+		return fmt.Errorf("transact write items: %w", err)
+	}
+	return nil
+}
+
+// conflicts reports which items of a cancelled transaction failed their
+// condition, or returns nil if none did.
+func conflicts(err error) []bool {
+	var tce *dynamodb.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		return nil
+	}
+	c := make([]bool, len(tce.CancellationReasons))
+	var any bool
+	for i, r := range tce.CancellationReasons {
+		if aws.StringValue(r.Code) == "ConditionalCheckFailed" {
+			c[i], any = true, true
+		}
+	}
+	if !any {
+		return nil
+	}
+	return c
+}
+
+func key(slug string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{"slug": {S: &slug}}
+}
+
+// Delete deletes the entry with the given slug.
+func (db *Database) Delete(ctx context.Context, slug string) error {
+	if _, err := db.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:           &db.table,
+		Key:                 key(slug),
+		ConditionExpression: aws.String("attribute_exists(slug)"),
+	}); err != nil {
+		if isConditionalCheckFailed(err) {
+			return database.ErrNoResults
+		}
+		return fmt.Errorf("delete item: %w", err)
+	}
+	return nil
+}
+
+// Lookup looks up the named entry.
+func (db *Database) Lookup(ctx context.Context, slug string) (database.Entry, error) {
+	out, err := db.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      &db.table,
+		Key:            key(slug),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return database.Entry{}, fmt.Errorf("get item: %w", err)
+	}
+	if out.Item == nil {
+		return database.Entry{}, database.ErrNoResults
+	}
+	var it item
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &it); err != nil {
+		return database.Entry{}, fmt.Errorf("unmarshal map: %w", err)
+	}
+	return it.entry(), nil
+}
+
+// maxBatchGetItems is the most items DynamoDB gets in a single batch.
+const maxBatchGetItems = 100
+
+// LookupMany looks up the named entries with batches of up to 100 consistent
+// reads, retrying keys DynamoDB leaves unprocessed.
+func (db *Database) LookupMany(ctx context.Context, slugs []string) (map[string]database.Entry, error) {
+	m := make(map[string]database.Entry, len(slugs))
+	// Batches may not hold duplicate keys.
+	seen := make(map[string]bool, len(slugs))
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, slug := range slugs {
+		if !seen[slug] {
+			seen[slug] = true
+			keys = append(keys, key(slug))
+		}
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxBatchGetItems {
+			n = maxBatchGetItems
+		}
+		out, err := db.client.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{
+				db.table: {Keys: keys[:n], ConsistentRead: aws.Bool(true)},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("batch get item: %w", err)
+		}
+		var items []item
+		if err := dynamodbattribute.UnmarshalListOfMaps(out.Responses[db.table], &items); err != nil {
+			return nil, fmt.Errorf("unmarshal list of maps: %w", err)
+		}
+		for _, it := range items {
+			m[it.Slug] = it.entry()
+		}
+		keys = keys[n:]
+		if u := out.UnprocessedKeys[db.table]; u != nil {
+			keys = append(keys, u.Keys...)
+		}
+	}
+	return m, nil
+}
+
+// LookupBySum looks up the newest entry with the given sum by querying the
+// sum index.
+func (db *Database) LookupBySum(ctx context.Context, sum string) (newest database.Entry, err error) {
+	if err := db.client.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                &db.table,
+		IndexName:                aws.String(SumIndex),
+		KeyConditionExpression:   aws.String("#s = :s"),
+		ExpressionAttributeNames: map[string]*string{"#s": aws.String("sum")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {S: &sum},
+		},
+	}, func(out *dynamodb.QueryOutput, _ bool) bool {
+		var items []item
+		if err = dynamodbattribute.UnmarshalListOfMaps(out.Items, &items); err != nil {
+			return false
+		}
+		for _, it := range items {
+			e := it.entry()
+			if newest.Slug == "" || database.CursorOf(e).Compare(database.CursorOf(newest)) > 0 {
+				newest = e
+			}
+		}
+		return true
+	}); err != nil {
+		return database.Entry{}, fmt.Errorf("query pages: %w", err)
+	}
+	if err != nil {
+		return database.Entry{}, fmt.Errorf("unmarshal list of maps: %w", err)
+	}
+	if newest.Slug == "" {
+		return database.Entry{}, database.ErrNoResults
+	}
+	return newest, nil
+}
+
+// List lists entries matching opts by querying the position index. Filters
+// are applied after reading, so sparse matches may take several requests.
+func (db *Database) List(ctx context.Context, opts database.ListOptions) ([]database.Entry, string, error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	in := &dynamodb.QueryInput{
+		TableName:                &db.table,
+		IndexName:                aws.String(PositionIndex),
+		KeyConditionExpression:   aws.String("#k = :k"),
+		ExpressionAttributeNames: map[string]*string{"#k": aws.String("kind")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":k": {S: aws.String(kind)},
+		},
+		ScanIndexForward: aws.Bool(!opts.Descending),
+		Limit:            aws.Int64(int64(opts.Size() + 1)),
+	}
+	if !c.IsZero() {
+		op := ">"
+		if opts.Descending {
+			op = "<"
+		}
+		*in.KeyConditionExpression += " AND #p " + op + " :p"
+		in.ExpressionAttributeNames["#p"] = aws.String("position")
+		in.ExpressionAttributeValues[":p"] = &dynamodb.AttributeValue{S: aws.String(position(c))}
+	}
+
+	now := time.Now()
+	var entries []database.Entry
+	for len(entries) <= opts.Size() {
+		out, err := db.client.QueryWithContext(ctx, in)
+		if err != nil {
+			return nil, "", fmt.Errorf("query: %w", err)
+		}
+		var items []item
+		if err := dynamodbattribute.UnmarshalListOfMaps(out.Items, &items); err != nil {
+			return nil, "", fmt.Errorf("unmarshal list of maps: %w", err)
+		}
+		for _, it := range items {
+			if e := it.entry(); opts.Match(e, now) && len(entries) <= opts.Size() {
+				entries = append(entries, e)
+			}
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	entries, next := opts.Page(entries)
+	return entries, next, nil
+}
+
+// Touch sets the last access time of the named entry to t.
+func (db *Database) Touch(ctx context.Context, slug string, t time.Time) error {
+	if _, err := db.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                &db.table,
+		Key:                      key(slug),
+		ConditionExpression:      aws.String("attribute_exists(slug)"),
+		UpdateExpression:         aws.String("SET #a = :t"),
+		ExpressionAttributeNames: map[string]*string{"#a": aws.String("last_access")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {N: aws.String(strconv.FormatInt(t.UnixNano(), 10))},
+		},
+	}); err != nil {
+		if isConditionalCheckFailed(err) {
+			return database.ErrNoResults
+		}
+		return fmt.Errorf("update item: %w", err)
+	}
+	return nil
+}
+
+// SoftDelete marks the named entry as deleted at t for the given reason.
+func (db *Database) SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.updateEntry(ctx, slug, "SET #d = :d, #r = :r", map[string]*dynamodb.AttributeValue{
+		":d": {N: aws.String(strconv.FormatInt(t.UnixNano(), 10))},
+		":r": {S: aws.String(reason)},
+	})
+}
+
+// Restore unmarks the named entry as deleted.
+func (db *Database) Restore(ctx context.Context, slug string) error {
+	return db.updateEntry(ctx, slug, "REMOVE #d, #r", nil)
+}
+
+// Block marks the named entry as blocked at t for the given reason.
+func (db *Database) Block(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.updateEntry(ctx, slug, "SET #b = :b, #c = :c", map[string]*dynamodb.AttributeValue{
+		":b": {N: aws.String(strconv.FormatInt(t.UnixNano(), 10))},
+		":c": {S: aws.String(reason)},
+	})
+}
+
+// Unblock unmarks the named entry as blocked.
+func (db *Database) Unblock(ctx context.Context, slug string) error {
+	return db.updateEntry(ctx, slug, "REMOVE #b, #c", nil)
+}
+
+// Rename sets the name of the named entry.
+func (db *Database) Rename(ctx context.Context, slug, name string) error {
+	return db.updateEntry(ctx, slug, "SET #n = :n", map[string]*dynamodb.AttributeValue{
+		":n": {S: aws.String(name)},
+	})
+}
+
+// SetLifetime sets the lifetime of the named entry to t, or removes it if t
+// is nil, along with the attribute it expires by.
+func (db *Database) SetLifetime(ctx context.Context, slug string, t *time.Time) error {
+	if t == nil {
+		return db.updateEntry(ctx, slug, "REMOVE #l, #e", nil)
+	}
+	return db.updateEntry(ctx, slug, "SET #l = :l, #e = :e", map[string]*dynamodb.AttributeValue{
+		":l": {N: aws.String(strconv.FormatInt(t.UnixNano(), 10))},
+		":e": {N: aws.String(strconv.FormatInt(t.Unix(), 10))},
+	})
+}
+
+// updateAttributes are the attributes update expressions may refer to.
+var updateAttributes = map[string]string{
+	"#d": "deleted",
+	"#r": "delete_reason",
+	"#b": "blocked",
+	"#c": "block_reason",
+	"#n": "name",
+	"#l": "lifetime",
+	"#e": "expires",
+}
+
+// updateEntry applies the update expression to the named entry, which may
+// refer to the deleted, delete reason, blocked, block reason, name, lifetime
+// and expires attributes as #d, #r, #b, #c, #n, #l and #e.
+func (db *Database) updateEntry(ctx context.Context, slug, expr string, values map[string]*dynamodb.AttributeValue) error {
+	// DynamoDB rejects names which the expression doesn't use.
+	names := make(map[string]*string)
+	for k, v := range updateAttributes {
+		if strings.Contains(expr, k) {
+			names[k] = aws.String(v)
+		}
+	}
+	if _, err := db.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &db.table,
+		Key:                       key(slug),
+		ConditionExpression:       aws.String("attribute_exists(slug)"),
+		UpdateExpression:          &expr,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}); err != nil {
+		if isConditionalCheckFailed(err) {
+			return database.ErrNoResults
+		}
+		return fmt.Errorf("update item: %w", err)
+	}
+	return nil
+}
+
+// Extend sets the lifetime of the named entry to t if it has a lifetime which
+// ends before t, and moves its TTL accordingly.
+func (db *Database) Extend(ctx context.Context, slug string, t time.Time) error {
+	if _, err := db.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           &db.table,
+		Key:                 key(slug),
+		ConditionExpression: aws.String("attribute_exists(slug) AND #l < :l"),
+		UpdateExpression:    aws.String("SET #l = :l, #e = :e"),
+		ExpressionAttributeNames: map[string]*string{
+			"#l": aws.String("lifetime"),
+			"#e": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l": {N: aws.String(strconv.FormatInt(t.UnixNano(), 10))},
+			":e": {N: aws.String(strconv.FormatInt(t.Unix(), 10))},
+		},
+	}); err != nil {
+		if !isConditionalCheckFailed(err) {
+			return fmt.Errorf("update item: %w", err)
+		}
+		// The entry either doesn't exist, or doesn't need extending.
+		if _, err := db.Lookup(ctx, slug); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadsKey returns the key of the item holding the download counts of
+// the named entry. Slugs never contain a "/", so the keys can't collide with
+// entries.
+func downloadsKey(slug string) map[string]*dynamodb.AttributeValue {
+	return key("downloads/" + slug)
+}
+
+// dayPrefix prefixes the attributes of download count items, which are named
+// by day.
+const dayPrefix = "d:"
+
+// AddDownloads adds n to the download count of the named entry for the day
+// containing t.
+func (db *Database) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	if _, err := db.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        &db.table,
+		Key:              downloadsKey(slug),
+		UpdateExpression: aws.String("ADD #d :n"),
+		ExpressionAttributeNames: map[string]*string{
+			"#d": aws.String(dayPrefix + database.Day(t).Format("2006-01-02")),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":n": {N: aws.String(strconv.FormatInt(n, 10))},
+		},
+	}); err != nil {
+		return fmt.Errorf("update item: %w", err)
+	}
+	return nil
+}
+
+// Downloads returns the download counts of the named entry for each day
+// since t, oldest first.
+func (db *Database) Downloads(ctx context.Context, slug string, t time.Time) ([]database.Downloads, error) {
+	out, err := db.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      &db.table,
+		Key:            downloadsKey(slug),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get item: %w", err)
+	}
+	since := database.Day(t)
+	var d []database.Downloads
+	for k, v := range out.Item {
+		if !strings.HasPrefix(k, dayPrefix) || v.N == nil {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", k[len(dayPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("parse day: %w", err)
+		}
+		if day.Before(since) {
+			continue
+		}
+		n, err := strconv.ParseInt(*v.N, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse count: %w", err)
+		}
+		d = append(d, database.Downloads{Day: day, Count: n})
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i].Day.Before(d[j].Day) })
+	return d, nil
+}
+
+// RemoveDownloads removes the download counts of the named entry.
+func (db *Database) RemoveDownloads(ctx context.Context, slug string) error {
+	if _, err := db.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: &db.table,
+		Key:       downloadsKey(slug),
+	}); err != nil {
+		return fmt.Errorf("delete item: %w", err)
+	}
+	return nil
+}
+
+// Expires reports that DynamoDB removes expired entries, once TTL has been
+// enabled for the table.
+func (db *Database) Expires() bool { return true }
+
+// Ping describes the table.
+func (db *Database) Ping(ctx context.Context) error {
+	if _, err := db.client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: &db.table,
+	}); err != nil {
+		return fmt.Errorf("describe table: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op, as the client holds no resources which need releasing.
+func (db *Database) Close(context.Context) error { return nil }
@@ -0,0 +1,112 @@
+package dynamodb_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsdynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/dynamodb"
+)
+
+// config returns the config for the DynamoDB endpoint in KIPP_TEST_DYNAMODB,
+// such as DynamoDB Local, skipping the test if it isn't set.
+func config(t *testing.T) *aws.Config {
+	endpoint := os.Getenv("KIPP_TEST_DYNAMODB")
+	if endpoint == "" {
+		t.Skip("KIPP_TEST_DYNAMODB not set")
+	}
+	return &aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    &endpoint,
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	}
+}
+
+// open creates a table which is deleted when the test finishes.
+func open(t *testing.T, c *aws.Config) (db *dynamodb.Database, table string) {
+	ctx := context.Background()
+	table = fmt.Sprintf("kipp-test-%d", time.Now().UnixNano())
+	db, err := dynamodb.New(table, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := db.DeleteTable(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	return db, table
+}
+
+// TestDatabase creates and deletes a table for each test.
+func TestDatabase(t *testing.T) {
+	c := config(t)
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, _ := open(t, c)
+		return db
+	})
+}
+
+// TestExpires checks only entries with a lifetime are given a TTL, which
+// follows the lifetime as it's extended.
+func TestExpires(t *testing.T) {
+	ctx := context.Background()
+
+	c := config(t)
+	db, table := open(t, c)
+
+	e := databasetest.NewEntry("expires")
+	forever := databasetest.NewEntry("forever")
+	forever.Lifetime = nil
+	for _, e := range []database.Entry{e, forever} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	later := e.Lifetime.Add(time.Hour)
+	for _, slug := range []string{e.Slug, forever.Slug} {
+		if err := db.Extend(ctx, slug, later); err != nil {
+			t.Fatalf("extend: %v", err)
+		}
+	}
+
+	sess, err := session.NewSession(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := awsdynamodb.New(sess)
+	for slug, want := range map[string]string{
+		e.Slug:       strconv.FormatInt(later.Unix(), 10),
+		forever.Slug: "",
+	} {
+		out, err := client.GetItemWithContext(ctx, &awsdynamodb.GetItemInput{
+			TableName: &table,
+			Key:       map[string]*awsdynamodb.AttributeValue{"slug": {S: aws.String(slug)}},
+		})
+		if err != nil {
+			t.Fatalf("get item: %v", err)
+		}
+		var got string
+		if v := out.Item["expires"]; v != nil {
+			got = aws.StringValue(v.N)
+		}
+		if got != want {
+			t.Fatalf("unexpected expiry for %s; got %q, want %q", slug, got, want)
+		}
+	}
+	if !db.Expires() {
+		t.Fatal("DynamoDB should expire entries")
+	}
+}
@@ -0,0 +1,549 @@
+// Package instrument records Prometheus metrics, and optionally OpenTelemetry
+// spans, for calls to a database.Database.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer spans are recorded with.
+const tracerName = "github.com/uhthomas/kipp/database/instrument"
+
+type metrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	inFlight *prometheus.GaugeVec
+}
+
+// newMetrics registers the metrics with r, or reuses those already
+// registered by another Database.
+func newMetrics(r prometheus.Registerer) (*metrics, error) {
+	labels := []string{"backend", "method"}
+	duration, err := register(r, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kipp",
+		Subsystem: "database",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of database calls.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	errs, err := register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kipp",
+		Subsystem: "database",
+		Name:      "errors_total",
+		Help:      "Number of failed database calls, not counting those which found no results.",
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	inFlight, err := register(r, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kipp",
+		Subsystem: "database",
+		Name:      "requests_in_flight",
+		Help:      "Number of database calls in progress.",
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	return &metrics{
+		duration: duration.(*prometheus.HistogramVec),
+		errors:   errs.(*prometheus.CounterVec),
+		inFlight: inFlight.(*prometheus.GaugeVec),
+	}, nil
+}
+
+// register registers c with r, returning the existing collector if an
+// equivalent one is already registered.
+func register(r prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	return c, nil
+}
+
+// Database wraps a database.Database, recording the duration, errors and
+// number in flight of each call, labelled by backend and method, and a span of
+// each if it's traced. Errors are passed through as they are, and contexts
+// too, but for their spans.
+//
+// Database implements every optional interface in package database. Methods
+// of interfaces the wrapped database doesn't implement return
+// database.ErrUnsupported, so capabilities must be checked before wrapping.
+type Database struct {
+	db      database.Database
+	backend string
+	m       *metrics
+	tracer  trace.Tracer
+}
+
+// An Option configures a Database.
+type Option func(db *Database)
+
+// Tracer records a span for each call with a tracer of tp, as a child of the
+// span of its context. Calls aren't traced if tp is nil.
+func Tracer(tp trace.TracerProvider) Option {
+	return func(db *Database) {
+		if tp != nil {
+			db.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// New wraps db, registering its metrics with r, or recording none if r is
+// nil, such as when calls are only traced. The backend label is
+// database.Name(db) if backend is empty.
+func New(db database.Database, r prometheus.Registerer, backend string, opts ...Option) (*Database, error) {
+	d := &Database{db: db, backend: backend}
+	if r != nil {
+		m, err := newMetrics(r)
+		if err != nil {
+			return nil, err
+		}
+		d.m = m
+	}
+	if d.backend == "" {
+		d.backend = database.Name(db)
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d, nil
+}
+
+// Unwrap returns the wrapped database.
+func (db *Database) Unwrap() database.Database { return db.db }
+
+// observe calls f, recording metrics and a span for the named method. f is
+// called with the context of the span, so those of the wrapped database are
+// its children. The span is labelled with slug if it's not empty.
+func (db *Database) observe(ctx context.Context, method, slug string, f func(ctx context.Context) error) error {
+	var span trace.Span
+	if db.tracer != nil {
+		ctx, span = db.tracer.Start(ctx, "database."+method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("kipp.database.backend", db.backend)),
+		)
+		if slug != "" {
+			span.SetAttributes(attribute.String("kipp.slug", slug))
+		}
+		defer span.End()
+	}
+	var labels prometheus.Labels
+	if db.m != nil {
+		labels = prometheus.Labels{"backend": db.backend, "method": method}
+		g := db.m.inFlight.With(labels)
+		g.Inc()
+		defer g.Dec()
+	}
+
+	start := time.Now()
+	err := f(ctx)
+	failed := err != nil && !errors.Is(err, database.ErrNoResults)
+	if span != nil && failed {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if db.m == nil {
+		return err
+	}
+	db.m.duration.With(labels).Observe(time.Since(start).Seconds())
+	if failed {
+		db.m.errors.With(labels).Inc()
+	}
+	return err
+}
+
+func (db *Database) Create(ctx context.Context, e database.Entry) error {
+	return db.observe(ctx, "create", e.Slug, func(ctx context.Context) error { return db.db.Create(ctx, e) })
+}
+
+func (db *Database) CreateBatch(ctx context.Context, entries []database.Entry) error {
+	return db.observe(ctx, "create_batch", "", func(ctx context.Context) error { return db.db.CreateBatch(ctx, entries) })
+}
+
+func (db *Database) Delete(ctx context.Context, slug string) error {
+	return db.observe(ctx, "delete", slug, func(ctx context.Context) error { return db.db.Delete(ctx, slug) })
+}
+
+func (db *Database) Lookup(ctx context.Context, slug string) (e database.Entry, err error) {
+	err = db.observe(ctx, "lookup", slug, func(ctx context.Context) error {
+		e, err = db.db.Lookup(ctx, slug)
+		return err
+	})
+	return e, err
+}
+
+func (db *Database) LookupMany(ctx context.Context, slugs []string) (m map[string]database.Entry, err error) {
+	err = db.observe(ctx, "lookup_many", "", func(ctx context.Context) error {
+		m, err = db.db.LookupMany(ctx, slugs)
+		return err
+	})
+	return m, err
+}
+
+func (db *Database) LookupBySum(ctx context.Context, sum string) (e database.Entry, err error) {
+	err = db.observe(ctx, "lookup_by_sum", "", func(ctx context.Context) error {
+		e, err = db.db.LookupBySum(ctx, sum)
+		return err
+	})
+	return e, err
+}
+
+func (db *Database) List(ctx context.Context, opts database.ListOptions) (entries []database.Entry, next string, err error) {
+	err = db.observe(ctx, "list", "", func(ctx context.Context) error {
+		entries, next, err = db.db.List(ctx, opts)
+		return err
+	})
+	return entries, next, err
+}
+
+func (db *Database) SearchByName(ctx context.Context, query string, opts database.SearchOptions) (entries []database.Entry, next string, err error) {
+	err = db.observe(ctx, "search_by_name", "", func(ctx context.Context) error {
+		d, ok := db.db.(database.Searcher)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		entries, next, err = d.SearchByName(ctx, query, opts)
+		return err
+	})
+	return entries, next, err
+}
+
+func (db *Database) Ping(ctx context.Context) error {
+	return db.observe(ctx, "ping", "", func(ctx context.Context) error { return db.db.Ping(ctx) })
+}
+
+func (db *Database) Close(ctx context.Context) error {
+	return db.observe(ctx, "close", "", func(ctx context.Context) error { return db.db.Close(ctx) })
+}
+
+func (db *Database) Touch(ctx context.Context, slug string, t time.Time) error {
+	return db.observe(ctx, "touch", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.Toucher)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.Touch(ctx, slug, t)
+	})
+}
+
+func (db *Database) Extend(ctx context.Context, slug string, t time.Time) error {
+	return db.observe(ctx, "extend", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.Extender)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.Extend(ctx, slug, t)
+	})
+}
+
+func (db *Database) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	return db.observe(ctx, "add_downloads", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.DownloadCounter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.AddDownloads(ctx, slug, t, n)
+	})
+}
+
+func (db *Database) Downloads(ctx context.Context, slug string, t time.Time) (downloads []database.Downloads, err error) {
+	err = db.observe(ctx, "downloads", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.DownloadCounter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		downloads, err = d.Downloads(ctx, slug, t)
+		return err
+	})
+	return downloads, err
+}
+
+func (db *Database) Stats(ctx context.Context, now time.Time) (s database.Stats, err error) {
+	err = db.observe(ctx, "stats", "", func(ctx context.Context) error {
+		d, ok := db.db.(database.StatsReporter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		s, err = d.Stats(ctx, now)
+		return err
+	})
+	return s, err
+}
+
+func (db *Database) RemoveDownloads(ctx context.Context, slug string) error {
+	return db.observe(ctx, "remove_downloads", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.DownloadCounter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.RemoveDownloads(ctx, slug)
+	})
+}
+
+func (db *Database) SetLifetime(ctx context.Context, slug string, t *time.Time) error {
+	return db.observe(ctx, "set_lifetime", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.LifetimeSetter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.SetLifetime(ctx, slug, t)
+	})
+}
+
+func (db *Database) SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.observe(ctx, "soft_delete", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.SoftDeleter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.SoftDelete(ctx, slug, t, reason)
+	})
+}
+
+func (db *Database) Restore(ctx context.Context, slug string) error {
+	return db.observe(ctx, "restore", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.SoftDeleter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.Restore(ctx, slug)
+	})
+}
+
+func (db *Database) Block(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.observe(ctx, "block", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.Blocker)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.Block(ctx, slug, t, reason)
+	})
+}
+
+func (db *Database) Unblock(ctx context.Context, slug string) error {
+	return db.observe(ctx, "unblock", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.Blocker)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.Unblock(ctx, slug)
+	})
+}
+
+func (db *Database) Rename(ctx context.Context, slug, name string) error {
+	return db.observe(ctx, "rename", slug, func(ctx context.Context) error {
+		d, ok := db.db.(database.Renamer)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return d.Rename(ctx, slug, name)
+	})
+}
+
+func (db *Database) Deny(ctx context.Context, d database.Denial) error {
+	return db.observe(ctx, "deny", "", func(ctx context.Context) error {
+		dl, ok := db.db.(database.Denylister)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return dl.Deny(ctx, d)
+	})
+}
+
+func (db *Database) Denied(ctx context.Context, sum string) (d database.Denial, err error) {
+	err = db.observe(ctx, "denied", "", func(ctx context.Context) error {
+		dl, ok := db.db.(database.Denylister)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		d, err = dl.Denied(ctx, sum)
+		return err
+	})
+	return d, err
+}
+
+func (db *Database) Allow(ctx context.Context, sum string) error {
+	return db.observe(ctx, "allow", "", func(ctx context.Context) error {
+		dl, ok := db.db.(database.Denylister)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return dl.Allow(ctx, sum)
+	})
+}
+
+func (db *Database) Denials(ctx context.Context) (denials []database.Denial, err error) {
+	err = db.observe(ctx, "denials", "", func(ctx context.Context) error {
+		dl, ok := db.db.(database.Denylister)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		denials, err = dl.Denials(ctx)
+		return err
+	})
+	return denials, err
+}
+
+func (db *Database) Report(ctx context.Context, r database.Report) error {
+	return db.observe(ctx, "report", r.Slug, func(ctx context.Context) error {
+		rp, ok := db.db.(database.Reporter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return rp.Report(ctx, r)
+	})
+}
+
+func (db *Database) Reports(ctx context.Context, status database.ReportStatus) (reports []database.Report, err error) {
+	err = db.observe(ctx, "reports", "", func(ctx context.Context) error {
+		rp, ok := db.db.(database.Reporter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		reports, err = rp.Reports(ctx, status)
+		return err
+	})
+	return reports, err
+}
+
+func (db *Database) ResolveReports(ctx context.Context, slug string) error {
+	return db.observe(ctx, "resolve_reports", slug, func(ctx context.Context) error {
+		rp, ok := db.db.(database.Reporter)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return rp.ResolveReports(ctx, slug)
+	})
+}
+
+func (db *Database) Audit(ctx context.Context, r database.AuditRecord) error {
+	return db.observe(ctx, "audit", "", func(ctx context.Context) error {
+		a, ok := db.db.(database.Auditor)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return a.Audit(ctx, r)
+	})
+}
+
+func (db *Database) AuditLog(ctx context.Context, opts database.AuditOptions) (records []database.AuditRecord, next string, err error) {
+	err = db.observe(ctx, "audit_log", "", func(ctx context.Context) error {
+		a, ok := db.db.(database.Auditor)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		records, next, err = a.AuditLog(ctx, opts)
+		return err
+	})
+	return records, next, err
+}
+
+func (db *Database) CreateUser(ctx context.Context, u database.User) error {
+	return db.observe(ctx, "create_user", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return us.CreateUser(ctx, u)
+	})
+}
+
+func (db *Database) User(ctx context.Context, id string) (u database.User, err error) {
+	err = db.observe(ctx, "user", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		u, err = us.User(ctx, id)
+		return err
+	})
+	return u, err
+}
+
+func (db *Database) Users(ctx context.Context) (users []database.User, err error) {
+	err = db.observe(ctx, "users", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		users, err = us.Users(ctx)
+		return err
+	})
+	return users, err
+}
+
+func (db *Database) DeleteUser(ctx context.Context, id string) error {
+	return db.observe(ctx, "delete_user", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return us.DeleteUser(ctx, id)
+	})
+}
+
+func (db *Database) AddUserToken(ctx context.Context, t database.UserToken) error {
+	return db.observe(ctx, "add_user_token", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return us.AddUserToken(ctx, t)
+	})
+}
+
+func (db *Database) UserTokens(ctx context.Context, id string) (tokens []database.UserToken, err error) {
+	err = db.observe(ctx, "user_tokens", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		tokens, err = us.UserTokens(ctx, id)
+		return err
+	})
+	return tokens, err
+}
+
+func (db *Database) RemoveUserToken(ctx context.Context, id, token string) error {
+	return db.observe(ctx, "remove_user_token", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		return us.RemoveUserToken(ctx, id, token)
+	})
+}
+
+func (db *Database) UserByToken(ctx context.Context, hash string) (u database.User, err error) {
+	err = db.observe(ctx, "user_by_token", "", func(ctx context.Context) error {
+		us, ok := db.db.(database.UserStore)
+		if !ok {
+			return database.ErrUnsupported
+		}
+		u, err = us.UserByToken(ctx, hash)
+		return err
+	})
+	return u, err
+}
+
+// Expires reports whether the wrapped database removes expired entries.
+func (db *Database) Expires() bool {
+	d, ok := db.db.(database.Expirer)
+	return ok && d.Expires()
+}
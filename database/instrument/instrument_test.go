@@ -0,0 +1,204 @@
+package instrument_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/instrument"
+	"github.com/uhthomas/kipp/database/memory"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := instrument.New(memory.New(), prometheus.NewRegistry(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+type key struct{}
+
+// fake is a database whose lookups take delay, block until unblocked is
+// closed, and fail with err.
+type fake struct {
+	database.Database
+	delay     time.Duration
+	unblocked chan struct{}
+	err       error
+	ctx       context.Context
+}
+
+func (f *fake) Lookup(ctx context.Context, slug string) (database.Entry, error) {
+	f.ctx = ctx
+	time.Sleep(f.delay)
+	<-f.unblocked
+	return database.Entry{Slug: slug}, f.err
+}
+
+func TestDatabase(t *testing.T) {
+	errFake := errors.New("fake")
+
+	r := prometheus.NewRegistry()
+	f := &fake{Database: memory.New(), delay: 10 * time.Millisecond, unblocked: make(chan struct{})}
+	db, err := instrument.New(f, r, "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another database shares the metrics, with its own label.
+	mdb, err := instrument.New(memory.New(), r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Lookup(context.Background(), "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	for _, want := range []error{errFake, database.ErrNoResults, nil} {
+		f.err = want
+		done := make(chan error)
+		go func() {
+			_, err := db.Lookup(ctx, "slug")
+			done <- err
+		}()
+		for testutil.ToFloat64(inFlight(t, r, "fake")) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		close(f.unblocked)
+		if err := <-done; err != want {
+			t.Fatalf("unexpected error; got %v, want %v", err, want)
+		}
+		if f.ctx != ctx {
+			t.Fatal("context was not passed through")
+		}
+		f.unblocked = make(chan struct{})
+	}
+	if n := testutil.ToFloat64(inFlight(t, r, "fake")); n != 0 {
+		t.Fatalf("unexpected calls in flight; got %v, want 0", n)
+	}
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]float64{
+		"kipp_database_errors_total": {"fake": 1},
+		// The sample count, checked along with the sum.
+		"kipp_database_request_duration_seconds": {"fake": 3, "memory": 1},
+	}
+	for _, mf := range mfs {
+		w, ok := want[mf.GetName()]
+		if !ok {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var backend, method string
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "backend":
+					backend = l.GetValue()
+				case "method":
+					method = l.GetValue()
+				}
+			}
+			if method != "lookup" {
+				t.Fatalf("unexpected method %q", method)
+			}
+			got := m.GetCounter().GetValue()
+			if h := m.GetHistogram(); h != nil {
+				got = float64(h.GetSampleCount())
+				if backend == "fake" && h.GetSampleSum() < 3*f.delay.Seconds() {
+					t.Fatalf("unexpected duration; got %vs, want at least %vs", h.GetSampleSum(), 3*f.delay.Seconds())
+				}
+			}
+			if got != w[backend] {
+				t.Fatalf("unexpected %s for %s; got %v, want %v", mf.GetName(), backend, got, w[backend])
+			}
+			delete(w, backend)
+		}
+		if len(w) > 0 {
+			t.Fatalf("missing %s for %v", mf.GetName(), w)
+		}
+		delete(want, mf.GetName())
+	}
+	if len(want) > 0 {
+		t.Fatalf("missing metrics %v", want)
+	}
+}
+
+// inFlight returns the in-flight gauge of lookups for the backend.
+func inFlight(t *testing.T, r *prometheus.Registry, backend string) prometheus.Collector {
+	t.Helper()
+	v := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kipp",
+		Subsystem: "database",
+		Name:      "requests_in_flight",
+		Help:      "Number of database calls in progress.",
+	}, []string{"backend", "method"})
+	var are prometheus.AlreadyRegisteredError
+	if err := r.Register(v); !errors.As(err, &are) {
+		t.Fatalf("unexpected error; got %v, want %T", err, are)
+	}
+	return are.ExistingCollector.(*prometheus.GaugeVec).WithLabelValues(backend, "lookup")
+}
+
+func TestTracer(t *testing.T) {
+	ctx := context.Background()
+
+	errFake := errors.New("fake")
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	f := &fake{Database: memory.New(), unblocked: make(chan struct{}), err: errFake}
+	close(f.unblocked)
+	// Calls are traced without metrics.
+	db, err := instrument.New(f, nil, "fake", instrument.Tracer(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Lookup(ctx, "slug"); !errors.Is(err, errFake) {
+		t.Fatalf("unexpected error; got %v, want %v", err, errFake)
+	}
+	// Lookups which find nothing aren't errors.
+	f.err = database.ErrNoResults
+	if _, err := db.Lookup(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("unexpected spans; got %d, want 2", len(spans))
+	}
+	if got := spans[1].Status().Code; got != codes.Unset {
+		t.Fatalf("unexpected status; got %v, want %v", got, codes.Unset)
+	}
+	span := spans[0]
+	if span.Name() != "database.lookup" || span.Status().Code != codes.Error {
+		t.Fatalf("unexpected span; got %s with %v", span.Name(), span.Status())
+	}
+	// The wrapped database is called with the span's context.
+	if got := trace.SpanContextFromContext(f.ctx); !got.Equal(spans[1].SpanContext()) {
+		t.Fatalf("unexpected context; got span %v, want %v", got.SpanID(), spans[1].SpanContext().SpanID())
+	}
+	want := []attribute.KeyValue{
+		attribute.String("kipp.database.backend", "fake"),
+		attribute.String("kipp.slug", "slug"),
+	}
+	if got := span.Attributes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected attributes; got %v, want %v", got, want)
+	}
+}
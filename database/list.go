@@ -0,0 +1,149 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultListLimit is the number of entries listed when no limit is given.
+const DefaultListLimit = 100
+
+// ErrInvalidCursor is returned by List when the cursor can't be parsed.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions configures List. Entries are ordered by timestamp, and then by
+// slug for entries created at the same time.
+type ListOptions struct {
+	// Limit is the maximum number of entries to list, or DefaultListLimit
+	// if zero.
+	Limit int
+	// Cursor continues a previous listing from where it left off.
+	Cursor string
+	// Descending lists the newest entries first.
+	Descending bool
+	// Expired only lists entries whose lifetime has ended.
+	Expired bool
+	// CreatedBefore and CreatedAfter, when non-zero, only list entries
+	// created before or after them.
+	CreatedBefore, CreatedAfter time.Time
+	// NamePrefix only lists entries whose names start with it.
+	NamePrefix string
+	// Deleted only lists soft deleted entries.
+	Deleted bool
+	// Tag, when non-empty, only lists entries with it.
+	Tag string
+	// Owner, when non-empty, only lists entries owned by the user with it
+	// as their ID.
+	Owner string
+}
+
+// Size returns the maximum number of entries to list.
+func (o ListOptions) Size() int {
+	if o.Limit <= 0 {
+		return DefaultListLimit
+	}
+	return o.Limit
+}
+
+// Match reports whether e passes the filters in o, given the current time.
+// The cursor is not considered.
+func (o ListOptions) Match(e Entry, now time.Time) bool {
+	if o.Expired && (e.Lifetime == nil || e.Lifetime.After(now)) {
+		return false
+	}
+	if o.Deleted && e.Deleted == nil {
+		return false
+	}
+	if !o.CreatedBefore.IsZero() && !e.Timestamp.Before(o.CreatedBefore) {
+		return false
+	}
+	if !o.CreatedAfter.IsZero() && !e.Timestamp.After(o.CreatedAfter) {
+		return false
+	}
+	if o.Tag != "" && !e.HasTag(o.Tag) {
+		return false
+	}
+	if o.Owner != "" && e.Owner != o.Owner {
+		return false
+	}
+	return strings.HasPrefix(e.Name, o.NamePrefix)
+}
+
+// Page trims entries, which are listed in order and hold at most one more
+// entry than the limit, to the limit. It returns the trimmed entries and the
+// cursor for the next page, which is empty if nothing was trimmed.
+func (o ListOptions) Page(entries []Entry) ([]Entry, string) {
+	if n := o.Size(); len(entries) > n {
+		return entries[:n], CursorOf(entries[n-1]).String()
+	}
+	return entries, ""
+}
+
+// A Cursor is the position of an entry in a listing. Cursors refer to
+// positions rather than entries, so they stay valid as entries are created
+// and deleted.
+type Cursor struct {
+	Timestamp time.Time
+	Slug      string
+}
+
+// CursorOf returns the cursor for e.
+func CursorOf(e Entry) Cursor { return Cursor{Timestamp: e.Timestamp, Slug: e.Slug} }
+
+// ParseCursor parses a cursor returned by Cursor.String. The zero Cursor is
+// returned for an empty string.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	i := strings.IndexByte(string(b), '/')
+	if i < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(string(b[:i]), 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Timestamp: time.Unix(0, n).UTC(), Slug: string(b[i+1:])}, nil
+}
+
+// String encodes c as an opaque string.
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(strconv.FormatInt(c.Timestamp.UnixNano(), 10) + "/" + c.Slug),
+	)
+}
+
+// IsZero reports whether c is the zero Cursor, which is before every entry.
+func (c Cursor) IsZero() bool { return c.Timestamp.IsZero() && c.Slug == "" }
+
+// Compare returns -1, 0 or 1 if c is ordered before, at or after d.
+func (c Cursor) Compare(d Cursor) int {
+	switch {
+	case c.Timestamp.Before(d.Timestamp):
+		return -1
+	case c.Timestamp.After(d.Timestamp):
+		return 1
+	}
+	return strings.Compare(c.Slug, d.Slug)
+}
+
+// Follows reports whether e is listed after the cursor, in the given order.
+// Every entry follows the zero Cursor.
+func (c Cursor) Follows(e Entry, descending bool) bool {
+	if c.IsZero() {
+		return true
+	}
+	n := CursorOf(e).Compare(c)
+	if descending {
+		return n < 0
+	}
+	return n > 0
+}
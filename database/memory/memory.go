@@ -0,0 +1,612 @@
+// Package memory implements a kipp entry database in memory, which is useful
+// for tests and throwaway deployments. A Database is safe for concurrent use.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// Database is a mutex guarded map of entries. Expired entries are never
+// returned, as if they had been removed.
+type Database struct {
+	mu        sync.RWMutex
+	entries   map[string]database.Entry
+	downloads map[string]map[time.Time]int64
+	denials   map[string]database.Denial
+	reports   []database.Report
+	audit     []database.AuditRecord
+	users     map[string]database.User
+	// tokens maps the hashes of users' tokens to them.
+	tokens map[string]database.UserToken
+	// name is the file snapshots are written to on close, if any.
+	name string
+}
+
+// New returns a new, empty Database.
+func New() *Database {
+	return &Database{
+		entries:   make(map[string]database.Entry),
+		downloads: make(map[string]map[time.Time]int64),
+		denials:   make(map[string]database.Denial),
+		users:     make(map[string]database.User),
+		tokens:    make(map[string]database.UserToken),
+	}
+}
+
+// snapshot is the JSON representation of a Database.
+type snapshot struct {
+	Entries   []database.Entry
+	Downloads map[string][]database.Downloads
+	Denials   []database.Denial
+	Reports   []database.Report
+	Audit     []database.AuditRecord
+	Users     []database.User
+	Tokens    []database.UserToken
+}
+
+// Load returns a new Database with the contents of the named JSON snapshot,
+// which is rewritten on close. The database is empty if the snapshot doesn't
+// exist yet.
+func Load(name string) (*Database, error) {
+	db := New()
+	db.name = name
+	b, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return db, nil
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("json unmarshal: %w", err)
+	}
+	for _, e := range s.Entries {
+		db.entries[e.Slug] = e
+	}
+	for slug, d := range s.Downloads {
+		m := make(map[time.Time]int64, len(d))
+		for _, v := range d {
+			m[v.Day] = v.Count
+		}
+		db.downloads[slug] = m
+	}
+	for _, d := range s.Denials {
+		db.denials[d.Sum] = d
+	}
+	db.reports, db.audit = s.Reports, s.Audit
+	for _, u := range s.Users {
+		db.users[u.ID] = u
+	}
+	for _, t := range s.Tokens {
+		db.tokens[t.Hash] = t
+	}
+	return db, nil
+}
+
+// expired reports whether e has outlived its lifetime.
+func expired(e database.Entry) bool {
+	return e.Lifetime != nil && !e.Lifetime.After(time.Now())
+}
+
+// Create stores e, replacing any entry with the same slug.
+func (db *Database) Create(_ context.Context, e database.Entry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.entries[e.Slug] = e
+	return nil
+}
+
+func (db *Database) CreateBatch(_ context.Context, entries []database.Entry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, e := range entries {
+		db.entries[e.Slug] = e
+	}
+	return nil
+}
+
+// Delete deletes the entry with the given slug. Expired entries can still be
+// deleted.
+func (db *Database) Delete(_ context.Context, slug string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.entries[slug]; !ok {
+		return database.ErrNoResults
+	}
+	delete(db.entries, slug)
+	return nil
+}
+
+// Lookup looks up the named entry.
+func (db *Database) Lookup(_ context.Context, slug string) (database.Entry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	e, ok := db.entries[slug]
+	if !ok || expired(e) {
+		return database.Entry{}, database.ErrNoResults
+	}
+	return e, nil
+}
+
+// LookupMany looks up the named entries.
+func (db *Database) LookupMany(_ context.Context, slugs []string) (map[string]database.Entry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	m := make(map[string]database.Entry, len(slugs))
+	for _, slug := range slugs {
+		if e, ok := db.entries[slug]; ok && !expired(e) {
+			m[slug] = e
+		}
+	}
+	return m, nil
+}
+
+// LookupBySum looks up the newest entry with the given sum.
+func (db *Database) LookupBySum(_ context.Context, sum string) (database.Entry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var (
+		newest database.Entry
+		ok     bool
+	)
+	for _, e := range db.entries {
+		if e.Sum != sum || expired(e) {
+			continue
+		}
+		if !ok || database.CursorOf(e).Compare(database.CursorOf(newest)) > 0 {
+			newest, ok = e, true
+		}
+	}
+	if !ok {
+		return database.Entry{}, database.ErrNoResults
+	}
+	return newest, nil
+}
+
+// List lists entries matching opts. Expired entries are listed too, so they
+// can be found and deleted.
+func (db *Database) List(_ context.Context, opts database.ListOptions) ([]database.Entry, string, error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	db.mu.RLock()
+	var entries []database.Entry
+	for _, e := range db.entries {
+		if c.Follows(e, opts.Descending) && opts.Match(e, now) {
+			entries = append(entries, e)
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		n := database.CursorOf(entries[i]).Compare(database.CursorOf(entries[j]))
+		if opts.Descending {
+			return n > 0
+		}
+		return n < 0
+	})
+	if n := opts.Size() + 1; len(entries) > n {
+		entries = entries[:n]
+	}
+	entries, next := opts.Page(entries)
+	return entries, next, nil
+}
+
+// update applies f to the named entry.
+func (db *Database) update(slug string, f func(e *database.Entry)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, ok := db.entries[slug]
+	if !ok || expired(e) {
+		return database.ErrNoResults
+	}
+	f(&e)
+	db.entries[slug] = e
+	return nil
+}
+
+// Touch sets the last access time of the named entry to t.
+func (db *Database) Touch(_ context.Context, slug string, t time.Time) error {
+	return db.update(slug, func(e *database.Entry) { e.LastAccess = &t })
+}
+
+// Extend sets the lifetime of the named entry to t if it has a lifetime which
+// ends before t.
+func (db *Database) Extend(_ context.Context, slug string, t time.Time) error {
+	return db.update(slug, func(e *database.Entry) {
+		if e.Lifetime != nil && e.Lifetime.Before(t) {
+			e.Lifetime = &t
+		}
+	})
+}
+
+// SetLifetime sets the lifetime of the named entry to t, or removes it if t
+// is nil.
+func (db *Database) SetLifetime(_ context.Context, slug string, t *time.Time) error {
+	return db.update(slug, func(e *database.Entry) { e.Lifetime = t })
+}
+
+// SoftDelete marks the named entry as deleted at t for the given reason.
+func (db *Database) SoftDelete(_ context.Context, slug string, t time.Time, reason string) error {
+	return db.update(slug, func(e *database.Entry) { e.Deleted, e.DeleteReason = &t, reason })
+}
+
+// Restore unmarks the named entry as deleted.
+func (db *Database) Restore(_ context.Context, slug string) error {
+	return db.update(slug, func(e *database.Entry) { e.Deleted, e.DeleteReason = nil, "" })
+}
+
+// Block marks the named entry as blocked at t for the given reason.
+func (db *Database) Block(_ context.Context, slug string, t time.Time, reason string) error {
+	return db.update(slug, func(e *database.Entry) { e.Blocked, e.BlockReason = &t, reason })
+}
+
+// Unblock unmarks the named entry as blocked.
+func (db *Database) Unblock(_ context.Context, slug string) error {
+	return db.update(slug, func(e *database.Entry) { e.Blocked, e.BlockReason = nil, "" })
+}
+
+// Rename sets the name of the named entry.
+func (db *Database) Rename(_ context.Context, slug, name string) error {
+	return db.update(slug, func(e *database.Entry) { e.Name = name })
+}
+
+// AddDownloads adds n to the download count of the named entry for the day
+// containing t.
+func (db *Database) AddDownloads(_ context.Context, slug string, t time.Time, n int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, ok := db.downloads[slug]
+	if !ok {
+		m = make(map[time.Time]int64)
+		db.downloads[slug] = m
+	}
+	m[database.Day(t)] += n
+	return nil
+}
+
+// Downloads returns the download counts of the named entry for each day
+// since t, oldest first.
+func (db *Database) Downloads(_ context.Context, slug string, t time.Time) ([]database.Downloads, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return downloads(db.downloads[slug], database.Day(t)), nil
+}
+
+func downloads(m map[time.Time]int64, since time.Time) []database.Downloads {
+	var d []database.Downloads
+	for day, n := range m {
+		if !day.Before(since) {
+			d = append(d, database.Downloads{Day: day, Count: n})
+		}
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i].Day.Before(d[j].Day) })
+	return d
+}
+
+// RemoveDownloads removes the download counts of the named entry.
+func (db *Database) RemoveDownloads(_ context.Context, slug string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.downloads, slug)
+	return nil
+}
+
+// Deny adds d to the denylist, replacing any denial of the same sum.
+func (db *Database) Deny(_ context.Context, d database.Denial) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.denials[d.Sum] = d
+	return nil
+}
+
+// Denied returns the denial of sum.
+func (db *Database) Denied(_ context.Context, sum string) (database.Denial, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	d, ok := db.denials[sum]
+	if !ok {
+		return database.Denial{}, database.ErrNoResults
+	}
+	return d, nil
+}
+
+// Allow removes the denial of sum.
+func (db *Database) Allow(_ context.Context, sum string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.denials[sum]; !ok {
+		return database.ErrNoResults
+	}
+	delete(db.denials, sum)
+	return nil
+}
+
+// Denials returns every denial, oldest first.
+func (db *Database) Denials(context.Context) ([]database.Denial, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sortedDenials(), nil
+}
+
+// sortedDenials returns every denial, oldest first. The lock must be held.
+func (db *Database) sortedDenials() []database.Denial {
+	d := make([]database.Denial, 0, len(db.denials))
+	for _, v := range db.denials {
+		d = append(d, v)
+	}
+	sort.Slice(d, func(i, j int) bool {
+		if !d[i].Time.Equal(d[j].Time) {
+			return d[i].Time.Before(d[j].Time)
+		}
+		return d[i].Sum < d[j].Sum
+	})
+	return d
+}
+
+// Report stores r.
+func (db *Database) Report(_ context.Context, r database.Report) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.reports = append(db.reports, r)
+	return nil
+}
+
+// Reports returns the reports with status, or every report if it's empty,
+// oldest first.
+func (db *Database) Reports(_ context.Context, status database.ReportStatus) ([]database.Report, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sortedReports(status), nil
+}
+
+// sortedReports returns the reports with status, or every report if it's
+// empty, oldest first. The lock must be held.
+func (db *Database) sortedReports(status database.ReportStatus) []database.Report {
+	var r []database.Report
+	for _, v := range db.reports {
+		if status == "" || v.Status == status {
+			r = append(r, v)
+		}
+	}
+	sort.SliceStable(r, func(i, j int) bool { return r[i].Time.Before(r[j].Time) })
+	return r
+}
+
+// ResolveReports resolves the open reports of the named entry.
+func (db *Database) ResolveReports(_ context.Context, slug string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var n int
+	for i, r := range db.reports {
+		if r.Slug == slug && r.Status == database.ReportOpen {
+			db.reports[i].Status = database.ReportResolved
+			n++
+		}
+	}
+	if n == 0 {
+		return database.ErrNoResults
+	}
+	return nil
+}
+
+// Audit appends r to the audit log.
+func (db *Database) Audit(_ context.Context, r database.AuditRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.audit = append(db.audit, r)
+	return nil
+}
+
+// AuditLog lists records matching opts, newest first.
+func (db *Database) AuditLog(_ context.Context, opts database.AuditOptions) ([]database.AuditRecord, string, error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	db.mu.RLock()
+	var records []database.AuditRecord
+	for _, r := range db.audit {
+		if opts.Match(r) && (c.IsZero() || database.AuditCursorOf(r).Compare(c) < 0) {
+			records = append(records, r)
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(records, func(i, j int) bool {
+		return database.AuditCursorOf(records[i]).Compare(database.AuditCursorOf(records[j])) > 0
+	})
+	if n := opts.Size() + 1; len(records) > n {
+		records = records[:n]
+	}
+	records, next := opts.Page(records)
+	return records, next, nil
+}
+
+// CreateUser stores u, returning database.ErrConflict if there's already a
+// user with its ID.
+func (db *Database) CreateUser(_ context.Context, u database.User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[u.ID]; ok {
+		return database.ErrConflict
+	}
+	db.users[u.ID] = u
+	return nil
+}
+
+// User returns the user with id.
+func (db *Database) User(_ context.Context, id string) (database.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	u, ok := db.users[id]
+	if !ok {
+		return database.User{}, database.ErrNoResults
+	}
+	return u, nil
+}
+
+// Users returns every user, oldest first.
+func (db *Database) Users(context.Context) ([]database.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sortedUsers(), nil
+}
+
+// sortedUsers returns every user, oldest first. The lock must be held.
+func (db *Database) sortedUsers() []database.User {
+	u := make([]database.User, 0, len(db.users))
+	for _, v := range db.users {
+		u = append(u, v)
+	}
+	sort.Slice(u, func(i, j int) bool {
+		if !u[i].Created.Equal(u[j].Created) {
+			return u[i].Created.Before(u[j].Created)
+		}
+		return u[i].ID < u[j].ID
+	})
+	return u
+}
+
+// DeleteUser deletes the user with id, and its tokens.
+func (db *Database) DeleteUser(_ context.Context, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[id]; !ok {
+		return database.ErrNoResults
+	}
+	delete(db.users, id)
+	for hash, t := range db.tokens {
+		if t.User == id {
+			delete(db.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// AddUserToken stores t, returning database.ErrNoResults if its user doesn't
+// exist, and database.ErrConflict if it has a token with the same ID or hash.
+func (db *Database) AddUserToken(_ context.Context, t database.UserToken) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[t.User]; !ok {
+		return database.ErrNoResults
+	}
+	if _, ok := db.tokens[t.Hash]; ok {
+		return database.ErrConflict
+	}
+	for _, v := range db.tokens {
+		if v.User == t.User && v.ID == t.ID {
+			return database.ErrConflict
+		}
+	}
+	db.tokens[t.Hash] = t
+	return nil
+}
+
+// UserTokens returns the tokens of the user with id, oldest first.
+func (db *Database) UserTokens(_ context.Context, id string) ([]database.UserToken, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if _, ok := db.users[id]; !ok {
+		return nil, database.ErrNoResults
+	}
+	var tokens []database.UserToken
+	for _, t := range db.tokens {
+		if t.User == id {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].Created.Before(tokens[j].Created)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// RemoveUserToken removes the token of the user with id whose ID is token.
+func (db *Database) RemoveUserToken(_ context.Context, id, token string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for hash, t := range db.tokens {
+		if t.User == id && t.ID == token {
+			delete(db.tokens, hash)
+			return nil
+		}
+	}
+	return database.ErrNoResults
+}
+
+// UserByToken returns the user with the token whose hash is hash.
+func (db *Database) UserByToken(_ context.Context, hash string) (database.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	t, ok := db.tokens[hash]
+	if !ok {
+		return database.User{}, database.ErrNoResults
+	}
+	return db.users[t.User], nil
+}
+
+// Ping does nothing, as there is nothing to reach.
+func (db *Database) Ping(context.Context) error { return nil }
+
+// Close writes a snapshot if the database was loaded from one.
+func (db *Database) Close(context.Context) error {
+	if db.name == "" {
+		return nil
+	}
+	db.mu.RLock()
+	s := snapshot{Downloads: make(map[string][]database.Downloads, len(db.downloads))}
+	for _, e := range db.entries {
+		s.Entries = append(s.Entries, e)
+	}
+	for slug, m := range db.downloads {
+		s.Downloads[slug] = downloads(m, time.Time{})
+	}
+	s.Denials = db.sortedDenials()
+	s.Reports = db.sortedReports("")
+	s.Audit = db.audit
+	s.Users = db.sortedUsers()
+	for _, t := range db.tokens {
+		s.Tokens = append(s.Tokens, t)
+	}
+	db.mu.RUnlock()
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+	// Write to a temporary file first so a failed write never clobbers the
+	// previous snapshot.
+	f, err := os.CreateTemp(filepath.Dir(db.name), filepath.Base(db.name)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	if err := os.Rename(f.Name(), db.name); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+)
+
+func TestDatabase(t *testing.T) {
+	databasetest.Run(t, func(*testing.T) database.Database { return memory.New() })
+}
+
+func TestExpired(t *testing.T) {
+	ctx := context.Background()
+
+	db := memory.New()
+	e := databasetest.NewEntry("expired")
+	l := time.Now().Add(-time.Minute)
+	e.Lifetime = &l
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Lookup(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	name := filepath.Join(t.TempDir(), "kipp.json")
+	db, err := memory.Load(name)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	e := databasetest.NewEntry("snapshot")
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	day := database.Day(time.Now())
+	if err := db.AddDownloads(ctx, e.Slug, day, 3); err != nil {
+		t.Fatalf("add downloads: %v", err)
+	}
+	if err := db.Deny(ctx, database.Denial{Sum: e.Sum, Reason: "abuse", Time: day}); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	if err := db.Report(ctx, database.Report{Slug: e.Slug, Category: "malware", Status: database.ReportOpen, Time: day}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if err := db.Audit(ctx, database.AuditRecord{ID: "a", Time: day, Action: "delete", Target: e.Slug}); err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	if err := db.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if db, err = memory.Load(name); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got, err := db.Lookup(ctx, e.Slug)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if !databasetest.Equal(got, e) {
+		t.Fatalf("entries are not equal; got %#v, want %#v", got, e)
+	}
+	d, err := db.Downloads(ctx, e.Slug, day)
+	if err != nil {
+		t.Fatalf("downloads: %v", err)
+	}
+	if len(d) != 1 || !d[0].Day.Equal(day) || d[0].Count != 3 {
+		t.Fatalf("unexpected downloads; got %v", d)
+	}
+	if denial, err := db.Denied(ctx, e.Sum); err != nil || denial.Reason != "abuse" {
+		t.Fatalf("unexpected denial; got %+v, %v", denial, err)
+	}
+	if r, err := db.Reports(ctx, database.ReportOpen); err != nil || len(r) != 1 || r[0].Category != "malware" {
+		t.Fatalf("unexpected reports; got %+v, %v", r, err)
+	}
+	if r, _, err := db.AuditLog(ctx, database.AuditOptions{}); err != nil || len(r) != 1 || r[0].Action != "delete" {
+		t.Fatalf("unexpected audit log; got %+v, %v", r, err)
+	}
+}
@@ -0,0 +1,441 @@
+// Package mongo implements a kipp entry database on MongoDB.
+//
+// Entries are documents with the slug as their _id, so slugs are unique. A TTL
+// index on the lifetime has MongoDB remove expired entries by itself, and an
+// index on the sum allows looking entries up by their contents. Times are
+// stored as BSON dates, which have millisecond precision.
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// Database is an abstraction over the entries and downloads collections of a
+// MongoDB database.
+type Database struct {
+	client    *mongo.Client
+	entries   *mongo.Collection
+	downloads *mongo.Collection
+}
+
+// Open connects to the MongoDB deployment at uri, and ensures the indexes
+// exist. The database is named by the uri, or is kipp if it isn't. Calls are
+// given a deadline of 10 seconds unless the uri sets timeoutMS or the context
+// already has one.
+func Open(ctx context.Context, uri string) (*Database, error) {
+	cs, err := connstring.ParseAndValidate(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse connection string: %w", err)
+	}
+	name := cs.Database
+	if name == "" {
+		name = "kipp"
+	}
+	opts := options.Client().ApplyURI(uri)
+	if opts.Timeout == nil {
+		opts.SetTimeout(10 * time.Second)
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	db := New(client.Database(name))
+	if err := db.ensureIndexes(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return db, nil
+}
+
+// New returns a new Database using the entries and downloads collections of
+// db. The indexes are not created.
+func New(db *mongo.Database) *Database {
+	return &Database{
+		client:    db.Client(),
+		entries:   db.Collection("entries"),
+		downloads: db.Collection("downloads"),
+	}
+}
+
+func (db *Database) ensureIndexes(ctx context.Context) error {
+	if _, err := db.entries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sum", Value: 1}, {Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}, {Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "lifetime", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}); err != nil {
+		return fmt.Errorf("create entries indexes: %w", err)
+	}
+	if _, err := db.downloads.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "slug", Value: 1}, {Key: "day", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("create downloads index: %w", err)
+	}
+	return nil
+}
+
+// Drop drops the database, and everything in it.
+func (db *Database) Drop(ctx context.Context) error {
+	if err := db.entries.Database().Drop(ctx); err != nil {
+		return fmt.Errorf("drop: %w", err)
+	}
+	return nil
+}
+
+// document is the MongoDB representation of an entry.
+type document struct {
+	Slug         string     `bson:"_id"`
+	Name         string     `bson:"name"`
+	Sum          string     `bson:"sum"`
+	Size         int64      `bson:"size"`
+	Lifetime     *time.Time `bson:"lifetime,omitempty"`
+	Timestamp    time.Time  `bson:"timestamp"`
+	LastAccess   *time.Time `bson:"last_access,omitempty"`
+	GzipSize     int64      `bson:"gzip_size"`
+	Deleted      *time.Time `bson:"deleted,omitempty"`
+	DeleteReason string     `bson:"delete_reason,omitempty"`
+	Blocked      *time.Time `bson:"blocked,omitempty"`
+	BlockReason  string     `bson:"block_reason,omitempty"`
+	Tags         []string   `bson:"tags,omitempty"`
+	Owner        string     `bson:"owner,omitempty"`
+}
+
+// Create inserts e, returning database.ErrConflict if an entry with the same
+// slug already exists.
+func (db *Database) Create(ctx context.Context, e database.Entry) error {
+	if _, err := db.entries.InsertOne(ctx, document(e)); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return database.ErrConflict
+		}
+		return fmt.Errorf("insert one: %w", err)
+	}
+	return nil
+}
+
+// CreateBatch inserts entries without a transaction, as they need a replica
+// set, so fails with a *database.BatchError if only some are inserted.
+func (db *Database) CreateBatch(ctx context.Context, entries []database.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(entries))
+	for i, e := range entries {
+		docs[i] = document(e)
+	}
+	_, err := db.entries.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return nil
+	}
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return fmt.Errorf("insert many: %w", err)
+	}
+	errs := make([]error, len(entries))
+	for _, we := range bwe.WriteErrors {
+		if we.Index < 0 || we.Index >= len(errs) {
+			return fmt.Errorf("insert many: %w", err)
+		}
+		if mongo.IsDuplicateKeyError(we) {
+			errs[we.Index] = database.ErrConflict
+		} else {
+			errs[we.Index] = fmt.Errorf("insert many: %w", we)
+		}
+	}
+	return &database.BatchError{Errs: errs}
+}
+
+// Delete deletes the entry with the given slug.
+func (db *Database) Delete(ctx context.Context, slug string) error {
+	res, err := db.entries.DeleteOne(ctx, bson.D{{Key: "_id", Value: slug}})
+	if err != nil {
+		return fmt.Errorf("delete one: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return database.ErrNoResults
+	}
+	return nil
+}
+
+// Lookup looks up the named entry.
+func (db *Database) Lookup(ctx context.Context, slug string) (database.Entry, error) {
+	var d document
+	if err := db.entries.FindOne(ctx, bson.D{{Key: "_id", Value: slug}}).Decode(&d); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return database.Entry{}, database.ErrNoResults
+		}
+		return database.Entry{}, fmt.Errorf("find one: %w", err)
+	}
+	return database.Entry(d), nil
+}
+
+// LookupMany looks up the named entries with a single query.
+func (db *Database) LookupMany(ctx context.Context, slugs []string) (map[string]database.Entry, error) {
+	m := make(map[string]database.Entry, len(slugs))
+	if len(slugs) == 0 {
+		return m, nil
+	}
+	cur, err := db.entries.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: slugs}}}})
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var docs []document
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("all: %w", err)
+	}
+	for _, d := range docs {
+		m[d.Slug] = database.Entry(d)
+	}
+	return m, nil
+}
+
+// LookupBySum looks up the newest entry with the given sum.
+func (db *Database) LookupBySum(ctx context.Context, sum string) (database.Entry, error) {
+	var d document
+	if err := db.entries.FindOne(ctx,
+		bson.D{{Key: "sum", Value: sum}},
+		options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}),
+	).Decode(&d); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return database.Entry{}, database.ErrNoResults
+		}
+		return database.Entry{}, fmt.Errorf("find one: %w", err)
+	}
+	return database.Entry(d), nil
+}
+
+// List lists entries matching opts, paging through them by timestamp and
+// slug so concurrent inserts never cause entries to be skipped or repeated.
+func (db *Database) List(ctx context.Context, opts database.ListOptions) ([]database.Entry, string, error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	op, order := "$gt", 1
+	if opts.Descending {
+		op, order = "$lt", -1
+	}
+	filter := bson.D{}
+	if !c.IsZero() {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "timestamp", Value: bson.D{{Key: op, Value: c.Timestamp}}}},
+			bson.D{
+				{Key: "timestamp", Value: c.Timestamp},
+				{Key: "_id", Value: bson.D{{Key: op, Value: c.Slug}}},
+			},
+		}})
+	}
+	if opts.Expired {
+		filter = append(filter, bson.E{Key: "lifetime", Value: bson.D{{Key: "$lte", Value: time.Now()}}})
+	}
+	if opts.Deleted {
+		filter = append(filter, bson.E{Key: "deleted", Value: bson.D{{Key: "$exists", Value: true}}})
+	}
+	var created bson.D
+	if !opts.CreatedBefore.IsZero() {
+		created = append(created, bson.E{Key: "$lt", Value: opts.CreatedBefore})
+	}
+	if !opts.CreatedAfter.IsZero() {
+		created = append(created, bson.E{Key: "$gt", Value: opts.CreatedAfter})
+	}
+	if created != nil {
+		filter = append(filter, bson.E{Key: "timestamp", Value: created})
+	}
+	if opts.NamePrefix != "" {
+		filter = append(filter, bson.E{Key: "name", Value: primitive.Regex{
+			Pattern: "^" + regexp.QuoteMeta(opts.NamePrefix),
+		}})
+	}
+	if opts.Tag != "" {
+		filter = append(filter, bson.E{Key: "tags", Value: opts.Tag})
+	}
+	if opts.Owner != "" {
+		filter = append(filter, bson.E{Key: "owner", Value: opts.Owner})
+	}
+
+	cur, err := db.entries.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int64(opts.Size()+1)),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("find: %w", err)
+	}
+	var docs []document
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, "", fmt.Errorf("all: %w", err)
+	}
+	entries := make([]database.Entry, len(docs))
+	for i, d := range docs {
+		entries[i] = database.Entry(d)
+	}
+	entries, next := opts.Page(entries)
+	return entries, next, nil
+}
+
+// Touch sets the last access time of the named entry to t.
+func (db *Database) Touch(ctx context.Context, slug string, t time.Time) error {
+	return db.update(ctx, slug, bson.D{{Key: "$set", Value: bson.D{{Key: "last_access", Value: t}}}})
+}
+
+// SoftDelete marks the named entry as deleted at t for the given reason.
+func (db *Database) SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.update(ctx, slug, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "deleted", Value: t},
+		{Key: "delete_reason", Value: reason},
+	}}})
+}
+
+// Restore unmarks the named entry as deleted.
+func (db *Database) Restore(ctx context.Context, slug string) error {
+	return db.update(ctx, slug, bson.D{{Key: "$unset", Value: bson.D{
+		{Key: "deleted", Value: ""},
+		{Key: "delete_reason", Value: ""},
+	}}})
+}
+
+// Block marks the named entry as blocked at t for the given reason.
+func (db *Database) Block(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.update(ctx, slug, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "blocked", Value: t},
+		{Key: "block_reason", Value: reason},
+	}}})
+}
+
+// Unblock unmarks the named entry as blocked.
+func (db *Database) Unblock(ctx context.Context, slug string) error {
+	return db.update(ctx, slug, bson.D{{Key: "$unset", Value: bson.D{
+		{Key: "blocked", Value: ""},
+		{Key: "block_reason", Value: ""},
+	}}})
+}
+
+// Rename sets the name of the named entry.
+func (db *Database) Rename(ctx context.Context, slug, name string) error {
+	return db.update(ctx, slug, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}}}})
+}
+
+// SetLifetime sets the lifetime of the named entry to t, or removes it if t
+// is nil.
+func (db *Database) SetLifetime(ctx context.Context, slug string, t *time.Time) error {
+	if t == nil {
+		return db.update(ctx, slug, bson.D{{Key: "$unset", Value: bson.D{{Key: "lifetime", Value: ""}}}})
+	}
+	return db.update(ctx, slug, bson.D{{Key: "$set", Value: bson.D{{Key: "lifetime", Value: *t}}}})
+}
+
+// update applies update to the named entry.
+func (db *Database) update(ctx context.Context, slug string, update bson.D) error {
+	res, err := db.entries.UpdateOne(ctx, bson.D{{Key: "_id", Value: slug}}, update)
+	if err != nil {
+		return fmt.Errorf("update one: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return database.ErrNoResults
+	}
+	return nil
+}
+
+// Extend sets the lifetime of the named entry to t if it has a lifetime which
+// ends before t.
+func (db *Database) Extend(ctx context.Context, slug string, t time.Time) error {
+	res, err := db.entries.UpdateOne(ctx,
+		bson.D{
+			{Key: "_id", Value: slug},
+			{Key: "lifetime", Value: bson.D{{Key: "$lt", Value: t}}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lifetime", Value: t}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("update one: %w", err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	// The entry either doesn't exist, or doesn't need extending.
+	n, err := db.entries.CountDocuments(ctx, bson.D{{Key: "_id", Value: slug}})
+	if err != nil {
+		return fmt.Errorf("count documents: %w", err)
+	}
+	if n == 0 {
+		return database.ErrNoResults
+	}
+	return nil
+}
+
+// AddDownloads adds n to the download count of the named entry for the day
+// containing t.
+func (db *Database) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	if _, err := db.downloads.UpdateOne(ctx,
+		bson.D{{Key: "slug", Value: slug}, {Key: "day", Value: database.Day(t)}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "count", Value: n}}}},
+		options.Update().SetUpsert(true),
+	); err != nil {
+		return fmt.Errorf("update one: %w", err)
+	}
+	return nil
+}
+
+// Downloads returns the download counts of the named entry for each day
+// since t, oldest first.
+func (db *Database) Downloads(ctx context.Context, slug string, t time.Time) ([]database.Downloads, error) {
+	c, err := db.downloads.Find(ctx,
+		bson.D{
+			{Key: "slug", Value: slug},
+			{Key: "day", Value: bson.D{{Key: "$gte", Value: database.Day(t)}}},
+		},
+		options.Find().SetSort(bson.D{{Key: "day", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var docs []struct {
+		Day   time.Time `bson:"day"`
+		Count int64     `bson:"count"`
+	}
+	if err := c.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("all: %w", err)
+	}
+	var d []database.Downloads
+	for _, v := range docs {
+		d = append(d, database.Downloads{Day: v.Day.UTC(), Count: v.Count})
+	}
+	return d, nil
+}
+
+// RemoveDownloads removes the download counts of the named entry.
+func (db *Database) RemoveDownloads(ctx context.Context, slug string) error {
+	if _, err := db.downloads.DeleteMany(ctx, bson.D{{Key: "slug", Value: slug}}); err != nil {
+		return fmt.Errorf("delete many: %w", err)
+	}
+	return nil
+}
+
+// Expires reports that MongoDB removes expired entries.
+func (db *Database) Expires() bool { return true }
+
+// Ping pings the primary.
+func (db *Database) Ping(ctx context.Context) error {
+	return db.client.Ping(ctx, readpref.Primary())
+}
+
+// Close disconnects the client.
+func (db *Database) Close(ctx context.Context) error { return db.client.Disconnect(ctx) }
@@ -0,0 +1,113 @@
+package mongo_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// open opens a database on the MongoDB deployment at the uri in
+// KIPP_TEST_MONGO, such as a test container, which is dropped when the test
+// finishes. The test is skipped if KIPP_TEST_MONGO isn't set.
+func open(t *testing.T) (*mongo.Database, string) {
+	uri := os.Getenv("KIPP_TEST_MONGO")
+	if uri == "" {
+		t.Skip("KIPP_TEST_MONGO not set")
+	}
+	ctx := context.Background()
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Path = fmt.Sprintf("/kipp-test-%d", time.Now().UnixNano())
+	db, err := mongo.Open(ctx, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := db.Drop(ctx); err != nil {
+			t.Error(err)
+		}
+		db.Close(ctx)
+	})
+	return db, u.String()
+}
+
+// TestDatabase creates and drops a database for each test.
+func TestDatabase(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, _ := open(t)
+		return db
+	})
+}
+
+// TestExpires checks entries are expired by a TTL index on their lifetime,
+// which entries without a lifetime are missing from.
+func TestExpires(t *testing.T) {
+	ctx := context.Background()
+
+	db, uri := open(t)
+
+	e := databasetest.NewEntry("expires")
+	forever := databasetest.NewEntry("forever")
+	forever.Lifetime = nil
+	for _, e := range []database.Entry{e, forever} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if err := db.Extend(ctx, forever.Slug, e.Lifetime.Add(time.Hour)); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+
+	client, err := mongodriver.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := client.Database(u.Path[1:]).Collection("entries")
+
+	var indexes []bson.M
+	cur, err := entries.Indexes().List(ctx)
+	if err != nil {
+		t.Fatalf("list indexes: %v", err)
+	}
+	if err := cur.All(ctx, &indexes); err != nil {
+		t.Fatalf("all: %v", err)
+	}
+	var ttl bool
+	for _, index := range indexes {
+		key, _ := index["key"].(bson.M)
+		if _, ok := index["expireAfterSeconds"]; ok && key["lifetime"] != nil {
+			ttl = true
+		}
+	}
+	if !ttl {
+		t.Fatalf("missing TTL index on lifetime; got %v", indexes)
+	}
+
+	n, err := entries.CountDocuments(ctx, bson.M{"_id": forever.Slug, "lifetime": bson.M{"$exists": true}})
+	if err != nil {
+		t.Fatalf("count documents: %v", err)
+	}
+	if n != 0 {
+		t.Fatal("entries without a lifetime must not have one")
+	}
+	if !db.Expires() {
+		t.Fatal("MongoDB should expire entries")
+	}
+}
@@ -0,0 +1,95 @@
+package namecrypt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownKey is returned when a name was encrypted with a key the
+// KeyProvider doesn't have.
+var ErrUnknownKey = errors.New("unknown key")
+
+// A Key is an AES-256 key, identified in the names it encrypts by its ID.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// validate reports whether k can be used to encrypt names.
+func (k Key) validate() error {
+	if k.ID == "" || strings.ContainsAny(k.ID, ":,") {
+		return fmt.Errorf("invalid key id %q", k.ID)
+	}
+	if len(k.Secret) != 32 {
+		return fmt.Errorf("key %s: secret must be 32 bytes, not %d", k.ID, len(k.Secret))
+	}
+	return nil
+}
+
+// A KeyProvider provides the keys names are encrypted with.
+type KeyProvider interface {
+	// Primary returns the key new names are encrypted with.
+	Primary(ctx context.Context) (Key, error)
+	// Key returns the key with the given ID, or an error wrapping
+	// ErrUnknownKey if there isn't one.
+	Key(ctx context.Context, id string) (Key, error)
+}
+
+// Keys is a KeyProvider for a fixed set of keys. The first key is primary,
+// and the others are kept to decrypt names until they're re-encrypted.
+type Keys []Key
+
+// ParseKeys parses comma or newline separated keys of the form id:secret,
+// where secret is 32 bytes encoded with standard base64. IDs must be unique,
+// and can't contain ':' or ','.
+func ParseKeys(s string) (Keys, error) {
+	var keys Keys
+	seen := make(map[string]bool)
+	for _, kv := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid key %q, want id:secret", kv)
+		}
+		secret, err := base64.StdEncoding.DecodeString(kv[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("key %s: decode secret: %w", kv[:i], err)
+		}
+		k := Key{ID: kv[:i], Secret: secret}
+		if err := k.validate(); err != nil {
+			return nil, err
+		}
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		seen[k.ID] = true
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+	return keys, nil
+}
+
+// Primary returns the first key.
+func (k Keys) Primary(context.Context) (Key, error) {
+	if len(k) == 0 {
+		return Key{}, errors.New("no keys")
+	}
+	return k[0], nil
+}
+
+// Key returns the key with the given ID.
+func (k Keys) Key(_ context.Context, id string) (Key, error) {
+	for _, key := range k {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+	return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, id)
+}
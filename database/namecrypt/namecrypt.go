@@ -0,0 +1,514 @@
+// Package namecrypt encrypts the names of entries, so they can't be read from
+// the database without a key.
+//
+// Names are sealed with AES-256-GCM, using the slug of their entry as
+// additional data so they can't be moved between entries. Everything else is
+// stored as it is, so lookups by slug and sum keep working.
+package namecrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// ErrDecrypt is returned when a name can't be decrypted, because it was
+// encrypted with a different key with the same ID or was tampered with.
+var ErrDecrypt = errors.New("decrypt name")
+
+// prefix begins encrypted names, which are followed by the key ID, a ':' and
+// the URL safe base64 encoded nonce and ciphertext.
+const prefix = "enc:"
+
+// Database wraps a database.Database, encrypting names as they're written and
+// decrypting them as they're read. Names written before encryption was
+// enabled are read as they are, until Reencrypt encrypts them.
+//
+// Database implements every optional interface in package database. Methods
+// of interfaces the wrapped database doesn't implement return
+// database.ErrUnsupported, so capabilities must be checked before wrapping.
+// SearchByName always does, as encrypted names can only be searched by
+// listing them.
+type Database struct {
+	db   database.Database
+	keys KeyProvider
+}
+
+// New wraps db, encrypting names with keys.
+func New(db database.Database, keys KeyProvider) *Database {
+	return &Database{db: db, keys: keys}
+}
+
+// Unwrap returns the wrapped database.
+func (db *Database) Unwrap() database.Database { return db.db }
+
+// aead returns the cipher for k.
+func aead(k Key) (cipher.AEAD, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	b, err := aes.NewCipher(k.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	return cipher.NewGCM(b)
+}
+
+// seal encrypts the name of the entry with the given slug.
+func (db *Database) seal(ctx context.Context, slug, name string) (string, error) {
+	k, err := db.keys.Primary(ctx)
+	if err != nil {
+		return "", fmt.Errorf("primary key: %w", err)
+	}
+	a, err := aead(k)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, a.NonceSize(), a.NonceSize()+len(name)+a.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("read nonce: %w", err)
+	}
+	b := a.Seal(nonce, nonce, []byte(name), []byte(slug))
+	return prefix + k.ID + ":" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// open decrypts the name of the entry with the given slug. Names which
+// aren't encrypted are returned as they are.
+func (db *Database) open(ctx context.Context, slug, name string) (string, error) {
+	id, data, ok := parse(name)
+	if !ok {
+		return name, nil
+	}
+	k, err := db.keys.Key(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", slug, err)
+	}
+	a, err := aead(k)
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(b) < a.NonceSize() {
+		return "", fmt.Errorf("%s: %w: malformed", slug, ErrDecrypt)
+	}
+	b, err = a.Open(nil, b[:a.NonceSize()], b[a.NonceSize():], []byte(slug))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w with key %q: %v", slug, ErrDecrypt, id, err)
+	}
+	return string(b), nil
+}
+
+// parse splits an encrypted name into its key ID and data, reporting whether
+// it's encrypted.
+func parse(name string) (id, data string, ok bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return "", "", false
+	}
+	i := strings.IndexByte(name[len(prefix):], ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return name[len(prefix) : len(prefix)+i], name[len(prefix)+i+1:], true
+}
+
+func (db *Database) sealEntry(ctx context.Context, e database.Entry) (database.Entry, error) {
+	var err error
+	e.Name, err = db.seal(ctx, e.Slug, e.Name)
+	return e, err
+}
+
+func (db *Database) openEntry(ctx context.Context, e database.Entry) (database.Entry, error) {
+	var err error
+	e.Name, err = db.open(ctx, e.Slug, e.Name)
+	return e, err
+}
+
+func (db *Database) Create(ctx context.Context, e database.Entry) error {
+	e, err := db.sealEntry(ctx, e)
+	if err != nil {
+		return err
+	}
+	return db.db.Create(ctx, e)
+}
+
+func (db *Database) CreateBatch(ctx context.Context, entries []database.Entry) error {
+	sealed := make([]database.Entry, len(entries))
+	for i, e := range entries {
+		var err error
+		if sealed[i], err = db.sealEntry(ctx, e); err != nil {
+			return err
+		}
+	}
+	return db.db.CreateBatch(ctx, sealed)
+}
+
+func (db *Database) Delete(ctx context.Context, slug string) error { return db.db.Delete(ctx, slug) }
+
+func (db *Database) Lookup(ctx context.Context, slug string) (database.Entry, error) {
+	e, err := db.db.Lookup(ctx, slug)
+	if err != nil {
+		return e, err
+	}
+	return db.openEntry(ctx, e)
+}
+
+func (db *Database) LookupMany(ctx context.Context, slugs []string) (map[string]database.Entry, error) {
+	m, err := db.db.LookupMany(ctx, slugs)
+	if err != nil {
+		return nil, err
+	}
+	for slug, e := range m {
+		if m[slug], err = db.openEntry(ctx, e); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (db *Database) LookupBySum(ctx context.Context, sum string) (database.Entry, error) {
+	e, err := db.db.LookupBySum(ctx, sum)
+	if err != nil {
+		return e, err
+	}
+	return db.openEntry(ctx, e)
+}
+
+// List lists entries matching opts. The wrapped database can't match name
+// prefixes, so they're matched here, listing pages until there are enough.
+func (db *Database) List(ctx context.Context, opts database.ListOptions) ([]database.Entry, string, error) {
+	inner := opts
+	inner.NamePrefix = ""
+	var entries []database.Entry
+	for {
+		page, next, err := db.db.List(ctx, inner)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, e := range page {
+			if e, err = db.openEntry(ctx, e); err != nil {
+				return nil, "", err
+			}
+			if strings.HasPrefix(e.Name, opts.NamePrefix) {
+				entries = append(entries, e)
+			}
+		}
+		if opts.NamePrefix == "" {
+			return entries, next, nil
+		}
+		if next == "" || len(entries) > opts.Size() {
+			break
+		}
+		inner.Cursor = next
+	}
+	if n := opts.Size() + 1; len(entries) > n {
+		entries = entries[:n]
+	}
+	entries, next := opts.Page(entries)
+	return entries, next, nil
+}
+
+// SearchByName returns database.ErrUnsupported, as the wrapped database can't
+// search encrypted names.
+func (db *Database) SearchByName(context.Context, string, database.SearchOptions) ([]database.Entry, string, error) {
+	return nil, "", database.ErrUnsupported
+}
+
+func (db *Database) Ping(ctx context.Context) error { return db.db.Ping(ctx) }
+
+func (db *Database) Close(ctx context.Context) error { return db.db.Close(ctx) }
+
+func (db *Database) Rename(ctx context.Context, slug, name string) error {
+	d, ok := db.db.(database.Renamer)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	name, err := db.seal(ctx, slug, name)
+	if err != nil {
+		return err
+	}
+	return d.Rename(ctx, slug, name)
+}
+
+func (db *Database) Touch(ctx context.Context, slug string, t time.Time) error {
+	d, ok := db.db.(database.Toucher)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.Touch(ctx, slug, t)
+}
+
+func (db *Database) Extend(ctx context.Context, slug string, t time.Time) error {
+	d, ok := db.db.(database.Extender)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.Extend(ctx, slug, t)
+}
+
+func (db *Database) SetLifetime(ctx context.Context, slug string, t *time.Time) error {
+	d, ok := db.db.(database.LifetimeSetter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.SetLifetime(ctx, slug, t)
+}
+
+func (db *Database) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	d, ok := db.db.(database.DownloadCounter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.AddDownloads(ctx, slug, t, n)
+}
+
+func (db *Database) Downloads(ctx context.Context, slug string, t time.Time) ([]database.Downloads, error) {
+	d, ok := db.db.(database.DownloadCounter)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	return d.Downloads(ctx, slug, t)
+}
+
+func (db *Database) RemoveDownloads(ctx context.Context, slug string) error {
+	d, ok := db.db.(database.DownloadCounter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.RemoveDownloads(ctx, slug)
+}
+
+func (db *Database) SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error {
+	d, ok := db.db.(database.SoftDeleter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.SoftDelete(ctx, slug, t, reason)
+}
+
+func (db *Database) Restore(ctx context.Context, slug string) error {
+	d, ok := db.db.(database.SoftDeleter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.Restore(ctx, slug)
+}
+
+func (db *Database) Block(ctx context.Context, slug string, t time.Time, reason string) error {
+	d, ok := db.db.(database.Blocker)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.Block(ctx, slug, t, reason)
+}
+
+func (db *Database) Unblock(ctx context.Context, slug string) error {
+	d, ok := db.db.(database.Blocker)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return d.Unblock(ctx, slug)
+}
+
+func (db *Database) Stats(ctx context.Context, now time.Time) (database.Stats, error) {
+	d, ok := db.db.(database.StatsReporter)
+	if !ok {
+		return database.Stats{}, database.ErrUnsupported
+	}
+	return d.Stats(ctx, now)
+}
+
+func (db *Database) Deny(ctx context.Context, d database.Denial) error {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return dl.Deny(ctx, d)
+}
+
+func (db *Database) Denied(ctx context.Context, sum string) (database.Denial, error) {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return database.Denial{}, database.ErrUnsupported
+	}
+	return dl.Denied(ctx, sum)
+}
+
+func (db *Database) Allow(ctx context.Context, sum string) error {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return dl.Allow(ctx, sum)
+}
+
+func (db *Database) Denials(ctx context.Context) ([]database.Denial, error) {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	return dl.Denials(ctx)
+}
+
+func (db *Database) Report(ctx context.Context, r database.Report) error {
+	rp, ok := db.db.(database.Reporter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return rp.Report(ctx, r)
+}
+
+func (db *Database) Reports(ctx context.Context, status database.ReportStatus) ([]database.Report, error) {
+	rp, ok := db.db.(database.Reporter)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	return rp.Reports(ctx, status)
+}
+
+func (db *Database) ResolveReports(ctx context.Context, slug string) error {
+	rp, ok := db.db.(database.Reporter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return rp.ResolveReports(ctx, slug)
+}
+
+func (db *Database) Audit(ctx context.Context, r database.AuditRecord) error {
+	a, ok := db.db.(database.Auditor)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return a.Audit(ctx, r)
+}
+
+func (db *Database) AuditLog(ctx context.Context, opts database.AuditOptions) ([]database.AuditRecord, string, error) {
+	a, ok := db.db.(database.Auditor)
+	if !ok {
+		return nil, "", database.ErrUnsupported
+	}
+	return a.AuditLog(ctx, opts)
+}
+
+func (db *Database) CreateUser(ctx context.Context, u database.User) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return us.CreateUser(ctx, u)
+}
+
+func (db *Database) User(ctx context.Context, id string) (database.User, error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.User{}, database.ErrUnsupported
+	}
+	return us.User(ctx, id)
+}
+
+func (db *Database) Users(ctx context.Context) ([]database.User, error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	return us.Users(ctx)
+}
+
+func (db *Database) DeleteUser(ctx context.Context, id string) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return us.DeleteUser(ctx, id)
+}
+
+func (db *Database) AddUserToken(ctx context.Context, t database.UserToken) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return us.AddUserToken(ctx, t)
+}
+
+func (db *Database) UserTokens(ctx context.Context, id string) ([]database.UserToken, error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	return us.UserTokens(ctx, id)
+}
+
+func (db *Database) RemoveUserToken(ctx context.Context, id, token string) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return us.RemoveUserToken(ctx, id, token)
+}
+
+func (db *Database) UserByToken(ctx context.Context, hash string) (database.User, error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.User{}, database.ErrUnsupported
+	}
+	return us.UserByToken(ctx, hash)
+}
+
+// Expires reports whether the wrapped database removes expired entries.
+func (db *Database) Expires() bool {
+	d, ok := db.db.(database.Expirer)
+	return ok && d.Expires()
+}
+
+// Reencrypt walks every entry, encrypting names which aren't encrypted with
+// the primary key with it, such as after the primary key is rotated or
+// encryption is enabled. It returns the number of names it encrypted. The
+// wrapped database must implement database.Renamer.
+func Reencrypt(ctx context.Context, db *Database) (n int, err error) {
+	r, ok := db.db.(database.Renamer)
+	if !ok {
+		return 0, database.ErrUnsupported
+	}
+	primary, err := db.keys.Primary(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("primary key: %w", err)
+	}
+	opts := database.ListOptions{Limit: 1000}
+	for {
+		entries, next, err := db.db.List(ctx, opts)
+		if err != nil {
+			return n, fmt.Errorf("list: %w", err)
+		}
+		for _, e := range entries {
+			if id, _, ok := parse(e.Name); ok && id == primary.ID {
+				continue
+			}
+			name, err := db.open(ctx, e.Slug, e.Name)
+			if err != nil {
+				return n, err
+			}
+			if name, err = db.seal(ctx, e.Slug, name); err != nil {
+				return n, err
+			}
+			// Entries may have been deleted since they were listed.
+			if err := r.Rename(ctx, e.Slug, name); errors.Is(err, database.ErrNoResults) {
+				continue
+			} else if err != nil {
+				return n, fmt.Errorf("rename %s: %w", e.Slug, err)
+			}
+			n++
+		}
+		if next == "" {
+			return n, nil
+		}
+		opts.Cursor = next
+	}
+}
@@ -0,0 +1,147 @@
+package namecrypt_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/database/namecrypt"
+)
+
+func key(id string, b byte) namecrypt.Key {
+	return namecrypt.Key{ID: id, Secret: bytes.Repeat([]byte{b}, 32)}
+}
+
+func TestConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		return namecrypt.New(memory.New(), namecrypt.Keys{key("a", 1)})
+	})
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+
+	mdb := memory.New()
+	db := namecrypt.New(mdb, namecrypt.Keys{key("a", 1)})
+	e := databasetest.NewEntry("secret")
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	raw, err := mdb.Lookup(ctx, e.Slug)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if strings.Contains(raw.Name, e.Name) || !strings.HasPrefix(raw.Name, "enc:a:") {
+		t.Fatalf("name is not encrypted; got %q", raw.Name)
+	}
+	raw.Name = e.Name
+	if !databasetest.Equal(raw, e) {
+		t.Fatalf("unexpected entry; got %+v, want %+v", raw, e)
+	}
+
+	// The name is bound to the slug, so can't be moved to another entry.
+	moved, _ := mdb.Lookup(ctx, e.Slug)
+	moved.Slug = "moved"
+	if err := mdb.Create(ctx, moved); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Lookup(ctx, moved.Slug); !errors.Is(err, namecrypt.ErrDecrypt) {
+		t.Fatalf("unexpected error; got %v, want %v", err, namecrypt.ErrDecrypt)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	ctx := context.Background()
+
+	mdb := memory.New()
+	e := databasetest.NewEntry("secret")
+	if err := namecrypt.New(mdb, namecrypt.Keys{key("a", 1)}).Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	for _, tt := range []struct {
+		keys namecrypt.Keys
+		want error
+	}{
+		{keys: namecrypt.Keys{key("a", 2)}, want: namecrypt.ErrDecrypt},
+		{keys: namecrypt.Keys{key("b", 1)}, want: namecrypt.ErrUnknownKey},
+	} {
+		db := namecrypt.New(mdb, tt.keys)
+		if _, err := db.Lookup(ctx, e.Slug); !errors.Is(err, tt.want) {
+			t.Fatalf("unexpected error; got %v, want %v", err, tt.want)
+		}
+		if _, _, err := db.List(ctx, database.ListOptions{}); !errors.Is(err, tt.want) {
+			t.Fatalf("unexpected error; got %v, want %v", err, tt.want)
+		}
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	ctx := context.Background()
+
+	mdb := memory.New()
+	// Written before encryption was enabled.
+	legacy := databasetest.NewEntry("legacy")
+	if err := mdb.Create(ctx, legacy); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	old := databasetest.NewEntry("old")
+	if err := namecrypt.New(mdb, namecrypt.Keys{key("a", 1)}).Create(ctx, old); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db := namecrypt.New(mdb, namecrypt.Keys{key("b", 2), key("a", 1)})
+	current := databasetest.NewEntry("current")
+	if err := db.Create(ctx, current); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if n, err := namecrypt.Reencrypt(ctx, db); err != nil || n != 2 {
+		t.Fatalf("reencrypt; got %d, %v, want 2, <nil>", n, err)
+	}
+
+	// The old key is no longer needed.
+	db = namecrypt.New(mdb, namecrypt.Keys{key("b", 2)})
+	for _, want := range []database.Entry{legacy, old, current} {
+		got, err := db.Lookup(ctx, want.Slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if !databasetest.Equal(got, want) {
+			t.Fatalf("unexpected entry; got %+v, want %+v", got, want)
+		}
+	}
+	if n, err := namecrypt.Reencrypt(ctx, db); err != nil || n != 0 {
+		t.Fatalf("reencrypt; got %d, %v, want 0, <nil>", n, err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	keys, err := namecrypt.ParseKeys("new:" + secret + ", old:" + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != "old" {
+		t.Fatalf("unexpected keys; got %+v", keys)
+	}
+
+	for _, s := range []string{
+		"",
+		"new",
+		"new:" + secret[1:],
+		":" + secret,
+		"new:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"new:" + secret + ",new:" + secret,
+	} {
+		if _, err := namecrypt.ParseKeys(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
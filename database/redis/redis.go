@@ -0,0 +1,492 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uhthomas/kipp/database"
+)
+
+// Database stores entries as redis hashes keyed by slug. Entries with a
+// lifetime are expired by redis itself.
+type Database struct{ client redis.UniversalClient }
+
+// Open parses the redis URL, and connects to it. AUTH, TLS (with the rediss
+// scheme) and connection pooling are configured by the URL, as described by
+// redis.ParseURL.
+func Open(ctx context.Context, url string) (*Database, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	db := New(redis.NewClient(opts))
+	if err := db.Ping(ctx); err != nil {
+		db.Close(ctx)
+		return nil, fmt.Errorf("ping: %w", err)
+	}
+	return db, nil
+}
+
+// New returns a new Database using client.
+func New(client redis.UniversalClient) *Database { return &Database{client: client} }
+
+const timeFormat = time.RFC3339Nano
+
+func entryKey(slug string) string { return "kipp:entry:" + slug }
+
+func downloadsKey(slug string) string { return "kipp:downloads:" + slug }
+
+// timestampsKey is the key of a sorted set indexing entries by timestamp. All
+// members have the same score, and are ordered lexically by the zero padded
+// unix nanoseconds of the timestamp followed by the slug. Members of expired
+// entries are removed lazily, by List.
+const timestampsKey = "kipp:timestamps"
+
+// sumKey is the key of a set of the slugs of entries with the given sum.
+// Members of expired entries are removed lazily, by LookupBySum.
+func sumKey(sum string) string { return "kipp:sums:" + sum }
+
+func timestampMember(c database.Cursor) string {
+	return fmt.Sprintf("%019d/%s", c.Timestamp.UnixNano(), c.Slug)
+}
+
+// Create sets the hash for e, and expires it at the end of its lifetime.
+func (db *Database) Create(ctx context.Context, e database.Entry) error {
+	return db.CreateBatch(ctx, []database.Entry{e})
+}
+
+// CreateBatch creates entries in a single MULTI/EXEC transaction.
+func (db *Database) CreateBatch(ctx context.Context, entries []database.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if _, err := db.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for _, e := range entries {
+			p.HSet(ctx, entryKey(e.Slug), encode(e))
+			p.ZAdd(ctx, timestampsKey, redis.Z{Member: timestampMember(database.CursorOf(e))})
+			p.SAdd(ctx, sumKey(e.Sum), e.Slug)
+			if e.Lifetime != nil {
+				p.PExpireAt(ctx, entryKey(e.Slug), *e.Lifetime)
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("tx pipelined: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes the entry with the given slug.
+func (db *Database) Delete(ctx context.Context, slug string) error {
+	v, err := db.client.HMGet(ctx, entryKey(slug), "timestamp", "sum").Result()
+	if err != nil {
+		return fmt.Errorf("hmget: %w", err)
+	}
+	ts, ok := v[0].(string)
+	if !ok {
+		return database.ErrNoResults
+	}
+	sum, _ := v[1].(string)
+	var del *redis.IntCmd
+	if _, err := db.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		del = p.Del(ctx, entryKey(slug))
+		if t, err := time.Parse(timeFormat, ts); err == nil {
+			p.ZRem(ctx, timestampsKey, timestampMember(database.Cursor{Timestamp: t, Slug: slug}))
+		}
+		p.SRem(ctx, sumKey(sum), slug)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("tx pipelined: %w", err)
+	}
+	if del.Val() == 0 {
+		return database.ErrNoResults
+	}
+	return nil
+}
+
+// Lookup looks up the entry with the given slug.
+func (db *Database) Lookup(ctx context.Context, slug string) (database.Entry, error) {
+	m, err := db.client.HGetAll(ctx, entryKey(slug)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return database.Entry{}, database.ErrNoResults
+		}
+		return database.Entry{}, fmt.Errorf("hgetall: %w", err)
+	}
+	if len(m) == 0 {
+		return database.Entry{}, database.ErrNoResults
+	}
+	return decode(slug, m)
+}
+
+// LookupMany looks up the named entries in a single pipeline.
+func (db *Database) LookupMany(ctx context.Context, slugs []string) (map[string]database.Entry, error) {
+	cmds, err := db.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, slug := range slugs {
+			p.HGetAll(ctx, entryKey(slug))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pipelined: %w", err)
+	}
+	entries := make(map[string]database.Entry, len(slugs))
+	for i, cmd := range cmds {
+		m, err := cmd.(*redis.MapStringStringCmd).Result()
+		if err != nil {
+			return nil, fmt.Errorf("hgetall: %w", err)
+		}
+		if len(m) == 0 {
+			continue
+		}
+		if entries[slugs[i]], err = decode(slugs[i], m); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// LookupBySum looks up the newest entry with the given sum.
+func (db *Database) LookupBySum(ctx context.Context, sum string) (newest database.Entry, err error) {
+	slugs, err := db.client.SMembers(ctx, sumKey(sum)).Result()
+	if err != nil {
+		return database.Entry{}, fmt.Errorf("smembers: %w", err)
+	}
+	cmds, err := db.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, slug := range slugs {
+			p.HGetAll(ctx, entryKey(slug))
+		}
+		return nil
+	})
+	if err != nil {
+		return database.Entry{}, fmt.Errorf("pipelined: %w", err)
+	}
+	for i, cmd := range cmds {
+		m, err := cmd.(*redis.MapStringStringCmd).Result()
+		if err != nil {
+			return database.Entry{}, fmt.Errorf("hgetall: %w", err)
+		}
+		// The entry expired, or was replaced.
+		if len(m) == 0 || m["sum"] != sum {
+			if err := db.client.SRem(ctx, sumKey(sum), slugs[i]).Err(); err != nil {
+				return database.Entry{}, fmt.Errorf("srem: %w", err)
+			}
+			continue
+		}
+		e, err := decode(slugs[i], m)
+		if err != nil {
+			return database.Entry{}, err
+		}
+		if newest.Slug == "" || database.CursorOf(e).Compare(database.CursorOf(newest)) > 0 {
+			newest = e
+		}
+	}
+	if newest.Slug == "" {
+		return database.Entry{}, database.ErrNoResults
+	}
+	return newest, nil
+}
+
+// List lists entries matching opts by walking the timestamp index.
+func (db *Database) List(ctx context.Context, opts database.ListOptions) ([]database.Entry, string, error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	from := "-"
+	if opts.Descending {
+		from = "+"
+	}
+	if !c.IsZero() {
+		from = "(" + timestampMember(c)
+	}
+	now := time.Now()
+	var entries []database.Entry
+	for len(entries) <= opts.Size() {
+		r := &redis.ZRangeBy{Min: from, Max: "+", Count: int64(opts.Size() + 1)}
+		cmd := db.client.ZRangeByLex
+		if opts.Descending {
+			r.Min, r.Max = "-", from
+			cmd = db.client.ZRevRangeByLex
+		}
+		members, err := cmd(ctx, timestampsKey, r).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("zrangebylex: %w", err)
+		}
+		if len(members) == 0 {
+			break
+		}
+		from = "(" + members[len(members)-1]
+
+		cmds, err := db.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, m := range members {
+				p.HGetAll(ctx, entryKey(m[20:]))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("pipelined: %w", err)
+		}
+		for i, cmd := range cmds {
+			m, err := cmd.(*redis.MapStringStringCmd).Result()
+			if err != nil {
+				return nil, "", fmt.Errorf("hgetall: %w", err)
+			}
+			var e database.Entry
+			if len(m) > 0 {
+				if e, err = decode(members[i][20:], m); err != nil {
+					return nil, "", err
+				}
+			}
+			// The entry expired, or was replaced.
+			if len(m) == 0 || timestampMember(database.CursorOf(e)) != members[i] {
+				if err := db.client.ZRem(ctx, timestampsKey, members[i]).Err(); err != nil {
+					return nil, "", fmt.Errorf("zrem: %w", err)
+				}
+				continue
+			}
+			if opts.Match(e, now) && len(entries) <= opts.Size() {
+				entries = append(entries, e)
+			}
+		}
+	}
+	entries, next := opts.Page(entries)
+	return entries, next, nil
+}
+
+// Touch sets the last access time of the named entry to t.
+func (db *Database) Touch(ctx context.Context, slug string, t time.Time) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, _ database.Entry) {
+		p.HSet(ctx, entryKey(slug), "last_access", t.Format(timeFormat))
+	})
+}
+
+// Extend sets the lifetime of the named entry to t if it has a lifetime which
+// ends before t, and moves its expiry accordingly.
+func (db *Database) Extend(ctx context.Context, slug string, t time.Time) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, e database.Entry) {
+		if e.Lifetime == nil || !e.Lifetime.Before(t) {
+			return
+		}
+		p.HSet(ctx, entryKey(slug), "lifetime", t.Format(timeFormat))
+		p.PExpireAt(ctx, entryKey(slug), t)
+	})
+}
+
+// SetLifetime sets the lifetime of the named entry to t, or removes it if t
+// is nil, and moves or removes its expiry accordingly.
+func (db *Database) SetLifetime(ctx context.Context, slug string, t *time.Time) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, _ database.Entry) {
+		if t == nil {
+			p.HDel(ctx, entryKey(slug), "lifetime")
+			p.Persist(ctx, entryKey(slug))
+			return
+		}
+		p.HSet(ctx, entryKey(slug), "lifetime", t.Format(timeFormat))
+		p.PExpireAt(ctx, entryKey(slug), *t)
+	})
+}
+
+// SoftDelete marks the named entry as deleted at t for the given reason.
+func (db *Database) SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, _ database.Entry) {
+		p.HSet(ctx, entryKey(slug), "deleted", t.Format(timeFormat), "delete_reason", reason)
+	})
+}
+
+// Restore unmarks the named entry as deleted.
+func (db *Database) Restore(ctx context.Context, slug string) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, _ database.Entry) {
+		p.HDel(ctx, entryKey(slug), "deleted", "delete_reason")
+	})
+}
+
+// Block marks the named entry as blocked at t for the given reason.
+func (db *Database) Block(ctx context.Context, slug string, t time.Time, reason string) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, _ database.Entry) {
+		p.HSet(ctx, entryKey(slug), "blocked", t.Format(timeFormat), "block_reason", reason)
+	})
+}
+
+// Unblock unmarks the named entry as blocked.
+func (db *Database) Unblock(ctx context.Context, slug string) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, _ database.Entry) {
+		p.HDel(ctx, entryKey(slug), "blocked", "block_reason")
+	})
+}
+
+// Rename sets the name of the named entry.
+func (db *Database) Rename(ctx context.Context, slug, name string) error {
+	return db.update(ctx, slug, func(p redis.Pipeliner, _ database.Entry) {
+		p.HSet(ctx, entryKey(slug), "name", name)
+	})
+}
+
+// update reads the named entry and calls f to queue changes to it, which are
+// applied only if the entry didn't change in the meantime. Conflicting
+// updates are retried.
+func (db *Database) update(ctx context.Context, slug string, f func(p redis.Pipeliner, e database.Entry)) error {
+	k := entryKey(slug)
+	for {
+		err := db.client.Watch(ctx, func(tx *redis.Tx) error {
+			m, err := tx.HGetAll(ctx, k).Result()
+			if err != nil {
+				return fmt.Errorf("hgetall: %w", err)
+			}
+			if len(m) == 0 {
+				return database.ErrNoResults
+			}
+			e, err := decode(slug, m)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				f(p, e)
+				return nil
+			})
+			return err
+		}, k)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+}
+
+// AddDownloads adds n to the download count of the named entry for the day
+// containing t.
+func (db *Database) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	day := database.Day(t).Format("2006-01-02")
+	if err := db.client.HIncrBy(ctx, downloadsKey(slug), day, n).Err(); err != nil {
+		return fmt.Errorf("hincrby: %w", err)
+	}
+	return nil
+}
+
+// Downloads returns the download counts of the named entry for each day
+// since t, oldest first.
+func (db *Database) Downloads(ctx context.Context, slug string, t time.Time) ([]database.Downloads, error) {
+	m, err := db.client.HGetAll(ctx, downloadsKey(slug)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("hgetall: %w", err)
+	}
+	since := database.Day(t)
+	var d []database.Downloads
+	for k, v := range m {
+		day, err := time.Parse("2006-01-02", k)
+		if err != nil {
+			return nil, fmt.Errorf("parse day: %w", err)
+		}
+		if day.Before(since) {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse count: %w", err)
+		}
+		d = append(d, database.Downloads{Day: day, Count: n})
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i].Day.Before(d[j].Day) })
+	return d, nil
+}
+
+// RemoveDownloads removes the download counts of the named entry.
+func (db *Database) RemoveDownloads(ctx context.Context, slug string) error {
+	if err := db.client.Del(ctx, downloadsKey(slug)).Err(); err != nil {
+		return fmt.Errorf("del: %w", err)
+	}
+	return nil
+}
+
+// Expires reports that redis removes expired entries.
+func (db *Database) Expires() bool { return true }
+
+// Ping pings redis.
+func (db *Database) Ping(ctx context.Context) error { return db.client.Ping(ctx).Err() }
+
+// Close closes the client.
+func (db *Database) Close(context.Context) error { return db.client.Close() }
+
+// encode returns the hash fields for e.
+func encode(e database.Entry) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":      e.Name,
+		"sum":       e.Sum,
+		"size":      e.Size,
+		"timestamp": e.Timestamp.Format(timeFormat),
+		"gzip_size": e.GzipSize,
+	}
+	if e.Lifetime != nil {
+		m["lifetime"] = e.Lifetime.Format(timeFormat)
+	}
+	if e.LastAccess != nil {
+		m["last_access"] = e.LastAccess.Format(timeFormat)
+	}
+	if e.Deleted != nil {
+		m["deleted"] = e.Deleted.Format(timeFormat)
+		m["delete_reason"] = e.DeleteReason
+	}
+	if e.Blocked != nil {
+		m["blocked"] = e.Blocked.Format(timeFormat)
+		m["block_reason"] = e.BlockReason
+	}
+	// Tags can't contain commas.
+	if len(e.Tags) > 0 {
+		m["tags"] = strings.Join(e.Tags, ",")
+	}
+	if e.Owner != "" {
+		m["owner"] = e.Owner
+	}
+	return m
+}
+
+// decode decodes the hash fields in m.
+func decode(slug string, m map[string]string) (e database.Entry, err error) {
+	e = database.Entry{
+		Slug:         slug,
+		Name:         m["name"],
+		Sum:          m["sum"],
+		DeleteReason: m["delete_reason"],
+		BlockReason:  m["block_reason"],
+		Owner:        m["owner"],
+	}
+	if v := m["tags"]; v != "" {
+		e.Tags = strings.Split(v, ",")
+	}
+	if e.Size, err = strconv.ParseInt(m["size"], 10, 64); err != nil {
+		return database.Entry{}, fmt.Errorf("parse size: %w", err)
+	}
+	if e.Timestamp, err = time.Parse(timeFormat, m["timestamp"]); err != nil {
+		return database.Entry{}, fmt.Errorf("parse timestamp: %w", err)
+	}
+	if v, ok := m["gzip_size"]; ok {
+		if e.GzipSize, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return database.Entry{}, fmt.Errorf("parse gzip size: %w", err)
+		}
+	}
+	for _, v := range []struct {
+		field string
+		out   **time.Time
+	}{
+		{field: "lifetime", out: &e.Lifetime},
+		{field: "last_access", out: &e.LastAccess},
+		{field: "deleted", out: &e.Deleted},
+		{field: "blocked", out: &e.Blocked},
+	} {
+		s, ok := m[v.field]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(timeFormat, s)
+		if err != nil {
+			return database.Entry{}, fmt.Errorf("parse %s: %w", v.field, err)
+		}
+		*v.out = &t
+	}
+	return e, nil
+}
@@ -0,0 +1,87 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/redis"
+)
+
+func TestRedis(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		s := miniredis.RunT(t)
+		db := redis.New(goredis.NewClient(&goredis.Options{Addr: s.Addr()}))
+		t.Cleanup(func() { db.Close(context.Background()) })
+		return db
+	})
+}
+
+func TestRedisExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	s := miniredis.RunT(t)
+	db := redis.New(goredis.NewClient(&goredis.Options{Addr: s.Addr()}))
+	defer db.Close(ctx)
+
+	e := databasetest.NewEntry("expire")
+	extended := databasetest.NewEntry("extended")
+	forever := databasetest.NewEntry("forever")
+	forever.Lifetime = nil
+	for _, e := range []database.Entry{e, extended, forever} {
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if err := db.Extend(ctx, extended.Slug, extended.Lifetime.Add(2*time.Hour)); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if err := db.Extend(ctx, forever.Slug, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if ttl := s.TTL("kipp:entry:" + forever.Slug); ttl != 0 {
+		t.Fatalf("unexpected ttl; got %s, want none", ttl)
+	}
+
+	s.SetTime(time.Now())
+	s.FastForward(2 * time.Hour)
+	if _, err := db.Lookup(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	for _, slug := range []string{extended.Slug, forever.Slug} {
+		if _, err := db.Lookup(ctx, slug); err != nil {
+			t.Fatalf("lookup %s: %v", slug, err)
+		}
+	}
+	if !db.Expires() {
+		t.Fatal("redis should expire entries")
+	}
+}
+
+// TestRedisServer runs against the redis URL in KIPP_TEST_REDIS, which will
+// be flushed between tests.
+func TestRedisServer(t *testing.T) {
+	url := os.Getenv("KIPP_TEST_REDIS")
+	if url == "" {
+		t.Skip("KIPP_TEST_REDIS not set")
+	}
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		opts, err := goredis.ParseURL(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := goredis.NewClient(opts)
+		if err := c.FlushDB(context.Background()).Err(); err != nil {
+			t.Fatal(err)
+		}
+		db := redis.New(c)
+		t.Cleanup(func() { db.Close(context.Background()) })
+		return db
+	})
+}
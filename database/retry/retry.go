@@ -0,0 +1,635 @@
+// Package retry retries database calls which fail transiently.
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+)
+
+// A Class is how an error may be retried.
+type Class int
+
+const (
+	// Permanent errors are never retried.
+	Permanent Class = iota
+	// Transient errors may not recur, but the failed call may have had an
+	// effect, so only idempotent calls are retried.
+	Transient
+	// Unapplied errors may not recur, and the failed call definitely had
+	// no effect, so any call is retried.
+	Unapplied
+)
+
+// Classify classifies errors from the databases in this module. Drivers are
+// matched by their methods, so they needn't be imported.
+func Classify(err error) Class {
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, database.ErrNoResults),
+		errors.Is(err, database.ErrConflict),
+		errors.Is(err, database.ErrUnsupported):
+		return Permanent
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return Unapplied
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return Transient
+	}
+
+	// PostgreSQL
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch s := pgErr.SQLState(); {
+		case s == "40001", s == "40P01": // serialization_failure, deadlock_detected
+			return Unapplied
+		case strings.HasPrefix(s, "08"): // connection_exception
+			return Transient
+		}
+		return Permanent
+	}
+
+	// Cassandra, whose errors also have a Code method so must be matched
+	// before SQLite's.
+	var cqlErr interface {
+		Code() int
+		Message() string
+	}
+	if errors.As(err, &cqlErr) {
+		switch cqlErr.Code() {
+		case 0x1000, 0x1001, 0x1002: // unavailable, overloaded, is_bootstrapping
+			return Unapplied
+		case 0x1100, 0x1200: // write_timeout, read_timeout
+			return Transient
+		}
+		return Permanent
+	}
+
+	// SQLite
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case 5, 6: // SQLITE_BUSY, SQLITE_LOCKED
+			return Unapplied
+		}
+		return Permanent
+	}
+
+	// DynamoDB
+	var awsErr interface{ Code() string }
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case "ProvisionedThroughputExceededException", "ThrottlingException", "RequestLimitExceeded":
+			return Unapplied
+		case "InternalServerError", "ServiceUnavailable":
+			return Transient
+		}
+		return Permanent
+	}
+
+	// MongoDB
+	var mongoErr interface{ HasErrorLabel(string) bool }
+	if errors.As(err, &mongoErr) {
+		switch {
+		case mongoErr.HasErrorLabel("TransientTransactionError"):
+			return Unapplied
+		case mongoErr.HasErrorLabel("RetryableWriteError"),
+			mongoErr.HasErrorLabel("NetworkError"):
+			return Transient
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return Transient
+	}
+	return Permanent
+}
+
+// A Policy configures how calls are retried.
+type Policy struct {
+	// Attempts is the maximum number of attempts of each call, including
+	// the first. Calls are not retried if it's less than two.
+	Attempts int
+	// Backoff is the delay before the first retry, which doubles for each
+	// retry after up to MaxBackoff. Delays are jittered by up to half.
+	Backoff, MaxBackoff time.Duration
+	// Budget limits retries to this fraction of calls once a burst of ten
+	// has been spent, so retries don't overwhelm a struggling database.
+	// Zero is unlimited.
+	Budget float64
+	// Classify classifies errors, or is Classify if nil.
+	Classify func(error) Class
+}
+
+// DefaultPolicy makes up to four attempts over about a second, and retries
+// at most a tenth of calls.
+var DefaultPolicy = Policy{
+	Attempts:   4,
+	Backoff:    50 * time.Millisecond,
+	MaxBackoff: time.Second,
+	Budget:     0.1,
+}
+
+// backoff returns the jittered delay before the nth retry.
+func (p Policy) backoff(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// maxTokens is the size of the burst of retries allowed by a budget.
+const maxTokens = 10
+
+// A budget is a token bucket, refilled by calls and drained by retries.
+type budget struct {
+	mu     sync.Mutex
+	tokens float64
+	ratio  float64
+}
+
+func (b *budget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens += b.ratio; b.tokens > maxTokens {
+		b.tokens = maxTokens
+	}
+}
+
+func (b *budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ratio == 0 {
+		return true
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Database wraps a database.Database, retrying calls which fail transiently.
+// Idempotent calls are retried on Transient errors, and other calls only on
+// Unapplied errors, so they're never applied twice. Retries never outlast
+// the deadline of the call's context.
+//
+// Creates and deletes are retried on Transient errors too, as a retried
+// attempt can tell whether an earlier one was applied. A create which
+// conflicts with an entry with the same sum and timestamp, or a delete of an
+// entry which no longer exists, succeeded earlier.
+//
+// Like instrument.Database, Database implements every optional interface in
+// package database, so capabilities must be checked before wrapping.
+type Database struct {
+	db      database.Database
+	policy  Policy
+	budget  *budget
+	backend string
+	retries *prometheus.CounterVec
+}
+
+// New wraps db, registering a count of retries with r. The backend label is
+// database.Name(db) if backend is empty.
+func New(db database.Database, r prometheus.Registerer, backend string, p Policy) (*Database, error) {
+	retries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kipp",
+		Subsystem: "database",
+		Name:      "retries_total",
+		Help:      "Number of retried database calls.",
+	}, []string{"backend", "method"})
+	if err := r.Register(retries); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+		retries = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	if backend == "" {
+		backend = database.Name(db)
+	}
+	if p.Classify == nil {
+		p.Classify = Classify
+	}
+	return &Database{
+		db:      db,
+		policy:  p,
+		budget:  &budget{tokens: maxTokens, ratio: p.Budget},
+		backend: backend,
+		retries: retries,
+	}, nil
+}
+
+// Unwrap returns the wrapped database.
+func (db *Database) Unwrap() database.Database { return db.db }
+
+// do calls f until it succeeds, or fails with an error of a lesser class
+// than min. The attempt is passed to f, starting at one.
+func (db *Database) do(ctx context.Context, method string, min Class, f func(attempt int) error) error {
+	db.budget.deposit()
+	for attempt := 1; ; attempt++ {
+		err := f(attempt)
+		if err == nil || attempt >= db.policy.Attempts || db.policy.Classify(err) < min {
+			return err
+		}
+		d := db.policy.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return err
+		}
+		if !db.budget.withdraw() {
+			return err
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		db.retries.WithLabelValues(db.backend, method).Inc()
+	}
+}
+
+func (db *Database) Create(ctx context.Context, e database.Entry) error {
+	return db.do(ctx, "create", Transient, func(attempt int) error {
+		err := db.db.Create(ctx, e)
+		if attempt > 1 && errors.Is(err, database.ErrConflict) {
+			if got, lerr := db.db.Lookup(ctx, e.Slug); lerr == nil &&
+				got.Sum == e.Sum && got.Timestamp.Equal(e.Timestamp) {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// CreateBatch is only retried on Unapplied errors, as a batch may have been
+// partly created.
+func (db *Database) CreateBatch(ctx context.Context, entries []database.Entry) error {
+	return db.do(ctx, "create_batch", Unapplied, func(int) error { return db.db.CreateBatch(ctx, entries) })
+}
+
+func (db *Database) Delete(ctx context.Context, slug string) error {
+	return db.do(ctx, "delete", Transient, func(attempt int) error {
+		err := db.db.Delete(ctx, slug)
+		if attempt > 1 && errors.Is(err, database.ErrNoResults) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (db *Database) Lookup(ctx context.Context, slug string) (e database.Entry, err error) {
+	err = db.do(ctx, "lookup", Transient, func(int) error {
+		e, err = db.db.Lookup(ctx, slug)
+		return err
+	})
+	return e, err
+}
+
+func (db *Database) LookupMany(ctx context.Context, slugs []string) (m map[string]database.Entry, err error) {
+	err = db.do(ctx, "lookup_many", Transient, func(int) error {
+		m, err = db.db.LookupMany(ctx, slugs)
+		return err
+	})
+	return m, err
+}
+
+func (db *Database) LookupBySum(ctx context.Context, sum string) (e database.Entry, err error) {
+	err = db.do(ctx, "lookup_by_sum", Transient, func(int) error {
+		e, err = db.db.LookupBySum(ctx, sum)
+		return err
+	})
+	return e, err
+}
+
+func (db *Database) List(ctx context.Context, opts database.ListOptions) (entries []database.Entry, next string, err error) {
+	err = db.do(ctx, "list", Transient, func(int) error {
+		entries, next, err = db.db.List(ctx, opts)
+		return err
+	})
+	return entries, next, err
+}
+
+func (db *Database) SearchByName(ctx context.Context, query string, opts database.SearchOptions) (entries []database.Entry, next string, err error) {
+	d, ok := db.db.(database.Searcher)
+	if !ok {
+		return nil, "", database.ErrUnsupported
+	}
+	err = db.do(ctx, "search_by_name", Transient, func(int) error {
+		entries, next, err = d.SearchByName(ctx, query, opts)
+		return err
+	})
+	return entries, next, err
+}
+
+func (db *Database) Ping(ctx context.Context) error {
+	return db.do(ctx, "ping", Transient, func(int) error { return db.db.Ping(ctx) })
+}
+
+// Close closes the wrapped database, without retrying.
+func (db *Database) Close(ctx context.Context) error { return db.db.Close(ctx) }
+
+func (db *Database) Touch(ctx context.Context, slug string, t time.Time) error {
+	d, ok := db.db.(database.Toucher)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "touch", Transient, func(int) error { return d.Touch(ctx, slug, t) })
+}
+
+func (db *Database) Extend(ctx context.Context, slug string, t time.Time) error {
+	d, ok := db.db.(database.Extender)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "extend", Transient, func(int) error { return d.Extend(ctx, slug, t) })
+}
+
+// AddDownloads is only retried on Unapplied errors, as it isn't idempotent.
+func (db *Database) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	d, ok := db.db.(database.DownloadCounter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "add_downloads", Unapplied, func(int) error { return d.AddDownloads(ctx, slug, t, n) })
+}
+
+func (db *Database) Downloads(ctx context.Context, slug string, t time.Time) (downloads []database.Downloads, err error) {
+	d, ok := db.db.(database.DownloadCounter)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	err = db.do(ctx, "downloads", Transient, func(int) error {
+		downloads, err = d.Downloads(ctx, slug, t)
+		return err
+	})
+	return downloads, err
+}
+
+func (db *Database) Stats(ctx context.Context, now time.Time) (s database.Stats, err error) {
+	d, ok := db.db.(database.StatsReporter)
+	if !ok {
+		return s, database.ErrUnsupported
+	}
+	err = db.do(ctx, "stats", Transient, func(int) error {
+		s, err = d.Stats(ctx, now)
+		return err
+	})
+	return s, err
+}
+
+func (db *Database) RemoveDownloads(ctx context.Context, slug string) error {
+	d, ok := db.db.(database.DownloadCounter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "remove_downloads", Transient, func(int) error { return d.RemoveDownloads(ctx, slug) })
+}
+
+func (db *Database) SetLifetime(ctx context.Context, slug string, t *time.Time) error {
+	d, ok := db.db.(database.LifetimeSetter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "set_lifetime", Transient, func(int) error { return d.SetLifetime(ctx, slug, t) })
+}
+
+func (db *Database) SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error {
+	d, ok := db.db.(database.SoftDeleter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "soft_delete", Transient, func(int) error { return d.SoftDelete(ctx, slug, t, reason) })
+}
+
+func (db *Database) Restore(ctx context.Context, slug string) error {
+	d, ok := db.db.(database.SoftDeleter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "restore", Transient, func(int) error { return d.Restore(ctx, slug) })
+}
+
+func (db *Database) Block(ctx context.Context, slug string, t time.Time, reason string) error {
+	d, ok := db.db.(database.Blocker)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "block", Transient, func(int) error { return d.Block(ctx, slug, t, reason) })
+}
+
+func (db *Database) Unblock(ctx context.Context, slug string) error {
+	d, ok := db.db.(database.Blocker)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "unblock", Transient, func(int) error { return d.Unblock(ctx, slug) })
+}
+
+func (db *Database) Rename(ctx context.Context, slug, name string) error {
+	d, ok := db.db.(database.Renamer)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "rename", Transient, func(int) error { return d.Rename(ctx, slug, name) })
+}
+
+func (db *Database) Deny(ctx context.Context, d database.Denial) error {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "deny", Transient, func(int) error { return dl.Deny(ctx, d) })
+}
+
+func (db *Database) Denied(ctx context.Context, sum string) (d database.Denial, err error) {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return d, database.ErrUnsupported
+	}
+	err = db.do(ctx, "denied", Transient, func(int) error {
+		d, err = dl.Denied(ctx, sum)
+		return err
+	})
+	return d, err
+}
+
+func (db *Database) Allow(ctx context.Context, sum string) error {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "allow", Transient, func(int) error { return dl.Allow(ctx, sum) })
+}
+
+func (db *Database) Denials(ctx context.Context) (denials []database.Denial, err error) {
+	dl, ok := db.db.(database.Denylister)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	err = db.do(ctx, "denials", Transient, func(int) error {
+		denials, err = dl.Denials(ctx)
+		return err
+	})
+	return denials, err
+}
+
+// Report is only retried on Unapplied errors, as it isn't idempotent.
+func (db *Database) Report(ctx context.Context, r database.Report) error {
+	rp, ok := db.db.(database.Reporter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "report", Unapplied, func(int) error { return rp.Report(ctx, r) })
+}
+
+func (db *Database) Reports(ctx context.Context, status database.ReportStatus) (reports []database.Report, err error) {
+	rp, ok := db.db.(database.Reporter)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	err = db.do(ctx, "reports", Transient, func(int) error {
+		reports, err = rp.Reports(ctx, status)
+		return err
+	})
+	return reports, err
+}
+
+func (db *Database) ResolveReports(ctx context.Context, slug string) error {
+	rp, ok := db.db.(database.Reporter)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "resolve_reports", Transient, func(int) error { return rp.ResolveReports(ctx, slug) })
+}
+
+// Audit is only retried on Unapplied errors, as records mustn't be appended
+// twice.
+func (db *Database) Audit(ctx context.Context, r database.AuditRecord) error {
+	a, ok := db.db.(database.Auditor)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "audit", Unapplied, func(int) error { return a.Audit(ctx, r) })
+}
+
+func (db *Database) AuditLog(ctx context.Context, opts database.AuditOptions) (records []database.AuditRecord, next string, err error) {
+	a, ok := db.db.(database.Auditor)
+	if !ok {
+		return nil, "", database.ErrUnsupported
+	}
+	err = db.do(ctx, "audit_log", Transient, func(int) error {
+		records, next, err = a.AuditLog(ctx, opts)
+		return err
+	})
+	return records, next, err
+}
+
+// CreateUser and AddUserToken are only retried on Unapplied errors, as a
+// retry of one which was applied conflicts with it.
+func (db *Database) CreateUser(ctx context.Context, u database.User) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "create_user", Unapplied, func(int) error { return us.CreateUser(ctx, u) })
+}
+
+func (db *Database) User(ctx context.Context, id string) (u database.User, err error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return u, database.ErrUnsupported
+	}
+	err = db.do(ctx, "user", Transient, func(int) error {
+		u, err = us.User(ctx, id)
+		return err
+	})
+	return u, err
+}
+
+func (db *Database) Users(ctx context.Context) (users []database.User, err error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	err = db.do(ctx, "users", Transient, func(int) error {
+		users, err = us.Users(ctx)
+		return err
+	})
+	return users, err
+}
+
+func (db *Database) DeleteUser(ctx context.Context, id string) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "delete_user", Transient, func(int) error { return us.DeleteUser(ctx, id) })
+}
+
+func (db *Database) AddUserToken(ctx context.Context, t database.UserToken) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "add_user_token", Unapplied, func(int) error { return us.AddUserToken(ctx, t) })
+}
+
+func (db *Database) UserTokens(ctx context.Context, id string) (tokens []database.UserToken, err error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return nil, database.ErrUnsupported
+	}
+	err = db.do(ctx, "user_tokens", Transient, func(int) error {
+		tokens, err = us.UserTokens(ctx, id)
+		return err
+	})
+	return tokens, err
+}
+
+func (db *Database) RemoveUserToken(ctx context.Context, id, token string) error {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return database.ErrUnsupported
+	}
+	return db.do(ctx, "remove_user_token", Transient, func(int) error { return us.RemoveUserToken(ctx, id, token) })
+}
+
+func (db *Database) UserByToken(ctx context.Context, hash string) (u database.User, err error) {
+	us, ok := db.db.(database.UserStore)
+	if !ok {
+		return u, database.ErrUnsupported
+	}
+	err = db.do(ctx, "user_by_token", Transient, func(int) error {
+		u, err = us.UserByToken(ctx, hash)
+		return err
+	})
+	return u, err
+}
+
+// Expires reports whether the wrapped database removes expired entries.
+func (db *Database) Expires() bool {
+	d, ok := db.db.(database.Expirer)
+	return ok && d.Expires()
+}
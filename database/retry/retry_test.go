@@ -0,0 +1,281 @@
+package retry_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/database/retry"
+)
+
+func TestConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := retry.New(memory.New(), prometheus.NewRegistry(), "", retry.DefaultPolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+// flaky is a memory database whose calls fail with queued errors. Errors
+// queued with applied fail after the call has taken effect.
+type flaky struct {
+	*memory.Database
+	errs    []error
+	applied bool
+	calls   int
+}
+
+func (f *flaky) fail(call func() error) error {
+	f.calls++
+	if len(f.errs) == 0 {
+		return call()
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	if f.applied {
+		call()
+	}
+	return err
+}
+
+func (f *flaky) Create(ctx context.Context, e database.Entry) error {
+	return f.fail(func() error { return f.Database.Create(ctx, e) })
+}
+
+func (f *flaky) Delete(ctx context.Context, slug string) error {
+	return f.fail(func() error { return f.Database.Delete(ctx, slug) })
+}
+
+func (f *flaky) Lookup(ctx context.Context, slug string) (e database.Entry, err error) {
+	err = f.fail(func() error {
+		e, err = f.Database.Lookup(ctx, slug)
+		return err
+	})
+	return e, err
+}
+
+func (f *flaky) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	return f.fail(func() error { return f.Database.AddDownloads(ctx, slug, t, n) })
+}
+
+var policy = retry.Policy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func open(t *testing.T, p retry.Policy, errs ...error) (*retry.Database, *flaky, *prometheus.Registry) {
+	t.Helper()
+	r := prometheus.NewRegistry()
+	f := &flaky{Database: memory.New(), errs: errs}
+	db, err := retry.New(f, r, "flaky", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, f, r
+}
+
+func retries(t *testing.T, r prometheus.Gatherer, method string) float64 {
+	t.Helper()
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n float64
+	for _, mf := range mfs {
+		if mf.GetName() != "kipp_database_retries_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "method" && l.GetValue() == method {
+					n += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return n
+}
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want retry.Class
+	}{
+		{errors.New("some error"), retry.Permanent},
+		{context.DeadlineExceeded, retry.Permanent},
+		{fmt.Errorf("lookup: %w", database.ErrNoResults), retry.Permanent},
+		{database.ErrConflict, retry.Permanent},
+		{driver.ErrBadConn, retry.Unapplied},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), retry.Unapplied},
+		{syscall.ECONNRESET, retry.Transient},
+		{pgError("40001"), retry.Unapplied},
+		{pgError("08006"), retry.Transient},
+		{pgError("23505"), retry.Permanent},
+		{sqliteError(5), retry.Unapplied},
+		{sqliteError(19), retry.Permanent},
+		{cqlError(0x1000), retry.Unapplied},
+		{cqlError(0x1100), retry.Transient},
+		{cqlError(0x2200), retry.Permanent},
+		{awsError("ThrottlingException"), retry.Unapplied},
+		{awsError("InternalServerError"), retry.Transient},
+		{awsError("ConditionalCheckFailedException"), retry.Permanent},
+	} {
+		if got := retry.Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+type pgError string
+
+func (e pgError) Error() string    { return "pg: " + string(e) }
+func (e pgError) SQLState() string { return string(e) }
+
+type sqliteError int
+
+func (e sqliteError) Error() string { return fmt.Sprintf("sqlite: %d", int(e)) }
+func (e sqliteError) Code() int     { return int(e) }
+
+type cqlError int
+
+func (e cqlError) Error() string   { return fmt.Sprintf("cql: %#x", int(e)) }
+func (e cqlError) Code() int       { return int(e) }
+func (e cqlError) Message() string { return e.Error() }
+
+type awsError string
+
+func (e awsError) Error() string { return "aws: " + string(e) }
+func (e awsError) Code() string  { return string(e) }
+
+func TestDatabaseTransient(t *testing.T) {
+	ctx := context.Background()
+	db, f, r := open(t, policy, syscall.ECONNRESET, syscall.ECONNRESET)
+	if _, err := db.Lookup(ctx, "missing"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if f.calls != 3 {
+		t.Fatalf("unexpected calls; got %d, want 3", f.calls)
+	}
+	if got := retries(t, r, "lookup"); got != 2 {
+		t.Fatalf("unexpected retries; got %v, want 2", got)
+	}
+}
+
+func TestDatabasePermanent(t *testing.T) {
+	ctx := context.Background()
+	errFake := errors.New("fake")
+	db, f, _ := open(t, policy, errFake)
+	if _, err := db.Lookup(ctx, "missing"); err != errFake {
+		t.Fatalf("unexpected error; got %v, want %v", err, errFake)
+	}
+	if f.calls != 1 {
+		t.Fatalf("unexpected calls; got %d, want 1", f.calls)
+	}
+}
+
+func TestDatabaseAttempts(t *testing.T) {
+	ctx := context.Background()
+	db, f, _ := open(t, policy, syscall.ECONNRESET, syscall.ECONNRESET, syscall.ECONNRESET, syscall.ECONNRESET)
+	if _, err := db.Lookup(ctx, "missing"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("unexpected error; got %v, want %v", err, syscall.ECONNRESET)
+	}
+	if f.calls != policy.Attempts {
+		t.Fatalf("unexpected calls; got %d, want %d", f.calls, policy.Attempts)
+	}
+}
+
+func TestDatabaseCreate(t *testing.T) {
+	ctx := context.Background()
+	e := databasetest.NewEntry("create")
+
+	// The first attempt was applied, so the second conflicts with it.
+	db, f, _ := open(t, policy, syscall.ECONNRESET)
+	f.applied = true
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// A retry which conflicts with an entry the earlier attempt didn't
+	// create still fails.
+	other := databasetest.NewEntry("other")
+	if err := f.Database.Create(ctx, other); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f.applied = false
+	f.errs = []error{syscall.ECONNRESET, database.ErrConflict}
+	e.Slug = other.Slug
+	if err := db.Create(ctx, e); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrConflict)
+	}
+
+	// Likewise, a retried delete of an entry deleted by an earlier attempt.
+	e.Slug = "create"
+	f.applied = true
+	f.errs = []error{syscall.ECONNRESET}
+	if err := db.Delete(ctx, e.Slug); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := db.Delete(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func TestDatabaseAddDownloads(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	db, f, _ := open(t, policy, syscall.ECONNRESET)
+	if err := db.AddDownloads(ctx, "slug", now, 1); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("unexpected error; got %v, want %v", err, syscall.ECONNRESET)
+	}
+	if f.calls != 1 {
+		t.Fatalf("unexpected calls; got %d, want 1", f.calls)
+	}
+
+	f.errs = []error{driver.ErrBadConn}
+	if err := db.AddDownloads(ctx, "slug", now, 1); err != nil {
+		t.Fatalf("add downloads: %v", err)
+	}
+	if f.calls != 3 {
+		t.Fatalf("unexpected calls; got %d, want 3", f.calls)
+	}
+}
+
+func TestDatabaseDeadline(t *testing.T) {
+	p := policy
+	p.Backoff, p.MaxBackoff = time.Hour, time.Hour
+	db, f, _ := open(t, p, syscall.ECONNRESET)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := db.Lookup(ctx, "missing"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("unexpected error; got %v, want %v", err, syscall.ECONNRESET)
+	}
+	if f.calls != 1 {
+		t.Fatalf("unexpected calls; got %d, want 1", f.calls)
+	}
+}
+
+func TestDatabaseBudget(t *testing.T) {
+	ctx := context.Background()
+	p := policy
+	p.Attempts, p.Budget = 2, 0.01
+	db, f, r := open(t, p)
+
+	// The budget allows a burst of ten retries, and is then refilled by a
+	// hundredth of a retry per call.
+	for i := 0; i < 12; i++ {
+		f.errs = []error{syscall.ECONNRESET}
+		db.Lookup(ctx, "missing")
+	}
+	if got := retries(t, r, "lookup"); got != 10 {
+		t.Fatalf("unexpected retries; got %v, want 10", got)
+	}
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Searcher searches entries by name natively, more cheaply than listing
+// them all. See package search for databases which don't.
+type Searcher interface {
+	// SearchByName lists entries whose names match query, as List would
+	// with the list options in opts. Databases return ErrUnsupported for
+	// searches they can't do natively.
+	SearchByName(ctx context.Context, query string, opts SearchOptions) (entries []Entry, next string, err error)
+}
+
+// SearchOptions configures SearchByName.
+type SearchOptions struct {
+	ListOptions
+	// Prefix only matches names starting with the query, rather than
+	// containing it.
+	Prefix bool
+	// CaseSensitive matches names exactly, rather than folding case.
+	CaseSensitive bool
+}
+
+// MatchName reports whether name matches query, as configured by o. Case is
+// folded a rune at a time, with Unicode simple folding.
+func (o SearchOptions) MatchName(name, query string) bool {
+	if o.CaseSensitive {
+		if o.Prefix {
+			return strings.HasPrefix(name, query)
+		}
+		return strings.Contains(name, query)
+	}
+	if o.Prefix {
+		return hasPrefixFold(name, query)
+	}
+	for i := range name {
+		if hasPrefixFold(name[i:], query) {
+			return true
+		}
+	}
+	return query == ""
+}
+
+// hasPrefixFold reports whether s begins with prefix, folding case.
+func hasPrefixFold(s, prefix string) bool {
+	for prefix != "" {
+		if s == "" {
+			return false
+		}
+		a, n := utf8.DecodeRuneInString(s)
+		b, m := utf8.DecodeRuneInString(prefix)
+		if !equalFold(a, b) {
+			return false
+		}
+		s, prefix = s[n:], prefix[m:]
+	}
+	return true
+}
+
+// equalFold reports whether a and b are equal under simple case folding.
+func equalFold(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for r := unicode.SimpleFold(a); r != a; r = unicode.SimpleFold(r) {
+		if r == b {
+			return true
+		}
+	}
+	return false
+}
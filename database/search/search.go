@@ -0,0 +1,52 @@
+// Package search searches the entries of any database by name.
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// pageSize is the number of entries listed at a time while scanning.
+const pageSize = 1000
+
+// ByName lists entries in db whose names match query, as configured by opts.
+// Databases implementing database.Searcher search natively, otherwise entries
+// are listed and filtered until a page of matches is found.
+func ByName(ctx context.Context, db database.Database, query string, opts database.SearchOptions) ([]database.Entry, string, error) {
+	if s, ok := db.(database.Searcher); ok {
+		entries, next, err := s.SearchByName(ctx, query, opts)
+		if !errors.Is(err, database.ErrUnsupported) {
+			return entries, next, err
+		}
+	}
+
+	lo := opts.ListOptions
+	lo.Limit = pageSize
+	n := opts.Size()
+	var matches []database.Entry
+	for len(matches) <= n {
+		entries, next, err := db.List(ctx, lo)
+		if err != nil {
+			return nil, "", fmt.Errorf("list: %w", err)
+		}
+		now := time.Now()
+		for _, e := range entries {
+			if opts.Match(e, now) && opts.MatchName(e.Name, query) {
+				matches = append(matches, e)
+			}
+		}
+		if next == "" {
+			break
+		}
+		lo.Cursor = next
+	}
+	if len(matches) > n+1 {
+		matches = matches[:n+1]
+	}
+	entries, next := opts.Page(matches)
+	return entries, next, nil
+}
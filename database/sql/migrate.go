@@ -0,0 +1,378 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	// ErrSchemaTooNew is returned by Open when the database has been
+	// migrated by a newer version of kipp.
+	ErrSchemaTooNew = errors.New("schema is newer than supported")
+	// ErrPendingMigrations is returned by Open when the schema is out of
+	// date and migrations are not being applied.
+	ErrPendingMigrations = errors.New("pending migrations")
+)
+
+// A MigrateMode controls how Open handles pending migrations.
+type MigrateMode int
+
+const (
+	// MigrateAuto applies pending migrations. It is the default.
+	MigrateAuto MigrateMode = iota
+	// MigrateDryRun logs pending migrations without applying them, and
+	// fails if there are any.
+	MigrateDryRun
+	// MigrateLocked never changes the schema, and fails if there are
+	// pending migrations.
+	MigrateLocked
+)
+
+// ParseMigrateMode parses "auto", "dry-run" or "locked".
+func ParseMigrateMode(s string) (MigrateMode, error) {
+	switch s {
+	case "auto":
+		return MigrateAuto, nil
+	case "dry-run":
+		return MigrateDryRun, nil
+	case "locked":
+		return MigrateLocked, nil
+	}
+	return 0, fmt.Errorf("invalid migrate mode: %s", s)
+}
+
+// A migration changes the schema of both dialects.
+type migration struct {
+	name             string
+	postgres, sqlite string
+}
+
+// query returns the query for the given driver.
+func (m migration) query(driver string) string {
+	if driver == SQLite {
+		return m.sqlite
+	}
+	return m.postgres
+}
+
+// migrations are applied in order, and the version of a schema is the number
+// of migrations which have been applied to it. Released migrations must never
+// be changed, the schema is changed by appending new ones.
+var migrations = []migration{{
+	name: "create entries",
+	postgres: `CREATE TABLE IF NOT EXISTS entries (
+	id SERIAL PRIMARY KEY NOT NULL,
+	slug VARCHAR(16) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	sum varchar(87) NOT NULL, -- len(b64([64]byte))
+	size BIGINT NOT NULL,
+	lifetime TIMESTAMP,
+	timestamp TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_slug ON entries (slug)`,
+	// The id column is an alias for the rowid, rather than a SERIAL.
+	sqlite: `CREATE TABLE entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	slug VARCHAR(16) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	sum VARCHAR(87) NOT NULL, -- len(b64([64]byte))
+	size BIGINT NOT NULL,
+	lifetime TIMESTAMP,
+	timestamp TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_slug ON entries (slug)`,
+}, {
+	name: "add gzip size, last access and downloads",
+	postgres: `ALTER TABLE entries ADD COLUMN IF NOT EXISTS gzip_size BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE entries ADD COLUMN IF NOT EXISTS last_access TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_timestamp_slug ON entries (timestamp, slug COLLATE "C");
+
+CREATE INDEX IF NOT EXISTS idx_sum ON entries (sum);
+
+CREATE TABLE IF NOT EXISTS downloads (
+	slug VARCHAR(16) NOT NULL,
+	day DATE NOT NULL,
+	count BIGINT NOT NULL,
+	PRIMARY KEY (slug, day)
+)`,
+	sqlite: `ALTER TABLE entries ADD COLUMN gzip_size BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE entries ADD COLUMN last_access TIMESTAMP;
+
+CREATE INDEX idx_timestamp_slug ON entries (timestamp, slug);
+
+CREATE INDEX idx_sum ON entries (sum);
+
+CREATE TABLE downloads (
+	slug VARCHAR(16) NOT NULL,
+	day DATE NOT NULL,
+	count BIGINT NOT NULL,
+	PRIMARY KEY (slug, day)
+)`,
+}, {
+	name: "add soft delete",
+	postgres: `ALTER TABLE entries ADD COLUMN deleted TIMESTAMP;
+
+ALTER TABLE entries ADD COLUMN delete_reason TEXT NOT NULL DEFAULT ''`,
+	sqlite: `ALTER TABLE entries ADD COLUMN deleted TIMESTAMP;
+
+ALTER TABLE entries ADD COLUMN delete_reason TEXT NOT NULL DEFAULT ''`,
+}, {
+	name:     "add lowercased name index",
+	postgres: `CREATE INDEX IF NOT EXISTS idx_name_lower ON entries (lower(name) text_pattern_ops)`,
+	// SQLite only folds ASCII, and can't use an index for instr.
+}, {
+	name: "add tags",
+	postgres: `CREATE TABLE IF NOT EXISTS tags (
+	slug VARCHAR(16) NOT NULL,
+	tag VARCHAR(32) NOT NULL,
+	PRIMARY KEY (slug, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tag ON tags (tag, slug)`,
+	sqlite: `CREATE TABLE tags (
+	slug VARCHAR(16) NOT NULL,
+	tag VARCHAR(32) NOT NULL,
+	PRIMARY KEY (slug, tag)
+);
+
+CREATE INDEX idx_tag ON tags (tag, slug)`,
+}, {
+	name:     "widen names for encryption",
+	postgres: `ALTER TABLE entries ALTER COLUMN name TYPE TEXT`,
+	// SQLite doesn't enforce the lengths of columns.
+}, {
+	name: "add denials",
+	postgres: `CREATE TABLE IF NOT EXISTS denials (
+	sum VARCHAR(87) PRIMARY KEY NOT NULL,
+	reason TEXT NOT NULL,
+	timestamp TIMESTAMP NOT NULL
+)`,
+	sqlite: `CREATE TABLE denials (
+	sum VARCHAR(87) PRIMARY KEY NOT NULL,
+	reason TEXT NOT NULL,
+	timestamp TIMESTAMP NOT NULL
+)`,
+}, {
+	name: "add blocking",
+	postgres: `ALTER TABLE entries ADD COLUMN blocked TIMESTAMP;
+
+ALTER TABLE entries ADD COLUMN block_reason TEXT NOT NULL DEFAULT ''`,
+	sqlite: `ALTER TABLE entries ADD COLUMN blocked TIMESTAMP;
+
+ALTER TABLE entries ADD COLUMN block_reason TEXT NOT NULL DEFAULT ''`,
+}, {
+	name: "add reports",
+	postgres: `CREATE TABLE IF NOT EXISTS reports (
+	slug VARCHAR(16) NOT NULL,
+	category VARCHAR(32) NOT NULL,
+	description TEXT NOT NULL,
+	contact TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	timestamp TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_status ON reports (status, slug)`,
+	sqlite: `CREATE TABLE reports (
+	slug VARCHAR(16) NOT NULL,
+	category VARCHAR(32) NOT NULL,
+	description TEXT NOT NULL,
+	contact TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	timestamp TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_report_status ON reports (status, slug)`,
+}, {
+	name: "add audit log",
+	postgres: `CREATE TABLE IF NOT EXISTS audit (
+	id VARCHAR(32) PRIMARY KEY NOT NULL,
+	timestamp TIMESTAMP NOT NULL,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	remote TEXT NOT NULL,
+	before_value TEXT NOT NULL,
+	after_value TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_timestamp_id ON audit (timestamp, id)`,
+	sqlite: `CREATE TABLE audit (
+	id VARCHAR(32) PRIMARY KEY NOT NULL,
+	timestamp TIMESTAMP NOT NULL,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	remote TEXT NOT NULL,
+	before_value TEXT NOT NULL,
+	after_value TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_timestamp_id ON audit (timestamp, id)`,
+}, {
+	name: "add users",
+	postgres: `ALTER TABLE entries ADD COLUMN owner VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_owner ON entries (owner, timestamp);
+
+CREATE TABLE IF NOT EXISTS users (
+	id VARCHAR(64) PRIMARY KEY NOT NULL,
+	created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+	user_id VARCHAR(64) NOT NULL,
+	id VARCHAR(32) NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	created TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_token_hash ON user_tokens (hash)`,
+	sqlite: `ALTER TABLE entries ADD COLUMN owner VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_owner ON entries (owner, timestamp);
+
+CREATE TABLE users (
+	id VARCHAR(64) PRIMARY KEY NOT NULL,
+	created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE user_tokens (
+	user_id VARCHAR(64) NOT NULL,
+	id VARCHAR(32) NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	created TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, id)
+);
+
+CREATE UNIQUE INDEX idx_user_token_hash ON user_tokens (hash)`,
+}}
+
+const schemaVersionQuery = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`
+
+// schemaLockID identifies the advisory lock taken while migrating PostgreSQL,
+// so instances starting at the same time migrate one at a time. SQLite
+// transactions are begun immediately, which has the same effect.
+const schemaLockID = 0x6b697070 // "kipp"
+
+// migrate brings the schema of db up to date.
+func migrate(ctx context.Context, db *sql.DB, driver string, mode MigrateMode, log *slog.Logger) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if driver != SQLite {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", schemaLockID); err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+	}
+
+	v, err := schemaVersion(ctx, tx, driver)
+	if err != nil {
+		return err
+	}
+	if v > len(migrations) {
+		return fmt.Errorf("%w: version %d, want at most %d", ErrSchemaTooNew, v, len(migrations))
+	}
+	pending := migrations[v:]
+	if len(pending) == 0 {
+		return tx.Commit()
+	}
+	if mode != MigrateAuto {
+		if mode == MigrateDryRun {
+			for i, m := range pending {
+				log.InfoContext(ctx, "pending migration", "version", v+i+1, "name", m.name, "query", m.query(driver))
+			}
+		}
+		return fmt.Errorf("%w: version %d, want %d", ErrPendingMigrations, v, len(migrations))
+	}
+	for i, m := range pending {
+		// Migrations may not apply to both dialects.
+		if q := m.query(driver); q != "" {
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return fmt.Errorf("migrate to version %d, %s: %w", v+i+1, m.name, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO schema_version (version, applied_at) VALUES ($1, $2)",
+			v+i+1, time.Now().UTC(),
+		); err != nil {
+			return fmt.Errorf("insert version: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// schemaVersion returns the version of the schema, creating the version table
+// if need be.
+//
+// Databases created before migrations were versioned have entries but no
+// versions. The PostgreSQL migrations are idempotent, so they're applied from
+// the start, whereas SQLite databases were always created with the second
+// version.
+func schemaVersion(ctx context.Context, tx *sql.Tx, driver string) (int, error) {
+	var versioned bool
+	if err := tx.QueryRowContext(ctx, tableExistsQuery(driver), "schema_version").Scan(&versioned); err != nil {
+		return 0, fmt.Errorf("query row: %w", err)
+	}
+	if versioned {
+		var v int
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&v); err != nil {
+			return 0, fmt.Errorf("query row: %w", err)
+		}
+		return v, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, schemaVersionQuery); err != nil {
+		return 0, fmt.Errorf("create schema version: %w", err)
+	}
+	if driver != SQLite {
+		return 0, nil
+	}
+	var legacy bool
+	if err := tx.QueryRowContext(ctx, tableExistsQuery(driver), "entries").Scan(&legacy); err != nil {
+		return 0, fmt.Errorf("query row: %w", err)
+	}
+	if !legacy {
+		return 0, nil
+	}
+	const v = 2
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO schema_version (version, applied_at) VALUES ($1, $2)",
+		v, time.Now().UTC(),
+	); err != nil {
+		return 0, fmt.Errorf("insert version: %w", err)
+	}
+	return v, nil
+}
+
+// tableExistsQuery returns a query which reports whether the table named by
+// its argument exists.
+func tableExistsQuery(driver string) string {
+	if driver == SQLite {
+		return "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = $1)"
+	}
+	return "SELECT to_regclass($1) IS NOT NULL"
+}
@@ -0,0 +1,200 @@
+package sql_test
+
+import (
+	"context"
+	stdsql "database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/sql"
+)
+
+// v1 creates the first version of the schema, as it was before migrations
+// were versioned, and records its version unless legacy is set.
+func v1(t *testing.T, driver, name string, legacy bool) {
+	id := "id SERIAL PRIMARY KEY NOT NULL"
+	if driver == sql.SQLite {
+		id = "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+	exec(t, driver, name, `CREATE TABLE entries (
+	`+id+`,
+	slug VARCHAR(16) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	sum varchar(87) NOT NULL,
+	size BIGINT NOT NULL,
+	lifetime TIMESTAMP,
+	timestamp TIMESTAMP NOT NULL
+)`, "CREATE UNIQUE INDEX idx_slug ON entries (slug)")
+	if !legacy {
+		exec(t, driver, name,
+			"CREATE TABLE schema_version (version INTEGER PRIMARY KEY NOT NULL, applied_at TIMESTAMP NOT NULL)",
+			"INSERT INTO schema_version (version, applied_at) VALUES (1, CURRENT_TIMESTAMP)",
+		)
+	}
+}
+
+// insert inserts entries into the first version of the schema.
+func insert(t *testing.T, driver, name string, n int) []database.Entry {
+	db, err := stdsql.Open(driver, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	base := time.Now().UTC().Truncate(time.Millisecond)
+	entries := make([]database.Entry, n)
+	for i := range entries {
+		e := databasetest.NewEntry(string(rune('a' + i)))
+		e.Timestamp = base.Add(time.Duration(i) * time.Second)
+		e.LastAccess, e.GzipSize, e.Tags, e.Owner = nil, 0, nil, ""
+		if i%2 == 0 {
+			e.Lifetime = nil
+		}
+		var lifetime interface{}
+		if e.Lifetime != nil {
+			lifetime = e.Lifetime.UTC()
+		}
+		if _, err := db.Exec(
+			"INSERT INTO entries (slug, name, sum, size, lifetime, timestamp) VALUES ($1, $2, $3, $4, $5, $6)",
+			e.Slug, e.Name, e.Sum, e.Size, lifetime, e.Timestamp,
+		); err != nil {
+			t.Fatal(err)
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+func exec(t *testing.T, driver, name string, queries ...string) {
+	t.Helper()
+	db, err := stdsql.Open(driver, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	t.Run("SQLite", func(t *testing.T) {
+		testMigrate(t, sql.SQLite, func(t *testing.T) string {
+			return filepath.Join(t.TempDir(), "kipp.db")
+		})
+	})
+	t.Run("Postgres", func(t *testing.T) {
+		dsn := os.Getenv("KIPP_TEST_POSTGRES")
+		if dsn == "" {
+			t.Skip("KIPP_TEST_POSTGRES not set")
+		}
+		testMigrate(t, "pgx", postgres(dsn))
+	})
+}
+
+func testMigrate(t *testing.T, driver string, name func(t *testing.T) string) {
+	ctx := context.Background()
+
+	open := func(t *testing.T, name string, opts ...sql.Option) (*sql.Database, error) {
+		db, err := sql.Open(ctx, driver, name, opts...)
+		if err == nil {
+			t.Cleanup(func() { db.Close(ctx) })
+		}
+		return db, err
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		name := name(t)
+		if _, err := open(t, name, sql.Migrate(sql.MigrateLocked)); !errors.Is(err, sql.ErrPendingMigrations) {
+			t.Fatalf("unexpected error; got %v, want %v", err, sql.ErrPendingMigrations)
+		}
+		db, err := open(t, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := databasetest.NewEntry("empty")
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		db.Close(ctx)
+
+		// The schema is up to date, so it can be opened without migrating.
+		db, err = open(t, name, sql.Migrate(sql.MigrateLocked))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := db.Lookup(ctx, e.Slug); err != nil || !databasetest.Equal(got, e) {
+			t.Fatalf("lookup: got %+v, %v, want %+v", got, err, e)
+		}
+	})
+
+	for _, legacy := range []bool{false, true} {
+		legacy := legacy
+		sub := "V1"
+		if legacy {
+			sub = "Legacy"
+		}
+		t.Run(sub, func(t *testing.T) {
+			// SQLite databases which predate versioning were always
+			// created with the second version.
+			if legacy && driver == sql.SQLite {
+				t.Skip("legacy SQLite databases are up to date")
+			}
+			name := name(t)
+			v1(t, driver, name, legacy)
+			want := insert(t, driver, name, 5)
+
+			for _, mode := range []sql.MigrateMode{sql.MigrateLocked, sql.MigrateDryRun} {
+				if _, err := open(t, name, sql.Migrate(mode)); !errors.Is(err, sql.ErrPendingMigrations) {
+					t.Fatalf("unexpected error; got %v, want %v", err, sql.ErrPendingMigrations)
+				}
+			}
+			db, err := open(t, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := db.List(ctx, database.ListOptions{})
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("unexpected number of entries; got %d, want %d", len(got), len(want))
+			}
+			for i := range got {
+				if !databasetest.Equal(got[i], want[i]) {
+					t.Fatalf("unexpected entry; got %+v, want %+v", got[i], want[i])
+				}
+			}
+
+			// The columns and tables added since can be used.
+			e := databasetest.NewEntry("new")
+			if err := db.Create(ctx, e); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if got, err := db.Lookup(ctx, e.Slug); err != nil || !databasetest.Equal(got, e) {
+				t.Fatalf("lookup: got %+v, %v, want %+v", got, err, e)
+			}
+			if err := db.AddDownloads(ctx, e.Slug, e.Timestamp, 1); err != nil {
+				t.Fatalf("add downloads: %v", err)
+			}
+		})
+	}
+
+	t.Run("TooNew", func(t *testing.T) {
+		name := name(t)
+		if _, err := open(t, name); err != nil {
+			t.Fatal(err)
+		}
+		exec(t, driver, name, "INSERT INTO schema_version (version, applied_at) VALUES (1000, CURRENT_TIMESTAMP)")
+		if _, err := open(t, name); !errors.Is(err, sql.ErrSchemaTooNew) {
+			t.Fatalf("unexpected error; got %v, want %v", err, sql.ErrSchemaTooNew)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Reader reads lookups and lists from the named database, such as a read
+// replica, rather than the one passed to Open. Everything else is written to
+// and read from the one passed to Open, which reads fall back to while the
+// reader is failing.
+func Reader(name string) Option {
+	return func(ctx context.Context, db *Database) error {
+		db.readerName = name
+		return nil
+	}
+}
+
+// ReadYourWrites reads entries which were written within d from the writer
+// rather than the reader, so they can be read before they've been replicated.
+// It has no effect without a Reader.
+func ReadYourWrites(d time.Duration) Option {
+	return func(ctx context.Context, db *Database) error {
+		db.recent = &recent{d: d, keys: make(map[string]time.Time)}
+		return nil
+	}
+}
+
+// queryRow queries a row from the reader, or with stmt from the writer if
+// there's no reader, the reader fails or the row was written too recently to
+// be read from it. The query and stmt must be the same.
+func (db *Database) queryRow(ctx context.Context, key string, stmt *sql.Stmt, query string, args ...interface{}) *sql.Row {
+	if db.reader != nil && !db.recent.has(key) {
+		row := db.reader.QueryRowContext(ctx, query, args...)
+		err := row.Err()
+		if err == nil || ctx.Err() != nil {
+			return row
+		}
+		db.log.WarnContext(ctx, "query reader", "error", err)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// query queries rows from the reader, or from the writer if there's no
+// reader or it fails.
+func (db *Database) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if db.reader != nil {
+		rows, err := db.reader.QueryContext(ctx, query, args...)
+		if err == nil || ctx.Err() != nil {
+			return rows, err
+		}
+		db.log.WarnContext(ctx, "query reader", "error", err)
+	}
+	return db.db.QueryContext(ctx, query, args...)
+}
+
+// recent holds keys of recently written entries, which expire after d. A nil
+// recent holds nothing.
+type recent struct {
+	d    time.Duration
+	mu   sync.Mutex
+	keys map[string]time.Time
+	// sweep is when expired keys are next removed.
+	sweep time.Time
+}
+
+func slugKey(slug string) string { return "slug/" + slug }
+func sumKey(sum string) string   { return "sum/" + sum }
+
+// add adds the keys for slug and sum, if it isn't empty.
+func (r *recent) add(slug, sum string) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.After(r.sweep) {
+		for k, t := range r.keys {
+			if now.After(t) {
+				delete(r.keys, k)
+			}
+		}
+		r.sweep = now.Add(r.d)
+	}
+	r.keys[slugKey(slug)] = now.Add(r.d)
+	if sum != "" {
+		r.keys[sumKey(sum)] = now.Add(r.d)
+	}
+}
+
+// has reports whether key was added within d.
+func (r *recent) has(key string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.keys[key]
+	return ok && time.Now().Before(t)
+}
@@ -0,0 +1,105 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/sql"
+)
+
+func TestReader(t *testing.T) {
+	ctx := context.Background()
+
+	// The writer and reader are separate databases, so nothing written is
+	// ever replicated.
+	open := func(t *testing.T, name string, opts ...sql.Option) *sql.Database {
+		t.Helper()
+		db, err := sql.Open(ctx, sql.SQLite, name, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close(ctx) })
+		return db
+	}
+	lookup := func(t *testing.T, db database.Database, slug string, want error) {
+		t.Helper()
+		if _, err := db.Lookup(ctx, slug); !errors.Is(err, want) {
+			t.Fatalf("unexpected error for %s; got %v, want %v", slug, err, want)
+		}
+	}
+
+	t.Run("Conformance", func(t *testing.T) {
+		databasetest.Run(t, func(t *testing.T) database.Database {
+			name := filepath.Join(t.TempDir(), "kipp.db")
+			return open(t, name, sql.Reader(name))
+		})
+	})
+
+	t.Run("Split", func(t *testing.T) {
+		dir := t.TempDir()
+		reader := open(t, filepath.Join(dir, "reader.db"))
+		db := open(t, filepath.Join(dir, "writer.db"), sql.Reader(filepath.Join(dir, "reader.db")))
+
+		written, replicated := databasetest.NewEntry("written"), databasetest.NewEntry("replicated")
+		if err := db.Create(ctx, written); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := reader.Create(ctx, replicated); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		lookup(t, db, written.Slug, database.ErrNoResults)
+		lookup(t, db, replicated.Slug, nil)
+		if _, err := db.LookupBySum(ctx, written.Sum); !errors.Is(err, database.ErrNoResults) {
+			t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+		}
+		entries, _, err := db.List(ctx, database.ListOptions{})
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Slug != replicated.Slug {
+			t.Fatalf("unexpected entries; got %v, want only %s", entries, replicated.Slug)
+		}
+	})
+
+	t.Run("ReadYourWrites", func(t *testing.T) {
+		dir := t.TempDir()
+		open(t, filepath.Join(dir, "reader.db"))
+		db := open(t, filepath.Join(dir, "writer.db"),
+			sql.Reader(filepath.Join(dir, "reader.db")),
+			sql.ReadYourWrites(time.Minute),
+		)
+
+		e := databasetest.NewEntry("fresh")
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		lookup(t, db, e.Slug, nil)
+		if _, err := db.LookupBySum(ctx, e.Sum); err != nil {
+			t.Fatalf("lookup by sum: %v", err)
+		}
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		dir := t.TempDir()
+		// The reader can't be opened, as its directory doesn't exist.
+		db := open(t, filepath.Join(dir, "writer.db"), sql.Reader(filepath.Join(dir, "missing", "reader.db")))
+
+		e := databasetest.NewEntry("fallback")
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		lookup(t, db, e.Slug, nil)
+		entries, _, err := db.List(ctx, database.ListOptions{})
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("unexpected number of entries; got %d, want 1", len(entries))
+		}
+	})
+}
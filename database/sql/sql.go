@@ -5,7 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/uhthomas/kipp/database"
 )
@@ -13,29 +18,86 @@ import (
 // A Database is a wrapper around a sql db which provides high level
 // functions defined in database.Database.
 type Database struct {
-	db         *sql.DB
-	createStmt *sql.Stmt
-	removeStmt *sql.Stmt
-	lookupStmt *sql.Stmt
-}
-
-const initQuery = `CREATE TABLE IF NOT EXISTS entries (
-	id SERIAL PRIMARY KEY NOT NULL,
-	slug VARCHAR(16) NOT NULL,
-	name VARCHAR(255) NOT NULL,
-	sum varchar(87) NOT NULL, -- len(b64([64]byte))
-	size BIGINT NOT NULL,
-	lifetime TIMESTAMP,
-	timestamp TIMESTAMP NOT NULL
-);
-
-CREATE UNIQUE INDEX IF NOT EXISTS idx_slug ON entries (slug)`
-
-// Open opens a new sql database and prepares relevant statements.
-func Open(ctx context.Context, driver, name string) (_ *Database, err error) {
-	db, err := sql.Open(driver, name)
+	db                   *sql.DB
+	driver               string
+	createStmt           *sql.Stmt
+	createTagStmt        *sql.Stmt
+	deleteStmt           *sql.Stmt
+	deleteTagsStmt       *sql.Stmt
+	lookupStmt           *sql.Stmt
+	lookupBySumStmt      *sql.Stmt
+	touchStmt            *sql.Stmt
+	extendStmt           *sql.Stmt
+	softDeleteStmt       *sql.Stmt
+	restoreStmt          *sql.Stmt
+	blockStmt            *sql.Stmt
+	unblockStmt          *sql.Stmt
+	renameStmt           *sql.Stmt
+	setLifetimeStmt      *sql.Stmt
+	addDownloadsStmt     *sql.Stmt
+	downloadsStmt        *sql.Stmt
+	removeDownloadsStmt  *sql.Stmt
+	denyStmt             *sql.Stmt
+	deniedStmt           *sql.Stmt
+	allowStmt            *sql.Stmt
+	reportStmt           *sql.Stmt
+	resolveReportsStmt   *sql.Stmt
+	auditStmt            *sql.Stmt
+	createUserStmt       *sql.Stmt
+	userStmt             *sql.Stmt
+	deleteUserStmt       *sql.Stmt
+	deleteUserTokensStmt *sql.Stmt
+	addUserTokenStmt     *sql.Stmt
+	removeUserTokenStmt  *sql.Stmt
+	userByTokenStmt      *sql.Stmt
+	// slugOrder and idOrder are the expressions slugs and the IDs of audit
+	// records are ordered by when listing, which must order bytewise.
+	slugOrder, idOrder string
+	// day is the expression for the UTC day an entry was created, as
+	// YYYY-MM-DD.
+	day     string
+	migrate MigrateMode
+	// asOf follows the tables of stats queries, reading them as of a
+	// recent time on CockroachDB so they don't contend with writes.
+	asOf string
+	// reader is the pool lookups and lists are read from, or nil if
+	// they're read from db.
+	reader     *sql.DB
+	readerName string
+	recent     *recent
+	log        *slog.Logger
+}
+
+// An Option configures a Database.
+type Option func(ctx context.Context, db *Database) error
+
+// Migrate sets how pending migrations are handled, MigrateAuto by default.
+func Migrate(mode MigrateMode) Option {
+	return func(ctx context.Context, db *Database) error {
+		db.migrate = mode
+		return nil
+	}
+}
+
+// Logger sets the logger failed reads from the Reader and pending migrations
+// are logged to. The default is slog.Default.
+func Logger(l *slog.Logger) Option {
+	return func(ctx context.Context, db *Database) error {
+		db.log = l
+		return nil
+	}
+}
+
+// Open opens a new sql database, migrates its schema and prepares relevant
+// statements. The SQLite driver is handled specially, anything else is
+// assumed to be PostgreSQL compatible.
+func Open(ctx context.Context, driver, name string, opts ...Option) (_ *Database, err error) {
+	db, err := openDB(driver, name)
 	if err != nil {
-		return nil, fmt.Errorf("sql open: %w", err)
+		return nil, err
+	}
+	if driver == SQLite {
+		sqliteConfigureWriter(db)
 	}
 	defer func() {
 		if err != nil {
@@ -43,26 +105,83 @@ func Open(ctx context.Context, driver, name string) (_ *Database, err error) {
 		}
 	}()
 
-	db.SetConnMaxIdleTime(5 * time.Minute)
-	db.SetConnMaxLifetime(5 * time.Minute)
-	db.SetMaxIdleConns(20)
-	db.SetMaxOpenConns(25)
-
 	if err := db.PingContext(ctx); err != nil {
 		return nil, fmt.Errorf("ping: %w", err)
 	}
-	if _, err := db.ExecContext(ctx, initQuery); err != nil {
-		return nil, fmt.Errorf("exec init: %w", err)
-	}
 
-	d := &Database{db: db}
+	d := &Database{
+		db:        db,
+		driver:    driver,
+		slugOrder: `slug COLLATE "C"`,
+		idOrder:   `id COLLATE "C"`,
+		day:       "to_char(timestamp, 'YYYY-MM-DD')",
+		log:       slog.Default(),
+	}
+	if driver == SQLite {
+		d.slugOrder, d.idOrder, d.day = "slug", "id", "date(timestamp)"
+	} else {
+		var version string
+		if err := db.QueryRowContext(ctx, "SELECT version()").Scan(&version); err != nil {
+			return nil, fmt.Errorf("version: %w", err)
+		}
+		if strings.Contains(version, "CockroachDB") {
+			d.asOf = " AS OF SYSTEM TIME '-10s'"
+		}
+	}
+	for _, opt := range opts {
+		if err := opt(ctx, d); err != nil {
+			return nil, err
+		}
+	}
+	if d.readerName != "" {
+		// The reader isn't pinged, as lookups fall back to the writer
+		// while it's down.
+		if d.reader, err = openDB(driver, d.readerName); err != nil {
+			return nil, fmt.Errorf("reader: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				d.reader.Close()
+			}
+		}()
+	}
+	if err := migrate(ctx, db, driver, d.migrate, d.log); err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
 	for _, v := range []struct {
 		query string
 		out   **sql.Stmt
 	}{
 		{query: createQuery, out: &d.createStmt},
-		{query: removeQuery, out: &d.removeStmt},
+		{query: createTagQuery, out: &d.createTagStmt},
+		{query: deleteQuery, out: &d.deleteStmt},
+		{query: deleteTagsQuery, out: &d.deleteTagsStmt},
 		{query: lookupQuery, out: &d.lookupStmt},
+		{query: touchQuery, out: &d.touchStmt},
+		{query: extendQuery, out: &d.extendStmt},
+		{query: softDeleteQuery, out: &d.softDeleteStmt},
+		{query: restoreQuery, out: &d.restoreStmt},
+		{query: blockQuery, out: &d.blockStmt},
+		{query: unblockQuery, out: &d.unblockStmt},
+		{query: renameQuery, out: &d.renameStmt},
+		{query: setLifetimeQuery, out: &d.setLifetimeStmt},
+		{query: addDownloadsQuery, out: &d.addDownloadsStmt},
+		{query: downloadsQuery, out: &d.downloadsStmt},
+		{query: removeDownloadsQuery, out: &d.removeDownloadsStmt},
+		{query: denyQuery, out: &d.denyStmt},
+		{query: deniedQuery, out: &d.deniedStmt},
+		{query: allowQuery, out: &d.allowStmt},
+		{query: reportQuery, out: &d.reportStmt},
+		{query: auditQuery, out: &d.auditStmt},
+		{query: resolveReportsQuery, out: &d.resolveReportsStmt},
+		{query: createUserQuery, out: &d.createUserStmt},
+		{query: userQuery, out: &d.userStmt},
+		{query: deleteUserQuery, out: &d.deleteUserStmt},
+		{query: deleteUserTokensQuery, out: &d.deleteUserTokensStmt},
+		{query: addUserTokenQuery, out: &d.addUserTokenStmt},
+		{query: removeUserTokenQuery, out: &d.removeUserTokenStmt},
+		{query: userByTokenQuery, out: &d.userByTokenStmt},
+		{query: fmt.Sprintf(lookupBySumQuery, d.slugOrder), out: &d.lookupBySumStmt},
 	} {
 		var err error
 		if *v.out, err = db.PrepareContext(ctx, v.query); err != nil {
@@ -72,52 +191,241 @@ func Open(ctx context.Context, driver, name string) (_ *Database, err error) {
 	return d, nil
 }
 
+// openDB opens a pool for the named database.
+func openDB(driver, name string) (*sql.DB, error) {
+	dsn := name
+	if driver == SQLite {
+		dsn = sqliteName(name)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql open: %w", err)
+	}
+
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxIdleConns(20)
+	db.SetMaxOpenConns(25)
+	if driver == SQLite {
+		sqliteConfigure(db, name)
+	}
+	return db, nil
+}
+
 const createQuery = `INSERT INTO entries (
 	slug,
 	name,
 	sum,
 	size,
 	lifetime,
-	timestamp
-) VALUES ($1, $2, $3, $4, $5, $6)`
+	timestamp,
+	last_access,
+	gzip_size,
+	deleted,
+	delete_reason,
+	blocked,
+	block_reason,
+	owner
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
 
-// Create inserts e into the underlying db.
+const createTagQuery = "INSERT INTO tags (slug, tag) VALUES ($1, $2)"
+
+// Create inserts e into the underlying db, returning database.ErrConflict if
+// an entry with the same slug already exists.
 func (db *Database) Create(ctx context.Context, e database.Entry) error {
-	if _, err := db.createStmt.ExecContext(ctx,
+	// Entries with tags are inserted in a transaction with them.
+	if len(e.Tags) > 0 {
+		return db.CreateBatch(ctx, []database.Entry{e})
+	}
+	if err := retry(ctx, func() error { return create(ctx, db.createStmt, nil, e) }); err != nil {
+		return err
+	}
+	db.recent.add(e.Slug, e.Sum)
+	return nil
+}
+
+// CreateBatch inserts entries in a single transaction, returning the error of
+// the first which can't be inserted.
+func (db *Database) CreateBatch(ctx context.Context, entries []database.Entry) error {
+	if err := db.tx(ctx, func(tx *sql.Tx) error {
+		stmt, tagStmt := tx.StmtContext(ctx, db.createStmt), tx.StmtContext(ctx, db.createTagStmt)
+		for _, e := range entries {
+			if err := create(ctx, stmt, tagStmt, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		db.recent.add(e.Slug, e.Sum)
+	}
+	return nil
+}
+
+// create inserts e with stmt, and its tags with tagStmt, which are either the
+// create statements or the same statements bound to a transaction. The tag
+// statement may be nil if e has no tags.
+func create(ctx context.Context, stmt, tagStmt *sql.Stmt, e database.Entry) error {
+	if _, err := stmt.ExecContext(ctx,
 		e.Slug,
 		e.Name,
 		e.Sum,
 		e.Size,
-		e.Lifetime,
-		e.Timestamp,
+		utcPtr(e.Lifetime),
+		e.Timestamp.UTC(),
+		utcPtr(e.LastAccess),
+		e.GzipSize,
+		utcPtr(e.Deleted),
+		e.DeleteReason,
+		utcPtr(e.Blocked),
+		e.BlockReason,
+		e.Owner,
 	); err != nil {
+		if isUniqueViolation(err) {
+			return database.ErrConflict
+		}
 		return fmt.Errorf("exec: %w", err)
 	}
+	for _, tag := range e.Tags {
+		if _, err := tagStmt.ExecContext(ctx, e.Slug, tag); err != nil {
+			return fmt.Errorf("exec tag: %w", err)
+		}
+	}
 	return nil
 }
 
-const removeQuery = "DELETE FROM entries WHERE slug = $1"
-
-// Remove removes the entry with the given slug.
-func (db *Database) Remove(ctx context.Context, slug string) error {
-	if _, err := db.removeStmt.ExecContext(ctx, slug); err != nil {
-		return fmt.Errorf("exec: %w", err)
+// isUniqueViolation reports whether err is from violating a unique index or
+// primary key. The drivers are matched by their methods, so they needn't be
+// imported.
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState() == "23505" // unique_violation
 	}
-	return nil
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case 2067, 1555: // SQLITE_CONSTRAINT_UNIQUE, SQLITE_CONSTRAINT_PRIMARYKEY
+			return true
+		}
+	}
+	return false
 }
 
-const lookupQuery = "SELECT slug, name, sum, size, lifetime, timestamp FROM entries WHERE slug = $1"
+const (
+	deleteQuery     = "DELETE FROM entries WHERE slug = $1"
+	deleteTagsQuery = "DELETE FROM tags WHERE slug = $1"
+)
 
-// Lookup looks up the entry for the given slug.
-func (db *Database) Lookup(ctx context.Context, slug string) (e database.Entry, err error) {
-	if err := db.lookupStmt.QueryRowContext(ctx, slug).Scan(
+// Delete deletes the entry with the given slug, and its tags.
+func (db *Database) Delete(ctx context.Context, slug string) error {
+	defer db.recent.add(slug, "")
+	return db.tx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, db.deleteTagsStmt).ExecContext(ctx, slug); err != nil {
+			return fmt.Errorf("exec tags: %w", err)
+		}
+		return exec(ctx, tx.StmtContext(ctx, db.deleteStmt), slug)
+	})
+}
+
+// selectQuery selects entries, with their tags aggregated into a comma
+// separated list.
+const selectQuery = `SELECT slug, name, sum, size, lifetime, timestamp, last_access, gzip_size, deleted, delete_reason,
+	blocked, block_reason, owner,
+	(SELECT string_agg(tag, ',') FROM tags WHERE tags.slug = entries.slug)
+FROM entries`
+
+// scan scans an entry selected by selectQuery.
+func scan(row interface{ Scan(...interface{}) error }) (e database.Entry, err error) {
+	var tags sql.NullString
+	defer func() {
+		// Tags are aggregated in no particular order.
+		if err == nil && tags.String != "" {
+			e.Tags = strings.Split(tags.String, ",")
+			sort.Strings(e.Tags)
+		}
+	}()
+	return e, row.Scan(
 		&e.Slug,
 		&e.Name,
 		&e.Sum,
 		&e.Size,
 		&e.Lifetime,
 		&e.Timestamp,
-	); err != nil {
+		&e.LastAccess,
+		&e.GzipSize,
+		&e.Deleted,
+		&e.DeleteReason,
+		&e.Blocked,
+		&e.BlockReason,
+		&e.Owner,
+		&tags,
+	)
+}
+
+const lookupQuery = selectQuery + " WHERE slug = $1"
+
+// Lookup looks up the entry for the given slug.
+func (db *Database) Lookup(ctx context.Context, slug string) (database.Entry, error) {
+	e, err := scan(db.queryRow(ctx, slugKey(slug), db.lookupStmt, lookupQuery, slug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e, database.ErrNoResults
+		}
+		return e, fmt.Errorf("query row: %w", err)
+	}
+	return e, nil
+}
+
+// LookupMany looks up the named entries with a single query. They're read
+// from the writer if any were written too recently to be read from the
+// reader.
+func (db *Database) LookupMany(ctx context.Context, slugs []string) (map[string]database.Entry, error) {
+	m := make(map[string]database.Entry, len(slugs))
+	if len(slugs) == 0 {
+		return m, nil
+	}
+	var recent bool
+	params := make([]string, len(slugs))
+	args := make([]interface{}, len(slugs))
+	for i, slug := range slugs {
+		params[i], args[i] = "$"+strconv.Itoa(i+1), slug
+		recent = recent || db.recent.has(slugKey(slug))
+	}
+	q := selectQuery + " WHERE slug IN (" + strings.Join(params, ", ") + ")"
+	query := db.query
+	if recent {
+		query = db.db.QueryContext
+	}
+	rows, err := query(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		m[e.Slug] = e
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return m, nil
+}
+
+// lookupBySumQuery is formatted with the expression slugs are ordered by.
+const lookupBySumQuery = selectQuery + " WHERE sum = $1 ORDER BY timestamp DESC, %s DESC LIMIT 1"
+
+// LookupBySum looks up the newest entry with the given sum.
+func (db *Database) LookupBySum(ctx context.Context, sum string) (database.Entry, error) {
+	q := fmt.Sprintf(lookupBySumQuery, db.slugOrder)
+	e, err := scan(db.queryRow(ctx, sumKey(sum), db.lookupBySumStmt, q, sum))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return e, database.ErrNoResults
 		}
@@ -126,8 +434,527 @@ func (db *Database) Lookup(ctx context.Context, slug string) (e database.Entry,
 	return e, nil
 }
 
+// List lists entries matching opts, paging through them by timestamp and
+// slug so concurrent inserts never cause entries to be skipped or repeated.
+func (db *Database) List(ctx context.Context, opts database.ListOptions) ([]database.Entry, string, error) {
+	return db.list(ctx, opts, nil)
+}
+
+// list lists entries matching opts and the conditions returned by filter, if
+// it isn't nil, which adds its arguments with arg.
+func (db *Database) list(ctx context.Context, opts database.ListOptions, filter func(arg func(v interface{}) string) []string) ([]database.Entry, string, error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter != nil {
+		where = append(where, filter(arg)...)
+	}
+	op, order := ">", "ASC"
+	if opts.Descending {
+		op, order = "<", "DESC"
+	}
+	if !c.IsZero() {
+		where = append(where, fmt.Sprintf("(timestamp, %s) %s (%s, %s)",
+			db.slugOrder, op, arg(c.Timestamp.UTC()), arg(c.Slug),
+		))
+	}
+	if opts.Expired {
+		where = append(where, "lifetime <= "+arg(time.Now().UTC()))
+	}
+	if opts.Deleted {
+		where = append(where, "deleted IS NOT NULL")
+	}
+	if !opts.CreatedBefore.IsZero() {
+		where = append(where, "timestamp < "+arg(opts.CreatedBefore.UTC()))
+	}
+	if !opts.CreatedAfter.IsZero() {
+		where = append(where, "timestamp > "+arg(opts.CreatedAfter.UTC()))
+	}
+	if opts.Owner != "" {
+		where = append(where, "owner = "+arg(opts.Owner))
+	}
+	if opts.Tag != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM tags WHERE tags.slug = entries.slug AND tags.tag = "+arg(opts.Tag)+")")
+	}
+	if p := opts.NamePrefix; p != "" {
+		// LIKE is case insensitive in SQLite, and would need escaping.
+		where = append(where, fmt.Sprintf("substr(name, 1, %s) = %s",
+			arg(utf8.RuneCountInString(p)), arg(p),
+		))
+	}
+	q := selectQuery
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += fmt.Sprintf(" ORDER BY timestamp %s, %s %s LIMIT %s",
+		order, db.slugOrder, order, arg(opts.Size()+1),
+	)
+
+	rows, err := db.query(ctx, q, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []database.Entry
+	for rows.Next() {
+		e, err := scan(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("scan: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("rows: %w", err)
+	}
+	entries, next := opts.Page(entries)
+	return entries, next, nil
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchByName lists entries whose names match query. PostgreSQL matches with
+// LIKE, which can use the index on lowercased names for prefixes. SQLite only
+// folds ASCII, so returns database.ErrUnsupported for other case insensitive
+// searches.
+func (db *Database) SearchByName(ctx context.Context, query string, opts database.SearchOptions) ([]database.Entry, string, error) {
+	if db.driver == SQLite && !opts.CaseSensitive && !isASCII(query) {
+		return nil, "", database.ErrUnsupported
+	}
+	return db.list(ctx, opts.ListOptions, func(arg func(v interface{}) string) []string {
+		if db.driver != SQLite {
+			pattern := likeEscaper.Replace(query) + "%"
+			if !opts.Prefix {
+				pattern = "%" + pattern
+			}
+			if opts.CaseSensitive {
+				return []string{fmt.Sprintf(`name LIKE %s ESCAPE '\'`, arg(pattern))}
+			}
+			return []string{fmt.Sprintf(`lower(name) LIKE lower(%s) ESCAPE '\'`, arg(pattern))}
+		}
+		name, q := "name", query
+		if !opts.CaseSensitive {
+			name, q = "lower(name)", strings.ToLower(query)
+		}
+		if opts.Prefix {
+			return []string{fmt.Sprintf("substr(%s, 1, %s) = %s",
+				name, arg(utf8.RuneCountInString(q)), arg(q),
+			)}
+		}
+		return []string{fmt.Sprintf("instr(%s, %s) > 0", name, arg(q))}
+	})
+}
+
+// isASCII reports whether s is entirely ASCII.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+const touchQuery = "UPDATE entries SET last_access = $2 WHERE slug = $1"
+
+// Touch sets the last access time of the named entry to t.
+func (db *Database) Touch(ctx context.Context, slug string, t time.Time) error {
+	return execRetry(ctx, db.touchStmt, slug, t.UTC())
+}
+
+const extendQuery = "UPDATE entries SET lifetime = $2 WHERE slug = $1 AND lifetime < $2"
+
+// Extend sets the lifetime of the named entry to t if it has a lifetime which
+// ends before t.
+func (db *Database) Extend(ctx context.Context, slug string, t time.Time) error {
+	return retry(ctx, func() error {
+		if _, err := db.extendStmt.ExecContext(ctx, slug, t.UTC()); err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+		return nil
+	})
+}
+
+const setLifetimeQuery = "UPDATE entries SET lifetime = $2 WHERE slug = $1"
+
+// SetLifetime sets the lifetime of the named entry to t, or removes it if t
+// is nil.
+func (db *Database) SetLifetime(ctx context.Context, slug string, t *time.Time) error {
+	defer db.recent.add(slug, "")
+	return execRetry(ctx, db.setLifetimeStmt, slug, utcPtr(t))
+}
+
+const softDeleteQuery = "UPDATE entries SET deleted = $2, delete_reason = $3 WHERE slug = $1"
+
+// SoftDelete marks the named entry as deleted at t for the given reason.
+func (db *Database) SoftDelete(ctx context.Context, slug string, t time.Time, reason string) error {
+	defer db.recent.add(slug, "")
+	return execRetry(ctx, db.softDeleteStmt, slug, t.UTC(), reason)
+}
+
+const restoreQuery = "UPDATE entries SET deleted = NULL, delete_reason = '' WHERE slug = $1"
+
+// Restore unmarks the named entry as deleted.
+func (db *Database) Restore(ctx context.Context, slug string) error {
+	defer db.recent.add(slug, "")
+	return execRetry(ctx, db.restoreStmt, slug)
+}
+
+const blockQuery = "UPDATE entries SET blocked = $2, block_reason = $3 WHERE slug = $1"
+
+// Block marks the named entry as blocked at t for the given reason.
+func (db *Database) Block(ctx context.Context, slug string, t time.Time, reason string) error {
+	defer db.recent.add(slug, "")
+	return execRetry(ctx, db.blockStmt, slug, t.UTC(), reason)
+}
+
+const unblockQuery = "UPDATE entries SET blocked = NULL, block_reason = '' WHERE slug = $1"
+
+// Unblock unmarks the named entry as blocked.
+func (db *Database) Unblock(ctx context.Context, slug string) error {
+	defer db.recent.add(slug, "")
+	return execRetry(ctx, db.unblockStmt, slug)
+}
+
+const renameQuery = "UPDATE entries SET name = $2 WHERE slug = $1"
+
+// Rename sets the name of the named entry.
+func (db *Database) Rename(ctx context.Context, slug, name string) error {
+	defer db.recent.add(slug, "")
+	return execRetry(ctx, db.renameStmt, slug, name)
+}
+
+// exec executes stmt, returning database.ErrNoResults if no rows were
+// affected.
+func exec(ctx context.Context, stmt *sql.Stmt, args ...interface{}) error {
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return database.ErrNoResults
+	}
+	return nil
+}
+
+// execRetry executes stmt as exec does, retrying serialization failures. It
+// mustn't be used for statements bound to a transaction, which is aborted by
+// them and must be retried from the start.
+func execRetry(ctx context.Context, stmt *sql.Stmt, args ...interface{}) error {
+	return retry(ctx, func() error { return exec(ctx, stmt, args...) })
+}
+
+const addDownloadsQuery = `INSERT INTO downloads (slug, day, count) VALUES ($1, $2, $3)
+ON CONFLICT (slug, day) DO UPDATE SET count = downloads.count + excluded.count`
+
+// AddDownloads adds n to the download count of the named entry for the day
+// containing t, in a transaction which is retried if it contends with
+// another.
+func (db *Database) AddDownloads(ctx context.Context, slug string, t time.Time, n int64) error {
+	return db.tx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, db.addDownloadsStmt).ExecContext(ctx, slug, database.Day(t), n); err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+		return nil
+	})
+}
+
+const downloadsQuery = "SELECT day, count FROM downloads WHERE slug = $1 AND day >= $2 ORDER BY day"
+
+// Downloads returns the download counts of the named entry for each day
+// since t, oldest first.
+func (db *Database) Downloads(ctx context.Context, slug string, t time.Time) ([]database.Downloads, error) {
+	rows, err := db.downloadsStmt.QueryContext(ctx, slug, database.Day(t))
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var d []database.Downloads
+	for rows.Next() {
+		var v database.Downloads
+		if err := rows.Scan(&v.Day, &v.Count); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		d = append(d, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return d, nil
+}
+
+const removeDownloadsQuery = "DELETE FROM downloads WHERE slug = $1"
+
+// RemoveDownloads removes the download counts of the named entry.
+func (db *Database) RemoveDownloads(ctx context.Context, slug string) error {
+	if _, err := db.removeDownloadsStmt.ExecContext(ctx, slug); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	return nil
+}
+
+const denyQuery = `INSERT INTO denials (sum, reason, timestamp) VALUES ($1, $2, $3)
+ON CONFLICT (sum) DO UPDATE SET reason = excluded.reason, timestamp = excluded.timestamp`
+
+// Deny adds d to the denylist, replacing any denial of the same sum.
+func (db *Database) Deny(ctx context.Context, d database.Denial) error {
+	return retry(ctx, func() error {
+		if _, err := db.denyStmt.ExecContext(ctx, d.Sum, d.Reason, d.Time.UTC()); err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+		return nil
+	})
+}
+
+const deniedQuery = "SELECT sum, reason, timestamp FROM denials WHERE sum = $1"
+
+// Denied returns the denial of sum. It's always read from the writer, so
+// sums are denied as soon as they're added.
+func (db *Database) Denied(ctx context.Context, sum string) (database.Denial, error) {
+	var d database.Denial
+	if err := db.deniedStmt.QueryRowContext(ctx, sum).Scan(&d.Sum, &d.Reason, &d.Time); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return d, database.ErrNoResults
+		}
+		return d, fmt.Errorf("scan: %w", err)
+	}
+	return d, nil
+}
+
+const allowQuery = "DELETE FROM denials WHERE sum = $1"
+
+// Allow removes the denial of sum.
+func (db *Database) Allow(ctx context.Context, sum string) error {
+	return execRetry(ctx, db.allowStmt, sum)
+}
+
+const denialsQuery = "SELECT sum, reason, timestamp FROM denials ORDER BY timestamp, sum"
+
+// Denials returns every denial, oldest first.
+func (db *Database) Denials(ctx context.Context) ([]database.Denial, error) {
+	rows, err := db.query(ctx, denialsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var denials []database.Denial
+	for rows.Next() {
+		var d database.Denial
+		if err := rows.Scan(&d.Sum, &d.Reason, &d.Time); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		denials = append(denials, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return denials, nil
+}
+
+const reportQuery = `INSERT INTO reports (slug, category, description, contact, status, timestamp)
+VALUES ($1, $2, $3, $4, $5, $6)`
+
+// Report stores r.
+func (db *Database) Report(ctx context.Context, r database.Report) error {
+	return execRetry(ctx, db.reportStmt, r.Slug, r.Category, r.Description, r.Contact, string(r.Status), r.Time.UTC())
+}
+
+const reportsQuery = "SELECT slug, category, description, contact, status, timestamp FROM reports"
+
+// Reports returns the reports with status, or every report if it's empty,
+// oldest first.
+func (db *Database) Reports(ctx context.Context, status database.ReportStatus) ([]database.Report, error) {
+	q, args := reportsQuery, []interface{}{}
+	if status != "" {
+		q, args = q+" WHERE status = $1", append(args, string(status))
+	}
+	rows, err := db.query(ctx, q+" ORDER BY timestamp", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []database.Report
+	for rows.Next() {
+		var r database.Report
+		if err := rows.Scan(&r.Slug, &r.Category, &r.Description, &r.Contact, &r.Status, &r.Time); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		reports = append(reports, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return reports, nil
+}
+
+const resolveReportsQuery = "UPDATE reports SET status = $2 WHERE slug = $1 AND status = $3"
+
+// ResolveReports resolves the open reports of the named entry.
+func (db *Database) ResolveReports(ctx context.Context, slug string) error {
+	return execRetry(ctx, db.resolveReportsStmt, slug, string(database.ReportResolved), string(database.ReportOpen))
+}
+
+const auditQuery = `INSERT INTO audit (id, timestamp, actor, action, target, request_id, remote, before_value, after_value)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+// Audit appends r to the audit log.
+func (db *Database) Audit(ctx context.Context, r database.AuditRecord) error {
+	return execRetry(ctx, db.auditStmt, r.ID, r.Time.UTC(), r.Actor, r.Action, r.Target, r.RequestID, r.Remote, r.Before, r.After)
+}
+
+// AuditLog lists records matching opts, newest first, paging through them by
+// timestamp and ID.
+func (db *Database) AuditLog(ctx context.Context, opts database.AuditOptions) ([]database.AuditRecord, string, error) {
+	c, err := database.ParseCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if !c.IsZero() {
+		where = append(where, fmt.Sprintf("(timestamp, %s) < (%s, %s)", db.idOrder, arg(c.Timestamp.UTC()), arg(c.Slug)))
+	}
+	for _, v := range []struct{ column, value string }{
+		{"actor", opts.Actor},
+		{"action", opts.Action},
+		{"target", opts.Target},
+	} {
+		if v.value != "" {
+			where = append(where, v.column+" = "+arg(v.value))
+		}
+	}
+	if !opts.Before.IsZero() {
+		where = append(where, "timestamp < "+arg(opts.Before.UTC()))
+	}
+	if !opts.After.IsZero() {
+		where = append(where, "timestamp > "+arg(opts.After.UTC()))
+	}
+	q := "SELECT id, timestamp, actor, action, target, request_id, remote, before_value, after_value FROM audit"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += fmt.Sprintf(" ORDER BY timestamp DESC, %s DESC LIMIT %s", db.idOrder, arg(opts.Size()+1))
+
+	rows, err := db.query(ctx, q, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var records []database.AuditRecord
+	for rows.Next() {
+		var r database.AuditRecord
+		if err := rows.Scan(&r.ID, &r.Time, &r.Actor, &r.Action, &r.Target, &r.RequestID, &r.Remote, &r.Before, &r.After); err != nil {
+			return nil, "", fmt.Errorf("scan: %w", err)
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("rows: %w", err)
+	}
+	records, next := opts.Page(records)
+	return records, next, nil
+}
+
+const statsQuery = `SELECT
+	COUNT(*),
+	COALESCE(SUM(size), 0),
+	COALESCE(SUM(gzip_size), 0),
+	COALESCE(SUM(CASE WHEN lifetime > $1 AND lifetime <= $2 THEN size ELSE 0 END), 0),
+	COALESCE(SUM(CASE WHEN lifetime > $1 AND lifetime <= $3 THEN size ELSE 0 END), 0)
+FROM entries`
+
+// daysQuery is formatted with the expression for the day of an entry, and
+// the AS OF clause.
+const daysQuery = "SELECT %[1]s, COUNT(*), SUM(size) FROM entries%[2]s GROUP BY %[1]s ORDER BY %[1]s"
+
+// Stats aggregates statistics about every entry. On CockroachDB they're read
+// as of ten seconds ago, so they needn't wait for or abort writes.
+func (db *Database) Stats(ctx context.Context, now time.Time) (database.Stats, error) {
+	var s database.Stats
+	now = now.UTC()
+	rows, err := db.query(ctx, statsQuery+db.asOf, now, now.Add(24*time.Hour), now.Add(7*24*time.Hour))
+	if err != nil {
+		return s, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&s.Entries, &s.Bytes, &s.GzipBytes, &s.ExpiringDay, &s.ExpiringWeek); err != nil {
+			return s, fmt.Errorf("scan: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return s, fmt.Errorf("rows: %w", err)
+	}
+	rows.Close()
+
+	if rows, err = db.query(ctx, fmt.Sprintf(daysQuery, db.day, db.asOf)); err != nil {
+		return s, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			day string
+			d   database.DayStats
+		)
+		if err := rows.Scan(&day, &d.Entries, &d.Bytes); err != nil {
+			return s, fmt.Errorf("scan: %w", err)
+		}
+		if d.Day, err = time.Parse("2006-01-02", day); err != nil {
+			return s, fmt.Errorf("parse day: %w", err)
+		}
+		s.Days = append(s.Days, d)
+	}
+	if err := rows.Err(); err != nil {
+		return s, fmt.Errorf("rows: %w", err)
+	}
+	return s, nil
+}
+
 // Ping pings the underlying db.
 func (db *Database) Ping(ctx context.Context) error { return db.db.PingContext(ctx) }
 
-// Close closes the underlying db.
-func (db *Database) Close(context.Context) error { return db.db.Close() }
+// Close closes the underlying db, and the reader if there is one.
+func (db *Database) Close(context.Context) error {
+	if db.reader != nil {
+		if err := db.reader.Close(); err != nil {
+			db.db.Close()
+			return fmt.Errorf("close reader: %w", err)
+		}
+	}
+	return db.db.Close()
+}
+
+// utcPtr returns t in UTC, or nil if t is nil. Times are always stored in UTC
+// so they compare correctly regardless of the database's time zone.
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
@@ -0,0 +1,63 @@
+package sql_test
+
+import (
+	"context"
+	stdsql "database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/sql"
+	_ "modernc.org/sqlite"
+)
+
+func run(t *testing.T, driver string, name func(t *testing.T) string) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := sql.Open(context.Background(), driver, name(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close(context.Background()) })
+		return db
+	})
+}
+
+func TestSQLite(t *testing.T) {
+	t.Run("File", func(t *testing.T) {
+		run(t, sql.SQLite, func(t *testing.T) string {
+			return filepath.Join(t.TempDir(), "kipp.db")
+		})
+	})
+	t.Run("Memory", func(t *testing.T) {
+		run(t, sql.SQLite, func(*testing.T) string { return ":memory:" })
+	})
+}
+
+// TestPostgres runs against the database named by KIPP_TEST_POSTGRES, which
+// will have its tables dropped between tests.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("KIPP_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("KIPP_TEST_POSTGRES not set")
+	}
+	run(t, "pgx", postgres(dsn))
+}
+
+// postgres returns a function which drops the tables of the database named by
+// dsn, and returns dsn.
+func postgres(dsn string) func(t *testing.T) string {
+	return func(t *testing.T) string {
+		db, err := stdsql.Open("pgx", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Exec("DROP TABLE IF EXISTS entries, downloads, denials, reports, audit, schema_version"); err != nil {
+			t.Fatal(err)
+		}
+		return dsn
+	}
+}
@@ -0,0 +1,47 @@
+package sql
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// SQLite is the name of the SQLite driver, modernc.org/sqlite.
+const SQLite = "sqlite"
+
+// sqliteName returns name with the parameters kipp relies on. WAL allows
+// readers to proceed concurrently with a writer, and the busy timeout makes
+// writers wait rather than fail while another process holds the lock.
+// Transactions take the write lock immediately, so they wait rather than fail
+// too. Times are written in a format which sorts lexically, as they're always
+// UTC.
+func sqliteName(name string) string {
+	sep := "?"
+	if strings.Contains(name, "?") {
+		sep = "&"
+	}
+	return name + sep + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite&_txlock=immediate"
+}
+
+// sqliteConfigure configures db for the named database. Each connection to
+// an in-memory database has its own database, so there must only ever be
+// one, and it must never be closed.
+func sqliteConfigure(db *sql.DB, name string) {
+	if name != ":memory:" &&
+		!strings.HasPrefix(name, "file::memory:") &&
+		!strings.Contains(name, "mode=memory") {
+		return
+	}
+	db.SetConnMaxIdleTime(0)
+	db.SetConnMaxLifetime(0)
+	db.SetMaxIdleConns(1)
+	db.SetMaxOpenConns(1)
+}
+
+// sqliteConfigureWriter configures db, the pool written to, to have a single
+// connection. SQLite only allows one writer at a time, and concurrent writers
+// on separate connections can fail with SQLITE_BUSY however long the busy
+// timeout is, so writes wait for each other in the pool instead. A Reader
+// has its own pool, which isn't limited.
+func sqliteConfigureWriter(db *sql.DB) {
+	db.SetMaxOpenConns(1)
+}
@@ -0,0 +1,59 @@
+package sql_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/sql"
+	_ "modernc.org/sqlite"
+)
+
+// TestSQLiteConcurrentWrites checks concurrent writes to a database on disk
+// wait for each other rather than fail with SQLITE_BUSY. It's run with the
+// race detector by Bazel, which slows writes enough for some to wait longer
+// than the busy timeout if they aren't queued.
+func TestSQLiteConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open(ctx, sql.SQLite, filepath.Join(t.TempDir(), "kipp.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+
+	const workers, n = 16, 625
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				slug := fmt.Sprintf("%02d-%02d", i, j)
+				now := time.Now()
+				if err := db.Create(ctx, database.Entry{Slug: slug, Name: slug, Sum: slug, Timestamp: now}); err != nil {
+					errs <- fmt.Errorf("create %s: %w", slug, err)
+					return
+				}
+				if err := db.AddDownloads(ctx, slug, now, 1); err != nil {
+					errs <- fmt.Errorf("add downloads %s: %w", slug, err)
+					return
+				}
+				if _, err := db.Lookup(ctx, slug); err != nil {
+					errs <- fmt.Errorf("lookup %s: %w", slug, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	// maxAttempts is the most times a serialization failure is retried.
+	maxAttempts = 10
+	// backoff is the delay before the first retry, which doubles for each
+	// retry after up to maxBackoff.
+	backoff, maxBackoff = 10 * time.Millisecond, time.Second
+)
+
+// isSerializationFailure reports whether err is from a transaction which was
+// aborted to keep transactions serializable, and can be retried. CockroachDB
+// returns these for contended transactions, as does PostgreSQL for those run
+// at the serializable isolation level.
+func isSerializationFailure(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch pgErr.SQLState() {
+		case "40001", "40P01": // serialization_failure, deadlock_detected
+			return true
+		}
+	}
+	return false
+}
+
+// retry calls f until it doesn't fail with a serialization failure, backing
+// off between attempts. Retries never outlast the deadline of ctx.
+func retry(ctx context.Context, f func() error) error {
+	d := backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= maxAttempts || !isSerializationFailure(err) {
+			return err
+		}
+		jittered := d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < jittered {
+			return err
+		}
+		t := time.NewTimer(jittered)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		if d *= 2; d > maxBackoff {
+			d = maxBackoff
+		}
+	}
+}
+
+// tx calls f in a transaction, which is committed if f succeeds and rolled
+// back otherwise. The transaction is retried from the start if it fails with
+// a serialization failure, so f must be safe to call more than once.
+func (db *Database) tx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	return retry(ctx, func() (err error) {
+		tx, err := db.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			}
+		}()
+		if err := f(tx); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+		return nil
+	})
+}
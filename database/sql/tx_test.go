@@ -0,0 +1,92 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// pgError is a PostgreSQL error with the given SQLSTATE.
+type pgError string
+
+func (e pgError) Error() string    { return "pg: " + string(e) }
+func (e pgError) SQLState() string { return string(e) }
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+
+	var attempts int
+	if err := retry(ctx, func() error {
+		if attempts++; attempts < 3 {
+			return pgError("40001")
+		}
+		return nil
+	}); err != nil || attempts != 3 {
+		t.Fatalf("retry; got %d attempts and %v, want 3 and <nil>", attempts, err)
+	}
+
+	attempts = 0
+	if err := retry(ctx, func() error {
+		attempts++
+		return pgError("23505")
+	}); !errors.Is(err, pgError("23505")) || attempts != 1 {
+		t.Fatalf("retry; got %d attempts and %v, want 1 and 23505", attempts, err)
+	}
+
+	// Retries stop before the deadline would pass.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	attempts = 0
+	start := time.Now()
+	if err := retry(ctx, func() error {
+		attempts++
+		return pgError("40001")
+	}); !errors.Is(err, pgError("40001")) || attempts >= maxAttempts {
+		t.Fatalf("retry; got %d attempts and %v, want fewer than %d and 40001", attempts, err, maxAttempts)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("retry outlasted its deadline; took %v", d)
+	}
+}
+
+// TestTx checks transactions which fail with serialization failures are
+// rolled back and retried from the start.
+func TestTx(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := Open(ctx, SQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(ctx)
+
+	e := database.Entry{Slug: "tx", Name: "tx.txt", Timestamp: time.Now(), Tags: []string{"a"}}
+	var attempts int
+	if err := db.tx(ctx, func(tx *sql.Tx) error {
+		if err := create(ctx, tx.StmtContext(ctx, db.createStmt), tx.StmtContext(ctx, db.createTagStmt), e); err != nil {
+			return err
+		}
+		// The hook runs after the insert, so the first attempt must
+		// be rolled back for the second not to conflict.
+		if attempts++; attempts == 1 {
+			return pgError("40001")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("tx: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("unexpected attempts; got %d, want 2", attempts)
+	}
+	got, err := db.Lookup(ctx, e.Slug)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if got.Name != e.Name || len(got.Tags) != 1 {
+		t.Fatalf("unexpected entry; got %+v, want %+v", got, e)
+	}
+}
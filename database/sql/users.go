@@ -0,0 +1,166 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+const createUserQuery = "INSERT INTO users (id, created) VALUES ($1, $2)"
+
+// CreateUser stores u, returning database.ErrConflict if there's already a
+// user with its ID.
+func (db *Database) CreateUser(ctx context.Context, u database.User) error {
+	return retry(ctx, func() error {
+		if _, err := db.createUserStmt.ExecContext(ctx, u.ID, u.Created.UTC()); err != nil {
+			if isUniqueViolation(err) {
+				return database.ErrConflict
+			}
+			return fmt.Errorf("exec: %w", err)
+		}
+		return nil
+	})
+}
+
+const userQuery = "SELECT id, created FROM users WHERE id = $1"
+
+// User returns the user with id. It's always read from the writer, so users
+// are found as soon as they're created.
+func (db *Database) User(ctx context.Context, id string) (database.User, error) {
+	var u database.User
+	if err := db.userStmt.QueryRowContext(ctx, id).Scan(&u.ID, &u.Created); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return u, database.ErrNoResults
+		}
+		return u, fmt.Errorf("scan: %w", err)
+	}
+	return u, nil
+}
+
+const usersQuery = "SELECT id, created FROM users ORDER BY created, id"
+
+// Users returns every user, oldest first.
+func (db *Database) Users(ctx context.Context) ([]database.User, error) {
+	rows, err := db.query(ctx, usersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var users []database.User
+	for rows.Next() {
+		var u database.User
+		if err := rows.Scan(&u.ID, &u.Created); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return users, nil
+}
+
+const (
+	deleteUserQuery       = "DELETE FROM users WHERE id = $1"
+	deleteUserTokensQuery = "DELETE FROM user_tokens WHERE user_id = $1"
+)
+
+// DeleteUser deletes the user with id, and its tokens.
+func (db *Database) DeleteUser(ctx context.Context, id string) error {
+	return db.tx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.StmtContext(ctx, db.deleteUserTokensStmt).ExecContext(ctx, id); err != nil {
+			return fmt.Errorf("exec tokens: %w", err)
+		}
+		return exec(ctx, tx.StmtContext(ctx, db.deleteUserStmt), id)
+	})
+}
+
+const (
+	userExistsQuery   = "SELECT COUNT(*) FROM users WHERE id = $1"
+	addUserTokenQuery = "INSERT INTO user_tokens (user_id, id, hash, created) VALUES ($1, $2, $3, $4)"
+)
+
+// AddUserToken stores t, returning database.ErrNoResults if its user doesn't
+// exist, and database.ErrConflict if it has a token with the same ID or hash.
+func (db *Database) AddUserToken(ctx context.Context, t database.UserToken) error {
+	return db.tx(ctx, func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRowContext(ctx, userExistsQuery, t.User).Scan(&n); err != nil {
+			return fmt.Errorf("query row: %w", err)
+		}
+		if n == 0 {
+			return database.ErrNoResults
+		}
+		if _, err := tx.StmtContext(ctx, db.addUserTokenStmt).ExecContext(ctx, t.User, t.ID, t.Hash, t.Created.UTC()); err != nil {
+			if isUniqueViolation(err) {
+				return database.ErrConflict
+			}
+			return fmt.Errorf("exec: %w", err)
+		}
+		return nil
+	})
+}
+
+const userTokensQuery = "SELECT user_id, id, hash, created FROM user_tokens WHERE user_id = $1 ORDER BY created, id"
+
+// UserTokens returns the tokens of the user with id, oldest first. They're
+// always read from the writer, so they're listed as soon as they're added.
+func (db *Database) UserTokens(ctx context.Context, id string) ([]database.UserToken, error) {
+	rows, err := db.db.QueryContext(ctx, userTokensQuery, id)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []database.UserToken
+	for rows.Next() {
+		var t database.UserToken
+		if err := rows.Scan(&t.User, &t.ID, &t.Hash, &t.Created); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	if len(tokens) > 0 {
+		return tokens, nil
+	}
+	// Users without tokens are told apart from those which don't exist.
+	var n int
+	if err := db.db.QueryRowContext(ctx, userExistsQuery, id).Scan(&n); err != nil {
+		return nil, fmt.Errorf("query row: %w", err)
+	}
+	if n == 0 {
+		return nil, database.ErrNoResults
+	}
+	return nil, nil
+}
+
+const removeUserTokenQuery = "DELETE FROM user_tokens WHERE user_id = $1 AND id = $2"
+
+// RemoveUserToken removes the token of the user with id whose ID is token.
+func (db *Database) RemoveUserToken(ctx context.Context, id, token string) error {
+	return execRetry(ctx, db.removeUserTokenStmt, id, token)
+}
+
+const userByTokenQuery = `SELECT users.id, users.created FROM user_tokens
+JOIN users ON users.id = user_tokens.user_id
+WHERE user_tokens.hash = $1`
+
+// UserByToken returns the user with the token whose hash is hash. It's always
+// read from the writer, so tokens stop working as soon as they're removed.
+func (db *Database) UserByToken(ctx context.Context, hash string) (database.User, error) {
+	var u database.User
+	if err := db.userByTokenStmt.QueryRowContext(ctx, hash).Scan(&u.ID, &u.Created); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return u, database.ErrNoResults
+		}
+		return u, fmt.Errorf("scan: %w", err)
+	}
+	return u, nil
+}
@@ -0,0 +1,66 @@
+// Package stats computes statistics about the entries of any database.
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// pageSize is the number of entries listed at a time.
+const pageSize = 1000
+
+// Compute returns statistics about every entry in db, with lifetimes measured
+// from now. Databases implementing database.StatsReporter compute them
+// natively, otherwise every entry is listed.
+func Compute(ctx context.Context, db database.Database, now time.Time) (database.Stats, error) {
+	if r, ok := db.(database.StatsReporter); ok {
+		s, err := r.Stats(ctx, now)
+		if !errors.Is(err, database.ErrUnsupported) {
+			return s, err
+		}
+	}
+	var (
+		s      database.Stats
+		cursor string
+	)
+	for {
+		entries, next, err := db.List(ctx, database.ListOptions{Limit: pageSize, Cursor: cursor})
+		if err != nil {
+			return database.Stats{}, fmt.Errorf("list: %w", err)
+		}
+		for _, e := range entries {
+			Add(&s, e, now)
+		}
+		if next == "" {
+			return s, nil
+		}
+		cursor = next
+	}
+}
+
+// Add adds e to s, with its lifetime measured from now. Entries must be added
+// oldest first, as they're listed.
+func Add(s *database.Stats, e database.Entry, now time.Time) {
+	s.Entries++
+	s.Bytes += e.Size
+	s.GzipBytes += e.GzipSize
+	if l := e.Lifetime; l != nil && l.After(now) {
+		if !l.After(now.Add(24 * time.Hour)) {
+			s.ExpiringDay += e.Size
+		}
+		if !l.After(now.Add(7 * 24 * time.Hour)) {
+			s.ExpiringWeek += e.Size
+		}
+	}
+	day := database.Day(e.Timestamp)
+	if n := len(s.Days); n == 0 || !s.Days[n-1].Day.Equal(day) {
+		s.Days = append(s.Days, database.DayStats{Day: day})
+	}
+	d := &s.Days[len(s.Days)-1]
+	d.Entries++
+	d.Bytes += e.Size
+}
@@ -0,0 +1,63 @@
+package stats_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/instrument"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/database/sql"
+	"github.com/uhthomas/kipp/database/stats"
+	_ "modernc.org/sqlite"
+)
+
+func TestCompute(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	mdb := memory.New()
+	sdb, err := sql.Open(ctx, sql.SQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close(ctx)
+	// The wrapped memory database doesn't implement database.StatsReporter,
+	// so its stats are computed by listing it.
+	idb, err := instrument.New(mdb, prometheus.NewRegistry(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2500; i++ {
+		e := databasetest.NewEntry(fmt.Sprintf("e%d", i))
+		e.Timestamp = now.Add(-time.Duration(i) * time.Minute)
+		l := now.Add(time.Duration(i-100) * time.Hour)
+		e.Size, e.Lifetime = int64(i), &l
+		for _, db := range []database.Database{mdb, sdb} {
+			if err := db.Create(ctx, e); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+		}
+	}
+
+	want, err := stats.Compute(ctx, sdb, now)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if want.Entries != 2500 || len(want.Days) != 3 {
+		t.Fatalf("unexpected stats; got %+v", want)
+	}
+	got, err := stats.Compute(ctx, idb, now)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected stats; got %+v, want %+v", got, want)
+	}
+}
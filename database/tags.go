@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxTags is the maximum number of tags an entry may have.
+	MaxTags = 16
+	// MaxTagLength is the maximum length of a tag, in bytes.
+	MaxTagLength = 32
+)
+
+// ErrInvalidTags is returned by NormalizeTags for invalid tags.
+var ErrInvalidTags = errors.New("invalid tags")
+
+// ValidTag reports whether tag is made of between 1 and MaxTagLength lowercase
+// ASCII letters, digits, '-', '_' or '.', which every database can store and
+// index.
+func ValidTag(tag string) bool {
+	if tag == "" || len(tag) > MaxTagLength {
+		return false
+	}
+	for i := 0; i < len(tag); i++ {
+		switch c := tag[i]; {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeTags validates tags, and returns them sorted without duplicates as
+// entries store them. It returns nil if there are no tags.
+func NormalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	s := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !ValidTag(tag) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTags, tag)
+		}
+		s = append(s, tag)
+	}
+	sort.Strings(s)
+	n := 1
+	for _, tag := range s[1:] {
+		if tag != s[n-1] {
+			s[n] = tag
+			n++
+		}
+	}
+	if n > MaxTags {
+		return nil, fmt.Errorf("%w: more than %d", ErrInvalidTags, MaxTags)
+	}
+	return s[:n], nil
+}
+
+// HasTag reports whether e has the given tag.
+func (e Entry) HasTag(tag string) bool {
+	i := sort.SearchStrings(e.Tags, tag)
+	return i < len(e.Tags) && e.Tags[i] == tag
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// A UserStore stores user accounts, and the tokens they authenticate with,
+// which are only stored as hashes. Users are looked up by the hash of a token
+// for every request made with one, so they must be indexed by it.
+type UserStore interface {
+	// CreateUser stores u, returning ErrConflict if there's already a
+	// user with its ID.
+	CreateUser(ctx context.Context, u User) error
+	// User returns the user with id, or ErrNoResults if there isn't one.
+	User(ctx context.Context, id string) (User, error)
+	// Users returns every user, oldest first.
+	Users(ctx context.Context) ([]User, error)
+	// DeleteUser deletes the user with id and its tokens, returning
+	// ErrNoResults if there isn't one. Its entries keep it as their
+	// owner.
+	DeleteUser(ctx context.Context, id string) error
+	// AddUserToken stores t, returning ErrNoResults if its user doesn't
+	// exist, and ErrConflict if it has a token with the same ID, or any
+	// user has one with the same hash.
+	AddUserToken(ctx context.Context, t UserToken) error
+	// UserTokens returns the tokens of the user with id, oldest first,
+	// or ErrNoResults if there isn't one.
+	UserTokens(ctx context.Context, id string) ([]UserToken, error)
+	// RemoveUserToken removes the token of the user with id whose ID is
+	// token, returning ErrNoResults if there isn't one.
+	RemoveUserToken(ctx context.Context, id, token string) error
+	// UserByToken returns the user with the token whose hash is hash, or
+	// ErrNoResults if there isn't one.
+	UserByToken(ctx context.Context, hash string) (User, error)
+}
+
+// A User is an account, which owns the entries uploaded with its tokens.
+type User struct {
+	ID      string
+	Created time.Time
+}
+
+// A UserToken is a token a user authenticates with.
+type UserToken struct {
+	// ID identifies the token among those of its user, so it can be
+	// revoked without being known.
+	ID   string
+	User string
+	// Hash is the hex encoded SHA-256 hash of the token.
+	Hash    string
+	Created time.Time
+}
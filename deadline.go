@@ -0,0 +1,103 @@
+package kipp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transfer extends the read and write deadlines of the connection of a request
+// as bytes of its body are read or of its response are written, so transfers
+// which stall are cut off however long those which don't take. Deadlines are
+// never extended past limit, if it isn't zero.
+type transfer struct {
+	rc       *http.ResponseController
+	progress time.Duration
+	limit    time.Time
+
+	mu sync.Mutex
+	// extended is when the deadlines were last extended. They're extended
+	// at most every quarter of progress, rather than for every read and
+	// write.
+	extended time.Time
+	// expired is whether a read or write failed as a deadline passed.
+	expired atomic.Bool
+}
+
+// startTransfer sets deadlines for r and its response written to w, returning
+// r with its body extending them as it's read. It returns a nil transfer if
+// neither ProgressTimeout nor TransferTimeout is set.
+func (s Server) startTransfer(w *statusWriter, r *http.Request) (*http.Request, *transfer) {
+	if s.ProgressTimeout <= 0 && s.TransferTimeout <= 0 {
+		return r, nil
+	}
+	now := time.Now()
+	t := &transfer{rc: http.NewResponseController(w.ResponseWriter), progress: s.ProgressTimeout}
+	if s.TransferTimeout > 0 {
+		t.limit = now.Add(s.TransferTimeout)
+	}
+	t.extend(now)
+	w.transfer = t
+	r = r.Clone(r.Context())
+	r.Body = &transferBody{ReadCloser: r.Body, t: t}
+	return r, t
+}
+
+// finishTransfer ends t, which must be deferred by ServeHTTP. The connection is
+// closed if a deadline passed, as whatever was being sent can't be finished,
+// and otherwise its deadlines are cleared so the next request on it isn't
+// limited by them.
+func (s Server) finishTransfer(r *http.Request, t *transfer) {
+	if t.expired.Load() {
+		s.logger().WarnContext(r.Context(), "transfer timed out", "method", r.Method, "path", r.URL.Path)
+		panic(http.ErrAbortHandler)
+	}
+	// Writers which don't support deadlines never had them set.
+	_ = t.rc.SetReadDeadline(time.Time{})
+	_ = t.rc.SetWriteDeadline(time.Time{})
+}
+
+// extend extends the deadlines to progress from now, or sets them to limit if
+// progress is zero, unless they were recently extended.
+func (t *transfer) extend(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.extended.IsZero() && (t.progress <= 0 || now.Sub(t.extended) < t.progress/4) {
+		return
+	}
+	t.extended = now
+	d := t.limit
+	if t.progress > 0 && (d.IsZero() || now.Add(t.progress).Before(d)) {
+		d = now.Add(t.progress)
+	}
+	_ = t.rc.SetReadDeadline(d)
+	_ = t.rc.SetWriteDeadline(d)
+}
+
+// moved records that n bytes were read or written, or that they failed with
+// err.
+func (t *transfer) moved(n int, err error) {
+	if n > 0 {
+		t.extend(time.Now())
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.expired.Store(true)
+	}
+}
+
+// transferBody is a request body which extends the deadlines of t as it's
+// read.
+type transferBody struct {
+	io.ReadCloser
+	t *transfer
+}
+
+func (b *transferBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.t.moved(n, err)
+	return n, err
+}
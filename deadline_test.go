@@ -0,0 +1,170 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// partialFS is a file system which keeps what it's read of files it failed to
+// create, as some backends would, even once the request's been cancelled.
+type partialFS struct{ *memfs.FileSystem }
+
+func (fs partialFS) Create(ctx context.Context, name string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err := fs.FileSystem.Create(context.WithoutCancel(ctx), name, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	return err
+}
+
+// trickle is a request body which reads n bytes from r every interval.
+type trickle struct {
+	r        io.Reader
+	n        int
+	interval time.Duration
+}
+
+func (t trickle) Read(p []byte) (int, error) {
+	time.Sleep(t.interval)
+	if len(p) > t.n {
+		p = p[:t.n]
+	}
+	return t.r.Read(p)
+}
+
+// multipartBody returns a multipart body with a file of n bytes, and its
+// content type.
+func multipartBody(t *testing.T, n int) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, strings.Repeat("a", n))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+// closed checks the server closes c, rather than leaving it open, reading
+// what it sends until then and returning how much was read.
+func closed(t *testing.T, c net.Conn) int64 {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := io.Copy(io.Discard, c)
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		t.Fatalf("connection wasn't closed after reading %d bytes", n)
+	}
+	return n
+}
+
+func TestTimeouts(t *testing.T) {
+	newServer := func(t *testing.T, fs filesystem.FileSystem, progress, transfer time.Duration) *httptest.Server {
+		t.Helper()
+		s, err := New(context.Background(), DB(memory.New()), FS(fs), Limit(64<<20), Timeouts(time.Second, progress, transfer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hs := httptest.NewServer(s)
+		t.Cleanup(hs.Close)
+		hs.Client().CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		return hs
+	}
+
+	// A client which stops sending an upload is cut off, and what was
+	// stored of it is removed.
+	t.Run("stalled reader", func(t *testing.T) {
+		fs := memfs.New()
+		hs := newServer(t, partialFS{fs}, 100*time.Millisecond, 0)
+		c, err := net.Dial("tcp", hs.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		b, ctype := multipartBody(t, 64<<10)
+		fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: kipp\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", ctype, len(b))
+		c.Write(b[:len(b)/2])
+		closed(t, c)
+		var names []string
+		fs.Walk(context.Background(), func(fi filesystem.FileInfo) error {
+			names = append(names, fi.Name)
+			return nil
+		})
+		if len(names) > 0 {
+			t.Fatalf("partial upload wasn't removed: %v", names)
+		}
+	})
+
+	// A client which stops reading a download is cut off, rather than left
+	// half-open.
+	t.Run("stalled writer", func(t *testing.T) {
+		const size = 32 << 20
+		hs := newServer(t, memfs.New(), 100*time.Millisecond, 0)
+		b, ctype := multipartBody(t, size)
+		res, err := hs.Client().Post(hs.URL, ctype, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		loc, err := res.Location()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := net.Dial("tcp", hs.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: kipp\r\n\r\n", loc.Path)
+		// Nothing is read until the deadline has passed.
+		time.Sleep(time.Second)
+		if n := closed(t, c); n >= size {
+			t.Fatalf("download wasn't cut off; read %d bytes", n)
+		}
+	})
+
+	// Transfers which keep moving outlive the progress timeout.
+	t.Run("slow upload", func(t *testing.T) {
+		hs := newServer(t, memfs.New(), 100*time.Millisecond, 0)
+		b, ctype := multipartBody(t, 1<<10)
+		res, err := hs.Client().Post(hs.URL, ctype, trickle{bytes.NewReader(b), 128, 25 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d", res.StatusCode, http.StatusSeeOther)
+		}
+	})
+
+	// They don't outlive the transfer timeout.
+	t.Run("transfer timeout", func(t *testing.T) {
+		hs := newServer(t, memfs.New(), 100*time.Millisecond, 200*time.Millisecond)
+		b, ctype := multipartBody(t, 1<<10)
+		res, err := hs.Client().Post(hs.URL, ctype, trickle{bytes.NewReader(b), 128, 25 * time.Millisecond})
+		if err == nil {
+			res.Body.Close()
+			t.Fatalf("upload wasn't cut off; got %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// Delete deletes the named entry and its files, returning
+// database.ErrNoResults if it doesn't exist.
+//
+// The entry is deleted before its files so it can never be served without
+// them. If removing the files fails they are left as orphans, which are
+// harmless as nothing refers to them any more. Files which are already gone
+// aren't an error.
+func (s Server) Delete(ctx context.Context, slug string) error {
+	e, err := s.Database.Lookup(ctx, slug)
+	if err != nil {
+		return fmt.Errorf("lookup: %w", err)
+	}
+	if err := s.Database.Delete(ctx, slug); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	s.notify(EventDelete, e)
+	if s.usage != nil {
+		s.usage.Remove(e.Size + e.GzipSize)
+	}
+	if db, ok := s.Database.(database.DownloadCounter); ok {
+		if err := db.RemoveDownloads(ctx, slug); err != nil {
+			s.logger().Warn("remove downloads", "slug", slug, "error", err)
+		}
+	}
+	if e.GzipSize > 0 {
+		if err := s.FileSystem.Remove(ctx, gzipName(slug)); err != nil && !filesystem.IsNotExist(err) {
+			s.logger().Warn("remove gzip variant", "slug", slug, "error", err)
+		}
+	}
+	if err := s.FileSystem.Remove(ctx, slug); err != nil && !filesystem.IsNotExist(err) {
+		return fmt.Errorf("remove: %w", err)
+	}
+	return nil
+}
+
+// SoftDelete marks the named entry as deleted for the given reason, so it's
+// no longer served but can be restored until it's purged.
+func (s Server) SoftDelete(ctx context.Context, slug, reason string) error {
+	db, ok := s.Database.(database.SoftDeleter)
+	if !ok {
+		return errors.New("database does not support soft deletes")
+	}
+	return db.SoftDelete(ctx, slug, time.Now(), reason)
+}
+
+// Restore restores the named soft deleted entry.
+func (s Server) Restore(ctx context.Context, slug string) error {
+	db, ok := s.Database.(database.SoftDeleter)
+	if !ok {
+		return errors.New("database does not support soft deletes")
+	}
+	return db.Restore(ctx, slug)
+}
+
+// Purge deletes entries which were soft deleted more than s.PurgeAfter ago,
+// along with their files, returning how many were deleted. Nothing is purged
+// if s.PurgeAfter is zero.
+func (s Server) Purge(ctx context.Context) (n int, err error) {
+	if s.PurgeAfter <= 0 {
+		return 0, nil
+	}
+	before := time.Now().Add(-s.PurgeAfter)
+	opts := database.ListOptions{Deleted: true}
+	for {
+		entries, next, err := s.Database.List(ctx, opts)
+		if err != nil {
+			return n, fmt.Errorf("list: %w", err)
+		}
+		for _, e := range entries {
+			if !e.Deleted.Before(before) {
+				continue
+			}
+			if err := s.Delete(ctx, e.Slug); err != nil && !errors.Is(err, database.ErrNoResults) {
+				return n, fmt.Errorf("delete %s: %w", e.Slug, err)
+			}
+			n++
+		}
+		if next == "" {
+			return n, nil
+		}
+		opts.Cursor = next
+	}
+}
@@ -0,0 +1,122 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestServerDelete(t *testing.T) {
+	ctx := context.Background()
+
+	s := Server{Database: memory.New(), FileSystem: memfs.New()}
+
+	e := databasetest.NewEntry("delete")
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for _, name := range []string{e.Slug, gzipName(e.Slug)} {
+		if err := s.FileSystem.Create(ctx, name, strings.NewReader("some data")); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+
+	if err := s.Delete(ctx, e.Slug); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := s.Database.Lookup(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	for _, name := range []string{e.Slug, gzipName(e.Slug)} {
+		if _, err := s.FileSystem.Open(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("unexpected error for %s; got %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+
+	if err := s.Delete(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+// TestServerDeleteMissing checks entries are deleted even if their files are
+// already gone.
+func TestServerDeleteMissing(t *testing.T) {
+	ctx := context.Background()
+
+	s := Server{Database: memory.New(), FileSystem: memfs.New()}
+
+	e := databasetest.NewEntry("missing")
+	e.GzipSize = 1
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := s.Delete(ctx, e.Slug); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := s.Database.Lookup(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+}
+
+func TestServerSoftDelete(t *testing.T) {
+	ctx := context.Background()
+
+	s := Server{Database: memory.New(), FileSystem: memfs.New(), PurgeAfter: time.Hour}
+
+	e := databasetest.NewEntry("deleted")
+	e.GzipSize = 0
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := s.FileSystem.Create(ctx, e.Slug, strings.NewReader("some data")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	get := func(want int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+e.Slug, nil))
+		if w.Code != want {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, want)
+		}
+	}
+	get(http.StatusOK)
+	if err := s.SoftDelete(ctx, e.Slug, "abuse"); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	get(http.StatusNotFound)
+	if err := s.Restore(ctx, e.Slug); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	get(http.StatusOK)
+
+	// Entries are only purged once the purge window has passed.
+	if err := s.SoftDelete(ctx, e.Slug, "abuse"); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if n, err := s.Purge(ctx); err != nil || n != 0 {
+		t.Fatalf("purge: got %d, %v, want 0", n, err)
+	}
+	if err := s.Database.(database.SoftDeleter).SoftDelete(ctx, e.Slug, time.Now().Add(-2*time.Hour), "abuse"); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if n, err := s.Purge(ctx); err != nil || n != 1 {
+		t.Fatalf("purge: got %d, %v, want 1", n, err)
+	}
+	if _, err := s.Database.Lookup(ctx, e.Slug); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if _, err := s.FileSystem.Open(ctx, e.Slug); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, fs.ErrNotExist)
+	}
+}
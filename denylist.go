@@ -0,0 +1,198 @@
+package kipp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+const (
+	// adminDenylist is the path of the denylist, under which each denial
+	// is served by sum.
+	adminDenylist = adminPrefix + "denylist"
+	// sumSize is the size of the blake3 sums of files.
+	sumSize = 32
+)
+
+// errDenylisted is wrapped by the errors of uploads of denylisted files.
+var errDenylisted = newError(http.StatusUnavailableForLegalReasons, CodeDenylisted, "this file may not be uploaded")
+
+// checkDenylist returns an error wrapping errDenylisted if files with sum are
+// denylisted. Databases without denylists deny nothing.
+func (s Server) checkDenylist(ctx context.Context, sum []byte) error {
+	dl, ok := s.Database.(database.Denylister)
+	if !ok {
+		return nil
+	}
+	d, err := dl.Denied(ctx, base64.RawURLEncoding.EncodeToString(sum))
+	switch {
+	case errors.Is(err, database.ErrNoResults), errors.Is(err, database.ErrUnsupported):
+		return nil
+	case err != nil:
+		return fmt.Errorf("check denylist: %w", err)
+	}
+	return fmt.Errorf("%w: %s is denylisted: %s", errDenylisted, d.Sum, d.Reason)
+}
+
+// adminDenial is a denial as the admin API serves it.
+type adminDenial struct {
+	Sum    string    `json:"sum"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// adminDenials is every denial, oldest first.
+type adminDenials struct {
+	Denials []adminDenial `json:"denials"`
+}
+
+// adminDenied is a denial which was just added, with the slugs of the entries
+// with its sum which were deleted.
+type adminDenied struct {
+	adminDenial
+	Deleted []string `json:"deleted"`
+}
+
+// serveAdminDenylist serves the denylist, and each denial under it by sum.
+func (s Server) serveAdminDenylist(w http.ResponseWriter, r *http.Request) {
+	dl, ok := s.Database.(database.Denylister)
+	if !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support denylists"))
+		return
+	}
+	if r.URL.Path == adminDenylist {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.adminDenials(w, r, dl)
+		case http.MethodPost:
+			s.adminDeny(w, r, dl)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		}
+		return
+	}
+	sum, ok := strings.CutPrefix(r.URL.Path, adminDenylist+"/")
+	if !ok || sum == "" || strings.Contains(sum, "/") {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		d, err := dl.Denied(r.Context(), sum)
+		if err != nil {
+			s.adminDatabaseError(w, r, "denied", err)
+			return
+		}
+		s.adminJSON(w, r, http.StatusOK, adminDenial(d))
+	case http.MethodDelete:
+		d, err := dl.Denied(r.Context(), sum)
+		if err != nil {
+			s.adminDatabaseError(w, r, "denied", err)
+			return
+		}
+		if err := s.audit(r, "allow", sum, adminDenial(d), nil); err != nil {
+			s.adminDatabaseError(w, r, "audit", err)
+			return
+		}
+		if err := dl.Allow(r.Context(), sum); err != nil {
+			s.adminDatabaseError(w, r, "allow", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+	}
+}
+
+// adminDenials serves every denial.
+func (s Server) adminDenials(w http.ResponseWriter, r *http.Request, dl database.Denylister) {
+	denials, err := dl.Denials(r.Context())
+	if err != nil {
+		s.adminDatabaseError(w, r, "denials", err)
+		return
+	}
+	res := adminDenials{Denials: make([]adminDenial, len(denials))}
+	for i, d := range denials {
+		res.Denials[i] = adminDenial(d)
+	}
+	s.adminJSON(w, r, http.StatusOK, res)
+}
+
+// adminDeny denylists the sum of the body for its reason, and deletes the
+// entries which already have it.
+func (s Server) adminDeny(w http.ResponseWriter, r *http.Request, dl database.Denylister) {
+	var req struct {
+		Sum    string `json:"sum"`
+		Reason string `json:"reason"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+		return
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(req.Sum); err != nil || len(b) != sumSize {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid sum"))
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "reason is required"))
+		return
+	}
+	d := database.Denial{Sum: req.Sum, Reason: req.Reason, Time: time.Now().UTC()}
+	if err := s.audit(r, "deny", d.Sum, nil, adminDenial(d)); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	if err := dl.Deny(r.Context(), d); err != nil {
+		s.adminDatabaseError(w, r, "deny", err)
+		return
+	}
+	// The sum is denied first, so nothing with it can be uploaded while
+	// what already has it is deleted.
+	deleted, err := s.deleteBySum(r, d.Sum)
+	if err != nil {
+		s.adminDatabaseError(w, r, "delete by sum", err)
+		return
+	}
+	s.adminJSON(w, r, http.StatusOK, adminDenied{adminDenial: adminDenial(d), Deleted: deleted})
+}
+
+// deleteBySum deletes every entry with sum, and its files, returning the slugs
+// of those it deleted. Each is audited as it's deleted. Lookups by sum may lag behind deletes, such as when
+// they're read from a replica, so it stops if it finds an entry it already
+// deleted rather than deleting it forever.
+func (s Server) deleteBySum(r *http.Request, sum string) ([]string, error) {
+	ctx := r.Context()
+	deleted := []string{}
+	for {
+		e, err := s.Database.LookupBySum(ctx, sum)
+		if errors.Is(err, database.ErrNoResults) {
+			return deleted, nil
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("lookup by sum: %w", err)
+		}
+		if slices.Contains(deleted, e.Slug) {
+			return deleted, nil
+		}
+		if err := s.audit(r, "delete", e.Slug, newAdminEntry(e), nil); err != nil {
+			return deleted, err
+		}
+		if err := s.Delete(ctx, e.Slug); err != nil && !errors.Is(err, database.ErrNoResults) {
+			return deleted, fmt.Errorf("delete %s: %w", e.Slug, err)
+		}
+		deleted = append(deleted, e.Slug)
+	}
+}
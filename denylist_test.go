@@ -0,0 +1,129 @@
+package kipp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/zeebo/blake3"
+)
+
+func TestDenylist(t *testing.T) {
+	ctx := context.Background()
+	fs, staging := memfs.New(), memfs.New()
+	s, err := New(ctx, DB(memory.New()), FS(fs), Staging(staging, 0), Limit(1<<20), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(t *testing.T, method, target, body string, code int, v any) {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, code, w.Body)
+		}
+		if v != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatalf("unmarshal %q: %v", w.Body, err)
+			}
+		}
+	}
+	b := blake3.Sum256([]byte(strings.Repeat("a", 10)))
+	sum := base64.RawURLEncoding.EncodeToString(b[:])
+
+	// Files uploaded before their sum is denylisted are deleted when it is.
+	var slugs []string
+	for range 2 {
+		w := upload(t, s, 10)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+		}
+		slugs = append(slugs, strings.TrimSuffix(strings.TrimPrefix(w.Header().Get("Location"), "/"), ".txt"))
+	}
+	var denied adminDenied
+	do(t, http.MethodPost, "/admin/denylist", `{"sum":"`+sum+`","reason":"malware"}`, http.StatusOK, &denied)
+	if denied.Sum != sum || denied.Reason != "malware" || len(denied.Deleted) != 2 {
+		t.Fatalf("unexpected denial: %+v", denied)
+	}
+	for _, slug := range slugs {
+		if _, err := s.Database.Lookup(ctx, slug); !errors.Is(err, database.ErrNoResults) {
+			t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+		}
+		if _, err := fs.Stat(ctx, slug); err == nil {
+			t.Fatalf("file of %s wasn't removed", slug)
+		}
+	}
+
+	// And uploads of them are rejected, and nothing of them is kept,
+	// whether or not they're staged.
+	unstaged := *s
+	unstaged.Staging = nil
+	for _, s := range []*Server{s, &unstaged} {
+		r := uploadRequest(t, 10)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.UploadHandler(w, r)
+		var res errorBody
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusUnavailableForLegalReasons || res.Error.Code != CodeDenylisted {
+			t.Fatalf("unexpected response; got %d %+v", w.Code, res.Error)
+		}
+		if strings.Contains(w.Body.String(), "malware") {
+			t.Fatalf("reason was responded with: %s", w.Body)
+		}
+		if fs.Size() != 0 || staging.Size() != 0 {
+			t.Fatalf("denylisted upload was kept; %d bytes stored, %d staged", fs.Size(), staging.Size())
+		}
+	}
+	if w := upload(t, s, 11); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	var list adminDenials
+	do(t, http.MethodGet, "/admin/denylist", "", http.StatusOK, &list)
+	if len(list.Denials) != 1 || list.Denials[0].Sum != sum {
+		t.Fatalf("unexpected denials: %+v", list)
+	}
+	do(t, http.MethodGet, "/admin/denylist/"+sum, "", http.StatusOK, &adminDenial{})
+	for _, body := range []string{``, `{"sum":"x","reason":"malware"}`, `{"sum":"` + sum + `"}`, `{"sum":"` + sum + `","reason":"malware","slug":"a"}`} {
+		do(t, http.MethodPost, "/admin/denylist", body, http.StatusBadRequest, &errorBody{})
+	}
+
+	// Once it's allowed again, it can be uploaded.
+	do(t, http.MethodDelete, "/admin/denylist/"+sum, "", http.StatusNoContent, nil)
+	do(t, http.MethodDelete, "/admin/denylist/"+sum, "", http.StatusNotFound, &errorBody{})
+	if w := upload(t, s, 10); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+// TestDenylistUnsupported checks databases without denylists deny nothing,
+// and the admin API says they're unsupported.
+func TestDenylistUnsupported(t *testing.T) {
+	s, err := New(context.Background(), DB(struct{ database.Database }{memory.New()}), FS(memfs.New()), Limit(1<<10), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := upload(t, s, 10); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/admin/denylist", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusNotImplemented)
+	}
+}
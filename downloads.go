@@ -0,0 +1,128 @@
+package kipp
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// A downloadCounter tallies downloads in memory, and periodically flushes
+// them to the database so serving never blocks on it.
+type downloadCounter struct {
+	db  database.DownloadCounter
+	c   chan string
+	log *slog.Logger
+}
+
+func newDownloadCounter(db database.DownloadCounter, l *slog.Logger) *downloadCounter {
+	return &downloadCounter{db: db, c: make(chan string, 1024), log: l}
+}
+
+// Add counts a download of the named entry. The download is dropped if the
+// buffer is full.
+func (d *downloadCounter) Add(slug string) {
+	select {
+	case d.c <- slug:
+	default:
+	}
+}
+
+type downloadKey struct {
+	slug string
+	day  time.Time
+}
+
+// Run tallies downloads, flushing them every interval until ctx is done.
+func (d *downloadCounter) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	m := make(map[downloadKey]int64)
+	for {
+		select {
+		case slug := <-d.c:
+			m[downloadKey{slug: slug, day: database.Day(time.Now())}]++
+		case <-t.C:
+			d.flush(ctx, m)
+		case <-ctx.Done():
+			for len(d.c) > 0 {
+				m[downloadKey{slug: <-d.c, day: database.Day(time.Now())}]++
+			}
+			ctx, cancel := context.WithTimeout(xcontext.Detach(ctx), 10*time.Second)
+			defer cancel()
+			d.flush(ctx, m)
+			return
+		}
+	}
+}
+
+// flush writes the tallies in m to the database, removing them from m. Failed
+// writes are kept, and retried on the next flush.
+func (d *downloadCounter) flush(ctx context.Context, m map[downloadKey]int64) {
+	for k, n := range m {
+		if err := d.db.AddDownloads(ctx, k.slug, k.day, n); err != nil {
+			d.log.Warn("add downloads", "slug", k.slug, "error", err)
+			continue
+		}
+		delete(m, k)
+	}
+}
+
+const (
+	// defaultAdminDownloadDays is how many days of download counts are
+	// served if the query doesn't say.
+	defaultAdminDownloadDays = 30
+	// maxAdminDownloadDays is the most days of download counts served.
+	maxAdminDownloadDays = 366
+)
+
+// adminDownloads is the download counts of an entry as the admin API serves
+// them.
+type adminDownloads struct {
+	Days  []adminDownloadDay `json:"days"`
+	Total int64              `json:"total"`
+}
+
+type adminDownloadDay struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// adminDownloads serves the per-day download counts of the named entry for
+// the days of the query, including today.
+func (s Server) adminDownloads(w http.ResponseWriter, r *http.Request, slug string) {
+	days := defaultAdminDownloadDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAdminDownloadDays {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "days must be between 1 and "+strconv.Itoa(maxAdminDownloadDays)))
+			return
+		}
+		days = n
+	}
+	db, ok := s.Database.(database.DownloadCounter)
+	if !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support download counts"))
+		return
+	}
+	if _, err := s.Database.Lookup(r.Context(), slug); err != nil {
+		s.adminDatabaseError(w, r, "lookup", err)
+		return
+	}
+	downloads, err := db.Downloads(r.Context(), slug, database.Day(time.Now()).AddDate(0, 0, 1-days))
+	if err != nil {
+		s.adminDatabaseError(w, r, "downloads", err)
+		return
+	}
+	res := adminDownloads{Days: make([]adminDownloadDay, len(downloads))}
+	for i, d := range downloads {
+		res.Days[i] = adminDownloadDay{Day: d.Day.Format(time.DateOnly), Count: d.Count}
+		res.Total += d.Count
+	}
+	s.adminJSON(w, r, http.StatusOK, res)
+}
@@ -0,0 +1,57 @@
+package kipp
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+type fakeDownloadCounter struct {
+	mu sync.Mutex
+	m  map[string]int64
+}
+
+func (d *fakeDownloadCounter) AddDownloads(_ context.Context, slug string, _ time.Time, n int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.m[slug] += n
+	return nil
+}
+
+func (d *fakeDownloadCounter) Downloads(context.Context, string, time.Time) ([]database.Downloads, error) {
+	return nil, nil
+}
+
+func (d *fakeDownloadCounter) RemoveDownloads(context.Context, string) error { return nil }
+
+func TestDownloadCounter(t *testing.T) {
+	db := &fakeDownloadCounter{m: make(map[string]int64)}
+	d := newDownloadCounter(db, slog.Default())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx, time.Hour)
+	}()
+
+	for i := 0; i < 3; i++ {
+		d.Add("a")
+	}
+	d.Add("b")
+
+	// Cancelling flushes any remaining downloads.
+	cancel()
+	<-done
+
+	if got, want := db.m["a"], int64(3); got != want {
+		t.Fatalf("unexpected downloads for a; got %d, want %d", got, want)
+	}
+	if got, want := db.m["b"], int64(1); got != want {
+		t.Fatalf("unexpected downloads for b; got %d, want %d", got, want)
+	}
+}
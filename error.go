@@ -0,0 +1,226 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/uhthomas/kipp/database"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// An ErrorCode names why a request failed. Codes never change, unlike
+// messages, so API clients can rely on them.
+type ErrorCode string
+
+// The codes requests fail with.
+const (
+	CodeBadRequest          ErrorCode = "bad_request"
+	CodeInvalidName         ErrorCode = "invalid_name"
+	CodeInvalidTags         ErrorCode = "invalid_tags"
+	CodeInvalidURL          ErrorCode = "invalid_url"
+	CodeInvalidReport       ErrorCode = "invalid_report"
+	CodeUnauthorized        ErrorCode = "unauthorized"
+	CodeInvalidAPIKey       ErrorCode = "invalid_api_key"
+	CodeForbidden           ErrorCode = "forbidden"
+	CodeUploadDenied        ErrorCode = "upload_denied"
+	CodeDenylisted          ErrorCode = "denylisted"
+	CodeMalware             ErrorCode = "malware_detected"
+	CodeVerificationFailed  ErrorCode = "verification_failed"
+	CodeNotFound            ErrorCode = "not_found"
+	CodeGone                ErrorCode = "gone"
+	CodeBlocked             ErrorCode = "blocked"
+	CodeMethodNotAllowed    ErrorCode = "method_not_allowed"
+	CodeConflict            ErrorCode = "conflict"
+	CodeMisdirected         ErrorCode = "misdirected_request"
+	CodeEntityTooLarge      ErrorCode = "entity_too_large"
+	CodeUnsupportedFormat   ErrorCode = "unsupported_format"
+	CodeInsufficientStorage ErrorCode = "insufficient_storage"
+	CodeTooManyUploads      ErrorCode = "too_many_uploads"
+	CodeTooManyReports      ErrorCode = "too_many_reports"
+	CodeOverloaded          ErrorCode = "overloaded"
+	CodeScanUnavailable     ErrorCode = "scan_unavailable"
+	CodeReadOnly            ErrorCode = "read_only"
+	CodeShuttingDown        ErrorCode = "shutting_down"
+	CodeNotImplemented      ErrorCode = "not_implemented"
+	CodeInternal            ErrorCode = "internal"
+)
+
+// An Error is why a request failed, as it's responded with. Its message is
+// seen by clients, so it mustn't say anything they shouldn't know, but what
+// caused it is only logged.
+type Error struct {
+	Status  int
+	Code    ErrorCode
+	Message string
+	// Err is what caused the error, if anything.
+	Err error
+}
+
+// newError returns an Error with status, code and msg.
+func newError(status int, code ErrorCode, msg string) *Error {
+	return &Error{Status: status, Code: code, Message: msg}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// errNotFound is responded to requests for what doesn't exist.
+var errNotFound = newError(http.StatusNotFound, CodeNotFound, http.StatusText(http.StatusNotFound))
+
+// internalError is what requests which fail unexpectedly are responded with,
+// rather than why they did.
+func internalError(err error) *Error {
+	return &Error{
+		Status:  http.StatusInternalServerError,
+		Code:    CodeInternal,
+		Message: http.StatusText(http.StatusInternalServerError),
+		Err:     err,
+	}
+}
+
+// badUpload returns the error of an upload whose body couldn't be read as
+// err, which is too large if it exceeded the limit.
+func badUpload(code ErrorCode, err error) *Error {
+	if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+		return &Error{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    CodeEntityTooLarge,
+			Message: http.StatusText(http.StatusRequestEntityTooLarge),
+			Err:     err,
+		}
+	}
+	return &Error{Status: http.StatusBadRequest, Code: code, Message: err.Error(), Err: err}
+}
+
+// An ErrorHandler decides how requests which failed with err are responded
+// to, returning the status and the message seen by clients. It's called for
+// every request which fails, and err is logged regardless, so it needn't log
+// it, but it may report it elsewhere, such as to an error tracker, before
+// deferring to DefaultErrorHandler.
+type ErrorHandler func(ctx context.Context, r *http.Request, err error) (status int, msg string)
+
+// DefaultErrorHandler responds with the status and message of the *Error err
+// wraps, and with 404 Not Found for entries which don't exist. Other errors
+// are unexpected, so they're responded to with 500 Internal Server Error and
+// a message which says nothing of them.
+func DefaultErrorHandler(ctx context.Context, r *http.Request, err error) (status int, msg string) {
+	if e := (*Error)(nil); errors.As(err, &e) {
+		return e.Status, e.Message
+	}
+	if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge, http.StatusText(http.StatusRequestEntityTooLarge)
+	}
+	if errors.Is(err, database.ErrNoResults) {
+		return errNotFound.Status, errNotFound.Message
+	}
+	return http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)
+}
+
+// statusCodes are the codes of errors responded with statuses other than
+// those of the *Error they're of.
+var statusCodes = map[int]ErrorCode{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusMisdirectedRequest:    CodeMisdirected,
+	http.StatusGone:                  CodeGone,
+	http.StatusRequestEntityTooLarge: CodeEntityTooLarge,
+	http.StatusNotImplemented:        CodeNotImplemented,
+	http.StatusInsufficientStorage:   CodeInsufficientStorage,
+}
+
+// errorCode returns the code of err responded with status.
+func errorCode(err error, status int) ErrorCode {
+	if e := (*Error)(nil); errors.As(err, &e) && e.Status == status {
+		return e.Code
+	}
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status < http.StatusInternalServerError {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
+
+// httpError logs err, then responds to r with the status and message the
+// ErrorHandler of s decides on, as writeError does.
+func (s Server) httpError(w http.ResponseWriter, r *http.Request, err error) {
+	h := s.ErrorHandler
+	if h == nil {
+		h = DefaultErrorHandler
+	}
+	status, msg := h(r.Context(), r, err)
+	code := errorCode(err, status)
+	level := slog.LevelDebug
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	s.logger().LogAttrs(r.Context(), level, "request failed",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.String("code", string(code)),
+		slog.String("error", err.Error()),
+	)
+	if s.tracer != nil && status >= http.StatusInternalServerError {
+		trace.SpanFromContext(r.Context()).SetStatus(codes.Error, err.Error())
+	}
+	s.writeError(w, r, &Error{Status: status, Code: code, Message: msg})
+}
+
+// errorBody is the body of errors responded to clients which accept JSON.
+type errorBody struct {
+	Error struct {
+		Code      ErrorCode `json:"code"`
+		Message   string    `json:"message"`
+		RequestID string    `json:"request_id,omitempty"`
+	} `json:"error"`
+}
+
+// writeError responds to r with e. Clients which accept JSON, and those of
+// the admin API, are responded to with it as an errorBody, and others with its
+// message and the ID of r, as http.Error does.
+func (s Server) writeError(w http.ResponseWriter, r *http.Request, e *Error) {
+	if !acceptsJSON(r) && !s.isAdmin(r.URL.Path) {
+		http.Error(w, errorMessage(r, e.Message), e.Status)
+		return
+	}
+	var b errorBody
+	b.Error.Code, b.Error.Message = e.Code, e.Message
+	b.Error.RequestID, _ = RequestID(r.Context())
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(b)
+}
+
+// acceptsJSON reports whether the client of r named JSON as a type it
+// accepts. Wildcards don't count, as browsers send them.
+func acceptsJSON(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(v, ",") {
+			if mt, _, err := mime.ParseMediaType(t); err == nil && mt == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// failFS is a file system whose creates fail with err.
+type failFS struct {
+	*memfs.FileSystem
+	err error
+}
+
+func (fs failFS) Create(ctx context.Context, name string, r io.Reader) error {
+	io.Copy(io.Discard, r)
+	return fs.err
+}
+
+// multipartRequest returns an upload of a file of n bytes, preceded by
+// fields.
+func multipartRequest(t *testing.T, n int, fields ...string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i < len(fields); i += 2 {
+		mw.WriteField(fields[i], fields[i+1])
+	}
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, strings.Repeat("a", n))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestErrorCodes(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := New(context.Background(),
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<10),
+		APIKeys(APIKey{Name: "ci", Key: "ci-key"}),
+		Logger(slog.New(slog.NewJSONHandler(&buf, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := *s
+	failing.FileSystem = failFS{memfs.New(), errors.New("open /var/lib/kipp/secret: permission denied")}
+
+	for _, tt := range []struct {
+		name   string
+		s      Server
+		r      func() *http.Request
+		status int
+		code   ErrorCode
+	}{
+		{"method not allowed", *s, func() *http.Request {
+			return httptest.NewRequest(http.MethodPut, "/", nil)
+		}, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"not multipart", *s, func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/", strings.NewReader("a"))
+		}, http.StatusBadRequest, CodeBadRequest},
+		{"too large", *s, func() *http.Request {
+			return multipartRequest(t, 2<<10)
+		}, http.StatusRequestEntityTooLarge, CodeEntityTooLarge},
+		{"too large without a length", *s, func() *http.Request {
+			r := multipartRequest(t, 2<<10)
+			r.ContentLength = -1
+			return r
+		}, http.StatusRequestEntityTooLarge, CodeEntityTooLarge},
+		{"invalid tags", *s, func() *http.Request {
+			return multipartRequest(t, 1, "tags", strings.Repeat("a", 100))
+		}, http.StatusBadRequest, CodeInvalidTags},
+		{"invalid API key", *s, func() *http.Request {
+			r := multipartRequest(t, 1)
+			r.Header.Set("Authorization", "Bearer guess")
+			return r
+		}, http.StatusUnauthorized, CodeInvalidAPIKey},
+		{"not found", *s, func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/missing", nil)
+		}, http.StatusNotFound, CodeNotFound},
+		{"internal", failing, func() *http.Request {
+			return multipartRequest(t, 1)
+		}, http.StatusInternalServerError, CodeInternal},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			r := tt.r()
+			r.Header.Set("Accept", "application/json, text/plain;q=0.5")
+			r.Header.Set(requestIDHeader, "some-id")
+			w := httptest.NewRecorder()
+			tt.s.ServeHTTP(w, r)
+			if w.Code != tt.status || w.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("unexpected response; got %d %q, want %d", w.Code, w.Header().Get("Content-Type"), tt.status)
+			}
+			var b errorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+				t.Fatal(err)
+			}
+			if b.Error.Code != tt.code || b.Error.Message == "" || b.Error.RequestID != "some-id" {
+				t.Fatalf("unexpected error; got %+v, want code %q", b.Error, tt.code)
+			}
+			// Internal details are logged, but never responded with.
+			if strings.Contains(w.Body.String(), "/var/lib") {
+				t.Fatalf("internal details were responded with: %s", w.Body)
+			}
+			if tt.code == CodeInternal && !strings.Contains(buf.String(), "/var/lib/kipp/secret") {
+				t.Fatalf("internal details weren't logged: %s", &buf)
+			}
+		})
+	}
+
+	// Other clients are responded to with plain messages.
+	r := multipartRequest(t, 1)
+	r.Header.Set(requestIDHeader, "some-id")
+	w := httptest.NewRecorder()
+	failing.ServeHTTP(w, r)
+	if got, want := w.Body.String(), "Internal Server Error\nrequest id: some-id\n"; w.Code != http.StatusInternalServerError || got != want {
+		t.Fatalf("unexpected response; got %d %q, want %d %q", w.Code, got, http.StatusInternalServerError, want)
+	}
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	for _, tt := range []struct {
+		accept []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"*/*"}, false},
+		{[]string{"text/html,application/xhtml+xml,*/*;q=0.8"}, false},
+		{[]string{"application/json"}, true},
+		{[]string{"text/plain", "Application/JSON; charset=utf-8"}, true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header["Accept"] = tt.accept
+		if got := acceptsJSON(r); got != tt.want {
+			t.Errorf("acceptsJSON(%q) = %t, want %t", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultErrorHandler(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		status int
+		msg    string
+	}{
+		{fmt.Errorf("upload: %w", newError(http.StatusForbidden, CodeUploadDenied, "denied")), http.StatusForbidden, "denied"},
+		{&http.MaxBytesError{Limit: 1}, http.StatusRequestEntityTooLarge, "Request Entity Too Large"},
+		{fmt.Errorf("lookup: %w", database.ErrNoResults), http.StatusNotFound, "Not Found"},
+		{errors.New("dial tcp 10.0.0.1:5432: connection refused"), http.StatusInternalServerError, "Internal Server Error"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if status, msg := DefaultErrorHandler(r.Context(), r, tt.err); status != tt.status || msg != tt.msg {
+			t.Errorf("DefaultErrorHandler(%v) = %d %q, want %d %q", tt.err, status, msg, tt.status, tt.msg)
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var reported []error
+	s, err := New(context.Background(),
+		DB(memory.New()),
+		FS(failFS{memfs.New(), errors.New("disk on fire")}),
+		Limit(1<<10),
+		Admin("secret"),
+		HandleErrors(func(ctx context.Context, r *http.Request, err error) (int, string) {
+			reported = append(reported, err)
+			if errors.Is(err, errNotFound) {
+				return http.StatusGone, "never existed"
+			}
+			return DefaultErrorHandler(ctx, r, err)
+		}),
+		Logger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		r      *http.Request
+		status int
+		code   ErrorCode
+		msg    string
+		err    string
+	}{
+		{multipartRequest(t, 1), http.StatusInternalServerError, CodeInternal, "Internal Server Error", "disk on fire"},
+		{httptest.NewRequest(http.MethodGet, "/missing", nil), http.StatusGone, CodeGone, "never existed", "Not Found"},
+		{adminRequest(http.MethodGet, "/admin/files/missing"), http.StatusGone, CodeGone, "never existed", "Not Found"},
+	} {
+		reported = nil
+		tt.r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, tt.r)
+		var b errorBody
+		if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.status || b.Error.Code != tt.code || b.Error.Message != tt.msg {
+			t.Fatalf("unexpected response; got %d %+v, want %d %q %q", w.Code, b.Error, tt.status, tt.code, tt.msg)
+		}
+		if len(reported) != 1 || !strings.Contains(reported[0].Error(), tt.err) {
+			t.Fatalf("unexpected errors handled; got %v, want one of %q", reported, tt.err)
+		}
+	}
+}
+
+// adminRequest returns a request of the admin API with the token "secret".
+func adminRequest(method, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	return r
+}
@@ -0,0 +1,185 @@
+package kipp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+)
+
+// EventVersion is the version of Event. Fields may be added without changing
+// it, but it's incremented if any are changed or removed, so consumers can
+// tell events they don't understand apart.
+const EventVersion = 1
+
+// An EventType is the type of an Event.
+type EventType string
+
+// The types of events.
+const (
+	// EventUpload is an entry which was uploaded.
+	EventUpload EventType = "upload"
+	// EventDelete is an entry which was deleted, whether by the admin API,
+	// purging, a dangling scan or eviction.
+	EventDelete EventType = "delete"
+	// EventExpire is an entry which was reaped once its lifetime ended.
+	EventExpire EventType = "expire"
+	// EventDownload is an entry which was downloaded. Downloads are only
+	// streamed by the admin API, as there are far too many for webhooks
+	// and EventPublishers.
+	EventDownload EventType = "download"
+)
+
+// An Event is something which happened to an entry. It's what webhooks are
+// sent, and what EventPublishers publish.
+type Event struct {
+	// Version is EventVersion.
+	Version int       `json:"version"`
+	Type    EventType `json:"type"`
+	// Time is when the event happened.
+	Time     time.Time  `json:"time"`
+	Slug     string     `json:"slug"`
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Sum      string     `json:"sum"`
+	Uploaded time.Time  `json:"uploaded"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// newEvent returns the event typ of e, which happened now.
+func newEvent(typ EventType, e database.Entry) Event {
+	return Event{
+		Version:  EventVersion,
+		Type:     typ,
+		Time:     time.Now().UTC(),
+		Slug:     e.Slug,
+		Name:     e.Name,
+		Size:     e.Size,
+		Sum:      e.Sum,
+		Uploaded: e.Timestamp,
+		Expires:  e.Lifetime,
+	}
+}
+
+// An EventPublisher publishes events, such as to a message bus. Publish is
+// called with events one at a time, in the order they happened, so events
+// of the same entry are never reordered. It's called in the background, so
+// it may block, but events which happen meanwhile are queued, and dropped
+// once too many are. Errors are logged and counted, but events aren't
+// published again, so publishers which need to retry must do so themselves.
+type EventPublisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// eventQueue is how many events may wait to be published by each
+// EventPublisher before more are dropped.
+const eventQueue = 1024
+
+// eventPublishers queue events for EventPublishers, so publishing never holds
+// up the request or background work which caused them. Each publisher has
+// its own queue, so they can't hold each other up. Events still queued when
+// the server shuts down are never published.
+type eventPublishers struct {
+	queues []*publisherQueue
+	logger *slog.Logger
+
+	published, failures, dropped prometheus.Counter
+}
+
+// publisherQueue is an EventPublisher and the events it's yet to publish.
+type publisherQueue struct {
+	p     EventPublisher
+	queue chan Event
+}
+
+func newEventPublishers(r prometheus.Registerer, ps []EventPublisher, logger *slog.Logger) (*eventPublishers, error) {
+	e := &eventPublishers{
+		logger: logger,
+		published: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "events_published_total",
+			Help:      "Number of events published by event publishers.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "event_publish_failures_total",
+			Help:      "Number of events which event publishers failed to publish.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "events_dropped_total",
+			Help:      "Number of events dropped because the queue of an event publisher was full.",
+		}),
+	}
+	for _, p := range ps {
+		e.queues = append(e.queues, &publisherQueue{p: p, queue: make(chan Event, eventQueue)})
+	}
+	for _, c := range []prometheus.Collector{e.published, e.failures, e.dropped} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// Publish queues ev to be published by every publisher.
+func (e *eventPublishers) Publish(ev Event) {
+	for _, q := range e.queues {
+		select {
+		case q.queue <- ev:
+		default:
+			e.dropped.Inc()
+			e.logger.Warn("event queue is full", "type", ev.Type, "slug", ev.Slug)
+		}
+	}
+}
+
+// run publishes queued events until ctx is done.
+func (e *eventPublishers) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, q := range e.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case ev := <-q.queue:
+					if err := q.p.Publish(ctx, ev); err != nil {
+						e.failures.Inc()
+						e.logger.Warn("publish event", "type", ev.Type, "slug", ev.Slug, "error", err)
+						continue
+					}
+					e.published.Inc()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// notify notifies the admin event stream, webhooks and event publishers, if
+// there are any, of the event typ of e.
+func (s Server) notify(typ EventType, e database.Entry) {
+	if s.stream == nil && s.webhooks == nil && s.events == nil {
+		return
+	}
+	ev := newEvent(typ, e)
+	if s.stream != nil {
+		s.stream.Publish(ev)
+	}
+	if typ == EventDownload {
+		return
+	}
+	if s.webhooks != nil {
+		s.webhooks.Notify(ev)
+	}
+	if s.events != nil {
+		s.events.Publish(ev)
+	}
+}
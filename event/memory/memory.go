@@ -0,0 +1,39 @@
+// Package memory implements a kipp event publisher in memory, which is useful
+// for tests and for programs which embed the server and consume its events
+// themselves. A Publisher is safe for concurrent use.
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/uhthomas/kipp"
+)
+
+// Publisher is a mutex guarded slice of the events it's published, in the
+// order they were.
+type Publisher struct {
+	mu     sync.Mutex
+	events []kipp.Event
+}
+
+var _ kipp.EventPublisher = (*Publisher)(nil)
+
+// New returns a new Publisher which has published nothing.
+func New() *Publisher { return &Publisher{} }
+
+// Publish appends e to the events p has published.
+func (p *Publisher) Publish(ctx context.Context, e kipp.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+	return nil
+}
+
+// Events returns the events p has published, in the order it did.
+func (p *Publisher) Events() []kipp.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.events)
+}
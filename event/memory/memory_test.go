@@ -0,0 +1,26 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uhthomas/kipp"
+)
+
+func TestPublisher(t *testing.T) {
+	p := New()
+	for _, slug := range []string{"a", "b"} {
+		if err := p.Publish(context.Background(), kipp.Event{Type: kipp.EventUpload, Slug: slug}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := p.Events()
+	if len(events) != 2 || events[0].Slug != "a" || events[1].Slug != "b" {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	// The events returned are a copy.
+	events[0].Slug = "c"
+	if got := p.Events()[0].Slug; got != "a" {
+		t.Fatalf("events were modified; got %q, want %q", got, "a")
+	}
+}
@@ -0,0 +1,54 @@
+// Package nats publishes kipp events to NATS. It doesn't depend on a NATS
+// client, but publishes with anything which implements Conn, which
+// *nats.Conn of github.com/nats-io/nats.go does, so programs which embed the
+// server bring their own. Publishers for other message buses, such as Kafka,
+// can be written the same way, keyed by the slug of events so those of each
+// entry stay in order.
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/uhthomas/kipp"
+)
+
+// DefaultPrefix is the prefix of subjects if New isn't given one.
+const DefaultPrefix = "kipp"
+
+// Conn publishes messages to subjects. *nats.Conn implements it.
+type Conn interface {
+	Publish(subject string, data []byte) error
+}
+
+// Publisher publishes events as JSON to the subject of their type under a
+// prefix, such as kipp.upload. It implements kipp.EventPublisher.
+type Publisher struct {
+	conn   Conn
+	prefix string
+}
+
+var _ kipp.EventPublisher = (*Publisher)(nil)
+
+// New returns a Publisher which publishes events with conn under prefix, or
+// DefaultPrefix if it's empty.
+func New(conn Conn, prefix string) *Publisher {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &Publisher{conn: conn, prefix: prefix}
+}
+
+// Publish publishes e. NATS buffers what's published, so it only fails if
+// the connection is closed or its buffer is full.
+func (p *Publisher) Publish(ctx context.Context, e kipp.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	if err := p.conn.Publish(p.prefix+"."+string(e.Type), b); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	return nil
+}
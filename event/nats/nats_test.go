@@ -0,0 +1,78 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp"
+)
+
+// conn records what's published, or fails with err.
+type conn struct {
+	subjects []string
+	data     [][]byte
+	err      error
+}
+
+func (c *conn) Publish(subject string, data []byte) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.subjects = append(c.subjects, subject)
+	c.data = append(c.data, data)
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	c := &conn{}
+	want := kipp.Event{
+		Version:  kipp.EventVersion,
+		Type:     kipp.EventDelete,
+		Time:     time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC),
+		Slug:     "a1b2c3d4",
+		Name:     "cat.png",
+		Size:     48213,
+		Uploaded: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := New(c, "").Publish(context.Background(), want); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.subjects) != 1 || c.subjects[0] != "kipp.delete" {
+		t.Fatalf("unexpected subjects: %v", c.subjects)
+	}
+	var got kipp.Event
+	if err := json.Unmarshal(c.data[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("unexpected event; got %+v, want %+v", got, want)
+	}
+
+	c.err = errors.New("nats: connection closed")
+	if err := New(c, "files").Publish(context.Background(), want); !errors.Is(err, c.err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, c.err)
+	}
+}
+
+// printConn prints what's published, standing in for a *nats.Conn.
+type printConn struct{}
+
+func (printConn) Publish(subject string, data []byte) error {
+	fmt.Println(subject)
+	return nil
+}
+
+func Example() {
+	// nc, err := nats.Connect(nats.DefaultURL)
+	nc := printConn{}
+	p := New(nc, "files")
+	p.Publish(context.Background(), kipp.Event{Type: kipp.EventUpload, Slug: "a1b2c3d4"})
+
+	// The publisher is given to the server with kipp.PublishEvents(p).
+
+	// Output: files.upload
+}
@@ -0,0 +1,139 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// recorder is an EventPublisher which records what it publishes, failing
+// with err if it isn't nil.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (r *recorder) Publish(ctx context.Context, e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+// wait waits for r to have published n events, returning them.
+func (r *recorder) wait(t *testing.T, n int) []Event {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		events := r.events
+		r.mu.Unlock()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected events; got %d, want %d", len(events), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventPublishers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var a, b recorder
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), PublishEvents(&a, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries are uploaded and deleted concurrently, but the events of
+	// each are published in order.
+	const workers, n = 8, 10
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range n {
+				w := upload(t, s, 5)
+				if w.Code != http.StatusSeeOther {
+					t.Errorf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+					return
+				}
+				slug := strings.TrimSuffix(path.Base(w.Header().Get("Location")), ".txt")
+				if err := s.Delete(ctx, slug); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, r := range []*recorder{&a, &b} {
+		seen := make(map[string]EventType)
+		for _, e := range r.wait(t, 2*workers*n) {
+			if e.Version != EventVersion {
+				t.Fatalf("unexpected version; got %d, want %d", e.Version, EventVersion)
+			}
+			if prev := seen[e.Slug]; (e.Type == EventUpload) != (prev == "") {
+				t.Fatalf("%s event of %s followed %q", e.Type, e.Slug, prev)
+			}
+			seen[e.Slug] = e.Type
+		}
+	}
+}
+
+func TestEventPublishersDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failing := &recorder{err: errors.New("unavailable")}
+	ep, err := newEventPublishers(prometheus.NewRegistry(), []EventPublisher{failing}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		ep.run(ctx)
+		close(done)
+	}()
+	ep.Publish(newEvent(EventUpload, databasetest.NewEntry("a")))
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(ep.failures) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("failure wasn't counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+	// Nothing publishes events once it's stopped, so the queue fills
+	// rather than blocking.
+	for i := range eventQueue + 1 {
+		ep.Publish(newEvent(EventUpload, databasetest.NewEntry(fmt.Sprint(i))))
+	}
+	if got := testutil.ToFloat64(ep.dropped); got != 1 {
+		t.Fatalf("unexpected dropped events; got %v, want 1", got)
+	}
+}
@@ -0,0 +1,163 @@
+package kipp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+)
+
+// pinnedTag is the tag of entries which are never evicted.
+const pinnedTag = "pinned"
+
+// Evict deletes the least recently accessed entries along with their files
+// while the total size of stored files exceeds EvictHigh, until it's no more
+// than EvictLow, returning how many were deleted and the total size of their
+// files. Expired entries go first, as they're no longer served.
+//
+// Entries tagged "pinned", soft deleted entries, which are left to Purge, and
+// entries uploaded less than EvictMinAge ago are never evicted. Entries which
+// were never downloaded, or all of them if access isn't tracked, were last
+// accessed when they were uploaded. Entries which fail are logged and
+// skipped, as with Reap.
+func (s Server) Evict(ctx context.Context) (n int, size int64, err error) {
+	st, err := s.Stats(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stats: %w", err)
+	}
+	stored := st.Bytes + st.GzipBytes
+	if stored <= s.EvictHigh {
+		return 0, 0, nil
+	}
+	now := time.Now()
+	entries, err := s.evictable(ctx, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		if stored <= s.EvictLow {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return n, size, err
+		}
+		if err := s.reap(ctx, e); err != nil {
+			s.logger().Warn("evict", "slug", e.Slug, "error", err)
+			if s.evictMetrics != nil {
+				s.evictMetrics.failures.Inc()
+			}
+			continue
+		}
+		s.notify(EventDelete, e)
+		n++
+		size += e.Size + e.GzipSize
+		stored -= e.Size + e.GzipSize
+		if s.evictMetrics != nil {
+			s.evictMetrics.entries.Inc()
+			s.evictMetrics.bytes.Add(float64(e.Size + e.GzipSize))
+		}
+	}
+	if stored > s.EvictLow {
+		s.logger().Warn("evicted everything evictable, but storage is still above the low-water mark", "stored", stored)
+	}
+	return n, size, nil
+}
+
+// evictable lists the entries which may be evicted, in the order they should
+// be.
+func (s Server) evictable(ctx context.Context, now time.Time) ([]database.Entry, error) {
+	before := now.Add(-s.EvictMinAge)
+	var (
+		evictable []database.Entry
+		opts      database.ListOptions
+	)
+	for {
+		entries, next, err := s.Database.List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list: %w", err)
+		}
+		for _, e := range entries {
+			if e.Deleted != nil || e.Timestamp.After(before) || slices.Contains(e.Tags, pinnedTag) {
+				continue
+			}
+			evictable = append(evictable, e)
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	expired := func(e database.Entry) bool { return e.Lifetime != nil && e.Lifetime.Before(now) }
+	lastAccess := func(e database.Entry) time.Time {
+		if e.LastAccess != nil {
+			return *e.LastAccess
+		}
+		return e.Timestamp
+	}
+	sort.SliceStable(evictable, func(i, j int) bool {
+		if a, b := expired(evictable[i]), expired(evictable[j]); a != b {
+			return a
+		}
+		return lastAccess(evictable[i]).Before(lastAccess(evictable[j]))
+	})
+	return evictable, nil
+}
+
+// evictMetrics export what's been evicted.
+type evictMetrics struct {
+	entries, bytes, failures prometheus.Counter
+}
+
+func newEvictMetrics(r prometheus.Registerer) (*evictMetrics, error) {
+	m := &evictMetrics{
+		entries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "evicted_entries_total",
+			Help:      "Number of entries evicted to free up space.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "evicted_bytes_total",
+			Help:      "Total size of the files of entries evicted to free up space.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "eviction_failures_total",
+			Help:      "Number of entries which failed to be evicted.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.entries, m.bytes, m.failures} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// runEvictions evicts entries every interval until ctx is done.
+func runEvictions(ctx context.Context, s Server, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		// Nothing is evicted while the server is read-only.
+		if s.readOnly.On() {
+			continue
+		}
+		n, size, err := s.Evict(ctx)
+		if n > 0 {
+			s.logger().Info("evicted entries", "entries", n, "bytes", size)
+		}
+		if err != nil && ctx.Err() == nil {
+			s.logger().Error("evict", "error", err)
+		}
+	}
+}
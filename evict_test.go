@@ -0,0 +1,101 @@
+package kipp
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+
+	fs := memfs.New()
+	s := Server{Database: memory.New(), FileSystem: fs, EvictHigh: 60, EvictLow: 35, EvictMinAge: time.Hour}
+	m, err := newEvictMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.evictMetrics = m
+
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	// d has expired, so goes first even though it was accessed last, then
+	// e, which was never accessed, and then the rest by last access. c is
+	// pinned, f is too new and g is soft deleted, so they're kept.
+	for _, slug := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		e := databasetest.NewEntry(slug)
+		e.Size, e.GzipSize, e.Lifetime, e.Timestamp, e.Tags = 10, 0, nil, *ago(2 * time.Hour), nil
+		switch slug {
+		case "a":
+			e.LastAccess = ago(time.Hour)
+		case "b":
+			e.LastAccess = ago(10 * time.Minute)
+		case "c":
+			e.LastAccess, e.Tags = ago(3*time.Hour), []string{pinnedTag}
+		case "d":
+			e.LastAccess, e.Lifetime = ago(time.Minute), ago(time.Minute)
+		case "e":
+			e.LastAccess = nil
+		case "f":
+			e.LastAccess, e.Timestamp = nil, now
+		case "g":
+			e.Deleted = ago(time.Minute)
+		}
+		if err := s.Database.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := fs.Create(ctx, slug, strings.NewReader("abc")); err != nil {
+			t.Fatalf("create %s: %v", slug, err)
+		}
+	}
+
+	n, size, err := s.Evict(ctx)
+	if err != nil || n != 4 || size != 40 {
+		t.Fatalf("unexpected result; got %d entries taking up %d bytes and %v, want 4 taking up 40 bytes", n, size, err)
+	}
+	if got, want := listSlugs(t, s.Database), []string{"c", "g", "f"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entries; got %q, want %q", got, want)
+	}
+	if _, err := fs.Stat(ctx, "b"); err == nil {
+		t.Fatal("file of evicted entry wasn't removed")
+	}
+	if got := testutil.ToFloat64(m.bytes); got != 40 {
+		t.Fatalf("unexpected evicted bytes; got %v, want 40", got)
+	}
+
+	// It's now below the high-water mark.
+	if n, _, err := s.Evict(ctx); err != nil || n != 0 {
+		t.Fatalf("unexpected result; got %d entries and %v, want none", n, err)
+	}
+}
+
+func TestEvictionMarks(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		high, low, quota int64
+		ok               bool
+	}{
+		{high: 10, low: 5, ok: true},
+		{high: 10, low: 10},
+		{high: 10, low: -1},
+		{high: 10, low: 5, quota: 20, ok: true},
+		{high: 10, low: 5, quota: 10},
+	} {
+		_, err := New(ctx, DB(memory.New()), Eviction(time.Hour, tt.high, tt.low, 0), Quota(tt.quota), DatabaseMetrics(false))
+		if (err == nil) != tt.ok {
+			t.Fatalf("unexpected error for high %d, low %d and quota %d; got %v", tt.high, tt.low, tt.quota, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// blockSize is the default size of the blocks blobs are staged in. Each upload
+// buffers up to its concurrency blocks in memory.
+const blockSize = 4 << 20
+
+// FileSystem is an abstraction over an Azure Blob Storage container which
+// allows for the creation, opening and removal of blobs.
+type FileSystem struct {
+	client      *container.Client
+	prefix      string
+	blockSize   int64
+	concurrency int
+
+	cred      azcore.TokenCredential
+	sharedKey *container.SharedKeyCredential
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Prefix stores blobs under prefix, so a container can be shared. A trailing
+// slash is added if it's missing.
+func Prefix(prefix string) Option {
+	return func(fs *FileSystem) error {
+		if prefix = strings.TrimPrefix(prefix, "/"); prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		fs.prefix = prefix
+		return nil
+	}
+}
+
+// BlockSize sets the size of the blocks blobs are staged in, which must be at
+// least 1MiB and at most 4000MiB. Blobs can have at most 50,000 blocks, so
+// the block size limits how large they can be.
+func BlockSize(n int64) Option {
+	return func(fs *FileSystem) error {
+		if n < 1<<20 || n > blockblob.MaxStageBlockBytes {
+			return fmt.Errorf("invalid block size %d", n)
+		}
+		fs.blockSize = n
+		return nil
+	}
+}
+
+// Concurrency sets how many blocks of each blob are staged at once.
+func Concurrency(n int) Option {
+	return func(fs *FileSystem) error {
+		if n < 1 {
+			return fmt.Errorf("invalid concurrency %d", n)
+		}
+		fs.concurrency = n
+		return nil
+	}
+}
+
+// SharedKey authenticates with the storage account's name and key.
+func SharedKey(account, key string) Option {
+	return func(fs *FileSystem) error {
+		cred, err := container.NewSharedKeyCredential(account, key)
+		if err != nil {
+			return fmt.Errorf("shared key credential: %w", err)
+		}
+		fs.sharedKey = cred
+		return nil
+	}
+}
+
+// Credential authenticates with cred, such as a managed identity.
+func Credential(cred azcore.TokenCredential) Option {
+	return func(fs *FileSystem) error {
+		fs.cred = cred
+		return nil
+	}
+}
+
+// New creates a FileSystem for the container at containerURL, such as
+// https://account.blob.core.windows.net/container. If the URL has a SAS
+// token, it's used to authenticate. Otherwise, unless an option says
+// otherwise, the default Azure credential chain is used, which includes the
+// environment, workload identity and managed identity.
+func New(containerURL string, opts ...Option) (*FileSystem, error) {
+	fs := &FileSystem{blockSize: blockSize, concurrency: 1}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	switch {
+	case fs.sharedKey != nil:
+		fs.client, err = container.NewClientWithSharedKeyCredential(containerURL, fs.sharedKey, nil)
+	case fs.cred != nil:
+		fs.client, err = container.NewClient(containerURL, fs.cred, nil)
+	case strings.Contains(containerURL, "sig="):
+		fs.client, err = container.NewClientWithNoCredential(containerURL, nil)
+	default:
+		var cred *azidentity.DefaultAzureCredential
+		if cred, err = azidentity.NewDefaultAzureCredential(nil); err != nil {
+			return nil, fmt.Errorf("default credential: %w", err)
+		}
+		fs.client, err = container.NewClient(containerURL, cred, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("new client: %w", err)
+	}
+	return fs, nil
+}
+
+// Create stages r as blocks of the named blob, which are committed once all
+// of r is read. Blocks of failed or cancelled uploads are never committed,
+// and are discarded by Azure.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	if _, err := fs.client.NewBlockBlobClient(fs.prefix+name).UploadStream(ctx, r, &blockblob.UploadStreamOptions{
+		BlockSize:   fs.blockSize,
+		Concurrency: fs.concurrency,
+	}); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	return nil
+}
+
+// Open opens the named blob. The reader supports seeking, which is done with
+// ranged downloads.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r, err := newReader(ctx, fs.blob(name))
+	if isNotFound(err) {
+		return nil, filesystem.NotExist("open", name)
+	}
+	return r, err
+}
+
+// Remove removes the named blob.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	if _, err := fs.blob(name).Delete(ctx, nil); err != nil {
+		if isNotFound(err) {
+			return filesystem.NotExist("remove", name)
+		}
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// Stat describes the named blob from its properties.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	props, err := fs.blob(name).GetProperties(ctx, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+		}
+		return filesystem.FileInfo{}, fmt.Errorf("get properties: %w", err)
+	}
+	fi := filesystem.FileInfo{Name: name}
+	if props.ContentLength != nil {
+		fi.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		fi.ModTime = *props.LastModified
+	}
+	if props.ETag != nil {
+		fi.ETag = string(*props.ETag)
+	}
+	return fi, nil
+}
+
+// Ping checks the container can be reached by getting its properties.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	if _, err := fs.client.GetProperties(ctx, nil); err != nil {
+		return fmt.Errorf("get container properties: %w", err)
+	}
+	return nil
+}
+
+// Walk calls fn with each blob under the prefix. Blobs with further slashes
+// in their names are skipped, as they aren't objects of the file system.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	pager := fs.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &fs.prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list blobs: %w", err)
+		}
+		for _, b := range page.Segment.BlobItems {
+			if b.Name == nil {
+				continue
+			}
+			fi := filesystem.FileInfo{Name: strings.TrimPrefix(*b.Name, fs.prefix)}
+			if strings.Contains(fi.Name, "/") {
+				continue
+			}
+			if p := b.Properties; p != nil {
+				if p.ContentLength != nil {
+					fi.Size = *p.ContentLength
+				}
+				if p.LastModified != nil {
+					fi.ModTime = *p.LastModified
+				}
+				if p.ETag != nil {
+					fi.ETag = string(*p.ETag)
+				}
+			}
+			if err := fn(fi); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// blob returns a client for the named blob.
+func (fs *FileSystem) blob(name string) *blob.Client {
+	return fs.client.NewBlobClient(fs.prefix + name)
+}
+
+// isNotFound reports whether err is from a blob which doesn't exist.
+func isNotFound(err error) bool {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return true
+	}
+	var re *azcore.ResponseError
+	return errors.As(err, &re) && re.StatusCode == 404
+}
@@ -0,0 +1,172 @@
+package azure_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/azure"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+)
+
+// The well known account and key Azurite accepts.
+const (
+	account = "devstoreaccount1"
+	key     = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// open creates a container in the Azurite blob service at KIPP_TEST_AZURITE,
+// such as http://127.0.0.1:10000/devstoreaccount1, skipping the test if it
+// isn't set. The container is deleted when the test finishes.
+func open(t *testing.T, opts ...azure.Option) (*azure.FileSystem, *container.Client) {
+	endpoint := os.Getenv("KIPP_TEST_AZURITE")
+	if endpoint == "" {
+		t.Skip("KIPP_TEST_AZURITE not set")
+	}
+	ctx := context.Background()
+	u := fmt.Sprintf("%s/kipp-test-%d", endpoint, time.Now().UnixNano())
+	cred, err := container.NewSharedKeyCredential(account, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := container.NewClientWithSharedKeyCredential(u, cred, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := c.Delete(ctx, nil); err != nil {
+			t.Error(err)
+		}
+	})
+	fs, err := azure.New(u, append(opts, azure.SharedKey(account, key))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, c
+}
+
+func TestFileSystem(t *testing.T) {
+	var i interface{} = (*azure.FileSystem)(nil)
+	if _, ok := i.(filesystem.FileSystem); !ok {
+		t.Fatal("azure.FileSystem does not implement fs.FileSystem")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, _ := open(t)
+		return fs
+	})
+}
+
+func TestCreateOpen(t *testing.T) {
+	ctx := context.Background()
+	fs, c := open(t, azure.Prefix("files"))
+
+	want := []byte("some content")
+	if err := fs.Create(ctx, "a", bytes.NewReader(want)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := c.NewBlobClient("files/a").GetProperties(ctx, nil); err != nil {
+		t.Fatalf("blob should be stored under the prefix: %v", err)
+	}
+
+	f, err := fs.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("read all; got %q and %v, want %q", got, err, want)
+	}
+
+	// Ranged reads.
+	if n, err := f.(io.Seeker).Seek(-7, io.SeekEnd); err != nil || n != 5 {
+		t.Fatalf("seek end; got %d and %v, want 5", n, err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(f, b); err != nil || string(b) != "cont" {
+		t.Fatalf("read; got %q and %v, want %q", b, err, "cont")
+	}
+	if n, err := f.(io.Seeker).Seek(1, io.SeekCurrent); err != nil || n != 10 {
+		t.Fatalf("seek current; got %d and %v, want 10", n, err)
+	}
+	if got, err := io.ReadAll(f); err != nil || string(got) != "nt" {
+		t.Fatalf("read all; got %q and %v, want %q", got, err, "nt")
+	}
+
+	if err := fs.Remove(ctx, "a"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := fs.Open(ctx, "a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrNotExist)
+	}
+	if err := fs.Remove(ctx, "a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+// TestCreateBlocks checks blobs larger than a block are committed whole.
+func TestCreateBlocks(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := open(t, azure.BlockSize(1<<20), azure.Concurrency(2))
+
+	want := make([]byte, 3<<20+1)
+	rand.Read(want)
+	if err := fs.Create(ctx, "large", bytes.NewReader(want)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f, err := fs.Open(ctx, "large")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("read all; got %d bytes and %v, want %d bytes", len(got), err, len(want))
+	}
+}
+
+// TestCreateCancel checks blobs aren't committed when the upload is cancelled.
+func TestCreateCancel(t *testing.T) {
+	fs, _ := open(t, azure.BlockSize(1<<20))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := filesystem.PipeReader(func(w io.Writer) error {
+		// More than a block, and then the upload is cancelled.
+		if _, err := io.CopyN(w, rand.Reader, 2<<20); err != nil {
+			return err
+		}
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := fs.Create(ctx, "cancelled", r); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := fs.Open(context.Background(), "cancelled"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, opt := range []azure.Option{
+		azure.BlockSize(1),
+		azure.BlockSize(5 << 30),
+		azure.Concurrency(0),
+	} {
+		if _, err := azure.New("https://kipp.blob.core.windows.net/kipp", opt); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// reader reads a blob, downloading it from its offset whenever it's read
+// after seeking.
+type reader struct {
+	ctx          context.Context
+	client       *blob.Client
+	body         io.ReadCloser
+	offset, size int64
+}
+
+// newReader downloads the blob, so its size is known and errors for missing
+// blobs are returned up front.
+func newReader(ctx context.Context, client *blob.Client) (*reader, error) {
+	r := &reader{ctx: ctx, client: client}
+	if err := r.reset(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		if err := r.reset(); err != nil {
+			return 0, fmt.Errorf("reset: %w", err)
+		}
+	}
+	n, err = r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.body, r.offset = nil, offset
+	}
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// reset downloads the blob from the current offset.
+func (r *reader) reset() error {
+	r.Close()
+	resp, err := r.client.DownloadStream(r.ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: r.offset},
+	})
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	r.body = resp.Body
+	if r.offset == 0 && resp.ContentLength != nil {
+		r.size = *resp.ContentLength
+	}
+	return nil
+}
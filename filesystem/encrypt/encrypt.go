@@ -0,0 +1,242 @@
+// Package encrypt implements a file system which encrypts objects at rest
+// with AES-256-GCM, so they can't be read from the underlying file system
+// without the key.
+//
+// Each object is encrypted with its own random data key, which is stored in
+// the object's header encrypted with the master key. The plaintext is split
+// into chunks which are sealed separately, so readers can seek by decrypting
+// only the chunks they read. Each chunk's nonce is its index, and whether it's
+// the last chunk, so chunks can't be reordered, and objects can't be
+// truncated, without it being detected.
+package encrypt
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+const (
+	// magic identifies encrypted objects, and the version of the format.
+	magic = "kippenc1"
+	// chunkSize is the default size of plaintext chunks.
+	chunkSize = 64 << 10
+	// keySize is the size of master and data keys.
+	keySize = 32
+	// headerSize is the size of the header: the magic, chunk size, and the
+	// nonce, ciphertext and tag of the data key.
+	headerSize = 8 + 4 + 12 + keySize + 16
+)
+
+// ErrInvalid is returned when an object isn't encrypted, was encrypted with a
+// different key, or has been tampered with.
+var ErrInvalid = errors.New("invalid encrypted object")
+
+// FileSystem encrypts objects in the file system it wraps.
+type FileSystem struct {
+	fs        filesystem.FileSystem
+	master    cipher.AEAD
+	chunkSize int
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Key sets the 32 byte master key, which encrypts the data key of each
+// object. It's required.
+func Key(key []byte) Option {
+	return func(fs *FileSystem) error {
+		if len(key) != keySize {
+			return fmt.Errorf("key must be %d bytes, not %d", keySize, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		fs.master = aead
+		return nil
+	}
+}
+
+// ChunkSize sets the size of the plaintext chunks objects are encrypted in.
+// Each chunk adds 16 bytes of overhead, and readers decrypt whole chunks.
+// Objects remember their chunk size, so it can be changed at any time.
+func ChunkSize(n int) Option {
+	return func(fs *FileSystem) error {
+		if n < 1<<10 || n > 16<<20 {
+			return fmt.Errorf("invalid chunk size %d", n)
+		}
+		fs.chunkSize = n
+		return nil
+	}
+}
+
+// New returns a FileSystem which encrypts objects in fs.
+func New(fs filesystem.FileSystem, opts ...Option) (*FileSystem, error) {
+	efs := &FileSystem{fs: fs, chunkSize: chunkSize}
+	for _, opt := range opts {
+		if err := opt(efs); err != nil {
+			return nil, err
+		}
+	}
+	if efs.master == nil {
+		return nil, errors.New("key is required")
+	}
+	return efs, nil
+}
+
+// Create encrypts r and stores it as the named object.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	return fs.fs.Create(ctx, name, filesystem.PipeReader(func(w io.Writer) error {
+		return fs.encrypt(w, r)
+	}))
+}
+
+// encrypt writes a header and the encrypted chunks of r to w.
+func (fs *FileSystem) encrypt(w io.Writer, r io.Reader) error {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return fmt.Errorf("data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(magic)+4, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[len(magic):], uint32(fs.chunkSize))
+	nonce := header[len(header) : len(header)+fs.master.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("nonce: %w", err)
+	}
+	header = fs.master.Seal(header[:len(header)+len(nonce)], nonce, dataKey, header[:len(magic)+4])
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// Read a byte ahead, so the last chunk is known.
+	br := bufio.NewReaderSize(r, fs.chunkSize)
+	buf := make([]byte, fs.chunkSize, fs.chunkSize+aead.Overhead())
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < len(buf)
+		if !last {
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(aead.Seal(buf[:0], chunkNonce(i, last), buf[:n], header)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Open opens the named object, which is decrypted as it's read. Objects are
+// seeked to work out their sizes, so the file system fs wraps must be able to
+// seek them.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r, err := fs.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the named object of the file system fs wraps, and reads its
+// header.
+func (fs *FileSystem) open(ctx context.Context, name string) (*reader, error) {
+	f, err := fs.fs.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rs, ok := f.(filesystem.ReadSeeker)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("%s: can't seek: %w", name, filesystem.ErrUnsupported)
+	}
+	r, err := fs.newReader(rs)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return r, nil
+}
+
+// Remove removes the named object.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	return fs.fs.Remove(ctx, name)
+}
+
+// Stat describes the named object. Its size is that of the plaintext, which is
+// worked out from the object's header, so the header is read.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	fi, err := fs.fs.Stat(ctx, name)
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	r, err := fs.open(ctx, name)
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	defer r.Close()
+	fi.Size = r.size
+	return fi, nil
+}
+
+// Walk calls fn with each object of the file system fs wraps, returning an
+// error wrapping filesystem.ErrUnsupported if it isn't a filesystem.Walker.
+// Sizes are of the encrypted objects.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	w, ok := fs.fs.(filesystem.Walker)
+	if !ok {
+		return fmt.Errorf("walk: %w", filesystem.ErrUnsupported)
+	}
+	return w.Walk(ctx, fn)
+}
+
+// Ping pings the file system fs wraps, returning an error wrapping
+// filesystem.ErrUnsupported if it isn't a filesystem.Pinger.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	p, ok := fs.fs.(filesystem.Pinger)
+	if !ok {
+		return fmt.Errorf("ping: %w", filesystem.ErrUnsupported)
+	}
+	return p.Ping(ctx)
+}
+
+// newAEAD returns AES-GCM with the given key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of the ith chunk. Data keys are only used for
+// one object, so nonces needn't be random.
+func chunkNonce(i uint64, last bool) []byte {
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[3:11], i)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce[:]
+}
@@ -0,0 +1,213 @@
+package encrypt_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/encrypt"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/memory"
+)
+
+const chunkSize = 1 << 10
+
+func newKey(t testing.TB) []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// open returns an encrypted file system, and the file system it wraps.
+func open(t testing.TB, key []byte) (*encrypt.FileSystem, *memory.FileSystem) {
+	mfs := memory.New()
+	fs, err := encrypt.New(mfs, encrypt.Key(key), encrypt.ChunkSize(chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, mfs
+}
+
+func TestFileSystem(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, _ := open(t, newKey(t))
+		return fs
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	fs, mfs := open(t, newKey(t))
+
+	for _, n := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		want := make([]byte, n)
+		rand.Read(want)
+		if err := fs.Create(ctx, "a", bytes.NewReader(want)); err != nil {
+			t.Fatalf("create %d bytes: %v", n, err)
+		}
+
+		// The stored object must not contain the plaintext.
+		raw := read(t, mfs, "a")
+		if n > 16 && bytes.Contains(raw, want) {
+			t.Fatalf("%d bytes stored in plaintext", n)
+		}
+
+		f, err := fs.Open(ctx, "a")
+		if err != nil {
+			t.Fatalf("open %d bytes: %v", n, err)
+		}
+		if size, err := f.(io.Seeker).Seek(0, io.SeekEnd); err != nil || size != int64(n) {
+			t.Fatalf("size; got %d and %v, want %d", size, err, n)
+		}
+		if _, err := f.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("read all %d bytes; got %d bytes and %v", n, len(got), err)
+		}
+		f.Close()
+	}
+}
+
+// TestSeek checks reads starting in the middle of chunks, and spanning them.
+func TestSeek(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := open(t, newKey(t))
+
+	want := make([]byte, 5*chunkSize+123)
+	rand.Read(want)
+	if err := fs.Create(ctx, "a", bytes.NewReader(want)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f, err := fs.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	for _, tt := range []struct{ offset, n int64 }{
+		{offset: 3*chunkSize + 17, n: 100},
+		{offset: chunkSize - 10, n: 20},
+		{offset: 10, n: 3 * chunkSize},
+		{offset: 5*chunkSize + 100, n: 23},
+	} {
+		if _, err := f.(io.Seeker).Seek(tt.offset, io.SeekStart); err != nil {
+			t.Fatalf("seek: %v", err)
+		}
+		got := make([]byte, tt.n)
+		if _, err := io.ReadFull(f, got); err != nil || !bytes.Equal(got, want[tt.offset:tt.offset+tt.n]) {
+			t.Fatalf("read %d bytes at %d; got %v", tt.n, tt.offset, err)
+		}
+	}
+}
+
+// TestTamper checks modified, truncated, reordered and foreign objects can't
+// be read.
+func TestTamper(t *testing.T) {
+	ctx := context.Background()
+	key := newKey(t)
+	fs, mfs := open(t, key)
+
+	content := make([]byte, 3*chunkSize)
+	rand.Read(content)
+	if err := fs.Create(ctx, "a", bytes.NewReader(content)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	raw := read(t, mfs, "a")
+	headerSize := len(raw) - 3*(chunkSize+16)
+	chunk := func(i int) []byte {
+		off := headerSize + i*(chunkSize+16)
+		return raw[off : off+chunkSize+16]
+	}
+
+	for name, tampered := range map[string][]byte{
+		"flipped": func() []byte {
+			b := bytes.Clone(raw)
+			b[len(b)-chunkSize] ^= 1
+			return b
+		}(),
+		"truncated": raw[:headerSize+2*(chunkSize+16)],
+		"reordered": append(append(append(bytes.Clone(raw[:headerSize]), chunk(1)...), chunk(0)...), chunk(2)...),
+		"header": func() []byte {
+			b := bytes.Clone(raw)
+			b[10] ^= 1
+			return b
+		}(),
+	} {
+		if err := mfs.Create(ctx, name, bytes.NewReader(tampered)); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Open(ctx, name)
+		if err == nil {
+			_, err = io.ReadAll(f)
+			f.Close()
+		}
+		if !errors.Is(err, encrypt.ErrInvalid) {
+			t.Fatalf("unexpected error for %s; got %v, want %v", name, err, encrypt.ErrInvalid)
+		}
+	}
+
+	// Objects encrypted with another key, or not at all.
+	other, err := encrypt.New(mfs, encrypt.Key(newKey(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Create(ctx, "plain", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		fs   *encrypt.FileSystem
+		name string
+	}{{other, "a"}, {fs, "plain"}} {
+		if _, err := tt.fs.Open(ctx, tt.name); !errors.Is(err, encrypt.ErrInvalid) {
+			t.Fatalf("unexpected error for %s; got %v, want %v", tt.name, err, encrypt.ErrInvalid)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, opts := range [][]encrypt.Option{
+		nil,
+		{encrypt.Key(make([]byte, 16))},
+		{encrypt.Key(newKey(t)), encrypt.ChunkSize(1)},
+	} {
+		if _, err := encrypt.New(memory.New(), opts...); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+}
+
+func read(t testing.TB, fs filesystem.FileSystem, name string) []byte {
+	t.Helper()
+	f, err := fs.Open(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func BenchmarkCreate(b *testing.B) {
+	ctx := context.Background()
+	fs, err := encrypt.New(memory.New(), encrypt.Key(newKey(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	content := make([]byte, 16<<20)
+	b.SetBytes(int64(len(content)))
+	for i := 0; i < b.N; i++ {
+		if err := fs.Create(ctx, "a", bytes.NewReader(content)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
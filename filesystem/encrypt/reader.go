@@ -0,0 +1,139 @@
+package encrypt
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// reader decrypts an object, a chunk at a time.
+type reader struct {
+	f      filesystem.ReadSeeker
+	aead   cipher.AEAD
+	header []byte
+	// chunkSize is the size of plaintext chunks, and size is the size of
+	// the plaintext.
+	chunkSize, size int64
+	// chunks is the number of chunks.
+	chunks int64
+	offset int64
+	// chunk is the decrypted chunk at index i, if any.
+	chunk []byte
+	i     int64
+	buf   []byte
+}
+
+// newReader reads and decrypts the header of f, and works out the size of the
+// plaintext from the size of f.
+func (fs *FileSystem) newReader(f filesystem.ReadSeeker) (*reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: short header", ErrInvalid)
+		}
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalid)
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(header[len(magic):]))
+	if chunkSize == 0 {
+		return nil, fmt.Errorf("%w: bad chunk size", ErrInvalid)
+	}
+	ad, rest := header[:len(magic)+4], header[len(magic)+4:]
+	n := fs.master.NonceSize()
+	dataKey, err := fs.master.Open(nil, rest[:n], rest[n:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key: %v", ErrInvalid, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	// Every object has at least one chunk, the last of which may be empty.
+	sealed := chunkSize + int64(aead.Overhead())
+	body := end - headerSize
+	chunks := (body + sealed - 1) / sealed
+	if chunks == 0 || body-(chunks-1)*sealed < int64(aead.Overhead()) {
+		return nil, fmt.Errorf("%w: truncated", ErrInvalid)
+	}
+	return &reader{
+		f:         f,
+		aead:      aead,
+		header:    header,
+		chunkSize: chunkSize,
+		size:      body - chunks*int64(aead.Overhead()),
+		chunks:    chunks,
+		i:         -1,
+		buf:       make([]byte, sealed),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	i := r.offset / r.chunkSize
+	if i != r.i {
+		if err := r.load(i); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, r.chunk[r.offset-i*r.chunkSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// load reads and decrypts the ith chunk.
+func (r *reader) load(i int64) error {
+	r.chunk, r.i = nil, -1
+	sealed := r.chunkSize + int64(r.aead.Overhead())
+	if _, err := r.f.Seek(headerSize+i*sealed, io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+	n, err := io.ReadFull(r.f, r.buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("read chunk %d: %w", i, err)
+	}
+	last := i == r.chunks-1
+	if !last && n < len(r.buf) {
+		return fmt.Errorf("%w: chunk %d is short", ErrInvalid, i)
+	}
+	chunk, err := r.aead.Open(r.buf[:0], chunkNonce(uint64(i), last), r.buf[:n], r.header)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %v", ErrInvalid, i, err)
+	}
+	r.chunk, r.i = chunk, i
+	return nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *reader) Close() error { return r.f.Close() }
@@ -0,0 +1,210 @@
+// Package fallback implements a kipp filesystem which falls back to reading
+// from another, for migrating between file systems while serving from both.
+package fallback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/migrate"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FileSystem writes objects to a primary file system, and reads them from it,
+// or from a read only secondary file system if the primary doesn't have them.
+//
+// FileSystem is a prometheus.Collector of how many reads were served by the
+// secondary, and so how far a migration has to go.
+type FileSystem struct {
+	primary, secondary filesystem.FileSystem
+	migrate            bool
+
+	reads, migrations *prometheus.CounterVec
+	log               *slog.Logger
+
+	mu sync.Mutex
+	// migrating are the names of objects being migrated.
+	migrating map[string]bool
+	wg        sync.WaitGroup
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem)
+
+// MigrateOnRead copies objects read from the secondary to the primary in the
+// background, so they're read from the primary from then on.
+func MigrateOnRead() Option {
+	return func(fs *FileSystem) { fs.migrate = true }
+}
+
+// Logger sets the logger failed migrations are logged to. The default is
+// slog.Default.
+func Logger(l *slog.Logger) Option {
+	return func(fs *FileSystem) { fs.log = l }
+}
+
+// New returns a FileSystem which falls back from primary to secondary.
+func New(primary, secondary filesystem.FileSystem, opts ...Option) *FileSystem {
+	fs := &FileSystem{
+		primary:   primary,
+		secondary: secondary,
+		reads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "filesystem_fallback",
+			Name:      "reads_total",
+			Help:      "Number of objects opened, by the file system they were read from.",
+		}, []string{"filesystem"}),
+		migrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "filesystem_fallback",
+			Name:      "migrations_total",
+			Help:      "Number of objects copied from the secondary to the primary file system.",
+		}, []string{"result"}),
+		migrating: make(map[string]bool),
+		log:       slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(fs)
+	}
+	return fs
+}
+
+// Create creates the named object in the primary file system.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	return fs.primary.Create(ctx, name, r)
+}
+
+// Open opens the named object from the primary file system, or the secondary
+// if the primary doesn't have it.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r, err := fs.primary.Open(ctx, name)
+	if !filesystem.IsNotExist(err) {
+		if err == nil {
+			fs.reads.WithLabelValues("primary").Inc()
+			read(ctx, "primary")
+		}
+		return r, err
+	}
+	if r, err = fs.secondary.Open(ctx, name); err != nil {
+		return nil, err
+	}
+	fs.reads.WithLabelValues("secondary").Inc()
+	read(ctx, "secondary")
+	if fs.migrate {
+		fs.migrateObject(ctx, name)
+	}
+	return r, nil
+}
+
+// read labels the span of ctx, if it's recorded, with which file system an
+// object was read from, so reads which missed the primary can be found.
+func read(ctx context.Context, from string) {
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.String("kipp.fallback.read", from))
+	}
+}
+
+// migrateObject copies the named object from the secondary to the primary
+// file system in the background, unless it's already being copied.
+func (fs *FileSystem) migrateObject(ctx context.Context, name string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.migrating[name] {
+		return
+	}
+	fs.migrating[name] = true
+	fs.wg.Add(1)
+	go func() {
+		defer fs.wg.Done()
+		defer func() {
+			fs.mu.Lock()
+			defer fs.mu.Unlock()
+			delete(fs.migrating, name)
+		}()
+		if err := migrate.Copy(xcontext.Detach(ctx), fs.secondary, fs.primary, name); err != nil {
+			fs.migrations.WithLabelValues("error").Inc()
+			fs.log.Error("migrate", "name", name, "error", err)
+			return
+		}
+		fs.migrations.WithLabelValues("ok").Inc()
+	}()
+}
+
+// Wait waits for objects being migrated to finish.
+func (fs *FileSystem) Wait() { fs.wg.Wait() }
+
+// Remove removes the named object from both file systems. It only returns an
+// error wrapping filesystem.ErrNotExist if neither had it. Objects removed
+// while they're being migrated may be left in the primary, as orphans.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	var (
+		removed bool
+		errs    []error
+	)
+	for _, f := range []filesystem.FileSystem{fs.primary, fs.secondary} {
+		switch err := f.Remove(ctx, name); {
+		case err == nil:
+			removed = true
+		case !filesystem.IsNotExist(err):
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if !removed {
+		return filesystem.NotExist("remove", name)
+	}
+	return nil
+}
+
+// Stat describes the named object in the primary file system, or the
+// secondary if the primary doesn't have it.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	fi, err := fs.primary.Stat(ctx, name)
+	if filesystem.IsNotExist(err) {
+		return fs.secondary.Stat(ctx, name)
+	}
+	return fi, err
+}
+
+// Ping pings both file systems which are a filesystem.Pinger, as objects
+// which haven't been migrated can't be read without the secondary.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	for _, v := range []struct {
+		name string
+		fs   filesystem.FileSystem
+	}{
+		{"primary", fs.primary},
+		{"secondary", fs.secondary},
+	} {
+		p, ok := v.fs.(filesystem.Pinger)
+		if !ok {
+			continue
+		}
+		if err := p.Ping(ctx); err != nil && !errors.Is(err, filesystem.ErrUnsupported) {
+			return fmt.Errorf("%s: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (fs *FileSystem) Describe(c chan<- *prometheus.Desc) {
+	fs.reads.Describe(c)
+	fs.migrations.Describe(c)
+}
+
+// Collect implements prometheus.Collector.
+func (fs *FileSystem) Collect(c chan<- prometheus.Metric) {
+	fs.reads.Collect(c)
+	fs.migrations.Collect(c)
+}
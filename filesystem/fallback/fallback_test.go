@@ -0,0 +1,112 @@
+package fallback_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/fallback"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestFileSystem(t *testing.T) {
+	var i interface{} = (*fallback.FileSystem)(nil)
+	if _, ok := i.(filesystem.FileSystem); !ok {
+		t.Fatal("fallback.FileSystem does not implement filesystem.FileSystem")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		return fallback.New(memory.New(), memory.New(), fallback.MigrateOnRead())
+	})
+}
+
+// read reads all of the named object.
+func read(t *testing.T, fs filesystem.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+
+	for _, migrate := range []bool{false, true} {
+		primary, secondary := memory.New(), memory.New()
+		var opts []fallback.Option
+		if migrate {
+			opts = append(opts, fallback.MigrateOnRead())
+		}
+		fs := fallback.New(primary, secondary, opts...)
+
+		if err := secondary.Create(ctx, "old", strings.NewReader("old content")); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := fs.Create(ctx, "new", strings.NewReader("new content")); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if _, err := secondary.Stat(ctx, "new"); !filesystem.IsNotExist(err) {
+			t.Fatalf("new object written to the secondary: %v", err)
+		}
+		if fi, err := fs.Stat(ctx, "old"); err != nil || fi.Size != 11 {
+			t.Fatalf("unexpected info; got %+v and %v, want size 11", fi, err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if got, want := read(t, fs, "old"), "old content"; got != want {
+				t.Fatalf("unexpected content; got %q, want %q", got, want)
+			}
+			fs.Wait()
+		}
+		if got, want := read(t, fs, "new"), "new content"; got != want {
+			t.Fatalf("unexpected content; got %q, want %q", got, want)
+		}
+
+		// Once migrated, the second read is served by the primary.
+		want := `
+# HELP kipp_filesystem_fallback_reads_total Number of objects opened, by the file system they were read from.
+# TYPE kipp_filesystem_fallback_reads_total counter
+kipp_filesystem_fallback_reads_total{filesystem="primary"} 1
+kipp_filesystem_fallback_reads_total{filesystem="secondary"} 2
+`
+		if migrate {
+			if _, err := primary.Stat(ctx, "old"); err != nil {
+				t.Fatalf("not migrated: %v", err)
+			}
+			want = `
+# HELP kipp_filesystem_fallback_migrations_total Number of objects copied from the secondary to the primary file system.
+# TYPE kipp_filesystem_fallback_migrations_total counter
+kipp_filesystem_fallback_migrations_total{result="ok"} 1
+# HELP kipp_filesystem_fallback_reads_total Number of objects opened, by the file system they were read from.
+# TYPE kipp_filesystem_fallback_reads_total counter
+kipp_filesystem_fallback_reads_total{filesystem="primary"} 2
+kipp_filesystem_fallback_reads_total{filesystem="secondary"} 1
+`
+		}
+		if err := testutil.CollectAndCompare(fs, strings.NewReader(want)); err != nil {
+			t.Fatalf("migrate=%t: %v", migrate, err)
+		}
+
+		if err := fs.Remove(ctx, "old"); err != nil {
+			t.Fatalf("remove: %v", err)
+		}
+		for _, f := range []filesystem.FileSystem{primary, secondary} {
+			if _, err := f.Stat(ctx, "old"); !filesystem.IsNotExist(err) {
+				t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+			}
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package filesystemtest implements conformance tests for filesystem.FileSystem
+// implementations.
+package filesystemtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// Run runs the conformance tests against file systems returned by open, which
+// is called once per subtest and must return a new, empty file system.
+func Run(t *testing.T, open func(t *testing.T) filesystem.FileSystem) {
+	t.Run("CreateOpen", func(t *testing.T) { testCreateOpen(t, open(t)) })
+	t.Run("CreateEmpty", func(t *testing.T) { testCreateEmpty(t, open(t)) })
+	t.Run("CreateFail", func(t *testing.T) { testCreateFail(t, open(t)) })
+	t.Run("OpenMissing", func(t *testing.T) { testOpenMissing(t, open(t)) })
+	t.Run("Seek", func(t *testing.T) { testSeek(t, open(t)) })
+	t.Run("Remove", func(t *testing.T) { testRemove(t, open(t)) })
+	t.Run("RemoveMissing", func(t *testing.T) { testRemoveMissing(t, open(t)) })
+	t.Run("Stat", func(t *testing.T) { testStat(t, open(t)) })
+	t.Run("StatMissing", func(t *testing.T) { testStatMissing(t, open(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, open(t)) })
+	t.Run("Walk", func(t *testing.T) { testWalk(t, open(t)) })
+	t.Run("Ping", func(t *testing.T) { testPing(t, open(t)) })
+}
+
+// create creates the named object with the given content.
+func create(t *testing.T, fs filesystem.FileSystem, name, content string) {
+	t.Helper()
+	if err := fs.Create(context.Background(), name, strings.NewReader(content)); err != nil {
+		t.Fatalf("create %s: %v", name, err)
+	}
+}
+
+// read reads all of the named object.
+func read(t *testing.T, fs filesystem.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(b)
+}
+
+// notExist fails the test unless the named object doesn't exist.
+func notExist(t *testing.T, fs filesystem.FileSystem, name string) {
+	t.Helper()
+	f, err := fs.Open(context.Background(), name)
+	if err == nil {
+		f.Close()
+	}
+	if !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error for %s; got %v, want %v", name, err, filesystem.ErrNotExist)
+	}
+}
+
+func testCreateOpen(t *testing.T, fs filesystem.FileSystem) {
+	create(t, fs, "a", "some content")
+	create(t, fs, "b", "some other content")
+	if got, want := read(t, fs, "a"), "some content"; got != want {
+		t.Fatalf("unexpected content; got %q, want %q", got, want)
+	}
+	if got, want := read(t, fs, "b"), "some other content"; got != want {
+		t.Fatalf("unexpected content; got %q, want %q", got, want)
+	}
+}
+
+func testCreateEmpty(t *testing.T, fs filesystem.FileSystem) {
+	create(t, fs, "empty", "")
+	if got := read(t, fs, "empty"); got != "" {
+		t.Fatalf("unexpected content; got %q, want none", got)
+	}
+}
+
+// testCreateFail checks objects aren't created when reading fails part way.
+func testCreateFail(t *testing.T, fs filesystem.FileSystem) {
+	r := filesystem.PipeReader(func(w io.Writer) error {
+		if _, err := io.WriteString(w, "partial"); err != nil {
+			return err
+		}
+		return errors.New("read failed")
+	})
+	if err := fs.Create(context.Background(), "failed", r); err == nil {
+		t.Fatal("expected error")
+	}
+	notExist(t, fs, "failed")
+}
+
+func testOpenMissing(t *testing.T, fs filesystem.FileSystem) { notExist(t, fs, "missing") }
+
+func testSeek(t *testing.T, fs filesystem.FileSystem) {
+	create(t, fs, "seek", "0123456789")
+	r, err := fs.Open(context.Background(), "seek")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	f, ok := r.(filesystem.ReadSeeker)
+	if !ok {
+		t.Skip("not a filesystem.ReadSeeker")
+	}
+
+	for _, tt := range []struct {
+		offset int64
+		whence int
+		pos    int64
+		want   string
+	}{
+		{offset: 3, whence: io.SeekStart, pos: 3, want: "34"},
+		{offset: 1, whence: io.SeekCurrent, pos: 6, want: "6"},
+		{offset: 0, whence: io.SeekCurrent, pos: 7, want: "7"},
+		{offset: -2, whence: io.SeekEnd, pos: 8, want: "89"},
+		{offset: 0, whence: io.SeekStart, pos: 0, want: "0123456789"},
+		{offset: 0, whence: io.SeekEnd, pos: 10},
+		{offset: 20, whence: io.SeekStart, pos: 20},
+	} {
+		pos, err := f.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.pos {
+			t.Fatalf("seek(%d, %d); got %d and %v, want %d", tt.offset, tt.whence, pos, err, tt.pos)
+		}
+		b := make([]byte, len(tt.want))
+		if _, err := io.ReadFull(f, b); err != nil || string(b) != tt.want {
+			t.Fatalf("read after seek(%d, %d); got %q and %v, want %q", tt.offset, tt.whence, b, err, tt.want)
+		}
+		if tt.want == "0123456789" || tt.want == "" {
+			if n, err := f.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Fatalf("read at end; got %d and %v, want 0 and EOF", n, err)
+			}
+		}
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("expected error seeking to a negative offset")
+	}
+}
+
+func testRemove(t *testing.T, fs filesystem.FileSystem) {
+	create(t, fs, "a", "some content")
+	create(t, fs, "b", "some other content")
+	if err := fs.Remove(context.Background(), "a"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	notExist(t, fs, "a")
+	if got, want := read(t, fs, "b"), "some other content"; got != want {
+		t.Fatalf("unexpected content; got %q, want %q", got, want)
+	}
+}
+
+// testRemoveMissing checks removing an object which doesn't exist, or no
+// longer does, is reported as such.
+func testRemoveMissing(t *testing.T, fs filesystem.FileSystem) {
+	if err := fs.Remove(context.Background(), "missing"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+	create(t, fs, "a", "some content")
+	if err := fs.Remove(context.Background(), "a"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := fs.Remove(context.Background(), "a"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error removing twice; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+}
+
+func testStat(t *testing.T, fs filesystem.FileSystem) {
+	create(t, fs, "a", "some content")
+	create(t, fs, "b", "some other content")
+	create(t, fs, "empty", "")
+	stat := func(name string) filesystem.FileInfo {
+		t.Helper()
+		fi, err := fs.Stat(context.Background(), name)
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		return fi
+	}
+	a, b := stat("a"), stat("b")
+	for _, tt := range []struct {
+		fi   filesystem.FileInfo
+		name string
+		size int64
+	}{
+		{fi: a, name: "a", size: 12},
+		{fi: b, name: "b", size: 18},
+		{fi: stat("empty"), name: "empty"},
+	} {
+		if tt.fi.Name != tt.name || tt.fi.Size != tt.size || tt.fi.ModTime.IsZero() {
+			t.Fatalf("unexpected info for %s; got %+v, want size %d and a mod time", tt.name, tt.fi, tt.size)
+		}
+	}
+	// ETags are optional, but must identify content when there are any.
+	if a.ETag != "" && (a.ETag == b.ETag || stat("a").ETag != a.ETag) {
+		t.Fatalf("unexpected etags; got %q for a and %q for b", a.ETag, b.ETag)
+	}
+}
+
+func testStatMissing(t *testing.T, fs filesystem.FileSystem) {
+	if _, err := fs.Stat(context.Background(), "missing"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+}
+
+func testConcurrent(t *testing.T, fs filesystem.FileSystem) {
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("concurrent-%d", i)
+			content := bytes.Repeat([]byte{byte('a' + i)}, 1<<10*(i+1))
+			if err := fs.Create(context.Background(), name, bytes.NewReader(content)); err != nil {
+				errs <- fmt.Errorf("create %s: %w", name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("concurrent-%d", i)
+		if got, want := read(t, fs, name), strings.Repeat(string(rune('a'+i)), 1<<10*(i+1)); got != want {
+			t.Fatalf("unexpected content for %s; got %d bytes, want %d", name, len(got), len(want))
+		}
+	}
+}
+
+func testWalk(t *testing.T, fs filesystem.FileSystem) {
+	w, ok := fs.(filesystem.Walker)
+	if !ok {
+		t.Skip("not a filesystem.Walker")
+	}
+	create(t, fs, "a", "some content")
+	create(t, fs, "b", "some other content")
+	create(t, fs, "empty", "")
+	if err := fs.Remove(context.Background(), "b"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	got := make(map[string]filesystem.FileInfo)
+	if err := w.Walk(context.Background(), func(fi filesystem.FileInfo) error {
+		if _, ok := got[fi.Name]; ok {
+			return fmt.Errorf("%s walked twice", fi.Name)
+		}
+		got[fi.Name] = fi
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if len(got) != 2 || got["a"].ModTime.IsZero() || got["a"].Size == 0 || got["empty"].Name != "empty" {
+		t.Fatalf("unexpected objects; got %+v, want a and empty", got)
+	}
+
+	// Errors stop the walk.
+	errStop := errors.New("stop")
+	var n int
+	if err := w.Walk(context.Background(), func(filesystem.FileInfo) error {
+		n++
+		return errStop
+	}); !errors.Is(err, errStop) || n != 1 {
+		t.Fatalf("unexpected walk; got %v after %d objects, want %v after 1", err, n, errStop)
+	}
+}
+
+func testPing(t *testing.T, fs filesystem.FileSystem) {
+	p, ok := fs.(filesystem.Pinger)
+	if !ok {
+		t.Skip("not a filesystem.Pinger")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Ping(ctx); err != nil && !errors.Is(err, filesystem.ErrUnsupported) {
+		t.Fatalf("ping: %v", err)
+	}
+}
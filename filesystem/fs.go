@@ -2,25 +2,131 @@ package filesystem
 
 import (
 	"context"
+	"errors"
 	"io"
+	"os"
+	"path"
+	"reflect"
+	"time"
 )
 
+// ErrNotExist is wrapped by errors from Open and Remove for objects which
+// don't exist. It's os.ErrNotExist, so errors.Is works with either.
+var ErrNotExist = os.ErrNotExist
+
+// ErrUnsupported is returned by file systems which wrap others when the
+// wrapped file system doesn't implement the optional interface a method
+// belongs to.
+var ErrUnsupported = errors.New("unsupported")
+
 // A FileSystem is a persistent store of objects uniquely identified by name.
 type FileSystem interface {
 	// Create creates an object with the specified name, and will read
 	// from r up to io.EOF. The reader is explicitly passed in to allow
 	// implementations to cleanup, and guarantee consistency.
 	Create(ctx context.Context, name string, r io.Reader) error
+	// Open opens the named object, returning an error wrapping
+	// ErrNotExist if it doesn't exist.
 	Open(ctx context.Context, name string) (Reader, error)
+	// Remove removes the named object, returning an error wrapping
+	// ErrNotExist if it doesn't exist. Callers which only need the object
+	// to be gone, such as those cleaning up after a failure, should ignore
+	// these with IsNotExist.
 	Remove(ctx context.Context, name string) error
+	// Stat describes the named object without reading it, returning an
+	// error wrapping ErrNotExist if it doesn't exist.
+	Stat(ctx context.Context, name string) (FileInfo, error)
+}
+
+// Name returns the name of the package implementing fs, such as "s3",
+// looking through file systems which wrap others and have an Unwrap method.
+func Name(fs FileSystem) string {
+	for {
+		u, ok := fs.(interface{ Unwrap() FileSystem })
+		if !ok {
+			break
+		}
+		fs = u.Unwrap()
+	}
+	t := reflect.TypeOf(fs)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return path.Base(t.PkgPath())
+}
+
+// FileInfo describes an object.
+type FileInfo struct {
+	Name string
+	// Size is the size of the object as it's read, which may differ from
+	// how much space it takes up in file systems which transform it.
+	Size    int64
+	ModTime time.Time
+	// ETag identifies the content of the object, such as a checksum, if the
+	// file system has one. It's opaque, and only comparable to other ETags
+	// from the same file system.
+	ETag string
+}
+
+// NotExist returns an error for the named object, which doesn't exist. op is
+// the operation which failed, such as "open" or "remove".
+func NotExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: ErrNotExist}
 }
 
-// A Reader is a readable, seekable and closable file stream.
+// IsNotExist reports whether err is from an object which doesn't exist.
+func IsNotExist(err error) bool { return errors.Is(err, ErrNotExist) }
+
+// A Walker lists the objects of a file system.
+type Walker interface {
+	// Walk calls fn with each object, in no particular order, stopping at
+	// the first error fn returns, which Walk returns. Objects created or
+	// removed during the walk may or may not be visited. Sizes are how
+	// much space objects take up, which may differ from their size as
+	// read in file systems which transform them.
+	Walk(ctx context.Context, fn func(fi FileInfo) error) error
+}
+
+// A Pinger checks a file system is available, such as that its volume is
+// mounted and writable or its bucket can be reached.
+type Pinger interface {
+	// Ping returns an error if the file system isn't available. It must be
+	// cheap, as it's called by health checks, and return by the time ctx
+	// is done.
+	Ping(ctx context.Context) error
+}
+
+// A Reader is a readable and closable file stream. Most are a ReadSeeker, but
+// those of file systems which can only stream objects from the start, such as
+// pipes and some object store SDKs, needn't be. Callers which need to seek
+// should check, and fall back to reading from the start or fail.
 type Reader interface {
-	io.ReadSeeker
+	io.Reader
 	io.Closer
 }
 
+// A ReadSeeker is a Reader which can seek, so objects can be read from any
+// offset, such as to serve ranges of them.
+type ReadSeeker interface {
+	Reader
+	io.Seeker
+}
+
+// A Sysfiler is a ReadSeeker backed by an *os.File. Readers which are themselves
+// an *os.File need not implement it. Callers may use the file directly to
+// avoid copying through userspace, so its offset must match the reader's.
+type Sysfiler interface {
+	ReadSeeker
+	Sysfile() *os.File
+}
+
+// A ContentAddresser is a Reader of an object which can also be fetched over
+// IPFS, by its CID.
+type ContentAddresser interface {
+	Reader
+	CID() string
+}
+
 // PipeReader pipes r to f(w).
 func PipeReader(f func(w io.Writer) error) io.Reader {
 	pr, pw := io.Pipe()
@@ -0,0 +1,190 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/uhthomas/kipp/filesystem"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// chunkSize is the default size of the chunks objects are uploaded in. Each
+// upload buffers a chunk, and objects smaller than a chunk are uploaded in a
+// single request.
+const chunkSize = 16 << 20
+
+// FileSystem is an abstraction over a Google Cloud Storage bucket which allows
+// for the creation, opening and removal of objects.
+type FileSystem struct {
+	client     *storage.Client
+	bucket     *storage.BucketHandle
+	prefix     string
+	chunkSize  int
+	clientOpts []option.ClientOption
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Prefix stores objects under prefix, so a bucket can be shared. A trailing
+// slash is added if it's missing.
+func Prefix(prefix string) Option {
+	return func(fs *FileSystem) error {
+		if prefix = strings.TrimPrefix(prefix, "/"); prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		fs.prefix = prefix
+		return nil
+	}
+}
+
+// ChunkSize sets the size of the chunks objects are uploaded in, which is
+// rounded up to a multiple of 256KiB. Larger chunks make fewer requests for
+// large objects, but each upload buffers a chunk in memory. A size of zero
+// uploads objects in a single request, which can't be retried.
+func ChunkSize(n int) Option {
+	return func(fs *FileSystem) error {
+		if n < 0 {
+			return fmt.Errorf("invalid chunk size %d", n)
+		}
+		fs.chunkSize = n
+		return nil
+	}
+}
+
+// CredentialsFile authenticates with the service account key in the named
+// JSON file, rather than Application Default Credentials.
+func CredentialsFile(name string) Option {
+	return func(fs *FileSystem) error {
+		fs.clientOpts = append(fs.clientOpts, option.WithCredentialsFile(name))
+		return nil
+	}
+}
+
+// Client uses c rather than creating a client, such as one for an emulator.
+func Client(c *storage.Client) Option {
+	return func(fs *FileSystem) error {
+		fs.client = c
+		return nil
+	}
+}
+
+// New creates a FileSystem for the named bucket. Unless an option says
+// otherwise, the client authenticates with Application Default Credentials.
+func New(ctx context.Context, bucket string, opts ...Option) (*FileSystem, error) {
+	if bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	fs := &FileSystem{chunkSize: chunkSize}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	if fs.client == nil {
+		c, err := storage.NewClient(ctx, fs.clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("new client: %w", err)
+		}
+		fs.client = c
+	}
+	fs.bucket = fs.client.Bucket(bucket)
+	return fs, nil
+}
+
+// Create writes r to the named object. The object is only created if all of r
+// is uploaded; failed or cancelled uploads leave nothing behind.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	// Cancelling the writer's context aborts the upload, so the writer
+	// mustn't be closed if copying fails, as that would finish it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := fs.object(name).NewWriter(ctx)
+	w.ChunkSize = fs.chunkSize
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	return nil
+}
+
+// Open opens the named object. The reader supports seeking, which is done
+// with ranged reads.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r, err := newReader(ctx, fs.object(name))
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, filesystem.NotExist("open", name)
+	}
+	return r, err
+}
+
+// Remove removes the named object.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	if err := fs.object(name).Delete(ctx); err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return filesystem.NotExist("remove", name)
+		}
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// Stat describes the named object from its attributes.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	attrs, err := fs.object(name).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+		}
+		return filesystem.FileInfo{}, fmt.Errorf("attrs: %w", err)
+	}
+	return filesystem.FileInfo{Name: name, Size: attrs.Size, ModTime: attrs.Updated, ETag: attrs.Etag}, nil
+}
+
+// Ping checks the bucket can be reached by getting its attributes.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	if _, err := fs.bucket.Attrs(ctx); err != nil {
+		return fmt.Errorf("bucket attrs: %w", err)
+	}
+	return nil
+}
+
+// Walk calls fn with each object under the prefix. Objects with further
+// slashes in their names are skipped, as they aren't objects of the file
+// system.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	it := fs.bucket.Objects(ctx, &storage.Query{Prefix: fs.prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		// Prefixes of objects with further slashes are listed too.
+		if attrs.Prefix != "" {
+			continue
+		}
+		if err := fn(filesystem.FileInfo{
+			Name:    strings.TrimPrefix(attrs.Name, fs.prefix),
+			Size:    attrs.Size,
+			ModTime: attrs.Updated,
+			ETag:    attrs.Etag,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// object returns a handle for the named object.
+func (fs *FileSystem) object(name string) *storage.ObjectHandle {
+	return fs.bucket.Object(fs.prefix + name)
+}
@@ -0,0 +1,137 @@
+package gcs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/gcs"
+)
+
+// open returns a FileSystem for a bucket in a fake GCS server.
+func open(t *testing.T, opts ...gcs.Option) (*gcs.FileSystem, *fakestorage.Server) {
+	s, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	s.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "kipp"})
+	fs, err := gcs.New(context.Background(), "kipp", append(opts, gcs.Client(s.Client()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, s
+}
+
+func TestFileSystem(t *testing.T) {
+	var i interface{} = (*gcs.FileSystem)(nil)
+	if _, ok := i.(filesystem.FileSystem); !ok {
+		t.Fatal("gcs.FileSystem does not implement fs.FileSystem")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, _ := open(t)
+		return fs
+	})
+}
+
+func TestCreateOpen(t *testing.T) {
+	ctx := context.Background()
+	fs, s := open(t, gcs.Prefix("files"))
+
+	want := []byte("some content")
+	if err := fs.Create(ctx, "a", bytes.NewReader(want)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := s.GetObject("kipp", "files/a"); err != nil {
+		t.Fatalf("object should be stored under the prefix: %v", err)
+	}
+
+	f, err := fs.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("read all; got %q and %v, want %q", got, err, want)
+	}
+
+	// Ranged reads.
+	if n, err := f.(io.Seeker).Seek(-7, io.SeekEnd); err != nil || n != 5 {
+		t.Fatalf("seek end; got %d and %v, want 5", n, err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(f, b); err != nil || string(b) != "cont" {
+		t.Fatalf("read; got %q and %v, want %q", b, err, "cont")
+	}
+	if n, err := f.(io.Seeker).Seek(1, io.SeekCurrent); err != nil || n != 10 {
+		t.Fatalf("seek current; got %d and %v, want 10", n, err)
+	}
+	if got, err := io.ReadAll(f); err != nil || string(got) != "nt" {
+		t.Fatalf("read all; got %q and %v, want %q", got, err, "nt")
+	}
+
+	if err := fs.Remove(ctx, "a"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := fs.Open(ctx, "a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrNotExist)
+	}
+	if err := fs.Remove(ctx, "a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+// TestCreateChunked checks objects larger than a chunk are uploaded whole.
+func TestCreateChunked(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := open(t, gcs.ChunkSize(256<<10))
+
+	want := bytes.Repeat([]byte("kipp"), 200<<10)
+	if err := fs.Create(ctx, "large", bytes.NewReader(want)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f, err := fs.Open(ctx, "large")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("read all; got %d bytes and %v, want %d bytes", len(got), err, len(want))
+	}
+}
+
+// TestCreateFail checks objects aren't created when reading fails.
+func TestCreateFail(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := open(t)
+
+	errRead := errors.New("read failed")
+	r := filesystem.PipeReader(func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errRead
+	})
+	if err := fs.Create(ctx, "failed", r); !errors.Is(err, errRead) {
+		t.Fatalf("unexpected error; got %v, want %v", err, errRead)
+	}
+	if _, err := fs.Open(ctx, "failed"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	if _, err := gcs.New(context.Background(), ""); err == nil {
+		t.Fatal("expected error for missing bucket")
+	}
+	if _, err := gcs.New(context.Background(), "kipp", gcs.ChunkSize(-1)); err == nil {
+		t.Fatal("expected error for negative chunk size")
+	}
+}
@@ -0,0 +1,82 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// reader reads an object, opening a ranged reader from its offset whenever
+// it's read after seeking.
+type reader struct {
+	ctx          context.Context
+	obj          *storage.ObjectHandle
+	r            *storage.Reader
+	offset, size int64
+}
+
+// newReader opens obj, so its size is known and errors for missing objects
+// are returned up front.
+func newReader(ctx context.Context, obj *storage.ObjectHandle) (*reader, error) {
+	r := &reader{ctx: ctx, obj: obj}
+	if err := r.reset(); err != nil {
+		return nil, err
+	}
+	r.size = r.r.Attrs.Size
+	return r, nil
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.r == nil {
+		if err := r.reset(); err != nil {
+			return 0, fmt.Errorf("reset: %w", err)
+		}
+	}
+	n, err = r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.r, r.offset = nil, offset
+	}
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if r.r == nil {
+		return nil
+	}
+	return r.r.Close()
+}
+
+// reset opens a reader from the current offset.
+func (r *reader) reset() error {
+	r.Close()
+	rr, err := r.obj.NewRangeReader(r.ctx, r.offset, -1)
+	if err != nil {
+		return fmt.Errorf("new range reader: %w", err)
+	}
+	r.r = rr
+	return nil
+}
@@ -0,0 +1,341 @@
+// Package instrument records Prometheus metrics, and optionally OpenTelemetry
+// spans, for calls to a filesystem.FileSystem.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/filesystem"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer spans are recorded with.
+const tracerName = "github.com/uhthomas/kipp/filesystem/instrument"
+
+type metrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	read     *prometheus.CounterVec
+	written  *prometheus.CounterVec
+}
+
+// newMetrics registers the metrics with r, or reuses those already
+// registered by another FileSystem.
+func newMetrics(r prometheus.Registerer) (*metrics, error) {
+	labels := []string{"backend", "method"}
+	duration, err := register(r, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kipp",
+		Subsystem: "filesystem",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of file system calls, not counting reading opened objects.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	errs, err := register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kipp",
+		Subsystem: "filesystem",
+		Name:      "errors_total",
+		Help:      "Number of failed file system calls and reads, not counting those of objects which don't exist.",
+	}, labels))
+	if err != nil {
+		return nil, err
+	}
+	read, err := register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kipp",
+		Subsystem: "filesystem",
+		Name:      "read_bytes_total",
+		Help:      "Number of bytes read from opened objects, not counting those sent from the underlying file.",
+	}, []string{"backend"}))
+	if err != nil {
+		return nil, err
+	}
+	written, err := register(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kipp",
+		Subsystem: "filesystem",
+		Name:      "written_bytes_total",
+		Help:      "Number of bytes read by file systems creating objects.",
+	}, []string{"backend"}))
+	if err != nil {
+		return nil, err
+	}
+	return &metrics{
+		duration: duration.(*prometheus.HistogramVec),
+		errors:   errs.(*prometheus.CounterVec),
+		read:     read.(*prometheus.CounterVec),
+		written:  written.(*prometheus.CounterVec),
+	}, nil
+}
+
+// register registers c with r, returning the existing collector if an
+// equivalent one is already registered.
+func register(r prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	return c, nil
+}
+
+// FileSystem wraps a filesystem.FileSystem, recording the duration and errors
+// of each call, labelled by backend and method, how many bytes are read and
+// written, and a span of each call if it's traced. Errors are passed through
+// as they are, and contexts too, but for their spans.
+//
+// Wrapping file systems which wrap others, such as a cache and its origin,
+// with their own backend labels distinguishes what's served by each.
+//
+// FileSystem implements filesystem.Walker and filesystem.Pinger, returning
+// filesystem.ErrUnsupported if the wrapped file system doesn't, so
+// capabilities must be checked before wrapping. Readers keep whether they
+// can seek, are backed by a file or have a CID.
+type FileSystem struct {
+	fs      filesystem.FileSystem
+	backend string
+	m       *metrics
+	tracer  trace.Tracer
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem)
+
+// Tracer records a span for each call with a tracer of tp, as a child of the
+// span of its context. Reads of opened objects aren't traced. Calls aren't
+// traced if tp is nil.
+func Tracer(tp trace.TracerProvider) Option {
+	return func(fs *FileSystem) {
+		if tp != nil {
+			fs.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// New wraps fs, registering its metrics with r, or recording none if r is
+// nil, such as when calls are only traced. The backend label is
+// filesystem.Name(fs) if backend is empty.
+func New(fs filesystem.FileSystem, r prometheus.Registerer, backend string, opts ...Option) (*FileSystem, error) {
+	f := &FileSystem{fs: fs, backend: backend}
+	if r != nil {
+		m, err := newMetrics(r)
+		if err != nil {
+			return nil, err
+		}
+		f.m = m
+	}
+	if f.backend == "" {
+		f.backend = filesystem.Name(fs)
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f, nil
+}
+
+// Unwrap returns the wrapped file system.
+func (fs *FileSystem) Unwrap() filesystem.FileSystem { return fs.fs }
+
+// observe calls f, recording metrics and a span for the named method. f is
+// called with the context of the span, so those of the wrapped file system
+// are its children. The span is labelled with the name of the object if it's
+// not empty, and with the size of what f reads from r if it's not nil.
+func (fs *FileSystem) observe(ctx context.Context, method, name string, r *countingReader, f func(ctx context.Context) error) error {
+	var span trace.Span
+	if fs.tracer != nil {
+		ctx, span = fs.tracer.Start(ctx, "filesystem."+method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("kipp.filesystem.backend", fs.backend)),
+		)
+		if name != "" {
+			span.SetAttributes(attribute.String("kipp.name", name))
+		}
+		defer span.End()
+	}
+	start := time.Now()
+	err := f(ctx)
+	if span != nil {
+		if r != nil {
+			span.SetAttributes(attribute.Int64("kipp.size", r.n))
+		}
+		if failed(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	if fs.m != nil {
+		fs.m.duration.WithLabelValues(fs.backend, method).Observe(time.Since(start).Seconds())
+	}
+	fs.fail(method, err)
+	return err
+}
+
+// failed reports whether err is a failure, rather than nil or from an object
+// which doesn't exist or an unsupported call.
+func failed(err error) bool {
+	return err != nil && !filesystem.IsNotExist(err) && !errors.Is(err, filesystem.ErrUnsupported)
+}
+
+// fail counts err for the named method if it's a failure.
+func (fs *FileSystem) fail(method string, err error) {
+	if fs.m != nil && failed(err) {
+		fs.m.errors.WithLabelValues(fs.backend, method).Inc()
+	}
+}
+
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	cr := &countingReader{r: r}
+	if fs.m != nil {
+		cr.c = fs.m.written.WithLabelValues(fs.backend)
+	}
+	return fs.observe(ctx, "create", name, cr, func(ctx context.Context) error { return fs.fs.Create(ctx, name, cr) })
+}
+
+func (fs *FileSystem) Open(ctx context.Context, name string) (r filesystem.Reader, err error) {
+	err = fs.observe(ctx, "open", name, nil, func(ctx context.Context) error {
+		r, err = fs.fs.Open(ctx, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrap(r), nil
+}
+
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	return fs.observe(ctx, "remove", name, nil, func(ctx context.Context) error { return fs.fs.Remove(ctx, name) })
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (fi filesystem.FileInfo, err error) {
+	err = fs.observe(ctx, "stat", name, nil, func(ctx context.Context) error {
+		fi, err = fs.fs.Stat(ctx, name)
+		return err
+	})
+	return fi, err
+}
+
+// Walk walks the wrapped file system. Its duration includes the calls to fn.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	return fs.observe(ctx, "walk", "", nil, func(ctx context.Context) error {
+		w, ok := fs.fs.(filesystem.Walker)
+		if !ok {
+			return filesystem.ErrUnsupported
+		}
+		return w.Walk(ctx, fn)
+	})
+}
+
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	return fs.observe(ctx, "ping", "", nil, func(ctx context.Context) error {
+		p, ok := fs.fs.(filesystem.Pinger)
+		if !ok {
+			return filesystem.ErrUnsupported
+		}
+		return p.Ping(ctx)
+	})
+}
+
+// wrap wraps r to count what's read from it, keeping the optional interfaces
+// it implements.
+func (fs *FileSystem) wrap(r filesystem.Reader) filesystem.Reader {
+	cr := &reader{
+		countingReader: countingReader{r: r},
+		closer:         r,
+		fail:           func(err error) { fs.fail("read", err) },
+	}
+	if fs.m != nil {
+		cr.c = fs.m.read.WithLabelValues(fs.backend)
+	}
+	switch r := r.(type) {
+	case *os.File:
+		return &sysfileReader{readSeeker{cr, r}, r}
+	case filesystem.Sysfiler:
+		return &sysfileReader{readSeeker{cr, r}, r.Sysfile()}
+	}
+	s, seekable := r.(io.Seeker)
+	ca, addressed := r.(filesystem.ContentAddresser)
+	switch {
+	case seekable && addressed:
+		return &addressedReadSeeker{readSeeker{cr, s}, ca.CID()}
+	case seekable:
+		return &readSeeker{cr, s}
+	case addressed:
+		return &addressedReader{cr, ca.CID()}
+	}
+	return cr
+}
+
+// countingReader counts what's read from r, adding it to c if it's not nil.
+type countingReader struct {
+	r io.Reader
+	c prometheus.Counter
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.c != nil {
+		r.c.Add(float64(n))
+	}
+	return n, err
+}
+
+// reader counts what's read from an opened object, and its errors.
+type reader struct {
+	countingReader
+	closer io.Closer
+	fail   func(err error)
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.countingReader.Read(p)
+	if err != io.EOF {
+		r.fail(err)
+	}
+	return n, err
+}
+
+func (r *reader) Close() error { return r.closer.Close() }
+
+type readSeeker struct {
+	*reader
+	s io.Seeker
+}
+
+func (r *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.s.Seek(offset, whence)
+}
+
+// sysfileReader is a reader backed by a file. What's sent from the file
+// directly, such as with sendfile, isn't counted.
+type sysfileReader struct {
+	readSeeker
+	f *os.File
+}
+
+func (r *sysfileReader) Sysfile() *os.File { return r.f }
+
+type addressedReader struct {
+	*reader
+	cid string
+}
+
+func (r *addressedReader) CID() string { return r.cid }
+
+type addressedReadSeeker struct {
+	readSeeker
+	cid string
+}
+
+func (r *addressedReadSeeker) CID() string { return r.cid }
@@ -0,0 +1,134 @@
+package instrument_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/instrument"
+	"github.com/uhthomas/kipp/filesystem/local"
+	"github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, err := instrument.New(memory.New(), prometheus.NewRegistry(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+func TestFileSystem(t *testing.T) {
+	ctx := context.Background()
+
+	errFake := errors.New("fake")
+	r := prometheus.NewRegistry()
+	fs, err := instrument.New(memory.New(memory.FailCreate(1, errFake)), r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another file system shares the metrics, with its own label.
+	cache, err := instrument.New(memory.New(), r, "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Create(ctx, "a", strings.NewReader("abc")); !errors.Is(err, errFake) {
+		t.Fatalf("unexpected error; got %v, want %v", err, errFake)
+	}
+	if err := fs.Create(ctx, "a", strings.NewReader("abcd")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := fs.Stat(ctx, "missing"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+	f, err := fs.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if b, err := io.ReadAll(f); err != nil || string(b) != "abcd" {
+		t.Fatalf("unexpected content; got %q and %v", b, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := cache.Create(ctx, "a", strings.NewReader("ab")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// What's read by failed creates is counted too.
+	want := `
+# HELP kipp_filesystem_errors_total Number of failed file system calls and reads, not counting those of objects which don't exist.
+# TYPE kipp_filesystem_errors_total counter
+kipp_filesystem_errors_total{backend="memory",method="create"} 1
+# HELP kipp_filesystem_read_bytes_total Number of bytes read from opened objects, not counting those sent from the underlying file.
+# TYPE kipp_filesystem_read_bytes_total counter
+kipp_filesystem_read_bytes_total{backend="memory"} 4
+# HELP kipp_filesystem_written_bytes_total Number of bytes read by file systems creating objects.
+# TYPE kipp_filesystem_written_bytes_total counter
+kipp_filesystem_written_bytes_total{backend="cache"} 2
+kipp_filesystem_written_bytes_total{backend="memory"} 5
+`
+	if err := testutil.GatherAndCompare(r, strings.NewReader(want),
+		"kipp_filesystem_errors_total",
+		"kipp_filesystem_read_bytes_total",
+		"kipp_filesystem_written_bytes_total",
+	); err != nil {
+		t.Fatal(err)
+	}
+	// Creates, stats and opens of memory, and creates of cache.
+	if n, err := testutil.GatherAndCount(r, "kipp_filesystem_request_duration_seconds"); err != nil || n != 4 {
+		t.Fatalf("unexpected durations; got %d series and %v, want 4", n, err)
+	}
+}
+
+// TestReaders checks readers keep the optional interfaces of those they wrap.
+func TestReaders(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		fs       filesystem.FileSystem
+		seekable bool
+		sysfile  bool
+	}{
+		{"memory", memory.New(), true, false},
+		{"stream", memory.New(memory.Stream()), false, false},
+		{"local", l, true, true},
+	} {
+		fs, err := instrument.New(tt.fs, prometheus.NewRegistry(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Create(ctx, "a", strings.NewReader("abc")); err != nil {
+			t.Fatalf("%s: create: %v", tt.name, err)
+		}
+		f, err := fs.Open(ctx, "a")
+		if err != nil {
+			t.Fatalf("%s: open: %v", tt.name, err)
+		}
+		defer f.Close()
+		if _, ok := f.(io.Seeker); ok != tt.seekable {
+			t.Fatalf("%s: unexpected reader; got %T, want seekable=%t", tt.name, f, tt.seekable)
+		}
+		sf, ok := f.(filesystem.Sysfiler)
+		if ok != tt.sysfile {
+			t.Fatalf("%s: unexpected reader; got %T, want sysfile=%t", tt.name, f, tt.sysfile)
+		}
+		if ok && sf.Sysfile() == nil {
+			t.Fatalf("%s: no sysfile", tt.name)
+		}
+	}
+}
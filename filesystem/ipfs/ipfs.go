@@ -0,0 +1,337 @@
+// Package ipfs implements a kipp filesystem on an IPFS node, so objects can be
+// fetched from any IPFS gateway as well as from kipp.
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// ErrUnavailable is wrapped by errors from requests which couldn't reach the
+// node.
+var ErrUnavailable = errors.New("ipfs node unavailable")
+
+// FileSystem adds objects to an IPFS node through its RPC API, and pins them.
+// The CID of each object is recorded in the node's mutable file system (MFS),
+// as a file in a directory named by the object.
+//
+// The node must be kubo 0.30 or later, which records modification times.
+type FileSystem struct {
+	api    string
+	dir    string
+	client *http.Client
+	log    *slog.Logger
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Dir sets the MFS directory objects are recorded in. The default is /kipp.
+func Dir(dir string) Option {
+	return func(fs *FileSystem) error {
+		if !strings.HasPrefix(dir, "/") {
+			return fmt.Errorf("invalid dir %q: must be absolute", dir)
+		}
+		fs.dir = dir
+		return nil
+	}
+}
+
+// Client uses c to make requests, rather than http.DefaultClient.
+func Client(c *http.Client) Option {
+	return func(fs *FileSystem) error {
+		fs.client = c
+		return nil
+	}
+}
+
+// Logger sets the logger failures to unpin objects are logged to. The default
+// is slog.Default.
+func Logger(l *slog.Logger) Option {
+	return func(fs *FileSystem) error {
+		fs.log = l
+		return nil
+	}
+}
+
+// New creates a FileSystem using the RPC API at api, such as
+// http://127.0.0.1:5001, and makes the MFS directory objects are recorded in.
+func New(ctx context.Context, api string, opts ...Option) (*FileSystem, error) {
+	fs := &FileSystem{
+		api:    strings.TrimSuffix(api, "/"),
+		dir:    "/kipp",
+		client: http.DefaultClient,
+		log:    slog.Default(),
+	}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	if err := fs.call(ctx, "files/mkdir", url.Values{
+		"arg":     {fs.dir},
+		"parents": {"true"},
+	}, nil); err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+	return fs, nil
+}
+
+// Create adds and pins r, and records its CID as the named object, unpinning
+// whatever it replaced.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	werr := make(chan error, 1)
+	go func() {
+		fw, err := mw.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(fw, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+		werr <- err
+	}()
+
+	var added struct{ Hash string }
+	err := fs.decode(ctx, "add", url.Values{
+		"pin":         {"true"},
+		"cid-version": {"1"},
+		"mtime":       {strconv.FormatInt(time.Now().Unix(), 10)},
+	}, &body{r: pr, contentType: mw.FormDataContentType()}, &added)
+	// Closing the reader unblocks the writer if the request failed early.
+	pr.Close()
+	if rerr := <-werr; err != nil {
+		// Failing to read r fails the request, but isn't the node's
+		// fault.
+		if rerr != nil && !errors.Is(rerr, io.ErrClosedPipe) {
+			return fmt.Errorf("read: %w", rerr)
+		}
+		return fmt.Errorf("add: %w", err)
+	}
+
+	p := fs.path(name)
+	old, err := fs.stat(ctx, p)
+	if err != nil && !isNotExist(err) {
+		fs.unpin(ctx, added.Hash)
+		return fmt.Errorf("stat: %w", err)
+	}
+	if err == nil {
+		if err := fs.call(ctx, "files/rm", url.Values{"arg": {p}, "force": {"true"}}, nil); err != nil && !isNotExist(err) {
+			fs.unpin(ctx, added.Hash)
+			return fmt.Errorf("rm: %w", err)
+		}
+	}
+	if err := fs.call(ctx, "files/cp", url.Values{"arg": {"/ipfs/" + added.Hash, p}}, nil); err != nil {
+		fs.unpin(ctx, added.Hash)
+		return fmt.Errorf("cp: %w", err)
+	}
+	if old.Hash != "" && old.Hash != added.Hash {
+		fs.unpin(ctx, old.Hash)
+	}
+	return nil
+}
+
+// Open opens the named object, which is read with cat from the offset it's
+// seeked to.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	st, err := fs.stat(ctx, fs.path(name))
+	if err != nil {
+		if isNotExist(err) {
+			return nil, filesystem.NotExist("open", name)
+		}
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	return &reader{ctx: ctx, fs: fs, cid: st.Hash, size: st.Size}, nil
+}
+
+// Remove removes the named object, and unpins it. Its blocks stay on the node
+// until it's garbage collected.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	p := fs.path(name)
+	st, err := fs.stat(ctx, p)
+	if err != nil {
+		if isNotExist(err) {
+			return filesystem.NotExist("remove", name)
+		}
+		return fmt.Errorf("stat: %w", err)
+	}
+	if err := fs.call(ctx, "files/rm", url.Values{"arg": {p}, "force": {"true"}}, nil); err != nil {
+		if isNotExist(err) {
+			return filesystem.NotExist("remove", name)
+		}
+		return fmt.Errorf("rm: %w", err)
+	}
+	fs.unpin(ctx, st.Hash)
+	return nil
+}
+
+// Stat describes the named object. Its ETag is its CID.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	st, err := fs.stat(ctx, fs.path(name))
+	if err != nil {
+		if isNotExist(err) {
+			return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+		}
+		return filesystem.FileInfo{}, fmt.Errorf("stat: %w", err)
+	}
+	return filesystem.FileInfo{
+		Name:    name,
+		Size:    st.Size,
+		ModTime: time.Unix(st.Mtime, st.MtimeNsecs),
+		ETag:    st.Hash,
+	}, nil
+}
+
+// Ping checks the node is reachable by statting the MFS directory.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	if _, err := fs.stat(ctx, fs.dir); err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+	return nil
+}
+
+// path returns the MFS path of the named object.
+func (fs *FileSystem) path(name string) string { return path.Join(fs.dir, name) }
+
+// fileStat is the response of files/stat.
+type fileStat struct {
+	Hash       string
+	Size       int64
+	Mtime      int64
+	MtimeNsecs int64
+}
+
+// stat describes the MFS path p.
+func (fs *FileSystem) stat(ctx context.Context, p string) (fileStat, error) {
+	var st fileStat
+	if err := fs.decode(ctx, "files/stat", url.Values{"arg": {p}}, nil, &st); err != nil {
+		return fileStat{}, err
+	}
+	return st, nil
+}
+
+// unpin unpins cid. Objects which fail to be unpinned are left on the node,
+// so failures are only logged.
+func (fs *FileSystem) unpin(ctx context.Context, cid string) {
+	err := fs.call(ctx, "pin/rm", url.Values{"arg": {cid}}, nil)
+	var apiErr *apiError
+	if err != nil && !(errors.As(err, &apiErr) && strings.Contains(apiErr.Message, "not pinned")) {
+		fs.log.WarnContext(ctx, "unpin", "cid", cid, "error", err)
+	}
+}
+
+// body is a request body and its content type.
+type body struct {
+	r           io.Reader
+	contentType string
+}
+
+// do calls the RPC API's command cmd, returning the response if it succeeded.
+func (fs *FileSystem) do(ctx context.Context, cmd string, args url.Values, b *body) (*http.Response, error) {
+	var (
+		r     io.Reader
+		ctype string
+	)
+	if b != nil {
+		r, ctype = b.r, b.contentType
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fs.api+"/api/v0/"+cmd+"?"+args.Encode(), r)
+	if err != nil {
+		return nil, err
+	}
+	if ctype != "" {
+		req.Header.Set("Content-Type", ctype)
+	}
+	res, err := fs.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		apiErr := &apiError{Code: res.StatusCode}
+		if err := json.NewDecoder(res.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(res.StatusCode)
+		}
+		return nil, apiErr
+	}
+	return res, nil
+}
+
+// call calls cmd, discarding its response.
+func (fs *FileSystem) call(ctx context.Context, cmd string, args url.Values, b *body) error {
+	res, err := fs.do(ctx, cmd, args, b)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(io.Discard, res.Body)
+	return err
+}
+
+// decode calls cmd, decoding the last JSON value of its response into v, as
+// some commands stream their progress.
+func (fs *FileSystem) decode(ctx context.Context, cmd string, args url.Values, b *body, v any) error {
+	res, err := fs.do(ctx, cmd, args, b)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	dec := json.NewDecoder(res.Body)
+	for n := 0; ; n++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			if n == 0 {
+				return errors.New("empty response")
+			}
+			return streamError(res)
+		} else if err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+	}
+}
+
+// streamError returns the error the node reported after it started
+// responding, if any. The body must have been read to io.EOF.
+func streamError(res *http.Response) error {
+	if msg := res.Trailer.Get("X-Stream-Error"); msg != "" {
+		return &apiError{Code: res.StatusCode, Message: msg}
+	}
+	return nil
+}
+
+// apiError is an error returned by the RPC API.
+type apiError struct {
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string { return fmt.Sprintf("ipfs: %s (%d)", e.Message, e.Code) }
+
+// isNotExist reports whether err is from an MFS path which doesn't exist.
+func isNotExist(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && (strings.Contains(apiErr.Message, "does not exist") ||
+		strings.Contains(apiErr.Message, "no link named"))
+}
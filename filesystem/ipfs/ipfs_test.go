@@ -0,0 +1,228 @@
+package ipfs_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/ipfs"
+)
+
+func TestFileSystem(t *testing.T) {
+	var i interface{} = (*ipfs.FileSystem)(nil)
+	if _, ok := i.(filesystem.FileSystem); !ok {
+		t.Fatal("ipfs.FileSystem does not implement fs.FileSystem")
+	}
+}
+
+// node is a fake of the parts of kubo's RPC API used by the file system.
+type node struct {
+	mu     sync.Mutex
+	blocks map[string]block
+	pins   map[string]bool
+	// files maps MFS paths to CIDs, and dirs records MFS directories.
+	files map[string]string
+	dirs  map[string]bool
+}
+
+type block struct {
+	b     []byte
+	mtime int64
+}
+
+func newNode(t *testing.T) (*node, string) {
+	n := &node{
+		blocks: make(map[string]block),
+		pins:   make(map[string]bool),
+		files:  make(map[string]string),
+		dirs:   make(map[string]bool),
+	}
+	s := httptest.NewServer(n)
+	t.Cleanup(s.Close)
+	return n, s.URL
+}
+
+func (n *node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]any{"Message": msg, "Code": 0, "Type": "error"})
+	}
+	q := r.URL.Query()
+	if r.URL.Path == "/api/v0/add" {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		b, err := io.ReadAll(f)
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		mtime, _ := strconv.ParseInt(q.Get("mtime"), 10, 64)
+		sum := sha256.Sum256(fmt.Appendf(b, "%d", mtime))
+		cid := "bafy" + hex.EncodeToString(sum[:])
+		n.mu.Lock()
+		n.blocks[cid] = block{b: b, mtime: mtime}
+		n.pins[cid] = true
+		n.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"Name": cid, "Hash": cid, "Size": strconv.Itoa(len(b))})
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	args := q["arg"]
+	switch r.URL.Path {
+	case "/api/v0/files/mkdir":
+		n.dirs[args[0]] = true
+	case "/api/v0/files/stat":
+		if n.dirs[args[0]] {
+			json.NewEncoder(w).Encode(map[string]any{"Hash": "bafydir", "Type": "directory"})
+			return
+		}
+		cid, ok := n.files[args[0]]
+		if !ok {
+			fail("file does not exist")
+			return
+		}
+		b := n.blocks[cid]
+		json.NewEncoder(w).Encode(map[string]any{"Hash": cid, "Size": len(b.b), "Type": "file", "Mtime": b.mtime})
+	case "/api/v0/files/rm":
+		if _, ok := n.files[args[0]]; !ok {
+			fail("file does not exist")
+			return
+		}
+		delete(n.files, args[0])
+	case "/api/v0/files/cp":
+		if _, ok := n.files[args[1]]; ok {
+			fail("directory already has entry by that name")
+			return
+		}
+		n.files[args[1]] = strings.TrimPrefix(args[0], "/ipfs/")
+	case "/api/v0/pin/rm":
+		if !n.pins[args[0]] {
+			fail("not pinned or pinned indirectly")
+			return
+		}
+		delete(n.pins, args[0])
+	case "/api/v0/cat":
+		b, ok := n.blocks[args[0]]
+		if !ok {
+			fail("block not found")
+			return
+		}
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		w.Write(b.b[min(offset, len(b.b)):])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// open connects to KIPP_TEST_IPFS, such as http://127.0.0.1:5001 for a kubo
+// container, or a fake node if it isn't set. Objects are recorded in an MFS
+// directory unique to the test.
+func open(t *testing.T) *ipfs.FileSystem {
+	api := os.Getenv("KIPP_TEST_IPFS")
+	if api == "" {
+		_, api = newNode(t)
+	}
+	dir := fmt.Sprintf("/kipp-test-%d", time.Now().UnixNano())
+	fs, err := ipfs.New(context.Background(), api, ipfs.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem { return open(t) })
+}
+
+// TestCID checks opened objects have the CID they're stored as.
+func TestCID(t *testing.T) {
+	ctx := context.Background()
+	fs := open(t)
+	if err := fs.Create(ctx, "a", strings.NewReader("some content")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	fi, err := fs.Stat(ctx, "a")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	f, err := fs.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	ca, ok := f.(filesystem.ContentAddresser)
+	if !ok {
+		t.Fatalf("reader %T is not a filesystem.ContentAddresser", f)
+	}
+	if ca.CID() == "" || ca.CID() != fi.ETag {
+		t.Fatalf("unexpected cid; got %q, want %q", ca.CID(), fi.ETag)
+	}
+}
+
+// TestPins checks objects are unpinned when they're replaced or removed.
+func TestPins(t *testing.T) {
+	ctx := context.Background()
+	n, api := newNode(t)
+	fs, err := ipfs.New(ctx, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid := func() string {
+		t.Helper()
+		fi, err := fs.Stat(ctx, "a")
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		return fi.ETag
+	}
+	pinned := func(cid string) bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return n.pins[cid]
+	}
+
+	if err := fs.Create(ctx, "a", strings.NewReader("a")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	old := cid()
+	if err := fs.Create(ctx, "a", strings.NewReader("b")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	replaced := cid()
+	if pinned(old) || !pinned(replaced) {
+		t.Fatalf("unexpected pins; got %t for the old object and %t for the new, want false and true", pinned(old), pinned(replaced))
+	}
+	if err := fs.Remove(ctx, "a"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if pinned(replaced) {
+		t.Fatal("removed object is still pinned")
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+	if _, err := ipfs.New(context.Background(), s.URL); !errors.Is(err, ipfs.ErrUnavailable) {
+		t.Fatalf("unexpected error; got %v, want %v", err, ipfs.ErrUnavailable)
+	}
+}
@@ -0,0 +1,87 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// reader reads an object, catting it from its offset whenever it's read after
+// seeking.
+type reader struct {
+	ctx          context.Context
+	fs           *FileSystem
+	cid          string
+	res          *http.Response
+	offset, size int64
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.res == nil {
+		if err := r.reset(); err != nil {
+			return 0, fmt.Errorf("reset: %w", err)
+		}
+	}
+	n, err = r.res.Body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF {
+		if serr := streamError(r.res); serr != nil {
+			return n, serr
+		}
+		if r.offset < r.size {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.res, r.offset = nil, offset
+	}
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if r.res == nil {
+		return nil
+	}
+	return r.res.Body.Close()
+}
+
+// CID returns the CID of the object, so it can be fetched over IPFS.
+func (r *reader) CID() string { return r.cid }
+
+// reset cats the object from the current offset.
+func (r *reader) reset() error {
+	r.Close()
+	res, err := r.fs.do(r.ctx, "cat", url.Values{
+		"arg":    {r.cid},
+		"offset": {strconv.FormatInt(r.offset, 10)},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("cat: %w", err)
+	}
+	r.res = res
+	return nil
+}
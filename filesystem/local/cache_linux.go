@@ -0,0 +1,11 @@
+package local
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropCache advises the kernel to drop the pages of f from the page cache.
+// It's only advice, so errors are ignored.
+func dropCache(f *os.File) { unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED) }
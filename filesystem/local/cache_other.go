@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package local
+
+import "os"
+
+// dropCache does nothing, as only Linux supports dropping files from the page
+// cache.
+func dropCache(*os.File) {}
@@ -2,31 +2,178 @@ package local
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	iofs "io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/uhthomas/kipp/filesystem"
 )
 
+const (
+	// tempPrefix prefixes the names of temporary files.
+	tempPrefix = "kipp"
+	// probeInterval is the minimum interval between the probes of Ping.
+	probeInterval = 10 * time.Second
+)
+
 // A FileSystem contains information about the local filesystem.
-type FileSystem struct{ dir, tmp string }
+type FileSystem struct {
+	dir, tmp string
+	// depth is the number of levels of directories files are sharded into.
+	depth int
+	// tempAge is how old temporary files must be for New to remove them.
+	tempAge time.Duration
+	// sync, syncDir, dropCache and sidecars are set by the options of the
+	// same name.
+	sync, syncDir, dropCache, sidecars bool
+	// probe is the last probe of Ping, shared by copies of the FileSystem.
+	probe *probe
+}
+
+// probe is the result of probing the file system, which is done at most once
+// every probeInterval.
+type probe struct {
+	mu  sync.Mutex
+	t   time.Time
+	err error
+	// done is closed once the probe in progress finishes, or nil if there
+	// isn't one.
+	done chan struct{}
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Shard stores files in depth levels of directories, named by successive
+// pairs of characters from the start of each file's name, so abcdefgh is
+// stored as ab/cd/abcdefgh with a depth of two. This keeps directories small
+// when there are many files. Names too short to shard, or with dots or
+// separators where they'd be sharded, are stored flat.
+//
+// Files stored flat, such as before sharding was enabled, are still found,
+// and can be moved into the sharded layout with Migrate.
+func Shard(depth int) Option {
+	return func(fs *FileSystem) error {
+		if depth < 0 || depth > 4 {
+			return fmt.Errorf("invalid shard depth %d", depth)
+		}
+		fs.depth = depth
+		return nil
+	}
+}
+
+// TempAge sets how old temporary files must be for New to remove them. They're
+// left behind by Creates which never finished, such as when the process was
+// killed part way through one. Files are written to temporary files first,
+// so these are never served. The default is a day, which is much longer than
+// any upload should take, as other processes could be using the directory.
+// Zero keeps them.
+func TempAge(d time.Duration) Option {
+	return func(fs *FileSystem) error {
+		if d < 0 {
+			return fmt.Errorf("invalid temp age %v", d)
+		}
+		fs.tempAge = d
+		return nil
+	}
+}
+
+// Sync flushes files to stable storage before they're linked into place, so
+// files which are created survive a crash or power loss. It makes creating
+// files slower, depending on the storage; see BenchmarkCreate. Errors
+// flushing files fail their Create.
+func Sync() Option {
+	return func(fs *FileSystem) error {
+		fs.sync = true
+		return nil
+	}
+}
+
+// SyncDir flushes the directory containing a file, and any sharded directories
+// above it, to stable storage once the file is linked into place, so its name
+// survives a crash or power loss as well as its content. It's only useful
+// alongside Sync.
+func SyncDir() Option {
+	return func(fs *FileSystem) error {
+		fs.syncDir = true
+		return nil
+	}
+}
+
+// DropCache advises the kernel to drop files from the page cache once they're
+// written, so uploads don't push out the files being read, much like writing
+// them with O_DIRECT but without its alignment requirements. Only pages which
+// have been flushed can be dropped, so it works best alongside Sync. It does
+// nothing on systems other than Linux.
+func DropCache() Option {
+	return func(fs *FileSystem) error {
+		fs.dropCache = true
+		return nil
+	}
+}
 
 // New creates a new FileSystem, and makes the relevant directories for
-// dir and tmp.
-func New(dir string) (*FileSystem, error) {
+// dir and tmp. Old temporary files are removed, as set by TempAge.
+func New(dir string, opts ...Option) (*FileSystem, error) {
 	tmp := filepath.Join(dir, "tmp")
 	if err := os.MkdirAll(tmp, 0755); err != nil && !os.IsExist(err) {
 		return nil, err
 	}
-	return &FileSystem{dir: dir, tmp: tmp}, nil
+	fs := &FileSystem{dir: dir, tmp: tmp, tempAge: 24 * time.Hour, probe: &probe{}}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	if fs.tempAge > 0 {
+		if err := fs.sweep(time.Now().Add(-fs.tempAge)); err != nil {
+			return nil, fmt.Errorf("sweep: %w", err)
+		}
+	}
+	return fs, nil
+}
+
+// sweep removes temporary files last modified before t.
+func (fs FileSystem) sweep(t time.Time) error {
+	entries, err := os.ReadDir(fs.tmp)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), tempPrefix) {
+			continue
+		}
+		fi, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.ModTime().Before(t) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(fs.tmp, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
 }
 
 // Create writes r to a temporary file, and links it to a permanent location
-// upon success.
+// upon success, so the file only ever exists complete. The temporary file is
+// removed whether or not it succeeds.
 func (fs FileSystem) Create(_ context.Context, name string, r io.Reader) error {
-	f, err := os.CreateTemp(fs.tmp, "kipp")
+	if isSidecar(name) {
+		return fmt.Errorf("%s: names ending in %s are reserved for sidecars", name, sidecarSuffix)
+	}
+	f, err := os.CreateTemp(fs.tmp, tempPrefix)
 	if err != nil {
 		return fmt.Errorf("temp file: %w", err)
 	}
@@ -35,24 +182,256 @@ func (fs FileSystem) Create(_ context.Context, name string, r io.Reader) error {
 	if _, err := io.Copy(f, r); err != nil {
 		return fmt.Errorf("copy: %w", err)
 	}
+	if fs.sync {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	if fs.dropCache {
+		dropCache(f)
+	}
 	if err := f.Close(); err != nil {
 		return fmt.Errorf("close: %w", err)
 	}
-	if err := os.Link(f.Name(), fs.path(name)); err != nil && !os.IsExist(err) {
+	p := fs.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	if err := os.Link(f.Name(), p); err != nil && !os.IsExist(err) {
 		return fmt.Errorf("link: %w", err)
 	}
+	if fs.syncDir {
+		// Sharded directories may have just been created, so their
+		// parents are flushed too.
+		for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+			if err := syncDir(dir); err != nil {
+				return fmt.Errorf("sync dir: %w", err)
+			}
+			if dir == filepath.Clean(fs.dir) {
+				break
+			}
+		}
+	}
 	return nil
 }
 
-// Open opens the named file.
+// Open opens the named file, looking for it where it'd be stored flat if it
+// isn't in its shard.
 func (fs FileSystem) Open(_ context.Context, name string) (filesystem.Reader, error) {
-	return os.Open(fs.path(name))
+	if isSidecar(name) {
+		return nil, filesystem.NotExist("open", name)
+	}
+	p, flat := fs.path(name), fs.flatPath(name)
+	f, err := os.Open(p)
+	if p != flat && errors.Is(err, os.ErrNotExist) {
+		if f, err = os.Open(flat); errors.Is(err, os.ErrNotExist) {
+			// It may have been moved by Migrate in the meantime.
+			f, err = os.Open(p)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-// Remove removes the named file.
+// Remove removes the named file, from where it'd be stored flat if it isn't
+// in its shard, along with its sidecar.
 func (fs FileSystem) Remove(_ context.Context, name string) error {
-	return os.Remove(fs.path(name))
+	if isSidecar(name) {
+		return filesystem.NotExist("remove", name)
+	}
+	p, flat := fs.path(name), fs.flatPath(name)
+	err := os.Remove(p)
+	if p != flat && errors.Is(err, os.ErrNotExist) {
+		if err = os.Remove(flat); errors.Is(err, os.ErrNotExist) {
+			err = os.Remove(p)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := fs.removeSidecar(name); err != nil {
+		return fmt.Errorf("remove sidecar: %w", err)
+	}
+	return nil
+}
+
+// Stat describes the named file, looking for it where it'd be stored flat if
+// it isn't in its shard. Files have no ETag.
+func (fs FileSystem) Stat(_ context.Context, name string) (filesystem.FileInfo, error) {
+	if isSidecar(name) {
+		return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+	}
+	p, flat := fs.path(name), fs.flatPath(name)
+	fi, err := os.Stat(p)
+	if p != flat && errors.Is(err, os.ErrNotExist) {
+		if fi, err = os.Stat(flat); errors.Is(err, os.ErrNotExist) {
+			fi, err = os.Stat(p)
+		}
+	}
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	return filesystem.FileInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Walk calls fn with each file, whether it's sharded or stored flat.
+// Temporary files and sidecars are skipped.
+func (fs FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	return filepath.WalkDir(fs.dir, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			// Removed in the meantime, such as by Migrate.
+			if errors.Is(err, os.ErrNotExist) && p != fs.dir {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == fs.tmp {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || isSidecar(d.Name()) {
+			return nil
+		}
+		fi, err := d.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(filesystem.FileInfo{Name: d.Name(), Size: fi.Size(), ModTime: fi.ModTime()})
+	})
+}
+
+// Ping checks the file system is mounted writable with statfs, and that a
+// temporary file can be written and removed, so a volume which was unmounted
+// or remounted read only is caught. Probes are made at most every ten
+// seconds, with calls in between returning the last result. Probes which take
+// longer than ctx continue in the background, so a hung volume doesn't hang
+// Ping.
+func (fs FileSystem) Ping(ctx context.Context) error {
+	p := fs.probe
+	p.mu.Lock()
+	if p.done == nil && time.Since(p.t) >= probeInterval {
+		done := make(chan struct{})
+		p.done = done
+		go func() {
+			defer close(done)
+			err := fs.ping()
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.t, p.err, p.done = time.Now(), err, nil
+		}()
+	}
+	done := p.done
+	p.mu.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return fmt.Errorf("probe: %w", ctx.Err())
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// ping probes the file system.
+func (fs FileSystem) ping() error {
+	if err := statfs(fs.dir); err != nil {
+		return fmt.Errorf("statfs: %w", err)
+	}
+	f, err := os.CreateTemp(fs.tmp, tempPrefix+"ping")
+	if err != nil {
+		return fmt.Errorf("temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write([]byte{0}); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("remove: %w", err)
+	}
+	return nil
+}
+
+// Migrate moves files stored flat into their shards, along with their
+// sidecars, returning how many were moved. Files are moved atomically, so it's safe to use while the file
+// system is in use, including by another process.
+func (fs FileSystem) Migrate(ctx context.Context) (n int, err error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return 0, fmt.Errorf("read dir: %w", err)
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		name := e.Name()
+		p, flat := fs.path(name), fs.flatPath(name)
+		if isSidecar(name) {
+			// Sidecars are kept alongside their files.
+			p = fs.path(strings.TrimSuffix(name, sidecarSuffix)) + sidecarSuffix
+		}
+		if !e.Type().IsRegular() || p == flat {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return n, fmt.Errorf("mkdir: %w", err)
+		}
+		if err := os.Rename(flat, p); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Removed in the meantime.
+				continue
+			}
+			return n, fmt.Errorf("rename %s: %w", name, err)
+		}
+		if !isSidecar(name) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// syncDir flushes the named directory to stable storage.
+func syncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 // path returns the path of the named file relative to the file system.
-func (fs FileSystem) path(name string) string { return filepath.Join(fs.dir, name) }
+func (fs FileSystem) path(name string) string {
+	if fs.depth == 0 || len(name) <= 2*fs.depth {
+		return fs.flatPath(name)
+	}
+	elem := make([]string, 0, fs.depth+2)
+	elem = append(elem, fs.dir)
+	for i := 0; i < fs.depth; i++ {
+		// Dots and separators could escape the shard.
+		shard := name[2*i : 2*i+2]
+		if strings.ContainsAny(shard, "./\\") {
+			return fs.flatPath(name)
+		}
+		elem = append(elem, shard)
+	}
+	return filepath.Join(append(elem, name)...)
+}
+
+// flatPath returns the path of the named file when it isn't sharded.
+func (fs FileSystem) flatPath(name string) string { return filepath.Join(fs.dir, name) }
@@ -1,9 +1,18 @@
 package local_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
 	"github.com/uhthomas/kipp/filesystem/local"
 )
 
@@ -13,3 +22,282 @@ func TestFileSystem(t *testing.T) {
 		t.Fatal("local.FileSystem does not implement fs.FileSystem")
 	}
 }
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, err := local.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+func TestConformanceDurable(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, err := local.New(t.TempDir(), local.Shard(2), local.Sync(), local.SyncDir(), local.DropCache())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+func TestConformanceSharded(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, err := local.New(t.TempDir(), local.Shard(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+// TestShard checks files are found in both the flat and sharded layouts, and
+// that Migrate moves flat files into their shards.
+func TestShard(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	fs, err := local.New(dir, local.Shard(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Create(ctx, "abcdefgh", strings.NewReader("sharded")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ab", "cd", "abcdefgh")); err != nil {
+		t.Fatalf("file should be sharded: %v", err)
+	}
+	// Files stored before sharding was enabled, and those too short to shard.
+	for _, name := range []string{"flat1234", "flat1234.json", "flat5678", "abcd", "..abcdef"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(name, want string) {
+		t.Helper()
+		f, err := fs.Open(ctx, name)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		defer f.Close()
+		if b, err := io.ReadAll(f); err != nil || string(b) != want {
+			t.Fatalf("read %s; got %q and %v, want %q", name, b, err, want)
+		}
+	}
+	for name, want := range map[string]string{
+		"abcdefgh": "sharded",
+		"flat1234": "flat1234",
+		"flat5678": "flat5678",
+		"abcd":     "abcd",
+		"..abcdef": "..abcdef",
+	} {
+		read(name, want)
+	}
+
+	// Files can be removed from the old layout.
+	if err := fs.Remove(ctx, "flat5678"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := fs.Open(ctx, "flat5678"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrNotExist)
+	}
+
+	if n, err := fs.Migrate(ctx); err != nil || n != 1 {
+		t.Fatalf("migrate; got %d and %v, want 1", n, err)
+	}
+	// Sidecars are moved along with their files.
+	for _, name := range []string{"flat1234", "flat1234.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "fl", "at", name)); err != nil {
+			t.Fatalf("%s should be sharded: %v", name, err)
+		}
+	}
+	read("flat1234", "flat1234")
+	read("abcd", "abcd")
+	read("..abcdef", "..abcdef")
+	if n, err := fs.Migrate(ctx); err != nil || n != 0 {
+		t.Fatalf("migrate; got %d and %v, want 0", n, err)
+	}
+
+	if _, err := local.New(dir, local.Shard(5)); err == nil {
+		t.Fatal("expected error for shard depth 5")
+	}
+}
+
+// TestMigrateConcurrent checks files can be opened while they're migrated.
+func TestMigrateConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	fs, err := local.New(dir, local.Shard(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("%03dfile", i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fs.Migrate(ctx)
+		done <- err
+	}()
+	for _, name := range names {
+		f, err := fs.Open(ctx, name)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		f.Close()
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+}
+
+// TestCreateFail checks a file which fails part way through being written is
+// never visible under its name, and leaves no temporary file behind.
+func TestCreateFail(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	fs, err := local.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := filesystem.PipeReader(func(w io.Writer) error {
+		if _, err := io.WriteString(w, "partial"); err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(dir, "failed")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("file exists while being written: %v", err)
+		}
+		return errors.New("killed")
+	})
+	if err := fs.Create(ctx, "failed", r); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "failed")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file exists after failing: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("unexpected temporary files; got %d, want 0", len(entries))
+	}
+}
+
+// TestSweep checks New removes old temporary files, and only those.
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for name, mtime := range map[string]time.Time{
+		"kipp1": old,
+		"kipp2": time.Now(),
+		"other": old,
+	} {
+		p := filepath.Join(tmp, name)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := local.New(dir, local.TempAge(0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "kipp1")); err != nil {
+		t.Fatalf("temporary file removed with a zero age: %v", err)
+	}
+
+	if _, err := local.New(dir, local.TempAge(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{"kipp1": false, "kipp2": true, "other": true} {
+		if _, err := os.Stat(filepath.Join(tmp, name)); (err == nil) != exists {
+			t.Fatalf("unexpected stat for %s; got %v, want exists=%t", name, err, exists)
+		}
+	}
+
+	if _, err := local.New(dir, local.TempAge(-time.Hour)); err == nil {
+		t.Fatal("expected error for a negative age")
+	}
+}
+
+// BenchmarkCreate compares creating files with the durability options, which
+// depend heavily on the storage t.TempDir is on. Set TMPDIR to benchmark
+// other storage.
+func BenchmarkCreate(b *testing.B) {
+	for _, size := range []int{4 << 10, 1 << 20} {
+		data := strings.Repeat("a", size)
+		for _, bb := range []struct {
+			name string
+			opts []local.Option
+		}{
+			{name: "Default"},
+			{name: "Sync", opts: []local.Option{local.Sync()}},
+			{name: "SyncDir", opts: []local.Option{local.Sync(), local.SyncDir()}},
+			{name: "DropCache", opts: []local.Option{local.Sync(), local.DropCache()}},
+		} {
+			b.Run(fmt.Sprintf("%s/%dKiB", bb.name, size>>10), func(b *testing.B) {
+				fs, err := local.New(b.TempDir(), append(bb.opts, local.Shard(1))...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := fs.Create(context.Background(), fmt.Sprintf("%08d", i), strings.NewReader(data)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestPing checks Ping fails once the temporary directory is gone, as it would
+// be if the volume was unmounted, and that probes are rate limited.
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fs, err := local.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Ping(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := os.Mkdir(filepath.Join(dir, "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The last probe was too recent to probe again.
+	if err := fs.Ping(ctx); err == nil {
+		t.Fatal("expected error from the last probe")
+	}
+
+	if fs, err = local.New(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Ping(ctx); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package local
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/zeebo/blake3"
+)
+
+const (
+	// sidecarSuffix is appended to the name of a file for its sidecar.
+	// Names with it are reserved, so sidecars are never served as files.
+	sidecarSuffix = ".json"
+	// gzipSuffix is appended to the name of a file for its gzip variant, as
+	// kipp names them.
+	gzipSuffix = ".gz"
+	// rebuildBatch is how many sidecars are looked up and created at once
+	// by Rebuild.
+	rebuildBatch = 100
+)
+
+// isSidecar reports whether name is that of a sidecar.
+func isSidecar(name string) bool { return strings.HasSuffix(name, sidecarSuffix) }
+
+// sidecar is what's stored in a sidecar: the entry of its file as it was
+// uploaded.
+type sidecar struct {
+	Slug      string     `json:"slug"`
+	Name      string     `json:"name"`
+	Sum       string     `json:"sum"`
+	Size      int64      `json:"size"`
+	GzipSize  int64      `json:"gzip_size,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+	Lifetime  *time.Time `json:"lifetime,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+}
+
+// Sidecars stores the entry of each file alongside it, as written by
+// WriteSidecar, so the database can be rebuilt from the files with Rebuild if
+// it's lost. Sidecars are removed along with their files.
+func Sidecars() Option {
+	return func(fs *FileSystem) error {
+		fs.sidecars = true
+		return nil
+	}
+}
+
+// WriteSidecar writes e to the sidecar of its file, replacing any already
+// written, if sidecars are enabled. Sidecars record entries as they were
+// uploaded, so later changes to them, such as extended lifetimes or soft
+// deletes, aren't recorded.
+func (fs FileSystem) WriteSidecar(_ context.Context, e database.Entry) error {
+	if !fs.sidecars {
+		return nil
+	}
+	b, err := json.Marshal(sidecar{
+		Slug:      e.Slug,
+		Name:      e.Name,
+		Sum:       e.Sum,
+		Size:      e.Size,
+		GzipSize:  e.GzipSize,
+		Timestamp: e.Timestamp,
+		Lifetime:  e.Lifetime,
+		Tags:      e.Tags,
+		Owner:     e.Owner,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	f, err := os.CreateTemp(fs.tmp, tempPrefix)
+	if err != nil {
+		return fmt.Errorf("temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if fs.sync {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	// The file was just created, so it's in its shard.
+	if err := os.Rename(f.Name(), fs.path(e.Slug)+sidecarSuffix); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// removeSidecar removes the sidecar of the named file, wherever it is.
+func (fs FileSystem) removeSidecar(name string) error {
+	for _, p := range []string{fs.path(name), fs.flatPath(name)} {
+		if err := os.Remove(p + sidecarSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild creates the entries recorded by the sidecars of the local file
+// system in dir which db doesn't have, returning how many were created. Files
+// are checked against the size and sum of their sidecar first. Sidecars which
+// can't be read, or whose files are missing or don't match, are logged to log
+// and skipped. Gzip variants which are missing or don't match are left out of
+// their entries, and later removed as orphans.
+//
+// Entries db already has are left as they are, so it's safe to run again,
+// such as after it was interrupted, and while kipp is serving from dir.
+func Rebuild(ctx context.Context, dir string, db database.Database, log *slog.Logger) (n int, err error) {
+	var batch []database.Entry
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		slugs := make([]string, 0, len(batch))
+		for _, e := range batch {
+			slugs = append(slugs, e.Slug)
+		}
+		existing, err := db.LookupMany(ctx, slugs)
+		if err != nil {
+			return fmt.Errorf("lookup: %w", err)
+		}
+		var missing []database.Entry
+		for _, e := range batch {
+			if _, ok := existing[e.Slug]; !ok {
+				missing = append(missing, e)
+			}
+		}
+		batch = batch[:0]
+		if len(missing) == 0 {
+			return nil
+		}
+		if err := db.CreateBatch(ctx, missing); err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+		n += len(missing)
+		return nil
+	}
+	tmp := filepath.Join(dir, "tmp")
+	if err := filepath.WalkDir(dir, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			// Removed in the meantime, such as by Migrate.
+			if errors.Is(err, os.ErrNotExist) && p != dir {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == tmp {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !isSidecar(d.Name()) {
+			return nil
+		}
+		e, err := readSidecar(p)
+		if err != nil {
+			log.WarnContext(ctx, "rebuild", "path", p, "error", err)
+			return nil
+		}
+		batch = append(batch, e)
+		if len(batch) < rebuildBatch {
+			return nil
+		}
+		return flush()
+	}); err != nil {
+		return n, fmt.Errorf("walk: %w", err)
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// readSidecar reads the sidecar at p, and checks its file matches it.
+func readSidecar(p string) (database.Entry, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	var sc sidecar
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return database.Entry{}, fmt.Errorf("unmarshal: %w", err)
+	}
+	name := strings.TrimSuffix(p, sidecarSuffix)
+	if sc.Slug != filepath.Base(name) {
+		return database.Entry{}, fmt.Errorf("sidecar is of %s", sc.Slug)
+	}
+	if err := verify(name, sc.Size, sc.Sum); err != nil {
+		return database.Entry{}, err
+	}
+	if sc.GzipSize > 0 {
+		if fi, err := os.Stat(name + gzipSuffix); err != nil || fi.Size() != sc.GzipSize {
+			sc.GzipSize = 0
+		}
+	}
+	return database.Entry{
+		Slug:      sc.Slug,
+		Name:      sc.Name,
+		Sum:       sc.Sum,
+		Size:      sc.Size,
+		GzipSize:  sc.GzipSize,
+		Timestamp: sc.Timestamp,
+		Lifetime:  sc.Lifetime,
+		Tags:      sc.Tags,
+		Owner:     sc.Owner,
+	}, nil
+}
+
+// verify checks the named file has the given size and base64 blake3 sum.
+func verify(name string, size int64, sum string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := blake3.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("size is %d, want %d", n, size)
+	}
+	if got := base64.RawURLEncoding.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("sum is %s, want %s", got, sum)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package local_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/local"
+	"github.com/zeebo/blake3"
+)
+
+// entry creates the named file with content, and returns its entry.
+func entry(t *testing.T, fs *local.FileSystem, slug, content string) database.Entry {
+	t.Helper()
+	if err := fs.Create(context.Background(), slug, strings.NewReader(content)); err != nil {
+		t.Fatalf("create %s: %v", slug, err)
+	}
+	sum := blake3.Sum256([]byte(content))
+	lifetime := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	return database.Entry{
+		Slug:      slug,
+		Name:      slug + ".txt",
+		Sum:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		Size:      int64(len(content)),
+		Timestamp: time.Now().Truncate(time.Second).UTC(),
+		Lifetime:  &lifetime,
+		Tags:      []string{"a", "b"},
+		Owner:     "alice",
+	}
+}
+
+func TestSidecars(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	fs, err := local.New(dir, local.Shard(2), local.Sidecars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := entry(t, fs, "abcdefgh", "some content")
+	if err := fs.WriteSidecar(ctx, e); err != nil {
+		t.Fatalf("write sidecar: %v", err)
+	}
+	p := filepath.Join(dir, "ab", "cd", "abcdefgh.json")
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("sidecar should be alongside its file: %v", err)
+	}
+
+	// Sidecars aren't files of the file system.
+	if _, err := fs.Open(ctx, "abcdefgh.json"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+	if err := fs.Create(ctx, "other.json", strings.NewReader("{}")); err == nil {
+		t.Fatal("expected error for a sidecar name")
+	}
+	var names []string
+	if err := fs.Walk(ctx, func(fi filesystem.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if want := []string{"abcdefgh"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected files; got %q, want %q", names, want)
+	}
+
+	if err := fs.Remove(ctx, "abcdefgh"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("sidecar wasn't removed: %v", err)
+	}
+
+	// They're only written when enabled.
+	fs, err = local.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteSidecar(ctx, entry(t, fs, "ijklmnop", "abc")); err != nil {
+		t.Fatalf("write sidecar: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ijklmnop.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected sidecar: %v", err)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	fs, err := local.New(dir, local.Shard(2), local.Sidecars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := memory.New()
+
+	good := entry(t, fs, "aaaaaaaa", "good")
+	good.GzipSize = 4
+	if err := fs.Create(ctx, "aaaaaaaa.gz", strings.NewReader("gzip")); err != nil {
+		t.Fatal(err)
+	}
+	// The gzip variant doesn't match its size, so it's left out.
+	badGzip := entry(t, fs, "bbbbbbbb", "bad gzip")
+	badGzip.GzipSize = 100
+	corrupt := entry(t, fs, "cccccccc", "corrupt")
+	missing := entry(t, fs, "dddddddd", "missing")
+	// The database still has it, with changes since it was uploaded.
+	existing := entry(t, fs, "eeeeeeee", "existing")
+	for _, e := range []database.Entry{good, badGzip, corrupt, missing, existing} {
+		if err := fs.WriteSidecar(ctx, e); err != nil {
+			t.Fatalf("write sidecar: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cc", "cc", "cccccccc"), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "dd", "dd", "dddddddd")); err != nil {
+		t.Fatal(err)
+	}
+	existing.Name = "renamed.txt"
+	if err := db.Create(ctx, existing); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := local.Rebuild(ctx, dir, db, slog.Default()); err != nil || n != 2 {
+		t.Fatalf("unexpected rebuild; got %d and %v, want 2 created", n, err)
+	}
+	badGzip.GzipSize = 0
+	for _, want := range []database.Entry{good, badGzip, existing} {
+		got, err := db.Lookup(ctx, want.Slug)
+		if err != nil {
+			t.Fatalf("lookup %s: %v", want.Slug, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected entry; got %+v, want %+v", got, want)
+		}
+	}
+	for _, slug := range []string{corrupt.Slug, missing.Slug} {
+		if _, err := db.Lookup(ctx, slug); !errors.Is(err, database.ErrNoResults) {
+			t.Fatalf("unexpected entry %s: %v", slug, err)
+		}
+	}
+
+	// Rebuilding again changes nothing.
+	if n, err := local.Rebuild(ctx, dir, db, slog.Default()); err != nil || n != 0 {
+		t.Fatalf("unexpected rebuild; got %d and %v, want none created", n, err)
+	}
+}
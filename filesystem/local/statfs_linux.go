@@ -0,0 +1,20 @@
+package local
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// statfs returns an error if the file system containing dir is mounted read
+// only.
+func statfs(dir string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return err
+	}
+	if st.Flags&unix.ST_RDONLY != 0 {
+		return errors.New("read-only file system")
+	}
+	return nil
+}
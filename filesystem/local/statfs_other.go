@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package local
+
+import (
+	"errors"
+	"os"
+)
+
+// statfs returns an error if dir isn't a directory. Only Linux reports whether
+// it's mounted read only.
+func statfs(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("not a directory")
+	}
+	return nil
+}
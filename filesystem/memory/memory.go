@@ -0,0 +1,238 @@
+// Package memory implements a kipp filesystem in memory, which is useful for
+// tests. A FileSystem is safe for concurrent use.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// FileSystem is a mutex guarded map of objects. Objects are never modified
+// once created, so readers see the object as it was when they were opened.
+type FileSystem struct {
+	mu      sync.RWMutex
+	files   map[string]object
+	size    int64
+	creates int
+
+	latency    time.Duration
+	failCreate int
+	failErr    error
+	onSize     func(size int64)
+	stream     bool
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem)
+
+// Latency delays every operation by d, or until its context is done.
+func Latency(d time.Duration) Option {
+	return func(fs *FileSystem) { fs.latency = d }
+}
+
+// FailCreate makes the nth call to Create, counting from one, fail with err
+// after reading some of its reader. Nothing is stored.
+func FailCreate(n int, err error) Option {
+	return func(fs *FileSystem) { fs.failCreate, fs.failErr = n, err }
+}
+
+// Stream makes Open return readers which can't seek, as those of file systems
+// which can only stream objects do.
+func Stream() Option {
+	return func(fs *FileSystem) { fs.stream = true }
+}
+
+// OnSize calls f with the total size of all objects whenever it changes. f is
+// called with the file system locked, so it must not use it.
+func OnSize(f func(size int64)) Option {
+	return func(fs *FileSystem) { fs.onSize = f }
+}
+
+// An object is the content of an object and when it was created.
+type object struct {
+	b       []byte
+	modTime time.Time
+	etag    string
+}
+
+// New returns a new, empty FileSystem.
+func New(opts ...Option) *FileSystem {
+	fs := &FileSystem{files: make(map[string]object)}
+	for _, opt := range opts {
+		opt(fs)
+	}
+	return fs
+}
+
+// Create reads r up to io.EOF and stores it as the named object, replacing
+// any existing object.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	if err := fs.wait(ctx); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	fs.creates++
+	fail := fs.creates == fs.failCreate
+	fs.mu.Unlock()
+	if fail {
+		io.CopyN(io.Discard, r, 1)
+		return fmt.Errorf("create %s: %w", name, fs.failErr)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(b)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.setSize(fs.size + int64(len(b)-len(fs.files[name].b)))
+	fs.files[name] = object{b: b, modTime: time.Now(), etag: hex.EncodeToString(sum[:])}
+	return nil
+}
+
+// Open opens the named object.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	if err := fs.wait(ctx); err != nil {
+		return nil, err
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	o, ok := fs.files[name]
+	if !ok {
+		return nil, filesystem.NotExist("open", name)
+	}
+	r := &reader{r: bytes.NewReader(o.b)}
+	if fs.stream {
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, r}, nil
+	}
+	return r, nil
+}
+
+// Remove removes the named object.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	if err := fs.wait(ctx); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	o, ok := fs.files[name]
+	if !ok {
+		return filesystem.NotExist("remove", name)
+	}
+	delete(fs.files, name)
+	fs.setSize(fs.size - int64(len(o.b)))
+	return nil
+}
+
+// Stat describes the named object. Its ETag is the hex encoded SHA-256 of its
+// content.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	if err := fs.wait(ctx); err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	o, ok := fs.files[name]
+	if !ok {
+		return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+	}
+	return filesystem.FileInfo{Name: name, Size: int64(len(o.b)), ModTime: o.modTime, ETag: o.etag}, nil
+}
+
+// Walk calls fn with each object. Objects are listed up front, so fn may use
+// the file system.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	if err := fs.wait(ctx); err != nil {
+		return err
+	}
+	fs.mu.RLock()
+	fis := make([]filesystem.FileInfo, 0, len(fs.files))
+	for name, o := range fs.files {
+		fis = append(fis, filesystem.FileInfo{Name: name, Size: int64(len(o.b)), ModTime: o.modTime, ETag: o.etag})
+	}
+	fs.mu.RUnlock()
+	for _, fi := range fis {
+		if err := fn(fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size returns the total size of all objects.
+func (fs *FileSystem) Size() int64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.size
+}
+
+// setSize sets the total size of all objects. fs.mu must be held.
+func (fs *FileSystem) setSize(size int64) {
+	if size == fs.size {
+		return
+	}
+	fs.size = size
+	if fs.onSize != nil {
+		fs.onSize(size)
+	}
+}
+
+// wait waits for the configured latency, or until ctx is done.
+func (fs *FileSystem) wait(ctx context.Context) error {
+	if fs.latency <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(fs.latency)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// reader is a bytes.Reader which can't be used once closed.
+type reader struct {
+	r      *bytes.Reader
+	closed bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	return r.r.Read(p)
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	return r.r.Seek(offset, whence)
+}
+
+func (r *reader) Close() error {
+	if r.closed {
+		return os.ErrClosed
+	}
+	r.closed = true
+	return nil
+}
@@ -0,0 +1,105 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestFileSystem(t *testing.T) {
+	filesystemtest.Run(t, func(*testing.T) filesystem.FileSystem { return memory.New() })
+}
+
+func TestStream(t *testing.T) {
+	filesystemtest.Run(t, func(*testing.T) filesystem.FileSystem { return memory.New(memory.Stream()) })
+
+	fs := memory.New(memory.Stream())
+	if err := fs.Create(context.Background(), "a", strings.NewReader("some data")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f, err := fs.Open(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if _, ok := f.(io.Seeker); ok {
+		t.Fatal("stream can seek")
+	}
+}
+
+func TestFailCreate(t *testing.T) {
+	ctx := context.Background()
+
+	errFail := errors.New("disk full")
+	fs := memory.New(memory.FailCreate(2, errFail))
+	for i, want := range []error{nil, errFail, nil} {
+		if err := fs.Create(ctx, "a", strings.NewReader("some data")); !errors.Is(err, want) {
+			t.Fatalf("create %d: got %v, want %v", i+1, err, want)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	fs := memory.New(memory.Latency(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := fs.Open(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error; got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOnSize(t *testing.T) {
+	ctx := context.Background()
+
+	var sizes []int64
+	fs := memory.New(memory.OnSize(func(size int64) { sizes = append(sizes, size) }))
+	for _, s := range []string{"1234", "12"} {
+		if err := fs.Create(ctx, s, strings.NewReader(s)); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	// Replacing an object accounts for the difference in size.
+	if err := fs.Create(ctx, "12", strings.NewReader("123")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := fs.Remove(ctx, "1234"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if got, want := sizes, []int64{4, 6, 7, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected sizes; got %v, want %v", got, want)
+	}
+	if got := fs.Size(); got != 3 {
+		t.Fatalf("unexpected size; got %d, want 3", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+
+	fs := memory.New()
+	if err := fs.Create(ctx, "a", strings.NewReader("some data")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f, err := fs.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := io.ReadAll(f); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrClosed)
+	}
+	if _, err := f.(io.Seeker).Seek(0, io.SeekStart); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("unexpected error; got %v, want %v", err, os.ErrClosed)
+	}
+}
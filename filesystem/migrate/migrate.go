@@ -0,0 +1,292 @@
+// Package migrate copies the files of entries between file systems, such as
+// from a local disk to S3, while kipp keeps serving from them.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/zeebo/blake3"
+)
+
+// Options configure Migrate.
+type Options struct {
+	// Workers is how many entries are copied at once, or one if zero.
+	Workers int
+	// Verify checks files match the sums of their entries as they're
+	// copied, failing those which don't. Gzip variants are only checked
+	// against their size, as they aren't summed.
+	Verify bool
+	// Progress, if not nil, is called after each entry is migrated or
+	// fails. Calls are never concurrent.
+	Progress func(p Progress)
+	// Registerer, if not nil, registers metrics of what's been copied.
+	Registerer prometheus.Registerer
+}
+
+// Progress is how far a migration has got.
+type Progress struct {
+	// Entries is how many entries have been migrated or failed.
+	Entries int
+	// Copied and Skipped are how many files were copied, or skipped as
+	// they were already in the destination, and Bytes the size of those
+	// copied.
+	Copied, Skipped int
+	Bytes           int64
+	// Failed is how many entries failed.
+	Failed int
+}
+
+// A Report describes a migration.
+type Report struct {
+	Progress
+	// Failures are the entries which failed, so they can be retried.
+	Failures []Failure
+}
+
+// A Failure is an entry which failed to be migrated.
+type Failure struct {
+	Slug string
+	Err  error
+}
+
+// Migrate copies the files of every entry in db from src to dst, including
+// expired and soft deleted entries, streaming each of them. Files already in
+// dst with the size of their entry are skipped, so an interrupted migration
+// can be run again to resume it. Entries which fail are listed in the report
+// rather than stopping the migration. It returns early if listing entries
+// fails or ctx is done.
+func Migrate(ctx context.Context, db database.Database, src, dst filesystem.FileSystem, opts Options) (Report, error) {
+	m, err := newMetrics(opts.Registerer)
+	if err != nil {
+		return Report{}, err
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		mu  sync.Mutex
+		rep Report
+	)
+	entries := make(chan database.Entry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range entries {
+				res, err := migrate(ctx, src, dst, e, opts.Verify)
+				m.record(res, err)
+
+				mu.Lock()
+				rep.Entries++
+				rep.Copied += res.copied
+				rep.Skipped += res.skipped
+				rep.Bytes += res.bytes
+				if err != nil {
+					rep.Failed++
+					rep.Failures = append(rep.Failures, Failure{Slug: e.Slug, Err: err})
+				}
+				if opts.Progress != nil {
+					opts.Progress(rep.Progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	err = list(ctx, db, entries)
+	close(entries)
+	wg.Wait()
+	return rep, err
+}
+
+// list sends every entry in db to entries until ctx is done.
+func list(ctx context.Context, db database.Database, entries chan<- database.Entry) error {
+	var opts database.ListOptions
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, next, err := db.List(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("list: %w", err)
+		}
+		for _, e := range page {
+			select {
+			case entries <- e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Cursor = next
+	}
+}
+
+// result is what was done to migrate an entry.
+type result struct {
+	copied, skipped int
+	bytes           int64
+}
+
+// object is a file of an entry.
+type object struct {
+	name string
+	size int64
+	// sum is the sum of the file, or empty if it isn't summed.
+	sum string
+}
+
+// migrate copies the file of e, and its gzip variant if it has one, from src
+// to dst.
+func migrate(ctx context.Context, src, dst filesystem.FileSystem, e database.Entry, verify bool) (result, error) {
+	objects := []object{{e.Slug, e.Size, e.Sum}}
+	if e.GzipSize > 0 {
+		objects = append(objects, object{e.Slug + ".gz", e.GzipSize, ""})
+	}
+	var res result
+	for _, o := range objects {
+		fi, err := dst.Stat(ctx, o.name)
+		if err == nil && fi.Size == o.size {
+			res.skipped++
+			continue
+		}
+		if err != nil && !filesystem.IsNotExist(err) {
+			return res, fmt.Errorf("stat %s: %w", o.name, err)
+		}
+		if !verify {
+			o.sum = ""
+		}
+		if err := copyObject(ctx, src, dst, o.name, o.size, o.sum); err != nil {
+			return res, err
+		}
+		res.copied++
+		res.bytes += o.size
+	}
+	return res, nil
+}
+
+// Copy copies the named object from src to dst, streaming it.
+func Copy(ctx context.Context, src, dst filesystem.FileSystem, name string) error {
+	return copyObject(ctx, src, dst, name, -1, "")
+}
+
+// copyObject copies the named object from src to dst. Unless size is negative
+// or sum is empty, the copy fails if the object doesn't have them, before it's
+// complete so it's never stored.
+func copyObject(ctx context.Context, src, dst filesystem.FileSystem, name string, size int64, sum string) error {
+	r, err := src.Open(ctx, name)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	defer r.Close()
+	if err := dst.Create(ctx, name, Verify(r, size, sum)); err != nil {
+		return fmt.Errorf("create %s: %w", name, err)
+	}
+	return nil
+}
+
+// ErrMismatch is returned for files which don't match their entries.
+var ErrMismatch = errors.New("file doesn't match its entry")
+
+// Verify returns a reader of r which checks what's read has size bytes and
+// the sum of an entry, returning an error wrapping ErrMismatch in place of
+// io.EOF if it doesn't. The size isn't checked if it's negative, nor the sum
+// if it's empty. Passed to FileSystem.Create, it fails the create before it's
+// complete, so a file which doesn't match is never stored.
+func Verify(r io.Reader, size int64, sum string) io.Reader {
+	vr := &verifyReader{r: r, size: size, sum: sum}
+	if sum != "" {
+		vr.h = blake3.New()
+	}
+	return vr
+}
+
+// verifyReader checks the size and sum of what's read from r before returning
+// io.EOF, returning an error in its place if they don't match.
+type verifyReader struct {
+	r    io.Reader
+	h    hash.Hash
+	n    int64
+	size int64
+	sum  string
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.h != nil {
+		r.h.Write(p[:n])
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	if r.size >= 0 && r.n != r.size {
+		return n, fmt.Errorf("%w: read %d bytes, want %d", ErrMismatch, r.n, r.size)
+	}
+	if r.h != nil {
+		want, derr := base64.RawURLEncoding.DecodeString(r.sum)
+		if derr != nil || !bytes.Equal(r.h.Sum(nil), want) {
+			return n, fmt.Errorf("%w: sum differs", ErrMismatch)
+		}
+	}
+	return n, io.EOF
+}
+
+// metrics count what's been copied. Its methods do nothing if it's nil.
+type metrics struct {
+	files *prometheus.CounterVec
+	bytes prometheus.Counter
+}
+
+func newMetrics(r prometheus.Registerer) (*metrics, error) {
+	if r == nil {
+		return nil, nil
+	}
+	m := &metrics{
+		files: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "migrate",
+			Name:      "files_total",
+			Help:      "Number of files copied or skipped, and of entries which failed, by result.",
+		}, []string{"result"}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "migrate",
+			Name:      "bytes_total",
+			Help:      "Total size of the files copied.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.files, m.bytes} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *metrics) record(res result, err error) {
+	if m == nil {
+		return
+	}
+	m.files.WithLabelValues("copied").Add(float64(res.copied))
+	m.files.WithLabelValues("skipped").Add(float64(res.skipped))
+	m.bytes.Add(float64(res.bytes))
+	if err != nil {
+		m.files.WithLabelValues("failed").Inc()
+	}
+}
@@ -0,0 +1,102 @@
+package migrate_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/migrate"
+	"github.com/zeebo/blake3"
+)
+
+func sum(s string) string {
+	b := blake3.Sum256([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, src, dst := memory.New(), memfs.New(), memfs.New()
+
+	// a has a gzip variant, b's file doesn't match its sum, c's file is
+	// missing and d's is already in the destination.
+	for _, slug := range []string{"a", "b", "c", "d"} {
+		e := databasetest.NewEntry(slug)
+		e.Size, e.Sum, e.GzipSize = 3, sum(slug+slug+slug), 0
+		if slug == "a" {
+			e.GzipSize = 2
+		}
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	for name, content := range map[string]string{"a": "aaa", "a.gz": "gz", "b": "bad", "d": "ddd"} {
+		if err := src.Create(ctx, name, strings.NewReader(content)); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	if err := dst.Create(ctx, "d", strings.NewReader("ddd")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	var calls int
+	r := prometheus.NewRegistry()
+	rep, err := migrate.Migrate(ctx, db, src, dst, migrate.Options{
+		Workers:    4,
+		Verify:     true,
+		Progress:   func(migrate.Progress) { calls++ },
+		Registerer: r,
+	})
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if want := (migrate.Progress{Entries: 4, Copied: 2, Skipped: 1, Bytes: 5, Failed: 2}); rep.Progress != want || calls != 4 {
+		t.Fatalf("unexpected progress; got %+v after %d calls, want %+v after 4", rep.Progress, calls, want)
+	}
+	sort.Slice(rep.Failures, func(i, j int) bool { return rep.Failures[i].Slug < rep.Failures[j].Slug })
+	if len(rep.Failures) != 2 || rep.Failures[0].Slug != "b" || !errors.Is(rep.Failures[0].Err, migrate.ErrMismatch) ||
+		rep.Failures[1].Slug != "c" || !filesystem.IsNotExist(rep.Failures[1].Err) {
+		t.Fatalf("unexpected failures; got %v, want b mismatched and c missing", rep.Failures)
+	}
+	if _, err := dst.Stat(ctx, "b"); !filesystem.IsNotExist(err) {
+		t.Fatalf("mismatched file was stored: %v", err)
+	}
+	for _, name := range []string{"a", "a.gz"} {
+		if _, err := dst.Stat(ctx, name); err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+	}
+	if err := testutil.GatherAndCompare(r, strings.NewReader(`
+# HELP kipp_migrate_bytes_total Total size of the files copied.
+# TYPE kipp_migrate_bytes_total counter
+kipp_migrate_bytes_total 5
+`), "kipp_migrate_bytes_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrating again resumes, skipping what's been copied.
+	if rep, err = migrate.Migrate(ctx, db, src, dst, migrate.Options{Verify: true}); err != nil || rep.Copied != 0 || rep.Skipped != 3 || rep.Failed != 2 {
+		t.Fatalf("unexpected report; got %+v and %v, want 3 skipped, none copied and 2 failed", rep, err)
+	}
+}
+
+func TestMigrateCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db := memory.New()
+	if err := db.Create(ctx, databasetest.NewEntry("a")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	cancel()
+	if _, err := migrate.Migrate(ctx, db, memfs.New(), memfs.New(), migrate.Options{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error; got %v, want %v", err, context.Canceled)
+	}
+}
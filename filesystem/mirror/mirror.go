@@ -0,0 +1,298 @@
+// Package mirror implements a kipp filesystem which replicates objects across
+// several file systems.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/filesystem"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// errQuorum is returned when too few file systems are left to reach a quorum.
+var errQuorum = errors.New("too few file systems for a quorum")
+
+// FileSystem writes objects to every file system it mirrors, and reads them
+// from the first which has them, in the order they were given.
+//
+// FileSystem is a prometheus.Collector of the failures of each file system,
+// labelled by its index, so mirrors which are silently falling behind can
+// be noticed.
+type FileSystem struct {
+	fss      []filesystem.FileSystem
+	quorum   int
+	failures *prometheus.CounterVec
+	log      *slog.Logger
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Quorum sets how many file systems must store an object for Create to
+// succeed. Objects are removed from those which fail. The default is all of
+// them.
+func Quorum(n int) Option {
+	return func(fs *FileSystem) error {
+		if n < 1 || n > len(fs.fss) {
+			return fmt.Errorf("invalid quorum %d of %d", n, len(fs.fss))
+		}
+		fs.quorum = n
+		return nil
+	}
+}
+
+// Logger sets the logger failures are logged to. The default is slog.Default.
+func Logger(l *slog.Logger) Option {
+	return func(fs *FileSystem) error {
+		fs.log = l
+		return nil
+	}
+}
+
+// New returns a FileSystem which mirrors fss, in order of priority.
+func New(fss []filesystem.FileSystem, opts ...Option) (*FileSystem, error) {
+	if len(fss) == 0 {
+		return nil, errors.New("no file systems")
+	}
+	fs := &FileSystem{
+		fss:    fss,
+		quorum: len(fss),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "filesystem_mirror",
+			Name:      "failures_total",
+			Help:      "Number of failed calls to each mirrored file system, not counting missing objects.",
+		}, []string{"index", "method"}),
+		log: slog.Default(),
+	}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// Create writes r to every file system at once, succeeding once a quorum of
+// them have stored it. The object is removed from those which fail, or from
+// every file system if there's no quorum. Reading r is paced by the slowest
+// file system.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	pws := make([]*io.PipeWriter, len(fs.fss))
+	errs := make([]error, len(fs.fss))
+	var wg sync.WaitGroup
+	for i, f := range fs.fss {
+		pr, pw := io.Pipe()
+		pws[i] = pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.Create(ctx, name, pr)
+			// Writes would otherwise block forever if it stopped
+			// reading early.
+			pr.CloseWithError(errors.New("create returned"))
+		}()
+	}
+
+	err := fs.tee(pws, r)
+	for _, pw := range pws {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+
+	var stored []int
+	for i, err := range errs {
+		if err == nil {
+			stored = append(stored, i)
+			continue
+		}
+		fs.failed(i, "create", err)
+	}
+	if err == nil && len(stored) < fs.quorum {
+		err = errQuorum
+	}
+	// Failed creates shouldn't leave anything behind, but objects are
+	// removed anyway in case they did.
+	ctx = xcontext.Detach(ctx)
+	for i, f := range fs.fss {
+		if errs[i] == nil && err == nil {
+			continue
+		}
+		if rerr := f.Remove(ctx, name); rerr != nil && !filesystem.IsNotExist(rerr) {
+			fs.failed(i, "remove", rerr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("stored by %d of %d: %w", len(stored), len(fs.fss), errors.Join(append([]error{err}, errs...)...))
+	}
+	return nil
+}
+
+// tee copies r to every writer in pws, dropping writers which fail, until
+// fewer than a quorum are left.
+func (fs *FileSystem) tee(pws []*io.PipeWriter, r io.Reader) error {
+	live := make([]*io.PipeWriter, len(pws))
+	copy(live, pws)
+	n := len(live)
+	buf := make([]byte, 32<<10)
+	for {
+		k, err := r.Read(buf)
+		if k > 0 {
+			for i, pw := range live {
+				if pw == nil {
+					continue
+				}
+				if _, err := pw.Write(buf[:k]); err != nil {
+					// Its error is returned by its Create.
+					live[i] = nil
+					n--
+				}
+			}
+			if n < fs.quorum {
+				return errQuorum
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Open opens the named object from the first file system which has it.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	var errs []error
+	for i, f := range fs.fss {
+		r, err := f.Open(ctx, name)
+		if err == nil {
+			return r, nil
+		}
+		errs = append(errs, err)
+		if !filesystem.IsNotExist(err) {
+			fs.failed(i, "open", err)
+		}
+	}
+	return nil, notExistOr("open", name, errs)
+}
+
+// Remove removes the named object from every file system. It only returns an
+// error wrapping filesystem.ErrNotExist if none of them had it.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	errs := make([]error, len(fs.fss))
+	var wg sync.WaitGroup
+	for i, f := range fs.fss {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = f.Remove(ctx, name); errs[i] != nil && !filesystem.IsNotExist(errs[i]) {
+				fs.failed(i, "remove", errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	removed := false
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			removed = true
+		case !filesystem.IsNotExist(err):
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("remove: %w", errors.Join(failed...))
+	}
+	if !removed {
+		return filesystem.NotExist("remove", name)
+	}
+	return nil
+}
+
+// Stat describes the named object in the first file system which has it.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	var errs []error
+	for i, f := range fs.fss {
+		fi, err := f.Stat(ctx, name)
+		if err == nil {
+			return fi, nil
+		}
+		errs = append(errs, err)
+		if !filesystem.IsNotExist(err) {
+			fs.failed(i, "stat", err)
+		}
+	}
+	return filesystem.FileInfo{}, notExistOr("stat", name, errs)
+}
+
+// Ping pings every file system at once, succeeding if a quorum of them are
+// available, as creates would otherwise fail. File systems which aren't a
+// filesystem.Pinger count as available.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	errs := make([]error, len(fs.fss))
+	var wg sync.WaitGroup
+	for i, f := range fs.fss {
+		p, ok := f.(filesystem.Pinger)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Ping(ctx); err != nil && !errors.Is(err, filesystem.ErrUnsupported) {
+				errs[i] = fmt.Errorf("mirror %d: %w", i, err)
+				fs.failed(i, "ping", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(fs.fss)-len(failed) < fs.quorum {
+		return fmt.Errorf("%w: %w", errQuorum, errors.Join(failed...))
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (fs *FileSystem) Describe(c chan<- *prometheus.Desc) { fs.failures.Describe(c) }
+
+// Collect implements prometheus.Collector.
+func (fs *FileSystem) Collect(c chan<- prometheus.Metric) { fs.failures.Collect(c) }
+
+// failed records the failure of the ith file system.
+func (fs *FileSystem) failed(i int, method string, err error) {
+	fs.failures.WithLabelValues(strconv.Itoa(i), method).Inc()
+	fs.log.Warn("mirror "+method, "index", i, "error", err)
+}
+
+// notExistOr returns an error wrapping filesystem.ErrNotExist if every error
+// in errs does, and the others joined otherwise, as the object may be in the
+// file systems which failed.
+func notExistOr(op, name string, errs []error) error {
+	var failed []error
+	for _, err := range errs {
+		if !filesystem.IsNotExist(err) {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %w", op, errors.Join(failed...))
+	}
+	return filesystem.NotExist(op, name)
+}
@@ -0,0 +1,210 @@
+package mirror_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/mirror"
+)
+
+// flaky is a file system whose calls fail with err, if it's set.
+type flaky struct {
+	*memory.FileSystem
+	err error
+}
+
+func (f *flaky) Create(ctx context.Context, name string, r io.Reader) error {
+	if f.err != nil {
+		// Read some of r, as a backend which failed part way would.
+		io.CopyN(io.Discard, r, 1)
+		return f.err
+	}
+	return f.FileSystem.Create(ctx, name, r)
+}
+
+func (f *flaky) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.FileSystem.Open(ctx, name)
+}
+
+func (f *flaky) Remove(ctx context.Context, name string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.FileSystem.Remove(ctx, name)
+}
+
+func (f *flaky) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	if f.err != nil {
+		return filesystem.FileInfo{}, f.err
+	}
+	return f.FileSystem.Stat(ctx, name)
+}
+
+func TestFileSystem(t *testing.T) {
+	var i interface{} = (*mirror.FileSystem)(nil)
+	if _, ok := i.(filesystem.FileSystem); !ok {
+		t.Fatal("mirror.FileSystem does not implement filesystem.FileSystem")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, err := mirror.New([]filesystem.FileSystem{memory.New(), memory.New()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+// newFlaky returns a mirror of a healthy and a flaky file system.
+func newFlaky(t *testing.T, opts ...mirror.Option) (fs *mirror.FileSystem, healthy *memory.FileSystem, f *flaky) {
+	healthy, f = memory.New(), &flaky{FileSystem: memory.New()}
+	fs, err := mirror.New([]filesystem.FileSystem{healthy, f}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, healthy, f
+}
+
+func TestCreateQuorum(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	fs, healthy, f := newFlaky(t, mirror.Quorum(1), mirror.Logger(slog.New(slog.NewTextHandler(&buf, nil))))
+	f.err = errors.New("flaky")
+	if err := fs.Create(ctx, "a", strings.NewReader("some content")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !strings.Contains(buf.String(), `msg="mirror create" index=1 error=flaky`) {
+		t.Fatalf("failure not logged: %q", buf.String())
+	}
+	if _, err := healthy.Stat(ctx, "a"); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	// Removing what it may have stored fails too.
+	if err := testutil.CollectAndCompare(fs, strings.NewReader(`
+# HELP kipp_filesystem_mirror_failures_total Number of failed calls to each mirrored file system, not counting missing objects.
+# TYPE kipp_filesystem_mirror_failures_total counter
+kipp_filesystem_mirror_failures_total{index="1",method="create"} 1
+kipp_filesystem_mirror_failures_total{index="1",method="remove"} 1
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a quorum, the object is removed from those which stored it.
+	fs, healthy, f = newFlaky(t)
+	f.err = errors.New("flaky")
+	if err := fs.Create(ctx, "a", strings.NewReader("some content")); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := healthy.Stat(ctx, "a"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+}
+
+// TestCreateFail checks nothing is stored when reading fails, even with a
+// quorum.
+func TestCreateFail(t *testing.T) {
+	ctx := context.Background()
+
+	fs, healthy, f := newFlaky(t, mirror.Quorum(1))
+	r := filesystem.PipeReader(func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("read failed")
+	})
+	if err := fs.Create(ctx, "a", r); err == nil {
+		t.Fatal("expected error")
+	}
+	for _, m := range []*memory.FileSystem{healthy, f.FileSystem} {
+		if n := m.Size(); n != 0 {
+			t.Fatalf("unexpected size; got %d, want 0", n)
+		}
+	}
+}
+
+// TestOpenFallback checks objects are read from the next file system when
+// one fails or doesn't have them.
+func TestOpenFallback(t *testing.T) {
+	ctx := context.Background()
+
+	f := &flaky{FileSystem: memory.New()}
+	healthy := memory.New()
+	fs, err := mirror.New([]filesystem.FileSystem{f, healthy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := healthy.Create(ctx, "a", strings.NewReader("some content")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for _, err := range []error{nil, errors.New("flaky")} {
+		f.err = err
+		r, err := fs.Open(ctx, "a")
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != "some content" {
+			t.Fatalf("unexpected content; got %q and %v, want %q", b, err, "some content")
+		}
+		if fi, err := fs.Stat(ctx, "a"); err != nil || fi.Size != 12 {
+			t.Fatalf("unexpected info; got %+v and %v, want size 12", fi, err)
+		}
+	}
+
+	// Missing objects are only reported as such if no file system failed.
+	if _, err := fs.Open(ctx, "missing"); err == nil || filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want a failure", err)
+	}
+	f.err = nil
+	if _, err := fs.Open(ctx, "missing"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ctx := context.Background()
+
+	fs, healthy, f := newFlaky(t)
+	if err := fs.Create(ctx, "a", strings.NewReader("some content")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f.err = errors.New("flaky")
+	if err := fs.Remove(ctx, "a"); err == nil || filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want a failure", err)
+	}
+	if _, err := healthy.Stat(ctx, "a"); !filesystem.IsNotExist(err) {
+		t.Fatalf("not removed from the healthy file system: %v", err)
+	}
+	f.err = nil
+	if err := fs.Remove(ctx, "a"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := fs.Remove(ctx, "a"); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := mirror.New(nil); err == nil {
+		t.Fatal("expected error for no file systems")
+	}
+	for _, n := range []int{0, 3} {
+		if _, err := mirror.New([]filesystem.FileSystem{memory.New(), memory.New()}, mirror.Quorum(n)); err == nil {
+			t.Fatalf("expected error for quorum %d", n)
+		}
+	}
+}
@@ -10,31 +10,43 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
+// reader reads an object, getting it from its offset whenever it's read after
+// seeking.
 type reader struct {
 	ctx          context.Context
 	client       *s3.S3
-	bucket, name string
-	obj          *s3.GetObjectOutput
+	bucket, key  string
+	body         io.ReadCloser
 	offset, size int64
 }
 
-func newReader(ctx context.Context, client *s3.S3, bucket, name string) *reader {
-	return &reader{
+// newReader gets the object with the given key, so its size is known and
+// errors for missing objects are returned up front.
+func newReader(ctx context.Context, client *s3.S3, bucket, key string) (*reader, error) {
+	r := &reader{
 		ctx:    ctx,
 		client: client,
 		bucket: bucket,
-		name:   name,
+		key:    key,
 	}
+	if err := r.reset(); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 func (r *reader) Read(p []byte) (n int, err error) {
-	if r.obj != nil {
-		return r.obj.Body.Read(p)
+	if r.offset >= r.size {
+		return 0, io.EOF
 	}
-	if err := r.reset(); err != nil {
-		return 0, fmt.Errorf("reset: %w", err)
+	if r.body == nil {
+		if err := r.reset(); err != nil {
+			return 0, fmt.Errorf("reset: %w", err)
+		}
 	}
-	return r.Read(p)
+	n, err = r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
 }
 
 func (r *reader) Seek(offset int64, whence int) (int64, error) {
@@ -43,28 +55,31 @@ func (r *reader) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		offset += r.offset
 	case io.SeekEnd:
-		offset = r.size - offset
+		offset += r.size
 	default:
 		return 0, fmt.Errorf("invalid whence: %d", whence)
 	}
 	if offset < 0 {
 		return 0, errors.New("invalid offset")
 	}
-	r.Close()
-	r.obj, r.offset = nil, offset
+	if offset != r.offset {
+		r.Close()
+		r.body, r.offset = nil, offset
+	}
 	return offset, nil
 }
 
 func (r *reader) Close() error {
-	if r.obj == nil {
+	if r.body == nil {
 		return nil
 	}
-	return r.obj.Body.Close()
+	return r.body.Close()
 }
 
+// reset gets the object from the current offset.
 func (r *reader) reset() error {
 	r.Close()
-	in := &s3.GetObjectInput{Bucket: &r.bucket, Key: &r.name}
+	in := &s3.GetObjectInput{Bucket: &r.bucket, Key: &r.key}
 	if r.offset > 0 {
 		in.Range = aws.String(fmt.Sprintf("bytes=%d-", r.offset))
 	}
@@ -72,9 +87,9 @@ func (r *reader) reset() error {
 	if err != nil {
 		return fmt.Errorf("get object: %w", err)
 	}
-	r.obj = obj
-	if r.size == 0 {
-		r.size = *obj.ContentLength
+	r.body = obj.Body
+	if r.offset == 0 {
+		r.size = aws.Int64Value(obj.ContentLength)
 	}
 	return nil
 }
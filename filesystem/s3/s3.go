@@ -2,63 +2,347 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/uhthomas/kipp/filesystem"
 )
 
+const (
+	// maxRetries is how many times requests which fail with a 5xx or are
+	// throttled are retried, unless the config says otherwise.
+	maxRetries = 5
+	// abortTimeout bounds aborting a failed multipart upload, which is done
+	// even once the upload's context is cancelled.
+	abortTimeout = 30 * time.Second
+)
+
 // FileSystem is an abstraction over an s3 bucket which allows for the creation,
-// opening and removal of objects.
+// opening and removal of objects. It works with any S3-compatible service.
 type FileSystem struct {
 	client   *s3.S3
 	uploader *s3manager.Uploader
 	bucket   string
+	prefix   string
+
+	// config is the config of the session, set by options.
+	config *aws.Config
+
+	partSize     int64
+	concurrency  int
+	sse          string
+	kmsKeyID     string
+	storageClass string
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Prefix stores objects under prefix, so a bucket can be shared. A trailing
+// slash is added if it's missing.
+func Prefix(prefix string) Option {
+	return func(fs *FileSystem) error {
+		if prefix = strings.TrimPrefix(prefix, "/"); prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		fs.prefix = prefix
+		return nil
+	}
+}
+
+// PartSize uploads objects larger than size in parts of size bytes, which
+// must be at least 5MiB. An object can have at most 10,000 parts, so it limits
+// the size of objects. The default is 5MiB.
+func PartSize(size int64) Option {
+	return func(fs *FileSystem) error {
+		if size < s3manager.MinUploadPartSize {
+			return fmt.Errorf("part size %d is less than %d", size, s3manager.MinUploadPartSize)
+		}
+		fs.partSize = size
+		return nil
+	}
+}
+
+// Concurrency uploads up to n parts of an object at once. Each part being
+// uploaded is buffered in memory, so an upload buffers up to n parts. The
+// default is 5, and 1 uploads parts one after another.
+func Concurrency(n int) Option {
+	return func(fs *FileSystem) error {
+		if n < 1 {
+			return fmt.Errorf("concurrency %d is less than 1", n)
+		}
+		fs.concurrency = n
+		return nil
+	}
 }
 
-// New creates a new aws session and s3 client.
-func New(bucket string, config *aws.Config) (*FileSystem, error) {
-	sess, err := session.NewSession(config)
+// Endpoint sends requests to the S3-compatible service at endpoint, such as
+// https://minio.example.com, rather than AWS.
+func Endpoint(endpoint string) Option {
+	return func(fs *FileSystem) error {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("parse endpoint: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint %q must be an http or https URL", endpoint)
+		}
+		fs.config.Endpoint = aws.String(endpoint)
+		return nil
+	}
+}
+
+// PathStyle addresses buckets in the path of requests, rather than in the
+// host, which services such as MinIO and Ceph RGW need unless they're set up
+// with wildcard DNS.
+func PathStyle() Option {
+	return func(fs *FileSystem) error {
+		fs.config.S3ForcePathStyle = aws.Bool(true)
+		return nil
+	}
+}
+
+// Region overrides the region of the config.
+func Region(region string) Option {
+	return func(fs *FileSystem) error {
+		if region == "" {
+			return errors.New("region is empty")
+		}
+		fs.config.Region = aws.String(region)
+		return nil
+	}
+}
+
+// SSES3 encrypts objects created by the file system with keys managed by S3.
+func SSES3() Option {
+	return func(fs *FileSystem) error {
+		fs.sse, fs.kmsKeyID = s3.ServerSideEncryptionAes256, ""
+		return nil
+	}
+}
+
+// SSEKMS encrypts objects created by the file system with the KMS key with
+// the given ARN.
+func SSEKMS(keyARN string) Option {
+	return func(fs *FileSystem) error {
+		a, err := arn.Parse(keyARN)
+		if err != nil {
+			return fmt.Errorf("parse kms key: %w", err)
+		}
+		if a.Service != "kms" {
+			return fmt.Errorf("kms key %q isn't a kms ARN", keyARN)
+		}
+		fs.sse, fs.kmsKeyID = s3.ServerSideEncryptionAwsKms, keyARN
+		return nil
+	}
+}
+
+// StorageClass creates objects in the given storage class, such as
+// STANDARD_IA, rather than STANDARD.
+func StorageClass(class string) Option {
+	return func(fs *FileSystem) error {
+		if !slices.Contains(s3.StorageClass_Values(), class) {
+			return fmt.Errorf("unknown storage class %q", class)
+		}
+		fs.storageClass = class
+		return nil
+	}
+}
+
+// New creates a new aws session and s3 client. Credentials not set in config
+// are found with the default chain: the environment, shared config and
+// credential files, then the instance or task role. Options are applied to a
+// copy of config before the session is created.
+func New(bucket string, config *aws.Config, opts ...Option) (*FileSystem, error) {
+	if bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	fs := &FileSystem{bucket: bucket, config: config.Copy()}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	if fs.config.MaxRetries == nil {
+		fs.config.MaxRetries = aws.Int(maxRetries)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *fs.config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("new session: %w", err)
 	}
-	c := s3.New(sess)
-	return &FileSystem{
-		client:   c,
-		uploader: s3manager.NewUploaderWithClient(c),
-		bucket:   bucket,
-	}, nil
+	fs.client = s3.New(sess)
+	fs.uploader = s3manager.NewUploaderWithClient(fs.client, func(u *s3manager.Uploader) {
+		u.PartSize, u.Concurrency = fs.partSize, fs.concurrency
+		// The uploader aborts with the upload's context, which fails
+		// if it was cancelled, so Create aborts instead.
+		u.LeavePartsOnError = true
+	})
+	return fs, nil
 }
 
-// Create writes r to the named s3 bucket/object.
+// Create writes r to the named s3 bucket/object. Objects larger than a part
+// are uploaded in parts, several at once, as r is read. The upload is only
+// completed once every part has been uploaded and r has been read to the end,
+// and its parts are aborted if either fails or ctx is cancelled so they don't
+// accrue charges. Objects are encrypted and stored in the storage class set by
+// the options, if any.
 func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
-	if _, err := fs.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+	key := fs.key(name)
+	in := &s3manager.UploadInput{
 		Body:   r,
-		Bucket: aws.String(fs.bucket),
-		Key:    &name,
-	}); err != nil {
+		Bucket: &fs.bucket,
+		Key:    &key,
+	}
+	if fs.sse != "" {
+		in.ServerSideEncryption = &fs.sse
+	}
+	if fs.kmsKeyID != "" {
+		in.SSEKMSKeyId = &fs.kmsKeyID
+	}
+	if fs.storageClass != "" {
+		in.StorageClass = &fs.storageClass
+	}
+	if _, err := fs.uploader.UploadWithContext(ctx, in); err != nil {
+		var mu s3manager.MultiUploadFailure
+		if errors.As(err, &mu) {
+			if abortErr := fs.abort(ctx, key, mu.UploadID()); abortErr != nil {
+				return fmt.Errorf("upload: %w (%v)", err, abortErr)
+			}
+		}
 		return fmt.Errorf("upload: %w", err)
 	}
 	return nil
 }
 
-// Open gets the object with the specified key, name.
+// abort aborts the multipart upload with the given id, removing its parts.
+func (fs *FileSystem) abort(ctx context.Context, key, id string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+	defer cancel()
+	if _, err := fs.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &fs.bucket,
+		Key:      &key,
+		UploadId: &id,
+	}); err != nil {
+		return fmt.Errorf("abort multipart upload %s: %w", id, err)
+	}
+	return nil
+}
+
+// Open gets the object with the specified key, name. The reader supports
+// seeking, which is done with ranged requests.
 func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
-	r := newReader(ctx, fs.client, fs.bucket, name)
-	return r, nil
+	r, err := newReader(ctx, fs.client, fs.bucket, fs.key(name))
+	if isNotFound(err) {
+		return nil, filesystem.NotExist("open", name)
+	}
+	return r, err
 }
 
 // Remove removes the s3 object specified with key, name, from the bucket.
+// Deleting an object which doesn't exist succeeds in S3, so the object is
+// checked for first to report it as not existing.
 func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	if _, err := fs.Stat(ctx, name); err != nil {
+		if filesystem.IsNotExist(err) {
+			return filesystem.NotExist("remove", name)
+		}
+		return err
+	}
 	if _, err := fs.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: &fs.bucket,
-		Key:    &name,
+		Key:    aws.String(fs.key(name)),
 	}); err != nil {
-		return fmt.Errorf("delete object %s/%s: %w", fs.bucket, name, err)
+		return fmt.Errorf("delete object %s/%s: %w", fs.bucket, fs.key(name), err)
+	}
+	return nil
+}
+
+// Stat describes the named object from its metadata.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	out, err := fs.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &fs.bucket,
+		Key:    aws.String(fs.key(name)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+		}
+		return filesystem.FileInfo{}, fmt.Errorf("head object %s/%s: %w", fs.bucket, fs.key(name), err)
+	}
+	return filesystem.FileInfo{
+		Name:    name,
+		Size:    aws.Int64Value(out.ContentLength),
+		ModTime: aws.TimeValue(out.LastModified),
+		ETag:    strings.Trim(aws.StringValue(out.ETag), `"`),
+	}, nil
+}
+
+// Ping checks the bucket can be reached with a HEAD request.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	if _, err := fs.client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: &fs.bucket}); err != nil {
+		return fmt.Errorf("head bucket %s: %w", fs.bucket, err)
 	}
 	return nil
 }
+
+// Walk calls fn with each object under the prefix. Keys with further slashes
+// are skipped, as they aren't objects of the file system.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	var ferr error
+	if err := fs.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: &fs.bucket,
+		Prefix: aws.String(fs.prefix),
+	}, func(out *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range out.Contents {
+			name := strings.TrimPrefix(aws.StringValue(o.Key), fs.prefix)
+			if strings.Contains(name, "/") {
+				continue
+			}
+			if ferr = fn(filesystem.FileInfo{
+				Name:    name,
+				Size:    aws.Int64Value(o.Size),
+				ModTime: aws.TimeValue(o.LastModified),
+				ETag:    strings.Trim(aws.StringValue(o.ETag), `"`),
+			}); ferr != nil {
+				return false
+			}
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("list objects %s/%s: %w", fs.bucket, fs.prefix, err)
+	}
+	return ferr
+}
+
+// key returns the key of the named object.
+func (fs *FileSystem) key(name string) string { return fs.prefix + name }
+
+// isNotFound reports whether err is from an object which doesn't exist.
+func isNotFound(err error) bool {
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) && rf.StatusCode() == 404 {
+		return true
+	}
+	var ae awserr.Error
+	if errors.As(err, &ae) {
+		switch ae.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
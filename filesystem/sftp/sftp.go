@@ -0,0 +1,427 @@
+// Package sftp implements a kipp filesystem over SFTP.
+package sftp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/uhthomas/kipp/filesystem"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// FileSystem stores objects as files in a directory of an SFTP server. Files
+// are written to a temporary directory inside it first, and renamed into
+// place once complete.
+//
+// Requests are spread over a pool of connections, which are reconnected when
+// they fail, and kept alive while idle so long uploads aren't cut off.
+type FileSystem struct {
+	addr     string
+	config   *ssh.ClientConfig
+	dir, tmp string
+
+	keepalive time.Duration
+	pool      []*conn
+	next      atomic.Uint32
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Dir sets the directory files are stored in, which is the user's home
+// directory by default.
+func Dir(dir string) Option {
+	return func(fs *FileSystem) error {
+		fs.dir = dir
+		return nil
+	}
+}
+
+// Password authenticates with password.
+func Password(password string) Option {
+	return func(fs *FileSystem) error {
+		fs.config.Auth = append(fs.config.Auth, ssh.Password(password))
+		return nil
+	}
+}
+
+// PrivateKey authenticates with the PEM encoded private key.
+func PrivateKey(pem []byte) Option {
+	return func(fs *FileSystem) error {
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return fmt.Errorf("parse private key: %w", err)
+		}
+		fs.config.Auth = append(fs.config.Auth, ssh.PublicKeys(signer))
+		return nil
+	}
+}
+
+// PrivateKeyFile authenticates with the PEM encoded private key in the named
+// file.
+func PrivateKeyFile(name string) Option {
+	return func(fs *FileSystem) error {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return PrivateKey(b)(fs)
+	}
+}
+
+// KnownHosts verifies the server's host key against the named known_hosts
+// files, rather than ~/.ssh/known_hosts.
+func KnownHosts(files ...string) Option {
+	return func(fs *FileSystem) error {
+		cb, err := knownhosts.New(files...)
+		if err != nil {
+			return fmt.Errorf("known hosts: %w", err)
+		}
+		fs.config.HostKeyCallback = cb
+		return nil
+	}
+}
+
+// HostKey verifies the server's host key is key.
+func HostKey(key ssh.PublicKey) Option {
+	return func(fs *FileSystem) error {
+		fs.config.HostKeyCallback = ssh.FixedHostKey(key)
+		return nil
+	}
+}
+
+// InsecureIgnoreHostKey accepts any host key, which leaves connections open
+// to being intercepted. It should only be used for testing.
+func InsecureIgnoreHostKey() Option {
+	return func(fs *FileSystem) error {
+		fs.config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return nil
+	}
+}
+
+// Connections sets how many connections requests are spread over. The
+// default is four.
+func Connections(n int) Option {
+	return func(fs *FileSystem) error {
+		if n < 1 {
+			return fmt.Errorf("invalid connections %d", n)
+		}
+		fs.pool = make([]*conn, n)
+		return nil
+	}
+}
+
+// Keepalive sets the interval at which idle connections are checked, which
+// are reconnected if the server doesn't respond in time. The default is 30
+// seconds.
+func Keepalive(d time.Duration) Option {
+	return func(fs *FileSystem) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid keepalive %v", d)
+		}
+		fs.keepalive = d
+		return nil
+	}
+}
+
+// New connects to the SFTP server at addr as user, and makes the directories
+// files are stored in. The server's host key is verified against
+// ~/.ssh/known_hosts unless another option is given.
+func New(addr, user string, opts ...Option) (*FileSystem, error) {
+	fs := &FileSystem{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:    user,
+			Timeout: 30 * time.Second,
+		},
+		keepalive: 30 * time.Second,
+		pool:      make([]*conn, 4),
+	}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	if fs.config.HostKeyCallback == nil {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("home dir: %w", err)
+		}
+		if err := KnownHosts(filepath.Join(home, ".ssh", "known_hosts"))(fs); err != nil {
+			return nil, err
+		}
+	}
+	for i := range fs.pool {
+		fs.pool[i] = &conn{fs: fs}
+	}
+	fs.tmp = path.Join(fs.dir, "tmp")
+
+	c, err := fs.client()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.MkdirAll(fs.tmp); err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+	return fs, nil
+}
+
+// Create writes r to a temporary file, and renames it into place once all of
+// r is written. Cancelling ctx aborts the upload.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) (err error) {
+	c, err := fs.client()
+	if err != nil {
+		return err
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return err
+	}
+	tmp := path.Join(fs.tmp, "kipp"+hex.EncodeToString(b[:]))
+	f, err := c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			c.Remove(tmp)
+		}
+	}()
+
+	// The SFTP client doesn't take contexts, so the file is closed to
+	// interrupt writes when ctx is done.
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	_, err = io.Copy(f, r)
+	if !stop() {
+		return ctx.Err()
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("copy: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	if err := rename(c, tmp, fs.path(name)); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// rename renames oldname to newname, replacing it if it exists. Servers
+// without the posix-rename extension can't replace files atomically, so
+// existing files are left as they are.
+func rename(c *sftp.Client, oldname, newname string) error {
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		return c.PosixRename(oldname, newname)
+	}
+	if err := c.Rename(oldname, newname); err != nil {
+		if _, serr := c.Stat(newname); serr == nil {
+			c.Remove(oldname)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Open opens the named file. The file supports seeking, which is done by
+// reading from a different offset.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	var f *sftp.File
+	if err := fs.do(func(c *sftp.Client) (err error) {
+		f, err = c.Open(fs.path(name))
+		return err
+	}); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, filesystem.NotExist("open", name)
+		}
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	return f, nil
+}
+
+// Remove removes the named file.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	p := fs.path(name)
+	if err := fs.do(func(c *sftp.Client) error {
+		err := c.Remove(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			// Some servers fail generically for missing files.
+			if _, serr := c.Stat(p); errors.Is(serr, os.ErrNotExist) {
+				return serr
+			}
+		}
+		return err
+	}); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return filesystem.NotExist("remove", name)
+		}
+		return fmt.Errorf("remove: %w", err)
+	}
+	return nil
+}
+
+// Stat describes the named file. Files have no ETag, and their modification
+// times are only accurate to the second.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	var fi os.FileInfo
+	if err := fs.do(func(c *sftp.Client) (err error) {
+		fi, err = c.Stat(fs.path(name))
+		return err
+	}); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+		}
+		return filesystem.FileInfo{}, fmt.Errorf("stat: %w", err)
+	}
+	return filesystem.FileInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Ping checks the temporary directory can be statted. SFTP requests can't be
+// cancelled, so it returns once ctx is done while the request carries on.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fs.do(func(c *sftp.Client) error {
+			_, err := c.Stat(fs.tmp)
+			return err
+		})
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			return fmt.Errorf("stat: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("stat: %w", ctx.Err())
+	}
+}
+
+// Close closes every connection.
+func (fs *FileSystem) Close() error {
+	for _, c := range fs.pool {
+		c.close()
+	}
+	return nil
+}
+
+// path returns the path of the named file.
+func (fs *FileSystem) path(name string) string { return path.Join(fs.dir, name) }
+
+// conn returns the next connection in the pool.
+func (fs *FileSystem) conn() *conn {
+	return fs.pool[int(fs.next.Add(1))%len(fs.pool)]
+}
+
+// client returns a client from the pool.
+func (fs *FileSystem) client() (*sftp.Client, error) { return fs.conn().get() }
+
+// do calls f with a client, retrying once with a new connection if the
+// connection was lost, so requests aren't failed by connections which
+// dropped while idle. f must be idempotent.
+func (fs *FileSystem) do(f func(c *sftp.Client) error) error {
+	cn := fs.conn()
+	c, err := cn.get()
+	if err != nil {
+		return err
+	}
+	if err = f(c); errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		cn.reset(c)
+		if c, err = cn.get(); err != nil {
+			return err
+		}
+		err = f(c)
+	}
+	return err
+}
+
+// A conn is a connection in the pool, which is reconnected when it fails.
+type conn struct {
+	fs *FileSystem
+
+	mu     sync.Mutex
+	ssh    *ssh.Client
+	client *sftp.Client
+}
+
+// get returns the connection's client, connecting if it isn't connected.
+func (c *conn) get() (*sftp.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	sc, err := ssh.Dial("tcp", c.fs.addr, c.fs.config)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	client, err := sftp.NewClient(sc)
+	if err != nil {
+		sc.Close()
+		return nil, fmt.Errorf("new client: %w", err)
+	}
+	c.ssh, c.client = sc, client
+	go c.keepalive(sc, client)
+	return client, nil
+}
+
+// keepalive pings the server every interval, closing the connection if it
+// doesn't respond in time, and forgets the connection once it's closed so
+// the next request reconnects.
+func (c *conn) keepalive(sc *ssh.Client, client *sftp.Client) {
+	done := make(chan struct{})
+	go func() {
+		sc.Wait()
+		close(done)
+	}()
+	t := time.NewTicker(c.fs.keepalive)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			c.reset(client)
+			client.Close()
+			return
+		case <-t.C:
+			ping := time.AfterFunc(c.fs.keepalive, func() { sc.Close() })
+			if _, _, err := sc.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				sc.Close()
+			}
+			ping.Stop()
+		}
+	}
+}
+
+// reset closes and forgets the connection if its client is still client, so
+// the next request reconnects.
+func (c *conn) reset(client *sftp.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == client {
+		c.ssh.Close()
+		c.ssh, c.client = nil, nil
+	}
+}
+
+// close closes the connection, if it's connected.
+func (c *conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ssh != nil {
+		c.ssh.Close()
+	}
+}
@@ -0,0 +1,227 @@
+// Package tier implements a kipp filesystem which stores objects in one of two
+// file systems by their size, such as small pastes on a local disk and large
+// files in S3.
+package tier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/migrate"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// A Tier is one of the file systems of a FileSystem.
+type Tier int
+
+const (
+	// Small holds objects of at most the threshold.
+	Small Tier = iota
+	// Large holds objects larger than the threshold.
+	Large
+)
+
+func (t Tier) String() string {
+	switch t {
+	case Small:
+		return "small"
+	case Large:
+		return "large"
+	}
+	return fmt.Sprintf("Tier(%d)", t)
+}
+
+// FileSystem stores objects of at most a threshold in a small file system,
+// and larger objects in a large one, under the same names.
+//
+// Which tier holds an object isn't recorded anywhere else: the small tier is
+// looked in first, and objects it doesn't have are in the large tier. The
+// small tier should be the cheaper of the two to look in, as every object in
+// the large tier is looked for in it first. If the small tier fails, the
+// large tier is looked in anyway, so large objects can still be read, and
+// failures of the large tier don't affect small objects.
+//
+// FileSystem is a prometheus.Collector of how many objects were created in
+// each tier.
+type FileSystem struct {
+	small, large filesystem.FileSystem
+	threshold    int64
+
+	creates *prometheus.CounterVec
+}
+
+// New returns a FileSystem which stores objects of at most threshold bytes in
+// small, and larger objects in large. Objects are buffered in memory until
+// they're known to be larger than threshold, so it should be small.
+func New(small, large filesystem.FileSystem, threshold int64) *FileSystem {
+	return &FileSystem{
+		small:     small,
+		large:     large,
+		threshold: threshold,
+		creates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "filesystem_tier",
+			Name:      "creates_total",
+			Help:      "Number of objects created, by the tier they were stored in.",
+		}, []string{"tier"}),
+	}
+}
+
+// tier returns the file system of t.
+func (fs *FileSystem) tier(t Tier) filesystem.FileSystem {
+	if t == Small {
+		return fs.small
+	}
+	return fs.large
+}
+
+// Create buffers up to the threshold of r, creating the named object in the
+// small tier if r ends by then, or in the large tier otherwise.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, fs.threshold+1)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("read: %w", err)
+	}
+	t := Small
+	if n > fs.threshold {
+		t, r = Large, io.MultiReader(&buf, r)
+	} else {
+		r = &buf
+	}
+	if err := fs.tier(t).Create(ctx, name, r); err != nil {
+		return fmt.Errorf("%s: %w", t, err)
+	}
+	fs.creates.WithLabelValues(t.String()).Inc()
+	return nil
+}
+
+// Open opens the named object from the tier holding it.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r, err := fs.small.Open(ctx, name)
+	if err == nil {
+		return r, nil
+	}
+	r, lerr := fs.large.Open(ctx, name)
+	return r, join(err, lerr)
+}
+
+// Remove removes the named object from the tier holding it. Objects removed
+// while they're being moved may be left in the tier they're moved to, as
+// orphans.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	err := fs.small.Remove(ctx, name)
+	if err == nil {
+		return nil
+	}
+	return join(err, fs.large.Remove(ctx, name))
+}
+
+// Stat describes the named object in the tier holding it.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	fi, err := fs.small.Stat(ctx, name)
+	if err == nil {
+		return fi, nil
+	}
+	fi, lerr := fs.large.Stat(ctx, name)
+	return fi, join(err, lerr)
+}
+
+// join returns the error of an operation which failed in both tiers, given
+// the error of each. Objects the large tier has are found even if the small
+// tier failed, so its error is only returned if the large tier doesn't have
+// the object either.
+func join(small, large error) error {
+	if large == nil {
+		return nil
+	}
+	if !filesystem.IsNotExist(large) {
+		if filesystem.IsNotExist(small) {
+			return fmt.Errorf("large: %w", large)
+		}
+		return errors.Join(fmt.Errorf("small: %w", small), fmt.Errorf("large: %w", large))
+	}
+	if !filesystem.IsNotExist(small) {
+		return fmt.Errorf("small: %w", small)
+	}
+	return large
+}
+
+// Locate returns the tier holding the named object.
+func (fs *FileSystem) Locate(ctx context.Context, name string) (Tier, error) {
+	_, err := fs.small.Stat(ctx, name)
+	if err == nil {
+		return Small, nil
+	}
+	_, lerr := fs.large.Stat(ctx, name)
+	if lerr == nil {
+		return Large, nil
+	}
+	return 0, join(err, lerr)
+}
+
+// Move moves the named object to tier t, whatever its size, by copying it and
+// then removing it from the other tier. Objects already in t are left as they
+// are. It's read from either tier in the meantime.
+func (fs *FileSystem) Move(ctx context.Context, name string, t Tier) error {
+	from, err := fs.Locate(ctx, name)
+	if err != nil {
+		return fmt.Errorf("locate: %w", err)
+	}
+	if from == t {
+		return nil
+	}
+	if err := migrate.Copy(ctx, fs.tier(from), fs.tier(t), name); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	// The object is in both tiers until it's removed, so it must be removed
+	// even if ctx is done.
+	if err := fs.tier(from).Remove(xcontext.Detach(ctx), name); err != nil && !filesystem.IsNotExist(err) {
+		return fmt.Errorf("remove from %s: %w", from, err)
+	}
+	return nil
+}
+
+// Walk calls fn with each object in both tiers. Both must implement
+// filesystem.Walker. Objects being moved may be visited twice.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	var ws []filesystem.Walker
+	for _, t := range []Tier{Small, Large} {
+		w, ok := fs.tier(t).(filesystem.Walker)
+		if !ok {
+			return fmt.Errorf("walk %s: %w", t, filesystem.ErrUnsupported)
+		}
+		ws = append(ws, w)
+	}
+	for i, w := range ws {
+		if err := w.Walk(ctx, fn); err != nil {
+			return fmt.Errorf("%s: %w", Tier(i), err)
+		}
+	}
+	return nil
+}
+
+// Ping pings both tiers which are a filesystem.Pinger.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	for _, t := range []Tier{Small, Large} {
+		p, ok := fs.tier(t).(filesystem.Pinger)
+		if !ok {
+			continue
+		}
+		if err := p.Ping(ctx); err != nil && !errors.Is(err, filesystem.ErrUnsupported) {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (fs *FileSystem) Describe(c chan<- *prometheus.Desc) { fs.creates.Describe(c) }
+
+// Collect implements prometheus.Collector.
+func (fs *FileSystem) Collect(c chan<- prometheus.Metric) { fs.creates.Collect(c) }
@@ -0,0 +1,163 @@
+package tier_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/tier"
+)
+
+func TestFileSystem(t *testing.T) {
+	var i interface{} = (*tier.FileSystem)(nil)
+	if _, ok := i.(filesystem.FileSystem); !ok {
+		t.Fatal("tier.FileSystem does not implement filesystem.FileSystem")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		// Objects of the conformance tests are in both tiers.
+		return tier.New(memory.New(), memory.New(), 8)
+	})
+}
+
+// read reads all of the named object.
+func read(t *testing.T, fs filesystem.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func TestThreshold(t *testing.T) {
+	ctx := context.Background()
+
+	small, large := memory.New(), memory.New()
+	fs := tier.New(small, large, 4)
+	for _, tt := range []struct {
+		content string
+		want    tier.Tier
+	}{
+		{"", tier.Small},
+		{"abc", tier.Small},
+		{"abcd", tier.Small},
+		{"abcde", tier.Large},
+		{strings.Repeat("a", 1<<20), tier.Large},
+	} {
+		name := "object" + tt.content[:min(len(tt.content), 5)]
+		if err := fs.Create(ctx, name, strings.NewReader(tt.content)); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if got, err := fs.Locate(ctx, name); err != nil || got != tt.want {
+			t.Fatalf("unexpected tier of %d bytes; got %v and %v, want %v", len(tt.content), got, err, tt.want)
+		}
+		if got := read(t, fs, name); got != tt.content {
+			t.Fatalf("unexpected content of %d bytes; got %d bytes", len(tt.content), len(got))
+		}
+	}
+	want := `
+# HELP kipp_filesystem_tier_creates_total Number of objects created, by the tier they were stored in.
+# TYPE kipp_filesystem_tier_creates_total counter
+kipp_filesystem_tier_creates_total{tier="large"} 2
+kipp_filesystem_tier_creates_total{tier="small"} 3
+`
+	if err := testutil.CollectAndCompare(fs, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// broken fails every operation.
+type broken struct{ filesystem.FileSystem }
+
+var errBroken = errors.New("broken")
+
+func (broken) Create(context.Context, string, io.Reader) error { return errBroken }
+
+func (broken) Open(context.Context, string) (filesystem.Reader, error) { return nil, errBroken }
+
+func (broken) Remove(context.Context, string) error { return errBroken }
+
+func (broken) Stat(context.Context, string) (filesystem.FileInfo, error) {
+	return filesystem.FileInfo{}, errBroken
+}
+
+func TestBrokenTier(t *testing.T) {
+	ctx := context.Background()
+
+	for _, brokenTier := range []tier.Tier{tier.Small, tier.Large} {
+		tiers := [2]filesystem.FileSystem{memory.New(), memory.New()}
+		tiers[brokenTier] = broken{}
+		fs := tier.New(tiers[tier.Small], tiers[tier.Large], 4)
+
+		// Objects in the tier which works are unaffected.
+		name, content := "large", "abcdefgh"
+		if brokenTier == tier.Large {
+			name, content = "small", "abc"
+		}
+		if err := fs.Create(ctx, name, strings.NewReader(content)); err != nil {
+			t.Fatalf("create with %v broken: %v", brokenTier, err)
+		}
+		if got := read(t, fs, name); got != content {
+			t.Fatalf("unexpected content with %v broken; got %q, want %q", brokenTier, got, content)
+		}
+		if fi, err := fs.Stat(ctx, name); err != nil || fi.Size != int64(len(content)) {
+			t.Fatalf("unexpected stat with %v broken; got %+v and %v", brokenTier, fi, err)
+		}
+		if err := fs.Remove(ctx, name); err != nil {
+			t.Fatalf("remove with %v broken: %v", brokenTier, err)
+		}
+
+		// Objects in the broken tier fail, rather than being missing.
+		other, otherContent := "small", "abc"
+		if brokenTier == tier.Large {
+			other, otherContent = "large", "abcdefgh"
+		}
+		if err := fs.Create(ctx, other, strings.NewReader(otherContent)); !errors.Is(err, errBroken) {
+			t.Fatalf("unexpected create error with %v broken; got %v, want %v", brokenTier, err, errBroken)
+		}
+		if _, err := fs.Open(ctx, other); !errors.Is(err, errBroken) || filesystem.IsNotExist(err) {
+			t.Fatalf("unexpected open error with %v broken; got %v, want %v", brokenTier, err, errBroken)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+
+	small, large := memory.New(), memory.New()
+	fs := tier.New(small, large, 4)
+	if err := fs.Create(ctx, "a", strings.NewReader("abc")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for _, to := range []tier.Tier{tier.Large, tier.Large, tier.Small} {
+		if err := fs.Move(ctx, "a", to); err != nil {
+			t.Fatalf("move to %v: %v", to, err)
+		}
+		if got, err := fs.Locate(ctx, "a"); err != nil || got != to {
+			t.Fatalf("unexpected tier; got %v and %v, want %v", got, err, to)
+		}
+		if got := read(t, fs, "a"); got != "abc" {
+			t.Fatalf("unexpected content; got %q, want %q", got, "abc")
+		}
+	}
+	if _, err := large.Stat(ctx, "a"); !filesystem.IsNotExist(err) {
+		t.Fatalf("object wasn't removed from the large tier: %v", err)
+	}
+	if err := fs.Move(ctx, "missing", tier.Large); !filesystem.IsNotExist(err) {
+		t.Fatalf("unexpected error; got %v, want %v", err, filesystem.ErrNotExist)
+	}
+}
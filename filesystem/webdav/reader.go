@@ -0,0 +1,107 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// reader reads a file, getting the range from its offset whenever it's read
+// after seeking.
+type reader struct {
+	ctx          context.Context
+	fs           *FileSystem
+	name         string
+	body         io.ReadCloser
+	offset, size int64
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		if err := r.reset(); err != nil {
+			return 0, fmt.Errorf("reset: %w", err)
+		}
+	}
+	n, err = r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.body, r.offset = nil, offset
+	}
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// reset gets the file from the current offset. The first request isn't
+// ranged, so the size of the file is known, including when it's empty.
+func (r *reader) reset() error {
+	r.Close()
+	var h http.Header
+	if r.offset > 0 {
+		h = http.Header{"Range": {"bytes=" + strconv.FormatInt(r.offset, 10) + "-"}}
+	}
+	res, err := r.fs.do(r.ctx, http.MethodGet, r.fs.url(r.name), nil, h)
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		if h == nil {
+			r.size = res.ContentLength
+		}
+		// The server doesn't support ranges, so the body is read up to
+		// the offset.
+		if _, err := io.CopyN(io.Discard, res.Body, r.offset); err != nil {
+			res.Body.Close()
+			return fmt.Errorf("skip: %w", err)
+		}
+	case http.StatusPartialContent:
+	case http.StatusNotFound:
+		res.Body.Close()
+		return errNotFound
+	default:
+		res.Body.Close()
+		return fmt.Errorf("get: %s", res.Status)
+	}
+	r.body = res.Body
+	if r.size < 0 {
+		p, err := r.fs.propfind(r.ctx, r.name)
+		if err != nil {
+			r.Close()
+			return fmt.Errorf("propfind: %w", err)
+		}
+		r.size = p.ContentLength
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+// Package webdav implements a kipp filesystem on a WebDAV server, such as a NAS
+// or Nextcloud.
+package webdav
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/filesystem"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// FileSystem stores objects as files in a WebDAV collection. Files are
+// uploaded to a temporary collection inside it first, and moved into place
+// once complete.
+type FileSystem struct {
+	base      *url.URL
+	client    *http.Client
+	tlsConfig *tls.Config
+	auth      func(r *http.Request)
+	log       *slog.Logger
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// BasicAuth authenticates with username and password.
+func BasicAuth(username, password string) Option {
+	return func(fs *FileSystem) error {
+		fs.auth = func(r *http.Request) { r.SetBasicAuth(username, password) }
+		return nil
+	}
+}
+
+// BearerToken authenticates with token, such as an app password.
+func BearerToken(token string) Option {
+	return func(fs *FileSystem) error {
+		fs.auth = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+		return nil
+	}
+}
+
+// TLSConfig verifies the server with c, such as one with the certificate
+// authority of a self-signed certificate in its RootCAs.
+func TLSConfig(c *tls.Config) Option {
+	return func(fs *FileSystem) error {
+		fs.tlsConfig = c
+		return nil
+	}
+}
+
+// InsecureSkipVerify accepts any certificate, which leaves connections open to
+// being intercepted. It should only be used for testing.
+func InsecureSkipVerify() Option {
+	return TLSConfig(&tls.Config{InsecureSkipVerify: true})
+}
+
+// Client uses c to make requests. TLSConfig is ignored if it's given.
+func Client(c *http.Client) Option {
+	return func(fs *FileSystem) error {
+		fs.client = c
+		return nil
+	}
+}
+
+// Logger sets the logger failures to remove temporary files are logged to.
+// The default is slog.Default.
+func Logger(l *slog.Logger) Option {
+	return func(fs *FileSystem) error {
+		fs.log = l
+		return nil
+	}
+}
+
+// New creates a FileSystem for the collection at rawURL, and makes the
+// collections files are stored in if they don't exist.
+func New(ctx context.Context, rawURL string, opts ...Option) (*FileSystem, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	fs := &FileSystem{base: u, auth: func(*http.Request) {}, log: slog.Default()}
+	for _, opt := range opts {
+		if err := opt(fs); err != nil {
+			return nil, err
+		}
+	}
+	if fs.client == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = fs.tlsConfig
+		fs.client = &http.Client{Transport: t}
+	}
+	for _, p := range []string{"", "tmp/"} {
+		if err := fs.mkcol(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// mkcol makes the collection p, relative to the base collection, unless it
+// exists.
+func (fs *FileSystem) mkcol(ctx context.Context, p string) error {
+	res, err := fs.do(ctx, "MKCOL", fs.url(p), nil, nil)
+	if err != nil {
+		return fmt.Errorf("mkcol: %w", err)
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusCreated:
+		return nil
+	// Servers respond to existing collections with 405 Method Not Allowed,
+	// though some respond as if they were files.
+	case http.StatusMethodNotAllowed, http.StatusConflict:
+		if _, err := fs.propfind(ctx, p); err != nil {
+			return fmt.Errorf("mkcol %s: %s", p, res.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("mkcol %s: %s", p, res.Status)
+}
+
+// Create uploads r to a temporary file with a chunked PUT, and moves it into
+// place once all of r is uploaded.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) (err error) {
+	var b [8]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return err
+	}
+	tmp := fs.url("tmp/kipp" + hex.EncodeToString(b[:]))
+	defer func() {
+		if err != nil {
+			fs.removeTemp(xcontext.Detach(ctx), tmp)
+		}
+	}()
+
+	// Hide any other methods of r, so the request isn't given a length and
+	// is streamed.
+	res, err := fs.do(ctx, http.MethodPut, tmp, struct{ io.Reader }{r}, nil)
+	if err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return fmt.Errorf("put: %s", res.Status)
+	}
+
+	res, err = fs.do(ctx, "MOVE", tmp, nil, http.Header{
+		"Destination": {fs.url(name)},
+		"Overwrite":   {"T"},
+	})
+	if err != nil {
+		return fmt.Errorf("move: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("move: %s", res.Status)
+	}
+	return nil
+}
+
+// removeTemp removes the temporary file of a failed upload. The server may
+// still be handling the upload and have it locked, so it's retried while it
+// is.
+func (fs *FileSystem) removeTemp(ctx context.Context, u string) {
+	for i := 0; ; i++ {
+		err := fs.delete(ctx, u)
+		if errors.Is(err, errLocked) && i < 5 {
+			time.Sleep(100 * time.Millisecond << i)
+			continue
+		}
+		if err != nil && !errors.Is(err, errNotFound) {
+			fs.log.WarnContext(ctx, "remove", "url", u, "error", err)
+		}
+		return
+	}
+}
+
+// Open opens the named file, which is read with ranged GETs from the offset
+// it's seeked to.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r := &reader{ctx: ctx, fs: fs, name: name}
+	if err := r.reset(); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, filesystem.NotExist("open", name)
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// Remove removes the named file.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	if err := fs.delete(ctx, fs.url(name)); err != nil {
+		if errors.Is(err, errNotFound) {
+			return filesystem.NotExist("remove", name)
+		}
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// delete deletes the resource at u.
+func (fs *FileSystem) delete(ctx context.Context, u string) error {
+	res, err := fs.do(ctx, http.MethodDelete, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return errNotFound
+	case http.StatusLocked:
+		return errLocked
+	}
+	return errors.New(res.Status)
+}
+
+// Stat describes the named file from its properties.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	p, err := fs.propfind(ctx, name)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return filesystem.FileInfo{}, filesystem.NotExist("stat", name)
+		}
+		return filesystem.FileInfo{}, fmt.Errorf("propfind: %w", err)
+	}
+	fi := filesystem.FileInfo{Name: name, Size: p.ContentLength, ETag: p.ETag}
+	if fi.ModTime, err = http.ParseTime(p.LastModified); err != nil {
+		return filesystem.FileInfo{}, fmt.Errorf("parse last modified: %w", err)
+	}
+	return fi, nil
+}
+
+// Ping checks the temporary collection can be reached.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	if _, err := fs.propfind(ctx, "tmp/"); err != nil {
+		return fmt.Errorf("propfind: %w", err)
+	}
+	return nil
+}
+
+var (
+	// errNotFound is returned for requests which 404.
+	errNotFound = errors.New("not found")
+	// errLocked is returned for requests to locked resources.
+	errLocked = errors.New("locked")
+)
+
+// prop is the properties of a resource.
+type prop struct {
+	ContentLength int64  `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+}
+
+// propfindBody requests the properties in prop.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><getcontentlength/><getlastmodified/><getetag/></prop></propfind>`
+
+// propfind gets the properties of p, relative to the base collection.
+func (fs *FileSystem) propfind(ctx context.Context, p string) (prop, error) {
+	res, err := fs.do(ctx, "PROPFIND", fs.url(p), strings.NewReader(propfindBody), http.Header{
+		"Content-Type": {"application/xml; charset=utf-8"},
+		"Depth":        {"0"},
+	})
+	if err != nil {
+		return prop{}, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusMultiStatus:
+	case http.StatusNotFound:
+		return prop{}, errNotFound
+	default:
+		return prop{}, errors.New(res.Status)
+	}
+	var ms struct {
+		Responses []struct {
+			Propstats []struct {
+				Prop   prop   `xml:"DAV: prop"`
+				Status string `xml:"DAV: status"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return prop{}, fmt.Errorf("decode: %w", err)
+	}
+	if len(ms.Responses) == 0 {
+		return prop{}, errors.New("no response")
+	}
+	// Properties the resource doesn't have, such as the length of a
+	// collection, are in their own propstat with a 404 status.
+	var pr prop
+	for _, ps := range ms.Responses[0].Propstats {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		if ps.Prop.LastModified != "" {
+			pr.LastModified = ps.Prop.LastModified
+		}
+		if ps.Prop.ETag != "" {
+			pr.ETag = ps.Prop.ETag
+		}
+		if ps.Prop.ContentLength != 0 {
+			pr.ContentLength = ps.Prop.ContentLength
+		}
+	}
+	return pr, nil
+}
+
+// url returns the URL of p, relative to the base collection.
+func (fs *FileSystem) url(p string) string {
+	u := fs.base.JoinPath(p)
+	if (p == "" || strings.HasSuffix(p, "/")) && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// do makes a request with the method to u.
+func (fs *FileSystem) do(ctx context.Context, method, u string, body io.Reader, h http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	fs.auth(req)
+	res, err := fs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, u, res.Status)
+	}
+	return res, nil
+}
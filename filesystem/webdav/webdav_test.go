@@ -0,0 +1,166 @@
+package webdav_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/webdav"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+func TestFileSystem(t *testing.T) {
+	var i interface{} = (*webdav.FileSystem)(nil)
+	if _, ok := i.(filesystem.FileSystem); !ok {
+		t.Fatal("webdav.FileSystem does not implement fs.FileSystem")
+	}
+}
+
+// handler returns a WebDAV handler serving fs at /dav, which accepts either
+// the user "kipp" with password "kipp", or the bearer token "token".
+func handler(fs xwebdav.FileSystem) http.Handler {
+	h := &xwebdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: fs,
+		LockSystem: xwebdav.NewMemLS(),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !(ok && u == "kipp" && p == "kipp") && r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func open(t *testing.T, h http.Handler) *webdav.FileSystem {
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	fs, err := webdav.New(context.Background(), s.URL+"/dav/kipp", webdav.BasicAuth("kipp", "kipp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		// The in-memory file system doesn't set the modification times
+		// of empty files.
+		return open(t, handler(xwebdav.Dir(t.TempDir())))
+	})
+}
+
+// TestSeekWithoutRanges checks seeking works with servers which ignore
+// ranges.
+func TestSeekWithoutRanges(t *testing.T) {
+	ctx := context.Background()
+	h := handler(xwebdav.NewMemFS())
+	fs := open(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Range")
+		h.ServeHTTP(w, r)
+	}))
+	if err := fs.Create(ctx, "a", strings.NewReader("some content")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	f, err := fs.Open(ctx, "a")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Seeker).Seek(5, io.SeekStart); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	if b, err := io.ReadAll(f); err != nil || string(b) != "content" {
+		t.Fatalf("read all; got %q and %v, want %q", b, err, "content")
+	}
+}
+
+// TestCreateFailTemp checks failed uploads don't leave temporary files.
+func TestCreateFailTemp(t *testing.T) {
+	ctx := context.Background()
+	mfs := xwebdav.NewMemFS()
+	h := handler(mfs)
+	// The upload fails once the server is reading it, so it's created the
+	// temporary file.
+	reading := make(chan struct{})
+	var once sync.Once
+	fs := open(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			r.Body = readFunc{r.Body, func() { once.Do(func() { close(reading) }) }}
+		}
+		h.ServeHTTP(w, r)
+	}))
+
+	r := filesystem.PipeReader(func(w io.Writer) error {
+		io.WriteString(w, "some content")
+		<-reading
+		return errors.New("some error")
+	})
+	if err := fs.Create(ctx, "a", r); err == nil {
+		t.Fatal("expected error")
+	}
+	d, err := mfs.OpenFile(ctx, "/kipp/tmp", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if fis, err := d.Readdir(-1); err != nil || len(fis) > 0 {
+		t.Fatalf("unexpected temporary files; got %d and %v, want none", len(fis), err)
+	}
+}
+
+// readFunc calls f before each read.
+type readFunc struct {
+	io.ReadCloser
+	f func()
+}
+
+func (r readFunc) Read(p []byte) (int, error) {
+	r.f()
+	return r.ReadCloser.Read(p)
+}
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+	s := httptest.NewServer(handler(xwebdav.NewMemFS()))
+	defer s.Close()
+
+	if _, err := webdav.New(ctx, s.URL+"/dav/kipp", webdav.BasicAuth("kipp", "wrong")); err == nil {
+		t.Fatal("expected error for the wrong password")
+	}
+	if _, err := webdav.New(ctx, s.URL+"/dav/kipp", webdav.BearerToken("token")); err != nil {
+		t.Fatalf("new with bearer token: %v", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ctx := context.Background()
+	s := httptest.NewTLSServer(handler(xwebdav.NewMemFS()))
+	defer s.Close()
+
+	if _, err := webdav.New(ctx, s.URL+"/dav/kipp", webdav.BasicAuth("kipp", "kipp")); err == nil {
+		t.Fatal("expected error for an unknown certificate authority")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	if _, err := webdav.New(ctx, s.URL+"/dav/kipp",
+		webdav.BasicAuth("kipp", "kipp"),
+		webdav.TLSConfig(&tls.Config{RootCAs: pool}),
+	); err != nil {
+		t.Fatalf("new with root CAs: %v", err)
+	}
+	if _, err := webdav.New(ctx, s.URL+"/dav/kipp", webdav.BasicAuth("kipp", "kipp"), webdav.InsecureSkipVerify()); err != nil {
+		t.Fatalf("new skipping verification: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// reader decompresses an object, a frame at a time.
+type reader struct {
+	f   filesystem.ReadSeeker
+	dec *zstd.Decoder
+	// raw is whether the object is stored as it is, in which case f is
+	// read from directly, and seek whether f must be seeked to offset
+	// first.
+	raw, seek bool
+	// offsets are where each frame starts, compressed and decompressed,
+	// followed by where the last ends.
+	offsets      []frameOffset
+	offset, size int64
+	// frame is the decompressed frame at index i, if any, and buf holds
+	// the compressed frame.
+	frame, buf []byte
+	i          int
+}
+
+type frameOffset struct{ compressed, decompressed int64 }
+
+// newReader reads the header of f, and the seek table if f is compressed.
+func (fs *FileSystem) newReader(f filesystem.ReadSeeker) (*reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: short header", ErrInvalid)
+		}
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalid)
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	r := &reader{f: f, dec: fs.dec, i: -1}
+	switch header[len(magic)] {
+	case modeRaw:
+		r.raw, r.seek, r.size = true, true, end-int64(headerSize)
+		return r, nil
+	case modeSeekable:
+	default:
+		return nil, fmt.Errorf("%w: bad mode", ErrInvalid)
+	}
+
+	if end < int64(headerSize)+8+footerSize {
+		return nil, fmt.Errorf("%w: truncated", ErrInvalid)
+	}
+	footer := make([]byte, footerSize)
+	if _, err := f.Seek(end-footerSize, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	if _, err := io.ReadFull(f, footer); err != nil {
+		return nil, fmt.Errorf("read footer: %w", err)
+	}
+	frames := int64(binary.LittleEndian.Uint32(footer))
+	if footer[4] != 0 || binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, fmt.Errorf("%w: bad footer", ErrInvalid)
+	}
+	tableSize := 8 + frames*8 + footerSize
+	if end-int64(headerSize) < tableSize {
+		return nil, fmt.Errorf("%w: truncated", ErrInvalid)
+	}
+	table := make([]byte, tableSize-footerSize)
+	if _, err := f.Seek(end-tableSize, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	if _, err := io.ReadFull(f, table); err != nil {
+		return nil, fmt.Errorf("read seek table: %w", err)
+	}
+	if binary.LittleEndian.Uint32(table) != seekTableMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize-8 {
+		return nil, fmt.Errorf("%w: bad seek table", ErrInvalid)
+	}
+
+	r.offsets = make([]frameOffset, 0, frames+1)
+	o := frameOffset{compressed: int64(headerSize)}
+	var maxFrame int64
+	for b := table[8:]; len(b) > 0; b = b[8:] {
+		r.offsets = append(r.offsets, o)
+		c, d := int64(binary.LittleEndian.Uint32(b)), int64(binary.LittleEndian.Uint32(b[4:]))
+		o.compressed += c
+		o.decompressed += d
+		maxFrame = max(maxFrame, c, d)
+	}
+	if o.compressed != end-tableSize {
+		return nil, fmt.Errorf("%w: seek table doesn't match frames", ErrInvalid)
+	}
+	r.offsets = append(r.offsets, o)
+	r.size = o.decompressed
+	r.buf = make([]byte, 0, maxFrame)
+	return r, nil
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.raw {
+		if r.seek {
+			if _, err := r.f.Seek(int64(headerSize)+r.offset, io.SeekStart); err != nil {
+				return 0, fmt.Errorf("seek: %w", err)
+			}
+			r.seek = false
+		}
+		n, err = r.f.Read(p)
+		r.offset += int64(n)
+		return n, err
+	}
+	// The frame containing offset is the last to start at or before it.
+	i := sort.Search(len(r.offsets), func(i int) bool { return r.offsets[i].decompressed > r.offset }) - 1
+	if i != r.i {
+		if err := r.load(i); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, r.frame[r.offset-r.offsets[i].decompressed:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// load reads and decompresses the ith frame.
+func (r *reader) load(i int) error {
+	r.i = -1
+	start, end := r.offsets[i], r.offsets[i+1]
+	if _, err := r.f.Seek(start.compressed, io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+	b := r.buf[:end.compressed-start.compressed]
+	if _, err := io.ReadFull(r.f, b); err != nil {
+		return fmt.Errorf("read frame %d: %w", i, err)
+	}
+	frame, err := r.dec.DecodeAll(b, r.frame[:0])
+	if err != nil {
+		return fmt.Errorf("%w: frame %d: %v", ErrInvalid, i, err)
+	}
+	if int64(len(frame)) != end.decompressed-start.decompressed {
+		return fmt.Errorf("%w: frame %d has the wrong size", ErrInvalid, i)
+	}
+	r.frame, r.i = frame, i
+	return nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	if offset != r.offset {
+		r.offset, r.seek = offset, r.raw
+	}
+	return offset, nil
+}
+
+func (r *reader) Close() error { return r.f.Close() }
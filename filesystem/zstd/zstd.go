@@ -0,0 +1,259 @@
+// Package zstd implements a file system which compresses objects with zstd.
+//
+// Objects are compressed in independent frames followed by a seek table, in
+// the zstd seekable format, so readers can seek by decompressing only the
+// frames they read. Objects which don't compress well are stored as they
+// are, so they cost nothing to read.
+package zstd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+const (
+	// magic identifies objects written by this package, and the version of
+	// the format. It's followed by the mode the object is stored in.
+	magic      = "kippzst1"
+	headerSize = len(magic) + 1
+
+	// modeRaw objects are stored as they are, and modeSeekable objects
+	// are in the zstd seekable format.
+	modeRaw, modeSeekable = 0, 1
+
+	// frameSize is the default size of decompressed frames, and
+	// maxFrameSize the largest they can be.
+	frameSize, maxFrameSize = 1 << 20, 64 << 20
+	// sampleSize is how much of each object is compressed to decide
+	// whether it's worth compressing.
+	sampleSize = 64 << 10
+	// minRatio is the most a sample can be compressed to, relative to its
+	// size, for objects to be compressed.
+	minRatio = 0.9
+
+	// seekTableMagic and seekableMagic are the magic numbers of the
+	// skippable frame containing the seek table, and of its footer.
+	seekTableMagic = 0x184D2A5E
+	seekableMagic  = 0x8F92EAB1
+	// footerSize is the size of the seek table's footer: the number of
+	// frames, the descriptor and the magic number.
+	footerSize = 4 + 1 + 4
+	// seekTableSize is the size of the seek table of a single frame.
+	seekTableSize = 8 + 8 + footerSize
+)
+
+// ErrInvalid is returned when an object wasn't written by this package, or is
+// corrupt.
+var ErrInvalid = errors.New("invalid compressed object")
+
+// FileSystem compresses objects in the file system it wraps.
+type FileSystem struct {
+	fs        filesystem.FileSystem
+	enc       *zstd.Encoder
+	dec       *zstd.Decoder
+	level     zstd.EncoderLevel
+	frameSize int
+}
+
+// An Option configures a FileSystem.
+type Option func(fs *FileSystem) error
+
+// Level sets the compression level, which defaults to zstd.SpeedDefault.
+func Level(l zstd.EncoderLevel) Option {
+	return func(fs *FileSystem) error {
+		if l < zstd.SpeedFastest || l > zstd.SpeedBestCompression {
+			return fmt.Errorf("invalid level %d", l)
+		}
+		fs.level = l
+		return nil
+	}
+}
+
+// FrameSize sets the size of the decompressed frames objects are compressed
+// in. Larger frames compress better, but readers decompress whole frames when
+// they seek.
+func FrameSize(n int) Option {
+	return func(fs *FileSystem) error {
+		if n < 4<<10 || n > maxFrameSize {
+			return fmt.Errorf("invalid frame size %d", n)
+		}
+		fs.frameSize = n
+		return nil
+	}
+}
+
+// New returns a FileSystem which compresses objects in fs.
+func New(fs filesystem.FileSystem, opts ...Option) (*FileSystem, error) {
+	zfs := &FileSystem{fs: fs, level: zstd.SpeedDefault, frameSize: frameSize}
+	for _, opt := range opts {
+		if err := opt(zfs); err != nil {
+			return nil, err
+		}
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zfs.level))
+	if err != nil {
+		return nil, fmt.Errorf("new encoder: %w", err)
+	}
+	// Frames are decompressed whole, so the largest frame size bounds
+	// memory, which still reads objects written with larger frames.
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxFrameSize))
+	if err != nil {
+		return nil, fmt.Errorf("new decoder: %w", err)
+	}
+	zfs.enc, zfs.dec = enc, dec
+	return zfs, nil
+}
+
+// Create compresses r and stores it as the named object. The start of r is
+// compressed first, and if it doesn't compress well r is stored as it is.
+func (fs *FileSystem) Create(ctx context.Context, name string, r io.Reader) error {
+	return fs.fs.Create(ctx, name, filesystem.PipeReader(func(w io.Writer) error {
+		return fs.compress(w, r)
+	}))
+}
+
+// compress writes a header and r, compressed if it's worth it, to w.
+func (fs *FileSystem) compress(w io.Writer, r io.Reader) error {
+	buf := make([]byte, fs.frameSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := err != nil
+
+	// Small objects may not save enough to pay for the seek table.
+	sample := buf[:min(n, sampleSize)]
+	if len(sample) == 0 || float64(len(fs.enc.EncodeAll(sample, nil))+seekTableSize) > minRatio*float64(len(sample)) {
+		if _, err := w.Write(append([]byte(magic), modeRaw)); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	if _, err := w.Write(append([]byte(magic), modeSeekable)); err != nil {
+		return err
+	}
+	var table []byte
+	var frame []byte
+	for {
+		frame = fs.enc.EncodeAll(buf[:n], frame[:0])
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(frame)))
+		table = binary.LittleEndian.AppendUint32(table, uint32(n))
+		if last {
+			break
+		}
+		if n, err = io.ReadFull(r, buf); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	frames := len(table) / 8
+	b := binary.LittleEndian.AppendUint32(nil, seekTableMagic)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(table)+footerSize))
+	b = append(b, table...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(frames))
+	b = append(b, 0) // No checksums; zstd frames have their own.
+	b = binary.LittleEndian.AppendUint32(b, seekableMagic)
+	_, err = w.Write(b)
+	return err
+}
+
+// Open opens the named object, which is decompressed as it's read. Objects are
+// seeked to work out their sizes, so the file system fs wraps must be able to
+// seek them.
+func (fs *FileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r, err := fs.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the named object of the file system fs wraps, and reads its
+// header.
+func (fs *FileSystem) open(ctx context.Context, name string) (*reader, error) {
+	f, err := fs.fs.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rs, ok := f.(filesystem.ReadSeeker)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("%s: can't seek: %w", name, filesystem.ErrUnsupported)
+	}
+	r, err := fs.newReader(rs)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return r, nil
+}
+
+// Remove removes the named object.
+func (fs *FileSystem) Remove(ctx context.Context, name string) error {
+	return fs.fs.Remove(ctx, name)
+}
+
+// Stat describes the named object. Its size is decompressed, which is worked
+// out from the object's header and seek table, so they're read.
+func (fs *FileSystem) Stat(ctx context.Context, name string) (filesystem.FileInfo, error) {
+	fi, err := fs.fs.Stat(ctx, name)
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	r, err := fs.open(ctx, name)
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	defer r.Close()
+	fi.Size = r.size
+	return fi, nil
+}
+
+// Walk calls fn with each object of the file system fs wraps, returning an
+// error wrapping filesystem.ErrUnsupported if it isn't a filesystem.Walker.
+// Sizes are of the compressed objects.
+func (fs *FileSystem) Walk(ctx context.Context, fn func(fi filesystem.FileInfo) error) error {
+	w, ok := fs.fs.(filesystem.Walker)
+	if !ok {
+		return fmt.Errorf("walk: %w", filesystem.ErrUnsupported)
+	}
+	return w.Walk(ctx, fn)
+}
+
+// Ping pings the file system fs wraps, returning an error wrapping
+// filesystem.ErrUnsupported if it isn't a filesystem.Pinger.
+func (fs *FileSystem) Ping(ctx context.Context) error {
+	p, ok := fs.fs.(filesystem.Pinger)
+	if !ok {
+		return fmt.Errorf("ping: %w", filesystem.ErrUnsupported)
+	}
+	return p.Ping(ctx)
+}
+
+// StoredSize returns the size of the named object in the file system fs
+// wraps, which is how much space it takes up.
+func (fs *FileSystem) StoredSize(ctx context.Context, name string) (int64, error) {
+	fi, err := fs.fs.Stat(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size, nil
+}
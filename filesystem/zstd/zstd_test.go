@@ -0,0 +1,273 @@
+package zstd_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	kzstd "github.com/klauspost/compress/zstd"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/filesystemtest"
+	"github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/zstd"
+)
+
+const frameSize = 4 << 10
+
+// open returns a compressed file system, and the file system it wraps.
+func open(t testing.TB, opts ...zstd.Option) (*zstd.FileSystem, *memory.FileSystem) {
+	mfs := memory.New()
+	fs, err := zstd.New(mfs, append([]zstd.Option{zstd.FrameSize(frameSize)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, mfs
+}
+
+// logs returns n bytes of JSON log lines, which compress well.
+func logs(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, `{"level":"info","ts":%d,"msg":"request","method":"GET","path":"/files/%d","status":200}`+"\n", 1600000000+i, i%997)
+	}
+	return b.Bytes()[:n]
+}
+
+// random returns n random bytes, which don't compress.
+func random(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func TestFileSystem(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, _ := open(t)
+		return fs
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := open(t)
+
+	for _, tt := range []struct {
+		name       string
+		content    []byte
+		compressed bool
+	}{
+		{name: "empty"},
+		{name: "small logs", content: logs(100)},
+		{name: "some logs", content: logs(1000), compressed: true},
+		{name: "logs", content: logs(10*frameSize + 17), compressed: true},
+		{name: "frame of logs", content: logs(frameSize), compressed: true},
+		{name: "random", content: random(3*frameSize + 5)},
+	} {
+		if err := fs.Create(ctx, tt.name, bytes.NewReader(tt.content)); err != nil {
+			t.Fatalf("create %s: %v", tt.name, err)
+		}
+		stored, err := fs.StoredSize(ctx, tt.name)
+		if err != nil {
+			t.Fatalf("stored size %s: %v", tt.name, err)
+		}
+		if compressed := stored < int64(len(tt.content)); compressed != tt.compressed {
+			t.Fatalf("unexpected compression for %s; stored %d of %d bytes", tt.name, stored, len(tt.content))
+		}
+
+		f, err := fs.Open(ctx, tt.name)
+		if err != nil {
+			t.Fatalf("open %s: %v", tt.name, err)
+		}
+		if size, err := f.(io.Seeker).Seek(0, io.SeekEnd); err != nil || size != int64(len(tt.content)) {
+			t.Fatalf("size of %s; got %d and %v, want %d", tt.name, size, err, len(tt.content))
+		}
+		if _, err := f.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, tt.content) {
+			t.Fatalf("read all %s; got %d bytes and %v, want %d", tt.name, len(got), err, len(tt.content))
+		}
+		f.Close()
+	}
+}
+
+// TestSeek checks reads starting in the middle of frames, and spanning them,
+// for both compressed and raw objects.
+func TestSeek(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := open(t)
+
+	for name, content := range map[string][]byte{
+		"logs":   logs(5*frameSize + 123),
+		"random": random(5*frameSize + 123),
+	} {
+		if err := fs.Create(ctx, name, bytes.NewReader(content)); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		f, err := fs.Open(ctx, name)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		for _, tt := range []struct{ offset, n int64 }{
+			{offset: 3*frameSize + 17, n: 100},
+			{offset: frameSize - 10, n: 20},
+			{offset: 10, n: 3 * frameSize},
+			{offset: 5*frameSize + 100, n: 23},
+		} {
+			if _, err := f.(io.Seeker).Seek(tt.offset, io.SeekStart); err != nil {
+				t.Fatalf("seek: %v", err)
+			}
+			got := make([]byte, tt.n)
+			if _, err := io.ReadFull(f, got); err != nil || !bytes.Equal(got, content[tt.offset:tt.offset+tt.n]) {
+				t.Fatalf("read %d bytes of %s at %d; got %v", tt.n, name, tt.offset, err)
+			}
+		}
+		f.Close()
+	}
+}
+
+// TestSeekable checks compressed objects are in the zstd seekable format
+// after the header, which any zstd decoder can decompress.
+func TestSeekable(t *testing.T) {
+	ctx := context.Background()
+	fs, mfs := open(t)
+
+	content := logs(3*frameSize + 5)
+	if err := fs.Create(ctx, "a", bytes.NewReader(content)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	raw := read(t, mfs, "a")
+	dec, err := kzstd.NewReader(bytes.NewReader(raw[len("kippzst1")+1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if got, err := io.ReadAll(dec); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("decode; got %d bytes and %v, want %d", len(got), err, len(content))
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	ctx := context.Background()
+	fs, mfs := open(t)
+
+	if err := fs.Create(ctx, "a", bytes.NewReader(logs(3*frameSize))); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	raw := read(t, mfs, "a")
+	for name, b := range map[string][]byte{
+		"plain":     logs(100),
+		"truncated": raw[:len(raw)-1],
+		"frame": func() []byte {
+			b := bytes.Clone(raw)
+			b[len("kippzst1")+20] ^= 0xff
+			return b
+		}(),
+	} {
+		if err := mfs.Create(ctx, name, bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Open(ctx, name)
+		if err == nil {
+			_, err = io.ReadAll(f)
+			f.Close()
+		}
+		if !errors.Is(err, zstd.ErrInvalid) {
+			t.Fatalf("unexpected error for %s; got %v, want %v", name, err, zstd.ErrInvalid)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, opt := range []zstd.Option{
+		zstd.FrameSize(1),
+		zstd.FrameSize(1 << 30),
+		zstd.Level(kzstd.EncoderLevel(42)),
+	} {
+		if _, err := zstd.New(memory.New(), opt); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+}
+
+func read(t testing.TB, fs filesystem.FileSystem, name string) []byte {
+	t.Helper()
+	f, err := fs.Open(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// payloads returns representative payloads for benchmarks.
+func payloads() []struct {
+	name    string
+	content []byte
+} {
+	return []struct {
+		name    string
+		content []byte
+	}{
+		{"logs", logs(16 << 20)},
+		{"random", random(16 << 20)},
+	}
+}
+
+func BenchmarkCreate(b *testing.B) {
+	ctx := context.Background()
+	for _, p := range payloads() {
+		b.Run(p.name, func(b *testing.B) {
+			fs, err := zstd.New(memory.New())
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(p.content)))
+			for i := 0; i < b.N; i++ {
+				if err := fs.Create(ctx, "a", bytes.NewReader(p.content)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			stored, err := fs.StoredSize(ctx, "a")
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(len(p.content))/float64(stored), "ratio")
+		})
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	ctx := context.Background()
+	for _, p := range payloads() {
+		b.Run(p.name, func(b *testing.B) {
+			fs, err := zstd.New(memory.New())
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := fs.Create(ctx, "a", bytes.NewReader(p.content)); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(p.content)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := fs.Open(ctx, "a")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, f); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
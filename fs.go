@@ -1,8 +1,11 @@
 package kipp
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"os"
+	"syscall"
 	"time"
 
 	"github.com/uhthomas/kipp/database"
@@ -14,6 +17,11 @@ type fileSystemFunc func(string) (http.File, error)
 
 func (f fileSystemFunc) Open(name string) (http.File, error) { return f(name) }
 
+var (
+	errNoSysfile   = errors.New("not backed by an os file")
+	errNotSeekable = errors.New("can't seek")
+)
+
 type file struct {
 	filesystem.Reader
 	entry database.Entry
@@ -23,6 +31,44 @@ func (f *file) Readdir(int) ([]os.FileInfo, error) { return nil, nil }
 
 func (f *file) Stat() (os.FileInfo, error) { return &fileInfo{entry: f.entry}, nil }
 
+// Seek seeks the underlying reader, if it can. Readers which can't are only
+// served whole, so http.ServeContent never seeks them.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.Reader.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, errNotSeekable
+}
+
+// SyscallConn implements syscall.Conn, which allows net/http to use sendfile
+// when the file is backed by an *os.File.
+func (f *file) SyscallConn() (syscall.RawConn, error) {
+	if sf := f.sysfile(); sf != nil {
+		return sf.SyscallConn()
+	}
+	return nil, errNoSysfile
+}
+
+// WriteTo implements io.WriterTo, passing through to the underlying reader
+// where possible.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := f.Reader.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.Copy(w, struct{ io.Reader }{f.Reader})
+}
+
+// sysfile returns the *os.File backing f, or nil if there isn't one.
+func (f *file) sysfile() *os.File {
+	switch r := f.Reader.(type) {
+	case *os.File:
+		return r
+	case filesystem.Sysfiler:
+		return r.Sysfile()
+	}
+	return nil
+}
+
 type fileInfo struct{ entry database.Entry }
 
 func (fi *fileInfo) Name() string { return fi.entry.Name }
@@ -36,3 +82,27 @@ func (fi *fileInfo) IsDir() bool { return false }
 func (fi *fileInfo) Sys() interface{} { return nil }
 
 func (fi *fileInfo) ModTime() time.Time { return fi.entry.Timestamp }
+
+// streamWriter replaces the Accept-Ranges header set by http.ServeContent with
+// none once stream is set, for files which can't seek, so ranges of them
+// can't be served.
+type streamWriter struct {
+	http.ResponseWriter
+	stream bool
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	if w.stream {
+		w.Header().Set("Accept-Ranges", "none")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// ReadFrom passes through to the underlying http.ResponseWriter, so sendfile
+// can still be used.
+func (w *streamWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *streamWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+package kipp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// BenchmarkFileServe compares serving a large file with and without access
+// to the underlying *os.File, which decides whether net/http can use
+// sendfile. It reports the CPU time spent by the process per operation.
+func BenchmarkFileServe(b *testing.B) {
+	const size = 512 << 20
+
+	name := filepath.Join(b.TempDir(), "file")
+	f, err := os.Create(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bb := range []struct {
+		name string
+		wrap func(*os.File) filesystem.Reader
+	}{
+		{name: "sendfile", wrap: func(f *os.File) filesystem.Reader { return f }},
+		{name: "copy", wrap: func(f *os.File) filesystem.Reader {
+			return struct{ filesystem.Reader }{f}
+		}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			srv := httptest.NewServer(http.FileServer(fileSystemFunc(func(string) (http.File, error) {
+				f, err := os.Open(name)
+				if err != nil {
+					return nil, err
+				}
+				return &file{
+					Reader: bb.wrap(f),
+					entry:  database.Entry{Name: "file", Size: size, Timestamp: time.Now()},
+				}, nil
+			})))
+			defer srv.Close()
+
+			b.SetBytes(size)
+			b.ResetTimer()
+
+			start := cpuTime(b)
+			for i := 0; i < b.N; i++ {
+				res, err := http.Get(srv.URL + "/file")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, res.Body); err != nil {
+					b.Fatal(err)
+				}
+				res.Body.Close()
+			}
+			b.ReportMetric(float64(cpuTime(b)-start)/float64(b.N), "cpu-ns/op")
+		})
+	}
+}
+
+func cpuTime(b *testing.B) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -65,7 +66,7 @@ func TestFileStat(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if got, want := fi.(*fileInfo).entry, e; got != want {
+	if got, want := fi.(*fileInfo).entry, e; !reflect.DeepEqual(got, want) {
 		t.Fatalf("entries are not equal; got %#v, want %#v", got, want)
 	}
 }
@@ -0,0 +1,80 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// Live responds with 200 OK, unless the server is shutting down, in which
+// case it responds with 503 Service Unavailable. Nothing else is checked, so
+// a server whose database is down isn't restarted.
+func (s Server) Live(w http.ResponseWriter, r *http.Request) {
+	if s.lifecycle.isDraining() {
+		s.draining(w, r, "shutting down")
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// Ready responds with 200 OK if the database and file system can be pinged
+// within a second, and with 503 Service Unavailable otherwise, or if the
+// server is shutting down. The body has a line for each, naming what failed.
+func (s Server) Ready(w http.ResponseWriter, r *http.Request) {
+	// The database and file system are closed once requests have drained,
+	// so they're only pinged by requests which are waited for.
+	if !s.lifecycle.enter() {
+		s.draining(w, r, "server: shutting down")
+		return
+	}
+	defer s.lifecycle.exit()
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	// The file system is pinged alongside the database, so they share the
+	// second.
+	fsErr := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { fsErr <- err }()
+		defer s.recoverTo(ctx, &err)
+		if p, ok := s.FileSystem.(filesystem.Pinger); ok {
+			err = p.Ping(ctx)
+		}
+		if errors.Is(err, filesystem.ErrUnsupported) {
+			err = nil
+		}
+	}()
+
+	code := http.StatusOK
+	var lines []string
+	for _, c := range []struct {
+		name string
+		err  error
+	}{
+		{"database", s.Database.Ping(ctx)},
+		{"filesystem", <-fsErr},
+	} {
+		if c.err != nil {
+			s.logger().Error("ping "+c.name, "error", c.err)
+			code = http.StatusServiceUnavailable
+			lines = append(lines, fmt.Sprintf("%s: %v", c.name, c.err))
+			continue
+		}
+		lines = append(lines, c.name+": ok")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	io.WriteString(w, strings.Join(lines, "\n")+"\n")
+}
+
+// Health is Ready, which /healthz is an alias of for compatibility.
+func (s Server) Health(w http.ResponseWriter, r *http.Request) { s.Ready(w, r) }
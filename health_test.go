@@ -0,0 +1,68 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestProbes(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := func(method, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	for _, tt := range []struct {
+		path, body string
+	}{
+		{"/livez", "ok\n"},
+		{"/readyz", "database: ok\nfilesystem: ok\n"},
+		{"/healthz", "database: ok\nfilesystem: ok\n"},
+	} {
+		if w := probe(http.MethodGet, tt.path); w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Fatalf("%s: unexpected response; got %d %q, want %d %q", tt.path, w.Code, w.Body, http.StatusOK, tt.body)
+		}
+	}
+	if w := probe(http.MethodPost, "/livez"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	// A request which keeps the server draining.
+	if !s.lifecycle.enter() {
+		t.Fatal("request rejected before shutdown")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Shutdown(ctx) }()
+	for !s.lifecycle.isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+	for _, path := range []string{"/livez", "/readyz", "/healthz"} {
+		w := probe(http.MethodGet, path)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s: unexpected response while draining; got %d %q", path, w.Code, w.Body)
+		}
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept", "application/json")
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		var b errorBody
+		if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil || b.Error.Code != CodeShuttingDown {
+			t.Fatalf("%s: unexpected error while draining; got %q: %v", path, w.Body, err)
+		}
+	}
+	s.lifecycle.exit()
+	cancel()
+	<-done
+}
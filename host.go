@@ -0,0 +1,69 @@
+package kipp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// errMisdirected is responded to requests for hosts which aren't allowed.
+var errMisdirected = newError(http.StatusMisdirectedRequest, CodeMisdirected, http.StatusText(http.StatusMisdirectedRequest))
+
+// hostPolicy is the hosts requests may be for. Patterns starting with "*."
+// allow any subdomain of what follows, but not the domain itself.
+type hostPolicy struct {
+	exact    map[string]bool
+	suffixes []string
+}
+
+// newHostPolicy returns the policy allowing hosts, or nil if there are none,
+// which allows any host.
+func newHostPolicy(hosts []string) (*hostPolicy, error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	p := &hostPolicy{exact: make(map[string]bool)}
+	for _, h := range hosts {
+		if _, _, err := net.SplitHostPort(h); err == nil {
+			return nil, fmt.Errorf("host %q has a port", h)
+		}
+		host := normalizeHost(strings.Trim(h, "[]"))
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			host = suffix
+			p.suffixes = append(p.suffixes, "."+suffix)
+		} else {
+			p.exact[host] = true
+		}
+		if host == "" || strings.ContainsAny(host, "*/") {
+			return nil, fmt.Errorf("invalid host %q", h)
+		}
+	}
+	return p, nil
+}
+
+// Allows reports whether requests may be for host, which may have a port.
+func (p *hostPolicy) Allows(host string) bool {
+	if p == nil {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = normalizeHost(strings.Trim(host, "[]"))
+	if p.exact[host] {
+		return true
+	}
+	for _, suffix := range p.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost returns host in lower case without a trailing dot, as hosts
+// are compared.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
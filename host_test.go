@@ -0,0 +1,85 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestHostPolicy(t *testing.T) {
+	p, err := newHostPolicy([]string{"Example.com", "*.files.example.com", "[::1]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com.", true},
+		{"example.com:8080", true},
+		{"www.example.com", false},
+		{"a.files.example.com", true},
+		{"a.b.files.example.com:443", true},
+		{"files.example.com", false},
+		{"evilfiles.example.com", false},
+		{"[::1]:80", true},
+		{"127.0.0.1", false},
+		{"", false},
+	} {
+		if got := p.Allows(tt.host); got != tt.want {
+			t.Errorf("Allows(%q) = %t, want %t", tt.host, got, tt.want)
+		}
+	}
+
+	// Servers without allowed hosts allow any.
+	if !(*hostPolicy)(nil).Allows("anything.example") {
+		t.Fatal("nil policy doesn't allow every host")
+	}
+	for _, hosts := range [][]string{{"example.com:80"}, {"*"}, {"a.*.example.com"}, {""}} {
+		if _, err := newHostPolicy(hosts); err == nil {
+			t.Errorf("newHostPolicy(%q) succeeded, want an error", hosts)
+		}
+	}
+}
+
+func TestAllowHosts(t *testing.T) {
+	db := memory.New()
+	s, err := New(context.Background(), DB(db), FS(memfs.New()), Limit(1<<10), AllowHosts("files.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Uploads for other hosts are rejected before they're stored.
+	r := multipartRequest(t, 1)
+	r.Host = "attacker.example"
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusMisdirectedRequest {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusMisdirectedRequest)
+	}
+	if entries, _, err := db.List(context.Background(), database.ListOptions{}); err != nil || len(entries) != 0 {
+		t.Fatalf("unexpected entries; got %d, %v, want none", len(entries), err)
+	}
+
+	r = multipartRequest(t, 1)
+	r.Host = "files.example.com:8443"
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, DefaultLivePath, nil)
+	r.Host = "10.0.0.1:8080"
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status of probe; got %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -3,16 +3,27 @@ package databaseutil
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/url"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/uhthomas/kipp/database"
 	"github.com/uhthomas/kipp/database/badger"
+	"github.com/uhthomas/kipp/database/bolt"
+	"github.com/uhthomas/kipp/database/cassandra"
+	"github.com/uhthomas/kipp/database/dynamodb"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/database/mongo"
+	"github.com/uhthomas/kipp/database/redis"
 	"github.com/uhthomas/kipp/database/sql"
 )
 
-// Parse parses s, and will create the appropriate database for the scheme.
-func Parse(ctx context.Context, s string) (database.Database, error) {
+// Parse parses s, and will create the appropriate database for the scheme,
+// which logs to log.
+func Parse(ctx context.Context, s string, log *slog.Logger) (database.Database, error) {
 	u, err := url.Parse(s)
 	if err != nil {
 		return nil, err
@@ -20,10 +31,105 @@ func Parse(ctx context.Context, s string) (database.Database, error) {
 	switch u.Scheme {
 	case "":
 		return badger.Open(u.Path)
+	case "bolt":
+		// bolt:kipp.db or bolt:///path/to/kipp.db
+		name := u.Opaque
+		if name == "" {
+			name = u.Host + u.Path
+		}
+		return bolt.Open(name)
+	case "cassandra", "scylla":
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return cassandra.Open(ctx, u.String())
+	case "dynamodb":
+		c := &aws.Config{Region: &u.Host}
+		if u.User != nil {
+			p, _ := u.User.Password()
+			c.Credentials = credentials.NewStaticCredentials(u.User.Username(), p, "")
+		}
+		if e := u.Query().Get("endpoint"); e != "" {
+			c.Endpoint = &e
+		}
+		return dynamodb.New(strings.TrimPrefix(u.Path, "/"), c)
+	case "memory":
+		// memory: or memory:///path/to/snapshot.json
+		name := u.Opaque
+		if name == "" {
+			name = u.Host + u.Path
+		}
+		if name == "" {
+			return memory.New(), nil
+		}
+		return memory.Load(name)
+	case "mongodb", "mongodb+srv":
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return mongo.Open(ctx, u.String())
+	case "cockroach", "cockroachdb":
+		// CockroachDB speaks the PostgreSQL protocol, and is detected
+		// once connected.
+		u.Scheme = "postgresql"
+		fallthrough
 	case "psql", "postgres", "postgresql":
+		opts, err := sqlOptions(u)
+		if err != nil {
+			return nil, err
+		}
 		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 		defer cancel()
-		return sql.Open(ctx, "pgx", u.String())
+		return sql.Open(ctx, "pgx", u.String(), append(opts, sql.Logger(log))...)
+	case "sqlite", "sqlite3":
+		// sqlite::memory: or sqlite:///path/to/kipp.db
+		opts, err := sqlOptions(u)
+		if err != nil {
+			return nil, err
+		}
+		name := u.Opaque
+		if name == "" {
+			name = u.Host + u.Path
+		}
+		if u.RawQuery != "" {
+			name += "?" + u.RawQuery
+		}
+		return sql.Open(ctx, sql.SQLite, name, append(opts, sql.Logger(log))...)
+	case "redis", "rediss":
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return redis.Open(ctx, u.String())
 	}
 	return nil, fmt.Errorf("invalid scheme: %s", u.Scheme)
 }
+
+// sqlOptions removes the migrate, reader and read-your-writes parameters from
+// the query of u, which would otherwise be passed on to the driver, and
+// returns the options they set.
+func sqlOptions(u *url.URL) ([]sql.Option, error) {
+	q := u.Query()
+	var opts []sql.Option
+	if s := q.Get("migrate"); s != "" {
+		mode, err := sql.ParseMigrateMode(s)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sql.Migrate(mode))
+	}
+	if s := q.Get("reader"); s != "" {
+		opts = append(opts, sql.Reader(s))
+	}
+	if s := q.Get("read-your-writes"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("parse read-your-writes: %w", err)
+		}
+		opts = append(opts, sql.ReadYourWrites(d))
+	}
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	for _, k := range []string{"migrate", "reader", "read-your-writes"} {
+		q.Del(k)
+	}
+	u.RawQuery = q.Encode()
+	return opts, nil
+}
@@ -1,35 +1,272 @@
 package filesystemutil
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/alecthomas/units"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/azure"
+	"github.com/uhthomas/kipp/filesystem/fallback"
+	"github.com/uhthomas/kipp/filesystem/gcs"
+	"github.com/uhthomas/kipp/filesystem/ipfs"
 	"github.com/uhthomas/kipp/filesystem/local"
+	"github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/mirror"
 	"github.com/uhthomas/kipp/filesystem/s3"
+	"github.com/uhthomas/kipp/filesystem/sftp"
+	"github.com/uhthomas/kipp/filesystem/tier"
+	"github.com/uhthomas/kipp/filesystem/webdav"
 )
 
-// Parse parses s, and will create the appropriate filesystem for the scheme.
-func Parse(s string) (filesystem.FileSystem, error) {
+// Parse parses s, and will create the appropriate filesystem for the scheme,
+// which logs to log.
+func Parse(ctx context.Context, s string, log *slog.Logger) (filesystem.FileSystem, error) {
 	u, err := url.Parse(s)
 	if err != nil {
 		return nil, err
 	}
 	switch u.Scheme {
 	case "":
-		return local.New(u.Path)
+		var opts []local.Option
+		if d := u.Query().Get("shard"); d != "" {
+			depth, err := strconv.Atoi(d)
+			if err != nil {
+				return nil, fmt.Errorf("parse shard: %w", err)
+			}
+			opts = append(opts, local.Shard(depth))
+		}
+		sync, err := boolParam(u, "sync")
+		if err != nil {
+			return nil, err
+		}
+		if sync {
+			opts = append(opts, local.Sync(), local.SyncDir())
+		}
+		dropCache, err := boolParam(u, "dropcache")
+		if err != nil {
+			return nil, err
+		}
+		if dropCache {
+			opts = append(opts, local.DropCache())
+		}
+		sidecars, err := boolParam(u, "sidecars")
+		if err != nil {
+			return nil, err
+		}
+		if sidecars {
+			opts = append(opts, local.Sidecars())
+		}
+		return local.New(u.Path, opts...)
+	case "memory":
+		return memory.New(), nil
+	case "fallback":
+		q := u.Query()
+		primary, err := Parse(ctx, q.Get("primary"), log)
+		if err != nil {
+			return nil, fmt.Errorf("primary: %w", err)
+		}
+		secondary, err := Parse(ctx, q.Get("secondary"), log)
+		if err != nil {
+			return nil, fmt.Errorf("secondary: %w", err)
+		}
+		opts := []fallback.Option{fallback.Logger(log)}
+		migrate, err := boolParam(u, "migrate")
+		if err != nil {
+			return nil, err
+		}
+		if migrate {
+			opts = append(opts, fallback.MigrateOnRead())
+		}
+		return fallback.New(primary, secondary, opts...), nil
+	case "tier":
+		q := u.Query()
+		small, err := Parse(ctx, q.Get("small"), log)
+		if err != nil {
+			return nil, fmt.Errorf("small: %w", err)
+		}
+		large, err := Parse(ctx, q.Get("large"), log)
+		if err != nil {
+			return nil, fmt.Errorf("large: %w", err)
+		}
+		threshold, err := units.ParseBase2Bytes(q.Get("threshold"))
+		if err != nil {
+			return nil, fmt.Errorf("parse threshold: %w", err)
+		}
+		return tier.New(small, large, int64(threshold)), nil
+	case "mirror":
+		q := u.Query()
+		var fss []filesystem.FileSystem
+		for _, s := range q["fs"] {
+			fs, err := Parse(ctx, s, log)
+			if err != nil {
+				return nil, fmt.Errorf("mirror %s: %w", s, err)
+			}
+			fss = append(fss, fs)
+		}
+		opts := []mirror.Option{mirror.Logger(log)}
+		if v := q.Get("quorum"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("parse quorum: %w", err)
+			}
+			opts = append(opts, mirror.Quorum(n))
+		}
+		return mirror.New(fss, opts...)
 	case "s3":
-		c := &aws.Config{Region: &u.Host}
+		q := u.Query()
+		c := &aws.Config{}
 		if u.User != nil {
 			p, _ := u.User.Password()
 			c.Credentials = credentials.NewStaticCredentials(u.User.Username(), p, "")
 		}
-		if e := u.Query().Get("endpoint"); e != "" {
-			c.Endpoint = &e
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		opts := []s3.Option{s3.Prefix(prefix), s3.Region(u.Host)}
+		if v := q.Get("endpoint"); v != "" {
+			// Endpoints were hosts before they could be URLs.
+			if !strings.Contains(v, "://") {
+				v = "https://" + v
+			}
+			opts = append(opts, s3.Endpoint(v))
+		}
+		pathStyle, err := boolParam(u, "path-style")
+		if err != nil {
+			return nil, err
+		}
+		if pathStyle {
+			opts = append(opts, s3.PathStyle())
+		}
+		switch v := q.Get("sse"); v {
+		case "":
+		case "s3":
+			opts = append(opts, s3.SSES3())
+		case "kms":
+			opts = append(opts, s3.SSEKMS(q.Get("kms-key")))
+		default:
+			return nil, fmt.Errorf("unknown sse %q, must be s3 or kms", v)
+		}
+		if v := q.Get("storage-class"); v != "" {
+			opts = append(opts, s3.StorageClass(v))
+		}
+		if v := q.Get("part-size"); v != "" {
+			size, err := units.ParseBase2Bytes(v)
+			if err != nil {
+				return nil, fmt.Errorf("parse part size: %w", err)
+			}
+			opts = append(opts, s3.PartSize(int64(size)))
+		}
+		if v := q.Get("concurrency"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("parse concurrency: %w", err)
+			}
+			opts = append(opts, s3.Concurrency(n))
+		}
+		return s3.New(bucket, c, opts...)
+	case "ipfs":
+		opts := []ipfs.Option{ipfs.Logger(log)}
+		if u.Path != "" {
+			opts = append(opts, ipfs.Dir(u.Path))
+		}
+		return ipfs.New(ctx, "http://"+u.Host, opts...)
+	case "sftp":
+		q := u.Query()
+		// Paths are absolute, unless they start with /~/, as with curl.
+		var opts []sftp.Option
+		if p := u.Path; p != "" {
+			if rest, ok := strings.CutPrefix(p, "/~"); ok {
+				p = strings.TrimPrefix(rest, "/")
+			}
+			opts = append(opts, sftp.Dir(p))
 		}
-		return s3.New(u.Path, c)
+		if p, ok := u.User.Password(); ok {
+			opts = append(opts, sftp.Password(p))
+		}
+		if k := q.Get("key"); k != "" {
+			opts = append(opts, sftp.PrivateKeyFile(k))
+		}
+		if k := q["known_hosts"]; len(k) > 0 {
+			opts = append(opts, sftp.KnownHosts(k...))
+		}
+		insecure, err := boolParam(u, "insecure")
+		if err != nil {
+			return nil, err
+		}
+		if insecure {
+			opts = append(opts, sftp.InsecureIgnoreHostKey())
+		}
+		if v := q.Get("connections"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("parse connections: %w", err)
+			}
+			opts = append(opts, sftp.Connections(n))
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host += ":22"
+		}
+		return sftp.New(host, u.User.Username(), opts...)
+	case "webdav", "webdavs":
+		q := u.Query()
+		opts := []webdav.Option{webdav.Logger(log)}
+		if u.User != nil {
+			p, _ := u.User.Password()
+			opts = append(opts, webdav.BasicAuth(u.User.Username(), p))
+		}
+		if t := q.Get("token"); t != "" {
+			opts = append(opts, webdav.BearerToken(t))
+		}
+		insecure, err := boolParam(u, "insecure")
+		if err != nil {
+			return nil, err
+		}
+		if insecure {
+			opts = append(opts, webdav.InsecureSkipVerify())
+		}
+		c := url.URL{Scheme: "https", Host: u.Host, Path: u.Path}
+		if u.Scheme == "webdav" {
+			c.Scheme = "http"
+		}
+		return webdav.New(ctx, c.String(), opts...)
+	case "gs":
+		opts := []gcs.Option{gcs.Prefix(strings.TrimPrefix(u.Path, "/"))}
+		if c := u.Query().Get("credentials"); c != "" {
+			opts = append(opts, gcs.CredentialsFile(c))
+		}
+		return gcs.New(ctx, u.Host, opts...)
+	case "azblob", "azblob+http":
+		q := u.Query()
+		opts := []azure.Option{azure.Prefix(q.Get("prefix"))}
+		q.Del("prefix")
+		if u.User != nil {
+			key, _ := u.User.Password()
+			opts = append(opts, azure.SharedKey(u.User.Username(), key))
+		}
+		c := url.URL{Scheme: "https", Host: u.Host, Path: u.Path, RawQuery: q.Encode()}
+		if u.Scheme == "azblob+http" {
+			c.Scheme = "http"
+		}
+		return azure.New(c.String(), opts...)
 	}
 	return nil, fmt.Errorf("invalid scheme: %s", u.Scheme)
 }
+
+// boolParam parses the named query parameter of u, which is false if unset.
+func boolParam(u *url.URL, name string) (bool, error) {
+	v := u.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,33 @@
+package kipp
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// limits is a response of the limits endpoint.
+type limits struct {
+	// Limit is the largest upload the client may make, in bytes.
+	Limit int64 `json:"limit"`
+	// Lifetime is the lifetime of files the client uploads in seconds, or
+	// zero if they don't expire.
+	Lifetime int64 `json:"lifetime"`
+}
+
+// Limits serves the limits of uploads made by the client of r, which are those
+// of the API key it authenticates with as a bearer token, if any. Clients with
+// bearer tokens which are neither keys nor users' tokens are responded to with
+// 401 Unauthorized, as their uploads would be.
+func (s Server) Limits(w http.ResponseWriter, r *http.Request) {
+	r, err := s.authenticate(r)
+	if err != nil {
+		s.authError(w, r, err)
+		return
+	}
+	limit, lifetime := s.uploadLimits(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	// The limits of API keys are only for their clients.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Authorization")
+	json.NewEncoder(w).Encode(limits{Limit: limit, Lifetime: int64(lifetime.Seconds())})
+}
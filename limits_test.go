@@ -0,0 +1,115 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestAPIKeyLimits(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx,
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<10),
+		Lifetime(time.Hour),
+		APIKeys(
+			APIKey{Name: "ci", Key: "ci-key", Limit: 4 << 10, Lifetime: 24 * time.Hour},
+			APIKey{Name: "small", Key: "small-key", Limit: 512},
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		key      string
+		size     int
+		noLength bool
+		status   int
+		lifetime time.Duration
+	}{
+		{"anonymous", "", 600, false, http.StatusSeeOther, time.Hour},
+		{"anonymous above the limit", "", 2 << 10, false, http.StatusRequestEntityTooLarge, 0},
+		{"key above the limit", "ci-key", 2 << 10, false, http.StatusSeeOther, 24 * time.Hour},
+		{"key above the limit without a length", "ci-key", 2 << 10, true, http.StatusSeeOther, 24 * time.Hour},
+		{"key above its own limit", "ci-key", 5 << 10, false, http.StatusRequestEntityTooLarge, 0},
+		{"key below the limit", "small-key", 600, false, http.StatusRequestEntityTooLarge, 0},
+		{"key below the limit without a length", "small-key", 600, true, http.StatusRequestEntityTooLarge, 0},
+		{"key within its own limit", "small-key", 100, false, http.StatusSeeOther, time.Hour},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := multipartRequest(t, tt.size)
+			if tt.key != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			if tt.noLength {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status; got %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusSeeOther {
+				return
+			}
+			slug := w.Header().Get("Location")[1:]
+			slug = slug[:len(slug)-len(".txt")]
+			e, err := s.Database.Lookup(ctx, slug)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.Lifetime.Sub(e.Timestamp); got != tt.lifetime {
+				t.Fatalf("unexpected lifetime; got %s, want %s", got, tt.lifetime)
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s, err := New(context.Background(),
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<10),
+		APIKeys(APIKey{Name: "ci", Key: "ci-key", Limit: 4 << 10, Lifetime: time.Hour}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		key    string
+		status int
+		want   limits
+	}{
+		{"", http.StatusOK, limits{Limit: 1 << 10}},
+		{"ci-key", http.StatusOK, limits{Limit: 4 << 10, Lifetime: 3600}},
+		{"guess", http.StatusUnauthorized, limits{}},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/limits", nil)
+		if tt.key != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.key)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Fatalf("unexpected status with key %q; got %d, want %d", tt.key, w.Code, tt.status)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var got limits
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Fatalf("unexpected limits with key %q; got %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package kipp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// defaultGracePeriod is how long requests in flight are given to finish
+	// when the server shuts down, unless it's set otherwise.
+	defaultGracePeriod = time.Minute
+	// readHeaderTimeout and idleTimeout limit connections which are slow to
+	// send requests, or idle between them, unless HeaderTimeout is set
+	// otherwise. Bodies aren't limited by the http.Server, as uploads and
+	// downloads of large files take as long as they take, but by
+	// ProgressTimeout and TransferTimeout as they move.
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 120 * time.Second
+	// certReloadInterval is how often certificate files are checked for
+	// changes.
+	certReloadInterval = 10 * time.Second
+)
+
+// checkTLS checks the server is given at most one way of getting
+// certificates, and everything it needs for it.
+func (s Server) checkTLS() error {
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return errors.New("certificate and key files must both be set")
+	}
+	if s.CertFile != "" && len(s.ACMEHosts) > 0 {
+		return errors.New("certificate files and acme are mutually exclusive")
+	}
+	// Certificates would be issued again on every restart otherwise,
+	// which is rate limited.
+	if len(s.ACMEHosts) > 0 && s.ACMECache == "" {
+		return errors.New("acme requires a cache directory")
+	}
+	if s.RedirectAddr != "" && !s.isTLS() {
+		return errors.New("redirecting to https requires a certificate or acme")
+	}
+	return nil
+}
+
+// isTLS reports whether the server has certificates to serve TLS with.
+func (s Server) isTLS() bool {
+	return s.CertFile != "" || len(s.ACMEHosts) > 0
+}
+
+// ListenAndServe listens on addr, and serves the server with Serve. addr is a
+// TCP address, or the path of a unix socket prefixed with unix://, which is
+// created with SocketMode and removed once the server has shut down. If
+// systemd passed sockets to the process, the first is served instead. The
+// server is shut down if it can't listen.
+func (s Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := s.listen(addr, 0)
+	if err != nil {
+		return errors.Join(fmt.Errorf("listen: %w", err), s.Shutdown(ctx))
+	}
+	return s.Serve(ctx, ln)
+}
+
+// ListenAndServeTLS listens on addr as ListenAndServe does, and serves the
+// server with ServeTLS. If systemd passed a second socket to the process,
+// redirects are served on it rather than RedirectAddr.
+func (s Server) ListenAndServeTLS(ctx context.Context, addr string) error {
+	ln, err := s.listen(addr, 0)
+	if err != nil {
+		return errors.Join(fmt.Errorf("listen: %w", err), s.Shutdown(ctx))
+	}
+	return s.ServeTLS(ctx, ln)
+}
+
+// Serve serves the server on ln until ctx is done or the process is sent
+// SIGINT or SIGTERM, and then shuts the server down, giving requests in flight
+// up to GracePeriod to finish. ln is kept open while the server drains, so
+// requests which arrive in the meantime are told to retry. A second signal
+// kills the process. The server is shut down however Serve returns.
+func (s Server) Serve(ctx context.Context, ln net.Listener) error {
+	return s.serve(ctx, ln, false)
+}
+
+// ServeTLS serves the server on ln with TLS, as Serve does, with the
+// certificate in CertFile and KeyFile, or those obtained for ACMEHosts. If
+// RedirectAddr is set, plain HTTP is served on it too, redirecting requests
+// to HTTPS.
+func (s Server) ServeTLS(ctx context.Context, ln net.Listener) error {
+	return s.serve(ctx, ln, true)
+}
+
+// certSource gets certificates for TLS connections, and handles requests
+// made over plain HTTP which it needs to, such as ACME challenges.
+type certSource struct {
+	config *tls.Config
+	// handler wraps the handler of plain HTTP requests.
+	handler func(http.Handler) http.Handler
+	// run, if not nil, keeps certificates current until its context is
+	// done.
+	run func(context.Context)
+}
+
+// newCertSource returns the source of the server's certificates.
+func (s Server) newCertSource() (certSource, error) {
+	if !s.isTLS() {
+		return certSource{}, errors.New("no certificate or acme hosts")
+	}
+	if len(s.ACMEHosts) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(s.ACMECache),
+			HostPolicy: autocert.HostWhitelist(s.ACMEHosts...),
+		}
+		return certSource{
+			config:  m.TLSConfig(),
+			handler: m.HTTPHandler,
+		}, nil
+	}
+	c, err := newCertReloader(s.CertFile, s.KeyFile)
+	if err != nil {
+		return certSource{}, err
+	}
+	return certSource{
+		config:  &tls.Config{GetCertificate: c.GetCertificate},
+		handler: func(h http.Handler) http.Handler { return h },
+		run: func(ctx context.Context) {
+			c.run(ctx, certReloadInterval, s.logger())
+		},
+	}, nil
+}
+
+func (s Server) serve(ctx context.Context, ln net.Listener, useTLS bool) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Requests aren't cancelled by the signal, so they can finish.
+	newServer := func(h http.Handler) *http.Server {
+		return &http.Server{
+			Handler:           h,
+			ReadHeaderTimeout: s.HeaderTimeout,
+			IdleTimeout:       idleTimeout,
+			BaseContext:       func(net.Listener) context.Context { return xcontext.Detach(ctx) },
+		}
+	}
+	hs := newServer(s)
+	servers := []*http.Server{hs}
+	errc := make(chan error, 2)
+	fail := func(err error) error {
+		ln.Close()
+		for _, hs := range servers {
+			hs.Close()
+		}
+		return errors.Join(err, s.Shutdown(ctx))
+	}
+	if !useTLS {
+		go func() { errc <- hs.Serve(ln) }()
+	} else {
+		src, err := s.newCertSource()
+		if err != nil {
+			return fail(fmt.Errorf("certificates: %w", err))
+		}
+		// What keeps certificates current is stopped once the server
+		// has shut down.
+		if src.run != nil {
+			cctx, cancel := context.WithCancel(xcontext.Detach(ctx))
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				src.run(cctx)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+		}
+		hs.TLSConfig = src.config
+		go func() { errc <- hs.ServeTLS(ln, "", "") }()
+		if s.RedirectAddr != "" {
+			rln, err := s.listen(s.RedirectAddr, 1)
+			if err != nil {
+				return fail(fmt.Errorf("listen for redirects: %w", err))
+			}
+			_, port, _ := net.SplitHostPort(ln.Addr().String())
+			rs := newServer(src.handler(redirectHandler(port)))
+			servers = append(servers, rs)
+			go func() { errc <- rs.Serve(rln) }()
+		}
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+		err = fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+		stop()
+		s.logger().Info("shutting down")
+	}
+
+	sctx := ctx
+	if s.GracePeriod != 0 {
+		sctx = xcontext.Detach(ctx)
+		if s.GracePeriod > 0 {
+			var cancel context.CancelFunc
+			sctx, cancel = context.WithTimeout(sctx, s.GracePeriod)
+			defer cancel()
+		}
+	}
+	serr := s.Shutdown(sctx)
+	// Requests left are those which didn't finish in time.
+	for _, hs := range servers {
+		if err := hs.Shutdown(sctx); err != nil {
+			hs.Close()
+		}
+	}
+	return errors.Join(err, serr)
+}
+
+// redirectHandler redirects requests to the same URL over HTTPS on port.
+// Redirects are permanent, and keep the method, so uploads made over plain
+// HTTP are made again over HTTPS.
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		u := *r.URL
+		u.Scheme, u.Host = "https", host
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
+
+// certReloader serves a certificate and key loaded from files, loading them
+// again when they change.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	// modTime is when the files were last modified when they were loaded.
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the certificate last loaded, as
+// tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// reload loads the certificate and key.
+func (c *certReloader) reload() error {
+	t, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	c.modTime = t
+	return nil
+}
+
+// lastModified returns when either file was last modified.
+func (c *certReloader) lastModified() (time.Time, error) {
+	var t time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("stat: %w", err)
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t, nil
+}
+
+// run reloads the certificate and key when the process is sent SIGHUP, or
+// when they've changed when they're checked every interval, until ctx is
+// done. The certificate which was last loaded is kept if they can't be.
+func (c *certReloader) run(ctx context.Context, interval time.Duration, log *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-t.C:
+			// Files which can't be found are reported by reload.
+			if m, err := c.lastModified(); err == nil && m.Equal(c.modTime) {
+				continue
+			}
+		}
+		if err := c.reload(); err != nil {
+			log.Warn("reload certificate", "error", err)
+			continue
+		}
+		log.Info("reloaded certificate", "file", c.certFile)
+	}
+}
@@ -0,0 +1,190 @@
+package kipp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 with the given
+// common name, and its key, to certFile and keyFile, returning it.
+func writeCert(t *testing.T, certFile, keyFile, cn string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert := writeCert(t, certFile, keyFile, "kipp")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), Certificate(certFile, keyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.ServeTLS(ctx, ln) }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	// The upload is redirected to the file, which is followed.
+	r := uploadRequest(t, 10)
+	req, err := http.NewRequest(r.Method, "https://"+ln.Addr().String()+"/", r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = r.Header
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(b) != strings.Repeat("a", 10) {
+		t.Fatalf("unexpected response; got %d %q", res.StatusCode, b)
+	}
+	if res.TLS == nil || res.Request.URL.Scheme != "https" {
+		t.Fatal("file wasn't served over TLS")
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "old")
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.run(ctx, time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Files replaced within the same tick of the clock would look
+	// unchanged.
+	writeCert(t, certFile, keyFile, "new")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		cert, err := c.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.Subject.CommonName == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate wasn't reloaded; got %q", cert.Leaf.Subject.CommonName)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tt := range []struct {
+		port, target, want string
+	}{
+		{"443", "http://example.com/abc?x=1", "https://example.com/abc?x=1"},
+		{"8443", "http://example.com:8080/", "https://example.com:8443/"},
+	} {
+		w := httptest.NewRecorder()
+		redirectHandler(tt.port).ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, nil))
+		if w.Code != http.StatusPermanentRedirect {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusPermanentRedirect)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Fatalf("unexpected location; got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range [][]Option{
+		{Certificate("cert.pem", "")},
+		{Certificate("cert.pem", "key.pem"), ACME("acme", "example.com")},
+		{ACME("", "example.com")},
+		{RedirectHTTP(":80")},
+	} {
+		if _, err := New(ctx, append([]Option{DB(memory.New()), FS(memfs.New())}, opts...)...); err == nil {
+			t.Fatal("expected error for invalid tls options")
+		}
+	}
+}
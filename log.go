@@ -0,0 +1,46 @@
+package kipp
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logger returns the logger of s, or slog.Default if it has none, which logs
+// with the log package unless it's been replaced.
+func (s Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// logRequest logs the response to r at the debug level. Only the path of r is
+// logged, as queries may be sensitive.
+func (s Server) logRequest(r *http.Request, w *statusWriter, d time.Duration) {
+	l := s.logger()
+	if !l.Enabled(r.Context(), slog.LevelDebug) {
+		return
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	l.LogAttrs(r.Context(), slog.LevelDebug, "request",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Int64("bytes", w.n),
+		slog.Duration("duration", d),
+		slog.String("remote", remoteAddr(r)),
+	)
+}
+
+// remoteAddr returns the IP of the client of r, or its peer's address if the
+// IP couldn't be resolved.
+func remoteAddr(r *http.Request) string {
+	if ip, ok := ClientIP(r.Context()); ok {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,133 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// records decodes the JSON log lines in buf.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var rs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// find returns the first record with msg.
+func find(t *testing.T, rs []map[string]any, msg string) map[string]any {
+	t.Helper()
+	for _, r := range rs {
+		if r["msg"] == msg {
+			return r
+		}
+	}
+	t.Fatalf("no %q record in %v", msg, rs)
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), Logger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := upload(t, s, 10)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	slug := strings.TrimSuffix(strings.TrimPrefix(w.Header().Get("Location"), "/"), ".txt")
+	rec := find(t, records(t, &buf), "upload")
+	if rec["level"] != "DEBUG" || rec["slug"] != slug || rec["size"] != float64(10) {
+		t.Fatalf("unexpected upload record: %v", rec)
+	}
+
+	// Queries may be sensitive, so they aren't logged.
+	buf.Reset()
+	r := httptest.NewRequest(http.MethodGet, "/"+slug+".txt?password=hunter2", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("query was logged: %s", buf.String())
+	}
+	rec = find(t, records(t, &buf), "request")
+	for k, want := range map[string]any{
+		"method": http.MethodGet,
+		"path":   "/" + slug + ".txt",
+		"status": float64(http.StatusOK),
+		"bytes":  float64(10),
+		"remote": "192.0.2.1",
+	} {
+		if rec[k] != want {
+			t.Fatalf("unexpected %s; got %v, want %v", k, rec[k], want)
+		}
+	}
+	if _, ok := rec["duration"]; !ok {
+		t.Fatalf("no duration in %v", rec)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	ctx := context.Background()
+
+	// Reaping the expired entry is logged once it's done.
+	db := memory.New()
+	e := databasetest.NewEntry("expired")
+	lifetime := time.Now().Add(-time.Hour)
+	e.Lifetime = &lifetime
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, nil))
+	s, err := New(ctx, DB(db), FS(memfs.New()), Limit(1<<20), Reaper(time.Millisecond, 10), Logger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload(t, s, 10)
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	for testutil.ToFloat64(s.reapMetrics.entries) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The reaper has stopped once it's shut down, so buf can be read.
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	// Requests and uploads are below the info level.
+	rs := records(t, &buf)
+	for _, r := range rs {
+		if r["msg"] == "request" || r["msg"] == "upload" {
+			t.Fatalf("unexpected record below the info level: %v", r)
+		}
+	}
+	rec := find(t, rs, "reaped expired entries")
+	if rec["level"] != "INFO" || rec["entries"] != float64(1) {
+		t.Fatalf("unexpected reap record: %v", rec)
+	}
+}
@@ -0,0 +1,182 @@
+package kipp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// httpMetrics export what's been served. Their labels are bounded, so routes
+// rather than paths are recorded, and never slugs.
+type httpMetrics struct {
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	uploads        *prometheus.CounterVec
+	uploadSize     prometheus.Histogram
+	uploadDuration *prometheus.HistogramVec
+	downloads      *prometheus.CounterVec
+	downloadBytes  *prometheus.CounterVec
+	inFlight       *prometheus.GaugeVec
+	panics         prometheus.Counter
+}
+
+func newHTTPMetrics(r prometheus.Registerer) (*httpMetrics, error) {
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of requests served, by route and status code.",
+		}, []string{"route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "kipp",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests, until their responses were written.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, []string{"route"}),
+		uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "uploads_total",
+			Help:      "Number of uploads, by outcome.",
+		}, []string{"outcome"}),
+		uploadSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "kipp",
+			Name:      "upload_size_bytes",
+			Help:      "Size of the bodies of successful uploads.",
+			Buckets:   prometheus.ExponentialBuckets(1<<10, 4, 10),
+		}),
+		uploadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "kipp",
+			Name:      "upload_duration_seconds",
+			Help:      "Duration of uploads, by outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+		}, []string{"outcome"}),
+		downloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "downloads_total",
+			Help:      "Number of GET requests for files, by status class and whether a range was requested.",
+		}, []string{"class", "kind"}),
+		downloadBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "downloaded_bytes_total",
+			Help:      "Number of bytes of files sent, by whether a range was requested.",
+		}, []string{"kind"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "transfers_in_flight",
+			Help:      "Number of uploads and downloads in progress.",
+		}, []string{"direction"}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Subsystem: "http",
+			Name:      "panics_total",
+			Help:      "Number of panics recovered while serving requests.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.requests, m.duration, m.uploads, m.uploadSize,
+		m.uploadDuration, m.downloads, m.downloadBytes, m.inFlight,
+		m.panics,
+	} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// track records metrics for r to route rt, returning r with its body counted
+// and a function to call once the response has been written to w.
+func (m *httpMetrics) track(r *http.Request, w *statusWriter, rt string) (*http.Request, func()) {
+	start := time.Now()
+	var direction string
+	switch {
+	case r.Method == http.MethodPost && rt == "/":
+		direction = "upload"
+	case r.Method == http.MethodGet && rt == "/{file}":
+		direction = "download"
+	}
+	var body *countingBody
+	if direction != "" {
+		m.inFlight.WithLabelValues(direction).Inc()
+		if direction == "upload" {
+			body = &countingBody{ReadCloser: r.Body}
+			r = r.Clone(r.Context())
+			r.Body = body
+		}
+	}
+	return r, func() {
+		d := time.Since(start)
+		status := w.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.WithLabelValues(rt, strconv.Itoa(status)).Inc()
+		m.duration.WithLabelValues(rt).Observe(d.Seconds())
+		switch direction {
+		case "upload":
+			outcome := uploadOutcome(status)
+			m.uploads.WithLabelValues(outcome).Inc()
+			m.uploadDuration.WithLabelValues(outcome).Observe(d.Seconds())
+			if outcome == "success" {
+				m.uploadSize.Observe(float64(body.n))
+			}
+		case "download":
+			kind := "full"
+			if r.Header.Get("Range") != "" {
+				kind = "range"
+			}
+			m.downloads.WithLabelValues(strconv.Itoa(status/100)+"xx", kind).Inc()
+			// Bodies of errors aren't files.
+			if status/100 == 2 {
+				m.downloadBytes.WithLabelValues(kind).Add(float64(w.n))
+			}
+		}
+		if direction != "" {
+			m.inFlight.WithLabelValues(direction).Dec()
+		}
+	}
+}
+
+// uploadOutcome returns the outcome of an upload responded to with status.
+func uploadOutcome(status int) string {
+	switch {
+	case status == http.StatusSeeOther:
+		return "success"
+	case status == http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case status == http.StatusInsufficientStorage:
+		return "insufficient_storage"
+	case status >= http.StatusInternalServerError:
+		return "error"
+	}
+	return "rejected"
+}
+
+// countingBody counts what's read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// serveMetrics serves the metrics of the registry of the server, only to
+// requests with MetricsToken as a bearer token or from addresses in
+// MetricsAllow if either is set.
+func (s Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if (s.MetricsToken != "" || len(s.MetricsAllow) > 0) && !allowed(r, s.MetricsToken, s.MetricsAllow) {
+		s.httpError(w, r, newError(http.StatusForbidden, CodeForbidden, http.StatusText(http.StatusForbidden)))
+		return
+	}
+	s.metricHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,164 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(uploadRequest(t, 100))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if w := serve(uploadRequest(t, 2<<10)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	loc := w.Header().Get("Location")
+	serve(httptest.NewRequest(http.MethodGet, loc, nil))
+	r := httptest.NewRequest(http.MethodGet, loc, nil)
+	r.Header.Set("Range", "bytes=0-9")
+	if w := serve(r); w.Code != http.StatusPartialContent {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	serve(httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/varz", nil))
+	for _, want := range []string{
+		`kipp_uploads_total{outcome="success"} 1`,
+		`kipp_uploads_total{outcome="too_large"} 1`,
+		`kipp_upload_size_bytes_count 1`,
+		`kipp_upload_duration_seconds_count{outcome="success"} 1`,
+		`kipp_downloads_total{class="2xx",kind="full"} 1`,
+		`kipp_downloads_total{class="2xx",kind="range"} 1`,
+		`kipp_downloads_total{class="4xx",kind="full"} 1`,
+		`kipp_downloaded_bytes_total{kind="full"} 100`,
+		`kipp_downloaded_bytes_total{kind="range"} 10`,
+		`kipp_http_requests_total{code="404",route="/{file}"} 1`,
+		`kipp_http_requests_total{code="413",route="/"} 1`,
+		`kipp_http_request_duration_seconds_count{route="/{file}"} 3`,
+		`kipp_transfers_in_flight{direction="download"} 0`,
+		`kipp_transfers_in_flight{direction="upload"} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Fatalf("no %s in:\n%s", want, w.Body)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	// The program's registry already has a Go collector.
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(prometheus.NewGoCollector()); err != nil {
+		t.Fatal(err)
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_things_total", Help: "Things."})
+	c.Add(3)
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Registry(reg, reg), Collectors(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// kipp's metrics are registered with it, and /varz serves it.
+	if n, err := testutil.GatherAndCount(reg, "kipp_reaped_entries_total", "app_things_total"); err != nil || n != 2 {
+		t.Fatalf("unexpected series; got %d and %v, want 2", n, err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/varz", nil))
+	if !strings.Contains(w.Body.String(), "app_things_total 3\n") {
+		t.Fatalf("no app_things_total in:\n%s", w.Body)
+	}
+
+	// Collectors which can't be registered fail.
+	if _, err := New(ctx, DB(memory.New()), FS(memfs.New()), Collectors(c, c)); err == nil {
+		t.Fatal("expected error for a duplicate collector")
+	}
+	if _, err := New(ctx, DB(memory.New()), FS(memfs.New()), Registry(reg, nil)); err == nil {
+		t.Fatal("expected error for a registerer without a gatherer")
+	}
+}
+
+func TestProtectMetrics(t *testing.T) {
+	ctx := context.Background()
+	const (
+		proxy   = "10.0.0.1:1234"
+		inside  = "192.0.2.1"
+		outside = "198.51.100.1"
+	)
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		allow []netip.Prefix
+		// want is whether each of the requests below is served metrics:
+		// one without a token from an allowed address, one with the
+		// token from another address, and one with neither.
+		want [3]bool
+	}{
+		{"open", "", nil, [3]bool{true, true, true}},
+		{"token", "secret", nil, [3]bool{false, true, false}},
+		{"allow", "", []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, [3]bool{true, false, false}},
+		{"token or allow", "secret", []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, [3]bool{true, true, false}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(ctx, DB(memory.New()), FS(memfs.New()),
+				TrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+				ProtectMetrics(tt.token, tt.allow...),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Clients are named by the trusted proxy in front of
+			// them.
+			get := func(path, client, token string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				r.RemoteAddr = proxy
+				r.Header.Set("X-Forwarded-For", client)
+				if token != "" {
+					r.Header.Set("Authorization", "Bearer "+token)
+				}
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+				return w
+			}
+			for i, req := range []struct{ client, token string }{
+				{inside, ""},
+				{outside, "secret"},
+				{outside, "guess"},
+			} {
+				w := get("/varz", req.client, req.token)
+				if got := w.Code == http.StatusOK; got != tt.want[i] {
+					t.Fatalf("%s with %q: unexpected status %d", req.client, req.token, w.Code)
+				}
+				if !tt.want[i] && (w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "go_goroutines")) {
+					t.Fatalf("%s with %q: metrics weren't forbidden; got %d %q", req.client, req.token, w.Code, w.Body)
+				}
+			}
+			// Probes are open to everyone.
+			if w := get("/healthz", outside, ""); w.Code != http.StatusOK {
+				t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusOK)
+			}
+		})
+	}
+}
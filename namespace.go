@@ -0,0 +1,184 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultNamespace names the server's own namespace, of requests which aren't
+// for any of its Namespaces, in metrics and the admin API.
+const defaultNamespace = "default"
+
+// A Namespace is a server of its own, with its own database, file system,
+// limits and lifetimes, which serves some of the requests of the server it's
+// part of. Its entries are never served for another namespace, as it has no
+// way to look them up.
+type Namespace struct {
+	// Name names the namespace in logs and metrics, and in the admin API,
+	// which serves it to requests with it as their namespace parameter.
+	// It's made of lowercase letters, digits, hyphens and underscores.
+	Name string
+	// Hosts, which may start with "*." to match any subdomain, and
+	// PathPrefix are the requests the namespace serves, which must be for
+	// one of Hosts, if there are any, and under PathPrefix, if it's set.
+	// PathPrefix is stripped from requests before the namespace serves
+	// them. Namespaces are matched in order.
+	Hosts      []string
+	PathPrefix string
+	// Options configure the namespace's server, which must be given a
+	// database and file system, which it shouldn't share with any other.
+	// Nothing else is inherited, except its logger, which it logs with
+	// unless it's given one, its admin token, unless it's given one, and
+	// its registry, which its metrics are registered with, labelled by its
+	// name.
+	Options []Option
+}
+
+// namespaceServer is a namespace's server, and the requests it serves.
+type namespaceServer struct {
+	name   string
+	s      *Server
+	hosts  *hostPolicy
+	prefix string
+}
+
+// Matches reports whether r, whose path is yet to have the namespace's prefix
+// stripped, is for the namespace.
+func (ns namespaceServer) Matches(r *http.Request) bool {
+	if ns.hosts != nil && !ns.hosts.Allows(r.Host) {
+		return false
+	}
+	return ns.prefix == "" || r.URL.Path == ns.prefix || strings.HasPrefix(r.URL.Path, ns.prefix+"/")
+}
+
+// validNamespace reports whether name is a valid name of a namespace.
+func validNamespace(name string) bool {
+	if name == "" || name == defaultNamespace {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newNamespaces makes the servers of s's Namespaces, which register their
+// metrics with r and serve those gathered from g, and run until ctx is done
+// or they're shut down.
+func (s Server) newNamespaces(ctx context.Context, r prometheus.Registerer, g prometheus.Gatherer) ([]namespaceServer, error) {
+	seen := make(map[string]bool, len(s.Namespaces))
+	servers := make([]namespaceServer, 0, len(s.Namespaces))
+	for _, ns := range s.Namespaces {
+		if !validNamespace(ns.Name) {
+			return nil, fmt.Errorf("invalid namespace name %q", ns.Name)
+		}
+		if seen[ns.Name] {
+			return nil, fmt.Errorf("duplicate namespace %q", ns.Name)
+		}
+		seen[ns.Name] = true
+		prefix := strings.TrimSuffix(ns.PathPrefix, "/")
+		if ns.PathPrefix != "" && (prefix == "" || !strings.HasPrefix(prefix, "/")) {
+			return nil, fmt.Errorf("namespace %q: path prefix must start with a slash and not be the root", ns.Name)
+		}
+		if len(ns.Hosts) == 0 && prefix == "" {
+			return nil, fmt.Errorf("namespace %q: must have hosts or a path prefix", ns.Name)
+		}
+		hosts, err := newHostPolicy(ns.Hosts)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", ns.Name, err)
+		}
+		opts := make([]Option, 0, len(ns.Options)+2)
+		opts = append(opts, Logger(s.logger().With("namespace", ns.Name)))
+		opts = append(opts, ns.Options...)
+		opts = append(opts, func(ctx context.Context, c *Server) error {
+			if c.Database == nil || c.FileSystem == nil {
+				return errors.New("must have a database and filesystem")
+			}
+			if len(c.Namespaces) > 0 {
+				return errors.New("must not have namespaces of its own")
+			}
+			c.namespace = ns.Name
+			if c.AdminToken == "" {
+				c.AdminToken = s.AdminToken
+			}
+			c.Registerer, c.Gatherer = r, g
+			c.PathPrefix = s.PathPrefix + prefix
+			return nil
+		})
+		c, err := New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", ns.Name, err)
+		}
+		servers = append(servers, namespaceServer{name: ns.Name, s: c, hosts: hosts, prefix: prefix})
+	}
+	return servers, nil
+}
+
+// serveNamespace serves r with the namespace it's for, if it's for one,
+// reporting whether it was. Requests to the admin API with a namespace
+// parameter are for the namespace it names if they're authorized with the
+// server's AdminToken, and are refused by s otherwise, so which namespaces
+// exist isn't told to anyone else.
+func (s Server) serveNamespace(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.namespaces) == 0 {
+		return false
+	}
+	if name := r.URL.Query().Get("namespace"); name != "" && s.isAdmin(r.URL.Path) {
+		if name == defaultNamespace || !s.adminAuthorized(r) {
+			return false
+		}
+		for _, ns := range s.namespaces {
+			if ns.name == name {
+				ns.s.ServeHTTP(w, r)
+				return true
+			}
+		}
+		s.httpError(w, r, newError(http.StatusNotFound, CodeNotFound, "unknown namespace"))
+		return true
+	}
+	for _, ns := range s.namespaces {
+		// Namespaces without hosts of their own are only for the hosts
+		// the server allows.
+		if !ns.Matches(r) || (ns.hosts == nil && !s.hosts.Allows(r.Host)) {
+			continue
+		}
+		if ns.prefix != "" {
+			r = r.Clone(r.Context())
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, ns.prefix)
+			r.URL.RawPath = ""
+		}
+		ns.s.ServeHTTP(w, r)
+		return true
+	}
+	return false
+}
+
+// shutdownNamespaces shuts the servers of s's namespaces down at once, as
+// Shutdown does s.
+func (s Server) shutdownNamespaces(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(s.namespaces))
+	)
+	for i, ns := range s.namespaces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ns.s.Shutdown(ctx); err != nil {
+				errs[i] = fmt.Errorf("namespace %q: %w", ns.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
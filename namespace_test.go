@@ -0,0 +1,189 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	shots, shotsFS := memory.New(), &closer{FileSystem: memfs.New()}
+	s, err := New(ctx,
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<20),
+		Admin("secret"),
+		Registry(reg, reg),
+		Namespaces(
+			Namespace{
+				Name:    "shots",
+				Hosts:   []string{"shots.example.net"},
+				Options: []Option{DB(shots), FS(shotsFS), Limit(512), Lifetime(time.Hour), Admin("secret")},
+			},
+			Namespace{
+				Name:       "pastes",
+				PathPrefix: "/pastes/",
+				Options:    []Option{DB(memory.New()), FS(memfs.New()), Limit(1 << 20)},
+			},
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, host, target string, r *http.Request) *httptest.ResponseRecorder {
+		t.Helper()
+		if r == nil {
+			r = httptest.NewRequest(method, target, nil)
+		}
+		r.Host = host
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// Each namespace has its own limits.
+	if w := do("", "shots.example.net", "", uploadRequest(t, 1<<10)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body)
+	}
+	w := do("", "shots.example.net", "", uploadRequest(t, 10))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	shot := w.Header().Get("Location")
+	slug := strings.TrimSuffix(strings.TrimPrefix(shot, "/"), ".txt")
+	e, err := shots.Lookup(ctx, slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Lifetime == nil {
+		t.Fatal("entry of the shots namespace doesn't expire")
+	}
+
+	// Path prefixes are stripped, and included in URLs.
+	w = do("", "files.example.com", "", func() *http.Request {
+		r := uploadRequest(t, 10)
+		r.URL.Path = "/pastes/"
+		return r
+	}())
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	paste := w.Header().Get("Location")
+	if !strings.HasPrefix(paste, "/pastes/") {
+		t.Fatalf("unexpected location; got %q, want it under /pastes/", paste)
+	}
+
+	// Files are only served for their own namespace.
+	for _, tt := range []struct {
+		host, target string
+		code         int
+	}{
+		{"shots.example.net", shot, http.StatusOK},
+		{"files.example.com", shot, http.StatusNotFound},
+		{"files.example.com", "/pastes" + shot, http.StatusNotFound},
+		{"files.example.com", paste, http.StatusOK},
+		{"files.example.com", strings.TrimPrefix(paste, "/pastes"), http.StatusNotFound},
+		{"shots.example.net", strings.TrimPrefix(paste, "/pastes"), http.StatusNotFound},
+	} {
+		if w := do(http.MethodGet, tt.host, tt.target, nil); w.Code != tt.code {
+			t.Errorf("%s%s: unexpected status; got %d, want %d", tt.host, tt.target, w.Code, tt.code)
+		}
+	}
+
+	// The admin API is filtered by namespace.
+	admin := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		return do("", "files.example.com", "", r)
+	}
+	if w := admin(adminFiles + "/" + slug + "?namespace=shots"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	for _, target := range []string{adminFiles + "/" + slug, adminFiles + "/" + slug + "?namespace=default"} {
+		if w := admin(target); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: unexpected status; got %d, want %d: %s", target, w.Code, http.StatusNotFound, w.Body)
+		}
+	}
+	if w := admin(adminFiles + "?namespace=nope"); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	// Which namespaces exist isn't told to requests without the token, and
+	// those without one of their own inherit it.
+	for _, target := range []string{adminFiles + "?namespace=nope", adminFiles + "?namespace=shots"} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Authorization", "Bearer wrong")
+		if w := do("", "files.example.com", "", r); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: unexpected status; got %d, want %d: %s", target, w.Code, http.StatusUnauthorized, w.Body)
+		}
+	}
+	if w := admin(adminFiles + "?namespace=pastes"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// Metrics are labelled by namespace.
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := make(map[string]bool)
+	for _, mf := range mfs {
+		if mf.GetName() != "kipp_uploads_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "namespace" {
+					namespaces[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	for _, name := range []string{"shots", "pastes"} {
+		if !namespaces[name] {
+			t.Errorf("no uploads labelled with namespace %q", name)
+		}
+	}
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !shotsFS.closed {
+		t.Fatal("file system of the shots namespace wasn't closed")
+	}
+}
+
+func TestNamespacesInvalid(t *testing.T) {
+	own := []Option{DB(memory.New()), FS(memfs.New())}
+	for _, tt := range []struct {
+		name string
+		ns   []Namespace
+	}{
+		{"no name", []Namespace{{Hosts: []string{"a.example.com"}, Options: own}}},
+		{"default", []Namespace{{Name: defaultNamespace, Hosts: []string{"a.example.com"}, Options: own}}},
+		{"invalid name", []Namespace{{Name: "A B", Hosts: []string{"a.example.com"}, Options: own}}},
+		{"duplicate", []Namespace{
+			{Name: "a", Hosts: []string{"a.example.com"}, Options: own},
+			{Name: "a", Hosts: []string{"b.example.com"}, Options: own},
+		}},
+		{"unmatched", []Namespace{{Name: "a", Options: own}}},
+		{"root prefix", []Namespace{{Name: "a", PathPrefix: "/", Options: own}}},
+		{"relative prefix", []Namespace{{Name: "a", PathPrefix: "a", Options: own}}},
+		{"invalid host", []Namespace{{Name: "a", Hosts: []string{"a.example.com:80"}, Options: own}}},
+		{"no database", []Namespace{{Name: "a", Hosts: []string{"a.example.com"}}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Namespaces(tt.ns...)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package kipp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register gif for image.DecodeConfig
+	_ "image/jpeg" // register jpeg for image.DecodeConfig
+	_ "image/png"  // register png for image.DecodeConfig
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// oEmbed is an oEmbed response. See https://oembed.com.
+type oEmbed struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	CacheAge     int    `json:"cache_age,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+}
+
+// OEmbed serves oEmbed responses for files. Images are embedded as photos,
+// and anything else as a link titled with the name of the file. Only the JSON
+// format is supported.
+func (s Server) OEmbed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if f := q.Get("format"); f != "" && f != "json" {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeUnsupportedFormat, "unsupported format"))
+		return
+	}
+
+	u, err := url.Parse(q.Get("url"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeInvalidURL, "invalid url"))
+		return
+	}
+
+	p, ok := strings.CutPrefix(u.Path, s.PathPrefix)
+	dir, slug := path.Split(p)
+	if !ok || dir != "/" {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	if i := strings.Index(slug, "."); i > -1 {
+		slug = slug[:i]
+	}
+
+	e, err := s.lookup(r.Context(), slug)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResults) {
+			s.httpError(w, r, errNotFound)
+			return
+		}
+		s.httpError(w, r, fmt.Errorf("lookup: %w", err))
+		return
+	}
+
+	// Expired entries must not leak their name.
+	now := time.Now()
+	if e.Lifetime != nil && e.Lifetime.Before(now) {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+
+	res := oEmbed{
+		Version:      "1.0",
+		Type:         "link",
+		Title:        e.Name,
+		ProviderName: "kipp",
+		ProviderURL:  (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: s.PathPrefix + "/"}).String(),
+	}
+	if e.Lifetime != nil {
+		res.CacheAge = int(e.Lifetime.Sub(now).Seconds())
+	}
+
+	c, err := s.imageConfig(r, e)
+	if err != nil {
+		s.logger().Warn("image config", "slug", slug, "error", err)
+	} else if c != nil {
+		res.Type = "photo"
+		res.URL = u.String()
+		res.Width, res.Height = c.Width, c.Height
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		s.logger().Warn("encode oembed", "error", err)
+	}
+}
+
+// imageConfig decodes the dimensions of the file for e, or returns nil if it
+// isn't a supported image.
+func (s Server) imageConfig(r *http.Request, e database.Entry) (*image.Config, error) {
+	f, err := s.FileSystem.Open(r.Context(), e.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	c, _, err := image.DecodeConfig(f)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+	return &c, nil
+}
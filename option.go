@@ -2,12 +2,22 @@ package kipp
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"os"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/namecrypt"
+	"github.com/uhthomas/kipp/database/retry"
 	"github.com/uhthomas/kipp/filesystem"
 	"github.com/uhthomas/kipp/internal/databaseutil"
 	"github.com/uhthomas/kipp/internal/filesystemutil"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Option func(ctx context.Context, s *Server) error
@@ -19,9 +29,11 @@ func DB(db database.Database) Option {
 	}
 }
 
+// ParseDB parses ss as a database, which logs to the Logger set by the options
+// before it, or slog.Default.
 func ParseDB(ss string) Option {
 	return func(ctx context.Context, s *Server) error {
-		db, err := databaseutil.Parse(ctx, ss)
+		db, err := databaseutil.Parse(ctx, ss, s.logger())
 		if err != nil {
 			return err
 		}
@@ -36,9 +48,11 @@ func FS(fs filesystem.FileSystem) Option {
 	}
 }
 
+// ParseFS parses ss as a file system, which logs to the Logger set by the options
+// before it, or slog.Default.
 func ParseFS(ss string) Option {
 	return func(ctx context.Context, s *Server) error {
-		fs, err := filesystemutil.Parse(ss)
+		fs, err := filesystemutil.Parse(ctx, ss, s.logger())
 		if err != nil {
 			return err
 		}
@@ -66,3 +80,604 @@ func Data(path string) Option {
 		return nil
 	}
 }
+
+// PathPrefix mounts the server under prefix, which must be stripped from
+// requests before they reach it, such as with http.StripPrefix, so URLs it
+// responds with include it.
+func PathPrefix(prefix string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.PathPrefix = prefix
+		return nil
+	}
+}
+
+// PublicFS serves fsys, such as an embed.FS, alongside the data directory,
+// whose files take precedence over those in fsys.
+func PublicFS(fsys fs.FS) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.PublicFS = fsys
+		return nil
+	}
+}
+
+// ListDirectories lists the files of directories of the data directory and
+// PublicFS without an index.html, rather than responding with 404 Not Found.
+func ListDirectories() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ListDirectories = true
+		return nil
+	}
+}
+
+// SPAFallback serves the index.html of the data directory for paths without
+// extensions which are neither files nor entries, rather than 404 Not Found, so
+// single-page apps can route them.
+func SPAFallback() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.SPAFallback = true
+		return nil
+	}
+}
+
+// ProbePaths serves the liveness and readiness probes, and the alias of the
+// readiness probe, at the given paths. Empty paths disable them, so they're
+// served as any other.
+func ProbePaths(live, ready, health string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.LivePath, s.ReadyPath, s.HealthPath = live, ready, health
+		return nil
+	}
+}
+
+// MetricsPath serves metrics at path, or disables them if it's empty, so it's
+// served as any other.
+func MetricsPath(path string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.MetricsPath = path
+		return nil
+	}
+}
+
+// ProtectMetrics only serves metrics to requests with token as a bearer token,
+// if it isn't empty, or from addresses in allow. They're served to everyone if
+// neither is given.
+func ProtectMetrics(token string, allow ...netip.Prefix) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.MetricsToken, s.MetricsAllow = token, allow
+		return nil
+	}
+}
+
+// Precompress stores a gzip variant of compressible files of at least min
+// bytes at upload time.
+func Precompress(min int64) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Precompress = min
+		return nil
+	}
+}
+
+// DownloadStats keeps per-day download counts in the database, flushing them
+// every interval. The database must implement database.DownloadCounter.
+func DownloadStats(interval time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.DownloadStats = interval
+		return nil
+	}
+}
+
+// LastAccess tracks when entries were last downloaded, updating each entry at
+// most once per interval. The database must implement database.Toucher.
+func LastAccess(interval time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.LastAccess = interval
+		return nil
+	}
+}
+
+// PurgeAfter keeps soft deleted entries and their files for d, after which
+// the reaper purges them. The database must implement database.SoftDeleter.
+func PurgeAfter(d time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.PurgeAfter = d
+		return nil
+	}
+}
+
+// DatabaseMetrics sets whether metrics are recorded for calls to the
+// database, which they are by default.
+func DatabaseMetrics(enabled bool) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.DatabaseMetrics = enabled
+		return nil
+	}
+}
+
+// FileSystemMetrics sets whether metrics are recorded for calls to the file
+// system, which they are by default.
+func FileSystemMetrics(enabled bool) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.FileSystemMetrics = enabled
+		return nil
+	}
+}
+
+// Logger logs diagnostics with l, rather than slog.Default. Requests and
+// uploads are logged at the debug level, summaries of background work at the
+// info level, and failures at the warn and error levels.
+func Logger(l *slog.Logger) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Logger = l
+		return nil
+	}
+}
+
+// HandleErrors decides how requests which fail are responded to with h,
+// rather than DefaultErrorHandler.
+func HandleErrors(h ErrorHandler) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ErrorHandler = h
+		return nil
+	}
+}
+
+// AccessLog writes a line for each request to w in the Combined Log Format,
+// in the background so a slow w never holds up requests. The values of query
+// parameters such as signatures and passwords are redacted.
+func AccessLog(w io.Writer) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.AccessLog = w
+		return nil
+	}
+}
+
+// Registry registers metrics with r, and serves those gathered from g, rather
+// than a registry of the server's own, so they can be merged with those of a
+// program which embeds it. r and g would usually be the same registry, such
+// as prometheus.DefaultRegisterer and prometheus.DefaultGatherer.
+func Registry(r prometheus.Registerer, g prometheus.Gatherer) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Registerer, s.Gatherer = r, g
+		return nil
+	}
+}
+
+// Collectors registers cs alongside the server's metrics.
+func Collectors(cs ...prometheus.Collector) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Collectors = append(s.Collectors, cs...)
+		return nil
+	}
+}
+
+// TracerProvider traces requests, and calls to the database and file system,
+// with spans of tracers of tp. Incoming W3C trace contexts are continued.
+// Nothing is traced by default.
+func TracerProvider(tp trace.TracerProvider) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.TracerProvider = tp
+		return nil
+	}
+}
+
+// Profiling serves net/http/pprof's profiles under prefix, or
+// DefaultProfilePrefix if it's empty, to requests with token as a bearer
+// token, or from addresses in allow. At least one of them must be given, so
+// profiles are never served to anyone. Nothing else is served under prefix.
+func Profiling(prefix, token string, allow ...netip.Prefix) Option {
+	return func(ctx context.Context, s *Server) error {
+		if prefix == "" {
+			prefix = DefaultProfilePrefix
+		}
+		s.ProfilePrefix, s.ProfileToken, s.ProfileAllow = prefix, token, allow
+		return nil
+	}
+}
+
+// GracePeriod gives requests in flight d to finish when the server is shut
+// down by Serve or its variants. A negative period waits for them
+// indefinitely, and zero doesn't wait at all.
+func GracePeriod(d time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.GracePeriod = d
+		return nil
+	}
+}
+
+// Certificate serves TLS with the certificate and key in the named files,
+// which are reloaded when they change, or when the process is sent SIGHUP.
+func Certificate(certFile, keyFile string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.CertFile, s.KeyFile = certFile, keyFile
+		return nil
+	}
+}
+
+// ACME serves TLS with certificates for hosts obtained automatically from
+// Let's Encrypt, accepting its terms of service. They're cached in the
+// directory cache, so they aren't obtained again on every restart. Requests
+// for other hosts fail their TLS handshakes.
+func ACME(cache string, hosts ...string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ACMECache, s.ACMEHosts = cache, hosts
+		return nil
+	}
+}
+
+// RedirectHTTP serves plain HTTP on addr alongside TLS, redirecting requests
+// to HTTPS. ACME's HTTP challenges are answered there too.
+func RedirectHTTP(addr string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.RedirectAddr = addr
+		return nil
+	}
+}
+
+// SocketMode creates unix sockets listened on by ListenAndServe and its
+// variants with mode, such as 0660 so a reverse proxy in the same group can
+// connect to them.
+func SocketMode(mode os.FileMode) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.SocketMode = mode
+		return nil
+	}
+}
+
+// TrustedProxies trusts the Forwarded and X-Forwarded-For headers of requests
+// from proxies in ps to name their clients, which are otherwise their peers.
+// The client is the rightmost address which isn't a trusted proxy. See
+// ClientIP.
+func TrustedProxies(ps ...netip.Prefix) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.TrustedProxies = append(s.TrustedProxies, ps...)
+		return nil
+	}
+}
+
+// AllowHosts only serves requests for hosts, which may start with "*." to
+// allow any subdomain, responding to others with 421 Misdirected Request.
+func AllowHosts(hosts ...string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.AllowedHosts = append(s.AllowedHosts, hosts...)
+		return nil
+	}
+}
+
+// Namespaces serves the requests ns are for with servers of their own, so
+// their files are isolated from each other's and the server's.
+func Namespaces(ns ...Namespace) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Namespaces = append(s.Namespaces, ns...)
+		return nil
+	}
+}
+
+// DatabaseRetry retries failed database calls according to p. See
+// retry.Database for which calls are retried.
+func DatabaseRetry(p retry.Policy) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.DatabaseRetry = p
+		return nil
+	}
+}
+
+// StatsInterval exports statistics about the database as metrics, refreshed
+// every d. Databases without native aggregation are listed in full each time.
+func StatsInterval(d time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.StatsInterval = d
+		return nil
+	}
+}
+
+// NameKeys encrypts the names of entries in the database with keys, so they
+// can't be read without them. See package namecrypt.
+func NameKeys(keys namecrypt.KeyProvider) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.NameKeys = keys
+		return nil
+	}
+}
+
+// FileKey encrypts files at rest with key, which must be 32 bytes, so they
+// can't be read from the file system without it. Files stored before it was
+// set can't be read. See package filesystem/encrypt.
+func FileKey(key []byte) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.FileKey = key
+		return nil
+	}
+}
+
+// Compress compresses files at rest with zstd, storing those which don't
+// compress well as they are. Files stored before it was enabled can't be
+// read. See package filesystem/zstd.
+func Compress() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Compress = true
+		return nil
+	}
+}
+
+// Quota limits the total size of stored files to n bytes, rejecting uploads
+// which would exceed it with 507 Insufficient Storage. The total is computed
+// from the database when the server starts, and hourly after. Zero is
+// unlimited.
+func Quota(n int64) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Quota = n
+		return nil
+	}
+}
+
+// Admin serves the admin API under /admin/ to requests with token as a bearer
+// token. Entries can be listed, looked up, deleted and have their lifetimes
+// set through it.
+func Admin(token string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.AdminToken = token
+		return nil
+	}
+}
+
+// RequireAudit fails actions taken through the admin API if they can't be
+// appended to the database's audit log, which must implement
+// database.Auditor.
+func RequireAudit() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.RequireAudit = true
+		return nil
+	}
+}
+
+// ReadOnly starts the server read-only, refusing uploads while still serving
+// files, such as while its storage is migrated.
+func ReadOnly() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ReadOnly = true
+		return nil
+	}
+}
+
+// ReadOnlySignal toggles whether the server is read-only when the process is
+// sent SIGUSR2.
+func ReadOnlySignal() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ReadOnlySignal = true
+		return nil
+	}
+}
+
+// ReadOnlyAllowAdmin allows the admin API's mutations, such as deleting
+// entries, while the server is read-only, rather than refusing them.
+func ReadOnlyAllowAdmin() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ReadOnlyAllowAdmin = true
+		return nil
+	}
+}
+
+// ScanUploads scans the files of uploads with sc as they're read, rejecting
+// those it detects malware in before they're stored.
+func ScanUploads(sc Scanner) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Scanner = sc
+		return nil
+	}
+}
+
+// ScanFailOpen stores uploads which fail to be scanned, such as when the
+// scanner is unavailable, rather than rejecting them.
+func ScanFailOpen() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ScanFailOpen = true
+		return nil
+	}
+}
+
+// Timeouts sets how long clients have to send the headers of requests, how
+// long uploads and downloads may go without a byte of them moving, and how
+// long they may take at all. Zero doesn't limit them.
+func Timeouts(header, progress, transfer time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.HeaderTimeout, s.ProgressTimeout, s.TransferTimeout = header, progress, transfer
+		return nil
+	}
+}
+
+// MaxConcurrentUploads limits how many uploads may be in progress at once to
+// n. Uploads beyond it wait up to wait for one to finish, or are rejected with
+// 503 Service Unavailable and a Retry-After header straight away if wait is
+// zero.
+func MaxConcurrentUploads(n int64, wait time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.MaxConcurrentUploads, s.UploadWait = n, wait
+		return nil
+	}
+}
+
+// ShedLoad sheds requests beyond total in progress at once, and uploads and
+// downloads beyond uploads and downloads, with 503 Service Unavailable and a
+// Retry-After header, rather than queueing them. Zero doesn't limit them.
+func ShedLoad(total, uploads, downloads int64) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.MaxInFlight, s.MaxInFlightUploads, s.MaxInFlightDownloads = total, uploads, downloads
+		return nil
+	}
+}
+
+// Brownout sheds the fraction shed, between zero and one, of new uploads
+// while the p99 of how long recent uploads took is above latency.
+func Brownout(latency time.Duration, shed float64) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.BrownoutLatency, s.BrownoutShed = latency, shed
+		return nil
+	}
+}
+
+// UploadNetworks only lets clients upload from networks in allow, if it isn't
+// empty, and never from those in deny.
+func UploadNetworks(allow, deny []netip.Prefix) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.UploadAllow, s.UploadDeny = allow, deny
+		return nil
+	}
+}
+
+// UploadNetworkFiles adds the networks in the named files, one per line, to
+// those clients may and may not upload from, reading them again when the
+// process is sent SIGHUP. Either may be empty.
+func UploadNetworkFiles(allow, deny string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.UploadAllowFile, s.UploadDenyFile = allow, deny
+		return nil
+	}
+}
+
+// APIKeys lets clients authenticate uploads with keys, as bearer tokens.
+func APIKeys(keys ...APIKey) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.APIKeys = append(s.APIKeys, keys...)
+		return nil
+	}
+}
+
+// Users lets clients authenticate as users with their tokens, as bearer tokens.
+func Users(users ...User) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Users = append(s.Users, users...)
+		return nil
+	}
+}
+
+// UserAccounts lets users and their tokens be created in the database with the
+// admin API.
+func UserAccounts() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.UserAccounts = true
+		return nil
+	}
+}
+
+// VerifyUploads verifies uploads which aren't authenticated with an API key or
+// as a user with v before their files are read.
+func VerifyUploads(v Verifier) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Verifier = v
+		return nil
+	}
+}
+
+// Webhooks sends events for entries which are uploaded, deleted and expire
+// to urls, signed with secret.
+func Webhooks(secret string, urls ...string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.WebhookSecret = secret
+		s.Webhooks = append(s.Webhooks, urls...)
+		return nil
+	}
+}
+
+// PublishEvents publishes events for entries which are uploaded, deleted and
+// expire with ps.
+func PublishEvents(ps ...EventPublisher) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.EventPublishers = append(s.EventPublishers, ps...)
+		return nil
+	}
+}
+
+// SlidingLifetime extends the lifetime of files when they're downloaded to at
+// least d from then, but never to more than max from when they were uploaded
+// unless max is zero. The database must implement database.Extender.
+func SlidingLifetime(d, max time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.SlidingLifetime, s.MaxLifetime = d, max
+		return nil
+	}
+}
+
+// OrphanScan scans for files without entries every interval, removing those
+// last modified more than age ago. A zero age is a day. The file system must
+// implement filesystem.Walker.
+func OrphanScan(interval, age time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.OrphanInterval, s.OrphanAge = interval, age
+		return nil
+	}
+}
+
+// OrphanDryRun only reports orphans found by scans, rather than removing them.
+func OrphanDryRun() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.OrphanDryRun = true
+		return nil
+	}
+}
+
+// DanglingScan scans for entries whose files are missing every interval,
+// resolving them with action. Marking them requires the database to implement
+// database.SoftDeleter.
+func DanglingScan(interval time.Duration, action DanglingAction) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.DanglingInterval, s.DanglingAction = interval, action
+		return nil
+	}
+}
+
+// Reaper deletes expired entries and their files every interval, listing
+// batch of them at a time. A zero batch is database.DefaultListLimit.
+func Reaper(interval time.Duration, batch int) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ReapInterval, s.ReapBatch = interval, batch
+		return nil
+	}
+}
+
+// Staging stages uploads in fs before they're verified and promoted to the
+// file system, sweeping staged objects last modified more than age ago, such
+// as those left behind by crashes. A zero age is a day. fs must implement
+// filesystem.Walker, and may be shared with other objects, which are never
+// swept.
+func Staging(fs filesystem.FileSystem, age time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.Staging, s.StagingAge = fs, age
+		return nil
+	}
+}
+
+// ParseStaging parses ss as with ParseFS, and stages uploads in it as with
+// Staging.
+func ParseStaging(ss string, age time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		fs, err := filesystemutil.Parse(ctx, ss, s.logger())
+		if err != nil {
+			return fmt.Errorf("staging: %w", err)
+		}
+		return Staging(fs, age)(ctx, s)
+	}
+}
+
+// Eviction evicts the least recently accessed entries and their files every
+// interval while the total size of stored files exceeds high bytes, until it's
+// no more than low, which must be less. Entries tagged "pinned", and those
+// uploaded less than minAge ago, are never evicted. It composes with
+// lifetimes, evicting expired entries first, and with Quota, below which high
+// must be so uploads aren't rejected first. Access is best tracked with
+// LastAccess, otherwise the oldest uploads are evicted first.
+func Eviction(interval time.Duration, high, low int64, minAge time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.EvictInterval, s.EvictHigh, s.EvictLow, s.EvictMinAge = interval, high, low, minAge
+		return nil
+	}
+}
+
+// Reports accepts abuse reports of entries at /api/report, at most limit from
+// each client's network per window. The database must implement
+// database.Reporter.
+func Reports(limit int, window time.Duration) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.ReportLimit, s.ReportWindow = limit, window
+		return nil
+	}
+}
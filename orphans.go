@@ -0,0 +1,280 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+const (
+	// defaultOrphanAge is how long ago files must have been modified to be
+	// considered orphans if Server.OrphanAge isn't set.
+	defaultOrphanAge = 24 * time.Hour
+	// orphanBatch is how many files are looked up at once.
+	orphanBatch = 100
+	// adminOrphanScan is the path orphan scans are run from.
+	adminOrphanScan = adminPrefix + "scans/orphans"
+)
+
+// An OrphanReport describes the orphans found by ScanOrphans.
+type OrphanReport struct {
+	// Files is how many files were scanned.
+	Files int
+	// Orphans are the names of files without entries, and Bytes the space
+	// they take up.
+	Orphans []string
+	Bytes   int64
+	// Removed is how many orphans were removed, and Reclaimed the space
+	// they took up. Both are zero for dry runs.
+	Removed   int
+	Reclaimed int64
+}
+
+// ScanOrphans finds files without entries, such as those of uploads which
+// crashed or entries deleted by hand, and removes them unless dryRun. The file
+// system must implement filesystem.Walker.
+//
+// Files are stored before their entries are created, so only files last
+// modified more than OrphanAge ago are considered, and each is looked up again
+// just before it's removed. Files which can't be removed are logged and left
+// for the next scan.
+func (s Server) ScanOrphans(ctx context.Context, dryRun bool) (OrphanReport, error) {
+	w, ok := s.FileSystem.(filesystem.Walker)
+	if !ok {
+		return OrphanReport{}, errors.New("filesystem does not support listing")
+	}
+	age := s.OrphanAge
+	if age <= 0 {
+		age = defaultOrphanAge
+	}
+	before := time.Now().Add(-age)
+
+	var (
+		rep   OrphanReport
+		batch []filesystem.FileInfo
+	)
+	flush := func() error {
+		defer func() { batch = batch[:0] }()
+		orphans, err := s.orphans(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, fi := range orphans {
+			rep.Orphans = append(rep.Orphans, fi.Name)
+			rep.Bytes += fi.Size
+			if dryRun {
+				continue
+			}
+			if err := s.removeOrphan(ctx, fi.Name); err != nil {
+				s.logger().Warn("remove orphan", "name", fi.Name, "error", err)
+				continue
+			}
+			rep.Removed++
+			rep.Reclaimed += fi.Size
+			if s.orphanMetrics != nil {
+				s.orphanMetrics.removed(fi.Size)
+			}
+		}
+		return nil
+	}
+	if err := w.Walk(ctx, func(fi filesystem.FileInfo) error {
+		// Staged objects are swept separately.
+		if isStaged(fi.Name) {
+			return nil
+		}
+		rep.Files++
+		// Files without modification times can't be told apart from
+		// uploads in progress.
+		if fi.ModTime.IsZero() || fi.ModTime.After(before) {
+			return nil
+		}
+		if batch = append(batch, fi); len(batch) < orphanBatch {
+			return nil
+		}
+		return flush()
+	}); err != nil {
+		return rep, fmt.Errorf("walk: %w", err)
+	}
+	if err := flush(); err != nil {
+		return rep, err
+	}
+	if s.orphanMetrics != nil {
+		s.orphanMetrics.found(rep)
+	}
+	return rep, nil
+}
+
+// orphans returns the files in fis without entries. Gzip variants are orphans
+// if their entry doesn't have one.
+func (s Server) orphans(ctx context.Context, fis []filesystem.FileInfo) ([]filesystem.FileInfo, error) {
+	if len(fis) == 0 {
+		return nil, nil
+	}
+	slugs := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		slugs = append(slugs, strings.TrimSuffix(fi.Name, ".gz"))
+	}
+	entries, err := s.Database.LookupMany(ctx, slugs)
+	if err != nil {
+		return nil, fmt.Errorf("lookup: %w", err)
+	}
+	var orphans []filesystem.FileInfo
+	for i, fi := range fis {
+		if e, ok := entries[slugs[i]]; !ok || (fi.Name != e.Slug && e.GzipSize == 0) {
+			orphans = append(orphans, fi)
+		}
+	}
+	return orphans, nil
+}
+
+// removeOrphan removes the named orphan, unless its entry was created since it
+// was found.
+func (s Server) removeOrphan(ctx context.Context, name string) error {
+	slug := strings.TrimSuffix(name, ".gz")
+	e, err := s.Database.Lookup(ctx, slug)
+	if err == nil && (name == slug || e.GzipSize > 0) {
+		return errors.New("no longer an orphan")
+	}
+	if err != nil && !errors.Is(err, database.ErrNoResults) {
+		return fmt.Errorf("lookup: %w", err)
+	}
+	if err := s.FileSystem.Remove(ctx, name); err != nil && !filesystem.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// orphanMetrics export the results of orphan scans.
+type orphanMetrics struct {
+	orphans, orphanBytes prometheus.Gauge
+	removals, reclaimed  prometheus.Counter
+}
+
+func newOrphanMetrics(r prometheus.Registerer) (*orphanMetrics, error) {
+	m := &orphanMetrics{
+		orphans: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "orphans",
+			Help:      "Number of files without entries found by the last orphan scan.",
+		}),
+		orphanBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "orphans_bytes",
+			Help:      "Total size of the files without entries found by the last orphan scan.",
+		}),
+		removals: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "orphans_removed_total",
+			Help:      "Number of files without entries removed.",
+		}),
+		reclaimed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "orphans_reclaimed_bytes_total",
+			Help:      "Total size of the files without entries removed.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.orphans, m.orphanBytes, m.removals, m.reclaimed} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// found records the orphans found by a complete scan.
+func (m *orphanMetrics) found(rep OrphanReport) {
+	m.orphans.Set(float64(len(rep.Orphans)))
+	m.orphanBytes.Set(float64(rep.Bytes))
+}
+
+// removed records the removal of an orphan of size bytes.
+func (m *orphanMetrics) removed(size int64) {
+	m.removals.Inc()
+	m.reclaimed.Add(float64(size))
+}
+
+// runOrphanScans scans for orphans every interval until ctx is done.
+func runOrphanScans(ctx context.Context, s Server, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		// Orphans are only reported while the server is read-only.
+		rep, err := s.ScanOrphans(ctx, s.OrphanDryRun || s.readOnly.On())
+		if err != nil {
+			s.logger().Error("scan orphans", "error", err)
+			continue
+		}
+		if len(rep.Orphans) > 0 {
+			s.logger().Info("found orphans", "orphans", len(rep.Orphans), "files", rep.Files, "bytes", rep.Bytes, "removed", rep.Removed)
+		}
+	}
+}
+
+// adminOrphanReport is an OrphanReport as the admin API serves it.
+type adminOrphanReport struct {
+	Files     int      `json:"files"`
+	Orphans   []string `json:"orphans"`
+	Bytes     int64    `json:"bytes"`
+	Removed   int      `json:"removed"`
+	Reclaimed int64    `json:"reclaimed"`
+}
+
+// serveAdminOrphanScan scans for orphans, removing them unless the body is a
+// dry run, and serves what was found.
+func (s Server) serveAdminOrphanScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	var req struct {
+		DryRun *bool `json:"dry_run"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+		return
+	}
+	if req.DryRun == nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "dry_run is required"))
+		return
+	}
+	if _, ok := s.FileSystem.(filesystem.Walker); !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "filesystem does not support listing"))
+		return
+	}
+	type scan struct {
+		DryRun bool `json:"dry_run"`
+	}
+	if err := s.audit(r, "scan orphans", "", nil, scan{*req.DryRun}); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	rep, err := s.ScanOrphans(r.Context(), *req.DryRun)
+	if err != nil {
+		s.adminDatabaseError(w, r, "scan orphans", err)
+		return
+	}
+	s.adminJSON(w, r, http.StatusOK, adminOrphanReport{
+		Files:     rep.Files,
+		Orphans:   rep.Orphans,
+		Bytes:     rep.Bytes,
+		Removed:   rep.Removed,
+		Reclaimed: rep.Reclaimed,
+	})
+}
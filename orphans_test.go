@@ -0,0 +1,103 @@
+package kipp
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestScanOrphans(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, fs := memory.New(), memfs.New()
+	// a has a gzip variant, b doesn't, so b.gz is an orphan as is c.
+	for slug, gzipSize := range map[string]int64{"a": 3, "b": 0} {
+		e := databasetest.NewEntry(slug)
+		e.GzipSize = gzipSize
+		if err := db.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	for _, name := range []string{"a", "a.gz", "b", "b.gz", "c"} {
+		if err := fs.Create(ctx, name, strings.NewReader("abc")); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+
+	s, err := New(ctx, DB(db), FS(fs), OrphanScan(0, time.Hour), DatabaseMetrics(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The files are too new to be orphans.
+	if rep, err := s.ScanOrphans(ctx, false); err != nil || len(rep.Orphans) > 0 || rep.Files != 5 {
+		t.Fatalf("unexpected report; got %+v and %v, want 5 files and no orphans", rep, err)
+	}
+
+	s.OrphanAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	rep, err := s.ScanOrphans(ctx, true)
+	if err != nil {
+		t.Fatalf("scan orphans: %v", err)
+	}
+	sort.Strings(rep.Orphans)
+	if want := []string{"b.gz", "c"}; !reflect.DeepEqual(rep.Orphans, want) || rep.Bytes != 6 || rep.Removed != 0 {
+		t.Fatalf("unexpected dry run report; got %+v, want orphans %q taking up 6 bytes and none removed", rep, want)
+	}
+	if _, err := fs.Stat(ctx, "c"); err != nil {
+		t.Fatalf("dry run removed orphan: %v", err)
+	}
+
+	if rep, err = s.ScanOrphans(ctx, false); err != nil || rep.Removed != 2 || rep.Reclaimed != 6 {
+		t.Fatalf("unexpected report; got %+v and %v, want 2 orphans removed taking up 6 bytes", rep, err)
+	}
+	for _, name := range []string{"b.gz", "c"} {
+		if _, err := fs.Stat(ctx, name); !filesystem.IsNotExist(err) {
+			t.Fatalf("orphan %s wasn't removed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"a", "a.gz", "b"} {
+		if _, err := fs.Stat(ctx, name); err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+	}
+	if got := testutil.ToFloat64(s.orphanMetrics.reclaimed); got != 6 {
+		t.Fatalf("unexpected reclaimed bytes; got %v, want 6", got)
+	}
+}
+
+// TestRemoveOrphanCreated checks orphans aren't removed if their entries were
+// created since they were found.
+func TestRemoveOrphanCreated(t *testing.T) {
+	ctx := context.Background()
+	s := Server{Database: memory.New(), FileSystem: memfs.New()}
+	if err := s.FileSystem.Create(ctx, "a", strings.NewReader("abc")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := s.Database.Create(ctx, databasetest.NewEntry("a")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := s.removeOrphan(ctx, "a"); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := s.FileSystem.Stat(ctx, "a"); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+}
+
+func TestOrphanScanUnsupported(t *testing.T) {
+	// Hide the file system's Walk method.
+	fs := struct{ filesystem.FileSystem }{memfs.New()}
+	if _, err := New(context.Background(), DB(memory.New()), FS(fs), OrphanScan(time.Hour, 0), DatabaseMetrics(false)); err == nil {
+		t.Fatal("expected error")
+	}
+}
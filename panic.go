@@ -0,0 +1,54 @@
+package kipp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverRequest recovers a panic while serving r, which must be deferred by
+// ServeHTTP. The panic is logged with its stack and counted, and r is
+// responded to with 500 Internal Server Error if nothing has been written to
+// w yet. Otherwise, the response can't be fixed, so it's aborted, as it is if
+// the panic was http.ErrAbortHandler.
+func (s Server) recoverRequest(w *statusWriter, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	s.reportPanic(r.Context(), v, debug.Stack())
+	if w.status != 0 {
+		panic(http.ErrAbortHandler)
+	}
+	s.httpError(w, r, internalError(fmt.Errorf("panic: %v", v)))
+}
+
+// recoverTo recovers a panic of a goroutine other than that of a request,
+// which must be deferred by it, as the goroutine would otherwise crash the
+// process. The panic is logged and counted, and stored in err, unless it was
+// http.ErrAbortHandler, which is stored as is so whoever gets it can abort
+// the request.
+func (s Server) recoverTo(ctx context.Context, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		*err = http.ErrAbortHandler
+		return
+	}
+	s.reportPanic(ctx, v, debug.Stack())
+	*err = fmt.Errorf("panic: %v", v)
+}
+
+// reportPanic logs the panic v with its stack, and counts it.
+func (s Server) reportPanic(ctx context.Context, v any, stack []byte) {
+	s.logger().ErrorContext(ctx, "panic", "panic", v, "stack", string(stack))
+	if s.httpMetrics != nil {
+		s.httpMetrics.panics.Inc()
+	}
+}
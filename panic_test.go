@@ -0,0 +1,205 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// panicFS is a file system which panics with v when it's called with the
+// method named method.
+type panicFS struct {
+	filesystem.FileSystem
+	method string
+	v      any
+}
+
+func (fs panicFS) Create(ctx context.Context, name string, r io.Reader) error {
+	if gzip := strings.HasSuffix(name, gzipName("")); fs.method == "Create" && !gzip || fs.method == "CreateGzip" && gzip {
+		panic(fs.v)
+	}
+	return fs.FileSystem.Create(ctx, name, r)
+}
+
+func (fs panicFS) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	if fs.method == "Open" {
+		panic(fs.v)
+	}
+	f, err := fs.FileSystem.Open(ctx, name)
+	if err != nil || fs.method != "Read" {
+		return f, err
+	}
+	return panicReader{f, fs.v}, nil
+}
+
+func (fs panicFS) Ping(ctx context.Context) error {
+	if fs.method == "Ping" {
+		panic(fs.v)
+	}
+	return nil
+}
+
+// panicReader is a file which panics with v when it's closed, once it's been
+// served.
+type panicReader struct {
+	filesystem.Reader
+	v any
+}
+
+func (r panicReader) Seek(offset int64, whence int) (int64, error) {
+	return r.Reader.(io.Seeker).Seek(offset, whence)
+}
+
+func (r panicReader) Close() error { panic(r.v) }
+
+// panicBody is a request body which panics with v once n bytes have been read
+// from r, so the upload has started, and fails after.
+type panicBody struct {
+	r        io.Reader
+	n        int
+	v        any
+	panicked bool
+}
+
+func (b *panicBody) Read(p []byte) (int, error) {
+	if b.panicked {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if b.n <= 0 {
+		b.panicked = true
+		panic(b.v)
+	}
+	if len(p) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= n
+	return n, err
+}
+
+func (b *panicBody) Close() error { return nil }
+
+func TestRecover(t *testing.T) {
+	newServer := func(t *testing.T, db database.Database, fs filesystem.FileSystem) (*Server, *bytes.Buffer) {
+		t.Helper()
+		var buf bytes.Buffer
+		l := slog.New(slog.NewJSONHandler(&buf, nil))
+		s, err := New(context.Background(), DB(db), FS(fs), Limit(1<<20), Precompress(1), Logger(l))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s, &buf
+	}
+	// uploaded returns a database and file system with a file uploaded to
+	// them, and its location.
+	uploaded := func(t *testing.T) (database.Database, filesystem.FileSystem, string) {
+		t.Helper()
+		db, fs := memory.New(), memfs.New()
+		s, _ := newServer(t, db, fs)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, uploadRequest(t, 10))
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+		}
+		return db, fs, w.Header().Get("Location")
+	}
+	// checkPanic checks a panic was logged with its stack and counted.
+	checkPanic := func(t *testing.T, s *Server, buf *bytes.Buffer, v string) {
+		t.Helper()
+		rec := find(t, records(t, buf), "panic")
+		if rec["panic"] != v || !strings.Contains(rec["stack"].(string), "panic_test.go") {
+			t.Fatalf("unexpected panic record: %v", rec)
+		}
+		if got := testutil.ToFloat64(s.httpMetrics.panics); got != 1 {
+			t.Fatalf("unexpected panics; got %v, want 1", got)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, method string
+		upload, body bool
+		code         int
+	}{
+		{"open", "Open", false, false, http.StatusInternalServerError},
+		{"create", "Create", true, false, http.StatusInternalServerError},
+		{"upload body", "", true, true, http.StatusInternalServerError},
+		// Variants never fail uploads.
+		{"create gzip", "CreateGzip", true, false, http.StatusSeeOther},
+		{"ping", "Ping", false, false, http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fs, loc := uploaded(t)
+			s, buf := newServer(t, db, panicFS{fs, tt.method, tt.name})
+			r := httptest.NewRequest(http.MethodGet, loc, nil)
+			switch {
+			case tt.method == "Ping":
+				r = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			case tt.body:
+				r = uploadRequest(t, 64<<10)
+				r.Body = &panicBody{r: r.Body, n: 32 << 10, v: tt.name}
+			case tt.upload:
+				r = uploadRequest(t, 10)
+			}
+			r.Header.Set(requestIDHeader, "some-id")
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status; got %d, want %d", w.Code, tt.code)
+			}
+			if tt.code == http.StatusInternalServerError && !strings.Contains(w.Body.String(), "request id: some-id") {
+				t.Fatalf("no request id in %q", w.Body)
+			}
+			checkPanic(t, s, buf, tt.name)
+		})
+	}
+
+	// Responses which have started are aborted.
+	t.Run("read", func(t *testing.T) {
+		db, fs, loc := uploaded(t)
+		s, buf := newServer(t, db, panicFS{fs, "Read", "read"})
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatalf("unexpected panic; got %v, want %v", v, http.ErrAbortHandler)
+			}
+			checkPanic(t, s, buf, "read")
+		}()
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, loc, nil))
+	})
+
+	// Aborts are passed on, and aren't counted.
+	t.Run("abort", func(t *testing.T) {
+		db, fs, loc := uploaded(t)
+		s, _ := newServer(t, db, panicFS{fs, "Open", http.ErrAbortHandler})
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatalf("unexpected panic; got %v, want %v", v, http.ErrAbortHandler)
+			}
+			if got := testutil.ToFloat64(s.httpMetrics.panics); got != 0 {
+				t.Fatalf("unexpected panics; got %v, want 0", got)
+			}
+		}()
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, loc, nil))
+	})
+	t.Run("abort upload", func(t *testing.T) {
+		s, _ := newServer(t, memory.New(), memfs.New())
+		r := uploadRequest(t, 64<<10)
+		r.Body = &panicBody{r: r.Body, n: 32 << 10, v: http.ErrAbortHandler}
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatalf("unexpected panic; got %v, want %v", v, http.ErrAbortHandler)
+			}
+		}()
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	})
+}
@@ -0,0 +1,86 @@
+package kipp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"strings"
+)
+
+// DefaultProfilePrefix is the path prefix profiles are served under if
+// Profiling isn't given one, as it is by net/http/pprof.
+const DefaultProfilePrefix = "/debug/pprof/"
+
+// checkProfiling checks profiles would be served somewhere which doesn't
+// shadow anything else, and only to those allowed to request them.
+func (s Server) checkProfiling() error {
+	if s.ProfilePrefix == "" {
+		return nil
+	}
+	if len(s.ProfilePrefix) < 3 || s.ProfilePrefix[0] != '/' || !strings.HasSuffix(s.ProfilePrefix, "/") {
+		return errors.New("profile prefix must be a directory, such as " + DefaultProfilePrefix)
+	}
+	if s.ProfileToken == "" && len(s.ProfileAllow) == 0 {
+		return errors.New("profiles must be guarded by a token or allowed addresses")
+	}
+	return nil
+}
+
+// isProfile reports whether path is of profiles, which are only served if
+// they're enabled.
+func (s Server) isProfile(path string) bool {
+	return s.ProfilePrefix != "" && strings.HasPrefix(path, s.ProfilePrefix)
+}
+
+// serveProfile serves the profile named by the path of r with net/http/pprof,
+// if r has the token or is from an allowed address.
+func (s Server) serveProfile(w http.ResponseWriter, r *http.Request) {
+	if !allowed(r, s.ProfileToken, s.ProfileAllow) {
+		if s.ProfileToken != "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="profiles"`)
+			s.httpError(w, r, newError(http.StatusUnauthorized, CodeUnauthorized, http.StatusText(http.StatusUnauthorized)))
+			return
+		}
+		s.httpError(w, r, newError(http.StatusForbidden, CodeForbidden, http.StatusText(http.StatusForbidden)))
+		return
+	}
+	// The handlers expect to be served under DefaultProfilePrefix, so
+	// they're picked by name rather than path.
+	switch name := strings.TrimPrefix(r.URL.Path, s.ProfilePrefix); name {
+	case "":
+		pprof.Index(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
+
+// allowed reports whether r has token as a bearer token, if it isn't empty, or
+// its client is at an address in allow.
+func allowed(r *http.Request, token string, allow []netip.Prefix) bool {
+	if token != "" {
+		t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	addr, ok := ClientIP(r.Context())
+	if !ok {
+		return false
+	}
+	for _, p := range allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestProfiling(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := New(ctx,
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<20),
+		Profiling("", "secret", netip.MustParsePrefix("192.0.2.0/24")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, path, remote, token string
+		code                      int
+		body                      string
+	}{
+		{"no token", "/debug/pprof/heap?debug=1", "198.51.100.1:1234", "", http.StatusUnauthorized, ""},
+		{"wrong token", "/debug/pprof/heap?debug=1", "198.51.100.1:1234", "guess", http.StatusUnauthorized, ""},
+		{"token", "/debug/pprof/heap?debug=1", "198.51.100.1:1234", "secret", http.StatusOK, "heap profile:"},
+		{"allowed address", "/debug/pprof/goroutine?debug=1", "192.0.2.1:1234", "", http.StatusOK, "goroutine profile:"},
+		{"index", "/debug/pprof/", "192.0.2.1:1234", "", http.StatusOK, "heap"},
+		{"unknown profile", "/debug/pprof/missing", "192.0.2.1:1234", "", http.StatusNotFound, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = tt.remote
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status; got %d, want %d", w.Code, tt.code)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Fatalf("no %q in:\n%s", tt.body, w.Body)
+			}
+		})
+	}
+
+	// Without a token, addresses which aren't allowed are forbidden.
+	s, err = New(ctx, DB(memory.New()), FS(memfs.New()), Profiling("/_pprof/", "", netip.MustParsePrefix("192.0.2.0/24")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/_pprof/heap", nil)
+	r.RemoteAddr = "198.51.100.1:1234"
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	for _, opt := range []Option{
+		Profiling("", ""),
+		Profiling("/", "secret"),
+		Profiling("/debug/pprof", "secret"),
+	} {
+		if _, err := New(ctx, DB(memory.New()), FS(memfs.New()), opt); err == nil {
+			t.Fatal("expected error for unguarded or invalid profiling")
+		}
+	}
+}
+
+// TestProfilingDisabled checks nothing is served under the prefix unless
+// profiling is enabled.
+func TestProfilingDisabled(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/debug/pprof/heap?debug=1", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Files are still served.
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, uploadRequest(t, 10))
+	w2 := httptest.NewRecorder()
+	s.ServeHTTP(w2, httptest.NewRequest(http.MethodGet, w.Header().Get("Location"), nil))
+	if w2.Code != http.StatusOK {
+		t.Fatalf("unexpected status; got %d, want %d", w2.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,147 @@
+package kipp
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// gzipName returns the name of the gzip variant for the named object.
+func gzipName(name string) string { return name + ".gz" }
+
+// compressible reports whether content of the given type is likely to
+// benefit from compression.
+func compressible(ctype string) bool {
+	if i := strings.IndexByte(ctype, ';'); i > -1 {
+		ctype = ctype[:i]
+	}
+	ctype = strings.TrimSpace(ctype)
+	if strings.HasPrefix(ctype, "text/") ||
+		strings.HasSuffix(ctype, "+json") ||
+		strings.HasSuffix(ctype, "+xml") {
+		return true
+	}
+	switch ctype {
+	case "application/json",
+		"application/javascript",
+		"application/x-javascript",
+		"application/x-ndjson",
+		"application/xml",
+		"application/x-sh",
+		"application/x-tar":
+		return true
+	}
+	return false
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header s permits the
+// given content coding.
+func acceptsEncoding(s, coding string) bool {
+	var wildcard bool
+	for _, v := range strings.Split(s, ",") {
+		name, params := v, ""
+		if i := strings.IndexByte(v, ';'); i > -1 {
+			name, params = v[:i], v[i+1:]
+		}
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+		ok := true
+		for _, p := range strings.Split(params, ";") {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(p[2:], 64)
+			ok = err == nil && q > 0
+		}
+		if name != "*" {
+			return ok
+		}
+		wildcard = ok
+	}
+	return wildcard
+}
+
+// A gzipVariant writes a gzip compressed copy of everything written to it
+// to a filesystem. Errors are recorded rather than returned so the variant
+// never interrupts the upload it is attached to.
+type gzipVariant struct {
+	fs   filesystem.FileSystem
+	name string
+	pw   *io.PipeWriter
+	zw   *gzip.Writer
+	n    int64
+	err  error
+	done chan error
+}
+
+// newGzipVariant returns a variant of the named file, stored in the file
+// system of s.
+func (s Server) newGzipVariant(ctx context.Context, name string) *gzipVariant {
+	pr, pw := io.Pipe()
+	v := &gzipVariant{
+		fs:   s.FileSystem,
+		name: gzipName(name),
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	v.zw = gzip.NewWriter(writerFunc(func(b []byte) (int, error) {
+		n, err := pw.Write(b)
+		v.n += int64(n)
+		return n, err
+	}))
+	go func() {
+		var err error
+		defer func() {
+			// Unblock any pending writes if create returned early.
+			pr.CloseWithError(io.ErrClosedPipe)
+			v.done <- err
+		}()
+		defer s.recoverTo(ctx, &err)
+		err = s.FileSystem.Create(ctx, v.name, pr)
+	}()
+	return v
+}
+
+func (v *gzipVariant) Write(b []byte) (int, error) {
+	if v.err == nil {
+		_, v.err = v.zw.Write(b)
+	}
+	return len(b), nil
+}
+
+// Commit finishes the variant, returning its size. The variant is removed
+// and a zero size is returned if keep is false, or if it ended up no smaller
+// than the n bytes it was made from.
+func (v *gzipVariant) Commit(ctx context.Context, n int64, keep bool) (int64, error) {
+	if v.err == nil {
+		v.err = v.zw.Close()
+	}
+	v.pw.CloseWithError(v.err)
+	if err := <-v.done; v.err == nil {
+		v.err = err
+	}
+	if v.err != nil || !keep || v.n >= n {
+		v.fs.Remove(ctx, v.name)
+		return 0, v.err
+	}
+	return v.n, nil
+}
+
+// Abort discards the variant.
+func (v *gzipVariant) Abort(ctx context.Context, err error) {
+	v.pw.CloseWithError(err)
+	<-v.done
+	v.fs.Remove(ctx, v.name)
+}
+
+// writerFunc implements io.Writer.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
@@ -0,0 +1,42 @@
+package kipp
+
+import "testing"
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: "gzip", want: true},
+		{header: "GZIP", want: true},
+		{header: "deflate, gzip;q=1.0, *;q=0.5", want: true},
+		{header: "gzip;q=0", want: false},
+		{header: "br, *", want: true},
+		{header: "br, *;q=0", want: false},
+		{header: "gzip;q=0, *", want: false},
+		{header: "identity", want: false},
+	} {
+		if got := acceptsEncoding(tt.header, "gzip"); got != tt.want {
+			t.Errorf("acceptsEncoding(%q); got %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for _, tt := range []struct {
+		ctype string
+		want  bool
+	}{
+		{ctype: "text/plain; charset=utf-8", want: true},
+		{ctype: "application/json", want: true},
+		{ctype: "image/svg+xml", want: true},
+		{ctype: "image/png", want: false},
+		{ctype: "application/zip", want: false},
+		{ctype: "application/octet-stream", want: false},
+	} {
+		if got := compressible(tt.ctype); got != tt.want {
+			t.Errorf("compressible(%q); got %t, want %t", tt.ctype, got, tt.want)
+		}
+	}
+}
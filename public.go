@@ -0,0 +1,108 @@
+package kipp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// publicIndex is the file served for directories of PublicPath and PublicFS.
+const publicIndex = "index.html"
+
+// openPublic opens name as openPublicFile does. Directories without an index
+// don't exist unless ListDirectories is set, and those with one can't be
+// listed, so what's in them is never revealed.
+func (s Server) openPublic(name string) (http.File, error) {
+	f, err := s.openPublicFile(name)
+	if err != nil || s.ListDirectories {
+		return f, err
+	}
+	d, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !d.IsDir() {
+		return f, nil
+	}
+	index, err := s.openPublicFile(path.Join(name, publicIndex))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	index.Close()
+	return unlistedDir{f}, nil
+}
+
+// unlistedDir is a directory which can't be listed.
+type unlistedDir struct {
+	http.File
+}
+
+func (unlistedDir) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, fs.ErrPermission
+}
+
+// openPublicFile opens name from PublicPath, or from PublicFS if it isn't
+// there, so files on disk override those embedded.
+func (s Server) openPublicFile(name string) (http.File, error) {
+	if s.PublicPath != "" || s.PublicFS == nil {
+		f, err := http.Dir(s.PublicPath).Open(name)
+		if s.PublicFS == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return http.FS(s.PublicFS).Open(name)
+}
+
+// publicEtag returns the Etag of the public file f, named name, whose info is
+// d. Files with mod times have nginx style weak Etags of them and their
+// sizes, but embedded files have none, so theirs are hashes of their contents,
+// which are only computed once as they never change.
+func (s Server) publicEtag(f http.File, name string, d fs.FileInfo) (string, error) {
+	if !d.ModTime().IsZero() {
+		return fmt.Sprintf(`W/"%x-%x"`, d.ModTime().Unix(), d.Size()), nil
+	}
+	if etag, ok := s.publicEtags.load(name); ok {
+		return etag, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	s.publicEtags.store(name, etag)
+	return etag, nil
+}
+
+// etagCache caches the Etags of embedded files by name. A nil cache, of a
+// server made without New, caches nothing.
+type etagCache struct {
+	m sync.Map
+}
+
+func (c *etagCache) load(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	v, ok := c.m.Load(name)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+func (c *etagCache) store(name, etag string) {
+	if c != nil {
+		c.m.Store(name, etag)
+	}
+}
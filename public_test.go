@@ -0,0 +1,154 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestPublicFS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.css"), []byte("overridden"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	embedded := fstest.MapFS{
+		"index.html":      {Data: []byte("<title>kipp</title>")},
+		"app.css":         {Data: []byte("body{}")},
+		"app.js":          {Data: []byte("app()")},
+		"docs/index.html": {Data: []byte("<title>docs</title>")},
+	}
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Data(dir), PublicFS(embedded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := databasetest.NewEntry("entry")
+	e.Lifetime = nil
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FileSystem.Create(ctx, e.Slug, strings.NewReader("some data")); err != nil {
+		t.Fatal(err)
+	}
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, tt := range []struct {
+		target string
+		status int
+		body   string
+	}{
+		// Directories are served their index.
+		{"/", http.StatusOK, "<title>kipp</title>"},
+		{"/docs/", http.StatusOK, "<title>docs</title>"},
+		{"/app.js", http.StatusOK, "app()"},
+		// Files on disk override those embedded.
+		{"/app.css", http.StatusOK, "overridden"},
+		// Files which aren't embedded fall through to entries.
+		{"/entry.txt", http.StatusOK, "some data"},
+		{"/missing.txt", http.StatusNotFound, ""},
+	} {
+		w := get(tt.target, "")
+		if w.Code != tt.status || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.target, w.Code, w.Body, tt.status, tt.body)
+		}
+	}
+
+	// Embedded files have no mod times, so their Etags are of their
+	// contents, and they're cached as the files of PublicPath are.
+	w := get("/app.js", "")
+	etag := w.Header().Get("Etag")
+	if !strings.HasPrefix(etag, `"`) || w.Header().Get("Cache-Control") != "max-age=31536000" {
+		t.Fatalf("unexpected headers; got Etag %q, Cache-Control %q", etag, w.Header().Get("Cache-Control"))
+	}
+	if w := get("/app.js", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusNotModified)
+	}
+	if got := get("/app.css", "").Header().Get("Etag"); !strings.HasPrefix(got, `W/"`) {
+		t.Fatalf("unexpected Etag of a file on disk; got %q", got)
+	}
+	if got := get("/", "").Header().Get("Etag"); got == "" || got == etag {
+		t.Fatalf("files with different contents have the same Etag %q", got)
+	}
+}
+
+func TestListDirectories(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"docs/index.html":         "<title>docs</title>",
+		"docs/private/secret.txt": "secret",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newServer := func(opts ...Option) *Server {
+		t.Helper()
+		s, err := New(context.Background(), append([]Option{DB(memory.New()), FS(memfs.New()), Data(dir)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	get := func(s *Server, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	s := newServer()
+	for _, tt := range []struct {
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		// Directories with an index are still redirected to have a
+		// trailing slash, and served it.
+		{"/docs", http.StatusMovedPermanently, "docs/", ""},
+		{"/docs/", http.StatusOK, "", "<title>docs</title>"},
+		{"/docs/private", http.StatusNotFound, "", ""},
+		{"/docs/private/", http.StatusNotFound, "", ""},
+		{"/docs/private/secret.txt", http.StatusOK, "", "secret"},
+	} {
+		w := get(s, tt.target)
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("GET %s = %d %q %q, want %d %q %q", tt.target, w.Code, w.Header().Get("Location"), w.Body, tt.status, tt.location, tt.body)
+		}
+		if tt.body == "" && strings.Contains(w.Body.String(), "secret.txt") {
+			t.Errorf("GET %s listed a directory: %s", tt.target, w.Body)
+		}
+	}
+	// Directories with an index can't be listed either.
+	f, err := s.openPublic("/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Readdir(-1); err == nil {
+		t.Fatal("directory was listed")
+	}
+
+	if w := get(newServer(ListDirectories()), "/docs/private/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "secret.txt") {
+		t.Fatalf("unexpected listing; got %d %q", w.Code, w.Body)
+	}
+}
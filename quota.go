@@ -0,0 +1,134 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// usageInterval is the interval at which stored bytes are recomputed
+	// from the database, to correct for entries deleted without the
+	// server knowing, such as by database TTLs.
+	usageInterval = time.Hour
+	// quotaRetryAfter is suggested to clients rejected for exceeding the
+	// quota, as space is freed as entries expire.
+	quotaRetryAfter = time.Hour
+)
+
+// errQuota is returned when storing a file would exceed the quota.
+var errQuota = errors.New("storage quota exceeded")
+
+// usage tracks how many bytes are stored to enforce a quota. Uploads reserve
+// bytes as they're written, so concurrent uploads can't exceed the quota
+// between them; whichever writes past it first fails.
+type usage struct {
+	quota int64
+
+	mu sync.Mutex
+	// stored is the total size of the files of entries, and reserved the
+	// total size of uploads in progress.
+	stored, reserved int64
+}
+
+func newUsage(r prometheus.Registerer, quota int64) (*usage, error) {
+	u := &usage{quota: quota}
+	for _, c := range []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "stored_bytes",
+			Help:      "Total size of stored files, including uploads in progress.",
+		}, func() float64 {
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			return float64(u.stored + u.reserved)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "quota_bytes",
+			Help:      "Maximum total size of stored files.",
+		}, func() float64 { return float64(quota) }),
+	} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return u, nil
+}
+
+// Reserve reserves n bytes for an upload, returning errQuota if they'd exceed
+// the quota.
+func (u *usage) Reserve(n int64) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.stored+u.reserved+n > u.quota {
+		return errQuota
+	}
+	u.reserved += n
+	return nil
+}
+
+// Release releases n bytes reserved by an upload which failed.
+func (u *usage) Release(n int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.reserved -= n
+}
+
+// Commit stores n bytes reserved by an upload which succeeded, along with
+// extra bytes, such as for its gzip variant, which are stored regardless of
+// the quota.
+func (u *usage) Commit(n, extra int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.reserved -= n
+	u.stored += n + extra
+}
+
+// Remove removes n stored bytes.
+func (u *usage) Remove(n int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stored -= n
+}
+
+// Full reports whether nothing more can be stored.
+func (u *usage) Full() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.stored+u.reserved >= u.quota
+}
+
+// Load sets stored bytes from the statistics of s's database. Uploads which
+// finish while they're computed may be counted twice or not at all until
+// the next Load.
+func (u *usage) Load(ctx context.Context, s Server) error {
+	st, err := s.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stored = st.Bytes + st.GzipBytes
+	return nil
+}
+
+// Run reloads stored bytes from s every interval until ctx is done.
+func (u *usage) Run(ctx context.Context, s Server, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := u.Load(ctx, s); err != nil {
+				s.logger().Error("load usage", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
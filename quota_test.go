@@ -0,0 +1,132 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// upload uploads a file of n bytes to s, returning the response.
+func upload(t *testing.T, s *Server, n int) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, strings.Repeat("a", n))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.UploadHandler(w, r)
+	return w
+}
+
+func TestQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Entries from before the server started count towards the quota.
+	db := memory.New()
+	e := databasetest.NewEntry("existing")
+	e.Size, e.GzipSize = 50, 10
+	if err := db.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	fs := memfs.New()
+	s, err := New(ctx, DB(db), FS(fs), Limit(1<<20), Quota(100), DatabaseMetrics(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := upload(t, s, 30); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	size := fs.Size()
+	w := upload(t, s, 30)
+	if w.Code != http.StatusInsufficientStorage || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("unexpected response; got %d with Retry-After %q, want %d with Retry-After",
+			w.Code, w.Header().Get("Retry-After"), http.StatusInsufficientStorage)
+	}
+	if fs.Size() != size {
+		t.Fatalf("rejected upload was stored; got %d bytes, want %d", fs.Size(), size)
+	}
+	// The rejected upload's reservation is released.
+	if w := upload(t, s, 10); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if w := upload(t, s, 0); w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("unexpected status when full; got %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+
+	if err := s.Delete(ctx, e.Slug); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	w = upload(t, s, 50)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status after delete; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	// Reloading from the database agrees with the running total.
+	stored := s.usage.stored
+	if err := s.usage.Load(ctx, *s); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if s.usage.stored != stored {
+		t.Fatalf("unexpected stored bytes; got %d, want %d", s.usage.stored, stored)
+	}
+}
+
+// TestUsageConcurrent checks concurrent reservations never exceed the quota.
+func TestUsageConcurrent(t *testing.T) {
+	u, err := newUsage(prometheus.NewRegistry(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		ok int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reserved int64
+			for j := 0; j < 10; j++ {
+				if err := u.Reserve(10); err != nil {
+					u.Release(reserved)
+					return
+				}
+				reserved += 10
+			}
+			u.Commit(reserved, 0)
+			mu.Lock()
+			ok++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if u.stored > u.quota || u.reserved != 0 {
+		t.Fatalf("unexpected usage; got %d stored and %d reserved, want at most %d and 0", u.stored, u.reserved, u.quota)
+	}
+	if int64(ok)*100 != u.stored {
+		t.Fatalf("unexpected stored bytes; got %d, want %d", u.stored, ok*100)
+	}
+}
@@ -0,0 +1,217 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// adminReadOnly is the path of whether the server is read-only.
+	adminReadOnly = adminPrefix + "read-only"
+	// readOnlyRetryAfter is how long clients are asked to wait before
+	// retrying what was refused while the server is read-only.
+	readOnlyRetryAfter = time.Minute
+)
+
+// errReadOnly is responded to uploads while the server is read-only.
+var errReadOnly = newError(http.StatusServiceUnavailable, CodeReadOnly, "uploads are paused for maintenance, try again later")
+
+// readOnlyMode is whether the server is read-only, and counts the uploads in
+// flight, so it can be seen when those which started before it was made
+// read-only have finished. It's shared by copies of the Server.
+type readOnlyMode struct {
+	// mu is held for reading while uploads start, and for writing while
+	// the mode changes, so none start once it's read-only and every one
+	// which did is counted.
+	mu      sync.RWMutex
+	on      bool
+	since   time.Time
+	uploads atomic.Int64
+	gauge   prometheus.Gauge
+}
+
+func newReadOnlyMode(r prometheus.Registerer, on bool) (*readOnlyMode, error) {
+	m := &readOnlyMode{
+		gauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "read_only",
+			Help:      "Whether the server is read-only, refusing uploads.",
+		}),
+	}
+	if err := r.Register(m.gauge); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	m.Set(on)
+	return m, nil
+}
+
+// startUpload records an upload in flight, returning false if the server is
+// read-only, in which case it must be refused. Servers made without New are
+// never read-only.
+func (m *readOnlyMode) startUpload() bool {
+	if m == nil {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.on {
+		return false
+	}
+	m.uploads.Add(1)
+	return true
+}
+
+// finishUpload records an upload started with startUpload has finished.
+func (m *readOnlyMode) finishUpload() {
+	if m != nil {
+		m.uploads.Add(-1)
+	}
+}
+
+// On reports whether the server is read-only.
+func (m *readOnlyMode) On() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.on
+}
+
+// Set makes the server read-only or not, returning whether it was. Uploads
+// in flight once it returns started before it was made read-only, and are
+// allowed to finish.
+func (m *readOnlyMode) Set(on bool) (was bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	was = m.on
+	if on != was || m.since.IsZero() {
+		m.on, m.since = on, time.Now().UTC()
+	}
+	if on {
+		m.gauge.Set(1)
+	} else {
+		m.gauge.Set(0)
+	}
+	return was
+}
+
+// State returns whether the server is read-only as the admin API serves it.
+func (m *readOnlyMode) State() adminReadOnlyState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return adminReadOnlyState{ReadOnly: m.on, Since: m.since, Uploads: m.uploads.Load()}
+}
+
+// run toggles whether the server is read-only when the process is sent
+// SIGUSR2, until ctx is done.
+func (m *readOnlyMode) run(ctx context.Context, log *slog.Logger) {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	defer signal.Stop(usr2)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-usr2:
+		}
+		// The mode is read and set under separate locks, but signals
+		// are handled one at a time.
+		on := !m.On()
+		m.Set(on)
+		log.Info("toggled read-only", "read_only", on, "uploads", m.uploads.Load())
+	}
+}
+
+// SetReadOnly makes the server read-only, refusing uploads while still
+// serving files, or writable again. Uploads which already started are
+// allowed to finish.
+func (s Server) SetReadOnly(on bool) {
+	if s.readOnly != nil {
+		s.readOnly.Set(on)
+	}
+}
+
+// readOnlyUpload responds to r that uploads are refused while the server is
+// read-only.
+func (s Server) readOnlyUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(readOnlyRetryAfter.Seconds())))
+	s.httpError(w, r, errReadOnly)
+}
+
+// adminRefusesWhileReadOnly reports whether r, a request of the admin API,
+// must be refused because the server is read-only, responding to it if so.
+// Only mutations are refused, unless ReadOnlyAllowAdmin is set, and it can
+// always be made writable again.
+func (s Server) adminRefusesWhileReadOnly(w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, r.URL.Path == adminReadOnly:
+		return false
+	case s.ReadOnlyAllowAdmin || !s.readOnly.On():
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(readOnlyRetryAfter.Seconds())))
+	s.httpError(w, r, newError(http.StatusServiceUnavailable, CodeReadOnly, "the server is read-only"))
+	return true
+}
+
+// adminReadOnlyState is whether the server is read-only as the admin API
+// serves it, since when, and how many uploads are in flight.
+type adminReadOnlyState struct {
+	ReadOnly bool      `json:"read_only"`
+	Since    time.Time `json:"since"`
+	Uploads  int64     `json:"uploads"`
+}
+
+// serveAdminReadOnly serves whether the server is read-only, and sets it to
+// that of the body of PUTs.
+func (s Server) serveAdminReadOnly(w http.ResponseWriter, r *http.Request) {
+	// Servers made without New have no mode.
+	if s.readOnly == nil {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		var req struct {
+			ReadOnly *bool `json:"read_only"`
+		}
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+			return
+		}
+		if req.ReadOnly == nil {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "read_only is required"))
+			return
+		}
+		type change struct {
+			ReadOnly bool `json:"read_only"`
+		}
+		if err := s.audit(r, "set read-only", "", change{s.readOnly.On()}, change{*req.ReadOnly}); err != nil {
+			s.adminDatabaseError(w, r, "audit", err)
+			return
+		}
+		s.readOnly.Set(*req.ReadOnly)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	s.adminJSON(w, r, http.StatusOK, s.readOnly.State())
+}
@@ -0,0 +1,120 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestReadOnly(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20), Admin("secret"), ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := adminDo(s)
+
+	r := uploadRequest(t, 10)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusServiceUnavailable, w.Body)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("unexpected Retry-After; got %q, want %q", got, "60")
+	}
+	var res errorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal %q: %v", w.Body, err)
+	}
+	if res.Error.Code != CodeReadOnly {
+		t.Fatalf("unexpected code; got %q, want %q", res.Error.Code, CodeReadOnly)
+	}
+	if got := testutil.ToFloat64(s.readOnly.gauge); got != 1 {
+		t.Fatalf("unexpected gauge; got %v, want 1", got)
+	}
+
+	// It's still ready, and the admin API can still be read.
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected readiness; got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var state adminReadOnlyState
+	do(t, http.MethodGet, adminReadOnly, "", http.StatusOK, &state)
+	if !state.ReadOnly || state.Since.IsZero() {
+		t.Fatalf("unexpected state; got %+v, want read-only", state)
+	}
+	do(t, http.MethodGet, adminFiles, "", http.StatusOK, nil)
+	do(t, http.MethodDelete, adminFiles+"/a", "", http.StatusServiceUnavailable, nil)
+
+	do(t, http.MethodPut, adminReadOnly, `{}`, http.StatusBadRequest, nil)
+	do(t, http.MethodPut, adminReadOnly, `{"read_only": false}`, http.StatusOK, &state)
+	if state.ReadOnly {
+		t.Fatalf("unexpected state; got %+v, want writable", state)
+	}
+	if w := upload(t, s, 10); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	do(t, http.MethodDelete, adminFiles+"/a", "", http.StatusNotFound, nil)
+}
+
+func TestReadOnlyAllowAdmin(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Admin("secret"), ReadOnly(), ReadOnlyAllowAdmin())
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminDo(s)(t, http.MethodDelete, adminFiles+"/a", "", http.StatusNotFound, nil)
+}
+
+// TestReadOnlyInFlight checks uploads which started before the server was made
+// read-only finish.
+func TestReadOnlyInFlight(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	r := httptest.NewRequest(http.MethodPost, "/", pr)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.ServeHTTP(w, r)
+	}()
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, "some data")
+	for s.readOnly.State().Uploads != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.SetReadOnly(true)
+	if w := upload(t, s, 10); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusServiceUnavailable, w.Body)
+	}
+	io.WriteString(fw, strings.Repeat("a", 10))
+	mw.Close()
+	pw.Close()
+	<-done
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	if n := s.readOnly.State().Uploads; n != 0 {
+		t.Fatalf("unexpected uploads; got %d, want 0", n)
+	}
+}
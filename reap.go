@@ -0,0 +1,182 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// Reap deletes entries whose lifetime has ended along with their files,
+// returning how many were deleted and the total size of their files. Entries
+// are listed ReapBatch at a time. Entries without a lifetime are never
+// deleted.
+//
+// Files are removed before their entry, so an entry whose files can't be
+// removed is left for the next call rather than leaving them as orphans.
+// Entries which fail are logged and skipped. Reap stops when ctx is done,
+// returning its error.
+func (s Server) Reap(ctx context.Context) (n int, size int64, err error) {
+	opts := database.ListOptions{Limit: s.ReapBatch, Expired: true}
+	for {
+		entries, next, err := s.Database.List(ctx, opts)
+		if err != nil {
+			return n, size, fmt.Errorf("list: %w", err)
+		}
+		now := time.Now()
+		for _, e := range entries {
+			if err := ctx.Err(); err != nil {
+				return n, size, err
+			}
+			if e.Lifetime == nil || e.Lifetime.After(now) {
+				continue
+			}
+			if err := s.reap(ctx, e); err != nil {
+				s.logger().Warn("reap", "slug", e.Slug, "error", err)
+				if s.reapMetrics != nil {
+					s.reapMetrics.failures.Inc()
+				}
+				continue
+			}
+			s.notify(EventExpire, e)
+			n++
+			size += e.Size + e.GzipSize
+			if s.reapMetrics != nil {
+				s.reapMetrics.entries.Inc()
+				s.reapMetrics.bytes.Add(float64(e.Size + e.GzipSize))
+			}
+		}
+		if next == "" {
+			return n, size, nil
+		}
+		opts.Cursor = next
+	}
+}
+
+// reap removes the files of e, then deletes it. Files or entries which are
+// already gone aren't an error.
+func (s Server) reap(ctx context.Context, e database.Entry) error {
+	names := []string{e.Slug}
+	if e.GzipSize > 0 {
+		names = append(names, gzipName(e.Slug))
+	}
+	for _, name := range names {
+		if err := s.FileSystem.Remove(ctx, name); err != nil && !filesystem.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %w", name, err)
+		}
+	}
+	if err := s.Database.Delete(ctx, e.Slug); err != nil {
+		if errors.Is(err, database.ErrNoResults) {
+			return nil
+		}
+		return fmt.Errorf("delete: %w", err)
+	}
+	if s.usage != nil {
+		s.usage.Remove(e.Size + e.GzipSize)
+	}
+	if db, ok := s.Database.(database.DownloadCounter); ok {
+		if err := db.RemoveDownloads(ctx, e.Slug); err != nil {
+			s.logger().Warn("remove downloads", "slug", e.Slug, "error", err)
+		}
+	}
+	return nil
+}
+
+// reapMetrics export what's been reaped.
+type reapMetrics struct {
+	entries, bytes, failures prometheus.Counter
+}
+
+func newReapMetrics(r prometheus.Registerer) (*reapMetrics, error) {
+	m := &reapMetrics{
+		entries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "reaped_entries_total",
+			Help:      "Number of expired entries deleted.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "reaped_bytes_total",
+			Help:      "Total size of the files of expired entries deleted.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "reap_failures_total",
+			Help:      "Number of expired entries which failed to be deleted.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.entries, m.bytes, m.failures} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// runReaper reaps expired entries every interval until ctx is done, sweeps the
+// files of those the database removed by itself, and purges soft deleted
+// entries if s.PurgeAfter is non-zero.
+func runReaper(ctx context.Context, s Server, interval time.Duration) {
+	if _, ok := s.FileSystem.(filesystem.Walker); !ok && s.expires() {
+		s.logger().Warn("the database removes expired entries by itself, but the file system can't be listed, so their files are left behind")
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		// Expired entries are kept until the server is writable again,
+		// so storage can be migrated without them changing under it.
+		if s.readOnly.On() {
+			continue
+		}
+		n, size, err := s.Reap(ctx)
+		if n > 0 {
+			s.logger().Info("reaped expired entries", "entries", n, "bytes", size)
+		}
+		if err != nil && ctx.Err() == nil {
+			s.logger().Error("reap", "error", err)
+		}
+		rep, err := s.sweepExpired(ctx)
+		if rep.Removed > 0 {
+			s.logger().Info("removed files of expired entries", "files", rep.Removed, "bytes", rep.Reclaimed)
+		}
+		if err != nil && ctx.Err() == nil {
+			s.logger().Error("sweep expired", "error", err)
+		}
+		if s.PurgeAfter > 0 {
+			n, err := s.Purge(ctx)
+			if n > 0 {
+				s.logger().Info("purged soft deleted entries", "entries", n)
+			}
+			if err != nil && ctx.Err() == nil {
+				s.logger().Error("purge", "error", err)
+			}
+		}
+	}
+}
+
+// expires reports whether the database removes expired entries by itself.
+func (s Server) expires() bool {
+	db, ok := s.Database.(database.Expirer)
+	return ok && db.Expires()
+}
+
+// sweepExpired removes the files of entries the database removed by itself
+// once they expired, which Reap can't list, by removing files without entries
+// as ScanOrphans does. Nothing is swept if the database doesn't remove
+// expired entries, if orphan scans already run, or if the file system can't
+// be listed.
+func (s Server) sweepExpired(ctx context.Context) (OrphanReport, error) {
+	if _, ok := s.FileSystem.(filesystem.Walker); !ok || !s.expires() || s.OrphanInterval > 0 {
+		return OrphanReport{}, nil
+	}
+	return s.ScanOrphans(ctx, s.OrphanDryRun)
+}
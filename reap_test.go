@@ -0,0 +1,220 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// failRemove fails to remove the named object.
+type failRemove struct {
+	filesystem.FileSystem
+	name string
+}
+
+func (fs failRemove) Remove(ctx context.Context, name string) error {
+	if name == fs.name {
+		return errors.New("some error")
+	}
+	return fs.FileSystem.Remove(ctx, name)
+}
+
+func TestReap(t *testing.T) {
+	ctx := context.Background()
+
+	mfs := memfs.New()
+	s := Server{Database: memory.New(), FileSystem: failRemove{mfs, "e"}, ReapBatch: 1}
+	m, err := newReapMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.reapMetrics = m
+
+	// a, d and e have expired, though d's file is already gone and e's
+	// can't be removed. b hasn't expired and c has no lifetime.
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	for _, slug := range []string{"a", "b", "c", "d", "e"} {
+		e := databasetest.NewEntry(slug)
+		e.Size, e.GzipSize = 10, 0
+		switch slug {
+		case "a":
+			e.Lifetime, e.GzipSize = &past, 5
+		case "b":
+			e.Lifetime = &future
+		case "c":
+			e.Lifetime = nil
+		case "d", "e":
+			e.Lifetime = &past
+		}
+		if err := s.Database.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	for _, name := range []string{"a", "a.gz", "b", "c", "e"} {
+		if err := mfs.Create(ctx, name, strings.NewReader("abc")); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+
+	n, size, err := s.Reap(ctx)
+	if err != nil || n != 2 || size != 25 {
+		t.Fatalf("unexpected result; got %d entries taking up %d bytes and %v, want 2 taking up 25 bytes", n, size, err)
+	}
+	if got, want := listSlugs(t, s.Database), []string{"b", "c", "e"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entries; got %q, want %q", got, want)
+	}
+	for _, name := range []string{"a", "a.gz"} {
+		if _, err := mfs.Stat(ctx, name); !filesystem.IsNotExist(err) {
+			t.Fatalf("file %s wasn't removed: %v", name, err)
+		}
+	}
+	for _, v := range []struct {
+		c    prometheus.Collector
+		want float64
+	}{
+		{m.entries, 2},
+		{m.bytes, 25},
+		{m.failures, 1},
+	} {
+		if got := testutil.ToFloat64(v.c); got != v.want {
+			t.Fatalf("unexpected value; got %v, want %v", got, v.want)
+		}
+	}
+}
+
+func TestReapCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := Server{Database: memory.New(), FileSystem: memfs.New()}
+	past := time.Now().Add(-time.Hour)
+	e := databasetest.NewEntry("a")
+	e.Lifetime = &past
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	cancel()
+	if _, _, err := s.Reap(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error; got %v, want %v", err, context.Canceled)
+	}
+	if got := listSlugs(t, s.Database); len(got) != 1 {
+		t.Fatalf("unexpected entries; got %q, want %q", got, []string{"a"})
+	}
+}
+
+// listSlugs lists the slugs of every entry in db, including expired entries
+// which lookups hide.
+func listSlugs(t *testing.T, db database.Database) []string {
+	t.Helper()
+	entries, _, err := db.List(context.Background(), database.ListOptions{})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var slugs []string
+	for _, e := range entries {
+		slugs = append(slugs, e.Slug)
+	}
+	return slugs
+}
+
+// expiring is a database which claims to remove expired entries by itself.
+type expiring struct{ database.Database }
+
+func (expiring) Expires() bool { return true }
+
+func TestSweepExpired(t *testing.T) {
+	ctx := context.Background()
+	mfs := memfs.New()
+	s := Server{Database: memory.New(), FileSystem: mfs, OrphanAge: time.Nanosecond}
+	if err := s.Database.Create(ctx, databasetest.NewEntry("a")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// b's entry was removed by the database when it expired.
+	for _, name := range []string{"a", "b"} {
+		if err := mfs.Create(ctx, name, strings.NewReader("abc")); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	// Databases which don't remove expired entries leave nothing to sweep.
+	if rep, err := s.sweepExpired(ctx); err != nil || rep.Files != 0 {
+		t.Fatalf("unexpected report; got %+v and %v, want nothing scanned", rep, err)
+	}
+	// Nor do those whose files are already swept by orphan scans.
+	s.Database, s.OrphanInterval = expiring{s.Database}, time.Hour
+	if rep, err := s.sweepExpired(ctx); err != nil || rep.Files != 0 {
+		t.Fatalf("unexpected report; got %+v and %v, want nothing scanned", rep, err)
+	}
+
+	s.OrphanInterval = 0
+	if rep, err := s.sweepExpired(ctx); err != nil || rep.Removed != 1 || rep.Reclaimed != 3 {
+		t.Fatalf("unexpected report; got %+v and %v, want 1 file removed taking up 3 bytes", rep, err)
+	}
+	if _, err := mfs.Stat(ctx, "b"); !filesystem.IsNotExist(err) {
+		t.Fatalf("file b wasn't removed: %v", err)
+	}
+	if _, err := mfs.Stat(ctx, "a"); err != nil {
+		t.Fatalf("stat a: %v", err)
+	}
+}
+
+// TestRunReaperPurge checks the reaper purges entries soft deleted more than
+// PurgeAfter ago.
+func TestRunReaperPurge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := Server{Database: memory.New(), FileSystem: memfs.New(), PurgeAfter: time.Hour}
+	for _, slug := range []string{"old", "recent"} {
+		e := databasetest.NewEntry(slug)
+		e.Lifetime = nil
+		if err := s.Database.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := s.FileSystem.Create(ctx, slug, strings.NewReader("abc")); err != nil {
+			t.Fatalf("create %s: %v", slug, err)
+		}
+	}
+	sd := s.Database.(database.SoftDeleter)
+	if err := sd.SoftDelete(ctx, "old", time.Now().Add(-2*time.Hour), "abuse"); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if err := sd.SoftDelete(ctx, "recent", time.Now(), "abuse"); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runReaper(ctx, s, time.Millisecond)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		// Files are removed after their entry, so they're gone last.
+		if _, err := s.FileSystem.Stat(ctx, "old"); filesystem.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("old wasn't purged")
+		}
+	}
+	cancel()
+	<-done
+
+	ctx = context.Background()
+	if _, err := s.Database.Lookup(ctx, "old"); !errors.Is(err, database.ErrNoResults) {
+		t.Fatalf("unexpected error; got %v, want %v", err, database.ErrNoResults)
+	}
+	if _, err := s.Database.Lookup(ctx, "recent"); err != nil {
+		t.Fatalf("lookup recent: %v", err)
+	}
+}
@@ -0,0 +1,425 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+)
+
+const (
+	// reportPath is the path abuse reports are made at.
+	reportPath = "/api/report"
+	// adminReports is the path of reports grouped by entry, under which
+	// those of each are served by slug.
+	adminReports = adminPrefix + "reports"
+	// defaultReportWindow is the window reports are limited per if there
+	// isn't another.
+	defaultReportWindow = time.Hour
+	// reportInterval is the interval at which the number of open reports
+	// is reloaded from the database, to count those made to other
+	// instances.
+	reportInterval = time.Minute
+	// maxReportBody is the size of the largest report accepted.
+	maxReportBody = 16 << 10
+	// maxReportDescription is the length of the longest description of a
+	// report.
+	maxReportDescription = 4 << 10
+	// maxReportContact is the length of the longest email address.
+	maxReportContact = 254
+)
+
+// reportCategories are what entries may be reported for.
+var reportCategories = []string{"malware", "doxxing", "copyright", "other"}
+
+// reportLimiter limits how many reports clients may make per window, by their
+// network, so clients with many IPv6 addresses are limited as one. Windows are
+// fixed rather than sliding, so clients may make up to twice the limit across
+// the end of one, which is fine for reports.
+type reportLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu sync.Mutex
+	// start is when the current window started, and counts how many
+	// reports were made from each network in it.
+	start  time.Time
+	counts map[netip.Prefix]int
+}
+
+// Allow reports whether a client at addr may report at now, or how long it
+// must wait until it may otherwise. Clients without addresses are limited as
+// one.
+func (l *reportLimiter) Allow(addr netip.Addr, now time.Time) (bool, time.Duration) {
+	var network netip.Prefix
+	switch addr = addr.Unmap(); {
+	case addr.Is4():
+		network = netip.PrefixFrom(addr, 32)
+	case addr.Is6():
+		network, _ = addr.Prefix(64)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.start) >= l.window {
+		l.start, l.counts = now, make(map[netip.Prefix]int)
+	}
+	if l.counts[network] >= l.limit {
+		return false, l.start.Add(l.window).Sub(now)
+	}
+	l.counts[network]++
+	return true, 0
+}
+
+// reportMetrics count reports, and how many are open so a backlog can be
+// alerted on.
+type reportMetrics struct {
+	open     prometheus.Gauge
+	reported *prometheus.CounterVec
+	limited  prometheus.Counter
+}
+
+func newReportMetrics(r prometheus.Registerer) (*reportMetrics, error) {
+	m := &reportMetrics{
+		open: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "reports_open",
+			Help:      "Number of abuse reports which haven't been resolved.",
+		}),
+		reported: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "reports_total",
+			Help:      "Number of abuse reports made, by category.",
+		}, []string{"category"}),
+		limited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "reports_limited_total",
+			Help:      "Number of abuse reports rejected because their client made too many.",
+		}),
+	}
+	for _, c := range reportCategories {
+		m.reported.WithLabelValues(c)
+	}
+	for _, c := range []prometheus.Collector{m.open, m.reported, m.limited} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Load sets the number of open reports from the database of s.
+func (m *reportMetrics) Load(ctx context.Context, s Server) error {
+	reports, err := s.Database.(database.Reporter).Reports(ctx, database.ReportOpen)
+	if err != nil {
+		return err
+	}
+	m.open.Set(float64(len(reports)))
+	return nil
+}
+
+// Run reloads the number of open reports from s every interval until ctx is
+// done.
+func (m *reportMetrics) Run(ctx context.Context, s Server, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := m.Load(ctx, s); err != nil && ctx.Err() == nil {
+			s.logger().Error("load open reports", "error", err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// isReport reports whether path is where abuse reports are made, which it
+// only is if they're accepted.
+func (s Server) isReport(path string) bool {
+	return s.reports != nil && path == reportPath
+}
+
+// Report accepts an abuse report of an entry, as JSON with its slug, category
+// and optionally a description and the reporter's email address. Reports are
+// stored for triage through the admin API, and each client's network may only
+// make so many per window.
+func (s Server) Report(w http.ResponseWriter, r *http.Request) {
+	if s.reports == nil {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	var req struct {
+		Slug        string `json:"slug"`
+		Category    string `json:"category"`
+		Description string `json:"description"`
+		Contact     string `json:"contact"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxReportBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, invalidReport("invalid body", err))
+		return
+	}
+	// Slugs are often copied with the extension of their URL.
+	slug, _, _ := strings.Cut(req.Slug, ".")
+	if slug == "" {
+		s.httpError(w, r, invalidReport("slug is required", nil))
+		return
+	}
+	if !slices.Contains(reportCategories, req.Category) {
+		s.httpError(w, r, invalidReport("category must be one of "+strings.Join(reportCategories, ", "), nil))
+		return
+	}
+	if len(req.Description) > maxReportDescription {
+		s.httpError(w, r, invalidReport("description is too long", nil))
+		return
+	}
+	var contact string
+	if req.Contact != "" {
+		a, err := mail.ParseAddress(req.Contact)
+		if err != nil || len(a.Address) > maxReportContact {
+			s.httpError(w, r, invalidReport("contact must be an email address", err))
+			return
+		}
+		contact = a.Address
+	}
+
+	now := time.Now()
+	addr, _ := ClientIP(r.Context())
+	if ok, wait := s.reports.Allow(addr, now); !ok {
+		s.reportMetrics.limited.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+		s.httpError(w, r, newError(http.StatusTooManyRequests, CodeTooManyReports, "too many reports"))
+		return
+	}
+
+	// Expired and deleted entries can't be reported, as there's nothing
+	// to do about them.
+	e, err := s.Database.Lookup(r.Context(), slug)
+	switch {
+	case errors.Is(err, database.ErrNoResults):
+		s.httpError(w, r, errNotFound)
+		return
+	case err != nil:
+		s.httpError(w, r, fmt.Errorf("lookup: %w", err))
+		return
+	case e.Deleted != nil, e.Lifetime != nil && e.Lifetime.Before(now):
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	if err := s.Database.(database.Reporter).Report(r.Context(), database.Report{
+		Slug:        e.Slug,
+		Category:    req.Category,
+		Description: req.Description,
+		Contact:     contact,
+		Status:      database.ReportOpen,
+		Time:        now.UTC(),
+	}); err != nil {
+		s.httpError(w, r, fmt.Errorf("report: %w", err))
+		return
+	}
+	s.reportMetrics.reported.WithLabelValues(req.Category).Inc()
+	s.reportMetrics.open.Inc()
+	s.logger().Info("reported", "slug", e.Slug, "category", req.Category)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// invalidReport returns the error of a report which is invalid for msg,
+// caused by err if it isn't nil.
+func invalidReport(msg string, err error) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeInvalidReport, Message: msg, Err: err}
+}
+
+// adminReport is a report as the admin API serves it.
+type adminReport struct {
+	Category    string                `json:"category"`
+	Description string                `json:"description,omitempty"`
+	Contact     string                `json:"contact,omitempty"`
+	Status      database.ReportStatus `json:"status"`
+	Time        time.Time             `json:"time"`
+}
+
+// adminReported is the reports of an entry, oldest first, grouped so those of
+// the same entry are triaged together.
+type adminReported struct {
+	Slug string `json:"slug"`
+	// File and URL link to the entry in the admin API, and as it's
+	// served.
+	File    string        `json:"file"`
+	URL     string        `json:"url"`
+	Open    int           `json:"open"`
+	Reports []adminReport `json:"reports"`
+}
+
+// adminReportList is the reports of every entry which has any, with those
+// reported first first.
+type adminReportList struct {
+	Reports []adminReported `json:"reports"`
+}
+
+// groupReports groups reports, oldest first, by entry, with those reported
+// first first.
+func (s Server) groupReports(reports []database.Report) []adminReported {
+	groups := []adminReported{}
+	index := make(map[string]int)
+	for _, r := range reports {
+		i, ok := index[r.Slug]
+		if !ok {
+			i, index[r.Slug] = len(groups), len(groups)
+			groups = append(groups, adminReported{
+				Slug: r.Slug,
+				File: s.PathPrefix + adminFiles + "/" + r.Slug,
+				URL:  s.PathPrefix + "/" + r.Slug,
+			})
+		}
+		if r.Status == database.ReportOpen {
+			groups[i].Open++
+		}
+		groups[i].Reports = append(groups[i].Reports, adminReport{
+			Category:    r.Category,
+			Description: r.Description,
+			Contact:     r.Contact,
+			Status:      r.Status,
+			Time:        r.Time,
+		})
+	}
+	return groups
+}
+
+// serveAdminReports serves reports grouped by entry, and those of each entry
+// under them by slug.
+func (s Server) serveAdminReports(w http.ResponseWriter, r *http.Request) {
+	rp, ok := s.Database.(database.Reporter)
+	if !ok {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "database does not support reports"))
+		return
+	}
+	if r.URL.Path == adminReports {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		s.adminReportList(w, r, rp)
+		return
+	}
+	slug, ok := strings.CutPrefix(r.URL.Path, adminReports+"/")
+	if !ok || slug == "" || strings.Contains(slug, "/") {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.adminReported(w, r, rp, slug)
+	case http.MethodPatch:
+		s.adminResolveReports(w, r, rp, slug)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PATCH")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+	}
+}
+
+// adminReportList serves the reports with the status of the query, which is
+// "open" by default, "resolved" or "all", grouped by entry.
+func (s Server) adminReportList(w http.ResponseWriter, r *http.Request, rp database.Reporter) {
+	var status database.ReportStatus
+	switch q := r.URL.Query().Get("status"); q {
+	case "", string(database.ReportOpen):
+		status = database.ReportOpen
+	case string(database.ReportResolved):
+		status = database.ReportResolved
+	case "all":
+	default:
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid status"))
+		return
+	}
+	reports, err := rp.Reports(r.Context(), status)
+	if err != nil {
+		s.adminDatabaseError(w, r, "reports", err)
+		return
+	}
+	s.adminJSON(w, r, http.StatusOK, adminReportList{Reports: s.groupReports(reports)})
+}
+
+// adminReported serves every report of the named entry.
+func (s Server) adminReported(w http.ResponseWriter, r *http.Request, rp database.Reporter, slug string) {
+	reports, err := rp.Reports(r.Context(), "")
+	if err != nil {
+		s.adminDatabaseError(w, r, "reports", err)
+		return
+	}
+	reports = slices.DeleteFunc(reports, func(r database.Report) bool { return r.Slug != slug })
+	if len(reports) == 0 {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	s.adminJSON(w, r, http.StatusOK, s.groupReports(reports)[0])
+}
+
+// adminResolveReports resolves the open reports of the named entry, if the
+// body's status is "resolved", and serves its reports as they are after.
+func (s Server) adminResolveReports(w http.ResponseWriter, r *http.Request, rp database.Reporter, slug string) {
+	var req struct {
+		Status database.ReportStatus `json:"status"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+		return
+	}
+	if req.Status != database.ReportResolved {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, `status must be "resolved"`))
+		return
+	}
+	reports, err := rp.Reports(r.Context(), database.ReportOpen)
+	if err != nil {
+		s.adminDatabaseError(w, r, "reports", err)
+		return
+	}
+	open := 0
+	for _, report := range reports {
+		if report.Slug == slug {
+			open++
+		}
+	}
+	if open == 0 {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	type change struct {
+		Open int `json:"open"`
+	}
+	if err := s.audit(r, "resolve reports", slug, change{open}, change{0}); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	if err := rp.ResolveReports(r.Context(), slug); err != nil {
+		s.adminDatabaseError(w, r, "resolve reports", err)
+		return
+	}
+	if s.reportMetrics != nil {
+		if err := s.reportMetrics.Load(r.Context(), s); err != nil {
+			s.logger().Error("load open reports", "error", err)
+		}
+	}
+	s.adminReported(w, r, rp, slug)
+}
@@ -0,0 +1,195 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Reports(3, time.Hour), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slug := range []string{"a", "b"} {
+		if err := s.Database.Create(ctx, databasetest.NewEntry(slug)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := func(t *testing.T, remote, body string, code int, want ErrorCode) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, reportPath, strings.NewReader(body))
+		r.RemoteAddr = remote
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, code, w.Body)
+		}
+		if want != "" {
+			var res errorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Error.Code != want {
+				t.Fatalf("unexpected code; got %q, want %q", res.Error.Code, want)
+			}
+		}
+		return w
+	}
+	admin := func(t *testing.T, method, target, body string, code int, v any) {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, code, w.Body)
+		}
+		if v != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatalf("unmarshal %q: %v", w.Body, err)
+			}
+		}
+	}
+
+	report(t, "192.0.2.1:1234", `{"slug":"a.txt","category":"malware","description":"it's a virus","contact":"Someone <someone@example.com>"}`, http.StatusAccepted, "")
+	report(t, "192.0.2.2:1234", `{"slug":"b","category":"copyright"}`, http.StatusAccepted, "")
+	report(t, "192.0.2.3:1234", `{"slug":"a","category":"other"}`, http.StatusAccepted, "")
+	if got := testutil.ToFloat64(s.reportMetrics.open); got != 3 {
+		t.Fatalf("unexpected open reports; got %v, want 3", got)
+	}
+
+	for _, body := range []string{
+		``,
+		`{"category":"malware"}`,
+		`{"slug":"a","category":"spam"}`,
+		`{"slug":"a","category":"malware","contact":"nobody"}`,
+		`{"slug":"a","category":"malware","description":"` + strings.Repeat("a", maxReportDescription+1) + `"}`,
+		`{"slug":"a","category":"malware","name":"a.txt"}`,
+	} {
+		report(t, "192.0.2.4:1234", body, http.StatusBadRequest, CodeInvalidReport)
+	}
+	report(t, "192.0.2.4:1234", `{"slug":"missing","category":"malware"}`, http.StatusNotFound, CodeNotFound)
+	r := httptest.NewRequest(http.MethodGet, reportPath, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Fatalf("unexpected response; got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	// Clients which report too often are limited, but not others.
+	for range 2 {
+		report(t, "192.0.2.1:1234", `{"slug":"b","category":"other"}`, http.StatusAccepted, "")
+	}
+	if w := report(t, "192.0.2.1:1234", `{"slug":"b","category":"other"}`, http.StatusTooManyRequests, CodeTooManyReports); w.Header().Get("Retry-After") == "" {
+		t.Fatal("no Retry-After")
+	}
+	if got := testutil.ToFloat64(s.reportMetrics.limited); got != 1 {
+		t.Fatalf("unexpected limited reports; got %v, want 1", got)
+	}
+
+	// Reports of the same entry are grouped, with those reported first
+	// first.
+	var list adminReportList
+	admin(t, http.MethodGet, adminReports, "", http.StatusOK, &list)
+	if len(list.Reports) != 2 {
+		t.Fatalf("unexpected reports: %+v", list)
+	}
+	a, b := list.Reports[0], list.Reports[1]
+	if a.Slug != "a" || a.Open != 2 || len(a.Reports) != 2 || a.File != adminFiles+"/a" || a.URL != "/a" {
+		t.Fatalf("unexpected reports of a: %+v", a)
+	}
+	if got := a.Reports[0]; got.Category != "malware" || got.Description != "it's a virus" || got.Contact != "someone@example.com" {
+		t.Fatalf("unexpected report: %+v", got)
+	}
+	if b.Slug != "b" || b.Open != 3 {
+		t.Fatalf("unexpected reports of b: %+v", b)
+	}
+
+	var resolved adminReported
+	admin(t, http.MethodPatch, adminReports+"/a", `{"status":"resolved"}`, http.StatusOK, &resolved)
+	if resolved.Open != 0 || len(resolved.Reports) != 2 || resolved.Reports[0].Status != database.ReportResolved {
+		t.Fatalf("unexpected reports: %+v", resolved)
+	}
+	if got := testutil.ToFloat64(s.reportMetrics.open); got != 3 {
+		t.Fatalf("unexpected open reports; got %v, want 3", got)
+	}
+	admin(t, http.MethodPatch, adminReports+"/a", `{"status":"resolved"}`, http.StatusNotFound, &errorBody{})
+	for _, body := range []string{``, `{"status":"open"}`, `{"status":"resolved","slug":"a"}`} {
+		admin(t, http.MethodPatch, adminReports+"/b", body, http.StatusBadRequest, &errorBody{})
+	}
+	admin(t, http.MethodGet, adminReports, "", http.StatusOK, &list)
+	if len(list.Reports) != 1 || list.Reports[0].Slug != "b" {
+		t.Fatalf("unexpected open reports: %+v", list)
+	}
+	admin(t, http.MethodGet, adminReports+"?status=resolved", "", http.StatusOK, &list)
+	if len(list.Reports) != 1 || list.Reports[0].Slug != "a" {
+		t.Fatalf("unexpected resolved reports: %+v", list)
+	}
+	admin(t, http.MethodGet, adminReports+"?status=all", "", http.StatusOK, &list)
+	if len(list.Reports) != 2 {
+		t.Fatalf("unexpected reports: %+v", list)
+	}
+	admin(t, http.MethodGet, adminReports+"?status=closed", "", http.StatusBadRequest, &errorBody{})
+	admin(t, http.MethodGet, adminReports+"/a", "", http.StatusOK, &resolved)
+	admin(t, http.MethodGet, adminReports+"/missing", "", http.StatusNotFound, &errorBody{})
+}
+
+// TestReportDisabled checks reports aren't accepted unless they're enabled,
+// and can't be enabled for databases which can't store them.
+func TestReportDisabled(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, reportPath, strings.NewReader(`{"slug":"a","category":"malware"}`)))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if _, err := New(ctx, DB(struct{ database.Database }{memory.New()}), FS(memfs.New()), Reports(1, 0)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestReportLimiter(t *testing.T) {
+	l := &reportLimiter{limit: 1, window: time.Hour}
+	now := time.Now()
+	for _, tt := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"192.0.2.1", true},
+		{"192.0.2.1", false},
+		{"::ffff:192.0.2.1", false},
+		{"192.0.2.2", true},
+		// Addresses of the same /64 are limited as one.
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"2001:db8:0:1::1", true},
+	} {
+		if ok, _ := l.Allow(netip.MustParseAddr(tt.addr), now); ok != tt.ok {
+			t.Fatalf("%s: unexpected allow; got %t, want %t", tt.addr, ok, tt.ok)
+		}
+	}
+	if ok, wait := l.Allow(netip.MustParseAddr("192.0.2.1"), now.Add(time.Minute)); ok || wait != 59*time.Minute {
+		t.Fatalf("unexpected allow; got %t, %v", ok, wait)
+	}
+	if ok, _ := l.Allow(netip.MustParseAddr("192.0.2.1"), now.Add(time.Hour)); !ok {
+		t.Fatal("client wasn't allowed once the window ended")
+	}
+}
@@ -0,0 +1,68 @@
+package kipp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+)
+
+const (
+	// requestIDHeader is the header request IDs are accepted from clients
+	// in, and echoed in responses.
+	requestIDHeader = "X-Request-Id"
+	// maxRequestIDLength is the length of the longest request ID accepted
+	// from clients, which is enough for a UUID or a trace ID.
+	maxRequestIDLength = 64
+)
+
+// requestIDKey is the key of the request's ID in the contexts of requests.
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request with ctx, if it has one.
+func RequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// withRequestID returns r with an ID on its context, and the ID. The client's
+// ID is used if it's valid, so requests can be correlated with those of a
+// proxy in front of the server, and a new one is generated otherwise.
+func withRequestID(r *http.Request) (*http.Request, string) {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		var b [12]byte
+		if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+			return r, ""
+		}
+		id = base64.RawURLEncoding.EncodeToString(b[:])
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)), id
+}
+
+// validRequestID reports whether id may be used as a request ID. IDs are
+// logged and echoed, so they're limited to characters which are safe to.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// errorMessage returns msg with the ID of r, if it has one, so users can
+// quote it when they report the error.
+func errorMessage(r *http.Request, msg string) string {
+	if id, ok := RequestID(r.Context()); ok {
+		return msg + "\nrequest id: " + id
+	}
+	return msg
+}
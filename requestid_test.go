@@ -0,0 +1,76 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestRequestID(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, id string
+		echoed   bool
+	}{
+		{"none", "", false},
+		{"valid", "3f2a9c1e-7b4d-4e8a-9f6c-2d1b0a9e8c7f", true},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"invalid characters", "abc\r\nSet-Cookie: x", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/livez", nil)
+			if tt.id != "" {
+				r.Header.Set(requestIDHeader, tt.id)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			got := w.Header().Get(requestIDHeader)
+			if tt.echoed && got != tt.id {
+				t.Fatalf("unexpected id; got %q, want %q", got, tt.id)
+			}
+			if !tt.echoed && (got == tt.id || !validRequestID(got)) {
+				t.Fatalf("unexpected id; got %q", got)
+			}
+		})
+	}
+}
+
+// TestRequestIDFailure checks the ID of a failed upload is in its response,
+// and in everything logged for it.
+func TestRequestIDFailure(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s, err := New(context.Background(), DB(failCreate{memory.New()}), FS(memfs.New()), Limit(1<<20), Logger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := uploadRequest(t, 10)
+	r.Header.Set(requestIDHeader, "some-id")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if got := w.Header().Get(requestIDHeader); got != "some-id" {
+		t.Fatalf("unexpected id; got %q, want %q", got, "some-id")
+	}
+	if !strings.Contains(w.Body.String(), "request id: some-id\n") {
+		t.Fatalf("no request id in %q", w.Body)
+	}
+	rs := records(t, &buf)
+	for _, msg := range []string{"request failed", "request"} {
+		if got := find(t, rs, msg)["request_id"]; got != "some-id" {
+			t.Fatalf("%s: unexpected request id; got %v, want %q", msg, got, "some-id")
+		}
+	}
+}
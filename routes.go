@@ -0,0 +1,63 @@
+package kipp
+
+import (
+	"errors"
+	"net/http"
+)
+
+// The paths endpoints are served at if they're not given others.
+const (
+	DefaultLivePath    = "/livez"
+	DefaultReadyPath   = "/readyz"
+	DefaultHealthPath  = "/healthz"
+	DefaultMetricsPath = "/varz"
+)
+
+// builtin is an endpoint served by kipp itself, rather than a file.
+type builtin struct {
+	// probe is whether it's served while draining, so load balancers stop
+	// sending requests.
+	probe bool
+	serve func(s Server, w http.ResponseWriter, r *http.Request)
+}
+
+// builtins returns the endpoints served by kipp itself by path, without
+// those which are disabled, whose paths are served as any other.
+func (s Server) builtins() map[string]builtin {
+	m := make(map[string]builtin)
+	for _, b := range []struct {
+		path string
+		builtin
+	}{
+		{s.LivePath, builtin{probe: true, serve: Server.Live}},
+		{s.ReadyPath, builtin{probe: true, serve: Server.Ready}},
+		{s.HealthPath, builtin{probe: true, serve: Server.Health}},
+		{s.MetricsPath, builtin{serve: Server.serveMetrics}},
+		{"/oembed", builtin{serve: Server.OEmbed}},
+		{"/limits", builtin{serve: Server.Limits}},
+	} {
+		if b.path != "" {
+			m[b.path] = b.builtin
+		}
+	}
+	return m
+}
+
+// checkBuiltins checks the paths of endpoints are absolute, and that none
+// shadows another.
+func (s Server) checkBuiltins() error {
+	seen := make(map[string]bool)
+	for _, p := range []string{s.LivePath, s.ReadyPath, s.HealthPath, s.MetricsPath, "/oembed", "/limits"} {
+		if p == "" {
+			continue
+		}
+		if p == "/" || p[0] != '/' {
+			return errors.New("endpoint path " + p + " must be absolute, and not the root")
+		}
+		if seen[p] {
+			return errors.New("endpoint path " + p + " is used more than once")
+		}
+		seen[p] = true
+	}
+	return nil
+}
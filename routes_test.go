@@ -0,0 +1,75 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestBuiltinPaths(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "varz"), []byte("a static file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Data(dir),
+		ProbePaths("/-/live", "/-/ready", ""),
+		MetricsPath("/metrics"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := databasetest.NewEntry("healthz")
+	if err := s.Database.Create(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FileSystem.Create(ctx, e.Slug, strings.NewReader("an entry")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/-/live", http.StatusOK, "ok\n"},
+		{"/-/ready", http.StatusOK, "database: ok\nfilesystem: ok\n"},
+		{"/metrics", http.StatusOK, "kipp_http_requests_total"},
+		// The paths of moved and disabled endpoints are served as any
+		// other.
+		{"/livez", http.StatusNotFound, ""},
+		{"/varz", http.StatusOK, "a static file"},
+		{"/healthz", http.StatusOK, "an entry"},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+			t.Fatalf("%s: unexpected response; got %d %q, want %d %q", tt.path, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+	if got := s.route("/metrics"); got != "/metrics" {
+		t.Fatalf("unexpected route; got %q, want %q", got, "/metrics")
+	}
+	if got := s.route("/varz"); got != "/{file}" {
+		t.Fatalf("unexpected route; got %q, want %q", got, "/{file}")
+	}
+
+	for _, opts := range [][]Option{
+		{MetricsPath("metrics")},
+		{MetricsPath("/")},
+		{MetricsPath("/livez")},
+		{ProbePaths("/oembed", "", "")},
+	} {
+		if _, err := New(ctx, append([]Option{DB(memory.New()), FS(memfs.New())}, opts...)...); err == nil {
+			t.Fatal("invalid paths were accepted")
+		}
+	}
+}
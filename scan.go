@@ -0,0 +1,142 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A Scanner scans the files of uploads for malware as they're read, before
+// they're stored. Scan is given the file as it's read, and may return before
+// reading all of it, such as if it only scans a prefix, in which case the rest
+// is still stored. Its errors which wrap a *Detection are detections, and
+// others are failures to scan.
+type Scanner interface {
+	Scan(ctx context.Context, name string, r io.Reader) error
+}
+
+// A Detection is what a Scanner detected in a file.
+type Detection struct {
+	// Name is what was detected, such as the name of a signature.
+	Name string
+}
+
+func (d *Detection) Error() string { return "malware detected: " + d.Name }
+
+// uploadScan scans the file of an upload as it's written.
+type uploadScan struct {
+	pw      *io.PipeWriter
+	done    chan struct{}
+	metrics *scanMetrics
+	// rejected is why the upload was rejected, if it was, once done is
+	// closed.
+	rejected *Error
+}
+
+// scanUpload starts scanning the file of an upload, named name, which must be
+// written to the returned uploadScan, and closed. Writes fail once it's
+// rejected, so uploads with malware are stopped as soon as it's detected.
+func (s Server) scanUpload(ctx context.Context, name string) *uploadScan {
+	pr, pw := io.Pipe()
+	u := &uploadScan{pw: pw, done: make(chan struct{}), metrics: s.scanMetrics}
+	go func() {
+		defer close(u.done)
+		start := time.Now()
+		err := s.Scanner.Scan(ctx, name, pr)
+		if s.scanMetrics != nil {
+			s.scanMetrics.Observe(time.Since(start), err)
+		}
+		if u.rejected = s.scanVerdict(ctx, name, err); u.rejected != nil {
+			pr.CloseWithError(u.rejected)
+			return
+		}
+		// What the scanner didn't read is discarded, so the upload
+		// isn't held up by it.
+		io.Copy(io.Discard, pr)
+	}()
+	return u
+}
+
+func (u *uploadScan) Write(b []byte) (int, error) { return u.pw.Write(b) }
+
+// Close ends the file, which failed to be written with err if it's not nil,
+// and waits for it to be scanned. It returns why the upload was rejected, if
+// it was, and nil if it wasn't or if it failed to be written for some other
+// reason.
+func (u *uploadScan) Close(err error) *Error {
+	start := time.Now()
+	u.pw.CloseWithError(err)
+	<-u.done
+	if u.metrics != nil {
+		u.metrics.wait.Observe(time.Since(start).Seconds())
+	}
+	if u.rejected == nil || (err != nil && !errors.Is(err, u.rejected)) {
+		return nil
+	}
+	return u.rejected
+}
+
+// scanVerdict returns why the upload of the file named name should be
+// rejected, given the error it was scanned with, or nil if it shouldn't be.
+// Uploads which failed to be scanned are only rejected if ScanFailOpen isn't
+// set.
+func (s Server) scanVerdict(ctx context.Context, name string, err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if d := (*Detection)(nil); errors.As(err, &d) {
+		s.logger().WarnContext(ctx, "malware detected", "name", name, "detection", d.Name)
+		return &Error{Status: http.StatusUnprocessableEntity, Code: CodeMalware, Message: "malware was detected in this file", Err: err}
+	}
+	if s.ScanFailOpen {
+		s.logger().WarnContext(ctx, "scan failed, storing upload", "name", name, "error", err)
+		return nil
+	}
+	return &Error{Status: http.StatusServiceUnavailable, Code: CodeScanUnavailable, Message: "uploads can't be scanned right now", Err: err}
+}
+
+// scanMetrics export how long scans take, and how long uploads wait for them
+// once they've been read.
+type scanMetrics struct {
+	duration *prometheus.HistogramVec
+	wait     prometheus.Histogram
+}
+
+func newScanMetrics(r prometheus.Registerer) (*scanMetrics, error) {
+	m := &scanMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "kipp",
+			Name:      "scan_duration_seconds",
+			Help:      "Duration of scans of uploads, by result, including the time spent waiting for the upload.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+		}, []string{"result"}),
+		wait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "kipp",
+			Name:      "scan_wait_seconds",
+			Help:      "Duration uploads waited for their scans once they were read.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.duration, m.wait} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Observe records a scan which took d and ended with err.
+func (m *scanMetrics) Observe(d time.Duration, err error) {
+	result := "clean"
+	if det := (*Detection)(nil); errors.As(err, &det) {
+		result = "detected"
+	} else if err != nil {
+		result = "failed"
+	}
+	m.duration.WithLabelValues(result).Observe(d.Seconds())
+}
@@ -0,0 +1,152 @@
+// Package clamd scans uploads for malware with ClamAV's daemon, clamd, by
+// streaming them to it with its INSTREAM command.
+package clamd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp"
+)
+
+const (
+	// defaultTimeout is how long clamd may go without accepting what's
+	// streamed to it, or take to respond once it's all been, if Timeout
+	// isn't given.
+	defaultTimeout = 30 * time.Second
+	// chunkSize is the size of the largest chunk streamed at a time.
+	chunkSize = 64 << 10
+	// maxReply is the size of the longest reply read.
+	maxReply = 4 << 10
+)
+
+// ErrUnavailable is returned when clamd can't be reached, or fails to scan a
+// file.
+var ErrUnavailable = errors.New("clamd is unavailable")
+
+// Scanner scans files with clamd. It implements kipp.Scanner.
+type Scanner struct {
+	network, addr string
+	timeout       time.Duration
+	maxSize       int64
+	dialer        net.Dialer
+}
+
+var _ kipp.Scanner = (*Scanner)(nil)
+
+// An Option configures a Scanner.
+type Option func(s *Scanner)
+
+// Timeout gives clamd d to connect, to accept each chunk of a file and to
+// respond once it's all been streamed, rather than thirty seconds.
+func Timeout(d time.Duration) Option {
+	return func(s *Scanner) { s.timeout = d }
+}
+
+// MaxSize only scans the first n bytes of files, so larger files can be
+// uploaded without clamd rejecting them. It should be at most clamd's
+// StreamMaxLength. Zero scans all of them.
+func MaxSize(n int64) Option {
+	return func(s *Scanner) { s.maxSize = n }
+}
+
+// New returns a Scanner which scans files with the clamd listening at addr.
+// Addresses which are paths, starting with "/", are Unix sockets, and others
+// are TCP addresses, such as "localhost:3310".
+func New(addr string, opts ...Option) *Scanner {
+	s := &Scanner{network: "tcp", addr: addr, timeout: defaultTimeout}
+	if strings.HasPrefix(addr, "/") {
+		s.network = "unix"
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Scan streams r to clamd, returning a *kipp.Detection if it finds anything.
+func (s *Scanner) Scan(ctx context.Context, name string, r io.Reader) error {
+	dctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	conn, err := s.dialer.DialContext(dctx, s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer conn.Close()
+	// Blocked reads and writes end when ctx does.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := s.stream(conn, r); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	reply, err := bufio.NewReader(io.LimitReader(conn, maxReply)).ReadString(0)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: read reply: %w", ErrUnavailable, err)
+	}
+	return parseReply(strings.TrimSuffix(reply, "\x00"))
+}
+
+// stream sends the INSTREAM command and r to conn, as chunks prefixed by their
+// lengths and ended by an empty one.
+func (s *Scanner) stream(conn net.Conn, r io.Reader) error {
+	if s.maxSize > 0 {
+		r = io.LimitReader(r, s.maxSize)
+	}
+	buf := make([]byte, 4+chunkSize)
+	copy(buf, "zINSTREAM\x00")
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	if _, err := conn.Write(buf[:10]); err != nil {
+		return fmt.Errorf("write command: %w", err)
+	}
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("read: %w", err)
+		}
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			conn.SetDeadline(time.Now().Add(s.timeout))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return fmt.Errorf("write: %w", err)
+			}
+		}
+		if err != nil {
+			binary.BigEndian.PutUint32(buf, 0)
+			if _, err := conn.Write(buf[:4]); err != nil {
+				return fmt.Errorf("write: %w", err)
+			}
+			return nil
+		}
+	}
+}
+
+// parseReply returns the error of the reply to INSTREAM, which is "stream:
+// OK" for clean files, "stream: <signature> FOUND" for those with malware,
+// and ends with "ERROR" if clamd failed to scan it.
+func parseReply(reply string) error {
+	switch {
+	case reply == "stream: OK":
+		return nil
+	case strings.HasSuffix(reply, " FOUND"):
+		sig := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return &kipp.Detection{Name: sig}
+	case strings.HasSuffix(reply, " ERROR"):
+		return fmt.Errorf("%w: %s", ErrUnavailable, strings.TrimSuffix(reply, " ERROR"))
+	}
+	return fmt.Errorf("%w: unexpected reply %q", ErrUnavailable, reply)
+}
@@ -0,0 +1,174 @@
+package clamd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// eicar is the EICAR test file, which every scanner detects.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// daemon is a clamd which detects the EICAR test file, records the files
+// it's streamed, and replies with reply instead if it's set.
+type daemon struct {
+	ln    net.Listener
+	reply string
+	delay time.Duration
+
+	mu    sync.Mutex
+	files [][]byte
+}
+
+func newDaemon(t *testing.T) *daemon {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	cmd := make([]byte, len("zINSTREAM\x00"))
+	if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+	var file []byte
+	for {
+		var n uint32
+		if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+			return
+		}
+		if n == 0 {
+			break
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		file = append(file, b...)
+	}
+	d.mu.Lock()
+	d.files = append(d.files, file)
+	d.mu.Unlock()
+	time.Sleep(d.delay)
+	reply := "stream: OK"
+	switch {
+	case d.reply != "":
+		reply = d.reply
+	case bytes.Contains(file, []byte(eicar)):
+		reply = "stream: Eicar-Signature FOUND"
+	}
+	io.WriteString(conn, reply+"\x00")
+}
+
+func TestScan(t *testing.T) {
+	d := newDaemon(t)
+	sc := New(d.ln.Addr().String())
+	ctx := context.Background()
+
+	if err := sc.Scan(ctx, "clean.txt", strings.NewReader("some data")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Files larger than a chunk are streamed whole.
+	big := strings.Repeat("a", 3*chunkSize/2) + eicar
+	var det *kipp.Detection
+	if err := sc.Scan(ctx, "eicar.com", strings.NewReader(big)); !errors.As(err, &det) || det.Name != "Eicar-Signature" {
+		t.Fatalf("unexpected error; got %v, want a detection of Eicar-Signature", err)
+	}
+	if got := string(d.files[1]); got != big {
+		t.Fatalf("unexpected file; got %d bytes, want %d", len(got), len(big))
+	}
+
+	// Only the first MaxSize bytes are scanned.
+	if err := New(d.ln.Addr().String(), MaxSize(4)).Scan(ctx, "eicar.com", strings.NewReader("safe"+eicar)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := string(d.files[2]); got != "safe" {
+		t.Fatalf("unexpected file; got %q, want %q", got, "safe")
+	}
+
+	d.reply = "INSTREAM size limit exceeded. ERROR"
+	if err := sc.Scan(ctx, "clean.txt", strings.NewReader("some data")); !errors.Is(err, ErrUnavailable) || !strings.Contains(err.Error(), "size limit") {
+		t.Fatalf("unexpected error; got %v, want %v", err, ErrUnavailable)
+	}
+}
+
+func TestScanUnavailable(t *testing.T) {
+	d := newDaemon(t)
+	d.delay = 100 * time.Millisecond
+	if err := New(d.ln.Addr().String(), Timeout(10*time.Millisecond)).Scan(context.Background(), "clean.txt", strings.NewReader("some data")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("unexpected error; got %v, want %v", err, ErrUnavailable)
+	}
+	d.ln.Close()
+	if err := New(d.ln.Addr().String()).Scan(context.Background(), "clean.txt", strings.NewReader("some data")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("unexpected error; got %v, want %v", err, ErrUnavailable)
+	}
+}
+
+// TestUpload checks uploads with malware are rejected by kipp before they're
+// stored.
+func TestUpload(t *testing.T) {
+	d := newDaemon(t)
+	fs := memfs.New()
+	s, err := kipp.New(context.Background(),
+		kipp.DB(memory.New()),
+		kipp.FS(fs),
+		kipp.Limit(1<<20),
+		kipp.ScanUploads(New(d.ln.Addr().String())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func(data string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		fw, err := mw.CreateFormFile("file", "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, data)
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", &buf)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	if w := upload("some data"); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	if w := upload(eicar); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+}
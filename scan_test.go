@@ -0,0 +1,135 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// stubScanner reads n bytes of files, or all of them if n is zero, and
+// returns err.
+type stubScanner struct {
+	n   int64
+	err error
+}
+
+func (sc stubScanner) Scan(ctx context.Context, name string, r io.Reader) error {
+	if sc.n > 0 {
+		r = io.LimitReader(r, sc.n)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	return sc.err
+}
+
+// countFiles returns how many files fs has.
+func countFiles(t *testing.T, fs filesystem.Walker) int {
+	t.Helper()
+	var n int
+	if err := fs.Walk(context.Background(), func(filesystem.FileInfo) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestScan(t *testing.T) {
+	detection := &Detection{Name: "Test-Signature"}
+	for _, tt := range []struct {
+		name     string
+		scanner  stubScanner
+		failOpen bool
+		size     int
+		code     int
+		want     ErrorCode
+	}{
+		{"clean", stubScanner{}, false, 1 << 10, http.StatusSeeOther, ""},
+		{"detected", stubScanner{err: detection}, false, 1 << 10, http.StatusUnprocessableEntity, CodeMalware},
+		// Scanners which only scan a prefix don't hold uploads up, and
+		// can stop them as soon as they detect something.
+		{"prefix", stubScanner{n: 10}, false, 1 << 20, http.StatusSeeOther, ""},
+		{"detected early", stubScanner{n: 10, err: detection}, false, 1 << 20, http.StatusUnprocessableEntity, CodeMalware},
+		{"failed", stubScanner{err: errors.New("unavailable")}, false, 1 << 10, http.StatusServiceUnavailable, CodeScanUnavailable},
+		{"failed open", stubScanner{err: errors.New("unavailable")}, true, 1 << 10, http.StatusSeeOther, ""},
+		{"detected failing open", stubScanner{err: detection}, true, 1 << 10, http.StatusUnprocessableEntity, CodeMalware},
+	} {
+		for _, staged := range []bool{false, true} {
+			name := tt.name
+			if staged {
+				name += " staged"
+			}
+			t.Run(name, func(t *testing.T) {
+				fs, staging := memfs.New(), memfs.New()
+				opts := []Option{DB(memory.New()), FS(fs), Limit(2 << 20), ScanUploads(tt.scanner)}
+				if tt.failOpen {
+					opts = append(opts, ScanFailOpen())
+				}
+				if staged {
+					opts = append(opts, Staging(staging, 0))
+				}
+				s, err := New(context.Background(), opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := uploadRequest(t, tt.size)
+				r.Header.Set("Accept", "application/json")
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, tt.code, w.Body)
+				}
+				if tt.want == "" {
+					slug := strings.TrimSuffix(strings.TrimPrefix(w.Header().Get("Location"), "/"), ".txt")
+					e, err := s.Database.Lookup(context.Background(), slug)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if e.Size != int64(tt.size) {
+						t.Fatalf("unexpected size; got %d, want %d", e.Size, tt.size)
+					}
+					return
+				}
+				var res errorBody
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatalf("unmarshal %q: %v", w.Body, err)
+				}
+				if res.Error.Code != tt.want {
+					t.Fatalf("unexpected code; got %q, want %q", res.Error.Code, tt.want)
+				}
+				// Nothing of rejected uploads is kept.
+				if n := countFiles(t, fs) + countFiles(t, staging); n != 0 {
+					t.Fatalf("unexpected files; got %d, want 0", n)
+				}
+			})
+		}
+	}
+}
+
+func TestScanMetrics(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20), ScanUploads(stubScanner{err: &Detection{Name: "Test-Signature"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := upload(t, s, 10); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if n := testutil.CollectAndCount(s.scanMetrics.duration); n != 1 {
+		t.Fatalf("unexpected results; got %d, want 1", n)
+	}
+	if n := testutil.CollectAndCount(s.scanMetrics.wait); n != 1 {
+		t.Fatalf("unexpected waits; got %d, want 1", n)
+	}
+}
@@ -1,57 +1,727 @@
 package kipp
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/instrument"
+	"github.com/uhthomas/kipp/database/namecrypt"
+	"github.com/uhthomas/kipp/database/retry"
 	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/encrypt"
+	fsinstrument "github.com/uhthomas/kipp/filesystem/instrument"
+	"github.com/uhthomas/kipp/filesystem/zstd"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
 	"github.com/zeebo/blake3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server acts as the HTTP server and configuration.
 type Server struct {
-	Database      database.Database
-	FileSystem    filesystem.FileSystem
-	Lifetime      time.Duration
-	Limit         int64
-	PublicPath    string
-	metricHandler http.Handler
+	Database   database.Database
+	FileSystem filesystem.FileSystem
+	Lifetime   time.Duration
+	Limit      int64
+	PublicPath string
+	// PublicFS, if not nil, is served alongside PublicPath, such as the
+	// embed.FS of a program which is a single binary. Files in PublicPath
+	// take precedence, so they can override those in PublicFS.
+	PublicFS fs.FS
+	// ListDirectories lists the files of directories of PublicPath and
+	// PublicFS without an index.html. They're not found otherwise.
+	ListDirectories bool
+	// Precompress is the minimum size of compressible files for which
+	// a gzip variant is stored at upload time. Zero disables it.
+	Precompress int64
+	// DownloadStats is the interval at which per-day download counts are
+	// flushed to the database. Zero disables them.
+	DownloadStats time.Duration
+	// LastAccess is the minimum interval between updates of an entry's
+	// last access time. Zero disables tracking.
+	LastAccess time.Duration
+	// SlidingLifetime, when non-zero, extends the lifetime of downloaded
+	// files to at least SlidingLifetime from the time of download, but no
+	// more than MaxLifetime from the time of upload if MaxLifetime is
+	// non-zero.
+	SlidingLifetime time.Duration
+	MaxLifetime     time.Duration
+	// PurgeAfter is how long soft deleted entries are kept before Purge
+	// deletes them and their files, which the reaper does each run. Zero
+	// keeps them until restored.
+	PurgeAfter time.Duration
+	// DatabaseMetrics records metrics for calls to the database. It's
+	// enabled by default.
+	DatabaseMetrics bool
+	// FileSystemMetrics records metrics for calls to the file system. It's
+	// enabled by default.
+	FileSystemMetrics bool
+	// DatabaseRetry is the policy with which failed database calls are
+	// retried. Calls are not retried if its Attempts is less than two.
+	DatabaseRetry retry.Policy
+	// StatsInterval is the interval at which database statistics are
+	// exported as metrics. Zero disables them.
+	StatsInterval time.Duration
+	// NameKeys, if not nil, encrypts the names of entries in the
+	// database.
+	NameKeys namecrypt.KeyProvider
+	// FileKey, if not nil, is the 32 byte key files are encrypted at rest
+	// with.
+	FileKey []byte
+	// Compress compresses files at rest with zstd.
+	Compress bool
+	// Quota is the maximum total size of stored files. Zero is unlimited.
+	Quota int64
+	// OrphanInterval is the interval at which files without entries are
+	// scanned for and removed. Zero disables scans.
+	OrphanInterval time.Duration
+	// OrphanAge is how long ago files must have been modified to be
+	// considered orphans, so uploads in progress aren't. It's a day if
+	// zero.
+	OrphanAge time.Duration
+	// OrphanDryRun only reports orphans found by scans, rather than
+	// removing them.
+	OrphanDryRun bool
+	// DanglingInterval is the interval at which entries whose files are
+	// missing are scanned for and resolved with DanglingAction. Zero
+	// disables scans.
+	DanglingInterval time.Duration
+	DanglingAction   DanglingAction
+	// ReapInterval is the interval at which expired entries and their
+	// files are deleted. Zero disables it, leaving them hidden.
+	ReapInterval time.Duration
+	// ReapBatch is how many expired entries are listed at a time, or
+	// database.DefaultListLimit if zero.
+	ReapBatch int
+	// Staging, if not nil, is where uploads are stored before they're
+	// verified and promoted to FileSystem. It must implement
+	// filesystem.Walker, so objects left behind by crashes can be swept.
+	Staging filesystem.FileSystem
+	// StagingAge is how long ago staged objects must have been modified
+	// to be swept, which should be longer than any upload takes. It's a
+	// day if zero.
+	StagingAge time.Duration
+	// EvictInterval is the interval at which the least recently accessed
+	// entries are evicted while the total size of stored files exceeds
+	// EvictHigh, until it's no more than EvictLow. Entries uploaded less
+	// than EvictMinAge ago aren't evicted. Zero disables it.
+	EvictInterval       time.Duration
+	EvictHigh, EvictLow int64
+	EvictMinAge         time.Duration
+	// Logger, if not nil, is where diagnostics are logged. They're logged
+	// with slog.Default otherwise. Lines logged for requests carry their
+	// IDs.
+	Logger *slog.Logger
+	// ErrorHandler, if not nil, decides how requests which fail are
+	// responded to. DefaultErrorHandler does otherwise.
+	ErrorHandler ErrorHandler
+	// AccessLog, if not nil, is written a line for each request in the
+	// Combined Log Format, as Apache's access logs are.
+	AccessLog io.Writer
+	// Registerer, if not nil, is where metrics are registered, and
+	// Gatherer where those served by /varz are gathered from, which would
+	// usually be the same registry. A new registry is used otherwise.
+	Registerer prometheus.Registerer
+	Gatherer   prometheus.Gatherer
+	// Collectors are registered alongside the server's metrics.
+	Collectors []prometheus.Collector
+	// TracerProvider, if not nil, traces requests, and calls to the
+	// database and file system, with spans of its tracers.
+	TracerProvider trace.TracerProvider
+	// ProfilePrefix, if not empty, is the path prefix net/http/pprof's
+	// profiles are served under, to requests with ProfileToken as a bearer
+	// token or from addresses in ProfileAllow.
+	ProfilePrefix string
+	ProfileToken  string
+	ProfileAllow  []netip.Prefix
+	// AdminToken, if not empty, is the bearer token of the admin API
+	// served under /admin/, which doesn't exist otherwise.
+	AdminToken string
+	// RequireAudit fails actions taken through the admin API if they
+	// can't be appended to the audit log, rather than only logging that
+	// they couldn't. The database must implement database.Auditor.
+	RequireAudit bool
+	// ReadOnly starts the server read-only, for maintenance such as
+	// storage migrations: uploads are refused with 503 Service
+	// Unavailable and a Retry-After header, while files are still served
+	// and it's still ready. It's toggled through the admin API and
+	// SetReadOnly, and by SIGUSR2 if ReadOnlySignal is set. The admin
+	// API's mutations are refused too, unless ReadOnlyAllowAdmin is set.
+	ReadOnly, ReadOnlySignal, ReadOnlyAllowAdmin bool
+	// SPAFallback serves the index.html of PublicPath, rather than 404 Not
+	// Found, for paths without extensions which are neither files nor
+	// entries, so single-page apps can route them.
+	SPAFallback bool
+	// PathPrefix, if not empty, is the path the server is mounted under,
+	// such as with http.StripPrefix or by a proxy, which is stripped from
+	// requests before they reach it. URLs it responds with, such as the
+	// locations of uploads, include it.
+	PathPrefix string
+	// Namespaces serve some requests, such as those for other hosts, with
+	// servers of their own, so files are isolated between them. Requests
+	// which aren't for any are served as usual. Metrics are labelled by
+	// the namespace they're of, which is "default" for the server's own.
+	Namespaces []Namespace
+	// LivePath, ReadyPath and HealthPath are the paths of the liveness
+	// and readiness probes, and the alias of the readiness probe, and
+	// MetricsPath that of metrics. New sets them to DefaultLivePath and so
+	// on. Empty paths disable their endpoints, so they're served as any
+	// other.
+	LivePath, ReadyPath, HealthPath string
+	MetricsPath                     string
+	// MetricsToken and MetricsAllow, if either is set, only serve metrics
+	// to requests with MetricsToken as a bearer token or from addresses in
+	// MetricsAllow, and 403 Forbidden to anyone else. Probes are served to
+	// everyone regardless.
+	MetricsToken string
+	MetricsAllow []netip.Prefix
+	// HeaderTimeout is how long Serve and its variants give clients to send
+	// the headers of requests. It's ten seconds by default, and zero
+	// doesn't limit them.
+	HeaderTimeout time.Duration
+	// ProgressTimeout, if not zero, is how long uploads and downloads may
+	// go without a byte of them moving before they're cut off, which
+	// includes waiting for the first. TransferTimeout, if not zero, is
+	// how long they may take at all. Uploads cut off are removed, and the
+	// connections of downloads cut off are closed.
+	ProgressTimeout time.Duration
+	TransferTimeout time.Duration
+	// GracePeriod is how long Serve and its variants give requests in
+	// flight to finish when the server shuts down. A negative period waits
+	// for them indefinitely, and zero doesn't wait at all. It's a minute
+	// by default.
+	GracePeriod time.Duration
+	// CertFile and KeyFile, if not empty, are the files of the certificate
+	// and key ServeTLS serves, which are reloaded when they change or the
+	// process is sent SIGHUP.
+	CertFile, KeyFile string
+	// ACMEHosts, if not empty, are the hosts ServeTLS obtains certificates
+	// for with ACME, caching them in the directory ACMECache.
+	ACMEHosts []string
+	ACMECache string
+	// RedirectAddr, if not empty, is the address ServeTLS serves plain HTTP
+	// on, redirecting requests to HTTPS.
+	RedirectAddr string
+	// SocketMode, if not zero, is the mode of unix sockets ListenAndServe
+	// and its variants create. They're created with the umask otherwise.
+	SocketMode os.FileMode
+	// TrustedProxies are the networks of proxies whose Forwarded and
+	// X-Forwarded-For headers are trusted to name clients. They're ignored
+	// from anyone else.
+	TrustedProxies []netip.Prefix
+	// AllowedHosts, if not empty, are the only hosts requests may be for,
+	// and others are responded to with 421 Misdirected Request. Hosts
+	// starting with "*." allow any subdomain of what follows. Probes are
+	// answered for any host, as they're usually requested by address.
+	AllowedHosts []string
+	// APIKeys are the keys clients may authenticate uploads with, as
+	// bearer tokens. Uploads with bearer tokens which are neither keys nor
+	// users' tokens are rejected with 401 Unauthorized.
+	APIKeys []APIKey
+	// Users are the users clients may authenticate as with their tokens,
+	// as bearer tokens, and UserAccounts, if set, lets users and their
+	// tokens be created in the database with the admin API. Uploads
+	// authenticated as a user are owned by it, and its files are listed
+	// and deleted at /api/me/files.
+	Users        []User
+	UserAccounts bool
+	// Verifier, if not nil, verifies uploads which aren't authenticated
+	// with an API key or as a user before their files are read, rejecting
+	// those it can't with 403 Forbidden.
+	Verifier Verifier
+	// Scanner, if not nil, scans the files of uploads as they're read,
+	// rejecting those it detects malware in with 422 Unprocessable Entity
+	// before they're stored. Uploads which fail to be scanned are rejected
+	// with 503 Service Unavailable, unless ScanFailOpen is set, which stores
+	// them.
+	Scanner      Scanner
+	ScanFailOpen bool
+	// UploadAllow and UploadDeny are the networks clients may and may not
+	// upload from, with those read from UploadAllowFile and UploadDenyFile,
+	// which are read again when the process is sent SIGHUP. Denied
+	// networks win, and if any are allowed, clients elsewhere may not
+	// upload either. Clients which may not are responded to with 403
+	// Forbidden. The admin API can replace them while serving.
+	UploadAllow, UploadDeny         []netip.Prefix
+	UploadAllowFile, UploadDenyFile string
+	// Webhooks are the URLs which are sent events for entries which are
+	// uploaded, deleted and expire, signed with WebhookSecret.
+	Webhooks      []string
+	WebhookSecret string
+	// EventPublishers publish the same events as webhooks are sent.
+	EventPublishers []EventPublisher
+	// MaxInFlight, MaxInFlightUploads and MaxInFlightDownloads, if not
+	// zero, are how many requests, and of them uploads and downloads, may
+	// be in progress at once. Requests beyond them are shed with 503
+	// Service Unavailable and a Retry-After header straight away, rather
+	// than queued. Probes and metrics are never shed.
+	MaxInFlight, MaxInFlightUploads, MaxInFlightDownloads int64
+	// BrownoutLatency, if not zero, is the p99 of how long recent uploads
+	// took above which BrownoutShed, a fraction between zero and one, of
+	// new uploads are shed.
+	BrownoutLatency time.Duration
+	BrownoutShed    float64
+	// ReportLimit, if not zero, accepts abuse reports of entries at
+	// /api/report, at most ReportLimit from each client's network per
+	// ReportWindow, which is an hour if it's zero. They're triaged through
+	// the admin API. The database must implement database.Reporter.
+	ReportLimit  int
+	ReportWindow time.Duration
+	// MaxConcurrentUploads, if not zero, is how many uploads may be in
+	// progress at once. Uploads beyond it wait up to UploadWait for one to
+	// finish, and are rejected with 503 Service Unavailable if none does.
+	MaxConcurrentUploads int64
+	UploadWait           time.Duration
+	metricHandler        http.Handler
+	routes               map[string]builtin
+	downloads            *downloadCounter
+	usage                *usage
+	orphanMetrics        *orphanMetrics
+	danglingMetrics      *danglingMetrics
+	reapMetrics          *reapMetrics
+	stagingMetrics       *stagingMetrics
+	evictMetrics         *evictMetrics
+	sidecars             sidecarWriter
+	lifecycle            *lifecycle
+	tracer               trace.Tracer
+	accessLog            *accessLog
+	hosts                *hostPolicy
+	publicEtags          *etagCache
+	shedder              *loadShedder
+	httpMetrics          *httpMetrics
+	uploads              *uploadLimiter
+	uploadNets           *uploadNetworks
+	webhooks             *webhooks
+	events               *eventPublishers
+	stream               *eventStream
+	reports              *reportLimiter
+	reportMetrics        *reportMetrics
+	scanMetrics          *scanMetrics
+	readOnly             *readOnlyMode
+	namespace            string
+	namespaces           []namespaceServer
 }
 
 func New(ctx context.Context, opts ...Option) (*Server, error) {
-	r := prometheus.NewRegistry()
-	if err := r.Register(prometheus.NewGoCollector()); err != nil {
-		return nil, fmt.Errorf("register go collector: %w", err)
-	}
 	s := &Server{
-		metricHandler: promhttp.InstrumentMetricHandler(
-			r, promhttp.HandlerFor(r, promhttp.HandlerOpts{}),
-		),
+		DatabaseMetrics:   true,
+		FileSystemMetrics: true,
+		GracePeriod:       defaultGracePeriod,
+		HeaderTimeout:     readHeaderTimeout,
+		LivePath:          DefaultLivePath,
+		ReadyPath:         DefaultReadyPath,
+		HealthPath:        DefaultHealthPath,
+		MetricsPath:       DefaultMetricsPath,
 	}
 	for _, opt := range opts {
 		if err := opt(ctx, s); err != nil {
 			return nil, err
 		}
 	}
+	if (s.Registerer == nil) != (s.Gatherer == nil) {
+		return nil, errors.New("registerer and gatherer must both be set")
+	}
+	if err := s.checkBuiltins(); err != nil {
+		return nil, err
+	}
+	s.routes = s.builtins()
+	if err := s.checkProfiling(); err != nil {
+		return nil, err
+	}
+	if err := s.checkTLS(); err != nil {
+		return nil, err
+	}
+	var r prometheus.Registerer = s.Registerer
+	g := s.Gatherer
+	if r == nil {
+		reg := prometheus.NewRegistry()
+		r, g = reg, reg
+	}
+	// Registries of programs which embed the server may already have it,
+	// and namespaces share their server's.
+	if s.namespace == "" {
+		if err := r.Register(prometheus.NewGoCollector()); err != nil && !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+			return nil, fmt.Errorf("register go collector: %w", err)
+		}
+	}
+	for _, c := range s.Collectors {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register collector: %w", err)
+		}
+	}
+	// The rest are labelled by namespace if there are any, so those of
+	// each can be told apart.
+	root := r
+	if s.namespace == "" && len(s.Namespaces) > 0 {
+		s.namespace = defaultNamespace
+	}
+	if s.namespace != "" {
+		r = prometheus.WrapRegistererWith(prometheus.Labels{"namespace": s.namespace}, r)
+	}
+	s.metricHandler = promhttp.InstrumentMetricHandler(
+		r, promhttp.HandlerFor(g, promhttp.HandlerOpts{}),
+	)
+	if s.TracerProvider != nil {
+		s.tracer = s.TracerProvider.Tracer(tracerName)
+	}
+	hm, err := newHTTPMetrics(r)
+	if err != nil {
+		return nil, fmt.Errorf("http metrics: %w", err)
+	}
+	s.httpMetrics = hm
+	// Background work runs until Shutdown, or ctx is done.
+	l := &lifecycle{drained: make(chan struct{})}
+	ctx, l.cancel = context.WithCancel(ctx)
+	s.lifecycle = l
+	// Workers are started once the server is built, each with the same copy
+	// of it, so they don't race with it being built.
+	var workers []func(s Server)
+	if _, ok := s.Database.(database.DownloadCounter); s.DownloadStats > 0 && !ok {
+		return nil, errors.New("database does not support download statistics")
+	}
+	if _, ok := s.Database.(database.Toucher); s.LastAccess > 0 && !ok {
+		return nil, errors.New("database does not support access tracking")
+	}
+	if _, ok := s.Database.(database.Extender); s.SlidingLifetime > 0 && !ok {
+		return nil, errors.New("database does not support extending lifetimes")
+	}
+	if _, ok := s.Database.(database.SoftDeleter); s.PurgeAfter > 0 && !ok {
+		return nil, errors.New("database does not support soft deletes")
+	}
+	if _, ok := s.Database.(database.SoftDeleter); s.DanglingAction == MarkDangling && !ok {
+		return nil, errors.New("database does not support marking dangling entries")
+	}
+	if _, ok := s.Database.(database.Reporter); s.ReportLimit > 0 && !ok {
+		return nil, errors.New("database does not support reports")
+	}
+	if _, ok := s.Database.(database.Auditor); s.RequireAudit && !ok {
+		return nil, errors.New("database does not support audit logs")
+	}
+	if _, ok := s.Database.(database.UserStore); s.UserAccounts && !ok {
+		return nil, errors.New("database does not support user accounts")
+	}
+	// Orphans can be scanned for on demand, so their metrics are exported
+	// whenever the file system can be listed.
+	_, walker := s.FileSystem.(filesystem.Walker)
+	if s.OrphanInterval > 0 && !walker {
+		return nil, errors.New("filesystem does not support listing")
+	}
+	if s.HeaderTimeout < 0 || s.ProgressTimeout < 0 || s.TransferTimeout < 0 {
+		return nil, errors.New("timeouts must not be negative")
+	}
+	if s.MaxConcurrentUploads < 0 || s.UploadWait < 0 {
+		return nil, errors.New("concurrent upload limit and wait must not be negative")
+	}
+	if s.MaxInFlight < 0 || s.MaxInFlightUploads < 0 || s.MaxInFlightDownloads < 0 || s.BrownoutLatency < 0 {
+		return nil, errors.New("in-flight limits and brownout latency must not be negative")
+	}
+	if s.BrownoutShed < 0 || s.BrownoutShed > 1 {
+		return nil, errors.New("brownout shed must be between zero and one")
+	}
+	if s.ReportLimit < 0 || s.ReportWindow < 0 {
+		return nil, errors.New("report limit and window must not be negative")
+	}
+	if len(s.Webhooks) > 0 && s.WebhookSecret == "" {
+		return nil, errors.New("webhooks must have a secret")
+	}
+	for _, k := range s.APIKeys {
+		if k.Name == "" || k.Key == "" {
+			return nil, errors.New("API keys must have a name and key")
+		}
+		if k.Limit < 0 || k.Lifetime < 0 {
+			return nil, errors.New("API key limits and lifetimes must not be negative")
+		}
+	}
+	if err := checkUsers(s.Users); err != nil {
+		return nil, err
+	}
+	if s.EvictInterval > 0 && (s.EvictLow < 0 || s.EvictLow >= s.EvictHigh) {
+		return nil, errors.New("eviction low-water mark must be less than its high-water mark")
+	}
+	// Uploads which would exceed the quota are rejected, so files would
+	// never be evicted.
+	if s.EvictInterval > 0 && s.Quota > 0 && s.EvictHigh >= s.Quota {
+		return nil, errors.New("eviction high-water mark must be less than the quota")
+	}
+	if _, ok := s.Staging.(filesystem.Walker); s.Staging != nil && !ok {
+		return nil, errors.New("staging filesystem does not support listing")
+	}
+	// File systems are closed by Shutdown, so they're found before they're
+	// wrapped.
+	for _, fs := range []filesystem.FileSystem{s.FileSystem, s.Staging} {
+		if c, ok := fs.(io.Closer); ok {
+			l.closers = append(l.closers, c)
+		}
+	}
+	// File systems such as mirror export their own metrics.
+	if c, ok := s.FileSystem.(prometheus.Collector); ok {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register filesystem: %w", err)
+		}
+	}
+	// The file system is measured beneath encryption and compression, so
+	// it's what's stored which is counted. It's wrapped without metrics if
+	// it's only traced.
+	if (s.FileSystemMetrics || s.tracer != nil) && s.FileSystem != nil {
+		var reg prometheus.Registerer
+		if s.FileSystemMetrics {
+			reg = r
+		}
+		fs, err := fsinstrument.New(s.FileSystem, reg, "", fsinstrument.Tracer(s.TracerProvider))
+		if err != nil {
+			return nil, fmt.Errorf("instrument filesystem: %w", err)
+		}
+		s.FileSystem = fs
+	}
+	if s.FileKey != nil && s.FileSystem != nil {
+		fs, err := encrypt.New(s.FileSystem, encrypt.Key(s.FileKey))
+		if err != nil {
+			return nil, fmt.Errorf("encrypt filesystem: %w", err)
+		}
+		s.FileSystem = fs
+	}
+	// Staged files are encrypted too, but aren't worth compressing.
+	if s.FileKey != nil && s.Staging != nil {
+		fs, err := encrypt.New(s.Staging, encrypt.Key(s.FileKey))
+		if err != nil {
+			return nil, fmt.Errorf("encrypt staging filesystem: %w", err)
+		}
+		s.Staging = fs
+	}
+	// Files are compressed before they're encrypted, as ciphertext doesn't
+	// compress.
+	if s.Compress && s.FileSystem != nil {
+		fs, err := zstd.New(s.FileSystem)
+		if err != nil {
+			return nil, fmt.Errorf("compress filesystem: %w", err)
+		}
+		s.FileSystem = fs
+	}
+	s.sidecars = findSidecarWriter(s.FileSystem)
+	// The wrappers implement every optional interface, so capabilities are
+	// checked beforehand. Retries are outermost, so each attempt is measured.
+	if s.NameKeys != nil && s.Database != nil {
+		// Fail now rather than on the first upload.
+		if _, err := s.NameKeys.Primary(ctx); err != nil {
+			return nil, fmt.Errorf("name keys: %w", err)
+		}
+		s.Database = namecrypt.New(s.Database, s.NameKeys)
+	}
+	if (s.DatabaseMetrics || s.tracer != nil) && s.Database != nil {
+		var reg prometheus.Registerer
+		if s.DatabaseMetrics {
+			reg = r
+		}
+		db, err := instrument.New(s.Database, reg, "", instrument.Tracer(s.TracerProvider))
+		if err != nil {
+			return nil, fmt.Errorf("instrument database: %w", err)
+		}
+		s.Database = db
+	}
+	if s.DatabaseRetry.Attempts > 1 && s.Database != nil {
+		db, err := retry.New(s.Database, r, "", s.DatabaseRetry)
+		if err != nil {
+			return nil, fmt.Errorf("retry database: %w", err)
+		}
+		s.Database = db
+	}
+	if s.DownloadStats > 0 {
+		s.downloads = newDownloadCounter(s.Database.(database.DownloadCounter), s.logger())
+		workers = append(workers, func(s Server) { s.downloads.Run(ctx, s.DownloadStats) })
+	}
+	if s.Quota > 0 && s.Database != nil {
+		u, err := newUsage(r, s.Quota)
+		if err != nil {
+			return nil, fmt.Errorf("usage: %w", err)
+		}
+		// Usage is recomputed rather than persisted, so it's correct
+		// however the server stopped.
+		if err := u.Load(ctx, *s); err != nil {
+			return nil, fmt.Errorf("load usage: %w", err)
+		}
+		s.usage = u
+		workers = append(workers, func(s Server) { u.Run(ctx, s, usageInterval) })
+	}
+	if s.MaxInFlight > 0 || s.MaxInFlightUploads > 0 || s.MaxInFlightDownloads > 0 || s.BrownoutLatency > 0 {
+		ls, err := newLoadShedder(r, s.MaxInFlight, s.MaxInFlightUploads, s.MaxInFlightDownloads, s.BrownoutLatency, s.BrownoutShed)
+		if err != nil {
+			return nil, fmt.Errorf("load shedder: %w", err)
+		}
+		s.shedder = ls
+	}
+	if s.MaxConcurrentUploads > 0 {
+		u, err := newUploadLimiter(r, s.MaxConcurrentUploads, s.UploadWait)
+		if err != nil {
+			return nil, fmt.Errorf("upload limiter: %w", err)
+		}
+		s.uploads = u
+	}
+	un, err := newUploadNetworks(r, s.UploadAllow, s.UploadDeny, s.UploadAllowFile, s.UploadDenyFile)
+	if err != nil {
+		return nil, fmt.Errorf("upload networks: %w", err)
+	}
+	s.uploadNets = un
+	if s.UploadAllowFile != "" || s.UploadDenyFile != "" {
+		workers = append(workers, func(s Server) { un.run(ctx, s.logger()) })
+	}
+	ro, err := newReadOnlyMode(r, s.ReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("read-only: %w", err)
+	}
+	s.readOnly = ro
+	if s.ReadOnlySignal {
+		workers = append(workers, func(s Server) { ro.run(ctx, s.logger()) })
+	}
+	if s.PathPrefix = strings.TrimSuffix(s.PathPrefix, "/"); s.PathPrefix != "" && !strings.HasPrefix(s.PathPrefix, "/") {
+		s.PathPrefix = "/" + s.PathPrefix
+	}
+	if s.PublicFS != nil {
+		s.publicEtags = &etagCache{}
+	}
+	hp, err := newHostPolicy(s.AllowedHosts)
+	if err != nil {
+		return nil, fmt.Errorf("allowed hosts: %w", err)
+	}
+	s.hosts = hp
+	if s.AccessLog != nil {
+		a, err := newAccessLog(r, s.AccessLog, s.logger())
+		if err != nil {
+			return nil, fmt.Errorf("access log: %w", err)
+		}
+		s.accessLog = a
+		workers = append(workers, func(Server) { a.run(ctx) })
+	}
+	if len(s.Webhooks) > 0 {
+		wh, err := newWebhooks(r, s.Webhooks, s.WebhookSecret, s.logger())
+		if err != nil {
+			return nil, fmt.Errorf("webhooks: %w", err)
+		}
+		s.webhooks = wh
+		workers = append(workers, func(Server) { wh.run(ctx) })
+	}
+	if s.AdminToken != "" {
+		es, err := newEventStream(r)
+		if err != nil {
+			return nil, fmt.Errorf("event stream: %w", err)
+		}
+		s.stream = es
+	}
+	if len(s.EventPublishers) > 0 {
+		ep, err := newEventPublishers(r, s.EventPublishers, s.logger())
+		if err != nil {
+			return nil, fmt.Errorf("event publishers: %w", err)
+		}
+		s.events = ep
+		workers = append(workers, func(Server) { ep.run(ctx) })
+	}
+	if s.StatsInterval > 0 {
+		g, err := newStatsGauges(r)
+		if err != nil {
+			return nil, fmt.Errorf("stats gauges: %w", err)
+		}
+		workers = append(workers, func(s Server) { g.Run(ctx, s, s.StatsInterval) })
+	}
+	if s.ReportLimit > 0 {
+		window := s.ReportWindow
+		if window == 0 {
+			window = defaultReportWindow
+		}
+		m, err := newReportMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("report metrics: %w", err)
+		}
+		s.reports = &reportLimiter{limit: s.ReportLimit, window: window}
+		s.reportMetrics = m
+		workers = append(workers, func(s Server) { m.Run(ctx, s, reportInterval) })
+	}
+	if s.Scanner != nil {
+		m, err := newScanMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("scan metrics: %w", err)
+		}
+		s.scanMetrics = m
+	}
+	if walker {
+		m, err := newOrphanMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("orphan metrics: %w", err)
+		}
+		s.orphanMetrics = m
+	}
+	if s.OrphanInterval > 0 {
+		workers = append(workers, func(s Server) { runOrphanScans(ctx, s, s.OrphanInterval) })
+	}
+	if s.Database != nil {
+		dm, err := newDanglingMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("dangling metrics: %w", err)
+		}
+		rm, err := newReapMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("reap metrics: %w", err)
+		}
+		em, err := newEvictMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("evict metrics: %w", err)
+		}
+		s.danglingMetrics, s.reapMetrics, s.evictMetrics = dm, rm, em
+	}
+	if s.DanglingInterval > 0 {
+		workers = append(workers, func(s Server) { runDanglingScans(ctx, s, s.DanglingInterval) })
+	}
+	if s.ReapInterval > 0 {
+		workers = append(workers, func(s Server) { runReaper(ctx, s, s.ReapInterval) })
+	}
+	if s.EvictInterval > 0 {
+		workers = append(workers, func(s Server) { runEvictions(ctx, s, s.EvictInterval) })
+	}
+	if s.Staging != nil {
+		m, err := newStagingMetrics(r)
+		if err != nil {
+			return nil, fmt.Errorf("staging metrics: %w", err)
+		}
+		s.stagingMetrics = m
+		// Objects staged before a crash are swept before uploads are
+		// accepted.
+		if _, err := s.SweepStaging(ctx); err != nil {
+			return nil, fmt.Errorf("sweep staging: %w", err)
+		}
+		workers = append(workers, func(s Server) { runStagingSweeps(ctx, s, stagingInterval) })
+	}
+	ns, err := s.newNamespaces(ctx, root, g)
+	if err != nil {
+		return nil, err
+	}
+	s.namespaces = ns
+	srv := *s
+	for _, w := range workers {
+		l.run(func() { w(srv) })
+	}
 	return s, nil
 }
 
@@ -59,6 +729,111 @@ func New(ctx context.Context, opts ...Option) (*Server, error) {
 // request is for uploading, it then tries to serve static files and then will
 // try to serve public files.
 func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	// http.StripPrefix strips the slash of prefixes which end with one.
+	if !strings.HasPrefix(r.URL.Path, "/") {
+		r = r.Clone(r.Context())
+		r.URL.Path = "/" + r.URL.Path
+		r.URL.RawPath = ""
+	}
+	// Namespaces log, measure and respond to what they serve themselves.
+	if s.serveNamespace(w, r) {
+		return
+	}
+	r = s.withClientIP(r)
+	// Lines logged for the request carry its ID, as this copy of s is what
+	// serves it.
+	var id string
+	if r, id = withRequestID(r); id != "" {
+		w.Header().Set(requestIDHeader, id)
+		s.Logger = s.logger().With(slog.String("request_id", id))
+	}
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+	defer func() {
+		s.logRequest(r, sw, time.Since(start))
+		if s.accessLog != nil {
+			s.accessLog.Log(r, sw, start)
+		}
+	}()
+	if s.tracer != nil {
+		var span trace.Span
+		r, span = s.startRequestSpan(r)
+		defer func() { endRequestSpan(span, sw) }()
+	}
+	if s.httpMetrics != nil {
+		var done func()
+		r, done = s.httpMetrics.track(r, sw, s.route(r.URL.Path))
+		defer done()
+	}
+	// Panics are recovered before the response is logged and measured, so
+	// it's recorded as what the client got.
+	defer s.recoverRequest(sw, r)
+
+	// Probes are answered while draining, so load balancers stop sending
+	// requests.
+	b, builtin := s.routes[r.URL.Path]
+	if builtin && b.probe && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		b.serve(s, w, r)
+		return
+	}
+
+	// Requests for other hosts are rejected before anything is looked
+	// up for them.
+	if !s.hosts.Allows(r.Host) {
+		s.httpError(w, r, errMisdirected)
+		return
+	}
+
+	if !s.lifecycle.enter() {
+		s.unavailable(w, r)
+		return
+	}
+	defer s.lifecycle.exit()
+
+	// Metrics are never shed, so shedding can be seen.
+	if !builtin || r.URL.Path != s.MetricsPath {
+		done, reason := s.shedder.Admit(s.requestKind(r))
+		if done == nil {
+			s.overloaded(w, r, reason)
+			return
+		}
+		defer done()
+	}
+
+	// Profiles are only routed if they're enabled, so they never shadow
+	// files otherwise. They're exempt from transfer deadlines, as CPU
+	// profiles and traces write nothing until they're done.
+	if s.isProfile(r.URL.Path) {
+		s.serveProfile(w, r)
+		return
+	}
+
+	// The admin API is only routed if there's a token for it, so it never
+	// shadows files otherwise.
+	if s.isAdmin(r.URL.Path) {
+		s.serveAdmin(w, r)
+		return
+	}
+
+	// Abuse reports are only routed if they're accepted, so they never
+	// shadow files otherwise.
+	if s.isReport(r.URL.Path) {
+		s.Report(w, r)
+		return
+	}
+
+	// Users' files are likewise only routed if there are users.
+	if s.isUserFiles(r.URL.Path) {
+		s.serveUserFiles(w, r)
+		return
+	}
+
+	if rr, t := s.startTransfer(sw, r); t != nil {
+		r = rr
+		defer s.finishTransfer(r, t)
+	}
+
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
 	case http.MethodPost:
@@ -76,39 +851,64 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Methods", allow)
 		} else {
 			w.Header().Set("Allow", allow)
-			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
 		}
 		return
 	}
 
-	switch r.URL.Path {
-	case "/healthz":
-		s.Health(w, r)
-		return
-	case "/varz":
-		s.metricHandler.ServeHTTP(w, r)
+	if builtin {
+		b.serve(s, w, r)
 		return
 	}
 
+	// spa is whether a single-page app in PublicPath is served if nothing
+	// is found.
+	spa := s.isSPARoute(r.URL.Path)
+
+	// served is the entry being served, if any.
+	var served *database.Entry
+	gw := &goneWriter{ResponseWriter: sw, s: s, r: r}
+	rw := &streamWriter{ResponseWriter: gw}
+	defer func() {
+		if served == nil || !isDownload(r, sw) {
+			return
+		}
+		if s.downloads != nil {
+			s.downloads.Add(served.Slug)
+		}
+		s.notify(EventDownload, *served)
+		if s.LastAccess > 0 {
+			s.touch(r.Context(), *served)
+		}
+	}()
+
 	http.FileServer(fileSystemFunc(func(name string) (_ http.File, err error) {
-		if f, err := http.Dir(s.PublicPath).Open(name); !os.IsNotExist(err) {
+		if f, err := s.openPublic(name); !errors.Is(err, fs.ErrNotExist) {
+			if err != nil {
+				return nil, err
+			}
 			d, err := f.Stat()
 			if err != nil {
+				f.Close()
 				return nil, err
 			}
 			if !d.IsDir() {
+				etag, err := s.publicEtag(f, name, d)
+				if err != nil {
+					f.Close()
+					return nil, err
+				}
 				w.Header().Set("Cache-Control", "max-age=31536000")
-				// nginx style weak Etag
-				w.Header().Set("Etag", fmt.Sprintf(
-					`W/"%x-%x"`,
-					d.ModTime().Unix(), d.Size(),
-				))
+				w.Header().Set("Etag", etag)
 			}
 			return f, nil
 		}
 
 		dir, name := path.Split(name)
 		if dir != "/" {
+			if spa {
+				return s.openSPA(r.Context(), w, r.URL.Path)
+			}
 			return nil, os.ErrNotExist
 		}
 
@@ -117,27 +917,62 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			name = name[:i]
 		}
 
-		e, err := s.Database.Lookup(r.Context(), name)
+		e, err := s.lookup(r.Context(), name)
 		if err != nil {
+			// The file server only responds with 404 Not Found, which
+			// the writer replaces.
+			if e := (*Error)(nil); errors.As(err, &e) {
+				gw.err = e
+				return nil, os.ErrNotExist
+			}
+			if errors.Is(err, errGone) {
+				gw.err = errFileGone
+			}
 			if errors.Is(err, database.ErrNoResults) {
+				if spa {
+					return s.openSPA(r.Context(), w, r.URL.Path)
+				}
 				return nil, os.ErrNotExist
 			}
 			return nil, err
 		}
 
+		if s.tracer != nil {
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.String("kipp.slug", e.Slug),
+				attribute.Int64("kipp.size", e.Size),
+			)
+		}
+
 		cache := "max-age=31536000" // ~ 1 year
 		if e.Lifetime != nil {
 			now := time.Now()
 			if e.Lifetime.Before(now) {
 				return nil, os.ErrNotExist
 			}
+			if s.SlidingLifetime > 0 && r.Method == http.MethodGet {
+				if err := s.slide(r.Context(), &e, now); err != nil {
+					s.logger().Warn("extend lifetime", "slug", e.Slug, "error", err)
+				}
+			}
 			cache = fmt.Sprintf(
 				"public, must-revalidate, max-age=%d",
 				int(e.Lifetime.Sub(now).Seconds()),
 			)
 		}
 
-		f, err := s.FileSystem.Open(r.Context(), e.Slug)
+		// Serve the precompressed variant if there is one, and the
+		// client will accept it. The variant is a distinct
+		// representation, so it has its own size and Etag.
+		fname, etag, encoding := e.Slug, e.Sum, ""
+		if e.GzipSize > 0 {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+				fname, etag, encoding = gzipName(e.Slug), e.Sum+"-gzip", "gzip"
+			}
+		}
+
+		f, err := s.FileSystem.Open(r.Context(), fname)
 		if err != nil {
 			return nil, err
 		}
@@ -147,7 +982,22 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}()
 
-		ctype, err := detectContentType(e.Name, f)
+		// Streams are replaced once they're sniffed, so what they
+		// implement is checked first.
+		ca, _ := f.(filesystem.ContentAddresser)
+		var ctype string
+		if rs, ok := f.(filesystem.ReadSeeker); ok {
+			ctype, err = detectContentType(e.Name, rs, encoding)
+		} else {
+			// Ranges of streams can't be served, so they're always
+			// served whole.
+			var sf filesystem.Reader
+			if ctype, sf, err = sniffStream(e.Name, f, encoding); err == nil {
+				f = sf
+			}
+			r.Header.Del("Range")
+			rw.stream = true
+		}
 		if err != nil {
 			return nil, fmt.Errorf("detect content type: %w", err)
 		}
@@ -164,109 +1014,328 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			url.PathEscape(e.Name),
 		))
 		w.Header().Set("Content-Type", ctype)
-		w.Header().Set("Etag", strconv.Quote(e.Sum))
+		w.Header().Set("Etag", strconv.Quote(etag))
 		if e.Lifetime != nil {
 			w.Header().Set("Expires", e.Lifetime.Format(http.TimeFormat))
 		}
 		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// Let IPFS aware clients fetch the file over IPFS instead.
+		if ca != nil && encoding == "" {
+			w.Header().Set("X-Ipfs-Path", "/ipfs/"+ca.CID())
+		}
+		served = &e
+		if encoding != "" {
+			// http.ServeContent won't set the length of encoded
+			// content, but the length of the variant is known.
+			e.Size = e.GzipSize
+			w.Header().Set("Content-Encoding", encoding)
+			w.Header().Set("Content-Length", strconv.FormatInt(e.Size, 10))
+		}
 		return &file{Reader: f, entry: e}, nil
-	})).ServeHTTP(w, r)
-}
-
-func (s Server) Health(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
-	defer cancel()
-
-	if err := s.Database.Ping(ctx); err != nil {
-		log.Printf("ping: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	})).ServeHTTP(rw, r)
 }
 
 // UploadHandler write the contents of the "file" part to a filesystem.Reader,
 // persists the entry to the database and writes the location of the file
-// to the response.
+// to the response. The entry is labelled with the comma separated tags in
+// any "tags" parts preceding the file.
 func (s Server) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if s.tracer != nil {
+		ctx, span := s.tracer.Start(r.Context(), "upload")
+		defer span.End()
+		r = r.WithContext(ctx)
+	}
+
+	// Uploads which get past this finish even if the server is made
+	// read-only before they do.
+	if !s.readOnly.startUpload() {
+		s.readOnlyUpload(w, r)
+		return
+	}
+	defer s.readOnly.finishUpload()
+	// Clients are checked before anything they've sent is read.
+	if s.uploadNets != nil && !s.uploadNets.Allowed(r.Context()) {
+		s.uploadDenied(w, r)
+		return
+	}
+	// Clients which meant to authenticate are told they didn't, rather
+	// than treated as anonymous.
+	r, err := s.authenticate(r)
+	if err != nil {
+		s.authError(w, r, err)
+		return
+	}
+
 	// Due to the overhead of multipart bodies, the actual limit for files
 	// is smaller than it should be. It's not really feasible to calculate
 	// the overhead so this is *good enough* for the time being.
 	//
 	// TODO(thomas): is there a better way to limit the size for the
 	//      part, rather than the whole body?
-	if r.ContentLength > s.Limit {
-		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+	limit, lifetime := s.uploadLimits(r.Context())
+	if r.ContentLength > limit {
+		s.httpError(w, r, newError(http.StatusRequestEntityTooLarge, CodeEntityTooLarge, http.StatusText(http.StatusRequestEntityTooLarge)))
+		return
+	}
+
+	if s.usage != nil && s.usage.Full() {
+		s.insufficientStorage(w, r)
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, s.Limit)
+	// Slots are held until the upload is stored, however it ends, and
+	// waiting for one ends if the client goes away.
+	if s.uploads != nil {
+		if !s.uploads.Acquire(r.Context()) {
+			s.tooManyUploads(w, r)
+			return
+		}
+		defer s.uploads.Release()
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
 
 	mr, err := r.MultipartReader()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.httpError(w, r, badUpload(CodeBadRequest, err))
 		return
 	}
 
-	var p *multipart.Part
+	var (
+		p    *multipart.Part
+		tags []string
+		// fields are the other fields preceding the file, which are
+		// only read for the Verifier.
+		fields = url.Values{}
+	)
 	for {
 		if p, err = mr.NextPart(); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.httpError(w, r, badUpload(CodeBadRequest, err))
 			return
 		}
 		if p.FormName() == "file" {
 			break
 		}
+		switch {
+		case p.FormName() == "tags":
+			t, err := readTags(p)
+			if err != nil {
+				s.httpError(w, r, badUpload(CodeInvalidTags, err))
+				return
+			}
+			tags = append(tags, t...)
+		case s.Verifier != nil:
+			if err := readVerifyField(fields, p); err != nil {
+				s.httpError(w, r, badUpload(CodeBadRequest, err))
+				return
+			}
+		}
 	}
 	defer p.Close()
 
+	if !s.verifyUpload(w, r, fields) {
+		return
+	}
+
+	if tags, err = database.NormalizeTags(tags); err != nil {
+		s.httpError(w, r, badUpload(CodeInvalidTags, err))
+		return
+	}
+
 	name := p.FileName()
 	if len(name) > 255 {
-		http.Error(w, "invalid name", http.StatusBadRequest)
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeInvalidName, "invalid name"))
 		return
 	}
 
 	var b [9]byte
 	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.httpError(w, r, fmt.Errorf("read slug: %w", err))
 		return
 	}
 
 	slug := base64.RawURLEncoding.EncodeToString(b[:])
 
-	if err := s.FileSystem.Create(r.Context(), slug, filesystem.PipeReader(func(w io.Writer) error {
+	// The entry is created once its files are stored, so it's never served
+	// without them. They're removed if either fails. Files are staged first
+	// if there's somewhere to stage them, and only promoted once they're
+	// complete.
+	var (
+		sum       []byte
+		n, gzSize int64
+		// reserved is how much of the quota the upload has reserved,
+		// and overQuota whether it was rejected for exceeding it.
+		reserved  atomic.Int64
+		overQuota atomic.Bool
+		// scanned is why the scanner rejected the upload, if it did.
+		scanned atomic.Pointer[Error]
+	)
+	piped := make(chan struct{})
+	body := filesystem.PipeReader(func(w io.Writer) (err error) {
+		defer close(piped)
+		defer s.recoverTo(r.Context(), &err)
+		// Read ahead enough to sniff the content type, so it's
+		// known whether a compressed variant is worth storing.
+		var b [3072]byte
+		k, err := io.ReadFull(p, b[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("read: %w", err)
+		}
+
 		h := blake3.New()
-		n, err := io.Copy(io.MultiWriter(w, h), p)
-		if err != nil {
-			return fmt.Errorf("copy: %w", err)
+		ws := []io.Writer{w, h}
+		if s.usage != nil {
+			// Reserve space before anything is written.
+			ws = append([]io.Writer{writerFunc(func(b []byte) (int, error) {
+				if err := s.usage.Reserve(int64(len(b))); err != nil {
+					overQuota.Store(true)
+					return 0, err
+				}
+				reserved.Add(int64(len(b)))
+				return len(b), nil
+			})}, ws...)
 		}
 
-		now := time.Now()
+		var gz *gzipVariant
+		if s.Precompress > 0 && compressible(sniffContentType(name, b[:k])) {
+			gz = s.newGzipVariant(r.Context(), slug)
+			ws = append(ws, gz)
+		}
 
-		var l *time.Time
-		if s.Lifetime > 0 {
-			t := now.Add(s.Lifetime)
-			l = &t
+		var scan *uploadScan
+		if s.Scanner != nil {
+			scan = s.scanUpload(r.Context(), name)
+			ws = append(ws, scan)
 		}
 
-		if err := s.Database.Create(r.Context(), database.Entry{
-			Slug:      slug,
-			Name:      name,
-			Sum:       base64.RawURLEncoding.EncodeToString(h.Sum(nil)),
-			Size:      n,
-			Timestamp: now,
-			Lifetime:  l,
-		}); err != nil {
-			return fmt.Errorf("create entry: %w", err)
+		n, err = io.Copy(io.MultiWriter(ws...), io.MultiReader(bytes.NewReader(b[:k]), p))
+		// Uploads are only stored once they're known to be clean.
+		if scan != nil {
+			if e := scan.Close(err); e != nil {
+				scanned.Store(e)
+				err = e
+			}
 		}
+		if err != nil {
+			if gz != nil {
+				gz.Abort(r.Context(), err)
+			}
+			return fmt.Errorf("copy: %w", err)
+		}
+
+		if gz != nil {
+			if gzSize, err = gz.Commit(r.Context(), n, n >= s.Precompress); err != nil {
+				s.logger().Warn("store gzip variant", "slug", slug, "error", err)
+			}
+		}
+		sum = h.Sum(nil)
 		return nil
-	})); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	})
+	// The part is read until it's piped, so it isn't closed until then,
+	// however storing it ends.
+	defer func() {
+		if c, ok := body.(io.Closer); ok {
+			c.Close()
+		}
+		<-piped
+	}()
+	// Denylisted files are never promoted, but without staging they're
+	// only known to be once they're stored, so they're removed as if they
+	// failed.
+	if s.Staging != nil {
+		var staged string
+		if staged, err = s.stage(r.Context(), body); err == nil {
+			if err = s.checkDenylist(r.Context(), sum); err != nil {
+				s.unstage(r.Context(), staged)
+			} else {
+				err = s.promote(r.Context(), staged, slug, n, sum)
+			}
+		}
+	} else if err = s.FileSystem.Create(r.Context(), slug, body); err == nil {
+		err = s.checkDenylist(r.Context(), sum)
+	}
+	if err != nil {
+		s.removeUpload(r.Context(), slug, reserved.Load())
+		if errors.Is(err, http.ErrAbortHandler) {
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, errDenylisted) {
+			s.httpError(w, r, err)
+			return
+		}
+		if e := scanned.Load(); e != nil {
+			s.httpError(w, r, e)
+			return
+		}
+		if overQuota.Load() {
+			s.insufficientStorage(w, r)
+			return
+		}
+		// Uploads which exceed the limit fail as they're stored.
+		if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+			s.httpError(w, r, badUpload(CodeBadRequest, err))
+			return
+		}
+		s.httpError(w, r, fmt.Errorf("store: %w", err))
 		return
 	}
 
+	now := time.Now()
+
+	var l *time.Time
+	if lifetime > 0 {
+		t := now.Add(lifetime)
+		l = &t
+	}
+
+	e := database.Entry{
+		Slug:      slug,
+		Name:      name,
+		Sum:       base64.RawURLEncoding.EncodeToString(sum),
+		Size:      n,
+		Timestamp: now,
+		Lifetime:  l,
+		GzipSize:  gzSize,
+		Tags:      tags,
+	}
+	if id, ok := UserID(r.Context()); ok {
+		e.Owner = id
+	}
+	if err := s.Database.Create(r.Context(), e); err != nil {
+		s.removeUpload(r.Context(), slug, reserved.Load())
+		s.httpError(w, r, fmt.Errorf("create entry: %w", err))
+		return
+	}
+	if s.usage != nil {
+		s.usage.Commit(reserved.Load(), gzSize)
+	}
+	s.notify(EventUpload, e)
+	// The database is the source of truth, so uploads succeed without
+	// their sidecar.
+	if s.sidecars != nil {
+		if err := s.sidecars.WriteSidecar(r.Context(), e); err != nil {
+			s.logger().Warn("write sidecar", "slug", slug, "error", err)
+		}
+	}
+
+	if s.tracer != nil {
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("kipp.slug", slug),
+			attribute.Int64("kipp.size", n),
+		)
+	}
+	s.logger().LogAttrs(r.Context(), slog.LevelDebug, "upload",
+		slog.String("slug", slug),
+		slog.Int64("size", n),
+		slog.Duration("duration", time.Since(start)),
+	)
+
 	ext := filepath.Ext(name)
 
 	var sb strings.Builder
-	sb.Grow(len(slug) + len(ext) + 2)
+	sb.Grow(len(s.PathPrefix) + len(slug) + len(ext) + 2)
+	sb.WriteString(s.PathPrefix)
 	sb.WriteRune('/')
 	sb.WriteString(slug)
 	sb.WriteString(ext)
@@ -277,19 +1346,223 @@ func (s Server) UploadHandler(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, sb.String())
 }
 
+// removeUpload removes the files of the named upload which failed, so they
+// aren't left as orphans, and releases the bytes it reserved. Files which
+// were never stored are ignored, and they're removed even if the upload
+// failed because its request was cancelled.
+func (s Server) removeUpload(ctx context.Context, slug string, reserved int64) {
+	if s.usage != nil {
+		s.usage.Release(reserved)
+	}
+	ctx = xcontext.Detach(ctx)
+	for _, name := range []string{slug, gzipName(slug)} {
+		if err := s.FileSystem.Remove(ctx, name); err != nil && !filesystem.IsNotExist(err) {
+			s.logger().Warn("remove failed upload", "name", name, "error", err)
+		}
+	}
+}
+
+// insufficientStorage responds to r that the upload would exceed the quota.
+func (s Server) insufficientStorage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(quotaRetryAfter.Seconds())))
+	s.httpError(w, r, newError(http.StatusInsufficientStorage, CodeInsufficientStorage, http.StatusText(http.StatusInsufficientStorage)))
+}
+
+// readTags reads the comma separated tags in p. Space around tags and empty
+// tags are ignored.
+func readTags(p *multipart.Part) ([]string, error) {
+	const max = database.MaxTags * (database.MaxTagLength + 1)
+	b, err := io.ReadAll(io.LimitReader(p, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("read tags: %w", err)
+	}
+	if len(b) > max {
+		return nil, fmt.Errorf("%w: too long", database.ErrInvalidTags)
+	}
+	var tags []string
+	for _, tag := range strings.Split(string(b), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// lookup looks up the named entry to be served, treating soft deleted
+// entries as missing, and failing with the *Error of blocked entries.
+func (s Server) lookup(ctx context.Context, slug string) (database.Entry, error) {
+	e, err := s.Database.Lookup(ctx, slug)
+	if err != nil {
+		return database.Entry{}, err
+	}
+	if e.Deleted != nil {
+		if e.DeleteReason == missingReason {
+			return database.Entry{}, errGone
+		}
+		return database.Entry{}, database.ErrNoResults
+	}
+	if e.Blocked != nil {
+		return database.Entry{}, blockedError(e)
+	}
+	return e, nil
+}
+
+// slide extends the lifetime of e to s.SlidingLifetime from now, capped to
+// s.MaxLifetime from when it was uploaded. Extensions of less than a minute
+// are skipped, so downloads of popular files don't all write to the
+// database.
+func (s Server) slide(ctx context.Context, e *database.Entry, now time.Time) error {
+	t := now.Add(s.SlidingLifetime)
+	if s.MaxLifetime > 0 {
+		if max := e.Timestamp.Add(s.MaxLifetime); t.After(max) {
+			t = max
+		}
+	}
+	if t.Sub(*e.Lifetime) < time.Minute {
+		return nil
+	}
+	if err := s.Database.(database.Extender).Extend(ctx, e.Slug, t); err != nil {
+		return err
+	}
+	e.Lifetime = &t
+	return nil
+}
+
+// touch updates the last access time of e, unless it was last updated
+// within s.LastAccess.
+func (s Server) touch(ctx context.Context, e database.Entry) {
+	now := time.Now()
+	if e.LastAccess != nil && now.Sub(*e.LastAccess) < s.LastAccess {
+		return
+	}
+	if err := s.Database.(database.Toucher).Touch(ctx, e.Slug, now); err != nil {
+		s.logger().Warn("touch", "slug", e.Slug, "error", err)
+	}
+}
+
+// isDownload reports whether the response to r is a download of the file,
+// rather than a HEAD, a conditional request which was not modified, or a
+// range which doesn't start at the beginning of the file.
+func isDownload(r *http.Request, w *statusWriter) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	switch w.status {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(w.Header().Get("Content-Range"), "bytes 0-")
+	}
+	return false
+}
+
+// statusWriter records the status code and number of bytes written to a
+// http.ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+	// transfer, if not nil, has its deadlines extended as the response is
+	// written.
+	transfer *transfer
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	if w.transfer != nil {
+		w.transfer.moved(n, err)
+	}
+	return n, err
+}
+
+// ReadFrom passes through to the underlying http.ResponseWriter, so sendfile
+// can still be used, unless the response has a transfer whose deadlines must
+// be extended as it's written.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.transfer != nil {
+		return io.Copy(writerFunc(w.Write), r)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.n += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
 // detectContentType sniffs up-to the first 3072 bytes of the stream,
-// falling back to extension if the content type could not be detected.
-func detectContentType(name string, r io.ReadSeeker) (string, error) {
-	var b [3072]byte
-	n, _ := io.ReadFull(r, b[:])
+// decoding it first if it has the given content coding, falling back to
+// extension if the content type could not be detected. The stream is seeked
+// back to the start.
+func detectContentType(name string, r io.ReadSeeker, encoding string) (string, error) {
+	ctype, err := readContentType(name, r, encoding)
+	if err != nil {
+		return "", err
+	}
 	if _, err := r.Seek(0, io.SeekStart); err != nil {
 		return "", errors.New("seeker can't seek")
 	}
-	m := mimetype.Detect(b[:n])
+	return ctype, nil
+}
+
+// sniffStream detects the content type of f as detectContentType does, for
+// streams which can't be seeked back to the start. What's sniffed is
+// buffered, and read again by the returned reader in place of f.
+func sniffStream(name string, f filesystem.Reader, encoding string) (string, filesystem.Reader, error) {
+	br := bufio.NewReaderSize(f, 3072)
+	// The variant is sniffed from what it decodes to, which may be cut
+	// short, but is enough to sniff.
+	b, err := br.Peek(3072)
+	if err != nil && err != io.EOF {
+		return "", nil, fmt.Errorf("read: %w", err)
+	}
+	ctype, err := readContentType(name, bytes.NewReader(b), encoding)
+	if err != nil {
+		return "", nil, err
+	}
+	return ctype, struct {
+		io.Reader
+		io.Closer
+	}{br, f}, nil
+}
+
+// readContentType reads up-to the first 3072 bytes of r to sniff its content
+// type, decoding it first if it has the given content coding.
+func readContentType(name string, r io.Reader, encoding string) (string, error) {
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return "", fmt.Errorf("gzip: %w", err)
+		}
+		r = zr
+	}
+	var b [3072]byte
+	n, _ := io.ReadFull(r, b[:])
+	return sniffContentType(name, b[:n]), nil
+}
+
+// sniffContentType detects the content type of b, falling back to extension
+// if the content type could not be detected.
+func sniffContentType(name string, b []byte) string {
+	m := mimetype.Detect(b)
 	if m.Is("application/octet-stream") {
 		if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
-			return ctype, nil
+			return ctype
 		}
 	}
-	return m.String(), nil
+	return m.String()
 }
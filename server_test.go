@@ -0,0 +1,355 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/instrument"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/encrypt"
+	fsinstrument "github.com/uhthomas/kipp/filesystem/instrument"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/zstd"
+)
+
+func TestNewDatabaseMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	for _, enabled := range []bool{true, false} {
+		var opts []Option
+		if !enabled {
+			opts = append(opts, DatabaseMetrics(false))
+		}
+		s, err := New(ctx, append(opts, DB(memory.New()))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.Database.(*instrument.Database); ok != enabled {
+			t.Fatalf("unexpected database for enabled=%t; got %T", enabled, s.Database)
+		}
+	}
+}
+
+func TestNewFileSystemMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	for _, enabled := range []bool{true, false} {
+		var opts []Option
+		if !enabled {
+			opts = append(opts, FileSystemMetrics(false))
+		}
+		s, err := New(ctx, append(opts, FS(memfs.New()))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.FileSystem.(*fsinstrument.FileSystem); ok != enabled {
+			t.Fatalf("unexpected filesystem for enabled=%t; got %T", enabled, s.FileSystem)
+		}
+	}
+}
+
+func TestNewFileKey(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := New(ctx, FS(memfs.New()), FileKey(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.FileSystem.(*encrypt.FileSystem); !ok {
+		t.Fatalf("unexpected filesystem; got %T", s.FileSystem)
+	}
+	if _, err := New(ctx, FS(memfs.New()), FileKey(make([]byte, 16))); err == nil {
+		t.Fatal("expected error for a short key")
+	}
+}
+
+func TestNewCompress(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := New(ctx, FS(memfs.New()), Compress(), FileKey(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.FileSystem.(*zstd.FileSystem); !ok {
+		t.Fatalf("unexpected filesystem; got %T", s.FileSystem)
+	}
+}
+
+func TestUploadTags(t *testing.T) {
+	ctx := context.Background()
+
+	s := Server{Database: memory.New(), FileSystem: memfs.New(), Limit: 1 << 20}
+
+	for _, tt := range []struct {
+		tags   []string
+		status int
+		want   []string
+	}{
+		{status: http.StatusSeeOther},
+		{tags: []string{"screenshot, ci-artifact", "screenshot"}, status: http.StatusSeeOther, want: []string{"ci-artifact", "screenshot"}},
+		{tags: []string{"Not a tag"}, status: http.StatusBadRequest},
+	} {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for _, tags := range tt.tags {
+			if err := mw.WriteField("tags", tags); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fw, err := mw.CreateFormFile("file", "some name.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, "some data")
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/", &buf)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		s.UploadHandler(w, r)
+		if w.Code != tt.status {
+			t.Fatalf("unexpected status for %q; got %d, want %d", tt.tags, w.Code, tt.status)
+		}
+		if w.Code != http.StatusSeeOther {
+			continue
+		}
+
+		slug := strings.TrimSuffix(path.Base(w.Header().Get("Location")), ".txt")
+		e, err := s.Database.Lookup(ctx, slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if !reflect.DeepEqual(e.Tags, tt.want) {
+			t.Fatalf("unexpected tags for %q; got %q, want %q", tt.tags, e.Tags, tt.want)
+		}
+	}
+}
+
+// failCreate is a database which fails to create entries.
+type failCreate struct{ database.Database }
+
+func (failCreate) Create(context.Context, database.Entry) error { return errors.New("create failed") }
+
+// TestUploadRollback checks the files of an upload are removed if its entry
+// can't be created.
+func TestUploadRollback(t *testing.T) {
+	fs := memfs.New()
+	s := Server{Database: failCreate{memory.New()}, FileSystem: fs, Limit: 1 << 20, Precompress: 1}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", "some name.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, strings.Repeat("some compressible data ", 100))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.UploadHandler(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if n := fs.Size(); n != 0 {
+		t.Fatalf("unexpected size; got %d, want 0", n)
+	}
+}
+
+// cidFileSystem is a file system whose objects all have the same CID.
+type cidFileSystem struct{ filesystem.FileSystem }
+
+type cidReader struct{ filesystem.Reader }
+
+func (cidReader) CID() string { return "bafyexample" }
+
+func (fs cidFileSystem) Open(ctx context.Context, name string) (filesystem.Reader, error) {
+	r, err := fs.FileSystem.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return cidReader{r}, nil
+}
+
+func TestServeCID(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(cidFileSystem{memfs.New()}), Limit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := upload(t, s, 10)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	loc := w.Header().Get("Location")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, loc, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusOK)
+	}
+	if got, want := w.Header().Get("X-Ipfs-Path"), "/ipfs/bafyexample"; got != want {
+		t.Fatalf("unexpected X-Ipfs-Path; got %q, want %q", got, want)
+	}
+}
+
+func TestServeStream(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		var opts []memfs.Option
+		if stream {
+			opts = append(opts, memfs.Stream())
+		}
+		s, err := New(context.Background(), DB(memory.New()), FS(memfs.New(opts...)), Limit(1<<20), Precompress(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := upload(t, s, 100)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+		}
+		loc := w.Header().Get("Location")
+
+		// Ranges of streams are ignored, and they're served whole.
+		status, size, ranges := http.StatusPartialContent, 4, "bytes"
+		if stream {
+			status, size, ranges = http.StatusOK, 100, "none"
+		}
+		r := httptest.NewRequest(http.MethodGet, loc, nil)
+		r.Header.Set("Range", "bytes=0-3")
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != status || w.Body.Len() != size {
+			t.Fatalf("unexpected response with stream=%t; got %d with %d bytes, want %d with %d bytes", stream, w.Code, w.Body.Len(), status, size)
+		}
+		if got := w.Header().Get("Accept-Ranges"); got != ranges {
+			t.Fatalf("unexpected Accept-Ranges with stream=%t; got %q, want %q", stream, got, ranges)
+		}
+
+		// The content type of the gzip variant is sniffed from what it
+		// decodes to.
+		for _, encoding := range []string{"", "gzip"} {
+			r := httptest.NewRequest(http.MethodGet, loc, nil)
+			r.Header.Set("Accept-Encoding", encoding)
+			w = httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != encoding {
+				t.Fatalf("unexpected response with stream=%t and encoding %q; got %d encoded with %q", stream, encoding, w.Code, w.Header().Get("Content-Encoding"))
+			}
+			if got, want := w.Header().Get("Content-Type"), "text/plain; charset=utf-8"; got != want {
+				t.Fatalf("unexpected content type with stream=%t and encoding %q; got %q, want %q", stream, encoding, got, want)
+			}
+		}
+	}
+}
+
+// pingFS is a file system whose pings fail with err.
+type pingFS struct {
+	filesystem.FileSystem
+	err error
+}
+
+func (fs pingFS) Ping(context.Context) error { return fs.err }
+
+func TestHealth(t *testing.T) {
+	for _, v := range []struct {
+		name string
+		fs   filesystem.FileSystem
+		code int
+	}{
+		{"healthy", pingFS{memfs.New(), nil}, http.StatusOK},
+		{"not a pinger", memfs.New(), http.StatusOK},
+		{"unhealthy", pingFS{memfs.New(), errors.New("read-only file system")}, http.StatusServiceUnavailable},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			s := Server{Database: memory.New(), FileSystem: v.fs}
+			w := httptest.NewRecorder()
+			s.Health(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if w.Code != v.code {
+				t.Fatalf("unexpected status; got %d, want %d", w.Code, v.code)
+			}
+			if v.code != http.StatusOK && !strings.Contains(w.Body.String(), "\nfilesystem: read-only") {
+				t.Fatalf("unexpected body; got %q, want the file system named", w.Body)
+			}
+		})
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte("app()"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		prefix string
+		mount  func(s *Server) http.Handler
+	}{
+		{"bare", "", func(s *Server) http.Handler { return s }},
+		{"strip prefix", "/files", func(s *Server) http.Handler {
+			return http.StripPrefix("/files", s)
+		}},
+		// The slash of prefixes which end with one is stripped too.
+		{"strip prefix with slash", "/files", func(s *Server) http.Handler {
+			mux := http.NewServeMux()
+			mux.Handle("/files/", http.StripPrefix("/files/", s))
+			return mux
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<10), Data(dir), PathPrefix(tt.prefix+"/"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := tt.mount(s)
+			serve := func(r *http.Request) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+
+			r := multipartRequest(t, 5)
+			r.URL.Path = tt.prefix + "/"
+			w := serve(r)
+			location := w.Header().Get("Location")
+			if w.Code != http.StatusSeeOther || !strings.HasPrefix(location, tt.prefix+"/") || strings.Count(location, "/") != strings.Count(tt.prefix, "/")+1 {
+				t.Fatalf("unexpected response; got %d %q, want a location under %q", w.Code, location, tt.prefix+"/")
+			}
+
+			// What's responded with can be requested.
+			if w := serve(httptest.NewRequest(http.MethodGet, location, nil)); w.Code != http.StatusOK || w.Body.String() != "aaaaa" {
+				t.Fatalf("unexpected download; got %d %q", w.Code, w.Body)
+			}
+			if w := serve(httptest.NewRequest(http.MethodGet, tt.prefix+"/app.js", nil)); w.Code != http.StatusOK || w.Body.String() != "app()" {
+				t.Fatalf("unexpected static file; got %d %q", w.Code, w.Body)
+			}
+			target := tt.prefix + "/oembed?url=" + url.QueryEscape("https://example.com"+location)
+			w = serve(httptest.NewRequest(http.MethodGet, target, nil))
+			var res oEmbed
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if want := "https://example.com" + tt.prefix + "/"; w.Code != http.StatusOK || res.ProviderURL != want {
+				t.Fatalf("unexpected oEmbed; got %d %q, want %q", w.Code, res.ProviderURL, want)
+			}
+		})
+	}
+}
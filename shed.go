@@ -0,0 +1,207 @@
+package kipp
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// shedRetryAfter is suggested to clients whose requests are shed.
+	shedRetryAfter = 5 * time.Second
+	// brownoutWindow is how many of the latest uploads the p99 of their
+	// handling time is of.
+	brownoutWindow = 256
+	// brownoutMinSamples is how many uploads must have been handled before
+	// the p99 is trusted, so a slow first upload doesn't brown out.
+	brownoutMinSamples = 32
+)
+
+// The reasons requests are shed for.
+const (
+	shedInFlight          = "in_flight"
+	shedUploadsInFlight   = "uploads_in_flight"
+	shedDownloadsInFlight = "downloads_in_flight"
+	shedBrownout          = "brownout"
+)
+
+// requestKind is what a request is, as far as shedding it goes.
+type requestKind int
+
+const (
+	otherKind requestKind = iota
+	uploadKind
+	downloadKind
+)
+
+// inFlightLimit limits how many requests are in progress at once, without
+// queueing any.
+type inFlightLimit struct {
+	max int64
+	n   atomic.Int64
+}
+
+// acquire reports whether there's room for another request, which must be
+// released if so. There's always room if there's no maximum.
+func (l *inFlightLimit) acquire() bool {
+	if l.max == 0 {
+		return true
+	}
+	if l.n.Add(1) > l.max {
+		l.n.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (l *inFlightLimit) release() {
+	if l.max > 0 {
+		l.n.Add(-1)
+	}
+}
+
+// loadShedder sheds requests when the server is overloaded, rather than
+// letting them pile up until it runs out of memory or file descriptors. Those
+// beyond the in-flight limits are shed straight away, and a fraction of
+// uploads are shed while the p99 of how long recent ones took is above the
+// brownout latency.
+type loadShedder struct {
+	total, uploads, downloads inFlightLimit
+	brownoutLatency           time.Duration
+	brownoutShed              float64
+
+	mu sync.Mutex
+	// latencies are how long the latest uploads took, as a ring.
+	latencies []time.Duration
+	next      int
+	browning  atomic.Bool
+
+	shed     *prometheus.CounterVec
+	brownout prometheus.Gauge
+}
+
+func newLoadShedder(r prometheus.Registerer, total, uploads, downloads int64, latency time.Duration, shed float64) (*loadShedder, error) {
+	l := &loadShedder{
+		brownoutLatency: latency,
+		brownoutShed:    shed,
+		shed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "requests_shed_total",
+			Help:      "Number of requests shed because the server was overloaded, by reason.",
+		}, []string{"reason"}),
+		brownout: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "brownout",
+			Help:      "Whether uploads are being shed because recent ones were too slow.",
+		}),
+	}
+	l.total.max, l.uploads.max, l.downloads.max = total, uploads, downloads
+	for _, reason := range []string{shedInFlight, shedUploadsInFlight, shedDownloadsInFlight, shedBrownout} {
+		l.shed.WithLabelValues(reason)
+	}
+	for _, c := range []prometheus.Collector{l.shed, l.brownout} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// Admit admits a request of kind, returning a func which must be called once
+// it's done, or the reason it's shed. Every request is admitted by a nil
+// shedder.
+func (l *loadShedder) Admit(kind requestKind) (done func(), reason string) {
+	if l == nil {
+		return func() {}, ""
+	}
+	if kind == uploadKind && l.browning.Load() && rand.Float64() < l.brownoutShed {
+		l.shed.WithLabelValues(shedBrownout).Inc()
+		return nil, shedBrownout
+	}
+	if !l.total.acquire() {
+		l.shed.WithLabelValues(shedInFlight).Inc()
+		return nil, shedInFlight
+	}
+	limit, limitReason := (*inFlightLimit)(nil), ""
+	switch kind {
+	case uploadKind:
+		limit, limitReason = &l.uploads, shedUploadsInFlight
+	case downloadKind:
+		limit, limitReason = &l.downloads, shedDownloadsInFlight
+	}
+	if limit != nil && !limit.acquire() {
+		l.total.release()
+		l.shed.WithLabelValues(limitReason).Inc()
+		return nil, limitReason
+	}
+	start := time.Now()
+	return func() {
+		if limit != nil {
+			limit.release()
+		}
+		l.total.release()
+		if kind == uploadKind && l.brownoutLatency > 0 {
+			l.record(time.Since(start))
+		}
+	}, ""
+}
+
+// record records that an upload took d, browning out if the p99 of recent
+// uploads is above the brownout latency, or recovering if it's fallen below
+// it.
+func (l *loadShedder) record(d time.Duration) {
+	l.mu.Lock()
+	if len(l.latencies) < brownoutWindow {
+		l.latencies = append(l.latencies, d)
+	} else {
+		l.latencies[l.next] = d
+		l.next = (l.next + 1) % brownoutWindow
+	}
+	if len(l.latencies) < brownoutMinSamples {
+		l.mu.Unlock()
+		return
+	}
+	sorted := slices.Clone(l.latencies)
+	l.mu.Unlock()
+	slices.Sort(sorted)
+	p99 := sorted[(len(sorted)*99+99)/100-1]
+	browning := p99 > l.brownoutLatency
+	l.browning.Store(browning)
+	if browning {
+		l.brownout.Set(1)
+	} else {
+		l.brownout.Set(0)
+	}
+}
+
+// requestKind returns what r is, as far as shedding it goes.
+func (s Server) requestKind(r *http.Request) requestKind {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/":
+		return uploadKind
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		if _, ok := s.routes[r.URL.Path]; ok || s.isAdmin(r.URL.Path) || s.isProfile(r.URL.Path) || s.isUserFiles(r.URL.Path) {
+			return otherKind
+		}
+		return downloadKind
+	}
+	return otherKind
+}
+
+// overloaded responds to r that it was shed for reason.
+func (s Server) overloaded(w http.ResponseWriter, r *http.Request, reason string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(shedRetryAfter.Seconds())))
+	s.httpError(w, r, &Error{
+		Status:  http.StatusServiceUnavailable,
+		Code:    CodeOverloaded,
+		Message: "the server is overloaded",
+		Err:     fmt.Errorf("shed: %s", reason),
+	})
+}
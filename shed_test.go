@@ -0,0 +1,110 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestShedLoad(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<10), ShedLoad(3, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	shed := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		var b errorBody
+		if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || b.Error.Code != CodeOverloaded {
+			t.Fatalf("request wasn't shed; got %d %q %+v", w.Code, w.Header().Get("Retry-After"), b.Error)
+		}
+	}
+
+	// An upload is in progress, so others are shed, but downloads aren't.
+	done, _ := s.shedder.Admit(uploadKind)
+	shed(t, serve(multipartRequest(t, 1)))
+	if w := serve(httptest.NewRequest(http.MethodGet, "/missing", nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	// So is a download, so others are shed too.
+	download, _ := s.shedder.Admit(downloadKind)
+	shed(t, serve(httptest.NewRequest(http.MethodGet, "/missing", nil)))
+	// And so is another request, which reaches the limit of them all.
+	other, _ := s.shedder.Admit(otherKind)
+	shed(t, serve(httptest.NewRequest(http.MethodGet, "/oembed", nil)))
+
+	// Probes and metrics are answered regardless.
+	for _, p := range []string{DefaultLivePath, DefaultReadyPath, DefaultMetricsPath} {
+		if w := serve(httptest.NewRequest(http.MethodGet, p, nil)); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status of %s; got %d, want %d", p, w.Code, http.StatusOK)
+		}
+	}
+	for reason, want := range map[string]float64{
+		shedUploadsInFlight:   1,
+		shedDownloadsInFlight: 1,
+		shedInFlight:          1,
+		shedBrownout:          0,
+	} {
+		if got := testutil.ToFloat64(s.shedder.shed.WithLabelValues(reason)); got != want {
+			t.Errorf("unexpected requests shed for %s; got %v, want %v", reason, got, want)
+		}
+	}
+
+	done()
+	download()
+	other()
+	if w := serve(multipartRequest(t, 1)); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+func TestBrownout(t *testing.T) {
+	l, err := newLoadShedder(prometheus.NewRegistry(), 0, 0, 0, 10*time.Millisecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Too few uploads have been handled to trust their p99.
+	for range brownoutMinSamples - 1 {
+		l.record(time.Second)
+	}
+	if done, reason := l.Admit(uploadKind); done == nil {
+		t.Fatalf("upload was shed for %s before there were enough samples", reason)
+	}
+	l.record(time.Second)
+	if _, reason := l.Admit(uploadKind); reason != shedBrownout {
+		t.Fatalf("upload wasn't shed; got reason %q, want %q", reason, shedBrownout)
+	}
+	if done, reason := l.Admit(downloadKind); done == nil {
+		t.Fatalf("download was shed for %s", reason)
+	}
+	if got := testutil.ToFloat64(l.brownout); got != 1 {
+		t.Fatalf("unexpected brownout; got %v, want 1", got)
+	}
+
+	// Once slow uploads are out of the window, uploads are admitted again.
+	for range brownoutWindow {
+		l.record(time.Millisecond)
+	}
+	if done, reason := l.Admit(uploadKind); done == nil {
+		t.Fatalf("upload was shed for %s after recovering", reason)
+	}
+	if got := testutil.ToFloat64(l.brownout); got != 0 {
+		t.Fatalf("unexpected brownout; got %v, want 0", got)
+	}
+}
@@ -0,0 +1,173 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+// drainRetryAfter is how long clients are asked to wait before retrying
+// requests rejected while the server shuts down, by which time another
+// instance should be serving.
+const drainRetryAfter = 10 * time.Second
+
+// lifecycle tracks the requests in flight and background work of a Server,
+// so they can be waited for when it shuts down. It's shared by copies of the
+// Server.
+type lifecycle struct {
+	mu       sync.Mutex
+	draining bool
+	// drained is closed once the server starts shutting down, so requests
+	// which would otherwise never finish, such as streams, can.
+	drained  chan struct{}
+	requests sync.WaitGroup
+	workers  sync.WaitGroup
+	// cancel stops the background work.
+	cancel context.CancelFunc
+	// closers are closed once everything else has stopped.
+	closers   []io.Closer
+	closeOnce sync.Once
+}
+
+// enter records a request in flight, returning false if the server is
+// shutting down, in which case it must be rejected. Servers made without New
+// have no lifecycle, and accept every request.
+func (l *lifecycle) enter() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.draining {
+		return false
+	}
+	l.requests.Add(1)
+	return true
+}
+
+// exit records a request entered with enter has finished.
+func (l *lifecycle) exit() {
+	if l != nil {
+		l.requests.Done()
+	}
+}
+
+// isDraining reports whether the server is shutting down.
+func (l *lifecycle) isDraining() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.draining
+}
+
+// done returns a channel which is closed once the server starts shutting
+// down. It's never closed for servers made without New.
+func (l *lifecycle) done() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	return l.drained
+}
+
+// run runs f in the background, where it must return once the context of
+// the Server's background work is done.
+func (l *lifecycle) run(f func()) {
+	l.workers.Add(1)
+	go func() {
+		defer l.workers.Done()
+		f()
+	}()
+}
+
+// wait waits for wg, or until ctx is done.
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown shuts the server down gracefully. New requests are rejected with
+// 503 Service Unavailable and a Retry-After header, while those in flight,
+// such as uploads, are waited for until ctx is done. Background work, such as
+// the reaper, is then stopped and waited for, and finally the database and
+// any file systems which are an io.Closer are closed.
+//
+// The database and file systems are closed even if ctx is done before
+// everything else stops, failing what's left, in which case ctx's error is
+// returned.
+func (s Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	// Namespaces are shut down alongside the server.
+	nsErr := make(chan error, 1)
+	go func() { nsErr <- s.shutdownNamespaces(ctx) }()
+	l := s.lifecycle
+	if l != nil {
+		l.mu.Lock()
+		if !l.draining {
+			l.draining = true
+			close(l.drained)
+		}
+		l.mu.Unlock()
+		if err := wait(ctx, &l.requests); err != nil {
+			errs = append(errs, fmt.Errorf("wait for requests: %w", err))
+		}
+		l.cancel()
+		if err := wait(ctx, &l.workers); err != nil {
+			errs = append(errs, fmt.Errorf("wait for background work: %w", err))
+		}
+	}
+	closeAll := func() {
+		// They're closed however long the rest took.
+		ctx := xcontext.Detach(ctx)
+		if s.Database != nil {
+			if err := s.Database.Close(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("close database: %w", err))
+			}
+		}
+		if l == nil {
+			return
+		}
+		for _, c := range l.closers {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close filesystem: %w", err))
+			}
+		}
+	}
+	if l != nil {
+		l.closeOnce.Do(closeAll)
+	} else {
+		closeAll()
+	}
+	errs = append(errs, <-nsErr)
+	return errors.Join(errs...)
+}
+
+// unavailable responds to r that the server is shutting down.
+func (s Server) unavailable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(drainRetryAfter.Seconds())))
+	s.httpError(w, r, newError(http.StatusServiceUnavailable, CodeShuttingDown, http.StatusText(http.StatusServiceUnavailable)))
+}
+
+// draining responds to r, a health check, with msg that the server is
+// shutting down.
+func (s Server) draining(w http.ResponseWriter, r *http.Request, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(drainRetryAfter.Seconds())))
+	s.httpError(w, r, newError(http.StatusServiceUnavailable, CodeShuttingDown, msg))
+}
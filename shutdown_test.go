@@ -0,0 +1,132 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// closer is a file system which records whether it was closed.
+type closer struct {
+	filesystem.FileSystem
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+
+	fs := &closer{FileSystem: memfs.New()}
+	s, err := New(ctx, DB(memory.New()), FS(fs), Limit(1<<20), Reaper(time.Hour, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The upload is written slowly, so it's in flight when the server
+	// starts shutting down.
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	r := httptest.NewRequest(http.MethodPost, "/", pr)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploaded := make(chan struct{})
+	go func() {
+		defer close(uploaded)
+		s.ServeHTTP(w, r)
+	}()
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(fw, strings.Repeat("a", 1<<10)); err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+
+	// New requests are rejected once it's draining.
+	for {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if w.Code == http.StatusServiceUnavailable {
+			if w.Header().Get("Retry-After") == "" {
+				t.Fatal("missing Retry-After")
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shut down with an upload in flight: %v", err)
+	default:
+	}
+
+	if _, err := io.WriteString(fw, strings.Repeat("b", 1<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+	<-uploaded
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	if !fs.closed {
+		t.Fatal("file system wasn't closed")
+	}
+
+	// The upload was persisted whole.
+	slug := strings.TrimSuffix(path.Base(w.Header().Get("Location")), ".txt")
+	e, err := s.Database.Lookup(ctx, slug)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if e.Size != 2<<10 {
+		t.Fatalf("unexpected size; got %d, want %d", e.Size, 2<<10)
+	}
+	f, err := fs.Open(ctx, slug)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if b, err := io.ReadAll(f); err != nil || string(b) != strings.Repeat("a", 1<<10)+strings.Repeat("b", 1<<10) {
+		t.Fatalf("unexpected file; got %d bytes and %v", len(b), err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A request which never finishes.
+	if !s.lifecycle.enter() {
+		t.Fatal("request rejected before shutdown")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error; got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
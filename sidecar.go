@@ -0,0 +1,32 @@
+package kipp
+
+import (
+	"context"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/filesystem"
+)
+
+// A sidecarWriter stores the entry of each file alongside it, such as
+// local.FileSystem, so the database can be rebuilt from the files.
+type sidecarWriter interface {
+	WriteSidecar(ctx context.Context, e database.Entry) error
+}
+
+// findSidecarWriter returns fs, or the file system it wraps, if it's a
+// sidecarWriter, or nil otherwise. File systems which transform files, such
+// as by compressing or encrypting them, can't be unwrapped, as what they
+// store can't be checked against the sums of their entries.
+func findSidecarWriter(fs filesystem.FileSystem) sidecarWriter {
+	for fs != nil {
+		if w, ok := fs.(sidecarWriter); ok {
+			return w
+		}
+		u, ok := fs.(interface{ Unwrap() filesystem.FileSystem })
+		if !ok {
+			break
+		}
+		fs = u.Unwrap()
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package kipp
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem/local"
+)
+
+func TestUploadSidecar(t *testing.T) {
+	ctx := context.Background()
+
+	for _, encrypted := range []bool{false, true} {
+		dir := t.TempDir()
+		fs, err := local.New(dir, local.Sidecars())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := []Option{DB(memory.New()), FS(fs), Limit(1 << 20)}
+		if encrypted {
+			opts = append(opts, FileKey(make([]byte, 32)))
+		}
+		s, err := New(ctx, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := upload(t, s, 10)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+		}
+		slug := strings.TrimSuffix(path.Base(w.Header().Get("Location")), ".txt")
+
+		// Encrypted files can't be checked against their sums, so they
+		// have no sidecars.
+		_, err = os.Stat(filepath.Join(dir, slug+".json"))
+		if (err == nil) == encrypted {
+			t.Fatalf("unexpected sidecar with encrypted=%t: %v", encrypted, err)
+		}
+		if encrypted {
+			continue
+		}
+		db := memory.New()
+		if n, err := local.Rebuild(ctx, dir, db, slog.Default()); err != nil || n != 1 {
+			t.Fatalf("unexpected rebuild; got %d and %v, want 1", n, err)
+		}
+		want, err := s.Database.Lookup(ctx, slug)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		got, err := db.Lookup(ctx, slug)
+		if err != nil || got.Sum != want.Sum || got.Name != want.Name || got.Size != want.Size {
+			t.Fatalf("unexpected rebuilt entry; got %+v and %v, want %+v", got, err, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package kipp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor of sockets passed by systemd.
+const listenFDsStart = 3
+
+// activated returns the listeners of sockets passed by systemd, if any. The
+// environment which passed them is unset, so they aren't passed on to child
+// processes.
+var activated = sync.OnceValues(func() ([]net.Listener, error) {
+	defer func() {
+		for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			os.Unsetenv(k)
+		}
+	}()
+	return listenFDs(listenFDsStart)
+})
+
+// listenFDs returns listeners of the sockets passed by systemd, starting at
+// the file descriptor start, if they were passed to this process.
+func listenFDs(start int) ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	lns := make([]net.Listener, 0, n)
+	for fd := start; fd < start+n; fd++ {
+		// The file descriptor is duplicated by FileListener, so the
+		// original is closed either way.
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("listen on fd %d: %w", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// listen listens on addr, which is a TCP address, or the path of a unix socket
+// prefixed with unix://. If systemd passed sockets to the process, the ith is
+// adopted instead, if there is one.
+func (s Server) listen(addr string, i int) (net.Listener, error) {
+	lns, err := activated()
+	if err != nil {
+		return nil, err
+	}
+	if i < len(lns) {
+		return lns[i], nil
+	}
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return listenUnix(path, s.SocketMode)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix listens on a unix socket at path, with the given mode unless
+// it's zero. A socket left behind at path which nothing is listening on is
+// removed first. The socket is removed when the listener is closed.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("chmod socket: %w", err)
+		}
+	}
+	return ln, nil
+}
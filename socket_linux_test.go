@@ -0,0 +1,179 @@
+//go:build linux
+// +build linux
+
+package kipp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+	"golang.org/x/sys/unix"
+)
+
+// unixClient returns a client which dials the unix socket at path, whatever
+// the address of the request.
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kipp.sock")
+
+	// A socket left behind by a crash is replaced.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), SocketMode(0o600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(ctx, "unix://"+path) }()
+
+	c := unixClient(path)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		res, err := c.Get("http://kipp/livez")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0o600 {
+		t.Fatalf("unexpected mode; got %o, want %o", got, 0o600)
+	}
+
+	// The upload is redirected to the file, which is followed.
+	r := uploadRequest(t, 10)
+	req, err := http.NewRequest(r.Method, "http://kipp/", r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = r.Header
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(b) != strings.Repeat("a", 10) {
+		t.Fatalf("unexpected response; got %d %q", res.StatusCode, b)
+	}
+
+	// Sockets which are in use aren't replaced.
+	if _, err := listenUnix(path, 0); err == nil {
+		t.Fatal("expected error for a socket in use")
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket wasn't removed: %v", err)
+	}
+
+	// Files which aren't sockets are never removed.
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(path, 0); err == nil {
+		t.Fatal("expected error for a file which isn't a socket")
+	}
+}
+
+func TestListenFDs(t *testing.T) {
+	// The sockets are duplicated to consecutive file descriptors, as
+	// systemd passes them.
+	const start = 100
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ln.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Dup2(int(f.Fd()), start+i); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		ln.Close()
+		addrs = append(addrs, ln.Addr().String())
+	}
+
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_PID", "1")
+	if lns, err := listenFDs(start); err != nil || lns != nil {
+		t.Fatalf("adopted sockets passed to another process: %v, %v", lns, err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	lns, err := listenFDs(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lns) != 2 {
+		t.Fatalf("unexpected listeners; got %d, want 2", len(lns))
+	}
+	for i, ln := range lns {
+		defer ln.Close()
+		if got := ln.Addr().String(); got != addrs[i] {
+			t.Fatalf("unexpected address; got %s, want %s", got, addrs[i])
+		}
+	}
+
+	// The passed socket is served.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, lns[0]) }()
+	res, err := http.Get("http://" + addrs[0] + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status; got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,61 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+// spaIndex is the page of PublicPath or PublicFS served for paths routed by a
+// single-page app.
+const spaIndex = "/index.html"
+
+// isSPARoute reports whether p may be routed by a single-page app in
+// PublicPath or PublicFS, rather than not found. Paths with extensions are of
+// missing assets, and those of endpoints are never routed by it, nor is the
+// API, whether or not it's enabled.
+func (s Server) isSPARoute(p string) bool {
+	if _, ok := s.routes[p]; ok || !s.SPAFallback || p == "/" || path.Ext(p) != "" {
+		return false
+	}
+	return !strings.HasPrefix(p, adminPrefix) &&
+		!strings.HasPrefix(p, DefaultProfilePrefix) &&
+		!s.isProfile(p)
+}
+
+// openSPA opens the index of the single-page app in PublicPath or PublicFS to
+// be served for p, which wasn't found, unless the first segment of p is the
+// slug of an entry. Entries are never shadowed by the app, even if they've
+// expired or been deleted, so p stays not found.
+func (s Server) openSPA(ctx context.Context, w http.ResponseWriter, p string) (http.File, error) {
+	slug, _, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if _, err := s.Database.Lookup(ctx, slug); !errors.Is(err, database.ErrNoResults) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, os.ErrNotExist
+	}
+	f, err := s.openPublic(spaIndex)
+	if err != nil {
+		return nil, err
+	}
+	d, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if d.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	// The page is served for many paths, so it's revalidated rather than
+	// cached as an asset would be, and it's always HTML whatever the path.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return f, nil
+}
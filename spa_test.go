@@ -0,0 +1,111 @@
+package kipp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+func TestSPAFallback(t *testing.T) {
+	const index = "<!doctype html><title>app</title>"
+
+	newServer := func(t *testing.T, withIndex bool, opts ...Option) *Server {
+		t.Helper()
+		ctx := context.Background()
+		dir := t.TempDir()
+		if withIndex {
+			if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(index), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte("app()"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := New(ctx, append([]Option{DB(memory.New()), FS(memfs.New()), Data(dir)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleted := databasetest.NewEntry("deleted")
+		deleted.Deleted = &deleted.Timestamp
+		for _, e := range []database.Entry{databasetest.NewEntry("entry"), deleted} {
+			e.Lifetime = nil
+			if err := s.Database.Create(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.FileSystem.Create(ctx, e.Slug, strings.NewReader("some data")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s
+	}
+	get := func(s *Server, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	s := newServer(t, true, SPAFallback())
+
+	// Client side routes are served the app.
+	for _, target := range []string{"/settings", "/settings/profile", "/a/b/c"} {
+		w := get(s, target)
+		if w.Code != http.StatusOK || w.Body.String() != index {
+			t.Fatalf("%s: app wasn't served; got %d %q", target, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+			t.Fatalf("%s: unexpected Cache-Control; got %q, want %q", target, got, "no-cache")
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+			t.Fatalf("%s: unexpected Content-Type; got %q", target, got)
+		}
+	}
+
+	for _, tt := range []struct {
+		target string
+		code   int
+		body   string
+	}{
+		// Files of the data directory and entries win.
+		{"/app.js", http.StatusOK, "app()"},
+		{"/entry", http.StatusOK, "some data"},
+		{"/entry.txt", http.StatusOK, "some data"},
+		// Paths with extensions are missing assets.
+		{"/missing.js", http.StatusNotFound, ""},
+		// Paths under entries, and those of deleted entries, aren't
+		// routed by the app.
+		{"/entry/settings", http.StatusNotFound, ""},
+		{"/deleted", http.StatusNotFound, ""},
+		// Nor are the API and probes, even when they're disabled.
+		{"/admin/files", http.StatusNotFound, ""},
+		{"/debug/pprof/", http.StatusNotFound, ""},
+		{"/oembed", http.StatusBadRequest, ""},
+		{"/livez", http.StatusOK, ""},
+	} {
+		w := get(s, tt.target)
+		if w.Code != tt.code {
+			t.Fatalf("%s: unexpected status; got %d, want %d", tt.target, w.Code, tt.code)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Fatalf("%s: unexpected body; got %q, want %q", tt.target, w.Body, tt.body)
+		}
+		if strings.Contains(w.Body.String(), index) {
+			t.Fatalf("%s: app was served", tt.target)
+		}
+	}
+
+	// Without the option, or without an app to serve, nothing falls back.
+	for _, s := range []*Server{newServer(t, true), newServer(t, false, SPAFallback())} {
+		if w := get(s, "/settings"); w.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package kipp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/migrate"
+	xcontext "github.com/uhthomas/kipp/internal/x/context"
+)
+
+const (
+	// stagedSuffix suffixes the names of staged objects. Slugs and their
+	// variants never end with it, so sweeps can't remove final objects
+	// even if the staging file system is shared with them.
+	stagedSuffix = ".staged"
+	// defaultStagingAge is how long ago staged objects must have been
+	// modified to be swept if Server.StagingAge isn't set.
+	defaultStagingAge = 24 * time.Hour
+	// stagingInterval is the interval at which staged objects are swept.
+	stagingInterval = 10 * time.Minute
+)
+
+// isStaged reports whether the named object is staged.
+func isStaged(name string) bool { return strings.HasSuffix(name, stagedSuffix) }
+
+// stage stores r in the staging file system under a new name, which it
+// returns. Anything stored of it is removed if it fails.
+func (s Server) stage(ctx context.Context, r io.Reader) (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return "", fmt.Errorf("name: %w", err)
+	}
+	name := base64.RawURLEncoding.EncodeToString(b[:]) + stagedSuffix
+	if err := s.Staging.Create(ctx, name, r); err != nil {
+		s.unstage(ctx, name)
+		return "", fmt.Errorf("stage: %w", err)
+	}
+	return name, nil
+}
+
+// promote copies the named staged object to the file system as slug, checking
+// it still has size bytes and sum as it's copied, so a staged object which
+// was corrupted is never committed. The staged object is removed whether or
+// not it succeeds.
+func (s Server) promote(ctx context.Context, staged, slug string, size int64, sum []byte) error {
+	defer s.unstage(ctx, staged)
+	f, err := s.Staging.Open(ctx, staged)
+	if err != nil {
+		return fmt.Errorf("open staged: %w", err)
+	}
+	defer f.Close()
+	r := migrate.Verify(f, size, base64.RawURLEncoding.EncodeToString(sum))
+	if err := s.FileSystem.Create(ctx, slug, r); err != nil {
+		return fmt.Errorf("promote: %w", err)
+	}
+	return nil
+}
+
+// unstage removes the named staged object, even if ctx was cancelled.
+func (s Server) unstage(ctx context.Context, name string) {
+	err := s.Staging.Remove(xcontext.Detach(ctx), name)
+	if err != nil && !filesystem.IsNotExist(err) {
+		s.logger().Warn("remove staged", "name", name, "error", err)
+	}
+}
+
+// SweepStaging removes staged objects last modified more than StagingAge ago,
+// such as those of uploads which crashed, returning how many were removed.
+// Objects which aren't staged are never removed, even if they share the
+// staging file system. Objects which can't be removed are logged and left for
+// the next sweep.
+func (s Server) SweepStaging(ctx context.Context) (n int, err error) {
+	age := s.StagingAge
+	if age <= 0 {
+		age = defaultStagingAge
+	}
+	before := time.Now().Add(-age)
+
+	var objects, size int64
+	if err := s.Staging.(filesystem.Walker).Walk(ctx, func(fi filesystem.FileInfo) error {
+		if !isStaged(fi.Name) {
+			return nil
+		}
+		if fi.ModTime.IsZero() || fi.ModTime.After(before) {
+			objects++
+			size += fi.Size
+			return nil
+		}
+		if err := s.Staging.Remove(ctx, fi.Name); err != nil && !filesystem.IsNotExist(err) {
+			s.logger().Warn("remove staged", "name", fi.Name, "error", err)
+			objects++
+			size += fi.Size
+			return nil
+		}
+		n++
+		return nil
+	}); err != nil {
+		return n, fmt.Errorf("walk: %w", err)
+	}
+	if s.stagingMetrics != nil {
+		s.stagingMetrics.objects.Set(float64(objects))
+		s.stagingMetrics.bytes.Set(float64(size))
+		s.stagingMetrics.swept.Add(float64(n))
+	}
+	return n, nil
+}
+
+// stagingMetrics export what's staged.
+type stagingMetrics struct {
+	objects, bytes prometheus.Gauge
+	swept          prometheus.Counter
+}
+
+func newStagingMetrics(r prometheus.Registerer) (*stagingMetrics, error) {
+	m := &stagingMetrics{
+		objects: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "staged_objects",
+			Help:      "Number of staged objects as of the last sweep.",
+		}),
+		bytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "staged_bytes",
+			Help:      "Total size of staged objects as of the last sweep.",
+		}),
+		swept: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "staged_swept_total",
+			Help:      "Number of old staged objects removed by sweeps.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.objects, m.bytes, m.swept} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// runStagingSweeps sweeps staged objects every interval until ctx is done.
+func runStagingSweeps(ctx context.Context, s Server, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		n, err := s.SweepStaging(ctx)
+		if n > 0 {
+			s.logger().Info("removed old staged objects", "objects", n)
+		}
+		if err != nil && ctx.Err() == nil {
+			s.logger().Error("sweep staging", "error", err)
+		}
+	}
+}
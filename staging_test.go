@@ -0,0 +1,117 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	"github.com/uhthomas/kipp/filesystem"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+	"github.com/uhthomas/kipp/filesystem/migrate"
+	"github.com/zeebo/blake3"
+)
+
+func TestStagingUpload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fs, staging := memfs.New(), memfs.New()
+	s, err := New(ctx, DB(memory.New()), FS(fs), Staging(staging, 0), Limit(1<<20), DatabaseMetrics(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := upload(t, s, 10)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	slug := strings.TrimSuffix(path.Base(w.Header().Get("Location")), ".txt")
+	f, err := fs.Open(ctx, slug)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if b, err := io.ReadAll(f); err != nil || string(b) != strings.Repeat("a", 10) {
+		t.Fatalf("unexpected file; got %q and %v", b, err)
+	}
+	if n := staging.Size(); n != 0 {
+		t.Fatalf("staged upload wasn't removed; %d bytes staged", n)
+	}
+}
+
+func TestPromoteCorrupt(t *testing.T) {
+	ctx := context.Background()
+
+	s := Server{FileSystem: memfs.New(), Staging: memfs.New()}
+	staged, err := s.stage(ctx, strings.NewReader("some data"))
+	if err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	sum := blake3.Sum256([]byte("other data"))
+	if err := s.promote(ctx, staged, "a", 9, sum[:]); !errors.Is(err, migrate.ErrMismatch) {
+		t.Fatalf("unexpected error; got %v, want %v", err, migrate.ErrMismatch)
+	}
+	if _, err := s.FileSystem.Stat(ctx, "a"); !filesystem.IsNotExist(err) {
+		t.Fatalf("corrupt file was promoted; got %v", err)
+	}
+	if _, err := s.Staging.Stat(ctx, staged); !filesystem.IsNotExist(err) {
+		t.Fatalf("staged file wasn't removed; got %v", err)
+	}
+}
+
+func TestSweepStaging(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The staging file system is shared with a final object, which must
+	// never be swept.
+	fs := memfs.New()
+	for _, name := range []string{"a", "b" + stagedSuffix, "c" + stagedSuffix} {
+		if err := fs.Create(ctx, name, strings.NewReader("abc")); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	s, err := New(ctx, DB(memory.New()), FS(fs), Staging(fs, time.Hour), DatabaseMetrics(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// They're too new to be swept when the server starts.
+	if got := testutil.ToFloat64(s.stagingMetrics.objects); got != 2 {
+		t.Fatalf("unexpected staged objects; got %v, want 2", got)
+	}
+
+	s.StagingAge = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if err := fs.Create(ctx, "d"+stagedSuffix, strings.NewReader("abcd")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if n, err := s.SweepStaging(ctx); err != nil || n != 2 {
+		t.Fatalf("unexpected sweep; got %d and %v, want 2 removed", n, err)
+	}
+	for name, want := range map[string]bool{"a": true, "b" + stagedSuffix: false, "c" + stagedSuffix: false, "d" + stagedSuffix: true} {
+		if _, err := fs.Stat(ctx, name); (err == nil) != want {
+			t.Fatalf("unexpected stat of %s; got %v, want exists=%t", name, err, want)
+		}
+	}
+	if got := testutil.ToFloat64(s.stagingMetrics.bytes); got != 4 {
+		t.Fatalf("unexpected staged bytes; got %v, want 4", got)
+	}
+	if got := testutil.ToFloat64(s.stagingMetrics.swept); got != 2 {
+		t.Fatalf("unexpected swept objects; got %v, want 2", got)
+	}
+}
+
+func TestStagingUnsupported(t *testing.T) {
+	ctx := context.Background()
+
+	staging := struct{ filesystem.FileSystem }{memfs.New()}
+	if _, err := New(ctx, DB(memory.New()), FS(memfs.New()), Staging(staging, 0)); err == nil {
+		t.Fatal("expected error for a staging filesystem which can't be listed")
+	}
+}
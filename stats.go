@@ -0,0 +1,125 @@
+package kipp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/stats"
+)
+
+// adminStats is the path of statistics about the entries in the database.
+const adminStats = adminPrefix + "stats"
+
+// Stats returns statistics about the entries in the database.
+func (s Server) Stats(ctx context.Context) (database.Stats, error) {
+	return stats.Compute(ctx, s.Database, time.Now())
+}
+
+// statsGauges export database statistics, which are expensive to compute, so
+// are refreshed periodically rather than when scraped.
+type statsGauges struct {
+	entries  prometheus.Gauge
+	bytes    *prometheus.GaugeVec
+	expiring *prometheus.GaugeVec
+}
+
+func newStatsGauges(r prometheus.Registerer) (*statsGauges, error) {
+	g := &statsGauges{
+		entries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "entries",
+			Help:      "Number of entries in the database.",
+		}),
+		bytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "entries_bytes",
+			Help:      "Total size of the files of entries in the database.",
+		}, []string{"variant"}),
+		expiring: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "entries_expiring_bytes",
+			Help:      "Total size of the files of entries whose lifetimes end within a period.",
+		}, []string{"within"}),
+	}
+	for _, c := range []prometheus.Collector{g.entries, g.bytes, g.expiring} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return g, nil
+}
+
+// Run refreshes the gauges from s every interval until ctx is done.
+func (g *statsGauges) Run(ctx context.Context, s Server, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		st, err := s.Stats(ctx)
+		if err != nil {
+			s.logger().Error("stats", "error", err)
+		} else {
+			g.set(st)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (g *statsGauges) set(s database.Stats) {
+	g.entries.Set(float64(s.Entries))
+	g.bytes.WithLabelValues("original").Set(float64(s.Bytes))
+	g.bytes.WithLabelValues("gzip").Set(float64(s.GzipBytes))
+	g.expiring.WithLabelValues("24h").Set(float64(s.ExpiringDay))
+	g.expiring.WithLabelValues("168h").Set(float64(s.ExpiringWeek))
+}
+
+// adminDatabaseStats are statistics about the entries in the database as the
+// admin API serves them.
+type adminDatabaseStats struct {
+	Entries      int64           `json:"entries"`
+	Bytes        int64           `json:"bytes"`
+	GzipBytes    int64           `json:"gzip_bytes"`
+	ExpiringDay  int64           `json:"expiring_day"`
+	ExpiringWeek int64           `json:"expiring_week"`
+	Days         []adminDayStats `json:"days"`
+}
+
+type adminDayStats struct {
+	Day     string `json:"day"`
+	Entries int64  `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// serveAdminStats serves statistics about the entries in the database, which
+// are computed for each request.
+func (s Server) serveAdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	st, err := s.Stats(r.Context())
+	if err != nil {
+		s.adminDatabaseError(w, r, "stats", err)
+		return
+	}
+	res := adminDatabaseStats{
+		Entries:      st.Entries,
+		Bytes:        st.Bytes,
+		GzipBytes:    st.GzipBytes,
+		ExpiringDay:  st.ExpiringDay,
+		ExpiringWeek: st.ExpiringWeek,
+		Days:         make([]adminDayStats, len(st.Days)),
+	}
+	for i, d := range st.Days {
+		res.Days[i] = adminDayStats{Day: d.Day.Format(time.DateOnly), Entries: d.Entries, Bytes: d.Bytes}
+	}
+	s.adminJSON(w, r, http.StatusOK, res)
+}
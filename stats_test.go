@@ -0,0 +1,48 @@
+package kipp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+)
+
+func TestStatsGauges(t *testing.T) {
+	ctx := context.Background()
+
+	s := Server{Database: memory.New()}
+	for _, slug := range []string{"a", "b"} {
+		e := databasetest.NewEntry(slug)
+		e.Size = 100
+		if err := s.Database.Create(ctx, e); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	g, err := newStatsGauges(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := s.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	g.set(st)
+
+	for _, v := range []struct {
+		c    prometheus.Collector
+		want float64
+	}{
+		{g.entries, 2},
+		{g.bytes.WithLabelValues("original"), 200},
+		// The entries' lifetimes end in an hour.
+		{g.expiring.WithLabelValues("24h"), 200},
+	} {
+		if got := testutil.ToFloat64(v.c); got != v.want {
+			t.Fatalf("unexpected value; got %v, want %v", got, v.want)
+		}
+	}
+}
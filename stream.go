@@ -0,0 +1,219 @@
+package kipp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// adminEvents is the path events are streamed from.
+	adminEvents = adminPrefix + "events"
+	// streamReplay is how many of the latest events are kept to be
+	// replayed to clients which reconnect.
+	streamReplay = 256
+	// streamBuffer is how many events may wait to be sent to each client
+	// before the oldest are dropped.
+	streamBuffer = 64
+	// streamHeartbeat is how often clients are sent a comment when there
+	// are no events, so proxies don't close idle streams.
+	streamHeartbeat = 15 * time.Second
+)
+
+// streamEvent is an event as it's streamed, with the ID clients reconnect
+// with.
+type streamEvent struct {
+	id   uint64
+	typ  EventType
+	data []byte
+}
+
+// eventStream streams events to clients of the admin API. Events are never
+// waited for clients to receive, so slow ones miss the oldest events rather
+// than holding up the server.
+type eventStream struct {
+	heartbeat time.Duration
+	dropped   prometheus.Counter
+
+	mu sync.Mutex
+	// next is the ID of the next event.
+	next uint64
+	// replay holds the latest events, oldest first.
+	replay  []streamEvent
+	clients map[*streamClient]struct{}
+}
+
+// streamClient is a client of an eventStream, and the events it's yet to be
+// sent.
+type streamClient struct {
+	types map[EventType]bool
+	// wake is sent to when events are buffered.
+	wake chan struct{}
+
+	mu  sync.Mutex
+	buf []streamEvent
+}
+
+func newEventStream(r prometheus.Registerer) (*eventStream, error) {
+	s := &eventStream{
+		heartbeat: streamHeartbeat,
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "admin_events_dropped_total",
+			Help:      "Number of events dropped because a client of the admin event stream was too slow.",
+		}),
+		next:    1,
+		clients: make(map[*streamClient]struct{}),
+	}
+	if err := r.Register(s.dropped); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	return s, nil
+}
+
+// Publish sends ev to every client which wants its type.
+func (s *eventStream) Publish(ev Event) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	se := streamEvent{id: s.next, typ: ev.Type, data: b}
+	s.next++
+	if len(s.replay) == streamReplay {
+		s.replay = append(s.replay[:0], s.replay[1:]...)
+	}
+	s.replay = append(s.replay, se)
+	for c := range s.clients {
+		if c.types[se.typ] {
+			c.push(se, s.dropped)
+		}
+	}
+}
+
+// Subscribe returns a client which is sent events of types, starting with
+// those after last which can still be replayed if last isn't zero. It must
+// be unsubscribed.
+func (s *eventStream) Subscribe(types map[EventType]bool, last uint64) *streamClient {
+	c := &streamClient{types: types, wake: make(chan struct{}, 1)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last > 0 {
+		for _, se := range s.replay {
+			if se.id > last && types[se.typ] {
+				c.push(se, s.dropped)
+			}
+		}
+	}
+	s.clients[c] = struct{}{}
+	return c
+}
+
+// Unsubscribe stops sending events to c.
+func (s *eventStream) Unsubscribe(c *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+// push buffers se, dropping the oldest event if the buffer is full.
+func (c *streamClient) push(se streamEvent, dropped prometheus.Counter) {
+	c.mu.Lock()
+	if len(c.buf) == streamBuffer {
+		c.buf = append(c.buf[:0], c.buf[1:]...)
+		dropped.Inc()
+	}
+	c.buf = append(c.buf, se)
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the buffered events, emptying the buffer.
+func (c *streamClient) take() []streamEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf := c.buf
+	c.buf = nil
+	return buf
+}
+
+// streamTypes parses the comma separated event types of a stream. Downloads
+// are only streamed if they're asked for, as there are far more of them.
+func streamTypes(s string) (map[EventType]bool, error) {
+	if s == "" {
+		return map[EventType]bool{EventUpload: true, EventDelete: true, EventExpire: true}, nil
+	}
+	types := make(map[EventType]bool)
+	for _, t := range strings.Split(s, ",") {
+		switch typ := EventType(t); typ {
+		case EventUpload, EventDelete, EventExpire, EventDownload:
+			types[typ] = true
+		default:
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return types, nil
+}
+
+// adminEvents streams events to r as server-sent events until it's done, or
+// the server shuts down. The types query parameter filters them, and clients
+// which reconnect with a Last-Event-ID are sent the events they missed, as
+// long as they're recent enough.
+func (s Server) adminEvents(w http.ResponseWriter, r *http.Request) {
+	types, err := streamTypes(r.URL.Query().Get("types"))
+	if err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, err.Error()))
+		return
+	}
+	// IDs which can't be parsed, such as those of a previous process
+	// after a restart, replay nothing.
+	last, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	c := s.stream.Subscribe(types, last)
+	defer s.stream.Unsubscribe(c)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// nginx buffers responses unless it's told not to.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	t := time.NewTicker(s.stream.heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.wake:
+			var sb strings.Builder
+			for _, se := range c.take() {
+				fmt.Fprintf(&sb, "id: %d\nevent: %s\ndata: %s\n\n", se.id, se.typ, se.data)
+			}
+			if _, err := io.WriteString(w, sb.String()); err != nil {
+				return
+			}
+		case <-t.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.lifecycle.done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
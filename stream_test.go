@@ -0,0 +1,236 @@
+package kipp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// sseMessage is a message of a server-sent event stream, which is a
+// heartbeat if it has no event.
+type sseMessage struct {
+	id, event string
+	data      Event
+}
+
+// readSSE sends the messages of the stream r to messages until it ends,
+// closing it then.
+func readSSE(t *testing.T, r io.Reader, messages chan<- sseMessage) {
+	defer close(messages)
+	var m sseMessage
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// Comments are heartbeats.
+		if strings.HasPrefix(sc.Text(), ":") {
+			messages <- sseMessage{}
+			continue
+		}
+		k, v, _ := strings.Cut(sc.Text(), ": ")
+		switch k {
+		case "":
+			if m.event != "" {
+				messages <- m
+			}
+			m = sseMessage{}
+		case "id":
+			m.id = v
+		case "event":
+			m.event = v
+		case "data":
+			if err := json.Unmarshal([]byte(v), &m.data); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestAdminEvents(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), Admin("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.stream.heartbeat = 10 * time.Millisecond
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	// Streams are ended before the test server waits for them.
+	defer s.Shutdown(ctx)
+
+	do := func(method, target, lastID string, body io.Reader) *http.Response {
+		t.Helper()
+		r, err := http.NewRequest(method, hs.URL+target, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(target, adminPrefix) {
+			r.Header.Set("Authorization", "Bearer secret")
+		}
+		if lastID != "" {
+			r.Header.Set("Last-Event-ID", lastID)
+		}
+		res, err := hs.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	stream := func(target, lastID string) chan sseMessage {
+		t.Helper()
+		res := do(http.MethodGet, target, lastID, nil)
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("unexpected response; got %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		messages := make(chan sseMessage, 16)
+		go func() {
+			defer res.Body.Close()
+			readSSE(t, res.Body, messages)
+		}()
+		return messages
+	}
+	// next returns the next event which isn't a heartbeat.
+	next := func(messages <-chan sseMessage) sseMessage {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case m, ok := <-messages:
+				if !ok {
+					t.Fatal("stream ended")
+				}
+				if m.event != "" {
+					return m
+				}
+			case <-timeout:
+				t.Fatal("no event was streamed")
+			}
+		}
+	}
+	uploadFile := func() string {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		fw, err := mw.CreateFormFile("file", "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, "some data")
+		mw.Close()
+		r, err := http.NewRequest(http.MethodPost, hs.URL, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		// The slug is where the upload redirects to.
+		res, err := hs.Client().Transport.RoundTrip(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d", res.StatusCode, http.StatusSeeOther)
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(res.Header.Get("Location"), "/"), ".txt")
+	}
+
+	messages := stream(adminEvents+"?types=upload,download", "")
+	slug := uploadFile()
+	first := next(messages)
+	if first.event != string(EventUpload) || first.data.Slug != slug || first.data.Name != "file.txt" {
+		t.Fatalf("unexpected event: %+v", first)
+	}
+	res := do(http.MethodGet, "/"+slug+".txt", "", nil)
+	res.Body.Close()
+	if m := next(messages); m.event != string(EventDownload) || m.data.Slug != slug {
+		t.Fatalf("unexpected event: %+v", m)
+	}
+	// Deletes aren't streamed, as they weren't asked for.
+	if err := s.Delete(ctx, slug); err != nil {
+		t.Fatal(err)
+	}
+	second := uploadFile()
+	if m := next(messages); m.event != string(EventUpload) || m.data.Slug != second {
+		t.Fatalf("unexpected event: %+v", m)
+	}
+	// Heartbeats are sent while nothing happens.
+	select {
+	case m := <-messages:
+		if m.event != "" {
+			t.Fatalf("unexpected event: %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no heartbeat was sent")
+	}
+
+	// Clients which reconnect are sent what they missed, without
+	// downloads unless they ask for them.
+	replayed := stream(adminEvents, first.id)
+	for _, want := range []struct {
+		typ  EventType
+		slug string
+	}{
+		{EventDelete, slug},
+		{EventUpload, second},
+	} {
+		if m := next(replayed); m.event != string(want.typ) || m.data.Slug != want.slug {
+			t.Fatalf("unexpected event; got %+v, want %s of %s", m, want.typ, want.slug)
+		}
+	}
+
+	res = do(http.MethodGet, adminEvents+"?types=upload,unknown", "", nil)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status; got %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+	res, err = hs.Client().Get(hs.URL + adminEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status; got %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Streams end when the server shuts down, rather than holding it up.
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for range messages {
+	}
+}
+
+func TestEventStreamDropsOldest(t *testing.T) {
+	es, err := newEventStream(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := es.Subscribe(map[EventType]bool{EventUpload: true}, 0)
+	defer es.Unsubscribe(c)
+	for range streamBuffer + 1 {
+		es.Publish(newEvent(EventUpload, databasetest.NewEntry("a")))
+		// Events clients don't want don't count towards their buffer.
+		es.Publish(newEvent(EventDelete, databasetest.NewEntry("a")))
+	}
+	buf := c.take()
+	if len(buf) != streamBuffer || buf[0].id != 3 {
+		t.Fatalf("unexpected buffer; got %d events from %d, want %d from 3", len(buf), buf[0].id, streamBuffer)
+	}
+	if got := testutil.ToFloat64(es.dropped); got != 1 {
+		t.Fatalf("unexpected dropped events; got %v, want 1", got)
+	}
+}
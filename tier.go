@@ -0,0 +1,110 @@
+package kipp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/uhthomas/kipp/filesystem"
+	"github.com/uhthomas/kipp/filesystem/tier"
+)
+
+// findTiers returns fs, or the file system it wraps, if it's a
+// tier.FileSystem, or nil otherwise. File systems which transform files, such
+// as by compressing or encrypting them, can't be unwrapped, but moving what
+// they store between tiers is unaffected.
+func findTiers(fs filesystem.FileSystem) *tier.FileSystem {
+	for fs != nil {
+		if t, ok := fs.(*tier.FileSystem); ok {
+			return t
+		}
+		u, ok := fs.(interface{ Unwrap() filesystem.FileSystem })
+		if !ok {
+			break
+		}
+		fs = u.Unwrap()
+	}
+	return nil
+}
+
+// parseTier returns the tier named s, such as "small".
+func parseTier(s string) (tier.Tier, bool) {
+	for _, t := range []tier.Tier{tier.Small, tier.Large} {
+		if t.String() == s {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// adminTier is the tier holding the files of an entry as the admin API serves
+// it.
+type adminTier struct {
+	Tier string `json:"tier"`
+}
+
+// serveAdminTier serves the tier holding the files of the named entry, and
+// moves them to that of the body of PUTs.
+func (s Server) serveAdminTier(w http.ResponseWriter, r *http.Request, slug string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut:
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	fs := findTiers(s.FileSystem)
+	if fs == nil {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "filesystem is not tiered"))
+		return
+	}
+	var to tier.Tier
+	if r.Method == http.MethodPut {
+		var req adminTier
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+			return
+		}
+		t, ok := parseTier(req.Tier)
+		if !ok {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "tier must be small or large"))
+			return
+		}
+		to = t
+	}
+	e, err := s.Database.Lookup(r.Context(), slug)
+	if err != nil {
+		s.adminDatabaseError(w, r, "lookup", err)
+		return
+	}
+	from, err := fs.Locate(r.Context(), slug)
+	if filesystem.IsNotExist(err) {
+		s.httpError(w, r, newError(http.StatusNotFound, CodeNotFound, "the file is missing"))
+		return
+	}
+	if err != nil {
+		s.adminDatabaseError(w, r, "locate", err)
+		return
+	}
+	if r.Method != http.MethodPut {
+		s.adminJSON(w, r, http.StatusOK, adminTier{from.String()})
+		return
+	}
+	if err := s.audit(r, "move tier", slug, adminTier{from.String()}, adminTier{to.String()}); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	names := []string{slug}
+	if e.GzipSize > 0 {
+		names = append(names, gzipName(slug))
+	}
+	for _, name := range names {
+		if err := fs.Move(r.Context(), name, to); err != nil {
+			s.adminDatabaseError(w, r, "move", err)
+			return
+		}
+	}
+	s.adminJSON(w, r, http.StatusOK, adminTier{to.String()})
+}
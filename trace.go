@@ -0,0 +1,77 @@
+package kipp
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer spans of requests are recorded with.
+const tracerName = "github.com/uhthomas/kipp"
+
+// propagator extracts the W3C trace contexts of requests, so their spans
+// continue the traces of clients.
+var propagator = propagation.TraceContext{}
+
+// route returns the route of the given path, with the names of files and
+// profiles replaced, so spans of requests for them share a name.
+func (s Server) route(path string) string {
+	if _, ok := s.routes[path]; ok || path == "/" || s.isReport(path) {
+		return path
+	}
+	if s.isProfile(path) {
+		return s.ProfilePrefix
+	}
+	if s.isAdmin(path) {
+		return adminRoute(path)
+	}
+	if s.isUserFiles(path) {
+		if path == userFilesPath {
+			return path
+		}
+		return userFilesPath + "/{slug}"
+	}
+	return "/{file}"
+}
+
+// startRequestSpan starts a server span for r, returning r with its context.
+// Only the path of r is recorded, as queries may be sensitive.
+func (s Server) startRequestSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	rt := s.route(r.URL.Path)
+	ctx, span := s.tracer.Start(ctx, r.Method+" "+rt,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(rt),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(remoteAddr(r)),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		),
+	)
+	if id, ok := RequestID(r.Context()); ok {
+		span.SetAttributes(attribute.String("kipp.request_id", id))
+	}
+	return r.WithContext(ctx), span
+}
+
+// endRequestSpan ends span with the status written to w. Only server errors
+// are errors of the span, as client errors are the client's.
+func endRequestSpan(span trace.Span, w *statusWriter) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttributes(
+		semconv.HTTPResponseStatusCode(status),
+		semconv.HTTPResponseBodySize(int(w.n)),
+	)
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
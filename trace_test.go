@@ -0,0 +1,150 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// uploadRequest returns a request uploading a file of n bytes.
+func uploadRequest(t *testing.T, n int) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, strings.Repeat("a", n))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// attr returns the value of the attribute of span with key, if any.
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTrace(t *testing.T) {
+	ctx := context.Background()
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	// Calls are traced even if they aren't measured.
+	s, err := New(ctx,
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<20),
+		TracerProvider(tp),
+		DatabaseMetrics(false),
+		FileSystemMetrics(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	r := uploadRequest(t, 10)
+	r.Header.Set("Traceparent", "00-"+traceID+"-"+spanID+"-01")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	slug := strings.TrimSuffix(strings.TrimPrefix(w.Header().Get("Location"), "/"), ".txt")
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range sr.Ended() {
+		spans[span.Name()] = span
+	}
+	server, ok := spans["POST /"]
+	if !ok {
+		t.Fatalf("no server span in %v", spans)
+	}
+	// The client's trace is continued.
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Fatalf("unexpected trace; got %s, want %s", got, traceID)
+	}
+	if got := server.Parent().SpanID().String(); got != spanID {
+		t.Fatalf("unexpected parent; got %s, want %s", got, spanID)
+	}
+	if got := attr(server, "http.response.status_code").AsInt64(); got != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", got, http.StatusSeeOther)
+	}
+	for _, tt := range []struct {
+		name, parent string
+		key          attribute.Key
+		want         string
+	}{
+		{"upload", "POST /", "kipp.slug", slug},
+		{"filesystem.create", "upload", "kipp.name", slug},
+		{"database.create", "upload", "kipp.slug", slug},
+	} {
+		span, ok := spans[tt.name]
+		if !ok {
+			t.Fatalf("no %s span in %v", tt.name, spans)
+		}
+		if got, want := span.Parent().SpanID(), spans[tt.parent].SpanContext().SpanID(); got != want {
+			t.Fatalf("%s: unexpected parent; got %s, want %s", tt.name, got, want)
+		}
+		if got := attr(span, tt.key).AsString(); got != tt.want {
+			t.Fatalf("%s: unexpected %s; got %q, want %q", tt.name, tt.key, got, tt.want)
+		}
+	}
+	if got := attr(spans["filesystem.create"], "kipp.size").AsInt64(); got != 10 {
+		t.Fatalf("unexpected size; got %d, want 10", got)
+	}
+	if got := attr(spans["database.create"], "kipp.database.backend").AsString(); got != "memory" {
+		t.Fatalf("unexpected backend; got %q, want %q", got, "memory")
+	}
+}
+
+// TestTraceStdout wires tracing up to the stdout exporter, as a program might
+// to see what's traced while developing.
+func TestTraceStdout(t *testing.T) {
+	ctx := context.Background()
+
+	// The exporter writes to os.Stdout by default.
+	var buf bytes.Buffer
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	defer tp.Shutdown(ctx)
+
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), TracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, 10))
+	for _, name := range []string{"POST /", "upload", "filesystem.create", "database.create"} {
+		if !strings.Contains(buf.String(), `"Name":"`+name+`"`) {
+			t.Fatalf("no %s span in %s", name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package kipp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/semaphore"
+)
+
+// uploadRetryAfter is suggested to clients rejected because too many uploads
+// are in progress, which is usually short lived.
+const uploadRetryAfter = 5 * time.Second
+
+// uploadLimiter limits how many uploads are in progress at once, so bursts of
+// them can't exhaust memory, file descriptors or connections to backends.
+type uploadLimiter struct {
+	sem *semaphore.Weighted
+	// wait is how long uploads queue for a slot before they're rejected.
+	wait     time.Duration
+	inFlight prometheus.Gauge
+	rejected prometheus.Counter
+}
+
+func newUploadLimiter(r prometheus.Registerer, n int64, wait time.Duration) (*uploadLimiter, error) {
+	l := &uploadLimiter{
+		sem:  semaphore.NewWeighted(n),
+		wait: wait,
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "uploads_in_flight",
+			Help:      "Number of uploads holding one of the concurrent upload slots.",
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "uploads_rejected_total",
+			Help:      "Number of uploads rejected because the concurrent upload slots were full.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{
+		l.inFlight,
+		l.rejected,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "kipp",
+			Name:      "uploads_max_in_flight",
+			Help:      "Maximum number of uploads in progress at once.",
+		}, func() float64 { return float64(n) }),
+	} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// Acquire acquires a slot for an upload, waiting for one for up to l.wait, or
+// until ctx is done. It reports whether one was acquired, in which case it must
+// be released with Release.
+func (l *uploadLimiter) Acquire(ctx context.Context) bool {
+	ok := l.sem.TryAcquire(1)
+	if !ok && l.wait > 0 {
+		ctx, cancel := context.WithTimeout(ctx, l.wait)
+		defer cancel()
+		ok = l.sem.Acquire(ctx, 1) == nil
+	}
+	if ok {
+		l.inFlight.Inc()
+	}
+	return ok
+}
+
+// Release releases a slot acquired by Acquire.
+func (l *uploadLimiter) Release() {
+	l.inFlight.Dec()
+	l.sem.Release(1)
+}
+
+// tooManyUploads responds to r that there's no slot for it, counting it as
+// rejected unless its client has gone away.
+func (s Server) tooManyUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Err() != nil {
+		return
+	}
+	s.uploads.rejected.Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(uploadRetryAfter.Seconds())))
+	s.httpError(w, r, newError(http.StatusServiceUnavailable, CodeTooManyUploads, "too many uploads in progress"))
+}
@@ -0,0 +1,160 @@
+package kipp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// slowBody is a request body which signals started when it's first read,
+// which is once its upload holds a slot, and then blocks until release is
+// closed.
+type slowBody struct {
+	r       io.Reader
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return b.r.Read(p)
+}
+
+func (b *slowBody) Close() error { return nil }
+
+func TestMaxConcurrentUploads(t *testing.T) {
+	newServer := func(t *testing.T, wait time.Duration) *Server {
+		t.Helper()
+		s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20), MaxConcurrentUploads(2, wait))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	// hold starts an upload to s which holds a slot until the returned
+	// function is called, which returns its response.
+	hold := func(t *testing.T, s *Server) func() *httptest.ResponseRecorder {
+		t.Helper()
+		r := uploadRequest(t, 10)
+		b := &slowBody{r: r.Body, started: make(chan struct{}), release: make(chan struct{})}
+		r.Body = b
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.ServeHTTP(w, r)
+		}()
+		<-b.started
+		return func() *httptest.ResponseRecorder {
+			close(b.release)
+			<-done
+			return w
+		}
+	}
+	check := func(t *testing.T, s *Server, inFlight, rejected float64) {
+		t.Helper()
+		if got := testutil.ToFloat64(s.uploads.inFlight); got != inFlight {
+			t.Fatalf("unexpected uploads in flight; got %v, want %v", got, inFlight)
+		}
+		if got := testutil.ToFloat64(s.uploads.rejected); got != rejected {
+			t.Fatalf("unexpected rejected uploads; got %v, want %v", got, rejected)
+		}
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		s := newServer(t, 0)
+		a, b := hold(t, s), hold(t, s)
+		check(t, s, 2, 0)
+
+		w := upload(t, s, 10)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusServiceUnavailable)
+		}
+		if got := w.Header().Get("Retry-After"); got != "5" {
+			t.Fatalf("unexpected Retry-After; got %q, want %q", got, "5")
+		}
+		check(t, s, 2, 1)
+
+		for _, release := range []func() *httptest.ResponseRecorder{a, b} {
+			if w := release(); w.Code != http.StatusSeeOther {
+				t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+			}
+		}
+		check(t, s, 0, 1)
+		if w := upload(t, s, 10); w.Code != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		s := newServer(t, time.Minute)
+		a, b := hold(t, s), hold(t, s)
+		defer b()
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- upload(t, s, 10) }()
+		select {
+		case w := <-done:
+			t.Fatalf("upload didn't wait for a slot; got %d", w.Code)
+		case <-time.After(50 * time.Millisecond):
+		}
+		a()
+		if w := <-done; w.Code != http.StatusSeeOther {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+		}
+		check(t, s, 1, 0)
+	})
+
+	t.Run("wait timeout", func(t *testing.T) {
+		s := newServer(t, 10*time.Millisecond)
+		defer hold(t, s)()
+		defer hold(t, s)()
+		if w := upload(t, s, 10); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusServiceUnavailable)
+		}
+		check(t, s, 2, 1)
+	})
+
+	// Clients which go away while waiting aren't counted as rejected.
+	t.Run("disconnect", func(t *testing.T) {
+		s := newServer(t, time.Minute)
+		defer hold(t, s)()
+		defer hold(t, s)()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, 10).WithContext(ctx))
+		}()
+		cancel()
+		<-done
+		check(t, s, 2, 0)
+	})
+
+	// Slots are released however uploads end.
+	t.Run("release", func(t *testing.T) {
+		s := newServer(t, 0)
+		for _, v := range []any{"boom", http.ErrAbortHandler} {
+			r := uploadRequest(t, 64<<10)
+			r.Body = &panicBody{r: r.Body, n: 32 << 10, v: v}
+			func() {
+				defer func() { recover() }()
+				s.ServeHTTP(httptest.NewRecorder(), r)
+			}()
+		}
+		r := uploadRequest(t, 64<<10)
+		r.Body = io.NopCloser(io.LimitReader(r.Body, 32<<10))
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		check(t, s, 0, 0)
+	})
+}
@@ -0,0 +1,208 @@
+package kipp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// networkLists are the networks clients may and may not upload from.
+type networkLists struct {
+	allow, deny []netip.Prefix
+}
+
+// uploadNetworks decides which clients may upload by their address. Its lists
+// are those given, and those read from files which are read again when the
+// process is sent SIGHUP, until they're replaced through the admin API.
+type uploadNetworks struct {
+	allow, deny         []netip.Prefix
+	allowFile, denyFile string
+	lists               atomic.Pointer[networkLists]
+	// mu serialises replacing the lists, so a reload can't interleave with
+	// a replacement.
+	mu     sync.Mutex
+	denied prometheus.Counter
+}
+
+func newUploadNetworks(r prometheus.Registerer, allow, deny []netip.Prefix, allowFile, denyFile string) (*uploadNetworks, error) {
+	n := &uploadNetworks{
+		allow:     allow,
+		deny:      deny,
+		allowFile: allowFile,
+		denyFile:  denyFile,
+		denied: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kipp_uploads_denied_total",
+			Help: "Uploads denied because of the network of the client.",
+		}),
+	}
+	if err := r.Register(n.denied); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	if err := n.Reload(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Allowed reports whether the client of the request with ctx may upload.
+// Denied networks win over allowed ones, and if any are allowed, clients must
+// be in one of them. Clients whose address is unknown may only upload if no
+// networks are allowed.
+func (n *uploadNetworks) Allowed(ctx context.Context) bool {
+	addr, ok := ClientIP(ctx)
+	l := n.lists.Load()
+	if ok && containsAddr(l.deny, addr) {
+		return false
+	}
+	return len(l.allow) == 0 || ok && containsAddr(l.allow, addr)
+}
+
+// Lists returns the networks clients may and may not upload from.
+func (n *uploadNetworks) Lists() (allow, deny []netip.Prefix) {
+	l := n.lists.Load()
+	return l.allow, l.deny
+}
+
+// Set replaces the networks clients may and may not upload from, until they
+// are next reloaded.
+func (n *uploadNetworks) Set(allow, deny []netip.Prefix) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.store(allow, deny)
+}
+
+// Reload replaces the networks clients may and may not upload from with
+// those given and those read from the files.
+func (n *uploadNetworks) Reload() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	allow, deny := n.allow, n.deny
+	for _, f := range []struct {
+		name string
+		ps   *[]netip.Prefix
+	}{
+		{n.allowFile, &allow},
+		{n.denyFile, &deny},
+	} {
+		if f.name == "" {
+			continue
+		}
+		ps, err := readNetworks(f.name)
+		if err != nil {
+			return err
+		}
+		*f.ps = slices.Concat(*f.ps, ps)
+	}
+	n.store(allow, deny)
+	return nil
+}
+
+func (n *uploadNetworks) store(allow, deny []netip.Prefix) {
+	n.lists.Store(&networkLists{allow: unmapPrefixes(allow), deny: unmapPrefixes(deny)})
+}
+
+// run reloads the lists when the process is sent SIGHUP, until ctx is done.
+// The lists which were last loaded are kept if they can't be.
+func (n *uploadNetworks) run(ctx context.Context, log *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if err := n.Reload(); err != nil {
+			log.Warn("reload upload networks", "error", err)
+			continue
+		}
+		allow, deny := n.Lists()
+		log.Info("reloaded upload networks", "allow", len(allow), "deny", len(deny))
+	}
+}
+
+// readNetworks reads the named file of networks, one per line, ignoring blank
+// lines and comments starting with #. Addresses are networks of themselves.
+func readNetworks(name string) ([]netip.Prefix, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open networks: %w", err)
+	}
+	defer f.Close()
+	var ps []netip.Prefix
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		v, _, _ := strings.Cut(sc.Text(), "#")
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		p, err := parseNetwork(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		ps = append(ps, p)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read networks: %w", err)
+	}
+	return ps, nil
+}
+
+// parseNetwork parses a network in CIDR notation, or an address as a network
+// of itself.
+func parseNetwork(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(a, a.BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
+// unmapPrefixes returns ps with IPv4-mapped IPv6 networks as the IPv4 networks
+// they map, as addresses of clients are unmapped, and masked.
+func unmapPrefixes(ps []netip.Prefix) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(ps))
+	for _, p := range ps {
+		if a := p.Addr(); a.Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(a.Unmap(), p.Bits()-96)
+		}
+		out = append(out, p.Masked())
+	}
+	return out
+}
+
+// containsAddr reports whether any of ps contains addr.
+func containsAddr(ps []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range ps {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadDenied responds to r, whose client may not upload, with 403
+// Forbidden.
+func (s Server) uploadDenied(w http.ResponseWriter, r *http.Request) {
+	s.uploadNets.denied.Inc()
+	s.logger().LogAttrs(r.Context(), slog.LevelInfo, "upload denied",
+		slog.String("remote", remoteAddr(r)),
+	)
+	s.httpError(w, r, newError(http.StatusForbidden, CodeUploadDenied, http.StatusText(http.StatusForbidden)))
+}
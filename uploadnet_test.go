@@ -0,0 +1,191 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// unreadBody is a request body which records whether it was read.
+type unreadBody struct {
+	io.Reader
+	read bool
+}
+
+func (b *unreadBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.Reader.Read(p)
+}
+
+func (b *unreadBody) Close() error { return nil }
+
+func TestUploadNetworks(t *testing.T) {
+	ctx := context.Background()
+	prefixes := func(ss ...string) []netip.Prefix {
+		var ps []netip.Prefix
+		for _, s := range ss {
+			ps = append(ps, netip.MustParsePrefix(s))
+		}
+		return ps
+	}
+
+	for _, tt := range []struct {
+		name        string
+		allow, deny []netip.Prefix
+		remote      string
+		want        bool
+	}{
+		{"open", nil, nil, "198.51.100.1:1234", true},
+		{"allowed", prefixes("192.0.2.0/24"), nil, "192.0.2.1:1234", true},
+		{"not allowed", prefixes("192.0.2.0/24"), nil, "198.51.100.1:1234", false},
+		{"denied", nil, prefixes("198.51.100.0/24"), "198.51.100.1:1234", false},
+		{"not denied", nil, prefixes("198.51.100.0/24"), "192.0.2.1:1234", true},
+		{"denied wins", prefixes("192.0.2.0/24"), prefixes("192.0.2.128/25"), "192.0.2.129:1234", false},
+		{"ipv6 allowed", prefixes("2001:db8::/32"), nil, "[2001:db8::1]:1234", true},
+		{"ipv6 not allowed", prefixes("2001:db8::/32"), nil, "[2001:db9::1]:1234", false},
+		{"ipv4 not in ipv6", prefixes("2001:db8::/32"), nil, "192.0.2.1:1234", false},
+		{"mapped client", prefixes("192.0.2.0/24"), nil, "[::ffff:192.0.2.1]:1234", true},
+		{"mapped network", prefixes("::ffff:192.0.2.0/120"), nil, "192.0.2.1:1234", true},
+		{"mapped denied", nil, prefixes("::ffff:192.0.2.0/120"), "[::ffff:192.0.2.1]:1234", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := slog.New(slog.NewJSONHandler(&buf, nil))
+			s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), Logger(l), UploadNetworks(tt.allow, tt.deny))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := uploadRequest(t, 10)
+			r.RemoteAddr = tt.remote
+			b := &unreadBody{Reader: r.Body}
+			r.Body = b
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if tt.want {
+				if w.Code != http.StatusSeeOther {
+					t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+				}
+				return
+			}
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if b.read {
+				t.Fatal("body of a denied upload was read")
+			}
+			if got := testutil.ToFloat64(s.uploadNets.denied); got != 1 {
+				t.Fatalf("unexpected denied uploads; got %v, want 1", got)
+			}
+			if rec := find(t, records(t, &buf), "upload denied"); rec["remote"] == nil {
+				t.Fatalf("no remote in %v", rec)
+			}
+		})
+	}
+}
+
+func TestUploadNetworksReload(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "deny")
+	if err := os.WriteFile(name, []byte("# abusers\n198.51.100.1\n\n203.0.113.0/24 # more\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), Admin("secret"),
+		UploadNetworks(nil, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}),
+		UploadNetworkFiles("", name),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadFrom := func(remote string) int {
+		r := uploadRequest(t, 10)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	for remote, want := range map[string]int{
+		"192.0.2.1:1234":    http.StatusForbidden,
+		"198.51.100.1:1234": http.StatusForbidden,
+		"198.51.100.2:1234": http.StatusSeeOther,
+		"203.0.113.9:1234":  http.StatusForbidden,
+	} {
+		if got := uploadFrom(remote); got != want {
+			t.Fatalf("%s: unexpected status; got %d, want %d", remote, got, want)
+		}
+	}
+
+	// Files are read again, and given networks are kept.
+	if err := os.WriteFile(name, []byte("198.51.100.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.uploadNets.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for remote, want := range map[string]int{
+		"192.0.2.1:1234":    http.StatusForbidden,
+		"198.51.100.1:1234": http.StatusSeeOther,
+		"198.51.100.2:1234": http.StatusForbidden,
+	} {
+		if got := uploadFrom(remote); got != want {
+			t.Fatalf("%s: unexpected status; got %d, want %d", remote, got, want)
+		}
+	}
+	// Files which can't be read keep what was last loaded.
+	if err := os.WriteFile(name, []byte("not a network\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.uploadNets.Reload(); err == nil {
+		t.Fatal("invalid file was loaded")
+	}
+	if got := uploadFrom("198.51.100.2:1234"); got != http.StatusForbidden {
+		t.Fatalf("unexpected status; got %d, want %d", got, http.StatusForbidden)
+	}
+
+	// The admin API replaces them.
+	admin := func(method, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/admin/upload-networks", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	w := admin(http.MethodPut, `{"allow":["::ffff:192.0.2.0/120"],"deny":["192.0.2.128/25"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	w = admin(http.MethodGet, "")
+	var got adminNetworks
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got.Allow, ",") != "192.0.2.0/24" || strings.Join(got.Deny, ",") != "192.0.2.128/25" {
+		t.Fatalf("unexpected networks: %+v", got)
+	}
+	for remote, want := range map[string]int{
+		"192.0.2.1:1234":    http.StatusSeeOther,
+		"192.0.2.129:1234":  http.StatusForbidden,
+		"198.51.100.1:1234": http.StatusForbidden,
+	} {
+		if got := uploadFrom(remote); got != want {
+			t.Fatalf("%s: unexpected status; got %d, want %d", remote, got, want)
+		}
+	}
+	for _, body := range []string{`{"allow":["nope"]}`, `{"block":[]}`} {
+		if w := admin(http.MethodPut, body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: unexpected status; got %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package kipp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uhthomas/kipp/database"
+)
+
+const (
+	// userFilesPath is the path users' files are listed at, under which
+	// each is deleted by slug.
+	userFilesPath = "/api/me/files"
+	// adminUsers is the path of the users stored in the database, under
+	// which each is served by ID, with its tokens under that.
+	adminUsers = adminPrefix + "users"
+	// maxUserID is the length of the longest user ID.
+	maxUserID = 64
+	// maxUserListLimit is the most files listed for a user at a time.
+	maxUserListLimit = 1000
+)
+
+var (
+	// errInvalidToken is the error of bearer tokens which are neither API
+	// keys nor users' tokens.
+	errInvalidToken = newError(http.StatusUnauthorized, CodeInvalidAPIKey, "invalid API key")
+	// errNoUser is the error of requests for users' files which aren't
+	// authenticated as a user.
+	errNoUser = newError(http.StatusUnauthorized, CodeUnauthorized, "a user's token is required")
+	// errReadOnlyDelete is the error of users' deletes while the server is
+	// read-only.
+	errReadOnlyDelete = newError(http.StatusServiceUnavailable, CodeReadOnly, "deletes are paused for maintenance, try again later")
+)
+
+// A User is an account configured with the server, rather than stored in the
+// database, which clients authenticate as with any of its tokens, as bearer
+// tokens. Uploads authenticated as a user are owned by it, and aren't verified
+// by the Verifier.
+type User struct {
+	// ID identifies the user as the owner of its entries. It's made of
+	// lowercase letters, digits, hyphens, underscores and dots.
+	ID     string
+	Tokens []string
+}
+
+type userContextKey struct{}
+
+// UserID returns the ID of the user the request with ctx was authenticated as,
+// if it was.
+func UserID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(userContextKey{}).(string)
+	return id, ok
+}
+
+// validUserID reports whether id is a valid ID of a user.
+func validUserID(id string) bool {
+	if id == "" || len(id) > maxUserID {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// checkUsers checks the IDs of users are valid and unique, and that they have
+// tokens.
+func checkUsers(users []User) error {
+	seen := make(map[string]bool, len(users))
+	for _, u := range users {
+		if !validUserID(u.ID) {
+			return fmt.Errorf("invalid user ID %q", u.ID)
+		}
+		if seen[u.ID] {
+			return fmt.Errorf("duplicate user %q", u.ID)
+		}
+		seen[u.ID] = true
+		if len(u.Tokens) == 0 {
+			return fmt.Errorf("user %q has no tokens", u.ID)
+		}
+		for _, t := range u.Tokens {
+			if t == "" {
+				return fmt.Errorf("user %q has an empty token", u.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// hasUsers reports whether clients may authenticate as users.
+func (s Server) hasUsers() bool { return len(s.Users) > 0 || s.UserAccounts }
+
+// hashToken returns the hash of a user's token, as it's stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate returns r with the API key or user it has the token of as a
+// bearer token on its context, if it has one. Tokens which are neither are an
+// error wrapping errInvalidToken, so clients which meant to authenticate
+// aren't treated as anonymous. Authorization is ignored if there are no keys
+// or users.
+func (s Server) authenticate(r *http.Request) (*http.Request, error) {
+	if len(s.APIKeys) == 0 && !s.hasUsers() {
+		return r, nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return r, nil
+	}
+	if k := s.apiKey(token); k != nil {
+		return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)), nil
+	}
+	id, err := s.userByToken(r.Context(), token)
+	switch {
+	case errors.Is(err, database.ErrNoResults):
+		return r, errInvalidToken
+	case err != nil:
+		return r, fmt.Errorf("user by token: %w", err)
+	}
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, id)), nil
+}
+
+// userByToken returns the ID of the user with token, or database.ErrNoResults
+// if there isn't one. Every token of configured users is compared, so how
+// long it takes doesn't say which matched, and the database is only asked if
+// none did.
+func (s Server) userByToken(ctx context.Context, token string) (string, error) {
+	var match string
+	for _, u := range s.Users {
+		for _, t := range u.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				match = u.ID
+			}
+		}
+	}
+	if match != "" {
+		return match, nil
+	}
+	if !s.UserAccounts {
+		return "", database.ErrNoResults
+	}
+	u, err := s.Database.(database.UserStore).UserByToken(ctx, hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	return u.ID, nil
+}
+
+// authError responds to r, which couldn't be authenticated with err.
+func (s Server) authError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errInvalidToken) || errors.Is(err, errNoUser) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="uploads"`)
+	}
+	s.httpError(w, r, err)
+}
+
+// isUserFiles reports whether path is where users' files are listed or
+// deleted, which it only is if there are users.
+func (s Server) isUserFiles(path string) bool {
+	return s.hasUsers() && (path == userFilesPath || strings.HasPrefix(path, userFilesPath+"/"))
+}
+
+// userFile is an entry as it's listed for its owner.
+type userFile struct {
+	Slug      string     `json:"slug"`
+	Name      string     `json:"name"`
+	URL       string     `json:"url"`
+	Size      int64      `json:"size"`
+	Timestamp time.Time  `json:"timestamp"`
+	Lifetime  *time.Time `json:"lifetime,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	// Blocked is the reason the entry was blocked, if it was.
+	Blocked string `json:"blocked,omitempty"`
+}
+
+// userFiles is a page of a user's files, newest first, with the cursor of the
+// next if there is one.
+type userFiles struct {
+	Files []userFile `json:"files"`
+	Next  string     `json:"next,omitempty"`
+}
+
+// serveUserFiles lists the files of the user r is authenticated as, and
+// deletes them by slug.
+func (s Server) serveUserFiles(w http.ResponseWriter, r *http.Request) {
+	r, err := s.authenticate(r)
+	if err != nil {
+		s.authError(w, r, err)
+		return
+	}
+	id, ok := UserID(r.Context())
+	if !ok {
+		s.authError(w, r, errNoUser)
+		return
+	}
+	// What's served is only for the user.
+	w.Header().Set("Cache-Control", "private, no-store")
+	if r.URL.Path == userFilesPath {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		s.listUserFiles(w, r, id)
+		return
+	}
+	slug := strings.TrimPrefix(r.URL.Path, userFilesPath+"/")
+	if slug == "" || strings.Contains(slug, "/") {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	s.deleteUserFile(w, r, id, slug)
+}
+
+// listUserFiles lists a page of the files of the user with id, newest first.
+// Soft deleted and expired entries are skipped, as they're gone as far as
+// their owner is concerned, so pages may be short.
+func (s Server) listUserFiles(w http.ResponseWriter, r *http.Request, id string) {
+	q := r.URL.Query()
+	opts := database.ListOptions{Owner: id, Cursor: q.Get("cursor"), Descending: true}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUserListLimit {
+			s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxUserListLimit)))
+			return
+		}
+		opts.Limit = n
+	}
+	entries, next, err := s.Database.List(r.Context(), opts)
+	switch {
+	case errors.Is(err, database.ErrInvalidCursor):
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid cursor"))
+		return
+	case err != nil:
+		s.httpError(w, r, fmt.Errorf("list: %w", err))
+		return
+	}
+	now := time.Now()
+	res := userFiles{Files: []userFile{}, Next: next}
+	for _, e := range entries {
+		if e.Deleted != nil || (e.Lifetime != nil && !e.Lifetime.After(now)) {
+			continue
+		}
+		res.Files = append(res.Files, userFile{
+			Slug:      e.Slug,
+			Name:      e.Name,
+			URL:       s.PathPrefix + "/" + e.Slug + filepath.Ext(e.Name),
+			Size:      e.Size,
+			Timestamp: e.Timestamp,
+			Lifetime:  e.Lifetime,
+			Tags:      e.Tags,
+			Blocked:   e.BlockReason,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// deleteUserFile deletes the entry with slug, and its files, if it's owned by
+// the user with id. Entries of anyone else are as if they don't exist, and
+// blocked entries are kept, so they still answer for why they were.
+func (s Server) deleteUserFile(w http.ResponseWriter, r *http.Request, id, slug string) {
+	if s.readOnly.On() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(readOnlyRetryAfter.Seconds())))
+		s.httpError(w, r, errReadOnlyDelete)
+		return
+	}
+	// Slugs are often copied with the extension of their URL.
+	slug, _, _ = strings.Cut(slug, ".")
+	e, err := s.Database.Lookup(r.Context(), slug)
+	switch {
+	case errors.Is(err, database.ErrNoResults):
+		s.httpError(w, r, errNotFound)
+		return
+	case err != nil:
+		s.httpError(w, r, fmt.Errorf("lookup: %w", err))
+		return
+	case e.Owner != id, e.Deleted != nil:
+		s.httpError(w, r, errNotFound)
+		return
+	case e.Blocked != nil:
+		s.httpError(w, r, blockedError(e))
+		return
+	}
+	if err := s.Delete(r.Context(), slug); err != nil && !errors.Is(err, database.ErrNoResults) {
+		s.httpError(w, r, fmt.Errorf("delete: %w", err))
+		return
+	}
+	// The entry is already gone, so failing to record it is only logged.
+	if err := s.auditAs(r, userActor(id), "delete", slug, newAdminEntry(e), nil); err != nil {
+		s.logger().ErrorContext(r.Context(), "audit", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminUser is a user as the admin API serves it, with its tokens when it's
+// served on its own.
+type adminUser struct {
+	ID      string           `json:"id"`
+	Created time.Time        `json:"created"`
+	Tokens  []adminUserToken `json:"tokens,omitempty"`
+}
+
+// adminUserToken is a user's token as the admin API serves it. The token
+// itself is only served when it's created, as only its hash is stored.
+type adminUserToken struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Token   string    `json:"token,omitempty"`
+}
+
+// adminUserList is every user stored in the database, oldest first.
+type adminUserList struct {
+	Users []adminUser `json:"users"`
+}
+
+// serveAdminUsers serves the users stored in the database, each by ID, and
+// their tokens.
+func (s Server) serveAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if !s.UserAccounts {
+		s.httpError(w, r, newError(http.StatusNotImplemented, CodeNotImplemented, "user accounts are disabled"))
+		return
+	}
+	us := s.Database.(database.UserStore)
+	if r.URL.Path == adminUsers {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.adminUserList(w, r, us)
+		case http.MethodPost:
+			s.adminCreateUser(w, r, us)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		}
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, adminUsers+"/"), "/")
+	for _, p := range parts {
+		if p == "" {
+			s.httpError(w, r, errNotFound)
+			return
+		}
+	}
+	var allow string
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.adminUser(w, r, us, parts[0])
+			return
+		case http.MethodDelete:
+			s.adminDeleteUser(w, r, us, parts[0])
+			return
+		}
+		allow = "GET, HEAD, DELETE"
+	case len(parts) == 2 && parts[1] == "tokens":
+		if r.Method == http.MethodPost {
+			s.adminCreateUserToken(w, r, us, parts[0])
+			return
+		}
+		allow = "POST"
+	case len(parts) == 3 && parts[1] == "tokens":
+		if r.Method == http.MethodDelete {
+			s.adminRevokeUserToken(w, r, us, parts[0], parts[2])
+			return
+		}
+		allow = "DELETE"
+	default:
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	w.Header().Set("Allow", allow)
+	s.httpError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+}
+
+// adminUserList serves every user stored in the database.
+func (s Server) adminUserList(w http.ResponseWriter, r *http.Request, us database.UserStore) {
+	users, err := us.Users(r.Context())
+	if err != nil {
+		s.adminDatabaseError(w, r, "users", err)
+		return
+	}
+	res := adminUserList{Users: make([]adminUser, len(users))}
+	for i, u := range users {
+		res.Users[i] = adminUser{ID: u.ID, Created: u.Created}
+	}
+	s.adminJSON(w, r, http.StatusOK, res)
+}
+
+// adminCreateUser creates the user with the ID of the body, without tokens.
+func (s Server) adminCreateUser(w http.ResponseWriter, r *http.Request, us database.UserStore) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid body: "+err.Error()))
+		return
+	}
+	if !validUserID(req.ID) {
+		s.httpError(w, r, newError(http.StatusBadRequest, CodeBadRequest, "invalid id"))
+		return
+	}
+	for _, u := range s.Users {
+		if u.ID == req.ID {
+			s.httpError(w, r, newError(http.StatusConflict, CodeConflict, "user is configured"))
+			return
+		}
+	}
+	if _, err := us.User(r.Context(), req.ID); err == nil {
+		s.httpError(w, r, newError(http.StatusConflict, CodeConflict, "user exists"))
+		return
+	} else if !errors.Is(err, database.ErrNoResults) {
+		s.adminDatabaseError(w, r, "user", err)
+		return
+	}
+	u := database.User{ID: req.ID, Created: time.Now().UTC()}
+	res := adminUser{ID: u.ID, Created: u.Created}
+	if err := s.audit(r, "create user", u.ID, nil, res); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	if err := us.CreateUser(r.Context(), u); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			s.httpError(w, r, newError(http.StatusConflict, CodeConflict, "user exists"))
+			return
+		}
+		s.adminDatabaseError(w, r, "create user", err)
+		return
+	}
+	s.adminJSON(w, r, http.StatusCreated, res)
+}
+
+// adminUser serves the user with id, and its tokens.
+func (s Server) adminUser(w http.ResponseWriter, r *http.Request, us database.UserStore, id string) {
+	u, err := us.User(r.Context(), id)
+	if err != nil {
+		s.adminDatabaseError(w, r, "user", err)
+		return
+	}
+	tokens, err := us.UserTokens(r.Context(), id)
+	if err != nil {
+		s.adminDatabaseError(w, r, "user tokens", err)
+		return
+	}
+	res := adminUser{ID: u.ID, Created: u.Created, Tokens: make([]adminUserToken, len(tokens))}
+	for i, t := range tokens {
+		res.Tokens[i] = adminUserToken{ID: t.ID, Created: t.Created}
+	}
+	s.adminJSON(w, r, http.StatusOK, res)
+}
+
+// adminDeleteUser deletes the user with id and its tokens. Its entries are
+// kept, still owned by it.
+func (s Server) adminDeleteUser(w http.ResponseWriter, r *http.Request, us database.UserStore, id string) {
+	u, err := us.User(r.Context(), id)
+	if err != nil {
+		s.adminDatabaseError(w, r, "user", err)
+		return
+	}
+	if err := s.audit(r, "delete user", id, adminUser{ID: u.ID, Created: u.Created}, nil); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	if err := us.DeleteUser(r.Context(), id); err != nil {
+		s.adminDatabaseError(w, r, "delete user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminCreateUserToken creates a token for the user with id, which is served
+// this once.
+func (s Server) adminCreateUserToken(w http.ResponseWriter, r *http.Request, us database.UserStore, id string) {
+	if _, err := us.User(r.Context(), id); err != nil {
+		s.adminDatabaseError(w, r, "user", err)
+		return
+	}
+	var b [32 + 6]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		s.adminDatabaseError(w, r, "generate token", err)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(b[:32])
+	t := database.UserToken{
+		ID:      base64.RawURLEncoding.EncodeToString(b[32:]),
+		User:    id,
+		Hash:    hashToken(token),
+		Created: time.Now().UTC(),
+	}
+	res := adminUserToken{ID: t.ID, Created: t.Created}
+	if err := s.audit(r, "create user token", id, nil, res); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	if err := us.AddUserToken(r.Context(), t); err != nil {
+		s.adminDatabaseError(w, r, "add user token", err)
+		return
+	}
+	res.Token = token
+	s.adminJSON(w, r, http.StatusCreated, res)
+}
+
+// adminRevokeUserToken removes the token of the user with id whose ID is
+// token.
+func (s Server) adminRevokeUserToken(w http.ResponseWriter, r *http.Request, us database.UserStore, id, token string) {
+	tokens, err := us.UserTokens(r.Context(), id)
+	if err != nil {
+		s.adminDatabaseError(w, r, "user tokens", err)
+		return
+	}
+	var before *adminUserToken
+	for _, t := range tokens {
+		if t.ID == token {
+			before = &adminUserToken{ID: t.ID, Created: t.Created}
+		}
+	}
+	if before == nil {
+		s.httpError(w, r, errNotFound)
+		return
+	}
+	if err := s.audit(r, "revoke user token", id, before, nil); err != nil {
+		s.adminDatabaseError(w, r, "audit", err)
+		return
+	}
+	if err := us.RemoveUserToken(r.Context(), id, token); err != nil {
+		s.adminDatabaseError(w, r, "remove user token", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,273 @@
+package kipp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// userUpload uploads a file of n bytes to s with token as a bearer token, if
+// it's not empty, returning its slug.
+func userUpload(t *testing.T, s *Server, token string, n int) string {
+	t.Helper()
+	r := uploadRequest(t, n)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	return strings.TrimSuffix(path.Base(w.Header().Get("Location")), ".txt")
+}
+
+// userDo makes a request of s with token as a bearer token, decoding the
+// response into v if it's not nil.
+func userDo(t *testing.T, s *Server, method, target, token string, code int, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != code {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, code, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("unmarshal %q: %v", w.Body, err)
+		}
+	}
+	return w
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx,
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<10),
+		Admin("secret"),
+		Users(User{ID: "alice", Tokens: []string{"alice-1", "alice-2"}}, User{ID: "bob", Tokens: []string{"bob-1"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anonymous := userUpload(t, s, "", 10)
+	a1 := userUpload(t, s, "alice-1", 10)
+	a2 := userUpload(t, s, "alice-2", 10)
+	b1 := userUpload(t, s, "bob-1", 10)
+	for slug, owner := range map[string]string{anonymous: "", a1: "alice", a2: "alice", b1: "bob"} {
+		e, err := s.Database.Lookup(ctx, slug)
+		if err != nil {
+			t.Fatalf("lookup %q: %v", slug, err)
+		}
+		if e.Owner != owner {
+			t.Fatalf("unexpected owner of %q; got %q, want %q", slug, e.Owner, owner)
+		}
+	}
+
+	// Invalid tokens are rejected rather than treated as anonymous.
+	r := uploadRequest(t, 10)
+	r.Header.Set("Authorization", "Bearer nope")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got == "" {
+		t.Fatal("WWW-Authenticate is not set")
+	}
+
+	userDo(t, s, http.MethodGet, userFilesPath, "", http.StatusUnauthorized, nil)
+	userDo(t, s, http.MethodGet, userFilesPath, "nope", http.StatusUnauthorized, nil)
+
+	// Files are listed newest first, a page at a time.
+	var page userFiles
+	w = userDo(t, s, http.MethodGet, userFilesPath+"?limit=1", "alice-2", http.StatusOK, &page)
+	if got := w.Header().Get("Cache-Control"); got != "private, no-store" {
+		t.Fatalf("unexpected Cache-Control; got %q, want %q", got, "private, no-store")
+	}
+	if len(page.Files) != 1 || page.Files[0].Slug != a2 || page.Next == "" {
+		t.Fatalf("unexpected page; got %+v, want %q and a cursor", page, a2)
+	}
+	if want := "/" + a2 + ".txt"; page.Files[0].URL != want {
+		t.Fatalf("unexpected URL; got %q, want %q", page.Files[0].URL, want)
+	}
+	userDo(t, s, http.MethodGet, userFilesPath+"?limit=1&cursor="+page.Next, "alice-1", http.StatusOK, &page)
+	if len(page.Files) != 1 || page.Files[0].Slug != a1 {
+		t.Fatalf("unexpected page; got %+v, want %q", page, a1)
+	}
+	userDo(t, s, http.MethodGet, userFilesPath+"?limit=0", "alice-1", http.StatusBadRequest, nil)
+	userDo(t, s, http.MethodGet, userFilesPath+"?cursor=nope", "alice-1", http.StatusBadRequest, nil)
+	userDo(t, s, http.MethodPost, userFilesPath, "alice-1", http.StatusMethodNotAllowed, nil)
+
+	// Files of others are as if they don't exist.
+	userDo(t, s, http.MethodDelete, userFilesPath+"/"+b1, "alice-1", http.StatusNotFound, nil)
+	userDo(t, s, http.MethodDelete, userFilesPath+"/"+anonymous, "alice-1", http.StatusNotFound, nil)
+	userDo(t, s, http.MethodDelete, userFilesPath+"/"+a1+".txt", "alice-2", http.StatusNoContent, nil)
+	userDo(t, s, http.MethodDelete, userFilesPath+"/"+a1, "alice-2", http.StatusNotFound, nil)
+	userDo(t, s, http.MethodGet, "/"+a1, "", http.StatusNotFound, nil)
+	var log adminAuditLog
+	adminDo(s)(t, http.MethodGet, adminAudit+"?actor=user:alice", "", http.StatusOK, &log)
+	if len(log.Records) != 1 || log.Records[0].Action != "delete" || log.Records[0].Target != a1 || log.Records[0].Before == nil {
+		t.Fatalf("unexpected audit log; got %+v, want the delete of %q", log.Records, a1)
+	}
+	userDo(t, s, http.MethodGet, userFilesPath, "alice-1", http.StatusOK, &page)
+	if len(page.Files) != 1 || page.Files[0].Slug != a2 {
+		t.Fatalf("unexpected files; got %+v, want %q", page.Files, a2)
+	}
+
+	// Owners are served by the admin API, but not publicly.
+	var entry adminEntry
+	adminDo(s)(t, http.MethodGet, adminFiles+"/"+b1, "", http.StatusOK, &entry)
+	if entry.Owner != "bob" {
+		t.Fatalf("unexpected owner; got %q, want %q", entry.Owner, "bob")
+	}
+	var list adminList
+	adminDo(s)(t, http.MethodGet, adminFiles+"?owner=bob", "", http.StatusOK, &list)
+	if len(list.Files) != 1 || list.Files[0].Slug != b1 {
+		t.Fatalf("unexpected files; got %+v, want %q", list.Files, b1)
+	}
+	if w := userDo(t, s, http.MethodGet, "/"+b1, "", http.StatusOK, nil); strings.Contains(w.Body.String(), "bob") {
+		t.Fatalf("owner is served: %s", w.Body)
+	}
+
+	// Deletes are paused while read-only.
+	s.SetReadOnly(true)
+	w = userDo(t, s, http.MethodDelete, userFilesPath+"/"+b1, "bob-1", http.StatusServiceUnavailable, nil)
+	if got := w.Header().Get("Retry-After"); got == "" {
+		t.Fatal("Retry-After is not set")
+	}
+	s.SetReadOnly(false)
+	userDo(t, s, http.MethodDelete, userFilesPath+"/"+b1, "bob-1", http.StatusNoContent, nil)
+
+	// Stored users are only managed with user accounts.
+	adminDo(s)(t, http.MethodGet, adminUsers, "", http.StatusNotImplemented, nil)
+}
+
+func TestUsersNotRouted(t *testing.T) {
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.isUserFiles(userFilesPath) {
+		t.Fatal("users' files are routed without users")
+	}
+}
+
+func TestUsersInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		users []User
+	}{
+		{"invalid ID", []User{{ID: "Alice", Tokens: []string{"a"}}}},
+		{"duplicate", []User{{ID: "alice", Tokens: []string{"a"}}, {ID: "alice", Tokens: []string{"b"}}}},
+		{"no tokens", []User{{ID: "alice"}}},
+		{"empty token", []User{{ID: "alice", Tokens: []string{""}}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Users(tt.users...)); err == nil {
+				t.Fatal("New succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestUserAccounts(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx,
+		DB(memory.New()),
+		FS(memfs.New()),
+		Limit(1<<10),
+		Admin("secret"),
+		Users(User{ID: "bob", Tokens: []string{"bob-1"}}),
+		UserAccounts(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := adminDo(s)
+
+	do(t, http.MethodPost, adminUsers, `{"id": "Alice"}`, http.StatusBadRequest, nil)
+	do(t, http.MethodPost, adminUsers, `{"id": "bob"}`, http.StatusConflict, nil)
+	var u adminUser
+	do(t, http.MethodPost, adminUsers, `{"id": "alice"}`, http.StatusCreated, &u)
+	if u.ID != "alice" || u.Created.IsZero() {
+		t.Fatalf("unexpected user; got %+v", u)
+	}
+	do(t, http.MethodPost, adminUsers, `{"id": "alice"}`, http.StatusConflict, nil)
+	var list adminUserList
+	do(t, http.MethodGet, adminUsers, "", http.StatusOK, &list)
+	if len(list.Users) != 1 || list.Users[0].ID != "alice" {
+		t.Fatalf("unexpected users; got %+v, want alice", list.Users)
+	}
+
+	var token adminUserToken
+	do(t, http.MethodPost, adminUsers+"/alice/tokens", "", http.StatusCreated, &token)
+	if token.ID == "" || token.Token == "" {
+		t.Fatalf("unexpected token; got %+v", token)
+	}
+	do(t, http.MethodPost, adminUsers+"/nope/tokens", "", http.StatusNotFound, nil)
+	do(t, http.MethodGet, adminUsers+"/alice", "", http.StatusOK, &u)
+	if len(u.Tokens) != 1 || u.Tokens[0].ID != token.ID || u.Tokens[0].Token != "" {
+		t.Fatalf("unexpected tokens; got %+v, want %q without the token", u.Tokens, token.ID)
+	}
+
+	slug := userUpload(t, s, token.Token, 10)
+	e, err := s.Database.Lookup(ctx, slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Owner != "alice" {
+		t.Fatalf("unexpected owner; got %q, want %q", e.Owner, "alice")
+	}
+	var page userFiles
+	userDo(t, s, http.MethodGet, userFilesPath, token.Token, http.StatusOK, &page)
+	if len(page.Files) != 1 || page.Files[0].Slug != slug {
+		t.Fatalf("unexpected files; got %+v, want %q", page.Files, slug)
+	}
+	// Configured users still authenticate.
+	userDo(t, s, http.MethodGet, userFilesPath, "bob-1", http.StatusOK, nil)
+
+	do(t, http.MethodDelete, adminUsers+"/alice/tokens/nope", "", http.StatusNotFound, nil)
+	do(t, http.MethodDelete, adminUsers+"/alice/tokens/"+token.ID, "", http.StatusNoContent, nil)
+	userDo(t, s, http.MethodGet, userFilesPath, token.Token, http.StatusUnauthorized, nil)
+
+	do(t, http.MethodDelete, adminUsers+"/alice", "", http.StatusNoContent, nil)
+	do(t, http.MethodGet, adminUsers+"/alice", "", http.StatusNotFound, nil)
+	do(t, http.MethodDelete, adminUsers+"/alice", "", http.StatusNotFound, nil)
+
+	var audit adminAuditLog
+	do(t, http.MethodGet, adminAudit, "", http.StatusOK, &audit)
+	var actions []string
+	for _, r := range audit.Records {
+		actions = append(actions, r.Action)
+		// Tokens are never audited.
+		if strings.Contains(string(r.After), token.Token) {
+			t.Fatalf("token is audited: %s", r.After)
+		}
+	}
+	if got, want := strings.Join(actions, ","), "delete user,revoke user token,create user token,create user"; got != want {
+		t.Fatalf("unexpected actions; got %q, want %q", got, want)
+	}
+}
+
+func TestUserAccountsUnsupported(t *testing.T) {
+	// Hide the database's user methods.
+	db := struct{ database.Database }{memory.New()}
+	if _, err := New(context.Background(), DB(db), FS(memfs.New()), UserAccounts(), DatabaseMetrics(false)); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,56 @@
+package kipp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// maxVerifyField is the size of the largest form field read for the
+// Verifier, which is plenty for the tokens of CAPTCHA services.
+const maxVerifyField = 8 << 10
+
+// Verifier verifies uploads were made by humans, such as by checking the
+// token of a CAPTCHA. It's called before the file of an upload is read, with
+// the form fields which precede it as the PostForm of r, so it mustn't read
+// the body of r. Its errors are responded with as 403 Forbidden, so their
+// messages are seen by clients.
+type Verifier interface {
+	Verify(ctx context.Context, r *http.Request) error
+}
+
+// readVerifyField adds p, a form field preceding the file of an upload, to
+// fields for the Verifier.
+func readVerifyField(fields url.Values, p *multipart.Part) error {
+	b, err := io.ReadAll(io.LimitReader(p, maxVerifyField+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > maxVerifyField {
+		return errors.New("field " + p.FormName() + " is too large")
+	}
+	fields.Add(p.FormName(), string(b))
+	return nil
+}
+
+// verifyUpload verifies the upload r is of, whose fields preceding its file
+// are fields, unless it's authenticated with an API key or as a user. It
+// responds to r and reports false if it can't be verified.
+func (s Server) verifyUpload(w http.ResponseWriter, r *http.Request, fields url.Values) bool {
+	if _, ok := APIKeyName(r.Context()); ok || s.Verifier == nil {
+		return true
+	}
+	if _, ok := UserID(r.Context()); ok {
+		return true
+	}
+	// The body is being read, so the form can't be parsed from it.
+	r.Form, r.PostForm = fields, fields
+	if err := s.Verifier.Verify(r.Context(), r); err != nil {
+		s.httpError(w, r, &Error{Status: http.StatusForbidden, Code: CodeVerificationFailed, Message: err.Error(), Err: err})
+		return false
+	}
+	return true
+}
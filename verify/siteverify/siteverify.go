@@ -0,0 +1,182 @@
+// Package siteverify verifies uploads were made by humans with CAPTCHA
+// services which implement the siteverify protocol, such as Cloudflare
+// Turnstile and hCaptcha.
+package siteverify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uhthomas/kipp"
+)
+
+// The endpoints tokens of well known services are verified with.
+const (
+	TurnstileURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	HCaptchaURL  = "https://api.hcaptcha.com/siteverify"
+)
+
+const (
+	// DefaultField is the form field tokens are read from if Field isn't
+	// given, which Turnstile's widget adds to forms.
+	DefaultField = "cf-turnstile-response"
+	// defaultTimeout is how long verifying a token may take if Timeout
+	// isn't given.
+	defaultTimeout = 5 * time.Second
+	// defaultReplayWindow is how long tokens are remembered for if
+	// ReplayWindow isn't given, which is how long Turnstile's are valid.
+	defaultReplayWindow = 5 * time.Minute
+)
+
+var (
+	// ErrMissingToken is returned for uploads without a token.
+	ErrMissingToken = errors.New("missing verification token")
+	// ErrReplayed is returned for tokens which were recently verified.
+	ErrReplayed = errors.New("verification token was already used")
+	// ErrFailed is returned for tokens the service rejects.
+	ErrFailed = errors.New("verification failed")
+	// ErrUnavailable is returned when the service can't be reached, or
+	// responds with something other than a verdict.
+	ErrUnavailable = errors.New("verification is unavailable")
+)
+
+// Verifier verifies the token of a form field of uploads with a siteverify
+// endpoint. It implements kipp.Verifier.
+type Verifier struct {
+	url, secret string
+	field       string
+	timeout     time.Duration
+	client      *http.Client
+
+	// Tokens are remembered for between window and twice it. Those which
+	// were seen are kept in cur until it's rotated into prev, once it's
+	// been window since it was last rotated.
+	window    time.Duration
+	mu        sync.Mutex
+	cur, prev map[string]struct{}
+	rotated   time.Time
+}
+
+var _ kipp.Verifier = (*Verifier)(nil)
+
+// An Option configures a Verifier.
+type Option func(v *Verifier)
+
+// Field reads tokens from the named form field, rather than DefaultField.
+func Field(name string) Option {
+	return func(v *Verifier) { v.field = name }
+}
+
+// Timeout gives the endpoint d to verify a token, rather than five seconds.
+func Timeout(d time.Duration) Option {
+	return func(v *Verifier) { v.timeout = d }
+}
+
+// Client verifies tokens with c, rather than http.DefaultClient.
+func Client(c *http.Client) Option {
+	return func(v *Verifier) { v.client = c }
+}
+
+// ReplayWindow remembers tokens for at least d, rather than five minutes, so
+// they can't be used again within it. It should be at least as long as
+// tokens are valid for.
+func ReplayWindow(d time.Duration) Option {
+	return func(v *Verifier) { v.window = d }
+}
+
+// New returns a Verifier which verifies tokens with the endpoint at rawURL,
+// such as TurnstileURL, with secret.
+func New(rawURL, secret string, opts ...Option) *Verifier {
+	v := &Verifier{
+		url:     rawURL,
+		secret:  secret,
+		field:   DefaultField,
+		timeout: defaultTimeout,
+		client:  http.DefaultClient,
+		window:  defaultReplayWindow,
+		cur:     make(map[string]struct{}),
+		prev:    make(map[string]struct{}),
+		rotated: time.Now(),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify verifies the token of the form field of r. Tokens are only
+// verified once, whether or not they pass.
+func (v *Verifier) Verify(ctx context.Context, r *http.Request) error {
+	token := r.PostFormValue(v.field)
+	if token == "" {
+		return ErrMissingToken
+	}
+	if v.seen(token, time.Now()) {
+		return ErrReplayed
+	}
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if ip, ok := kipp.ClientIP(ctx); ok {
+		form.Set("remoteip", ip.String())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrUnavailable, res.Status)
+	}
+	var out struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&out); err != nil {
+		return fmt.Errorf("%w: decode: %w", ErrUnavailable, err)
+	}
+	if !out.Success {
+		if len(out.ErrorCodes) > 0 {
+			return fmt.Errorf("%w: %s", ErrFailed, strings.Join(out.ErrorCodes, ", "))
+		}
+		return ErrFailed
+	}
+	return nil
+}
+
+// seen reports whether token was seen recently, remembering it if it wasn't.
+func (v *Verifier) seen(token string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if d := now.Sub(v.rotated); d >= v.window {
+		v.prev, v.cur = v.cur, make(map[string]struct{})
+		// Tokens in prev are older than the window if it's been more
+		// than twice it.
+		if d >= 2*v.window {
+			clear(v.prev)
+		}
+		v.rotated = now
+	}
+	if _, ok := v.cur[token]; ok {
+		return true
+	}
+	if _, ok := v.prev[token]; ok {
+		return true
+	}
+	v.cur[token] = struct{}{}
+	return false
+}
@@ -0,0 +1,155 @@
+package siteverify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uhthomas/kipp"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// endpoint is a siteverify endpoint which accepts the token "human" and
+// records the forms it's sent.
+type endpoint struct {
+	mu    sync.Mutex
+	forms []map[string]string
+	delay time.Duration
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(e.delay)
+	e.mu.Lock()
+	e.forms = append(e.forms, map[string]string{
+		"secret":   r.PostFormValue("secret"),
+		"response": r.PostFormValue("response"),
+		"remoteip": r.PostFormValue("remoteip"),
+	})
+	e.mu.Unlock()
+	res := map[string]any{"success": r.PostFormValue("response") == "human"}
+	if res["success"] == false {
+		res["error-codes"] = []string{"invalid-input-response"}
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// upload returns an upload of a file preceded by fields.
+func upload(t *testing.T, fields map[string]string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("some data"))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.RemoteAddr = "192.0.2.1:1234"
+	return r
+}
+
+func TestVerifier(t *testing.T) {
+	ep := &endpoint{}
+	hs := httptest.NewServer(ep)
+	defer hs.Close()
+
+	s, err := kipp.New(context.Background(),
+		kipp.DB(memory.New()),
+		kipp.FS(memfs.New()),
+		kipp.Limit(1<<20),
+		kipp.APIKeys(kipp.APIKey{Name: "ci", Key: "ci-key"}),
+		kipp.VerifyUploads(New(hs.URL, "secret", Field("token"))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(upload(t, map[string]string{"token": "human"})); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	if got, want := ep.forms[0], (map[string]string{"secret": "secret", "response": "human", "remoteip": "192.0.2.1"}); !maps.Equal(got, want) {
+		t.Fatalf("unexpected form; got %v, want %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		fields map[string]string
+		want   error
+	}{
+		{"missing", nil, ErrMissingToken},
+		{"replayed", map[string]string{"token": "human"}, ErrReplayed},
+		{"rejected", map[string]string{"token": "robot"}, ErrFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(upload(t, tt.fields))
+			if w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte(tt.want.Error())) {
+				t.Fatalf("unexpected response; got %d %q, want %d %q", w.Code, w.Body, http.StatusForbidden, tt.want)
+			}
+		})
+	}
+	// Replays aren't sent to the endpoint.
+	if len(ep.forms) != 2 {
+		t.Fatalf("unexpected verifications; got %d, want 2", len(ep.forms))
+	}
+
+	// Uploads authenticated with API keys aren't verified, but those with
+	// bearer tokens which aren't keys are rejected.
+	r := upload(t, nil)
+	r.Header.Set("Authorization", "Bearer ci-key")
+	if w := serve(r); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	r = upload(t, map[string]string{"token": "human"})
+	r.Header.Set("Authorization", "Bearer guess")
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestVerifierUnavailable(t *testing.T) {
+	hs := httptest.NewServer(&endpoint{delay: 100 * time.Millisecond})
+	defer hs.Close()
+	v := New(hs.URL, "secret", Timeout(10*time.Millisecond))
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.PostForm = map[string][]string{DefaultField: {"human"}}
+	if err := v.Verify(context.Background(), r); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("unexpected error; got %v, want %v", err, ErrUnavailable)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	v := New("", "", ReplayWindow(time.Minute))
+	now := time.Now()
+	if v.seen("a", now) {
+		t.Fatal("unseen token was seen")
+	}
+	for _, d := range []time.Duration{0, 30 * time.Second, 61 * time.Second, 89 * time.Second} {
+		if !v.seen("a", now.Add(d)) {
+			t.Fatalf("token wasn't remembered after %v", d)
+		}
+	}
+	if v.seen("a", now.Add(3*time.Minute)) {
+		t.Fatal("token was remembered after twice the window")
+	}
+}
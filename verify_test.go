@@ -0,0 +1,109 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// verifierFunc implements Verifier.
+type verifierFunc func(ctx context.Context, r *http.Request) error
+
+func (f verifierFunc) Verify(ctx context.Context, r *http.Request) error { return f(ctx, r) }
+
+func TestVerifyUploads(t *testing.T) {
+	const size = 64 << 10
+	// read is how much of the body of the upload was read when it was
+	// verified.
+	var (
+		body *countingBody
+		read int64
+	)
+	v := verifierFunc(func(ctx context.Context, r *http.Request) error {
+		read = body.n
+		if r.PostFormValue("token") != "human" {
+			return errors.New("not a human")
+		}
+		return nil
+	})
+	s, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20),
+		APIKeys(APIKey{Name: "ci", Key: "ci-key"}),
+		VerifyUploads(v),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func(key string, fields ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for i := 0; i < len(fields); i += 2 {
+			mw.WriteField(fields[i], fields[i+1])
+		}
+		fw, err := mw.CreateFormFile("file", "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, strings.Repeat("a", size))
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		body = &countingBody{ReadCloser: io.NopCloser(&buf)}
+		r.Body = body
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		read = -1
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := upload("", "tags", "screenshot", "token", "human")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	// Only what the multipart reader buffers of the file was read.
+	if read < 0 || read >= size/2 {
+		t.Fatalf("file was read before it was verified; read %d bytes", read)
+	}
+
+	w = upload("", "token", "robot")
+	if w.Code != http.StatusForbidden || !strings.HasPrefix(w.Body.String(), "not a human") {
+		t.Fatalf("unexpected response; got %d %q", w.Code, w.Body)
+	}
+	if w := upload("", "token", strings.Repeat("a", maxVerifyField+1)); w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Uploads authenticated with API keys aren't verified.
+	if w := upload("ci-key"); w.Code != http.StatusSeeOther || read != -1 {
+		t.Fatalf("unexpected response; got %d %q, verified %v", w.Code, w.Body, read != -1)
+	}
+	if w := upload("guess", "token", "human"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Without keys, bearer tokens are ignored.
+	s, err = New(context.Background(), DB(memory.New()), FS(memfs.New()), Limit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := upload("guess"); w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if _, err := New(context.Background(), DB(memory.New()), FS(memfs.New()), APIKeys(APIKey{Name: "ci"})); err == nil {
+		t.Fatal("key without a key was accepted")
+	}
+}
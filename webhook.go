@@ -0,0 +1,192 @@
+package kipp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The headers webhook deliveries are signed with. The signature is the hex
+// HMAC-SHA256, keyed with the secret, of the timestamp, a full stop and the
+// body, so receivers can reject deliveries which are replayed later.
+const (
+	WebhookSignatureHeader = "X-Kipp-Signature"
+	WebhookTimestampHeader = "X-Kipp-Timestamp"
+)
+
+const (
+	// webhookQueue is how many deliveries to each webhook may wait to be
+	// sent before more are dropped.
+	webhookQueue = 1024
+	// webhookAttempts is how many times a delivery is attempted before
+	// it's given up on.
+	webhookAttempts = 5
+	// webhookBackoff is how long the first retry of a delivery waits,
+	// doubling for each after it up to webhookMaxBackoff.
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 30 * time.Second
+	// webhookTimeout is how long each attempt may take.
+	webhookTimeout = 10 * time.Second
+)
+
+// webhooks delivers events to webhooks in the background, so a slow or dead
+// one never holds up the request or background work which caused them. Each
+// has its own queue, so they can't hold each other up either. Events are
+// dropped when a queue is full, and those still queued when the server shuts
+// down are never delivered.
+type webhooks struct {
+	hooks   []*webhook
+	secret  []byte
+	client  *http.Client
+	backoff time.Duration
+	logger  *slog.Logger
+
+	delivered, failures, dropped prometheus.Counter
+}
+
+// webhook is a URL deliveries are made to, and the queue of their bodies.
+type webhook struct {
+	url   string
+	queue chan []byte
+}
+
+func newWebhooks(r prometheus.Registerer, urls []string, secret string, logger *slog.Logger) (*webhooks, error) {
+	w := &webhooks{
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: webhookBackoff,
+		logger:  logger,
+		delivered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "webhook_deliveries_total",
+			Help:      "Number of events delivered to webhooks.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "webhook_failures_total",
+			Help:      "Number of events which failed to be delivered to webhooks after every attempt.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kipp",
+			Name:      "webhook_dropped_total",
+			Help:      "Number of events dropped because the queue of a webhook was full.",
+		}),
+	}
+	for _, u := range urls {
+		w.hooks = append(w.hooks, &webhook{url: u, queue: make(chan []byte, webhookQueue)})
+	}
+	for _, c := range []prometheus.Collector{w.delivered, w.failures, w.dropped} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// Notify queues ev to be delivered to every webhook.
+func (w *webhooks) Notify(ev Event) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		w.logger.Error("marshal webhook payload", "slug", ev.Slug, "error", err)
+		return
+	}
+	for _, h := range w.hooks {
+		select {
+		case h.queue <- b:
+		default:
+			w.dropped.Inc()
+			w.logger.Warn("webhook queue is full", "url", h.url, "type", ev.Type, "slug", ev.Slug)
+		}
+	}
+}
+
+// run delivers queued events until ctx is done.
+func (w *webhooks) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, h := range w.hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case b := <-h.queue:
+					w.deliver(ctx, h.url, b)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver delivers b to url, retrying with exponential backoff until it's
+// accepted, it's been attempted webhookAttempts times or ctx is done.
+func (w *webhooks) deliver(ctx context.Context, url string, b []byte) {
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(ctx, url, b)
+		if err == nil {
+			w.delivered.Inc()
+			return
+		}
+		if attempt == webhookAttempts || ctx.Err() != nil {
+			w.failures.Inc()
+			w.logger.Warn("deliver webhook", "url", url, "attempts", attempt, "error", err)
+			return
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+		backoff = min(2*backoff, webhookMaxBackoff)
+	}
+}
+
+// post posts b to url once, signed with w.secret. Responses other than 2xx
+// are errors.
+func (w *webhooks) post(ctx context.Context, url string, b []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(w.secret, ts, b))
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// The connection can only be reused once the body is read.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of the timestamp ts and body b,
+// keyed with secret.
+func signWebhook(secret []byte, ts string, b []byte) string {
+	m := hmac.New(sha256.New, secret)
+	io.WriteString(m, ts)
+	io.WriteString(m, ".")
+	m.Write(b)
+	return hex.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,181 @@
+package kipp
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uhthomas/kipp/database/databasetest"
+	"github.com/uhthomas/kipp/database/memory"
+	memfs "github.com/uhthomas/kipp/filesystem/memory"
+)
+
+// receiver is a webhook which checks the signatures of deliveries and sends
+// their payloads to events.
+func receiver(t *testing.T, secret string, events chan<- map[string]any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ts := r.Header.Get(WebhookTimestampHeader)
+		want := "sha256=" + signWebhook([]byte(secret), ts, b)
+		if got := r.Header.Get(WebhookSignatureHeader); ts == "" || !hmac.Equal([]byte(got), []byte(want)) {
+			t.Errorf("unexpected signature; got %q, want %q", got, want)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("unexpected content type; got %q", got)
+		}
+		var m map[string]any
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Error(err)
+		}
+		events <- m
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan map[string]any, 10)
+	hs := httptest.NewServer(receiver(t, "secret", events))
+	defer hs.Close()
+
+	s, err := New(ctx, DB(memory.New()), FS(memfs.New()), Limit(1<<20), Lifetime(time.Hour), Webhooks("secret", hs.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func(typ EventType) map[string]any {
+		t.Helper()
+		select {
+		case m := <-events:
+			if m["type"] != string(typ) {
+				t.Fatalf("unexpected event; got %v, want %s", m["type"], typ)
+			}
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s event wasn't delivered", typ)
+			return nil
+		}
+	}
+
+	w := upload(t, s, 5)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status; got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	slug := strings.TrimSuffix(path.Base(w.Header().Get("Location")), ".txt")
+	m := next(EventUpload)
+	for _, k := range []string{"time", "uploaded", "expires", "sum"} {
+		if v, _ := m[k].(string); v == "" {
+			t.Fatalf("event is missing %s: %v", k, m)
+		}
+	}
+	if m["version"] != float64(EventVersion) || m["slug"] != slug || m["name"] != "file.txt" || m["size"] != float64(5) {
+		t.Fatalf("unexpected event: %v", m)
+	}
+
+	if err := s.Delete(ctx, slug); err != nil {
+		t.Fatal(err)
+	}
+	if m := next(EventDelete); m["slug"] != slug {
+		t.Fatalf("unexpected event: %v", m)
+	}
+
+	// Entries which expire are reaped.
+	s.Lifetime = time.Nanosecond
+	slug = strings.TrimSuffix(path.Base(upload(t, s, 5).Header().Get("Location")), ".txt")
+	next(EventUpload)
+	time.Sleep(time.Millisecond)
+	if n, _, err := s.Reap(ctx); err != nil || n != 1 {
+		t.Fatalf("unexpected reap; got %d, %v", n, err)
+	}
+	if m := next(EventExpire); m["slug"] != slug {
+		t.Fatalf("unexpected event: %v", m)
+	}
+
+	if _, err := New(ctx, DB(memory.New()), FS(memfs.New()), Webhooks("", hs.URL)); err == nil {
+		t.Fatal("webhooks without a secret were accepted")
+	}
+}
+
+func TestWebhooksRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The healthy webhook accepts the second attempt, and the dead one
+	// never does.
+	var attempts atomic.Int64
+	events := make(chan map[string]any, 10)
+	ok := receiver(t, "secret", events)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		ok.ServeHTTP(w, r)
+	}))
+	defer healthy.Close()
+	var dead atomic.Int64
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dead.Add(1)
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer unhealthy.Close()
+
+	wh, err := newWebhooks(prometheus.NewRegistry(), []string{healthy.URL, unhealthy.URL}, "secret", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh.backoff = time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		wh.run(ctx)
+		close(done)
+	}()
+
+	wh.Notify(newEvent(EventUpload, databasetest.NewEntry("a")))
+	select {
+	case m := <-events:
+		if m["slug"] != "a" {
+			t.Fatalf("unexpected event: %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event wasn't delivered")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(wh.failures) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("failure wasn't counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := dead.Load(); got != webhookAttempts {
+		t.Fatalf("unexpected attempts; got %d, want %d", got, webhookAttempts)
+	}
+	if got := testutil.ToFloat64(wh.delivered); got != 1 {
+		t.Fatalf("unexpected deliveries; got %v, want 1", got)
+	}
+
+	cancel()
+	<-done
+	// Nothing delivers events once it's stopped, so the queues fill.
+	for range webhookQueue + 1 {
+		wh.Notify(newEvent(EventUpload, databasetest.NewEntry("b")))
+	}
+	if got := testutil.ToFloat64(wh.dropped); got != 2 {
+		t.Fatalf("unexpected dropped events; got %v, want 2", got)
+	}
+}